@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/collector"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/logarchive"
+)
+
+// runArchive implements the archive subcommand
+func runArchive(args []string) int {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "usage: signalbeam-collector archive verify [-config path] [-dir path]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("archive verify", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "Path to configuration file")
+	dir := fs.String("dir", "", "Log archive directory, default from the configuration")
+	fs.Parse(args[1:])
+
+	if *dir == "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+			return 1
+		}
+		*dir = collector.LogArchiveDir(cfg)
+	}
+
+	entries, problems, err := logarchive.Verify(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *dir, err)
+		return 1
+	}
+
+	for _, e := range entries {
+		at := time.Unix(e.Time, 0).UTC().Format(time.RFC3339)
+		switch e.Action {
+		case logarchive.Sealed:
+			fmt.Printf("%6d %s seal   %s %d lines %d bytes\n", e.Seq, at, e.File, e.Lines, e.Bytes)
+		default:
+			fmt.Printf("%6d %s %-6s %s %s\n", e.Seq, at, e.Action, e.File, e.Reason)
+		}
+	}
+	for _, p := range problems {
+		fmt.Printf("PROBLEM %s: %s\n", p.File, p.Reason)
+	}
+
+	head := ""
+	if n := len(entries); n > 0 {
+		head = entries[n-1].Hash
+	}
+	fmt.Fprintf(os.Stderr, "%d manifest entries, chain intact, head %s, %d problems\n", len(entries), head, len(problems))
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
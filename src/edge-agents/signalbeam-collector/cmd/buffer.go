@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/collector"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/output"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/queue"
+)
+
+// bufferRecord is a record of the buffer as printed by buffer query and
+// written by buffer replay
+type bufferRecord struct {
+	ID       int64           `json:"id,omitempty"`
+	Time     string          `json:"time"`
+	Type     string          `json:"type"`
+	DeviceID string          `json:"device_id"`
+	Topic    string          `json:"topic"`
+	QoS      byte            `json:"qos"`
+	Sent     string          `json:"sent,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	// Payloads that are not JSON, e.g. compressed or encrypted, as base64
+	PayloadBase64 []byte `json:"payload_base64,omitempty"`
+}
+
+// runBuffer implements the buffer subcommand
+func runBuffer(args []string) int {
+	switch {
+	case len(args) > 0 && args[0] == "query":
+		return runBufferQuery(args[1:])
+	case len(args) > 0 && args[0] == "replay":
+		return runBufferReplay(args[1:])
+	}
+	fmt.Fprintln(os.Stderr, "usage: signalbeam-collector buffer query [-config path] [-db path] [-type type] [-since time] [-until time] [-pending] [-limit n]")
+	fmt.Fprintln(os.Stderr, "       signalbeam-collector buffer replay [-config path] [-to output | -out dir]")
+	return 2
+}
+
+// runBufferQuery prints records of the SQLite buffer
+func runBufferQuery(args []string) int {
+	fs := flag.NewFlagSet("buffer query", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "Path to configuration file")
+	db := fs.String("db", "", "SQLite buffer database, default from the configuration")
+	dataType := fs.String("type", "", "Only records of this data type")
+	since := fs.String("since", "", "Only records from this time on: RFC 3339, or a duration ago such as 2h")
+	until := fs.String("until", "", "Only records before this time: RFC 3339, or a duration ago")
+	pending := fs.Bool("pending", false, "Only records not yet sent")
+	limit := fs.Int("limit", 1000, "Most records printed, oldest first; 0 for all")
+	fs.Parse(args)
+
+	filter := queue.Filter{Type: *dataType, Pending: *pending, Limit: *limit}
+	var err error
+	if filter.Since, err = parseSince(*since); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -since: %v\n", err)
+		return 2
+	}
+	if filter.Until, err = parseSince(*until); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -until: %v\n", err)
+		return 2
+	}
+
+	if *db == "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+			return 1
+		}
+		*db = collector.BufferPath(cfg)
+	}
+
+	records, err := queue.Query(*db, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to query buffer: %v\n", err)
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	sent := 0
+	for _, r := range records {
+		out := bufferRecord{
+			ID:       r.ID,
+			Time:     r.Time.UTC().Format(time.RFC3339Nano),
+			Type:     r.Type,
+			DeviceID: r.DeviceID,
+			Topic:    r.Topic,
+			QoS:      r.QoS,
+		}
+		if !r.Sent.IsZero() {
+			out.Sent = r.Sent.UTC().Format(time.RFC3339Nano)
+			sent++
+		}
+		if json.Valid(r.Payload) {
+			out.Payload = r.Payload
+		} else {
+			out.PayloadBase64 = r.Payload
+		}
+		if err := enc.Encode(out); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write record: %v\n", err)
+			return 1
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d records: %d sent, %d pending\n", len(records), sent, len(records)-sent)
+	return 0
+}
+
+// runBufferReplay empties the disk or SQLite buffer of a stopped collector:
+// to the broker, to an output, or into NDJSON files per data type
+func runBufferReplay(args []string) int {
+	fs := flag.NewFlagSet("buffer replay", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "Path to configuration file")
+	to := fs.String("to", "", "Output to send the records to, default the broker")
+	out := fs.String("out", "", "Directory to write the records to as NDJSON files instead")
+	fs.Parse(args)
+	if *to != "" && *out != "" {
+		fmt.Fprintln(os.Stderr, "-to and -out exclude each other")
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var sent int64
+	if *out != "" {
+		sent, err = dumpBuffer(ctx, cfg, *out)
+	} else {
+		sent, err = collector.ReplayBuffer(ctx, cfg, *to)
+	}
+	fmt.Fprintf(os.Stderr, "%d records replayed\n", sent)
+	if errors.Is(err, queue.ErrLocked) {
+		fmt.Fprintln(os.Stderr, "The buffer is in use; stop the collector first")
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Replay stopped: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// dumpBuffer appends the buffered records to {type}.ndjson files in dir, in
+// the format of buffer query, and removes them from the buffer
+func dumpBuffer(ctx context.Context, cfg *config.Config, dir string) (int64, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return 0, err
+	}
+	q, err := collector.OpenBuffer(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer q.Close()
+
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	sent, err := collector.ReplayQueue(ctx, q, cfg.Replay.Downsample, func(msg output.Message) error {
+		f, ok := files[msg.Type]
+		if !ok {
+			name := msg.Type
+			if name == "" {
+				name = "unknown"
+			}
+			var err error
+			if f, err = os.OpenFile(filepath.Join(dir, name+".ndjson"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+				return err
+			}
+			files[msg.Type] = f
+		}
+		out := bufferRecord{
+			Time:     msg.Time.UTC().Format(time.RFC3339Nano),
+			Type:     msg.Type,
+			DeviceID: msg.DeviceID,
+			Topic:    msg.Topic,
+			QoS:      msg.QoS,
+		}
+		if json.Valid(msg.Payload) {
+			out.Payload = msg.Payload
+		} else {
+			out.PayloadBase64 = msg.Payload
+		}
+		return json.NewEncoder(f).Encode(out)
+	})
+	for _, f := range files {
+		if syncErr := f.Sync(); err == nil {
+			err = syncErr
+		}
+	}
+	return sent, err
+}
+
+// parseSince reads an RFC 3339 time or a duration before now; "" is the
+// zero time
+func parseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/install"
+)
+
+// runInstall implements the install subcommand. Without -apply it prints
+// the generated unit so it can be reviewed first
+func runInstall(args []string) int {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	configPath := fs.String("config", "/etc/signalbeam/config.yaml", "Path to configuration file")
+	binary := fs.String("binary", "/usr/local/bin/signalbeam-collector", "Installed collector binary")
+	serviceUser := fs.String("user", "signalbeam", "Dedicated service user")
+	unitPath := fs.String("unit", "/etc/systemd/system/signalbeam-collector.service", "systemd unit path")
+	workDir := fs.String("workdir", "/var/lib/signalbeam", "Service working directory")
+	apply := fs.Bool("apply", false, "Create the user and directories, write the unit and reload systemd")
+	fs.Parse(args)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	absConfig, err := filepath.Abs(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to resolve config path: %v\n", err)
+		return 1
+	}
+
+	plan := install.Build(cfg, install.Options{
+		User:       *serviceUser,
+		Binary:     *binary,
+		ConfigPath: absConfig,
+		UnitPath:   *unitPath,
+		WorkDir:    *workDir,
+	})
+
+	for _, note := range plan.Notes {
+		fmt.Fprintf(os.Stderr, "note: %s\n", note)
+	}
+
+	if !*apply {
+		fmt.Print(plan.Unit())
+		return 0
+	}
+
+	if err := plan.Apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "Install failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Installed %s. Enable with: systemctl enable --now signalbeam-collector\n", *unitPath)
+	return 0
+}
@@ -5,15 +5,31 @@ import (
 	"flag"
 	"os"
 	"os/signal"
+	"runtime"
+	"runtime/debug"
 	"syscall"
-	"time"
 
 	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/collector"
 	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/fips"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "install":
+			os.Exit(runInstall(os.Args[2:]))
+		case "pipeline":
+			os.Exit(runPipeline(os.Args[2:]))
+		case "archive":
+			os.Exit(runArchive(os.Args[2:]))
+		case "buffer":
+			os.Exit(runBuffer(os.Args[2:]))
+		}
+	}
+
 	var configPath = flag.String("config", "config.yaml", "Path to configuration file")
 	flag.Parse()
 
@@ -23,25 +39,27 @@ func main() {
 		logrus.WithError(err).Fatal("Failed to load configuration")
 	}
 
-	// Setup logging
-	level, err := logrus.ParseLevel(cfg.Logging.Level)
-	if err != nil {
-		logrus.WithError(err).Warn("Invalid log level, defaulting to info")
-		level = logrus.InfoLevel
-	}
-	logrus.SetLevel(level)
-	
-	if cfg.Logging.Format == "json" {
-		logrus.SetFormatter(&logrus.JSONFormatter{})
+	// Setup logging and runtime tuning
+	logger := setup(cfg)
+
+	logger.Info("Starting SignalBeam Edge Collector")
+
+	if err := fips.Check(); err != nil {
+		logger.WithError(err).Fatal("FIPS self-check failed")
 	}
 
-	logger := logrus.WithFields(logrus.Fields{
-		"component": "signalbeam-collector",
-		"version":   "0.1.0",
-		"device_id": cfg.Device.ID,
-	})
+	// Verify writable state paths before doing anything else
+	paths := state.Resolve(cfg.State)
+	if err := paths.Prepare(); err != nil {
+		logger.WithError(err).Fatal("State directory check failed")
+	}
+	defer reportCrash(paths, logger)
 
-	logger.Info("Starting SignalBeam Edge Collector")
+	// Layer the configuration retained on the broker over the file
+	if cfg.Bootstrap.Enabled {
+		cfg = bootstrapConfig(*configPath, cfg, paths, logger)
+		logger = setup(cfg)
+	}
 
 	// Create collector instance
 	c, err := collector.New(cfg, logger)
@@ -55,25 +73,38 @@ func main() {
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
 
 	// Start collector
 	go func() {
+		defer reportCrash(paths, logger)
 		if err := c.Start(ctx); err != nil {
 			logger.WithError(err).Error("Collector failed")
 			cancel()
 		}
 	}()
 
-	// Wait for shutdown signal
-	select {
-	case sig := <-sigCh:
-		logger.WithField("signal", sig).Info("Received shutdown signal")
-	case <-ctx.Done():
-		logger.Info("Context cancelled")
+	// Wait for shutdown signal, reloading outputs on SIGHUP
+wait:
+	for {
+		select {
+		case <-hupCh:
+			reloadOutputs(*configPath, paths, c, logger)
+		case sig := <-sigCh:
+			logger.WithField("signal", sig).Info("Received shutdown signal")
+			break wait
+		case <-c.RestartRequested():
+			logger.Info("Restarting to apply an update")
+			break wait
+		case <-ctx.Done():
+			logger.Info("Context cancelled")
+			break wait
+		}
 	}
 
 	// Graceful shutdown with timeout
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
 	defer shutdownCancel()
 
 	logger.Info("Shutting down collector...")
@@ -82,4 +113,124 @@ func main() {
 	}
 
 	logger.Info("SignalBeam Edge Collector stopped")
-}
\ No newline at end of file
+}
+
+// setup configures logging and the runtime and returns the agent logger
+func setup(cfg *config.Config) *logrus.Entry {
+	level, err := logrus.ParseLevel(cfg.Logging.Level)
+	if err != nil {
+		logrus.WithError(err).Warn("Invalid log level, defaulting to info")
+		level = logrus.InfoLevel
+	}
+	logrus.SetLevel(level)
+
+	if cfg.Logging.Format == "json" {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	}
+
+	applyRuntime(cfg.Runtime)
+
+	return logrus.WithFields(logrus.Fields{
+		"component": "signalbeam-collector",
+		"version":   collector.Version,
+		"device_id": cfg.Device.ID,
+		"profile":   cfg.Profile,
+		"fips":      fips.Enabled,
+	})
+}
+
+// bootstrapConfig fetches the retained configuration and layers it over the
+// file. The last good document is kept in the state directory so the device
+// starts with it when the broker is unreachable or nothing is retained
+func bootstrapConfig(path string, cfg *config.Config, paths state.Paths, logger *logrus.Entry) *config.Config {
+	doc, err := collector.FetchBootstrap(cfg, logger)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to fetch bootstrapped configuration")
+	}
+
+	if doc != nil {
+		merged, err := config.LoadWithOverlay(path, doc)
+		if err == nil {
+			if err := writeFileAtomic(paths.BootstrapFile, doc); err != nil {
+				logger.WithError(err).Warn("Failed to store bootstrapped configuration")
+			}
+			logger.Info("Applied bootstrapped configuration")
+			return merged
+		}
+		logger.WithError(err).Error("Ignoring invalid bootstrapped configuration")
+	}
+
+	stored, err := os.ReadFile(paths.BootstrapFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.WithError(err).Warn("Failed to read stored bootstrapped configuration")
+		} else {
+			logger.Warn("No bootstrapped configuration available, using local configuration")
+		}
+		return cfg
+	}
+	merged, err := config.LoadWithOverlay(path, stored)
+	if err != nil {
+		logger.WithError(err).Error("Ignoring invalid stored bootstrapped configuration")
+		return cfg
+	}
+	logger.Info("Using stored bootstrapped configuration")
+	return merged
+}
+
+// reloadOutputs rereads the configuration and applies its outputs. Other
+// settings take effect on restart
+func reloadOutputs(path string, paths state.Paths, c *collector.Collector, logger *logrus.Entry) {
+	cfg, err := config.Load(path)
+	if err == nil && cfg.Bootstrap.Enabled {
+		if stored, rErr := os.ReadFile(paths.BootstrapFile); rErr == nil {
+			cfg, err = config.LoadWithOverlay(path, stored)
+		}
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to reload configuration, keeping current outputs")
+		return
+	}
+	if err := c.ReloadOutputs(cfg.Outputs); err != nil {
+		logger.WithError(err).Error("Failed to reload outputs")
+	}
+}
+
+// writeFileAtomic replaces a file so readers never see a partial write
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// applyRuntime tunes the Go runtime for constrained devices
+func applyRuntime(cfg config.RuntimeConfig) {
+	if cfg.GCPercent != 0 {
+		debug.SetGCPercent(cfg.GCPercent)
+	}
+	if cfg.MemoryLimit > 0 {
+		debug.SetMemoryLimit(cfg.MemoryLimit)
+	}
+	if cfg.MaxProcs > 0 {
+		runtime.GOMAXPROCS(cfg.MaxProcs)
+	}
+}
+
+// reportCrash writes a crash report for a panic and re-raises it
+func reportCrash(paths state.Paths, logger *logrus.Entry) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	if path, err := paths.WriteCrashReport(r); err != nil {
+		logger.WithError(err).Error("Failed to write crash report")
+	} else {
+		logger.WithField("path", path).Error("Collector crashed, report written")
+	}
+	panic(r)
+}
@@ -0,0 +1,134 @@
+package accounting
+
+import (
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// allocsMetric is the cumulative number of bytes allocated on the heap
+const allocsMetric = "/gc/heap/allocs:bytes"
+
+// Usage is the resource consumption of one pipeline component
+type Usage struct {
+	CPU        time.Duration // Thread CPU time, wall time where unavailable
+	AllocBytes uint64        // Heap bytes allocated while the component ran
+	Records    int64         // Records produced
+	Calls      int64         // Measured invocations
+}
+
+// Map returns the usage for the heartbeat payload
+func (u Usage) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"cpu_ms":      float64(u.CPU.Microseconds()) / 1000,
+		"alloc_bytes": u.AllocBytes,
+		"records":     u.Records,
+		"calls":       u.Calls,
+	}
+}
+
+// Ledger accumulates resource usage per component. A nil ledger records
+// nothing, so callers need not check whether accounting is enabled
+type Ledger struct {
+	mu    sync.Mutex
+	usage map[string]*Usage
+}
+
+// New creates an empty ledger
+func New() *Ledger {
+	return &Ledger{usage: make(map[string]*Usage)}
+}
+
+// Span measures one synchronous invocation of a component
+type Span struct {
+	ledger *Ledger
+	name   string
+	cpu    time.Duration
+	sample []metrics.Sample
+	ended  bool
+}
+
+// Start begins measuring work done by a component on the calling
+// goroutine. The goroutine is pinned to its thread until End so the thread's
+// CPU time is its own. Allocations are read process-wide and include those
+// of concurrently running goroutines
+func (l *Ledger) Start(name string) *Span {
+	if l == nil {
+		return nil
+	}
+	runtime.LockOSThread()
+	s := &Span{
+		ledger: l,
+		name:   name,
+		sample: []metrics.Sample{{Name: allocsMetric}},
+	}
+	metrics.Read(s.sample)
+	s.cpu = threadCPU()
+	return s
+}
+
+// End stops the measurement and credits the component with records. Only
+// the first call has an effect, so End may also be deferred as a safeguard
+func (s *Span) End(records int) {
+	if s == nil || s.ended {
+		return
+	}
+	s.ended = true
+	cpu := threadCPU() - s.cpu
+	before := allocated(s.sample)
+	metrics.Read(s.sample)
+	alloc := allocated(s.sample) - before
+	runtime.UnlockOSThread()
+
+	s.ledger.mu.Lock()
+	defer s.ledger.mu.Unlock()
+	u := s.ledger.entry(s.name)
+	u.CPU += cpu
+	u.AllocBytes += alloc
+	u.Records += int64(records)
+	u.Calls++
+}
+
+// Count credits a component with records produced outside a span
+func (l *Ledger) Count(name string, records int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entry(name).Records += int64(records)
+}
+
+// Snapshot returns the usage of every component
+func (l *Ledger) Snapshot() map[string]Usage {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	snap := make(map[string]Usage, len(l.usage))
+	for name, u := range l.usage {
+		snap[name] = *u
+	}
+	return snap
+}
+
+// entry returns the usage record of a component, creating it. Callers hold mu
+func (l *Ledger) entry(name string) *Usage {
+	u, ok := l.usage[name]
+	if !ok {
+		u = &Usage{}
+		l.usage[name] = u
+	}
+	return u
+}
+
+// allocated returns the cumulative allocation count from a sample
+func allocated(sample []metrics.Sample) uint64 {
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package accounting
+
+import "time"
+
+// epoch anchors the wall clock fallback
+var epoch = time.Now()
+
+// threadCPU falls back to wall time where per-thread CPU time is unavailable
+func threadCPU() time.Duration {
+	return time.Since(epoch)
+}
@@ -0,0 +1,200 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is one audit record. Hash covers every other field including Prev,
+// so editing or removing a line breaks the chain from that point on
+type Entry struct {
+	Seq       int64                  `json:"seq"`
+	Timestamp int64                  `json:"timestamp"`
+	Actor     string                 `json:"actor"`
+	Action    string                 `json:"action"`
+	Target    string                 `json:"target,omitempty"`
+	Result    string                 `json:"result"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	Prev      string                 `json:"prev"`
+	Hash      string                 `json:"hash"`
+}
+
+// Log is an append-only, hash-chained audit log stored as NDJSON
+type Log struct {
+	mu   sync.Mutex
+	path string
+	seq  int64
+	head string
+}
+
+// Open opens the log at path, verifying the existing chain
+func Open(path string) (*Log, error) {
+	l := &Log{path: path}
+
+	entries, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	if err := Verify(entries); err != nil {
+		return nil, fmt.Errorf("audit log %s: %w", path, err)
+	}
+	if n := len(entries); n > 0 {
+		l.seq = entries[n-1].Seq
+		l.head = entries[n-1].Hash
+	}
+
+	return l, nil
+}
+
+// Append records an operation and returns the stored entry
+func (l *Log) Append(actor, action, target, result string, details map[string]interface{}) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := Entry{
+		Seq:       l.seq + 1,
+		Timestamp: time.Now().UTC().Unix(),
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		Result:    result,
+		Details:   details,
+		Prev:      l.head,
+	}
+	hash, err := e.digest()
+	if err != nil {
+		return Entry{}, err
+	}
+	e.Hash = hash
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return Entry{}, fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return Entry{}, fmt.Errorf("failed to sync audit log: %w", err)
+	}
+
+	l.seq = e.Seq
+	l.head = e.Hash
+	return e, nil
+}
+
+// Since returns up to max entries with a sequence number greater than seq
+func (l *Log) Since(seq int64, max int) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Entry
+	for _, e := range entries {
+		if e.Seq > seq {
+			out = append(out, e)
+		}
+	}
+	if max > 0 && len(out) > max {
+		out = out[len(out)-max:]
+	}
+	return out, nil
+}
+
+// Last returns the most recent entry for action
+func (l *Log) Last(action string) (Entry, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries, err := l.read()
+	if err != nil {
+		return Entry{}, false, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Action == action {
+			return entries[i], true, nil
+		}
+	}
+	return Entry{}, false, nil
+}
+
+// Head returns the sequence number and hash of the latest entry
+func (l *Log) Head() (int64, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq, l.head
+}
+
+// Verify checks that entries form an unbroken hash chain
+func Verify(entries []Entry) error {
+	prev := ""
+	for _, e := range entries {
+		if e.Prev != prev {
+			return fmt.Errorf("chain broken at seq %d", e.Seq)
+		}
+		hash, err := e.digest()
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash {
+			return fmt.Errorf("hash mismatch at seq %d", e.Seq)
+		}
+		prev = e.Hash
+	}
+	return nil
+}
+
+// read loads all entries from disk
+func (l *Log) read() ([]Entry, error) {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("corrupt audit entry after seq %d: %w", len(entries), err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// digest hashes the entry with its Hash field cleared
+func (e Entry) digest() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,96 @@
+// Package azure reads the shared access credentials of Azure Event Hubs and
+// Service Bus, and follows the MQTT conventions of Azure IoT Hub
+package azure
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConnectionString is a parsed shared access policy connection string:
+// Endpoint=sb://ns.servicebus.windows.net/;SharedAccessKeyName=...;SharedAccessKey=...;EntityPath=...
+type ConnectionString struct {
+	Host       string // Namespace host, with a port for the emulator
+	KeyName    string
+	Key        string
+	Signature  string // SharedAccessSignature given instead of a key
+	EntityPath string
+	Emulator   bool // UseDevelopmentEmulator: plain AMQP without TLS
+}
+
+// ParseConnectionString parses s. It needs an endpoint and either a key name
+// and key or a shared access signature
+func ParseConnectionString(s string) (ConnectionString, error) {
+	var cs ConnectionString
+	var endpoint string
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "endpoint":
+			endpoint = value
+		case "sharedaccesskeyname":
+			cs.KeyName = value
+		case "sharedaccesskey":
+			cs.Key = value
+		case "sharedaccesssignature":
+			cs.Signature = value
+		case "entitypath":
+			cs.EntityPath = value
+		case "usedevelopmentemulator":
+			cs.Emulator = strings.EqualFold(value, "true")
+		}
+	}
+
+	u, err := url.Parse(endpoint)
+	switch {
+	case err != nil || u.Scheme != "sb" || u.Host == "":
+		return ConnectionString{}, fmt.Errorf("connection string needs an Endpoint=sb://namespace/")
+	case cs.Signature == "" && (cs.KeyName == "" || cs.Key == ""):
+		return ConnectionString{}, fmt.Errorf("connection string needs SharedAccessKeyName and SharedAccessKey, or SharedAccessSignature")
+	}
+	cs.Host = u.Host
+	return cs, nil
+}
+
+// SASToken is a parsed shared access signature:
+// SharedAccessSignature sr=...&sig=...&se=...&skn=...
+type SASToken struct {
+	Raw      string
+	Resource string    // Audience the token grants access to
+	Expiry   time.Time // Zero when the token has no expiry
+}
+
+// ParseSASToken parses a shared access signature token
+func ParseSASToken(s string) (SASToken, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(s), "SharedAccessSignature ")
+	if !ok {
+		return SASToken{}, fmt.Errorf("SAS token must start with \"SharedAccessSignature \"")
+	}
+	values, err := url.ParseQuery(rest)
+	if err != nil {
+		return SASToken{}, fmt.Errorf("invalid SAS token: %w", err)
+	}
+	t := SASToken{Raw: strings.TrimSpace(s), Resource: values.Get("sr")}
+	if t.Resource == "" || values.Get("sig") == "" {
+		return SASToken{}, fmt.Errorf("SAS token needs sr and sig")
+	}
+	if se := values.Get("se"); se != "" {
+		unix, err := strconv.ParseInt(se, 10, 64)
+		if err != nil {
+			return SASToken{}, fmt.Errorf("invalid SAS token expiry %q", se)
+		}
+		t.Expiry = time.Unix(unix, 0)
+	}
+	return t, nil
+}
+
+// Expired reports whether the token is no longer valid at now
+func (t SASToken) Expired(now time.Time) bool {
+	return !t.Expiry.IsZero() && !now.Before(t.Expiry)
+}
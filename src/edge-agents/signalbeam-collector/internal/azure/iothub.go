@@ -0,0 +1,100 @@
+package azure
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/oauth"
+)
+
+// IoTHubAPIVersion is the IoT Hub API version sent in the MQTT username
+const IoTHubAPIVersion = "2021-04-12"
+
+// Device twin topics of IoT Hub
+const (
+	TwinResponses = "$iothub/twin/res/#"
+	twinReported  = "$iothub/twin/PATCH/properties/reported/?$rid="
+	twinResponse  = "$iothub/twin/res/"
+)
+
+// IoTHubUsername returns the MQTT username of a device on a hub
+func IoTHubUsername(hub, deviceID, apiVersion string) string {
+	return hub + "/" + deviceID + "/?api-version=" + apiVersion
+}
+
+// EventsTopic returns the topic IoT Hub accepts device-to-cloud messages
+// on; a property bag may follow
+func EventsTopic(deviceID string) string {
+	return "devices/" + deviceID + "/messages/events/"
+}
+
+// DeviceSAS issues shared access signatures for a device identity, signed
+// with its symmetric key
+type DeviceSAS struct {
+	Hub      string
+	DeviceID string
+	// Key returns the base64 device key for each token, so a rotated key
+	// is picked up
+	Key      func() (string, error)
+	Lifetime time.Duration
+}
+
+// Token returns a signature valid for the lifetime from now
+func (d *DeviceSAS) Token(context.Context) (oauth.Token, error) {
+	key, err := d.Key()
+	if err != nil {
+		return oauth.Token{}, fmt.Errorf("failed to read shared access key: %w", err)
+	}
+	expiry := time.Now().Add(d.Lifetime).Truncate(time.Second)
+	token, err := Sign(d.Hub+"/devices/"+d.DeviceID, key, expiry)
+	if err != nil {
+		return oauth.Token{}, err
+	}
+	return oauth.Token{AccessToken: token, Expiry: expiry}, nil
+}
+
+// Sign returns a SAS token granting access to resource until expiry,
+// signed with a base64 key
+func Sign(resource, key string, expiry time.Time) (string, error) {
+	secret, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("shared access key is not base64: %w", err)
+	}
+	sr := url.QueryEscape(resource)
+	se := strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(sr + "\n" + se))
+	sig := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return "SharedAccessSignature sr=" + sr + "&sig=" + sig + "&se=" + se, nil
+}
+
+// TwinReportedTopic returns the topic updating reported properties; the
+// response carries the request ID
+func TwinReportedTopic(requestID string) string {
+	return twinReported + requestID
+}
+
+// ParseTwinResponse returns the status and request ID of a twin response
+func ParseTwinResponse(topic string) (status int, requestID string, ok bool) {
+	rest, ok := strings.CutPrefix(topic, twinResponse)
+	if !ok {
+		return 0, "", false
+	}
+	code, query, _ := strings.Cut(rest, "/?")
+	status, err := strconv.Atoi(code)
+	if err != nil {
+		return 0, "", false
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return 0, "", false
+	}
+	return status, values.Get("$rid"), true
+}
@@ -0,0 +1,30 @@
+package backoff
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Policy is an exponential backoff with jitter. Randomising the delay keeps
+// a fleet that lost its broker at the same moment from reconnecting in
+// lockstep when it comes back
+type Policy struct {
+	Initial    time.Duration // Delay before the first retry
+	Max        time.Duration // Upper bound of the delay
+	Multiplier float64       // Growth per retry
+	Jitter     float64       // Fraction of the delay randomised: 0 none, 1 full jitter
+}
+
+// Delay returns the wait before retry n, counted from 0. With jitter j the
+// delay is drawn uniformly from [d*(1-j), d]
+func (p Policy) Delay(n int) time.Duration {
+	d := float64(p.Initial) * math.Pow(p.Multiplier, float64(n))
+	if d > float64(p.Max) || math.IsInf(d, 0) || math.IsNaN(d) {
+		d = float64(p.Max)
+	}
+	if p.Jitter > 0 {
+		d -= d * p.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
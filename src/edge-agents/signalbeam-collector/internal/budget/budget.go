@@ -0,0 +1,231 @@
+// Package budget limits the publish rate and the bytes a device sends per
+// day and per month. Streams have priorities, so low-priority data gives
+// way first as a limit is approached, and the byte totals survive restarts
+package budget
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Priority orders streams by what is given up first
+type Priority int
+
+const (
+	Low Priority = iota
+	Normal
+	Critical
+)
+
+// Level is how far the byte budgets are used
+type Level int
+
+const (
+	Full      Level = iota // Every stream passes
+	Degraded               // Low-priority streams are dropped
+	Exhausted              // Only critical streams pass, none with a hard cap
+)
+
+// String returns the name reported in events and the heartbeat
+func (l Level) String() string {
+	switch l {
+	case Degraded:
+		return "degraded"
+	case Exhausted:
+		return "exhausted"
+	}
+	return "full"
+}
+
+// Reasons a publish is refused
+const (
+	ReasonRate    = "rate"
+	ReasonDaily   = "daily"
+	ReasonMonthly = "monthly"
+)
+
+// Limits configures a budget. Zero values are unlimited
+type Limits struct {
+	Rate      float64 // Publishes per second
+	Burst     int     // Publishes allowed at once above the rate
+	Daily     int64   // Bytes per day
+	Monthly   int64   // Bytes per month
+	ResetDay  int     // Day of the month the monthly budget starts, 1-28
+	DegradeAt float64 // Share of a byte budget from which low-priority streams are dropped
+	HardCap   bool    // Critical streams stop too once a byte budget is used
+}
+
+// state is what the budget keeps on disk
+type state struct {
+	Day        time.Time `json:"day"`
+	DayBytes   int64     `json:"day_bytes"`
+	Month      time.Time `json:"month"`
+	MonthBytes int64     `json:"month_bytes"`
+}
+
+// Snapshot is the use of the budgets
+type Snapshot struct {
+	Level      Level
+	Reason     string // Budget setting the level, empty when full
+	DayBytes   int64
+	MonthBytes int64
+	Tokens     float64 // Publishes available at once
+}
+
+// Budget accounts publishes against the limits
+type Budget struct {
+	path   string
+	limits Limits
+
+	mu     sync.Mutex
+	state  state
+	tokens float64
+	filled time.Time
+	dirty  bool
+}
+
+// Open loads the byte totals kept at path
+func Open(path string, limits Limits) (*Budget, error) {
+	b := &Budget{path: path, limits: limits, tokens: float64(limits.Burst)}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &b.state); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Allow reports whether a publish of size bytes with priority p fits the
+// budgets, and accounts it when it does. The reason names the limit that
+// refused it
+func (b *Budget) Allow(p Priority, size int, now time.Time) (bool, string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(now)
+
+	level, reason := b.level()
+	switch {
+	case level == Exhausted && (p != Critical || b.limits.HardCap):
+		return false, reason
+	case level == Degraded && p == Low:
+		return false, reason
+	}
+
+	if b.limits.Rate > 0 {
+		b.refill(now)
+		// Low-priority streams leave half the burst to the others
+		need := 1.0
+		if p == Low {
+			need += float64(b.limits.Burst) / 2
+		}
+		if p != Critical && b.tokens < need {
+			return false, ReasonRate
+		}
+		b.tokens = max(b.tokens-1, 0)
+	}
+
+	b.state.DayBytes += int64(size)
+	b.state.MonthBytes += int64(size)
+	b.dirty = true
+	return true, ""
+}
+
+// refill adds the tokens earned since the last publish. The caller must
+// hold b.mu
+func (b *Budget) refill(now time.Time) {
+	if !b.filled.IsZero() {
+		b.tokens += now.Sub(b.filled).Seconds() * b.limits.Rate
+		b.tokens = min(b.tokens, float64(b.limits.Burst))
+	}
+	b.filled = now
+}
+
+// level returns how far the byte budgets are used. The caller must hold
+// b.mu
+func (b *Budget) level() (Level, string) {
+	level, reason := Full, ""
+	budgets := []struct {
+		limit, used int64
+		reason      string
+	}{{b.limits.Daily, b.state.DayBytes, ReasonDaily}, {b.limits.Monthly, b.state.MonthBytes, ReasonMonthly}}
+	for _, u := range budgets {
+		if u.limit <= 0 {
+			continue
+		}
+		switch share := float64(u.used) / float64(u.limit); {
+		case share >= 1:
+			return Exhausted, u.reason
+		case share >= b.limits.DegradeAt && level == Full:
+			level, reason = Degraded, u.reason
+		}
+	}
+	return level, reason
+}
+
+// roll starts a new day or month. The caller must hold b.mu
+func (b *Budget) roll(now time.Time) {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !b.state.Day.Equal(day) {
+		b.state.Day, b.state.DayBytes = day, 0
+		b.dirty = true
+	}
+	resetDay := max(b.limits.ResetDay, 1)
+	month := time.Date(now.Year(), now.Month(), resetDay, 0, 0, 0, 0, now.Location())
+	if month.After(now) {
+		month = month.AddDate(0, -1, 0)
+	}
+	if !b.state.Month.Equal(month) {
+		b.state.Month, b.state.MonthBytes = month, 0
+		b.dirty = true
+	}
+}
+
+// Snapshot returns the use of the budgets
+func (b *Budget) Snapshot(now time.Time) Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(now)
+	if b.limits.Rate > 0 {
+		b.refill(now)
+	}
+	level, reason := b.level()
+	return Snapshot{
+		Level:      level,
+		Reason:     reason,
+		DayBytes:   b.state.DayBytes,
+		MonthBytes: b.state.MonthBytes,
+		Tokens:     b.tokens,
+	}
+}
+
+// Save writes the byte totals when they changed since the last save
+func (b *Budget) Save() error {
+	b.mu.Lock()
+	if !b.dirty {
+		b.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(b.state)
+	b.dirty = false
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(b.path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(b.path+".tmp", b.path)
+}
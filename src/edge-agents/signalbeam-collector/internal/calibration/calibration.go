@@ -0,0 +1,106 @@
+package calibration
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Point is one entry of a lookup table mapping a raw reading to a calibrated value
+type Point struct {
+	Raw   float64
+	Value float64
+}
+
+// Channel calibrates the readings of one input channel. Exactly one method
+// applies: a lookup table, a polynomial, or gain and offset (value = raw*gain + offset)
+type Channel struct {
+	Gain       float64
+	Offset     float64
+	Polynomial []float64 // Coefficients c0 + c1*x + c2*x^2 + ...
+	Table      []Point   // Linear interpolation between points, clamped at the ends
+
+	// Metadata recorded alongside calibrated values
+	ID   string
+	Date string
+}
+
+// Validate checks that the channel describes a single usable method
+func (c Channel) Validate() error {
+	if len(c.Table) > 0 && len(c.Polynomial) > 0 {
+		return fmt.Errorf("polynomial and table are mutually exclusive")
+	}
+	if len(c.Table) > 0 || len(c.Polynomial) > 0 {
+		if (c.Gain != 0 && c.Gain != 1) || c.Offset != 0 {
+			return fmt.Errorf("gain and offset cannot be combined with polynomial or table")
+		}
+	}
+	if len(c.Table) == 1 {
+		return fmt.Errorf("table needs at least two points")
+	}
+	for i := 1; i < len(c.Table); i++ {
+		if c.Table[i].Raw <= c.Table[i-1].Raw {
+			return fmt.Errorf("table raw values must be strictly increasing")
+		}
+	}
+	return nil
+}
+
+// Method names the calibration method in use
+func (c Channel) Method() string {
+	switch {
+	case len(c.Table) > 0:
+		return "table"
+	case len(c.Polynomial) > 0:
+		return "polynomial"
+	default:
+		return "linear"
+	}
+}
+
+// Apply converts a raw reading to a calibrated value
+func (c Channel) Apply(raw float64) float64 {
+	switch {
+	case len(c.Table) > 0:
+		return c.lookup(raw)
+	case len(c.Polynomial) > 0:
+		// Horner's method
+		v := 0.0
+		for i := len(c.Polynomial) - 1; i >= 0; i-- {
+			v = v*raw + c.Polynomial[i]
+		}
+		return v
+	default:
+		gain := c.Gain
+		if gain == 0 {
+			gain = 1
+		}
+		return raw*gain + c.Offset
+	}
+}
+
+// Metadata describes the calibration for the telemetry payload
+func (c Channel) Metadata() map[string]interface{} {
+	m := map[string]interface{}{"method": c.Method()}
+	if c.ID != "" {
+		m["id"] = c.ID
+	}
+	if c.Date != "" {
+		m["date"] = c.Date
+	}
+	return m
+}
+
+// lookup interpolates linearly in the table
+func (c Channel) lookup(raw float64) float64 {
+	t := c.Table
+	if raw <= t[0].Raw {
+		return t[0].Value
+	}
+	if raw >= t[len(t)-1].Raw {
+		return t[len(t)-1].Value
+	}
+
+	i := sort.Search(len(t), func(i int) bool { return t[i].Raw >= raw })
+	lo, hi := t[i-1], t[i]
+	return lo.Value + (raw-lo.Raw)*(hi.Value-lo.Value)/(hi.Raw-lo.Raw)
+}
@@ -0,0 +1,164 @@
+package cardinality
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/units"
+)
+
+// Policy controls what happens to series beyond the limit
+type Policy string
+
+const (
+	// PolicyDrop removes overflow series from the payload
+	PolicyDrop Policy = "drop"
+	// PolicyAggregate folds overflow series into a single "_other" series
+	PolicyAggregate Policy = "aggregate"
+)
+
+// OtherKey is the series name overflow is aggregated into
+const OtherKey = "_other"
+
+// Guard caps the number of unique label values (series) per metric so a
+// misconfigured input cannot explode downstream cardinality. The first
+// MaxSeries label values seen for a metric are admitted; later ones overflow.
+type Guard struct {
+	mu        sync.Mutex
+	maxSeries int
+	policy    Policy
+	seen      map[string]map[string]struct{}
+	reported  map[string]map[string]struct{}
+}
+
+// New creates a guard admitting up to maxSeries label values per metric
+func New(maxSeries int, policy Policy) *Guard {
+	return &Guard{
+		maxSeries: maxSeries,
+		policy:    policy,
+		seen:      make(map[string]map[string]struct{}),
+		reported:  make(map[string]map[string]struct{}),
+	}
+}
+
+// Apply enforces the limit on a map of series keyed by label value. It
+// returns the overflow label values not reported before, so callers can
+// emit a warning once per new offender.
+func (g *Guard) Apply(metric string, series map[string]interface{}) []string {
+	if g.maxSeries <= 0 || len(series) == 0 {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	seen, ok := g.seen[metric]
+	if !ok {
+		seen = make(map[string]struct{})
+		g.seen[metric] = seen
+	}
+
+	// Admit in sorted order so the selection is stable across restarts
+	keys := make([]string, 0, len(series))
+	for k := range series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var overflow []string
+	for _, k := range keys {
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		if len(seen) < g.maxSeries {
+			seen[k] = struct{}{}
+			continue
+		}
+		overflow = append(overflow, k)
+	}
+
+	if len(overflow) == 0 {
+		return nil
+	}
+
+	other := map[string]interface{}{}
+	for _, k := range overflow {
+		if g.policy == PolicyAggregate {
+			if fields, ok := series[k].(map[string]interface{}); ok {
+				sumInto(other, fields)
+			}
+		}
+		delete(series, k)
+	}
+	if g.policy == PolicyAggregate && len(other) > 0 {
+		other["series"] = len(overflow)
+		series[OtherKey] = other
+	}
+
+	return g.newlyReported(metric, overflow)
+}
+
+// ApplyTags tracks unique tag combinations per data type and reports
+// whether the combination is admitted
+func (g *Guard) ApplyTags(dataType string, tags map[string]string) bool {
+	if g.maxSeries <= 0 || len(tags) == 0 {
+		return true
+	}
+
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	key := strings.Join(pairs, ",")
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	metric := "tags." + dataType
+	seen, ok := g.seen[metric]
+	if !ok {
+		seen = make(map[string]struct{})
+		g.seen[metric] = seen
+	}
+	if _, ok := seen[key]; ok {
+		return true
+	}
+	if len(seen) < g.maxSeries {
+		seen[key] = struct{}{}
+		return true
+	}
+	return false
+}
+
+// newlyReported filters overflow values already reported for the metric.
+// The caller must hold g.mu.
+func (g *Guard) newlyReported(metric string, overflow []string) []string {
+	reported, ok := g.reported[metric]
+	if !ok {
+		reported = make(map[string]struct{})
+		g.reported[metric] = reported
+	}
+
+	var fresh []string
+	for _, k := range overflow {
+		if _, ok := reported[k]; !ok {
+			reported[k] = struct{}{}
+			fresh = append(fresh, k)
+		}
+	}
+	return fresh
+}
+
+// sumInto adds numeric fields of src into dst
+func sumInto(dst, src map[string]interface{}) {
+	for k, v := range src {
+		f, ok := units.ToFloat(v)
+		if !ok {
+			continue
+		}
+		prev, _ := dst[k].(float64)
+		dst[k] = prev + f
+	}
+}
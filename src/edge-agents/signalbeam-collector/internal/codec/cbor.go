@@ -0,0 +1,88 @@
+package codec
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// CBOR major types (RFC 8949)
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+)
+
+// cborEncoder writes CBOR
+type cborEncoder struct {
+	buf []byte
+}
+
+// head writes a major type with its argument in the shortest form
+func (e *cborEncoder) head(major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		e.buf = append(e.buf, m|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, m|24, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, m|25), uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, m|26), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, m|27), n)
+	}
+}
+
+func (e *cborEncoder) null() {
+	e.buf = append(e.buf, 0xf6)
+}
+
+func (e *cborEncoder) boolean(v bool) {
+	if v {
+		e.buf = append(e.buf, 0xf5)
+	} else {
+		e.buf = append(e.buf, 0xf4)
+	}
+}
+
+func (e *cborEncoder) integer(v int64) {
+	if v >= 0 {
+		e.head(cborUint, uint64(v))
+	} else {
+		e.head(cborNegint, uint64(-1-v))
+	}
+}
+
+func (e *cborEncoder) float(v float64) {
+	if float32Exact(v) {
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xfa), math.Float32bits(float32(v)))
+	} else {
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xfb), math.Float64bits(v))
+	}
+}
+
+func (e *cborEncoder) str(v string) {
+	e.head(cborText, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *cborEncoder) bin(v []byte) {
+	e.head(cborBytes, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *cborEncoder) array(n int) {
+	e.head(cborArray, uint64(n))
+}
+
+func (e *cborEncoder) object(n int) {
+	e.head(cborMap, uint64(n))
+}
+
+func (e *cborEncoder) bytes() []byte {
+	return e.buf
+}
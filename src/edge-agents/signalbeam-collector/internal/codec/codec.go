@@ -0,0 +1,167 @@
+// Package codec encodes telemetry records as JSON, CBOR or MessagePack.
+// Binary records are wrapped in an envelope of the same encoding naming the
+// content type, {"ct": "application/cbor", "d": record}, so the ingestion
+// service tells them from JSON by the first byte and from each other by the
+// tag
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Encodings
+const (
+	JSON    = "json"
+	CBOR    = "cbor"
+	MsgPack = "msgpack"
+)
+
+// Codec encodes values in one encoding
+type Codec struct {
+	name        string
+	contentType string
+	encoder     func() encoder
+}
+
+// encoder appends plain values to a buffer
+type encoder interface {
+	null()
+	boolean(v bool)
+	integer(v int64)
+	float(v float64)
+	str(v string)
+	bin(v []byte)
+	array(n int)
+	object(n int)
+	bytes() []byte
+}
+
+// New returns the codec of an encoding
+func New(name string) (Codec, error) {
+	switch name {
+	case JSON:
+		return Codec{name: JSON, contentType: "application/json"}, nil
+	case CBOR:
+		return Codec{name: CBOR, contentType: "application/cbor", encoder: func() encoder { return &cborEncoder{} }}, nil
+	case MsgPack:
+		return Codec{name: MsgPack, contentType: "application/msgpack", encoder: func() encoder { return &msgpackEncoder{} }}, nil
+	}
+	return Codec{}, fmt.Errorf("unknown encoding %q", name)
+}
+
+// Name returns the encoding name
+func (c Codec) Name() string {
+	return c.name
+}
+
+// ContentType returns the MIME type of the encoding
+func (c Codec) ContentType() string {
+	return c.contentType
+}
+
+// Binary reports whether the encoding is not JSON
+func (c Codec) Binary() bool {
+	return c.encoder != nil
+}
+
+// Marshal encodes a record. The record is brought to its JSON form first,
+// so JSON struct tags apply, and binary records are wrapped in the content
+// type envelope
+func (c Codec) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || !c.Binary() {
+		return data, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var plain interface{}
+	if err := dec.Decode(&plain); err != nil {
+		return nil, err
+	}
+	return c.Encode(map[string]interface{}{"ct": c.contentType, "d": plain})
+}
+
+// Encode encodes a plain value: nil, bool, integers, floats, json.Number,
+// string, []byte, []interface{} or map[string]interface{}. Byte slices are
+// byte strings in the binary encodings and base64 in JSON
+func (c Codec) Encode(v interface{}) ([]byte, error) {
+	if !c.Binary() {
+		return json.Marshal(v)
+	}
+	enc := c.encoder()
+	if err := encode(enc, v); err != nil {
+		return nil, err
+	}
+	return enc.bytes(), nil
+}
+
+// encode writes a plain value. Map keys are sorted, so equal records encode
+// to equal bytes
+func encode(enc encoder, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		enc.null()
+	case bool:
+		enc.boolean(v)
+	case int:
+		enc.integer(int64(v))
+	case int64:
+		enc.integer(v)
+	case uint64:
+		if v > math.MaxInt64 {
+			enc.float(float64(v))
+		} else {
+			enc.integer(int64(v))
+		}
+	case float64:
+		enc.float(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			enc.integer(i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		enc.float(f)
+	case string:
+		enc.str(v)
+	case []byte:
+		enc.bin(v)
+	case []interface{}:
+		enc.array(len(v))
+		for _, item := range v {
+			if err := encode(enc, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		enc.object(len(keys))
+		for _, k := range keys {
+			enc.str(k)
+			if err := encode(enc, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot encode %T", v)
+	}
+	return nil
+}
+
+// float32Exact reports whether a float survives a round trip through
+// float32, which takes half the bytes on the wire
+func float32Exact(v float64) bool {
+	return float64(float32(v)) == v || math.IsNaN(v)
+}
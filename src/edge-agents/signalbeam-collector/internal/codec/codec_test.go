@@ -0,0 +1,234 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// testValue exercises every header size of both encodings
+func testValue() map[string]interface{} {
+	wide := make(map[string]interface{})
+	for i := 0; i < 20; i++ {
+		wide[strings.Repeat("k", i+1)] = i
+	}
+	long := make([]interface{}, 300)
+	for i := range long {
+		long[i] = i - 150
+	}
+	return map[string]interface{}{
+		"null":    nil,
+		"true":    true,
+		"false":   false,
+		"ints":    []interface{}{0, 1, 23, 24, 127, 128, 255, 256, 65535, 65536, int64(math.MaxUint32), int64(math.MaxUint32) + 1, int64(math.MaxInt64)},
+		"negs":    []interface{}{-1, -24, -25, -32, -33, -128, -129, -32768, -32769, int64(math.MinInt32), int64(math.MinInt32) - 1, int64(math.MinInt64)},
+		"big":     uint64(math.MaxUint64),
+		"floats":  []interface{}{0.5, -1.25, 3.14159, 1e300, math.Inf(1)},
+		"numbers": []interface{}{json.Number("7"), json.Number("-7"), json.Number("2.5")},
+		"strs":    []interface{}{"", "a", strings.Repeat("s", 31), strings.Repeat("s", 32), strings.Repeat("s", 255), strings.Repeat("s", 256), strings.Repeat("s", 70000)},
+		"bin":     []interface{}{[]byte{}, []byte{1, 2, 3}, bytes.Repeat([]byte{0xff}, 300), bytes.Repeat([]byte{0}, 70000)},
+		"wide":    wide,
+		"long":    long,
+		"nested":  map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": []interface{}{}}}},
+	}
+}
+
+// normalize brings decoded and expected values to one form: int64 for
+// integers that fit, float64 for floats, []byte for byte strings and
+// string keys
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v)
+		}
+		return float64(v)
+	case float32:
+		return float64(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalize(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = normalize(item)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k.(string)] = normalize(item)
+		}
+		return out
+	}
+	return v
+}
+
+func decodeCBOR(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	dm, err := cbor.DecOptions{
+		DefaultMapType:   reflect.TypeOf(map[string]interface{}{}),
+		MaxArrayElements: 1 << 20,
+	}.DecMode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	if err := dm.Unmarshal(data, &v); err != nil {
+		t.Fatalf("reference CBOR decoder rejects the output: %v", err)
+	}
+	return v
+}
+
+func decodeMsgPack(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	r := bytes.NewReader(data)
+	dec := msgpack.NewDecoder(r)
+	v, err := dec.DecodeInterface()
+	if err != nil {
+		t.Fatalf("reference MessagePack decoder rejects the output: %v", err)
+	}
+	if r.Len() > 0 {
+		t.Fatalf("%d bytes left after the value", r.Len())
+	}
+	return v
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	decoders := map[string]func(*testing.T, []byte) interface{}{
+		CBOR:    decodeCBOR,
+		MsgPack: decodeMsgPack,
+	}
+	want := normalize(testValue())
+	for name, decode := range decoders {
+		c, err := New(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := c.Encode(testValue())
+		if err != nil {
+			t.Fatalf("%s: Encode: %v", name, err)
+		}
+		got := normalize(decode(t, data))
+		if !reflect.DeepEqual(got, want) {
+			for k := range want.(map[string]interface{}) {
+				g, w := got.(map[string]interface{})[k], want.(map[string]interface{})[k]
+				if !reflect.DeepEqual(g, w) {
+					t.Errorf("%s: %s decoded as %v, want %v", name, k, g, w)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeIsDeterministic(t *testing.T) {
+	for _, name := range []string{CBOR, MsgPack} {
+		c, _ := New(name)
+		first, err := c.Encode(testValue())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			again, _ := c.Encode(testValue())
+			if !bytes.Equal(first, again) {
+				t.Fatalf("%s: equal values encoded differently", name)
+			}
+		}
+	}
+}
+
+func TestFloatWidth(t *testing.T) {
+	cases := []struct {
+		name string
+		v    float64
+		want []byte
+	}{
+		{CBOR, 0.5, []byte{0xfa, 0x3f, 0x00, 0x00, 0x00}},
+		{CBOR, 0.1, []byte{0xfb, 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+		{MsgPack, 0.5, []byte{0xca, 0x3f, 0x00, 0x00, 0x00}},
+		{MsgPack, 0.1, []byte{0xcb, 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+	}
+	for _, tc := range cases {
+		c, _ := New(tc.name)
+		got, err := c.Encode(tc.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("%s: %v encoded as % x, want % x", tc.name, tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestMarshalEnvelope(t *testing.T) {
+	record := struct {
+		Device string             `json:"device_id"`
+		Data   map[string]float64 `json:"data"`
+		Seq    int64              `json:"seq"`
+	}{"dev-1", map[string]float64{"temperature": 21.5}, 1 << 53}
+
+	for name, decode := range map[string]func(*testing.T, []byte) interface{}{CBOR: decodeCBOR, MsgPack: decodeMsgPack} {
+		c, _ := New(name)
+		data, err := c.Marshal(record)
+		if err != nil {
+			t.Fatalf("%s: Marshal: %v", name, err)
+		}
+		got := normalize(decode(t, data))
+		want := map[string]interface{}{
+			"ct": c.ContentType(),
+			"d": map[string]interface{}{
+				"device_id": "dev-1",
+				"data":      map[string]interface{}{"temperature": 21.5},
+				"seq":       int64(1 << 53),
+			},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Marshal decoded as %v, want %v", name, got, want)
+		}
+	}
+
+	c, _ := New(JSON)
+	data, err := c.Marshal(record)
+	if err != nil || !json.Valid(data) || data[0] != '{' {
+		t.Errorf("JSON: Marshal = %s, %v", data, err)
+	}
+}
+
+func TestEncodeRejectsUnknownTypes(t *testing.T) {
+	c, _ := New(CBOR)
+	if _, err := c.Encode(map[string]interface{}{"ch": make(chan int)}); err == nil {
+		t.Error("Encode accepted a channel")
+	}
+	if _, err := New("xml"); err == nil {
+		t.Error("New accepted an unknown encoding")
+	}
+}
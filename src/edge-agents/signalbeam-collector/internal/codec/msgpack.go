@@ -0,0 +1,92 @@
+package codec
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// msgpackEncoder writes MessagePack
+type msgpackEncoder struct {
+	buf []byte
+}
+
+// length writes the header of a string, binary, array or map: the fixed
+// form for small lengths, else the 8, 16 or 32 bit form. fix is zero for
+// types without a fixed form, and codes lacking an 8 bit form are zero too
+func (e *msgpackEncoder) length(n int, fix byte, fixMax int, c8, c16, c32 byte) {
+	switch {
+	case fix != 0 && n <= fixMax:
+		e.buf = append(e.buf, fix|byte(n))
+	case c8 != 0 && n <= math.MaxUint8:
+		e.buf = append(e.buf, c8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, c16), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, c32), uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) null() {
+	e.buf = append(e.buf, 0xc0)
+}
+
+func (e *msgpackEncoder) boolean(v bool) {
+	if v {
+		e.buf = append(e.buf, 0xc3)
+	} else {
+		e.buf = append(e.buf, 0xc2)
+	}
+}
+
+func (e *msgpackEncoder) integer(v int64) {
+	switch {
+	case v >= 0 && v <= 127, v < 0 && v >= -32:
+		e.buf = append(e.buf, byte(v)) // Positive and negative fixint
+	case v > 0 && v <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(v))
+	case v > 0 && v <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xcd), uint16(v))
+	case v > 0 && v <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xce), uint32(v))
+	case v > 0:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcf), uint64(v))
+	case v >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xd2), uint32(v))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xd3), uint64(v))
+	}
+}
+
+func (e *msgpackEncoder) float(v float64) {
+	if float32Exact(v) {
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xca), math.Float32bits(float32(v)))
+	} else {
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcb), math.Float64bits(v))
+	}
+}
+
+func (e *msgpackEncoder) str(v string) {
+	e.length(len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+	e.buf = append(e.buf, v...)
+}
+
+func (e *msgpackEncoder) bin(v []byte) {
+	e.length(len(v), 0, 0, 0xc4, 0xc5, 0xc6)
+	e.buf = append(e.buf, v...)
+}
+
+func (e *msgpackEncoder) array(n int) {
+	e.length(n, 0x90, 15, 0, 0xdc, 0xdd)
+}
+
+func (e *msgpackEncoder) object(n int) {
+	e.length(n, 0x80, 15, 0, 0xde, 0xdf)
+}
+
+func (e *msgpackEncoder) bytes() []byte {
+	return e.buf
+}
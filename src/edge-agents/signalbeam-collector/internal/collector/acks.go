@@ -0,0 +1,425 @@
+package collector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/httpsend"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/output"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/routing"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+// ackTracker holds published records until the ingestion service
+// acknowledges their message IDs, or batch IDs when exactly once
+type ackTracker struct {
+	mu    sync.Mutex
+	byID  map[string]*ackEntry
+	order []*ackEntry // First sent first; entries done are removed lazily
+
+	// Exactly once only: the entries by the hash of their payload, released
+	// when an HTTPS post of the payload succeeds, and the state file
+	bySum map[[sha256.Size]byte]*ackEntry
+	path  string
+	dirty bool // Changed since the state file was written
+
+	acked         atomic.Int64
+	retransmitted atomic.Int64
+	expired       atomic.Int64
+	evicted       atomic.Int64 // Stopped being tracked for max_pending
+	restored      atomic.Int64 // Loaded from the state file at startup
+}
+
+// ackEntry is a published message and the message IDs of its records; a
+// batch carries several
+type ackEntry struct {
+	msg       output.Message
+	ids       []string
+	remaining int
+	first     time.Time
+	sent      time.Time
+	done      bool
+}
+
+// ackRequest is what the ingestion service publishes on the ack topic
+type ackRequest struct {
+	MessageID  string   `json:"message_id"`
+	MessageIDs []string `json:"message_ids"`
+	BatchID    string   `json:"batch_id"`
+	BatchIDs   []string `json:"batch_ids"`
+}
+
+// savedAck is a message awaiting acks in the state file
+type savedAck struct {
+	IDs      []string  `json:"ids"` // Not yet acknowledged
+	Type     string    `json:"type"`
+	DeviceID string    `json:"device_id"`
+	Topic    string    `json:"topic"`
+	QoS      byte      `json:"qos"`
+	Retained bool      `json:"retained,omitempty"`
+	Payload  []byte    `json:"payload"`
+	First    time.Time `json:"first"`
+}
+
+// newAckTracker creates the tracker. When exactly once, the messages that
+// awaited acks at the last stop are loaded, to be sent again with their
+// batch IDs at the next check; those past their TTL are dropped
+func (c *Collector) newAckTracker() (*ackTracker, error) {
+	cfg := c.config.Acks
+	a := &ackTracker{byID: make(map[string]*ackEntry)}
+	if !cfg.ExactlyOnce {
+		return a, nil
+	}
+	a.bySum = make(map[[sha256.Size]byte]*ackEntry)
+	a.path = filepath.Join(state.Resolve(c.config.State).Dir, "acks.json")
+
+	data, err := os.ReadFile(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []savedAck
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", a.path, err)
+	}
+	now := time.Now()
+	for _, s := range saved {
+		if len(s.IDs) == 0 || now.Sub(s.First) >= cfg.TTL {
+			continue
+		}
+		a.add(&ackEntry{
+			msg: output.Message{
+				Type:     s.Type,
+				DeviceID: s.DeviceID,
+				Topic:    s.Topic,
+				QoS:      s.QoS,
+				Retained: s.Retained,
+				Payload:  s.Payload,
+				Time:     now,
+			},
+			ids:       s.IDs,
+			remaining: len(s.IDs),
+			first:     s.First,
+		})
+		a.restored.Add(1)
+	}
+	return a, nil
+}
+
+// Map reports the records awaiting acks and counters for the heartbeat
+func (a *ackTracker) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"pending":       a.Len(),
+		"acked":         a.acked.Load(),
+		"retransmitted": a.retransmitted.Load(),
+		"expired":       a.expired.Load(),
+		"evicted":       a.evicted.Load(),
+		"restored":      a.restored.Load(),
+	}
+}
+
+// Len returns the number of records awaiting acks
+func (a *ackTracker) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.byID)
+}
+
+// ackTopic returns the topic acknowledgements arrive on
+func (c *Collector) ackTopic() string {
+	return c.expandTopic(c.config.Acks.Topic, "acks")
+}
+
+// subscribeAcks accepts acknowledgements from the ingestion service
+func (c *Collector) subscribeAcks(client mqtt.Client) {
+	topic := c.ackTopic()
+	token := client.Subscribe(topic, 1, c.handleAckMessage)
+	if token.Wait() && token.Error() != nil {
+		c.logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to subscribe to ack topic")
+		c.reportError("acks", token.Error())
+	}
+}
+
+// handleAckMessage releases the acknowledged records. IDs that are not
+// tracked, acknowledged twice or given up, are ignored
+func (c *Collector) handleAckMessage(_ mqtt.Client, msg mqtt.Message) {
+	var req ackRequest
+	if err := json.Unmarshal(msg.Payload(), &req); err != nil {
+		c.logger.WithError(err).Warn("Ignoring invalid ack")
+		c.reportError("acks", fmt.Errorf("invalid ack: %w", err))
+		return
+	}
+	ids := append(req.MessageIDs, req.BatchIDs...)
+	for _, id := range []string{req.MessageID, req.BatchID} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	a := c.acks
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, id := range ids {
+		e, ok := a.byID[id]
+		if !ok {
+			continue
+		}
+		delete(a.byID, id)
+		a.dirty = a.path != ""
+		if e.remaining--; e.remaining == 0 {
+			a.release(e)
+			a.acked.Add(1)
+		}
+	}
+}
+
+// ackPosted releases the messages of records an HTTPS post delivered, when
+// exactly once: the response of the ingestion service is their ack
+func (c *Collector) ackPosted(records []httpsend.Record) {
+	a := c.acks
+	if a == nil || a.bySum == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, r := range records {
+		payload := []byte(r.Payload)
+		if r.Binary != nil {
+			payload = r.Binary
+		}
+		if e, ok := a.bySum[sha256.Sum256(payload)]; ok && !e.done {
+			a.release(e)
+			a.dirty = true
+			a.acked.Add(1)
+		}
+	}
+}
+
+// expectsAck reports whether records of the type are acknowledged
+func (c *Collector) expectsAck(dataType string) bool {
+	types := c.config.Acks.Types
+	return c.acks != nil && !c.dryRun && dataType != "heartbeat" && (len(types) == 0 || slices.Contains(types, dataType))
+}
+
+// exactlyOnce reports whether messages of the type carry a batch ID
+func (c *Collector) exactlyOnce(dataType string) bool {
+	return c.config.Acks.ExactlyOnce && c.sequence != nil && c.expectsAck(dataType)
+}
+
+// batchID returns the ID of a message of the records of the device
+// numbered first to last. A sequence number is never issued twice, so the
+// ID is unique, and the same whenever the message is sent
+func batchID(deviceID string, first, last uint64) string {
+	return fmt.Sprintf("%s:%d-%d", deviceID, first, last)
+}
+
+// trackAck holds a published message until the records with the message
+// IDs, or the batch ID, are acknowledged. Above max_pending the oldest
+// stops being tracked
+func (c *Collector) trackAck(dataType, deviceID string, route routing.Route, data []byte, ids []string) {
+	if !c.expectsAck(dataType) || len(ids) == 0 || slices.Contains(ids, "") {
+		return
+	}
+	now := time.Now()
+	e := &ackEntry{
+		msg: output.Message{
+			Type:     dataType,
+			DeviceID: deviceID,
+			Topic:    route.Topic,
+			QoS:      route.QoS,
+			Retained: route.Retained,
+			Payload:  data,
+			Time:     now,
+		},
+		ids:       ids,
+		remaining: len(ids),
+		first:     now,
+		sent:      now,
+	}
+
+	a := c.acks
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.add(e)
+	for len(a.byID) > c.config.Acks.MaxPending && len(a.order) > 0 {
+		oldest := a.order[0]
+		a.order = a.order[1:]
+		if !oldest.done {
+			a.release(oldest)
+			a.evicted.Add(1)
+		}
+	}
+}
+
+// add starts tracking an entry. Called with the lock held
+func (a *ackTracker) add(e *ackEntry) {
+	for _, id := range e.ids {
+		a.byID[id] = e
+	}
+	if a.bySum != nil {
+		a.bySum[sha256.Sum256(e.msg.Payload)] = e
+		a.dirty = true
+	}
+	a.order = append(a.order, e)
+}
+
+// release stops tracking an entry. Called with the lock held
+func (a *ackTracker) release(e *ackEntry) {
+	e.done = true
+	for _, id := range e.ids {
+		if a.byID[id] == e {
+			delete(a.byID, id)
+		}
+	}
+	if a.bySum != nil {
+		sum := sha256.Sum256(e.msg.Payload)
+		if a.bySum[sum] == e {
+			delete(a.bySum, sum)
+		}
+		a.dirty = true
+	}
+}
+
+// save writes the messages awaiting acks to the state file, when exactly
+// once and changed since the last save
+func (a *ackTracker) save() error {
+	a.mu.Lock()
+	if a.path == "" || !a.dirty {
+		a.mu.Unlock()
+		return nil
+	}
+	saved := make([]savedAck, 0, len(a.order))
+	for _, e := range a.order {
+		if e.done {
+			continue
+		}
+		s := savedAck{
+			Type:     e.msg.Type,
+			DeviceID: e.msg.DeviceID,
+			Topic:    e.msg.Topic,
+			QoS:      e.msg.QoS,
+			Retained: e.msg.Retained,
+			Payload:  e.msg.Payload,
+			First:    e.first,
+		}
+		for _, id := range e.ids {
+			if a.byID[id] == e {
+				s.IDs = append(s.IDs, id)
+			}
+		}
+		saved = append(saved, s)
+	}
+	data, err := json.Marshal(saved)
+	a.dirty = false
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(a.path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(a.path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(a.path+".tmp", a.path)
+}
+
+// ackLoop sends unacknowledged records again and gives up on those past
+// their TTL. When exactly once, it saves the records awaiting acks
+func (c *Collector) ackLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(max(c.config.Acks.Timeout/4, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.checkAcks(ctx)
+			if err := c.acks.save(); err != nil {
+				c.logger.WithError(err).Warn("Failed to save records awaiting acks")
+				c.reportError("acks", err)
+			}
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// checkAcks resends the records whose ack is overdue, with their message
+// IDs unchanged so the ingestion service can drop duplicates. Nothing is
+// resent while the connection is down; the TTL keeps running
+func (c *Collector) checkAcks(ctx context.Context) {
+	cfg := c.config.Acks
+	a := c.acks
+	now := time.Now()
+	online := c.transport != "mqtt" || c.mqttClient.IsConnectionOpen()
+
+	var resend []output.Message
+	var expired []*ackEntry
+	a.mu.Lock()
+	live := a.order[:0]
+	for _, e := range a.order {
+		switch {
+		case e.done:
+			continue
+		case now.Sub(e.first) >= cfg.TTL:
+			a.release(e)
+			expired = append(expired, e)
+			continue
+		case online && now.Sub(e.sent) >= cfg.Timeout:
+			e.sent = now
+			resend = append(resend, e.msg)
+		}
+		live = append(live, e)
+	}
+	clear(a.order[len(live):])
+	a.order = live
+	a.mu.Unlock()
+
+	for _, e := range expired {
+		a.expired.Add(1)
+		c.sequenceGap(len(e.ids))
+		c.reportDropped(e.msg.Type)
+		c.logger.WithFields(logrus.Fields{"type": e.msg.Type, "records": len(e.ids)}).Warn("Records were never acknowledged, giving up")
+	}
+	for _, msg := range resend {
+		if err := c.broker.Publish(ctx, msg); err != nil {
+			c.reportError("acks", err)
+			continue
+		}
+		a.retransmitted.Add(1)
+	}
+	if len(resend) > 0 {
+		c.logger.WithField("messages", len(resend)).Info("Sent unacknowledged records again")
+	}
+}
+
+// waitAcks waits until every record is acknowledged, at shutdown. Overdue
+// records are not sent again meanwhile
+func (c *Collector) waitAcks(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for c.acks.Len() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/mqtt5"
+	"github.com/sirupsen/logrus"
+)
+
+// Results of an ACL check per topic
+const (
+	aclAllowed    = "allowed"
+	aclDenied     = "denied"
+	aclUnverified = "unverified" // Published, but an MQTT 3.1.1 broker acknowledges denied publishes too
+	aclUntested   = "untested"
+	aclFailed     = "error" // No answer, so neither allowed nor denied
+)
+
+// mqttNotAuthorized is the MQTT 5 reason code of a publish refused by ACL
+const mqttNotAuthorized = 0x87
+
+// subackFailure is the MQTT 3.1.1 SUBACK return code of a refused filter
+const subackFailure = 0x80
+
+// aclTopic is a topic the device needs and what the broker said about it
+type aclTopic struct {
+	Access string `json:"access"` // publish or subscribe
+	Topic  string `json:"topic"`
+	Need   string `json:"need"` // What uses the topic, e.g. metrics or acks
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+
+	client mqtt.Client
+	qos    byte
+}
+
+// aclChecker keeps the outcome of the last check for the heartbeat
+type aclChecker struct {
+	run sync.Mutex // One check at a time
+
+	mu      sync.Mutex
+	checks  int64
+	last    time.Time
+	results []aclTopic
+}
+
+// Map reports the last check for the heartbeat
+func (a *aclChecker) Map() map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := map[string]interface{}{"checks": a.checks}
+	if a.last.IsZero() {
+		return out
+	}
+	out["last_check"] = a.last.Unix()
+	for k, v := range aclCounts(a.results) {
+		out[k] = v
+	}
+	return out
+}
+
+// aclCounts counts the topics per result
+func aclCounts(results []aclTopic) map[string]int {
+	counts := map[string]int{aclAllowed: 0, aclDenied: 0}
+	for _, r := range results {
+		counts[r.Result]++
+	}
+	return counts
+}
+
+// aclTopics lists the topics the device publishes to and subscribes to.
+// Control topics are subscribed on the control connection when there is one
+func (c *Collector) aclTopics() []aclTopic {
+	cfg := c.config
+	var topics []aclTopic
+	seen := make(map[string]bool)
+	add := func(access, topic, need string, client mqtt.Client, qos byte) {
+		if topic == "" || seen[access+" "+topic] {
+			return
+		}
+		seen[access+" "+topic] = true
+		topics = append(topics, aclTopic{Access: access, Topic: topic, Need: need, client: client, qos: qos})
+	}
+
+	for _, dataType := range []string{"heartbeat", "metrics", "logs", "events", "diagnostics"} {
+		qos, _ := cfg.MQTT.Delivery(dataType)
+		add("publish", c.getTopicName(dataType), dataType, c.mqttClient, qos)
+	}
+	if cfg.MQTT.Status.Enabled {
+		add("publish", c.getTopicName("status"), "status", c.mqttClient, cfg.MQTT.Status.QoS)
+	}
+	for _, r := range cfg.Routing.Rules {
+		if r.Topic == "" || (r.Match.Type == "" && strings.Contains(r.Topic, "{type}")) {
+			continue
+		}
+		qos, _ := cfg.MQTT.Delivery(r.Match.Type)
+		add("publish", c.expandTopic(r.Topic, r.Match.Type), "routing rule "+r.Name, c.mqttClient, qos)
+	}
+
+	control := c.mqttClient
+	if c.control != nil {
+		control = c.control
+	}
+	for _, t := range []struct {
+		enabled bool
+		need    string
+		topic   func() string
+	}{
+		{cfg.Decoders.Enabled, "decoders", c.decoderTopic},
+		{cfg.Workloads.Enabled, "workloads", c.workloadTopic},
+		{cfg.OutputPush.Enabled, "output_push", c.outputPushTopic},
+		{cfg.Uploads.Enabled, "uploads", c.uploadTopic},
+		{cfg.Drift.Enabled, "drift", c.driftTopic},
+		{cfg.Compliance.Enabled, "compliance", c.complianceTopic},
+		{cfg.Update.Enabled, "update", c.updateTopic},
+		{cfg.GPIO.Enabled, "gpio", c.gpioTopic},
+		{cfg.Acks.Enabled, "acks", c.ackTopic},
+		{cfg.CloudThrottle.Enabled, "cloud_throttle", c.throttleTopic},
+	} {
+		if t.enabled {
+			add("subscribe", t.topic(), t.need, control, 1)
+		}
+	}
+	if cfg.MQTT.EchoProbe {
+		add("subscribe", c.getTopicName("echo"), "echo_probe", c.mqttClient, 0)
+	}
+	for _, input := range cfg.Bridge.Inputs {
+		add("subscribe", input.Topic, "bridge "+input.Name, c.mqttClient, 0)
+	}
+	return topics
+}
+
+// startACLCheck checks the ACLs in the background after the first
+// connection
+func (c *Collector) startACLCheck(ctx context.Context) {
+	if c.aclCheck == nil {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		if _, err := c.checkACL(ctx); err != nil {
+			c.logger.WithError(err).Warn("Failed to check broker ACLs")
+		}
+	}()
+}
+
+// CheckACL checks the ACLs on request of the local API and returns the
+// outcome per topic
+func (c *Collector) CheckACL(ctx context.Context) (map[string]interface{}, error) {
+	if c.aclCheck == nil {
+		return nil, errors.New("acl_check is not enabled")
+	}
+	results, err := c.checkACL(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{"topics": results}
+	for k, v := range aclCounts(results) {
+		out[k] = v
+	}
+	return out, nil
+}
+
+// checkACL subscribes to every topic the device subscribes to again, which
+// keeps its handler, and with publish_test publishes a test message to
+// every telemetry topic. Each missing right is logged and reported with
+// its topic, and an acl_check event sums up the outcome
+func (c *Collector) checkACL(ctx context.Context) ([]aclTopic, error) {
+	a := c.aclCheck
+	a.run.Lock()
+	defer a.run.Unlock()
+	if !c.mqttClient.IsConnectionOpen() {
+		return nil, errors.New("not connected to the broker")
+	}
+
+	topics := c.aclTopics()
+	for i := range topics {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		t := &topics[i]
+		if t.Access == "subscribe" {
+			c.checkSubscribe(t)
+		} else {
+			c.checkPublish(t)
+		}
+	}
+
+	var missing []string
+	for _, t := range topics {
+		if t.Result != aclDenied {
+			continue
+		}
+		missing = append(missing, t.Access+" "+t.Topic)
+		c.logger.WithFields(logrus.Fields{"access": t.Access, "topic": t.Topic, "need": t.Need, "detail": t.Detail}).Error("Broker ACL missing")
+		c.reportError("acl", fmt.Errorf("%s on %s denied, needed by %s", t.Access, t.Topic, t.Need))
+	}
+
+	a.mu.Lock()
+	a.checks++
+	a.last = time.Now()
+	a.results = topics
+	a.mu.Unlock()
+
+	counts := aclCounts(topics)
+	fields := map[string]interface{}{"topics": len(topics)}
+	for k, v := range counts {
+		fields[k] = v
+	}
+	if len(missing) > 0 {
+		fields["missing"] = strings.Join(missing, ", ")
+	}
+	c.logger.WithFields(logrus.Fields(fields)).Info("Checked broker ACLs")
+	c.publishEvent("acl_check", fields)
+	return topics, nil
+}
+
+// checkSubscribe subscribes to the topic and reads the broker's answer
+func (c *Collector) checkSubscribe(t *aclTopic) {
+	token := t.client.Subscribe(t.Topic, t.qos, nil)
+	if !token.WaitTimeout(c.config.ACLCheck.Timeout) {
+		t.Result, t.Detail = aclFailed, "no answer from the broker"
+		return
+	}
+	var refused *mqtt5.SubscribeError
+	switch err := token.Error(); {
+	case errors.As(err, &refused):
+		t.Result, t.Detail = aclDenied, err.Error()
+		return
+	case err != nil:
+		t.Result, t.Detail = aclFailed, err.Error()
+		return
+	}
+	if r, ok := token.(interface{ Result() map[string]byte }); ok {
+		if code, ok := r.Result()[t.Topic]; ok && code >= subackFailure {
+			t.Result, t.Detail = aclDenied, fmt.Sprintf("broker refused the subscription (0x%02x)", code)
+			return
+		}
+	}
+	t.Result = aclAllowed
+}
+
+// checkPublish publishes a test message when allowed to. Only an MQTT 5
+// broker says whether it refused it; one that closes the connection over a
+// publish it refused is caught too
+func (c *Collector) checkPublish(t *aclTopic) {
+	if !c.config.ACLCheck.PublishTest {
+		t.Result = aclUntested
+		return
+	}
+	if err := c.checkTenant(t.Topic); err != nil {
+		t.Result, t.Detail = aclDenied, err.Error()
+		return
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":      "acl_test",
+		"device_id": c.config.Device.ID,
+		"timestamp": time.Now().UTC(),
+	})
+	if err != nil {
+		t.Result, t.Detail = aclFailed, err.Error()
+		return
+	}
+
+	token := t.client.Publish(t.Topic, t.qos, false, payload)
+	if !token.WaitTimeout(c.config.ACLCheck.Timeout) {
+		t.Result, t.Detail = aclFailed, "no answer from the broker"
+		return
+	}
+	var rejected *mqtt5.PublishError
+	switch err := token.Error(); {
+	case errors.As(err, &rejected) && rejected.ReasonCode == mqttNotAuthorized:
+		t.Result, t.Detail = aclDenied, err.Error()
+	case err != nil:
+		t.Result, t.Detail = aclFailed, err.Error()
+	case !t.client.IsConnectionOpen():
+		t.Result, t.Detail = aclDenied, "broker closed the connection after the publish"
+	case c.config.MQTT.Protocol == "5" && t.qos > 0:
+		t.Result = aclAllowed
+	default:
+		t.Result = aclUnverified
+	}
+}
@@ -0,0 +1,44 @@
+package collector
+
+import (
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/audit"
+)
+
+// maxAuditEntries bounds the audit entries attached to one diagnostics message
+const maxAuditEntries = 50
+
+// recordConfig appends a config.apply entry when the running configuration
+// differs from the last one recorded
+func (c *Collector) recordConfig() error {
+	hash := c.config.Hash()
+
+	last, ok, err := c.audit.Last("config.apply")
+	if err != nil {
+		return err
+	}
+	if ok && last.Details["hash"] == hash {
+		return nil
+	}
+
+	details := map[string]interface{}{"hash": hash}
+	if ok {
+		details["previous"] = last.Details["hash"]
+	}
+	actor := "local"
+	if c.config.Bootstrapped {
+		actor = "control-plane"
+		details["source"] = "bootstrap"
+	}
+	_, err = c.audit.Append(actor, "config.apply", "config", "applied", details)
+	return err
+}
+
+// pendingAudit returns audit entries not yet included in diagnostics
+func (c *Collector) pendingAudit() []audit.Entry {
+	entries, err := c.audit.Since(c.auditSent, maxAuditEntries)
+	if err != nil {
+		c.logger.WithError(err).Warn("Failed to read audit log")
+		return nil
+	}
+	return entries
+}
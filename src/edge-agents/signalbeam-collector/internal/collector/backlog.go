@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/downsample"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/output"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/queue"
+)
+
+// backlog takes records off a buffer for sending, downsampling old metrics
+// when replay.downsample is enabled. Records folded into a window leave the
+// buffer before their average is sent; averages not sent yet are held in
+// unsent and put back into the buffer when the drain ends for good
+type backlog struct {
+	stream *downsample.Stream // nil without downsampling
+	unsent []output.Message
+}
+
+func newBacklog(cfg config.DownsampleConfig) *backlog {
+	if !cfg.Enabled {
+		return &backlog{}
+	}
+	return &backlog{stream: &downsample.Stream{Downsampler: downsample.Downsampler{
+		OlderThan: cfg.OlderThan,
+		Mode:      downsample.Mode(cfg.Mode),
+		KeepEvery: cfg.KeepEvery,
+		Window:    cfg.Window,
+	}}}
+}
+
+// next sends the head of q, or what downsampling made of it, and removes it
+// from q. It reports whether q had a record
+func (b *backlog) next(q queue.Queue, send func(output.Message) error) (bool, error) {
+	if err := b.sendUnsent(send); err != nil {
+		return false, err
+	}
+	e, ok, err := q.Next()
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		b.flush()
+		return false, b.sendUnsent(send)
+	}
+
+	if b.stream != nil {
+		closed, keep := b.stream.Add(downsampleSample(e.Message), time.Now())
+		b.unsent = append(b.unsent, downsampledMessages(closed)...)
+		if !keep {
+			return true, q.Ack(e)
+		}
+		if err := b.sendUnsent(send); err != nil {
+			return false, err
+		}
+	}
+	if err := send(e.Message); err != nil {
+		return false, err
+	}
+	return true, q.Ack(e)
+}
+
+// sendUnsent sends the averages waiting in order
+func (b *backlog) sendUnsent(send func(output.Message) error) error {
+	for len(b.unsent) > 0 {
+		if err := send(b.unsent[0]); err != nil {
+			return err
+		}
+		b.unsent = b.unsent[1:]
+	}
+	return nil
+}
+
+// flush closes the open window
+func (b *backlog) flush() {
+	if b.stream != nil {
+		b.unsent = append(b.unsent, downsampledMessages(b.stream.Flush())...)
+	}
+}
+
+// putBack appends the averages not sent, including that of the open window,
+// to q so they are not lost with the process
+func (b *backlog) putBack(q queue.Queue) error {
+	b.flush()
+	for len(b.unsent) > 0 {
+		if err := q.Append(b.unsent[0]); err != nil {
+			return err
+		}
+		b.unsent = b.unsent[1:]
+	}
+	return nil
+}
+
+// downsampleSample reads the data of a record for averaging. Payloads other
+// than a single JSON record, e.g. compressed, encrypted or batched, carry
+// no data and are never averaged
+func downsampleSample(msg output.Message) downsample.Sample {
+	s := downsample.Sample{Timestamp: msg.Time, Type: msg.Type, Ref: msg}
+	var record map[string]interface{}
+	if json.Unmarshal(msg.Payload, &record) == nil {
+		s.Data, _ = record["data"].(map[string]interface{})
+	}
+	return s
+}
+
+// downsampledMessages turns window averages back into records: the last
+// record of the window with the averaged data, the start of the window as
+// timestamp and interpolated set
+func downsampledMessages(samples []downsample.Sample) []output.Message {
+	msgs := make([]output.Message, 0, len(samples))
+	for _, s := range samples {
+		msg := s.Ref.(output.Message)
+		var record map[string]interface{}
+		if err := json.Unmarshal(msg.Payload, &record); err != nil {
+			continue
+		}
+		record["data"] = s.Data
+		record["timestamp"] = s.Timestamp
+		if s.Interpolated {
+			record["interpolated"] = true
+		}
+		payload, err := json.Marshal(record)
+		if err != nil {
+			continue
+		}
+		msg.Payload = payload
+		msg.Time = s.Timestamp
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
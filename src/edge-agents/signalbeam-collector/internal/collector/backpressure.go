@@ -0,0 +1,136 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// backpressure stretches the collection interval while the outbound queue
+// is long, so an outage does not fill memory with records
+type backpressure struct {
+	factor atomic.Int64 // Of collection.interval; 1 while the queue is short
+
+	mu       sync.Mutex
+	since    time.Time // Degraded since; zero while not degraded
+	degraded int64     // Times the interval was stretched from its setting
+}
+
+// newBackpressure creates the state at the configured interval
+func newBackpressure() *backpressure {
+	b := &backpressure{}
+	b.factor.Store(1)
+	return b
+}
+
+// backpressureMap reports the stretch and the queue for the heartbeat
+func (c *Collector) backpressureMap() map[string]interface{} {
+	b := c.backpressure
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]interface{}{
+		"factor":     b.factor.Load(),
+		"interval_s": c.collectionInterval().Seconds(),
+		"queue":      c.outboundQueue(),
+		"degraded":   b.degraded,
+	}
+}
+
+// collectionInterval returns the interval of metrics, logs and virtual
+// devices, stretched under backpressure or a throttle of the ingestion
+// service, whichever is longer
+func (c *Collector) collectionInterval() time.Duration {
+	factor := c.throttleFactor()
+	if c.backpressure != nil {
+		factor = max(factor, c.backpressure.factor.Load())
+	}
+	return c.config.Collection.Interval * time.Duration(factor)
+}
+
+// adaptTicker resets a collection ticker when the interval changed since
+// it was set to cur, and returns the interval in effect
+func (c *Collector) adaptTicker(ticker *time.Ticker, cur time.Duration) time.Duration {
+	if d := c.collectionInterval(); d != cur {
+		ticker.Reset(d)
+		return d
+	}
+	return cur
+}
+
+// outboundQueue returns the records waiting to leave: those in the offline
+// buffer and the publishes in flight
+func (c *Collector) outboundQueue() int64 {
+	n := c.stats.inFlight.Load()
+	if c.buffer != nil {
+		n += c.buffer.q.Len()
+	}
+	return n
+}
+
+// backpressureLoop checks the outbound queue every check interval
+func (c *Collector) backpressureLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.config.Backpressure.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.checkBackpressure()
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// checkBackpressure doubles the interval while the queue holds more than
+// high records and halves it once the queue is below low. Events report
+// the interval first stretched and back at its setting
+func (c *Collector) checkBackpressure() {
+	cfg := c.config.Backpressure
+	b := c.backpressure
+	queue := c.outboundQueue()
+	factor := b.factor.Load()
+	next := factor
+	switch {
+	case queue > cfg.High:
+		next = min(factor*2, int64(cfg.MaxFactor))
+	case queue < cfg.Low:
+		next = max(factor/2, 1)
+	}
+	if next == factor {
+		return
+	}
+	b.factor.Store(next)
+
+	interval := c.config.Collection.Interval * time.Duration(next)
+	fields := map[string]interface{}{
+		"queue":      queue,
+		"factor":     next,
+		"interval_s": interval.Seconds(),
+	}
+	now := time.Now()
+	b.mu.Lock()
+	event := ""
+	switch {
+	case factor == 1:
+		b.since = now
+		b.degraded++
+		event = "backpressure_degraded"
+	case next == 1:
+		fields["degraded_s"] = int64(now.Sub(b.since).Seconds())
+		b.since = time.Time{}
+		event = "backpressure_restored"
+	}
+	b.mu.Unlock()
+
+	c.logger.WithFields(logrus.Fields(fields)).Info("Adapted collection interval to the outbound queue")
+	if event != "" {
+		c.publishEvent(event, fields)
+	}
+}
@@ -0,0 +1,173 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/routing"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// batcher holds records of batched streams until their batch is full or old
+// enough, so many samples go out in one message
+type batcher struct {
+	mu      sync.Mutex
+	pending map[string]*batch // By data type, device and route
+
+	batches int64 // Batches sent
+	records int64 // Records sent in batches
+}
+
+// batch is the records waiting for one topic
+type batch struct {
+	dataType string
+	deviceID string
+	route    routing.Route
+	records  []TelemetryData
+	timer    *time.Timer
+}
+
+// newBatcher returns a batcher when any stream is batched
+func newBatcher(cfg config.MQTTConfig) *batcher {
+	for _, s := range cfg.Streams {
+		if s.Batch.Size > 0 {
+			return &batcher{pending: make(map[string]*batch)}
+		}
+	}
+	return nil
+}
+
+// Map reports the batches sent and the records waiting for the heartbeat
+func (b *batcher) Map() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	waiting := 0
+	for _, p := range b.pending {
+		waiting += len(p.records)
+	}
+	return map[string]interface{}{
+		"batches": b.batches,
+		"records": b.records,
+		"pending": waiting,
+	}
+}
+
+// batchRecord adds a record to the batch of its topic. The batch is
+// published here once full, else by a timer when its first record has
+// waited the batch interval
+func (c *Collector) batchRecord(dataType string, telemetry TelemetryData, route routing.Route, cfg config.BatchConfig, tr *trace.Trace) error {
+	b := c.batcher
+	key := fmt.Sprintf("%s\x00%s\x00%s\x00%d\x00%t\x00%s", dataType, telemetry.DeviceID, route.Topic, route.QoS, route.Retained, strings.Join(route.Outputs, ","))
+
+	b.mu.Lock()
+	p := b.pending[key]
+	if p == nil {
+		p = &batch{dataType: dataType, deviceID: telemetry.DeviceID, route: route}
+		p.timer = time.AfterFunc(cfg.Interval, func() { c.flushBatch(key, p) })
+		b.pending[key] = p
+	}
+	p.records = append(p.records, telemetry)
+	n := len(p.records)
+	full := n >= cfg.Size
+	if full {
+		p.timer.Stop()
+		delete(b.pending, key)
+	}
+	b.mu.Unlock()
+
+	tr.Step("batch", trace.Passed, fmt.Sprintf("record %d of %d in the batch", n, cfg.Size))
+	if !full {
+		return nil
+	}
+	return c.sendBatch(p)
+}
+
+// flushBatch sends a batch whose interval ran out, unless it was sent
+// meanwhile
+func (c *Collector) flushBatch(key string, p *batch) {
+	b := c.batcher
+	b.mu.Lock()
+	if b.pending[key] != p {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, key)
+	b.mu.Unlock()
+
+	if err := c.sendBatch(p); err != nil {
+		c.logger.WithError(err).WithField("type", p.dataType).Error("Failed to send batch")
+	}
+}
+
+// flushBatches sends every waiting batch, at shutdown
+func (c *Collector) flushBatches() {
+	b := c.batcher
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = make(map[string]*batch)
+	b.mu.Unlock()
+
+	for _, p := range pending {
+		p.timer.Stop()
+		if err := c.sendBatch(p); err != nil {
+			c.logger.WithError(err).WithField("type", p.dataType).Error("Failed to send batch")
+		}
+	}
+}
+
+// sendBatch encodes the records of a batch as one array and publishes it
+func (c *Collector) sendBatch(p *batch) error {
+	id := ""
+	if c.exactlyOnce(p.dataType) {
+		id = batchID(p.deviceID, p.records[0].Sequence, p.records[len(p.records)-1].Sequence)
+		for i := range p.records {
+			p.records[i].BatchID = id
+		}
+	}
+	output := c.output()
+	span := c.resources.Start("output." + output)
+	data, raw, err := c.encodeTelemetry(p.dataType, p.records, nil)
+	span.End(0)
+	if err != nil {
+		c.sequenceGap(len(p.records))
+		return err
+	}
+
+	if err := c.publishRecord(output, p.dataType, p.deviceID, p.route, data, raw, nil); err != nil {
+		c.sequenceGap(len(p.records))
+		if errors.Is(err, errOverBudget) {
+			return nil
+		}
+		return fmt.Errorf("failed to publish to MQTT: %w", err)
+	}
+
+	ids := []string{id}
+	if id == "" {
+		ids = make([]string, 0, len(p.records))
+		for _, r := range p.records {
+			ids = append(ids, r.MessageID)
+		}
+	}
+	c.trackAck(p.dataType, p.deviceID, p.route, data, ids)
+
+	c.batcher.mu.Lock()
+	c.batcher.batches++
+	c.batcher.records += int64(len(p.records))
+	c.batcher.mu.Unlock()
+
+	c.logger.WithFields(logrus.Fields{
+		"topic":   p.route.Topic,
+		"size":    len(data),
+		"type":    p.dataType,
+		"records": len(p.records),
+	}).Debug("Sent telemetry batch")
+	return nil
+}
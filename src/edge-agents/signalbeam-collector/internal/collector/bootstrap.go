@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+	"github.com/sirupsen/logrus"
+)
+
+// FetchBootstrap connects with the local broker settings and waits for the
+// retained configuration on the device's bootstrap topic. It returns nil when
+// nothing is retained before the timeout
+func FetchBootstrap(cfg *config.Config, logger *logrus.Entry) ([]byte, error) {
+	if err := checkBrokerEgress(cfg); err != nil {
+		return nil, err
+	}
+
+	tlsCfg, err := mqttTLSConfig(cfg.MQTT.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure MQTT TLS: %w", err)
+	}
+
+	// A separate client ID keeps the bootstrap connection from taking over
+	// the persistent session of the collector
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(cfg.MQTT.Broker)
+	opts.SetClientID(cfg.MQTT.ClientID + "-bootstrap")
+	opts.SetUsername(cfg.MQTT.Username)
+	opts.SetPassword(cfg.MQTT.Password)
+	opts.SetConnectTimeout(cfg.MQTT.Timeout)
+	opts.SetTLSConfig(tlsCfg)
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(false)
+	if t := cfg.MQTT.Token; t.Enabled {
+		ctx, cancel := context.WithTimeout(context.Background(), t.Timeout)
+		token, err := tokenSource(t, &http.Client{}).Token(ctx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain MQTT access token: %w", err)
+		}
+		opts.SetPassword(token.AccessToken)
+	}
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(cfg.MQTT.Timeout) {
+		return nil, fmt.Errorf("timed out connecting to MQTT broker")
+	}
+	if token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+	defer client.Disconnect(250)
+
+	topic := bootstrapTopic(cfg)
+	received := make(chan []byte, 1)
+	token = client.Subscribe(topic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		// Only the retained document counts; live updates are applied on
+		// the next start
+		if !msg.Retained() {
+			return
+		}
+		select {
+		case received <- msg.Payload():
+		default:
+		}
+	})
+	if !token.WaitTimeout(cfg.MQTT.Timeout) {
+		return nil, fmt.Errorf("timed out subscribing to %s", topic)
+	}
+	if token.Error() != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", topic, token.Error())
+	}
+
+	logger.WithField("topic", topic).Info("Waiting for bootstrapped configuration")
+	select {
+	case doc := <-received:
+		if len(doc) == 0 {
+			return nil, nil
+		}
+		return doc, nil
+	case <-time.After(cfg.Bootstrap.Timeout):
+		return nil, nil
+	}
+}
+
+// bootstrapTopic returns the configured bootstrap topic for the device
+func bootstrapTopic(cfg *config.Config) string {
+	return strings.NewReplacer(
+		"{prefix}", cfg.MQTT.Topics.Prefix,
+		"{org}", cfg.Device.Org,
+		"{device_id}", cfg.Device.ID,
+	).Replace(cfg.Bootstrap.Topic)
+}
+
+// checkBrokerEgress applies the outbound allowlist to the broker address
+func checkBrokerEgress(cfg *config.Config) error {
+	if !cfg.Egress.Enabled {
+		return nil
+	}
+	policy, err := egress.New(cfg.Egress.Allowlist)
+	if err != nil {
+		return fmt.Errorf("failed to create egress policy: %w", err)
+	}
+
+	uri, err := url.Parse(cfg.MQTT.Broker)
+	if err != nil {
+		return fmt.Errorf("invalid broker address: %w", err)
+	}
+	port, _ := strconv.Atoi(uri.Port())
+	if port == 0 {
+		switch uri.Scheme {
+		case "unix":
+			return nil
+		case "ws":
+			port = 80
+		case "wss":
+			port = 443
+		case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps":
+			port = 8883
+		default:
+			port = 1883
+		}
+	}
+	return policy.Check(uri.Hostname(), port)
+}
@@ -0,0 +1,243 @@
+package collector
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/supervisor"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/units"
+	"github.com/sirupsen/logrus"
+)
+
+// subscribeBridge subscribes to the topic of every active bridge input.
+// Supervised inputs that are backing off or quarantined are skipped
+func (c *Collector) subscribeBridge(client mqtt.Client) {
+	for _, input := range c.config.Bridge.Inputs {
+		if _, active := c.bridgeHandle(input.Name); !active {
+			continue
+		}
+		if err := c.subscribeBridgeInput(client, input); err != nil {
+			c.logger.WithError(err).WithField("topic", input.Topic).Warn("Failed to subscribe to bridge input")
+			c.reportError("bridge."+input.Name, err)
+		}
+	}
+}
+
+// subscribeBridgeInput subscribes to one bridge input topic
+func (c *Collector) subscribeBridgeInput(client mqtt.Client, input config.BridgeInput) error {
+	token := client.Subscribe(input.Topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+		c.handleBridge(input, msg)
+	})
+	token.Wait()
+	return token.Error()
+}
+
+// runBridgeInput returns the supervised run function of a bridge input. The
+// input is subscribed while running and unsubscribed when restarted
+func (c *Collector) runBridgeInput(input config.BridgeInput) func(context.Context, *supervisor.Handle) error {
+	return func(ctx context.Context, h *supervisor.Handle) error {
+		c.bridgeMu.Lock()
+		c.bridgeHandles[input.Name] = h
+		c.bridgeMu.Unlock()
+		defer func() {
+			c.bridgeMu.Lock()
+			delete(c.bridgeHandles, input.Name)
+			c.bridgeMu.Unlock()
+		}()
+
+		// While disconnected the connect handler subscribes
+		if c.mqttClient.IsConnected() {
+			if err := c.subscribeBridgeInput(c.mqttClient, input); err != nil {
+				return fmt.Errorf("subscribe %s: %w", input.Topic, err)
+			}
+		}
+
+		<-ctx.Done()
+		if c.mqttClient.IsConnected() {
+			c.mqttClient.Unsubscribe(input.Topic).WaitTimeout(c.config.MQTT.Timeout)
+		}
+		return nil
+	}
+}
+
+// bridgeHandle returns the supervisor handle of a bridge input and whether
+// the input is active. Without supervision every input is active
+func (c *Collector) bridgeHandle(name string) (*supervisor.Handle, bool) {
+	c.bridgeMu.Lock()
+	defer c.bridgeMu.Unlock()
+	if c.bridgeHandles == nil {
+		return nil, true
+	}
+	h, ok := c.bridgeHandles[name]
+	return h, ok
+}
+
+// handleBridge decodes a raw sensor payload and publishes it as sensors telemetry
+func (c *Collector) handleBridge(input config.BridgeInput, msg mqtt.Message) {
+	h, active := c.bridgeHandle(input.Name)
+	if !active {
+		return
+	}
+	span := c.resources.Start("input.bridge." + input.Name)
+	defer func() {
+		span.End(0)
+		if r := recover(); r != nil {
+			err := fmt.Errorf("panic handling bridge payload: %v", r)
+			c.logger.WithError(err).WithField("input", input.Name).Error("Bridge input failed")
+			c.reportError("bridge."+input.Name, err)
+			if h != nil {
+				h.Fail(err)
+			}
+		}
+	}()
+
+	fields, err := c.decodeBridge(input, msg.Payload())
+	if err != nil {
+		c.logger.WithError(err).WithField("input", input.Name).Debug("Failed to decode bridge payload")
+		c.reportError("bridge."+input.Name, err)
+		if h != nil {
+			h.Fail(err)
+		}
+		return
+	}
+	if h != nil {
+		h.OK()
+	}
+
+	data := map[string]interface{}{
+		"source":  input.Name,
+		"topic":   msg.Topic(),
+		"decoder": input.Decoder,
+		"fields":  fields,
+	}
+	if meta := c.correctCounters(input, msg.Topic(), fields); len(meta) > 0 {
+		data["counters"] = meta
+	}
+	if meta := calibrate(input, fields); len(meta) > 0 {
+		data["calibration"] = meta
+	}
+	span.End(1)
+
+	if err := c.sendTelemetry("sensors", c.newTelemetry("sensors", data)); err != nil {
+		c.logger.WithError(err).WithField("input", input.Name).Warn("Failed to send sensor data")
+	}
+}
+
+// decodeBridge unwraps the transport encoding and applies the input's decoder
+func (c *Collector) decodeBridge(input config.BridgeInput, payload []byte) (map[string]interface{}, error) {
+	d, ok := c.decoders.Get(input.Decoder)
+	if !ok {
+		return nil, fmt.Errorf("decoder %q is not installed", input.Decoder)
+	}
+
+	raw := payload
+	switch input.Encoding {
+	case "hex":
+		b, err := hex.DecodeString(strings.TrimSpace(string(payload)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex payload: %w", err)
+		}
+		raw = b
+	case "base64":
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(payload)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 payload: %w", err)
+		}
+		raw = b
+	}
+
+	return d.Decode(raw)
+}
+
+// correctCounters replaces counter fields with monotonic totals corrected for
+// wraparound and device resets, and returns the raw values and event counts.
+// Counters are tracked per topic since one input may carry several devices
+func (c *Collector) correctCounters(input config.BridgeInput, topic string, fields map[string]interface{}) map[string]interface{} {
+	meta := make(map[string]interface{})
+	for field, cfg := range input.Counters {
+		raw, ok := units.ToFloat(fields[field])
+		if !ok {
+			continue
+		}
+		r := c.counters.Update(topic+"|"+field, cfg.Spec(), raw)
+		if r.Event != "" {
+			c.logger.WithFields(logrus.Fields{"input": input.Name, "topic": topic, "field": field}).Infof("Counter %s detected", r.Event)
+		}
+		fields[field] = r.Total
+
+		entry := map[string]interface{}{"raw": r.Raw, "wraps": r.Wraps, "resets": r.Resets}
+		if r.Event != "" {
+			entry["event"] = r.Event
+		}
+		meta[field] = entry
+	}
+	return meta
+}
+
+// calibrate applies per-channel calibration to decoded fields in place and
+// returns the calibration metadata of every calibrated field
+func calibrate(input config.BridgeInput, fields map[string]interface{}) map[string]interface{} {
+	meta := make(map[string]interface{})
+	for field, cfg := range input.Calibration {
+		raw, ok := units.ToFloat(fields[field])
+		if !ok {
+			continue
+		}
+		ch := cfg.Channel()
+		fields[field] = ch.Apply(raw)
+		meta[field] = ch.Metadata()
+	}
+	return meta
+}
+
+// decoderTopic returns the topic filter for pushed decoders
+func (c *Collector) decoderTopic() string {
+	return c.expandTopic(c.config.Decoders.Topic, "decoders")
+}
+
+// subscribeDecoders accepts decoder definitions pushed by the Control Plane
+func (c *Collector) subscribeDecoders(client mqtt.Client) {
+	topic := c.decoderTopic()
+	token := client.Subscribe(topic, 1, c.handleDecoderMessage)
+	if token.Wait() && token.Error() != nil {
+		c.logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to subscribe to decoder topic")
+	}
+}
+
+// handleDecoderMessage is the message handler of the decoder topic
+func (c *Collector) handleDecoderMessage(_ mqtt.Client, msg mqtt.Message) {
+	c.handleDecoderPush(msg)
+}
+
+// handleDecoderPush installs or removes a decoder named by the last topic level
+func (c *Collector) handleDecoderPush(msg mqtt.Message) {
+	name := msg.Topic()[strings.LastIndex(msg.Topic(), "/")+1:]
+
+	action := "decoder.install"
+	var err error
+	if len(msg.Payload()) == 0 {
+		action = "decoder.remove"
+		err = c.decoders.Remove(name)
+	} else {
+		err = c.decoders.Install(name, msg.Payload())
+	}
+
+	result := "applied"
+	logger := c.logger.WithFields(logrus.Fields{"decoder": name, "action": action})
+	if err != nil {
+		result = "failed"
+		logger.WithError(err).Warn("Failed to apply pushed decoder")
+		c.reportError("decoders", err)
+	} else {
+		logger.Info("Applied pushed decoder")
+	}
+
+	if _, aErr := c.audit.Append("control-plane", action, name, result, nil); aErr != nil {
+		c.logger.WithError(aErr).Warn("Failed to write audit entry")
+	}
+}
@@ -0,0 +1,194 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/budget"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// errOverBudget is returned for records dropped by the publish budget
+var errOverBudget = errors.New("publish budget exceeded")
+
+// rateEventInterval spaces publish_rate_exceeded events
+const rateEventInterval = time.Minute
+
+// budgetEvents are published when the byte budgets change level
+var budgetEvents = map[budget.Level]string{
+	budget.Full:      "publish_budget_restored",
+	budget.Degraded:  "publish_budget_degraded",
+	budget.Exhausted: "publish_budget_exhausted",
+}
+
+// publishBudget drops publishes over the rate or byte budgets, lowest
+// priority first
+type publishBudget struct {
+	budget     *budget.Budget
+	priorities map[string]budget.Priority
+
+	mu        sync.Mutex
+	level     budget.Level     // Last reported
+	dropped   map[string]int64 // By data type
+	rateDrops int64            // Since the last rate event
+	rateEvent time.Time
+}
+
+// newPublishBudget loads the byte totals of the current day and month
+func (c *Collector) newPublishBudget() (*publishBudget, error) {
+	cfg := c.config.Budget
+	path := filepath.Join(state.Resolve(c.config.State).Dir, "publish-budget.json")
+	b, err := budget.Open(path, budget.Limits{
+		Rate:      cfg.MaxRate,
+		Burst:     cfg.Burst,
+		Daily:     cfg.Daily,
+		Monthly:   cfg.Monthly,
+		ResetDay:  cfg.ResetDay,
+		DegradeAt: cfg.DegradeAt,
+		HardCap:   cfg.HardCap,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	priorities := make(map[string]budget.Priority)
+	for _, typ := range cfg.Low {
+		priorities[typ] = budget.Low
+	}
+	for _, typ := range cfg.Critical {
+		priorities[typ] = budget.Critical
+	}
+	p := &publishBudget{
+		budget:     b,
+		priorities: priorities,
+		dropped:    make(map[string]int64),
+	}
+	// Restarting within an exhausted budget does not announce it again
+	p.level = b.Snapshot(time.Now()).Level
+	return p, nil
+}
+
+// Map reports the use of the budgets for the heartbeat
+func (p *publishBudget) Map() map[string]interface{} {
+	snap := p.budget.Snapshot(time.Now())
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dropped := make(map[string]interface{}, len(p.dropped))
+	for typ, n := range p.dropped {
+		dropped[typ] = n
+	}
+	out := map[string]interface{}{
+		"level":         snap.Level.String(),
+		"daily_bytes":   snap.DayBytes,
+		"monthly_bytes": snap.MonthBytes,
+		"dropped":       dropped,
+	}
+	if snap.Reason != "" {
+		out["budget"] = snap.Reason
+	}
+	return out
+}
+
+// checkBudget accounts an encoded record against the publish budget and
+// returns errOverBudget when it is dropped
+func (c *Collector) checkBudget(dataType string, size int, tr *trace.Trace) error {
+	p := c.budget
+	if p == nil {
+		return nil
+	}
+	priority, ok := p.priorities[dataType]
+	if !ok {
+		priority = budget.Normal
+	}
+	allowed, reason := p.budget.Allow(priority, size, time.Now())
+	if allowed {
+		return nil
+	}
+
+	tr.Step("budget", trace.Dropped, reason+" budget exceeded")
+	c.reportDropped(dataType)
+	p.mu.Lock()
+	p.dropped[dataType]++
+	rateDrops := int64(0)
+	if reason == budget.ReasonRate {
+		p.rateDrops++
+		if now := time.Now(); now.Sub(p.rateEvent) >= rateEventInterval {
+			p.rateEvent, rateDrops, p.rateDrops = now, p.rateDrops, 0
+		}
+	}
+	p.mu.Unlock()
+
+	if rateDrops > 0 {
+		c.logger.WithFields(logrus.Fields{"type": dataType, "max_rate": c.config.Budget.MaxRate}).Warn("Publish rate exceeded, dropping telemetry")
+		c.publishEvent("publish_rate_exceeded", map[string]interface{}{
+			"max_rate": c.config.Budget.MaxRate,
+			"dropped":  rateDrops,
+		})
+	} else if reason != budget.ReasonRate {
+		c.updateBudgetLevel()
+	}
+	return errOverBudget
+}
+
+// budgetLoop saves the byte totals and reports level changes, such as the
+// budget restored on a new day
+func (c *Collector) budgetLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.updateBudgetLevel()
+			if err := c.budget.budget.Save(); err != nil {
+				c.logger.WithError(err).Warn("Failed to save publish budget")
+				c.reportError("budget", err)
+			}
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// updateBudgetLevel publishes an event when the byte budgets changed level
+// since the last report. Once exhausted under a hard cap the event itself is
+// dropped; the heartbeat reports it when the budget resets
+func (c *Collector) updateBudgetLevel() {
+	p := c.budget
+	snap := p.budget.Snapshot(time.Now())
+	p.mu.Lock()
+	changed := snap.Level != p.level
+	p.level = snap.Level
+	p.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	cfg := c.config.Budget
+	fields := map[string]interface{}{
+		"level":         snap.Level.String(),
+		"daily_bytes":   snap.DayBytes,
+		"monthly_bytes": snap.MonthBytes,
+		"daily_limit":   cfg.Daily,
+		"monthly_limit": cfg.Monthly,
+		"hard_cap":      cfg.HardCap,
+	}
+	if snap.Reason != "" {
+		fields["budget"] = snap.Reason
+	}
+	logger := c.logger.WithFields(logrus.Fields(fields))
+	if snap.Level == budget.Full {
+		logger.Info("Publish budget restored")
+	} else {
+		logger.Warn("Publish budget running out, dropping telemetry")
+	}
+	c.publishEvent(budgetEvents[snap.Level], fields)
+}
@@ -0,0 +1,230 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/output"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/queue"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+// bufferRetry is how often draining is retried while a backlog is held
+const bufferRetry = 5 * time.Second
+
+// offlineBuffer holds records while the broker is unreachable and sends
+// them in order once it is reachable again
+type offlineBuffer struct {
+	q       queue.Queue
+	wake    chan struct{}
+	backlog *backlog
+
+	buffered atomic.Int64
+	sent     atomic.Int64
+	failed   atomic.Int64 // Records that could not be stored
+}
+
+// BufferPath returns the database of the SQLite buffer
+func BufferPath(cfg *config.Config) string {
+	if path := cfg.Buffer.SQLite.Path; path != "" {
+		return path
+	}
+	return filepath.Join(state.Resolve(cfg.State).BufferDir, "telemetry.db")
+}
+
+// OpenBuffer opens the disk or SQLite buffer of the configuration. A memory
+// buffer holds nothing outside the collector. The buffer stays locked until
+// closed; queue.ErrLocked means another process, such as the running
+// service, has it open
+func OpenBuffer(cfg *config.Config) (queue.Queue, error) {
+	b := cfg.Buffer
+	switch b.Type {
+	case "memory":
+		return nil, errors.New("a memory buffer is not kept on disk")
+	case "sqlite":
+		path := BufferPath(cfg)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, err
+		}
+		return queue.Lock(path+".lock", func() (queue.Queue, error) {
+			return queue.OpenSQLite(path, queue.SQLiteOptions{
+				MaxBytes: b.MaxBytes,
+				MaxAge:   b.MaxAge,
+				Vacuum:   b.SQLite.Vacuum,
+			})
+		})
+	}
+	dir := state.Resolve(cfg.State).BufferDir
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return queue.Lock(filepath.Join(dir, "lock"), func() (queue.Queue, error) {
+		return queue.OpenDisk(dir, queue.DiskOptions{
+			SegmentBytes: b.SegmentBytes,
+			MaxBytes:     b.MaxBytes,
+			MaxAge:       b.MaxAge,
+		})
+	})
+}
+
+// newBuffer opens the disk or SQLite buffer or creates the memory buffer
+func (c *Collector) newBuffer() (*offlineBuffer, error) {
+	cfg := c.config.Buffer
+	if cfg.Type == "memory" {
+		m := cfg.Memory
+		return &offlineBuffer{
+			q: queue.NewMemory(queue.MemoryOptions{
+				MaxRecords:   m.MaxRecords,
+				MaxBytes:     m.MaxBytes,
+				MaxAge:       cfg.MaxAge,
+				Overflow:     queue.Overflow(m.Overflow),
+				BlockTimeout: m.BlockTimeout,
+				Dropped: func(msg output.Message) {
+					c.reportDropped(msg.Type)
+				},
+			}),
+			wake:    make(chan struct{}, 1),
+			backlog: newBacklog(c.config.Replay.Downsample),
+		}, nil
+	}
+
+	q, err := OpenBuffer(c.config)
+	if err != nil {
+		return nil, err
+	}
+	if n := q.Len(); n > 0 {
+		c.logger.WithField("records", n).Info("Buffered records from a previous run will be sent once connected")
+	}
+	return &offlineBuffer{q: q, wake: make(chan struct{}, 1), backlog: newBacklog(c.config.Replay.Downsample)}, nil
+}
+
+// Map reports the buffer for the heartbeat
+func (b *offlineBuffer) Map() map[string]interface{} {
+	m := b.q.Stats().Map()
+	m["buffered"] = b.buffered.Load()
+	m["sent"] = b.sent.Load()
+	m["failed"] = b.failed.Load()
+	return m
+}
+
+// buffers reports whether a message goes to the buffer rather than the
+// transport: while the broker is unreachable or the ingestion service
+// throttles the device, and while a backlog drains so records stay in
+// order. Heartbeats and retained messages describe the current state, which
+// a later message replaces, and are never buffered. Over HTTPS the fallback
+// carries records instead
+func (c *Collector) buffers(transport string, msg output.Message) bool {
+	switch {
+	case c.buffer == nil || transport == "https" || msg.Type == "heartbeat" || msg.Retained:
+		return false
+	case !c.mqttClient.IsConnectionOpen(), c.throttleFactor() > 1:
+		return true
+	}
+	return c.buffer.q.Len() > 0
+}
+
+// bufferMessage stores a message for later; a message that cannot be
+// stored is lost. A full memory buffer is not an error of the buffer, its
+// overflow policy decided
+func (c *Collector) bufferMessage(msg output.Message) error {
+	b := c.buffer
+	if err := b.q.Append(msg); err != nil {
+		if !errors.Is(err, queue.ErrFull) {
+			b.failed.Add(1)
+			c.reportError("buffer", err)
+		}
+		return err
+	}
+	b.buffered.Add(1)
+	return nil
+}
+
+// wakeBuffer starts draining without waiting for the next retry
+func (c *Collector) wakeBuffer() {
+	if c.buffer == nil {
+		return
+	}
+	select {
+	case c.buffer.wake <- struct{}{}:
+	default:
+	}
+}
+
+// bufferLoop drains the buffer whenever the broker is reachable
+func (c *Collector) bufferLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(bufferRetry)
+	defer ticker.Stop()
+	for {
+		c.drainBuffer(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.buffer.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// drainBuffer sends buffered records oldest first, one at a time, until the
+// buffer is empty or a publish fails. A record leaves the buffer once the
+// broker acknowledged it, so none is lost when the connection drops again;
+// old metrics may be downsampled on the way. While throttled records leave
+// at the throttled replay rate
+func (c *Collector) drainBuffer(ctx context.Context) {
+	b := c.buffer
+	drained := 0
+	defer func() {
+		if drained > 0 {
+			c.logger.WithFields(logrus.Fields{"records": drained, "left": b.q.Len()}).Info("Sent buffered records")
+		}
+	}()
+	sendFailed := false
+	send := func(msg output.Message) error {
+		sendFailed = true
+		done := make(chan error, 1)
+		if err := c.publishTo(c.transport, msg.Topic, msg.QoS, msg.Retained, msg.Payload, len(msg.Payload), func(err error) { done <- err }); err != nil {
+			return err
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				c.reportError("buffer", err)
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		sendFailed = false
+		return nil
+	}
+	for ctx.Err() == nil && c.mqttClient.IsConnectionOpen() {
+		ok, err := b.backlog.next(b.q, send)
+		if err != nil {
+			if !sendFailed && !errors.Is(err, queue.ErrClosed) {
+				c.logger.WithError(err).Warn("Failed to take buffered record")
+				c.reportError("buffer", err)
+			}
+			return
+		}
+		if !ok {
+			return
+		}
+		b.sent.Add(1)
+		drained++
+
+		if wait := c.throttleDelay(); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
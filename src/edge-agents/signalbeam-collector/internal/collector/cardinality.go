@@ -0,0 +1,54 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// enforceCardinality caps the label values of each configured series path in
+// a metrics payload, emitting a warning event for newly overflowing values.
+// It returns the paths that overflowed
+func (c *Collector) enforceCardinality(data map[string]interface{}) []string {
+	var limited []string
+	for _, path := range c.config.Cardinality.Paths {
+		series := lookupMap(data, path)
+		if series == nil {
+			continue
+		}
+
+		overflow := c.cardinality.Apply(path, series)
+		if len(overflow) == 0 {
+			continue
+		}
+		limited = append(limited, path)
+
+		c.logger.WithFields(logrus.Fields{
+			"metric":   path,
+			"overflow": overflow,
+			"limit":    c.config.Cardinality.MaxSeries,
+		}).Warn("Series cardinality limit reached")
+		c.reportError("cardinality."+path, fmt.Errorf("limit of %d series reached", c.config.Cardinality.MaxSeries))
+		c.sendEvent("cardinality_limit", map[string]interface{}{
+			"metric":   path,
+			"limit":    c.config.Cardinality.MaxSeries,
+			"policy":   c.config.Cardinality.Policy,
+			"overflow": overflow,
+		})
+	}
+	return limited
+}
+
+// lookupMap resolves a dotted path to a nested map, or nil
+func lookupMap(data map[string]interface{}, path string) map[string]interface{} {
+	current := data
+	for _, part := range strings.Split(path, ".") {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/counter"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/deadband"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+// checkpoint is the state of the collector's processors kept in the state
+// file. Log offsets and sequence numbers have files of their own, written
+// as they change
+type checkpoint struct {
+	SavedAt  time.Time                   `json:"saved_at"`
+	Counters map[string]counter.Baseline `json:"counters,omitempty"`
+	Deadband map[string]deadband.Last    `json:"deadband,omitempty"`
+}
+
+// restoreCheckpoint continues counter totals and report-by-exception
+// values from the last checkpoint, so a restart neither resets counter
+// totals, which looks like a drop followed by a spike downstream, nor
+// publishes unchanged values again. A state file that cannot be read is
+// moved aside and the collector starts fresh, rather than not starting
+func (c *Collector) restoreCheckpoint() {
+	path := state.Resolve(c.config.State).StateFile
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var cp checkpoint
+	if err == nil {
+		err = json.Unmarshal(data, &cp)
+	}
+	if err != nil {
+		log := c.logger.WithError(err).WithField("path", path)
+		if rErr := os.Rename(path, path+".corrupt"); rErr != nil {
+			log = log.WithField("rename_error", rErr.Error())
+		}
+		log.Warn("Unreadable state file moved aside, starting with fresh collector state")
+		return
+	}
+
+	c.counters.Restore(cp.Counters)
+	if c.deadband != nil {
+		c.deadband.Restore(cp.Deadband)
+	}
+	c.logger.WithFields(logrus.Fields{
+		"saved_at": cp.SavedAt,
+		"counters": len(cp.Counters),
+		"deadband": len(cp.Deadband),
+	}).Info("Restored collector state")
+}
+
+// saveCheckpoint writes the state file atomically. The temporary file is
+// synced before the rename, so a power cut leaves the old or the new state
+// rather than an empty file
+func (c *Collector) saveCheckpoint() error {
+	cp := checkpoint{SavedAt: time.Now().UTC(), Counters: c.counters.Snapshot()}
+	if c.deadband != nil {
+		cp.Deadband = c.deadband.Snapshot()
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	path := state.Resolve(c.config.State).StateFile
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync state file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// checkpointLoop saves the state every checkpoint interval, bounding what
+// a crash loses to one interval
+func (c *Collector) checkpointLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.config.State.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.saveCheckpoint(); err != nil {
+				c.logger.WithError(err).Warn("Failed to save collector state")
+				c.reportError("state", err)
+			}
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
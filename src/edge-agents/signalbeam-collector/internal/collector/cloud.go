@@ -0,0 +1,90 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/azure"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// newDeviceSAS signs IoT Hub tokens with the device key. They are replaced
+// before they expire like access tokens, reconnecting
+func (c *Collector) newDeviceSAS() (*brokerToken, error) {
+	cfg := c.config.MQTT
+	a := cfg.Cloud.Azure
+	broker, err := url.Parse(cfg.Broker)
+	if err != nil {
+		return nil, err
+	}
+	return &brokerToken{
+		connection: "telemetry",
+		username:   cfg.Username,
+		source: &azure.DeviceSAS{
+			Hub:      broker.Hostname(),
+			DeviceID: c.config.Device.ID,
+			Key: func() (string, error) {
+				if a.SharedAccessKeyFile == "" {
+					return a.SharedAccessKey, nil
+				}
+				data, err := os.ReadFile(a.SharedAccessKeyFile)
+				return strings.TrimSpace(string(data)), err
+			},
+			Lifetime: a.TokenLifetime,
+		},
+		cfg: config.TokenConfig{RefreshBefore: a.RefreshBefore, Timeout: cfg.Timeout},
+	}, nil
+}
+
+// reportTwin writes the collector's version and configuration to the
+// reported properties of the device twin. IoT Hub answers on the response
+// topic, which is subscribed first
+func (c *Collector) reportTwin(client mqtt.Client) {
+	token := client.Subscribe(azure.TwinResponses, 0, c.handleTwinResponse)
+	if token.Wait() && token.Error() != nil {
+		c.logger.WithError(token.Error()).Warn("Failed to subscribe to device twin responses")
+		c.reportError("twin", token.Error())
+		return
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"signalbeam": map[string]interface{}{
+			"version":      Version,
+			"profile":      c.config.Profile,
+			"config_hash":  c.config.Hash(),
+			"started_at":   c.startedAt.UTC().Format(time.RFC3339),
+			"connected_at": time.Now().UTC().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return
+	}
+	id := strconv.FormatInt(c.twinRequests.Add(1), 10)
+	if err := c.publishTo(c.transport, azure.TwinReportedTopic(id), 0, false, data, len(data), nil); err != nil {
+		c.logger.WithError(err).Warn("Failed to report device twin properties")
+		c.reportError("twin", err)
+	}
+}
+
+// handleTwinResponse logs the outcome of a reported properties update
+func (c *Collector) handleTwinResponse(_ mqtt.Client, msg mqtt.Message) {
+	status, id, ok := azure.ParseTwinResponse(msg.Topic())
+	if !ok {
+		return
+	}
+	entry := c.logger.WithFields(logrus.Fields{"status": status, "request_id": id})
+	if status/100 != 2 {
+		err := fmt.Errorf("twin update %s answered with status %d", id, status)
+		entry.Warn("IoT Hub refused the device twin update")
+		c.reportError("twin", err)
+		return
+	}
+	entry.Debug("Reported device twin properties")
+}
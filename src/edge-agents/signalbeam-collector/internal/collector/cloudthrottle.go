@@ -0,0 +1,184 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+// cloudThrottle is the slowdown the ingestion service asked for
+type cloudThrottle struct {
+	factor atomic.Int64 // Of collection.interval; 1 while not throttled
+
+	mu        sync.Mutex
+	since     time.Time // Throttled since
+	until     time.Time
+	reason    string
+	timer     *time.Timer // Ends the throttle
+	throttled int64       // Times throttled
+
+	requests atomic.Int64
+	rejected atomic.Int64
+}
+
+// throttleRequest is what the ingestion service publishes on the throttle
+// topic. A factor of 1 or no duration lifts the throttle
+type throttleRequest struct {
+	Factor   int64  `json:"factor"`
+	Duration string `json:"duration"` // e.g. "15m"
+	Reason   string `json:"reason,omitempty"`
+}
+
+// newCloudThrottle creates the state, not throttled
+func newCloudThrottle() *cloudThrottle {
+	t := &cloudThrottle{}
+	t.factor.Store(1)
+	return t
+}
+
+// Map reports the throttle in effect and counters for the heartbeat
+func (t *cloudThrottle) Map() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := map[string]interface{}{
+		"factor":    t.factor.Load(),
+		"throttled": t.throttled,
+		"requests":  t.requests.Load(),
+		"rejected":  t.rejected.Load(),
+	}
+	if t.factor.Load() > 1 {
+		out["remaining_s"] = int64(time.Until(t.until).Seconds())
+		if t.reason != "" {
+			out["reason"] = t.reason
+		}
+	}
+	return out
+}
+
+// throttleFactor returns the factor the ingestion service asked for, 1
+// while not throttled
+func (c *Collector) throttleFactor() int64 {
+	if c.cloudThrottle == nil {
+		return 1
+	}
+	return c.cloudThrottle.factor.Load()
+}
+
+// throttleDelay returns the pause between records sent from the offline
+// buffer while throttled
+func (c *Collector) throttleDelay() time.Duration {
+	factor := c.throttleFactor()
+	if factor == 1 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) * float64(factor) / c.config.CloudThrottle.ReplayRate)
+}
+
+// throttleTopic returns the topic throttle requests arrive on
+func (c *Collector) throttleTopic() string {
+	return c.expandTopic(c.config.CloudThrottle.Topic, "throttle")
+}
+
+// subscribeThrottle accepts throttle requests from the ingestion service
+func (c *Collector) subscribeThrottle(client mqtt.Client) {
+	topic := c.throttleTopic()
+	token := client.Subscribe(topic, 1, c.handleThrottleMessage)
+	if token.Wait() && token.Error() != nil {
+		c.logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to subscribe to throttle topic")
+		c.reportError("cloud_throttle", token.Error())
+	}
+}
+
+// handleThrottleMessage applies a throttle request, capping the factor and
+// duration at their configured maximum. A new request replaces the one in
+// effect
+func (c *Collector) handleThrottleMessage(_ mqtt.Client, msg mqtt.Message) {
+	cfg := c.config.CloudThrottle
+	t := c.cloudThrottle
+	var req throttleRequest
+	var d time.Duration
+	err := json.Unmarshal(msg.Payload(), &req)
+	if err == nil && req.Duration != "" {
+		d, err = time.ParseDuration(req.Duration)
+	}
+	if err == nil && (req.Factor < 0 || d < 0) {
+		err = fmt.Errorf("factor and duration must not be negative")
+	}
+	if err != nil {
+		t.rejected.Add(1)
+		c.logger.WithError(err).Warn("Ignoring invalid throttle request")
+		c.reportError("cloud_throttle", fmt.Errorf("invalid throttle request: %w", err))
+		return
+	}
+	t.requests.Add(1)
+
+	if req.Factor <= 1 || d == 0 {
+		c.endThrottle("lifted")
+		return
+	}
+	c.startThrottle(min(req.Factor, int64(cfg.MaxFactor)), min(d, cfg.MaxDuration), req.Reason)
+}
+
+// startThrottle stretches the collection interval by factor for d and
+// holds records in the offline buffer meanwhile
+func (c *Collector) startThrottle(factor int64, d time.Duration, reason string) {
+	t := c.cloudThrottle
+	now := time.Now()
+	t.mu.Lock()
+	started := t.factor.Load() == 1
+	if started {
+		t.since = now
+		t.throttled++
+	}
+	t.until, t.reason = now.Add(d), reason
+	t.factor.Store(factor)
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.timer = time.AfterFunc(d, func() { c.endThrottle("expired") })
+	t.mu.Unlock()
+
+	fields := map[string]interface{}{
+		"factor":     factor,
+		"duration":   d.String(),
+		"interval_s": c.collectionInterval().Seconds(),
+	}
+	if reason != "" {
+		fields["reason"] = reason
+	}
+	c.logger.WithFields(logrus.Fields(fields)).Warn("Ingestion service asked to slow down")
+	if started {
+		c.publishEvent("throttle_started", fields)
+	}
+}
+
+// endThrottle goes back to the configured interval and sends the records
+// held meanwhile. A timer of a throttle since replaced does nothing
+func (c *Collector) endThrottle(how string) {
+	t := c.cloudThrottle
+	now := time.Now()
+	t.mu.Lock()
+	if t.factor.Load() == 1 || (how == "expired" && now.Before(t.until)) {
+		t.mu.Unlock()
+		return
+	}
+	t.factor.Store(1)
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	fields := map[string]interface{}{
+		"ended":       how,
+		"throttled_s": int64(now.Sub(t.since).Seconds()),
+	}
+	t.mu.Unlock()
+
+	c.logger.WithFields(logrus.Fields(fields)).Info("Throttle by the ingestion service ended")
+	c.publishEvent("throttle_ended", fields)
+	c.wakeBuffer()
+}
@@ -1,35 +1,186 @@
 package collector
 
 import (
+	"cmp"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/accounting"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/audit"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/backoff"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/cardinality"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/codec"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/compress"
 	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/counter"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/deadband"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/decoder"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/envelope"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/expr"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/fips"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/flows"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/hwinfo"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/localapi"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/logarchive"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/logtail"
 	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/metrics"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/mqtt5"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/output"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/quality"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/routing"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/sequence"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/signing"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/supervisor"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/topics"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/trace"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/units"
 	"github.com/sirupsen/logrus"
 )
 
 // Collector represents the main edge data collector
 type Collector struct {
-	config     *config.Config
-	logger     *logrus.Entry
-	mqttClient mqtt.Client
-	metrics    *metrics.Collector
-	stopCh     chan struct{}
-	wg         sync.WaitGroup
+	config        *config.Config
+	logger        *logrus.Entry
+	mqttClient    mqtt.Client
+	control       mqtt.Client  // Commands and configuration, when they have credentials of their own
+	mqttToken     *brokerToken // Access tokens used as MQTT passwords
+	controlToken  *brokerToken
+	metrics       *metrics.Collector
+	hardware      hwinfo.Identity
+	stats         linkStats
+	link          linkQuality
+	delivery      deliveryStats
+	quarantine    *quarantine
+	cardinality   *cardinality.Guard
+	units         *units.Processor
+	events        *eventDeduper
+	router        *routing.Router
+	labeler       *routing.Router // Matches records to label rules
+	codec         codec.Codec
+	batcher       *batcher
+	compressor    *compress.Compressor
+	sealer        *envelope.Sealer
+	signer        *signing.Signer
+	localAPI      *localapi.Server
+	audit         *audit.Log
+	egress        *egress.Policy
+	mqttProxy     transportProxy
+	decoders      *decoder.Registry
+	counters      *counter.Tracker
+	supervisor    *supervisor.Supervisor
+	reconnect     backoff.Policy
+	resources     *accounting.Ledger
+	tracer        *trace.Sampler
+	fallback      *httpFallback
+	broker        output.Sink
+	publisher     *publisher
+	outputs       *output.Set
+	export        *parquetExport
+	uploads       *uploads
+	notifications *notifications
+	drift         *driftMonitor
+	compliance    *complianceMonitor
+	flows         *flows.Table
+	speedtest     *speedtestMonitor
+	usage         *usageMonitor
+	portal        *portalMonitor
+	uplink        *uplinkMonitor
+	offPeak       *offPeakQueue
+	budget        *publishBudget
+	updates       *updater
+	gpio          *gpioOutputs
+	display       *displayMonitor
+	kiosk         *kioskMonitor
+	peripherals   *peripheralMonitor
+	backpressure  *backpressure
+	cloudThrottle *cloudThrottle
+	aclCheck      *aclChecker
+	acks          *ackTracker
+	sequence      *sequence.Sequencer
+	sequenceStats sequenceStats
+	logTailer     *logtail.Tailer
+	logArchive    *logarchive.Archive
+
+	// transport is the primary output: "mqtt", or "grpc" for the Edge Gateway
+	transport string
+
+	// Topic root of the organization, empty without one, and the publishes
+	// refused for lying outside it
+	tenantRoot       string
+	tenantViolations atomic.Int64
+
+	// Request IDs of device twin updates on IoT Hub
+	twinRequests atomic.Int64
+
+	// Dry-run collectors trace every record and publish nothing
+	dryRun bool
+
+	// publishCtx is cancelled on stop, ending retries of sinks
+	publishCtx  context.Context
+	stopPublish context.CancelFunc
+
+	// Bridge input handles while supervised; nil when inputs are unsupervised
+	bridgeMu      sync.Mutex
+	bridgeHandles map[string]*supervisor.Handle
+	stopInputs    context.CancelFunc
+	localBridge   *localBridge // Publishes of applications on a local broker
+	buffer        *offlineBuffer
+	quality       *quality.Annotator
+	qualityAlerts *quality.Alerts
+	deadband      *deadband.Filter
+	virtual       *virtualDevices
+	workloads     *workloads
+	suppressed    atomic.Int64
+	auditSent     int64
+	diagnostics   *diagnostics
+	startedAt     time.Time
+	stopCh        chan struct{}
+	restart       chan struct{}
+	wg            sync.WaitGroup
 }
 
 // TelemetryData represents data sent from edge to cloud
 type TelemetryData struct {
 	DeviceID  string                 `json:"device_id"`
 	Timestamp time.Time              `json:"timestamp"`
-	Type      string                 `json:"type"` // "metrics", "logs", "events"
+	Type      string                 `json:"type"` // "metrics", "logs", "events", "diagnostics"
 	Data      map[string]interface{} `json:"data"`
 	Tags      map[string]string      `json:"tags"`
+	Units     map[string]string      `json:"units,omitempty"`   // Unit per metric path pattern
+	Quality   map[string][]string    `json:"quality,omitempty"` // Quality flags per value path
+	// Org is the organization owning the device, when topics are isolated
+	// per organization
+	Org string `json:"org,omitempty"`
+	// MessageID is unique per record and kept on retransmission
+	MessageID string `json:"message_id,omitempty"`
+	// Sequence increases by one per record of the device, across restarts
+	Sequence uint64 `json:"sequence,omitempty"`
+	// BatchID names the message the record was sent in, the same on every
+	// send, when acks are exactly once
+	BatchID string `json:"batch_id,omitempty"`
+	// Dataset, Pipeline and RetentionClass route the record within the
+	// Ingestion Service
+	Dataset        string `json:"dataset,omitempty"`
+	Pipeline       string `json:"pipeline,omitempty"`
+	RetentionClass string `json:"retention_class,omitempty"`
+	// PII, Privacy and Retention label the record for storage policies
+	// downstream
+	PII       *bool  `json:"pii,omitempty"`
+	Privacy   string `json:"privacy,omitempty"`
+	Retention string `json:"retention,omitempty"`
 }
 
 // New creates a new edge collector instance
@@ -41,18 +192,406 @@ func New(cfg *config.Config, logger *logrus.Entry) (*Collector, error) {
 	opts.SetUsername(cfg.MQTT.Username)
 	opts.SetPassword(cfg.MQTT.Password)
 	opts.SetConnectTimeout(cfg.MQTT.Timeout)
+
+	tlsCfg, err := mqttTLSConfig(cfg.MQTT.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure MQTT TLS: %w", err)
+	}
+	opts.SetTLSConfig(tlsCfg)
+	if cfg.MQTT.TLS.InsecureSkipVerify {
+		logger.Warn("MQTT TLS certificate verification is disabled")
+	}
 	opts.SetKeepAlive(60 * time.Second)
+	opts.SetCleanSession(!cfg.MQTT.Session.Persistent)
+
 	opts.SetDefaultPublishHandler(func(client mqtt.Client, msg mqtt.Message) {
 		logger.WithFields(logrus.Fields{
 			"topic":   msg.Topic(),
 			"payload": string(msg.Payload()),
 		}).Debug("Received MQTT message")
 	})
+
+	paths := state.Resolve(cfg.State)
+
+	// Keep the messages in flight of a persistent session across restarts
+	sessionDir := ""
+	if cfg.MQTT.Session.Persistent && cfg.MQTT.Session.Store == "file" {
+		sessionDir = filepath.Join(paths.Dir, "mqtt-session")
+		if err := os.MkdirAll(sessionDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create MQTT session store: %w", err)
+		}
+		opts.SetStore(mqtt.NewFileStore(sessionDir))
+	}
+
+	seq, err := sequence.Open(filepath.Join(paths.Dir, "sequence.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message sequence: %w", err)
+	}
+
+	auditLog, err := audit.Open(paths.AuditFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	c := &Collector{
+		config:      cfg,
+		logger:      logger,
+		hardware:    hwinfo.Detect(),
+		startedAt:   time.Now(),
+		diagnostics: newDiagnostics(),
+		quarantine: &quarantine{
+			dir:      paths.QuarantineDir,
+			maxBytes: cfg.Validation.MaxQuarantineBytes,
+		},
+		events:     newEventDeduper(cfg.Collection.Events.DedupWindow),
+		audit:      auditLog,
+		sequence:   seq,
+		decoders:   decoder.NewRegistry(paths.DecoderDir, wasmLimits(cfg.Decoders.WASM)),
+		counters:   counter.NewTracker(),
+		supervisor: supervisor.New(supervisionPolicy(cfg.Supervision), logger),
+		stopCh:     make(chan struct{}),
+		restart:    make(chan struct{}, 1),
+	}
+	if cfg.Device.Org != "" {
+		if c.tenantRoot, err = cfg.TenantRoot(); err != nil {
+			return nil, fmt.Errorf("invalid topic template: %w", err)
+		}
+	}
+	c.broker = brokerSink{c}
+	c.publisher = newPublisher(cfg.MQTT.MaxInFlight)
+	c.publishCtx, c.stopPublish = context.WithCancel(context.Background())
+
+	if err := c.recordConfig(); err != nil {
+		return nil, fmt.Errorf("failed to record configuration in audit log: %w", err)
+	}
+
+	if err := c.decoders.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load decoders: %w", err)
+	}
+
+	if err := c.initPipeline(); err != nil {
+		return nil, err
+	}
+
+	// Counter totals and last published values of the previous run
+	c.restoreCheckpoint()
+
+	// Resource usage per input, processor and output
+	if cfg.Accounting.Enabled {
+		c.resources = accounting.New()
+	}
+
+	// Virtual devices computed from other inputs
+	if len(cfg.Virtual) > 0 {
+		if c.virtual, err = newVirtualDevices(cfg.Virtual); err != nil {
+			return nil, fmt.Errorf("failed to configure virtual devices: %w", err)
+		}
+	}
+
+	// Polling jobs shared across the collector group
+	if cfg.Workloads.Enabled {
+		c.workloads = c.newWorkloads(cfg.Workloads)
+	}
+
+	// Restrict outbound connections
+	if cfg.Egress.Enabled {
+		if c.egress, err = egress.New(cfg.Egress.Allowlist); err != nil {
+			return nil, fmt.Errorf("failed to create egress policy: %w", err)
+		}
+	}
+
+	// Reach the broker through a proxy
+	if c.mqttProxy, err = c.newTransportProxy(cfg.MQTT.Proxy); err != nil {
+		return nil, fmt.Errorf("failed to set up MQTT proxy: %w", err)
+	}
+
+	// Additional outputs beside the broker
+	c.outputs = output.NewSet(output.Env{
+		Dial:           c.dialOutput,
+		TLS:            mqttTLSConfig,
+		Retried:        c.delivery.retried,
+		Breaker:        cfg.Breaker,
+		BreakerChanged: c.breakerChanged,
+	})
+	if _, err := c.outputs.Sync(cfg.Outputs, output.FromConfig); err != nil {
+		return nil, fmt.Errorf("failed to start outputs: %w", err)
+	}
+
+	// Post telemetry over HTTPS while the broker is unreachable
+	if cfg.Fallback.Enabled {
+		if c.fallback, err = c.newFallback(cfg.Fallback); err != nil {
+			return nil, fmt.Errorf("failed to set up HTTPS fallback: %w", err)
+		}
+	}
+
+	// Parquet files for offline analysis
+	if cfg.Export.Enabled {
+		if c.export, err = c.newExport(cfg.Export); err != nil {
+			return nil, fmt.Errorf("failed to set up Parquet export: %w", err)
+		}
+	}
+
+	// Log files, optionally archived on local storage
+	if cfg.Collection.Logs.Enabled {
+		if c.logTailer, err = c.newLogTailer(cfg.Collection.Logs); err != nil {
+			return nil, fmt.Errorf("failed to set up log collection: %w", err)
+		}
+		if cfg.Collection.Logs.Archive.Enabled {
+			if c.logArchive, err = c.newLogArchive(cfg.Collection.Logs.Archive); err != nil {
+				return nil, fmt.Errorf("failed to open log archive: %w", err)
+			}
+		}
+	}
+
+	// Large artifacts go to object storage on request
+	if cfg.Uploads.Enabled {
+		if c.uploads, err = c.newUploads(cfg.Uploads); err != nil {
+			return nil, fmt.Errorf("failed to set up uploads: %w", err)
+		}
+	}
+
+	// Critical events reach operators through local gateways
+	if cfg.Notify.Enabled {
+		if c.notifications, err = c.newNotifications(cfg.Notify); err != nil {
+			return nil, fmt.Errorf("failed to set up notifications: %w", err)
+		}
+	}
+
+	// Configuration drift against the pushed baseline
+	if cfg.Drift.Enabled {
+		if c.drift, err = c.newDriftMonitor(); err != nil {
+			return nil, fmt.Errorf("failed to load drift baseline: %w", err)
+		}
+	}
+
+	// Compliance checks pushed by the cloud
+	if cfg.Compliance.Enabled {
+		if c.compliance, err = c.newComplianceMonitor(); err != nil {
+			return nil, fmt.Errorf("failed to load compliance profile: %w", err)
+		}
+	}
+
+	// Traffic summaries from packet headers
+	if cfg.Flows.Enabled {
+		c.flows = flows.NewTable()
+	}
+
+	// Scheduled link speed tests
+	if cfg.Speedtest.Enabled {
+		if c.speedtest, err = c.newSpeedtest(); err != nil {
+			return nil, fmt.Errorf("failed to set up speed tests: %w", err)
+		}
+	}
+
+	// Data usage accounting of metered interfaces
+	if cfg.DataUsage.Enabled {
+		if c.usage, err = c.newUsageMonitor(); err != nil {
+			return nil, fmt.Errorf("failed to load data usage: %w", err)
+		}
+	}
+
+	// Captive portal detection
+	if cfg.Portal.Enabled {
+		c.portal = c.newPortalMonitor()
+	}
+
+	// Active uplink tagging and failover events
+	if cfg.Uplink.Enabled {
+		c.uplink = c.newUplinkMonitor()
+	}
+
+	// Heavy data types wait for off-peak windows
+	if cfg.OffPeak.Enabled {
+		if c.offPeak, err = c.newOffPeakQueue(); err != nil {
+			return nil, fmt.Errorf("failed to set up off-peak windows: %w", err)
+		}
+	}
+
+	// Agent updates rolled out on the release channel
+	if cfg.Update.Enabled {
+		if c.updates, err = c.newUpdater(); err != nil {
+			return nil, fmt.Errorf("failed to set up updates: %w", err)
+		}
+	}
+
+	// Outputs switched on command and by local rules
+	if cfg.GPIO.Enabled {
+		if c.gpio, err = c.newGPIO(); err != nil {
+			return nil, fmt.Errorf("failed to set up GPIO outputs: %w", err)
+		}
+	}
+
+	// Displays of kiosks and signage
+	if cfg.Display.Enabled {
+		c.display = c.newDisplay()
+	}
+
+	// Records held until the ingestion service acknowledges them
+	if cfg.Acks.Enabled {
+		if c.acks, err = c.newAckTracker(); err != nil {
+			return nil, fmt.Errorf("failed to load records awaiting acks: %w", err)
+		}
+	}
+
+	// Health of a local kiosk application
+	if cfg.Kiosk.Enabled {
+		c.kiosk = c.newKiosk()
+	}
+
+	// Longer collection intervals while the outbound queue is long
+	if cfg.Backpressure.Enabled {
+		c.backpressure = newBackpressure()
+	}
+
+	// Slowdowns asked for by the ingestion service
+	if cfg.CloudThrottle.Enabled {
+		c.cloudThrottle = newCloudThrottle()
+	}
+
+	// Broker ACLs checked after connecting and on request
+	if cfg.ACLCheck.Enabled {
+		c.aclCheck = &aclChecker{}
+	}
+
+	// Printers and USB peripherals
+	if cfg.Peripherals.Enabled {
+		c.peripherals = c.newPeripherals()
+	}
+
+	// Keep records while the broker is unreachable
+	if cfg.Buffer.Enabled {
+		if c.buffer, err = c.newBuffer(); err != nil {
+			return nil, fmt.Errorf("failed to open buffer: %w", err)
+		}
+	}
+
+	// Republish what applications publish on a local broker
+	if cfg.Bridge.Local.Enabled {
+		c.localBridge = c.newLocalBridge()
+	}
+
+	// Publish rate and byte budgets
+	if cfg.Budget.Enabled {
+		if c.budget, err = c.newPublishBudget(); err != nil {
+			return nil, fmt.Errorf("failed to load publish budget: %w", err)
+		}
+	}
+
+	// Reconnect with a jittered exponential backoff. The 3.1.1 client retries
+	// immediately after a drop and backs off without jitter, so its own
+	// delays are disabled and the reconnecting handler, called before every
+	// attempt, waits instead. The MQTT 5 and gateway clients apply the policy
+	// themselves
+	c.reconnect = reconnectPolicy(cfg.MQTT.Reconnect)
+	waitReconnect := cfg.MQTT.Protocol != "5" && !cfg.Gateway.Enabled
+	if waitReconnect {
+		opts.SetMaxReconnectInterval(0)
+	}
 	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
 		logger.WithError(err).Error("MQTT connection lost")
+		c.link.disconnected()
+		c.checkPortal()
+		c.checkUplink()
 	})
 
-	mqttClient := mqtt.NewClient(opts)
+	// Track link quality across connects and reconnects
+	opts.SetCustomOpenConnectionFn(c.openConnection)
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		c.link.attempt()
+		return tlsCfg
+	})
+	opts.SetReconnectingHandler(func(client mqtt.Client, options *mqtt.ClientOptions) {
+		if waitReconnect {
+			// The lost handler runs concurrently and may not have marked
+			// the outage yet
+			c.link.disconnected()
+			c.waitReconnect(c.link.outageAttempts())
+			return
+		}
+		logger.Info("Reconnecting to MQTT broker")
+	})
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		reconnected := c.link.connected()
+		if r, ok := client.(interface{ SessionResumed() bool }); ok && reconnected && cfg.MQTT.Session.Persistent && !r.SessionResumed() {
+			logger.Warn("Broker did not resume the persistent session, messages queued while offline are lost")
+		}
+		if cfg.MQTT.EchoProbe {
+			c.subscribeEcho(client)
+		}
+		if c.control == nil {
+			c.subscribeControl(client)
+		}
+		c.subscribeBridge(client)
+		if cfg.MQTT.Status.Enabled {
+			c.sendStatus("online", "")
+		}
+		c.wakeBuffer()
+		if cfg.MQTT.Cloud.Provider == config.CloudAzureIoTHub && cfg.MQTT.Cloud.Azure.Twin {
+			c.reportTwin(client)
+		}
+	})
+
+	// Have the broker announce unexpected disconnects, on the status topic
+	// when there is one. A connection has a single will
+	switch {
+	case cfg.MQTT.Status.Enabled:
+		will, err := json.Marshal(c.offlineHeartbeat("connection_lost"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to build will message: %w", err)
+		}
+		opts.SetBinaryWill(c.getTopicName("status"), will, cfg.MQTT.Status.QoS, true)
+	case cfg.MQTT.Will.Enabled:
+		will, err := json.Marshal(c.offlineHeartbeat("connection_lost"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to build will message: %w", err)
+		}
+		qos, retained := cfg.MQTT.Delivery("heartbeat")
+		opts.SetBinaryWill(c.getTopicName("heartbeat"), will, qos, retained)
+	}
+
+	// Authenticate with an access token instead of a static password
+	if cfg.MQTT.Token.Enabled {
+		if c.mqttToken, err = c.newBrokerToken(cfg.MQTT.Token, cfg.MQTT.Username, "telemetry"); err != nil {
+			return nil, fmt.Errorf("failed to set up MQTT access token: %w", err)
+		}
+		opts.SetCredentialsProvider(c.credentials(c.mqttToken))
+	}
+
+	// Sign IoT Hub tokens with the device key
+	if a := cfg.MQTT.Cloud.Azure; cfg.MQTT.Cloud.Provider == config.CloudAzureIoTHub && (a.SharedAccessKey != "" || a.SharedAccessKeyFile != "") {
+		if c.mqttToken, err = c.newDeviceSAS(); err != nil {
+			return nil, fmt.Errorf("failed to set up IoT Hub SAS tokens: %w", err)
+		}
+		opts.SetCredentialsProvider(c.credentials(c.mqttToken))
+	}
+
+	c.transport = "mqtt"
+	switch {
+	case cfg.Gateway.Enabled:
+		if c.mqttClient, err = c.newGatewayClient(cfg.Gateway, opts); err != nil {
+			return nil, fmt.Errorf("failed to set up gRPC transport: %w", err)
+		}
+		c.transport = "grpc"
+	case cfg.MQTT.Protocol == "5":
+		c.mqttClient = mqtt5.NewClient(opts, mqtt5.Options{
+			TopicAliases:   cfg.MQTT.V5.TopicAliases,
+			UserProperties: userProperties(cfg),
+			SessionExpiry:  cfg.MQTT.Session.Expiry,
+			SessionDir:     sessionDir,
+			WillDelay:      cfg.MQTT.Will.Delay,
+
+			ReconnectBackoff: c.reconnect.Delay,
+		})
+	default:
+		c.mqttClient = mqtt.NewClient(opts)
+	}
+
+	// Commands and configuration on a connection with its own credentials
+	if cfg.MQTT.Control.Enabled {
+		if c.control, err = c.newControlClient(paths.Dir); err != nil {
+			return nil, fmt.Errorf("failed to set up MQTT control connection: %w", err)
+		}
+	}
 
 	// Create metrics collector
 	metricsCollector, err := metrics.New(logger)
@@ -60,49 +599,371 @@ func New(cfg *config.Config, logger *logrus.Entry) (*Collector, error) {
 		return nil, fmt.Errorf("failed to create metrics collector: %w", err)
 	}
 
-	return &Collector{
-		config:     cfg,
-		logger:     logger,
-		mqttClient: mqttClient,
-		metrics:    metricsCollector,
-		stopCh:     make(chan struct{}),
-	}, nil
+	c.metrics = metricsCollector
+
+	// Create local API server
+	if cfg.LocalAPI.Enabled {
+		if c.localAPI, err = localapi.New(cfg.LocalAPI, c, c.audit, logger); err != nil {
+			return nil, fmt.Errorf("failed to create local API: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// initPipeline creates the processors and payload protection that records
+// pass through before they are published
+func (c *Collector) initPipeline() error {
+	cfg := c.config
+	c.cardinality = cardinality.New(cfg.Cardinality.MaxSeries, cardinality.Policy(cfg.Cardinality.Policy))
+	c.units = units.NewProcessor(cfg.Units.Declare, unitRules(cfg.Units.Normalize))
+	rules, err := routingRules(cfg.Routing.Rules)
+	if err != nil {
+		return err
+	}
+	c.router = routing.New(rules)
+	labels, err := labelRules(cfg.Labels.Rules)
+	if err != nil {
+		return err
+	}
+	c.labeler = routing.New(labels)
+
+	// Annotate data quality
+	if cfg.Quality.Enabled {
+		c.quality = quality.New(qualityBounds(cfg.Quality.Bounds))
+		if cfg.Quality.Alerts {
+			c.qualityAlerts = quality.NewAlerts()
+		}
+	}
+
+	// Report by exception
+	if cfg.Exception.Enabled {
+		c.deadband = deadband.New(deadbandRules(cfg.Exception.Rules))
+	}
+
+	// Trace sampled records
+	if cfg.Tracing.Enabled {
+		c.tracer = trace.NewSampler(cfg.Tracing.SampleEvery, cfg.Tracing.Keep)
+	}
+
+	// Set up batching, payload encoding and compression
+	c.batcher = newBatcher(cfg.MQTT)
+	if c.codec, err = codec.New(cfg.Encoding); err != nil {
+		return err
+	}
+	if cfg.Compression.Algorithm != "none" {
+		compressor, err := compress.New(cfg.Compression.Algorithm, cfg.Compression.MinBytes, c.codec)
+		if err != nil {
+			return fmt.Errorf("failed to set up compression: %w", err)
+		}
+		c.compressor = compressor
+	}
+
+	// Set up payload signing
+	if cfg.Signing.Enabled {
+		key, err := signing.LoadPrivateKey(cfg.Signing.PrivateKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key: %w", err)
+		}
+		c.signer = signing.NewSigner(cfg.Signing.KeyID, key)
+	}
+
+	// Set up payload encryption
+	if cfg.Encryption.Enabled {
+		key, err := envelope.LoadKey(cfg.Encryption.KeyID, cfg.Encryption.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load encryption key: %w", err)
+		}
+		sealer, err := envelope.NewSealer(key, cfg.Encryption.DataKeyRotation)
+		if err != nil {
+			return fmt.Errorf("failed to set up encryption: %w", err)
+		}
+		c.sealer = sealer
+	}
+	return nil
 }
 
 // Start begins the collection and transmission of telemetry data
 func (c *Collector) Start(ctx context.Context) error {
 	c.logger.Info("Starting edge collector")
 
-	// Connect to MQTT broker
-	if token := c.mqttClient.Connect(); token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	if c.config.Power.Mode == "duty_cycle" {
+		return c.runDutyCycle(ctx)
+	}
+
+	// Bridge inputs subscribe once their supervisor starts them
+	c.bridgeMu.Lock()
+	c.bridgeHandles = make(map[string]*supervisor.Handle)
+	c.bridgeMu.Unlock()
+
+	// Connect to MQTT broker. With the HTTPS fallback the collector runs
+	// without it and keeps trying in the background
+	if c.fallback != nil {
+		c.wg.Add(1)
+		go c.fallbackLoop(ctx)
+	}
+	if c.export != nil {
+		c.wg.Add(1)
+		go c.exportLoop(ctx)
+	}
+	if c.notifications != nil {
+		c.wg.Add(1)
+		go c.notifyLoop(ctx)
+	}
+	if err := c.connect(); err != nil {
+		if c.fallback == nil {
+			return err
+		}
+		c.logger.WithError(err).Warn("Starting without MQTT, telemetry goes over HTTPS until the broker is reachable")
+		c.wg.Add(1)
+		go c.connectLoop(ctx)
+	} else {
+		c.startACLCheck(ctx)
 	}
-	c.logger.Info("Connected to MQTT broker")
 
 	// Send initial heartbeat
 	c.sendHeartbeat()
+	if c.updates != nil {
+		c.reportUpdateOutcome()
+	}
+	if c.virtual != nil {
+		c.sendVirtualHeartbeats(false)
+	}
+
+	// Start supervised inputs
+	inputCtx, stopInputs := context.WithCancel(ctx)
+	defer stopInputs()
+	c.stopInputs = stopInputs
 
-	// Start collection goroutines
 	if c.config.Collection.Metrics.Enabled {
+		c.supervisor.Go(inputCtx, &c.wg, supervisor.Input{
+			Name:          "metrics",
+			Run:           c.collectMetrics,
+			HealthTimeout: 3 * c.config.Collection.Interval,
+		})
+	}
+	if c.logTailer != nil {
+		c.supervisor.Go(inputCtx, &c.wg, supervisor.Input{
+			Name:          "logs",
+			Run:           c.collectLogs,
+			HealthTimeout: 3 * c.config.Collection.Interval,
+		})
+	}
+	if c.flows != nil {
+		for _, iface := range c.config.Flows.Interfaces {
+			c.supervisor.Go(inputCtx, &c.wg, supervisor.Input{
+				Name: "flows." + iface,
+				Run:  c.captureFlows(iface),
+			})
+		}
+	}
+	for _, input := range c.config.Bridge.Inputs {
+		c.supervisor.Go(inputCtx, &c.wg, supervisor.Input{
+			Name:          "bridge." + input.Name,
+			Run:           c.runBridgeInput(input),
+			HealthTimeout: input.HealthTimeout,
+		})
+	}
+	if c.localBridge != nil {
+		// Completes once connected; the client keeps retrying until then
+		c.localBridge.client.Connect()
+	}
+
+	if c.virtual != nil {
+		c.wg.Add(1)
+		go c.virtualLoop(ctx)
+	}
+	if c.config.Inventory.Enabled {
+		c.wg.Add(1)
+		go c.inventoryLoop(ctx)
+	}
+	if c.drift != nil {
+		c.wg.Add(1)
+		go c.driftLoop(ctx)
+	}
+	if c.compliance != nil {
+		c.wg.Add(1)
+		go c.complianceLoop(ctx)
+	}
+	if c.flows != nil {
+		c.wg.Add(1)
+		go c.flowsLoop(ctx)
+	}
+	if c.speedtest != nil {
+		c.wg.Add(1)
+		go c.speedtestLoop(ctx)
+	}
+	if c.usage != nil {
+		c.wg.Add(1)
+		go c.usageLoop(ctx)
+	}
+	if c.portal != nil {
+		c.wg.Add(1)
+		go c.portalLoop(ctx)
+	}
+	if c.uplink != nil {
+		c.wg.Add(1)
+		go c.uplinkLoop(ctx)
+	}
+	if c.offPeak != nil {
+		c.wg.Add(1)
+		go c.offPeakLoop(ctx)
+	}
+	if c.budget != nil {
+		c.wg.Add(1)
+		go c.budgetLoop(ctx)
+	}
+	if c.buffer != nil {
+		// Ends with the inputs; Stop drains what is left
+		c.wg.Add(1)
+		go c.bufferLoop(inputCtx)
+	}
+	if c.display != nil {
+		c.wg.Add(1)
+		go c.displayLoop(ctx)
+	}
+	if c.kiosk != nil {
+		c.wg.Add(1)
+		go c.kioskLoop(ctx)
+	}
+	if c.peripherals != nil {
+		c.wg.Add(1)
+		go c.peripheralsLoop(ctx)
+	}
+	if c.backpressure != nil {
+		c.wg.Add(1)
+		go c.backpressureLoop(ctx)
+	}
+	if c.acks != nil {
+		c.wg.Add(1)
+		go c.ackLoop(ctx)
+	}
+	if c.config.State.CheckpointInterval > 0 {
 		c.wg.Add(1)
-		go c.collectMetrics(ctx)
+		go c.checkpointLoop(ctx)
+	}
+	if c.mqttToken != nil {
+		c.wg.Add(1)
+		go c.tokenLoop(ctx, c.mqttToken, c.mqttClient)
+	}
+	if c.controlToken != nil {
+		c.wg.Add(1)
+		go c.tokenLoop(ctx, c.controlToken, c.control)
+	}
+
+	// Start local API
+	if c.localAPI != nil {
+		c.localAPI.Start()
 	}
 
 	// Start heartbeat goroutine
 	c.wg.Add(1)
 	go c.heartbeatLoop(ctx)
 
+	// Start diagnostics goroutine
+	if c.config.Diagnostics.Enabled {
+		c.wg.Add(1)
+		go c.diagnosticsLoop(ctx)
+	}
+
 	// Wait for context cancellation
 	<-ctx.Done()
 	return nil
 }
 
+// connect connects to the broker. Control topic handlers are registered
+// first, so messages the broker queued in a persistent session while the
+// collector was offline are handled as soon as the session resumes
+func (c *Collector) connect() error {
+	control := c.mqttClient
+	if c.control != nil {
+		control = c.control
+	}
+	c.routeControl(control)
+
+	token := c.mqttClient.Connect()
+	if token.Wait() && token.Error() != nil {
+		if c.transport == "grpc" {
+			return fmt.Errorf("failed to connect to Edge Gateway: %w", token.Error())
+		}
+		return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+
+	switch st, ok := token.(interface{ SessionPresent() bool }); {
+	case c.transport == "grpc":
+		c.logger.WithField("address", c.config.Gateway.Address).Info("Connected to Edge Gateway over gRPC")
+	case ok && st.SessionPresent():
+		c.logger.Info("Connected to MQTT broker, resumed persistent session")
+	default:
+		c.logger.Info("Connected to MQTT broker")
+	}
+
+	if c.control != nil {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.connectControl()
+		}()
+	}
+	return nil
+}
+
+// drain sends the records waiting in batches and, within shutdown.drain,
+// the backlog of the offline buffer, then waits for the acks of records not
+// yet acknowledged. Records left stay in a disk or SQLite buffer
+func (c *Collector) drain(ctx context.Context) {
+	c.flushBatches()
+	ctx, cancel := context.WithTimeout(ctx, c.config.Shutdown.Drain)
+	defer cancel()
+
+	start := time.Now()
+	held := false
+	var buffered int64
+	unacknowledged := 0
+	if c.buffer != nil && c.buffer.q.Len() > 0 {
+		held = true
+		c.drainBuffer(ctx)
+		buffered = c.buffer.q.Len()
+	}
+	if c.acks != nil && c.acks.Len() > 0 {
+		held = true
+		c.waitAcks(ctx)
+		unacknowledged = c.acks.Len()
+	}
+	if !held {
+		return
+	}
+	fields := logrus.Fields{
+		"buffered":       buffered,
+		"unacknowledged": unacknowledged,
+		"duration":       time.Since(start).Round(time.Millisecond),
+	}
+	if buffered > 0 || unacknowledged > 0 {
+		c.logger.WithFields(fields).Warn("Stopped draining with records left")
+		return
+	}
+	c.logger.WithFields(fields).Info("Drained held records")
+}
+
 // Stop gracefully stops the collector
 func (c *Collector) Stop(ctx context.Context) error {
 	c.logger.Info("Stopping edge collector")
 
 	// Signal all goroutines to stop
 	close(c.stopCh)
+	c.stopPublish()
+	if c.stopInputs != nil {
+		c.stopInputs()
+	}
+	if c.workloads != nil {
+		c.workloads.cancel()
+	}
+	if c.uploads != nil {
+		c.uploads.cancel()
+	}
+	if c.updates != nil {
+		c.updates.cancel()
+	}
 
 	// Wait for goroutines to finish with timeout
 	done := make(chan struct{})
@@ -118,66 +979,360 @@ func (c *Collector) Stop(ctx context.Context) error {
 		c.logger.Warn("Shutdown timeout reached")
 	}
 
+	if c.localAPI != nil {
+		if err := c.localAPI.Shutdown(ctx); err != nil {
+			c.logger.WithError(err).Warn("Failed to stop local API")
+		}
+	}
+
+	// No more local publishes once the batches are flushed below
+	if c.localBridge != nil {
+		c.localBridge.client.Disconnect(250)
+	}
+
+	// Finish power cycles in progress while their events can still be sent
+	if c.gpio != nil {
+		c.gpio.ctl.Close()
+	}
+
+	// Summaries of deduplicated events are held until their window closes
+	c.events.close(c.sendEventSummary)
+
+	// Send what is still held before the connection closes
+	c.drain(ctx)
+
 	// Disconnect from MQTT
 	if c.mqttClient.IsConnected() {
+		if c.virtual != nil {
+			c.sendVirtualHeartbeats(true)
+		}
+		if c.config.MQTT.Will.Enabled {
+			c.sendOfflineHeartbeat()
+		}
+		if c.config.MQTT.Status.Enabled {
+			c.sendStatus("offline", "shutdown")
+		}
+		c.waitPublishes(ctx)
 		c.mqttClient.Disconnect(1000)
 		c.logger.Info("Disconnected from MQTT broker")
 	}
+	if c.control != nil && c.control.IsConnected() {
+		c.control.Disconnect(250)
+	}
+	if c.buffer != nil {
+		if err := c.buffer.backlog.putBack(c.buffer.q); err != nil {
+			c.logger.WithError(err).Warn("Failed to keep downsampled records in buffer")
+		}
+		if err := c.buffer.q.Close(); err != nil {
+			c.logger.WithError(err).Warn("Failed to close buffer")
+		}
+	}
+
+	if err := c.outputs.Close(); err != nil {
+		c.logger.WithError(err).Warn("Failed to close outputs")
+	}
+	if c.logArchive != nil {
+		if err := c.logArchive.Close(); err != nil {
+			c.logger.WithError(err).Warn("Failed to seal log archive segment")
+		}
+	}
+	if err := c.metrics.Close(); err != nil {
+		c.logger.WithError(err).Warn("Failed to detach eBPF programs")
+	}
+	if err := c.decoders.Close(); err != nil {
+		c.logger.WithError(err).Warn("Failed to release WASM decoders")
+	}
+	if c.sequence != nil {
+		if err := c.sequence.Close(); err != nil {
+			c.logger.WithError(err).Warn("Failed to save message sequence")
+		}
+	}
+	if c.counters != nil {
+		if err := c.saveCheckpoint(); err != nil {
+			c.logger.WithError(err).Warn("Failed to save collector state")
+		}
+	}
+	if c.acks != nil {
+		if err := c.acks.save(); err != nil {
+			c.logger.WithError(err).Warn("Failed to save records awaiting acks")
+		}
+	}
+	if c.budget != nil {
+		if err := c.budget.budget.Save(); err != nil {
+			c.logger.WithError(err).Warn("Failed to save publish budget")
+		}
+	}
 
 	return nil
 }
 
 // collectMetrics periodically collects and sends system metrics
-func (c *Collector) collectMetrics(ctx context.Context) {
-	defer c.wg.Done()
-
-	ticker := time.NewTicker(c.config.Collection.Interval)
+func (c *Collector) collectMetrics(ctx context.Context, h *supervisor.Handle) error {
+	interval := c.collectionInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			c.gatherAndSendMetrics()
-		case <-c.stopCh:
-			return
+			if err := c.gatherAndSendMetrics(); err != nil {
+				h.Fail(err)
+			} else {
+				h.OK()
+			}
+			interval = c.adaptTicker(ticker, interval)
 		case <-ctx.Done():
-			return
+			return nil
 		}
 	}
 }
 
-// gatherAndSendMetrics collects system metrics and sends them via MQTT
-func (c *Collector) gatherAndSendMetrics() {
+// gatherAndSendMetrics collects system metrics and sends them via MQTT. It
+// returns an error only when collection failed entirely
+func (c *Collector) gatherAndSendMetrics() error {
+	telemetry, tr, err := c.gatherMetrics()
+	if err != nil {
+		return err
+	}
+	if err := c.sendTraced("metrics", telemetry, tr); err != nil {
+		c.logger.WithError(err).Error("Failed to send metrics")
+	}
+	return nil
+}
+
+// gatherMetrics collects system metrics into a record ready to send
+func (c *Collector) gatherMetrics() (TelemetryData, *trace.Trace, error) {
+	span := c.resources.Start("input.metrics")
 	metricsData, err := c.metrics.Collect(c.config.Collection.Metrics)
 	if err != nil {
+		span.End(0)
 		c.logger.WithError(err).Error("Failed to collect metrics")
-		return
+		c.reportError("metrics", err)
+		return TelemetryData{}, nil, err
+	}
+	failures := c.metrics.Failures()
+	for input, msg := range failures {
+		c.reportError("metrics."+input, errors.New(msg))
 	}
 
-	telemetry := TelemetryData{
+	telemetry := c.newTelemetry("metrics", metricsData)
+	tr := c.tracer.Sample("metrics", telemetry.DeviceID)
+	c.prepareMetrics(&telemetry, tr)
+	if c.quality != nil && len(failures) > 0 {
+		telemetry.Quality = make(map[string][]string, len(failures))
+		for input := range failures {
+			telemetry.Quality[input] = []string{quality.SensorFault}
+		}
+	}
+	span.End(1)
+	return telemetry, tr, nil
+}
+
+// newTelemetry wraps collected data in a telemetry envelope
+func (c *Collector) newTelemetry(dataType string, data map[string]interface{}) TelemetryData {
+	return TelemetryData{
 		DeviceID:  c.config.Device.ID,
 		Timestamp: time.Now().UTC(),
-		Type:      "metrics",
-		Data:      metricsData,
-		Tags:      c.config.Device.Tags,
+		Type:      dataType,
+		Data:      data,
+		Tags:      c.telemetryTags(),
 	}
+}
 
-	if err := c.sendTelemetry("metrics", telemetry); err != nil {
-		c.logger.WithError(err).Error("Failed to send metrics")
+// tagIngestion sets the ingestion metadata of a record: the defaults,
+// overridden per data type, with placeholders expanded for its device
+func (c *Collector) tagIngestion(dataType string, telemetry *TelemetryData) {
+	cfg := c.config.Ingestion
+	r := cfg.IngestionRouting
+	if t, ok := cfg.Types[dataType]; ok {
+		r.Dataset = cmp.Or(t.Dataset, r.Dataset)
+		r.Pipeline = cmp.Or(t.Pipeline, r.Pipeline)
+		r.RetentionClass = cmp.Or(t.RetentionClass, r.RetentionClass)
+	}
+	if r == (config.IngestionRouting{}) {
+		return
+	}
+	lookup := c.topicLookup(telemetry.DeviceID, dataType)
+	telemetry.Dataset = topics.Expand(r.Dataset, lookup)
+	telemetry.Pipeline = topics.Expand(r.Pipeline, lookup)
+	telemetry.RetentionClass = topics.Expand(r.RetentionClass, lookup)
+}
+
+// labelRecord sets the privacy and retention labels of a record: those of
+// its data type, overridden by the first label rule it matches
+func (c *Collector) labelRecord(dataType string, telemetry *TelemetryData, tr *trace.Trace) {
+	cfg := c.config.Labels
+	l := cfg.Types[dataType]
+	rule, ok := c.labeler.Match(dataType, telemetry.Tags, telemetry.Data)
+	if ok {
+		i := slices.IndexFunc(cfg.Rules, func(r config.LabelRule) bool { return r.Name == rule.Name })
+		r := cfg.Rules[i].RecordLabels
+		if r.PII != nil {
+			l.PII = r.PII
+		}
+		l.Privacy = cmp.Or(r.Privacy, l.Privacy)
+		l.Retention = cmp.Or(r.Retention, l.Retention)
+	}
+	if l == (config.RecordLabels{}) {
+		return
+	}
+	telemetry.PII, telemetry.Privacy, telemetry.Retention = l.PII, l.Privacy, l.Retention
+	if ok {
+		tr.Step("labels", trace.Modified, "rule "+rule.Name)
+	} else {
+		tr.Step("labels", trace.Modified, "labels of "+dataType)
+	}
+}
+
+// userProperties returns the MQTT 5 user properties attached to every
+// publish: device metadata, which configured properties may override
+func userProperties(cfg *config.Config) map[string]string {
+	props := map[string]string{
+		"device_id":     cfg.Device.ID,
+		"device_name":   cfg.Device.Name,
+		"location":      cfg.Device.Location,
+		"agent_version": Version,
+	}
+	for key, value := range cfg.MQTT.V5.UserProperties {
+		props[key] = value
+	}
+	return props
+}
+
+// supervisionPolicy converts the configured input restart policy
+func supervisionPolicy(cfg config.SupervisionConfig) supervisor.Policy {
+	return supervisor.Policy{
+		InitialBackoff:   cfg.InitialBackoff,
+		MaxBackoff:       cfg.MaxBackoff,
+		MaxRestarts:      cfg.MaxRestarts,
+		Window:           cfg.Window,
+		Quarantine:       cfg.Quarantine,
+		FailureThreshold: cfg.FailureThreshold,
+	}
+}
+
+// reconnectPolicy converts the reconnect configuration
+func reconnectPolicy(cfg config.ReconnectConfig) backoff.Policy {
+	return backoff.Policy{
+		Initial:    cfg.InitialInterval,
+		Max:        cfg.MaxInterval,
+		Multiplier: cfg.Multiplier,
+		Jitter:     cfg.Jitter,
+	}
+}
+
+// unitRules converts configured normalization rules
+func unitRules(cfg []config.UnitRule) []units.Rule {
+	rules := make([]units.Rule, len(cfg))
+	for i, r := range cfg {
+		rules[i] = units.Rule{Path: r.Path, From: r.From, To: r.To}
+	}
+	return rules
+}
+
+// wasmLimits converts the limits of WASM decoders
+func wasmLimits(cfg config.WASMDecodersConfig) decoder.WASMLimits {
+	return decoder.WASMLimits{MaxMemory: cfg.MaxMemory, Timeout: cfg.Timeout}
+}
+
+// routingRules converts configured routing rules, parsing their expressions
+func routingRules(cfg []config.RouteRule) ([]routing.Rule, error) {
+	rules := make([]routing.Rule, len(cfg))
+	for i, r := range cfg {
+		when, err := matchExpression(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("routing rule %s: %w", r.Name, err)
+		}
+		rules[i] = routing.Rule{
+			Name:     r.Name,
+			Type:     r.Match.Type,
+			Tags:     r.Match.Tags,
+			Fields:   r.Match.Fields,
+			When:     when,
+			Topic:    r.Topic,
+			QoS:      r.QoS,
+			Retained: r.Retained,
+			Outputs:  r.Outputs,
+		}
+	}
+	return rules, nil
+}
+
+// labelRules converts the label rules for matching; the labels are looked
+// up by rule name
+func labelRules(cfg []config.LabelRule) ([]routing.Rule, error) {
+	rules := make([]routing.Rule, len(cfg))
+	for i, r := range cfg {
+		when, err := matchExpression(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("label rule %s: %w", r.Name, err)
+		}
+		rules[i] = routing.Rule{
+			Name:   r.Name,
+			Type:   r.Match.Type,
+			Tags:   r.Match.Tags,
+			Fields: r.Match.Fields,
+			When:   when,
+		}
 	}
+	return rules, nil
+}
+
+// matchExpression parses the expression of a rule, nil without one
+func matchExpression(m config.RouteMatch) (*expr.Expr, error) {
+	if m.Expression == "" {
+		return nil, nil
+	}
+	return expr.Parse(m.Expression)
+}
+
+// qualityBounds converts configured quality bounds
+func qualityBounds(cfg []config.QualityBound) []quality.Bound {
+	bounds := make([]quality.Bound, len(cfg))
+	for i, b := range cfg {
+		bounds[i] = quality.Bound{
+			Type:       b.Type,
+			Path:       b.Path,
+			Min:        b.Min,
+			Max:        b.Max,
+			StaleAfter: b.StaleAfter,
+		}
+	}
+	return bounds
+}
+
+// deadbandRules converts configured report-by-exception rules
+func deadbandRules(cfg []config.DeadbandRule) []deadband.Rule {
+	rules := make([]deadband.Rule, len(cfg))
+	for i, r := range cfg {
+		rules[i] = deadband.Rule{
+			Type:        r.Type,
+			Path:        r.Path,
+			Absolute:    r.Absolute,
+			Percent:     r.Percent,
+			MaxInterval: r.MaxInterval,
+		}
+	}
+	return rules
 }
 
 // heartbeatLoop sends periodic heartbeats
 func (c *Collector) heartbeatLoop(ctx context.Context) {
 	defer c.wg.Done()
 
-	ticker := time.NewTicker(60 * time.Second) // Heartbeat every minute
+	ticker := time.NewTicker(c.config.Heartbeat.Interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
+			if c.config.MQTT.EchoProbe {
+				c.sendEchoProbe()
+			}
 			c.sendHeartbeat()
+			if c.virtual != nil {
+				c.sendVirtualHeartbeats(false)
+			}
 		case <-c.stopCh:
 			return
 		case <-ctx.Done():
@@ -188,70 +1343,398 @@ func (c *Collector) heartbeatLoop(ctx context.Context) {
 
 // sendHeartbeat sends a heartbeat message
 func (c *Collector) sendHeartbeat() {
+	heartbeat := c.heartbeat()
+
+	data, err := json.Marshal(heartbeat)
+	if err != nil {
+		c.logger.WithError(err).Error("Failed to marshal heartbeat")
+		return
+	}
+
+	route := routing.Route{Topic: c.getTopicName("heartbeat")}
+	route.QoS, route.Retained = c.config.MQTT.Delivery("heartbeat")
+	if err := c.publishRecord(c.output(), "heartbeat", c.config.Device.ID, route, data, len(data), nil); err != nil && !errors.Is(err, errOverBudget) {
+		c.logger.WithError(err).Error("Failed to send heartbeat")
+	}
+}
+
+// heartbeat builds the heartbeat payload
+func (c *Collector) heartbeat() map[string]interface{} {
 	heartbeat := map[string]interface{}{
 		"device_id":   c.config.Device.ID,
 		"device_name": c.config.Device.Name,
 		"location":    c.config.Device.Location,
 		"timestamp":   time.Now().UTC().Unix(),
-		"status":      "online",
-		"version":     "0.1.0",
+		"status":      c.status(),
+		"version":     Version,
+		"hardware":    c.hardware.Map(),
+		"fips":        fips.Enabled,
+	}
+	if org := c.config.Device.Org; org != "" {
+		heartbeat["org"] = org
 	}
 
+	c.addOperationalState(heartbeat)
+	return heartbeat
+}
+
+// offlineHeartbeat builds the heartbeat announcing the collector went
+// offline. As the will message it is published by the broker at an unknown
+// time, so it carries no timestamp
+func (c *Collector) offlineHeartbeat(reason string) map[string]interface{} {
+	heartbeat := map[string]interface{}{
+		"device_id":   c.config.Device.ID,
+		"device_name": c.config.Device.Name,
+		"location":    c.config.Device.Location,
+		"status":      "offline",
+		"reason":      reason,
+		"version":     Version,
+	}
+	if org := c.config.Device.Org; org != "" {
+		heartbeat["org"] = org
+	}
+	return heartbeat
+}
+
+// sendOfflineHeartbeat announces a graceful shutdown, which the broker
+// does not report with the will message
+func (c *Collector) sendOfflineHeartbeat() {
+	heartbeat := c.offlineHeartbeat("shutdown")
+	heartbeat["timestamp"] = time.Now().UTC().Unix()
+
 	data, err := json.Marshal(heartbeat)
 	if err != nil {
-		c.logger.WithError(err).Error("Failed to marshal heartbeat")
 		return
 	}
+	qos, retained := c.config.MQTT.Delivery("heartbeat")
+	if err := c.publish(c.getTopicName("heartbeat"), qos, retained, data); err != nil {
+		c.logger.WithError(err).Warn("Failed to send offline heartbeat")
+	}
+}
 
-	topic := c.getTopicName("heartbeat")
-	token := c.mqttClient.Publish(topic, c.config.MQTT.QoS, c.config.MQTT.Retained, data)
-	if token.Wait() && token.Error() != nil {
-		c.logger.WithError(token.Error()).Error("Failed to send heartbeat")
+// sendStatus replaces the retained message of the status topic. Online
+// messages carry what a dashboard shows before the first heartbeat; offline
+// ones match the will
+func (c *Collector) sendStatus(status, reason string) {
+	msg := c.offlineHeartbeat(reason)
+	msg["status"] = status
+	msg["timestamp"] = time.Now().UTC().Unix()
+	if status == "online" {
+		delete(msg, "reason")
+		msg["started_at"] = c.startedAt.UTC().Unix()
+		msg["ip_addresses"] = localAddresses()
+		msg["config_hash"] = c.config.Hash()
+		msg["transport"] = c.transport
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	if err := c.publish(c.getTopicName("status"), c.config.MQTT.Status.QoS, true, data); err != nil {
+		c.logger.WithError(err).WithField("status", status).Warn("Failed to send device status")
 	}
 }
 
-// sendTelemetry sends telemetry data via MQTT
+// sendTelemetry sends telemetry data to the broker and every output
 func (c *Collector) sendTelemetry(dataType string, telemetry TelemetryData) error {
-	data, err := json.Marshal(telemetry)
+	return c.sendTraced(dataType, telemetry, c.tracer.Sample(dataType, telemetry.DeviceID))
+}
+
+// sendTraced runs a record through the processors and outputs, recording
+// each stage in tr when the record is sampled
+func (c *Collector) sendTraced(dataType string, telemetry TelemetryData, tr *trace.Trace) error {
+	defer c.finishTrace(tr, &telemetry)
+
+	// Held records run the whole pipeline once released
+	if c.holdOffPeak(dataType, telemetry, tr) {
+		return nil
+	}
+
+	if c.virtual != nil && telemetry.DeviceID == c.config.Device.ID {
+		c.virtual.observe(dataType, telemetry.Data, telemetry.Timestamp)
+		tr.Step("virtual", trace.Passed, "observed by virtual devices")
+	}
+
+	if c.throttle(dataType) {
+		tr.Step("throttle", trace.Dropped, "data quota nearly used")
+		return nil
+	}
+
+	span := c.resources.Start("processor.cardinality")
+	tagsOK := c.cardinality.ApplyTags(dataType, telemetry.Tags)
+	span.End(1)
+	if !tagsOK {
+		c.logger.WithField("type", dataType).Warn("Tag combination limit reached, dropping tags")
+		c.reportError("cardinality.tags."+dataType, fmt.Errorf("limit of %d tag combinations reached", c.config.Cardinality.MaxSeries))
+		telemetry.Tags = nil
+		tr.Step("cardinality", trace.Modified, "tag combination limit reached, tags dropped")
+	} else {
+		tr.Step("cardinality", trace.Passed, "")
+	}
+
+	if c.deadband != nil {
+		scope, _ := telemetry.Data["source"].(string)
+		span := c.resources.Start("processor.deadband")
+		changed := c.deadband.Apply(dataType, scope, telemetry.Data, telemetry.Timestamp)
+		if !changed {
+			span.End(0)
+			c.suppressed.Add(1)
+			tr.Step("deadband", trace.Dropped, "no value moved past its deadband")
+			return nil
+		}
+		span.End(1)
+		tr.Step("deadband", trace.Passed, "")
+	}
+
+	if c.quality != nil {
+		span := c.resources.Start("processor.quality")
+		telemetry.Quality = c.quality.Annotate(dataType, telemetry.Data, telemetry.Timestamp, telemetry.Quality)
+		span.End(1)
+		if len(telemetry.Quality) > 0 {
+			tr.Step("quality", trace.Modified, fmt.Sprintf("flags on %d paths", len(telemetry.Quality)))
+		} else {
+			tr.Step("quality", trace.Passed, "")
+		}
+		if c.qualityAlerts != nil {
+			c.sendQualityAlerts(c.qualityAlerts.Observe(dataType, telemetry.Data, telemetry.Quality))
+		}
+	}
+
+	if c.config.Validation.Enabled {
+		span := c.resources.Start("processor.validation")
+		err := c.validateTelemetry(telemetry)
+		if err != nil {
+			span.End(0)
+			tr.Step("validation", trace.Dropped, err.Error())
+			c.reportError("validation."+dataType, err)
+			c.reportDropped(dataType)
+			if !c.dryRun {
+				if qErr := c.quarantine.store(telemetry, err); qErr != nil {
+					c.logger.WithError(qErr).Warn("Failed to quarantine invalid telemetry")
+				}
+			}
+			return fmt.Errorf("invalid telemetry: %w", err)
+		}
+		span.End(1)
+		tr.Step("validation", trace.Passed, "")
+	}
+
+	// Records always carry the organization of the collector
+	telemetry.Org = c.config.Device.Org
+	c.tagIngestion(dataType, &telemetry)
+	c.labelRecord(dataType, &telemetry, tr)
+	c.stampRecord(&telemetry, tr)
+	c.exportRecord(dataType, telemetry, tr)
+
+	route := c.route(dataType, telemetry)
+	if batch := c.config.MQTT.Batching(dataType); c.batcher != nil && batch.Size > 0 {
+		return c.batchRecord(dataType, telemetry, route, batch, tr)
+	}
+	if c.exactlyOnce(dataType) {
+		telemetry.BatchID = batchID(telemetry.DeviceID, telemetry.Sequence, telemetry.Sequence)
+	}
+
+	// The publish itself waits on the broker and is not measured
+	output := c.output()
+	span = c.resources.Start("output." + output)
+	data, raw, err := c.encodeTelemetry(dataType, telemetry, tr)
+	span.End(0)
 	if err != nil {
-		return fmt.Errorf("failed to marshal telemetry: %w", err)
+		c.sequenceGap(1)
+		return err
 	}
 
-	topic := c.getTopicName(dataType)
-	token := c.mqttClient.Publish(topic, c.config.MQTT.QoS, c.config.MQTT.Retained, data)
-	if token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to publish to MQTT: %w", token.Error())
+	rule := route.Rule
+	if rule == "" {
+		rule = "default"
 	}
+	tr.Step("routing", trace.Passed, "rule "+rule)
+	tr.Deliver(route.Topic, route.QoS, route.Retained, len(data))
+
+	if err := c.publishRecord(output, dataType, telemetry.DeviceID, route, data, raw, tr); err != nil {
+		c.sequenceGap(1)
+		if errors.Is(err, errOverBudget) {
+			return nil
+		}
+		return fmt.Errorf("failed to publish to MQTT: %w", err)
+	}
+	if c.dryRun {
+		return nil
+	}
+	c.trackAck(dataType, telemetry.DeviceID, route, data, []string{cmp.Or(telemetry.BatchID, telemetry.MessageID)})
 
 	c.logger.WithFields(logrus.Fields{
-		"topic": topic,
+		"topic": route.Topic,
 		"size":  len(data),
 		"type":  dataType,
+		"rule":  route.Rule,
 	}).Debug("Sent telemetry data")
 
 	return nil
 }
 
+// encodeTelemetry encodes a record, or a batch of records, and applies
+// compression, encryption and signing. raw is the encoded size before
+// compression, for the compression ratio of the outputs
+func (c *Collector) encodeTelemetry(dataType string, telemetry interface{}, tr *trace.Trace) (data []byte, raw int, err error) {
+	data, err = c.codec.Marshal(telemetry)
+	if err != nil {
+		tr.Step("encode", trace.Failed, err.Error())
+		c.reportError("encode."+dataType, err)
+		c.reportDropped(dataType)
+		return nil, 0, fmt.Errorf("failed to encode telemetry: %w", err)
+	}
+	raw = len(data)
+
+	if c.compressor != nil {
+		compressed, ok, err := c.compressor.Compress(data)
+		switch {
+		case err != nil:
+			tr.Step("compress", trace.Failed, err.Error())
+			c.reportError("compress."+dataType, err)
+			c.reportDropped(dataType)
+			return nil, 0, fmt.Errorf("failed to compress telemetry: %w", err)
+		case ok:
+			tr.Step("compress", trace.Modified, fmt.Sprintf("%s, %d to %d bytes", c.compressor.Algorithm(), len(data), len(compressed)))
+			data = compressed
+		default:
+			tr.Step("compress", trace.Passed, "sent uncompressed")
+		}
+	}
+
+	if c.sealer != nil {
+		if data, err = c.sealer.Seal(data); err != nil {
+			tr.Step("encrypt", trace.Failed, err.Error())
+			c.reportError("encrypt."+dataType, err)
+			c.reportDropped(dataType)
+			return nil, 0, fmt.Errorf("failed to encrypt telemetry: %w", err)
+		}
+		tr.Step("encrypt", trace.Modified, "sealed with key "+c.config.Encryption.KeyID)
+	}
+
+	if c.signer != nil {
+		if data, err = c.signer.Sign(data); err != nil {
+			tr.Step("sign", trace.Failed, err.Error())
+			c.reportError("sign."+dataType, err)
+			c.reportDropped(dataType)
+			return nil, 0, fmt.Errorf("failed to sign telemetry: %w", err)
+		}
+		tr.Step("sign", trace.Modified, "signed with key "+c.config.Signing.KeyID)
+	}
+	return data, raw, nil
+}
+
+// route selects the topic and delivery settings for a record, applying the
+// first matching routing rule over the defaults
+func (c *Collector) route(dataType string, telemetry TelemetryData) routing.Route {
+	def := routing.Route{Topic: c.deviceTopic(telemetry.DeviceID, dataType)}
+	def.QoS, def.Retained = c.config.MQTT.Delivery(dataType)
+
+	rule, ok := c.router.Match(dataType, telemetry.Tags, telemetry.Data)
+	if !ok {
+		return def
+	}
+
+	route := rule.Apply(def)
+	route.Topic = c.expandDeviceTopic(route.Topic, telemetry.DeviceID, dataType)
+	return route
+}
+
+// expandTopic substitutes {prefix}, {device_id} and {type} in a topic
+func (c *Collector) expandTopic(topic, dataType string) string {
+	return c.expandDeviceTopic(topic, c.config.Device.ID, dataType)
+}
+
+// expandDeviceTopic substitutes placeholders for the given device: the
+// device fields and tags of the topic template
+func (c *Collector) expandDeviceTopic(topic, deviceID, dataType string) string {
+	return topics.Expand(topic, c.topicLookup(deviceID, dataType))
+}
+
+// topicLookup returns the placeholder values of a device for one data type.
+// Names and tags are made safe as topic levels
+func (c *Collector) topicLookup(deviceID, dataType string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		switch name {
+		case "prefix":
+			return c.config.MQTT.Topics.Prefix, true
+		case "device_id":
+			return deviceID, true
+		case "type":
+			return dataType, true
+		case "topic":
+			return c.topicName(dataType), true
+		case "org":
+			if org := c.config.Device.Org; org != "" {
+				return org, true
+			}
+		}
+
+		dev := c.config.Device
+		if deviceID != dev.ID {
+			for _, v := range c.config.Virtual {
+				if v.ID == deviceID {
+					dev = config.DeviceConfig{ID: v.ID, Name: v.Name, Location: v.Location, Tags: v.Tags}
+					break
+				}
+			}
+		}
+		switch name {
+		case "device_name":
+			return topics.Level(dev.Name), true
+		case "location":
+			return topics.Level(dev.Location), true
+		}
+		value, ok := dev.Tags[name]
+		return topics.Level(value), ok
+	}
+}
+
 // getTopicName constructs MQTT topic name
 func (c *Collector) getTopicName(dataType string) string {
-	var topicSuffix string
+	return c.deviceTopic(c.config.Device.ID, dataType)
+}
+
+// deviceTopic returns the topic for a data type of the given device
+func (c *Collector) deviceTopic(deviceID, dataType string) string {
+	return c.expandDeviceTopic(c.config.MQTT.Topics.Template, deviceID, dataType)
+}
+
+// topicName returns the topic level configured for a data type, by default
+// the type itself
+func (c *Collector) topicName(dataType string) string {
 	switch dataType {
 	case "metrics":
-		topicSuffix = c.config.MQTT.Topics.Metrics
+		return c.config.MQTT.Topics.Metrics
 	case "logs":
-		topicSuffix = c.config.MQTT.Topics.Logs
+		return c.config.MQTT.Topics.Logs
 	case "events":
-		topicSuffix = c.config.MQTT.Topics.Events
+		return c.config.MQTT.Topics.Events
 	case "heartbeat":
-		topicSuffix = c.config.MQTT.Topics.Heartbeat
-	default:
-		topicSuffix = dataType
+		return c.config.MQTT.Topics.Heartbeat
+	case "diagnostics":
+		return c.config.MQTT.Topics.Diagnostics
+	case "echo":
+		return c.config.MQTT.Topics.Echo
+	case "status":
+		return c.config.MQTT.Topics.Status
+	case "sensors":
+		return c.config.MQTT.Topics.Sensors
+	case "polls":
+		return c.config.MQTT.Topics.Polls
+	case "inventory":
+		return c.config.MQTT.Topics.Inventory
+	case "compliance":
+		return c.config.MQTT.Topics.Compliance
+	case "flows":
+		return c.config.MQTT.Topics.Flows
+	case "speedtest":
+		return c.config.MQTT.Topics.Speedtest
+	case "usage":
+		return c.config.MQTT.Topics.Usage
+	case "display":
+		return c.config.MQTT.Topics.Display
 	}
-
-	return fmt.Sprintf("%s/%s/%s/%s",
-		c.config.MQTT.Topics.Prefix,
-		c.config.Device.ID,
-		topicSuffix,
-		dataType,
-	)
-}
\ No newline at end of file
+	return dataType
+}
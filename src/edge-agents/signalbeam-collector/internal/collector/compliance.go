@@ -0,0 +1,223 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/compliance"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+// complianceMonitor runs the check profile pushed by the cloud
+type complianceMonitor struct {
+	path    string
+	runner  *compliance.Runner
+	trigger chan struct{}
+
+	mu      sync.Mutex
+	raw     []byte
+	profile *compliance.Profile
+	counts  map[string]int // Results of the last run by status
+	lastRun time.Time
+	runs    int64
+}
+
+// newComplianceMonitor loads the profile kept from the last push, so checks
+// run before the broker redelivers it
+func (c *Collector) newComplianceMonitor() (*complianceMonitor, error) {
+	cfg := c.config.Compliance
+	m := &complianceMonitor{
+		path:    filepath.Join(state.Resolve(c.config.State).Dir, "compliance-profile"),
+		runner:  &compliance.Runner{AllowCommands: cfg.AllowCommands, Timeout: cfg.CommandTimeout},
+		trigger: make(chan struct{}, 1),
+	}
+
+	raw, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.profile, err = compliance.Parse(raw); err != nil {
+		return nil, err
+	}
+	m.raw = raw
+	return m, nil
+}
+
+// Map returns the results of the last run for the heartbeat
+func (m *complianceMonitor) Map() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := map[string]interface{}{"runs": m.runs}
+	if m.profile != nil {
+		out["profile"] = m.profile.Name
+		out["version"] = m.profile.Version
+	}
+	if !m.lastRun.IsZero() {
+		out["last_run"] = m.lastRun.Unix()
+		for _, status := range []string{compliance.Pass, compliance.Fail, compliance.Error} {
+			out[status] = m.counts[status]
+		}
+	}
+	return out
+}
+
+// set replaces the profile, nil removing it, and keeps it for restarts
+func (m *complianceMonitor) set(raw []byte, profile *compliance.Profile) error {
+	var err error
+	if profile == nil {
+		if err = os.Remove(m.path); errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	} else if err = os.MkdirAll(filepath.Dir(m.path), 0o700); err == nil {
+		if err = os.WriteFile(m.path+".tmp", raw, 0o600); err == nil {
+			err = os.Rename(m.path+".tmp", m.path)
+		}
+	}
+
+	m.mu.Lock()
+	m.raw, m.profile = raw, profile
+	m.counts, m.lastRun = nil, time.Time{}
+	m.mu.Unlock()
+
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// complianceTopic returns the topic the check profile is pushed to
+func (c *Collector) complianceTopic() string {
+	return c.expandTopic(c.config.Compliance.Topic, "compliance")
+}
+
+// subscribeCompliance receives the check profile pushed by the cloud
+func (c *Collector) subscribeCompliance(client mqtt.Client) {
+	topic := c.complianceTopic()
+	token := client.Subscribe(topic, 1, c.handleComplianceMessage)
+	if token.Wait() && token.Error() != nil {
+		c.logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to subscribe to compliance topic")
+		c.reportError("compliance", token.Error())
+	}
+}
+
+// handleComplianceMessage replaces the check profile. An empty payload
+// removes it; a profile delivered again after a reconnect changes nothing
+func (c *Collector) handleComplianceMessage(_ mqtt.Client, msg mqtt.Message) {
+	m := c.compliance
+	m.mu.Lock()
+	same := bytes.Equal(m.raw, msg.Payload())
+	m.mu.Unlock()
+	if same {
+		return
+	}
+
+	action, name := "compliance.remove", ""
+	var profile *compliance.Profile
+	var err error
+	if len(msg.Payload()) > 0 {
+		action = "compliance.update"
+		if profile, err = compliance.Parse(msg.Payload()); err == nil {
+			name = profile.Name + "@" + profile.Version
+		}
+	}
+	if err == nil {
+		err = m.set(msg.Payload(), profile)
+	}
+
+	result := "applied"
+	logger := c.logger.WithFields(logrus.Fields{"action": action, "profile": name})
+	if err != nil {
+		result = "failed"
+		logger.WithError(err).Warn("Failed to apply pushed compliance profile")
+		c.reportError("compliance", err)
+	} else {
+		logger.Info("Applied pushed compliance profile")
+	}
+	if _, aErr := c.audit.Append("control-plane", action, name, result, nil); aErr != nil {
+		c.logger.WithError(aErr).Warn("Failed to write audit entry")
+	}
+}
+
+// complianceLoop runs the checks every interval and whenever the profile
+// changes
+func (c *Collector) complianceLoop(ctx context.Context) {
+	defer c.wg.Done()
+	c.runCompliance()
+
+	ticker := time.NewTicker(c.config.Compliance.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.runCompliance()
+		case <-c.compliance.trigger:
+			c.runCompliance()
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// runCompliance runs the profile and sends a compliance record with the
+// result of every check
+func (c *Collector) runCompliance() {
+	m := c.compliance
+	m.mu.Lock()
+	profile := m.profile
+	m.mu.Unlock()
+	if profile == nil {
+		return
+	}
+
+	span := c.resources.Start("input.compliance")
+	results := m.runner.Run(profile)
+	span.End(len(results))
+
+	counts := make(map[string]int, 3)
+	items := make([]interface{}, 0, len(results))
+	for _, r := range results {
+		counts[r.Status]++
+		items = append(items, r.Map())
+	}
+	m.mu.Lock()
+	if m.profile == profile {
+		m.counts, m.lastRun = counts, time.Now()
+		m.runs++
+	}
+	m.mu.Unlock()
+
+	data := map[string]interface{}{
+		"profile": profile.Name,
+		"version": profile.Version,
+		"passed":  counts[compliance.Pass],
+		"failed":  counts[compliance.Fail],
+		"errors":  counts[compliance.Error],
+		"results": items,
+	}
+	if checked := counts[compliance.Pass] + counts[compliance.Fail]; checked > 0 {
+		data["score"] = float64(counts[compliance.Pass]) / float64(checked) * 100
+	}
+	if err := c.sendTelemetry("compliance", c.newTelemetry("compliance", data)); err != nil {
+		c.logger.WithError(err).Warn("Failed to send compliance results")
+		return
+	}
+	c.logger.WithFields(logrus.Fields{
+		"profile": profile.Name,
+		"passed":  counts[compliance.Pass],
+		"failed":  counts[compliance.Fail],
+		"errors":  counts[compliance.Error],
+	}).Info("Ran compliance checks")
+}
@@ -0,0 +1,173 @@
+package collector
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/mqtt5"
+)
+
+// newControlClient creates the connection receiving commands and pushed
+// configuration. It has credentials of its own, so a leaked telemetry
+// credential cannot be used to push configuration or commands, and the
+// broker ACL of each can be scoped to its topics
+func (c *Collector) newControlClient(sessionRoot string) (mqtt.Client, error) {
+	cfg := c.config.MQTT
+	ctl := cfg.Control
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(cfg.Broker)
+	opts.SetClientID(ctl.ClientID)
+	opts.SetUsername(ctl.Username)
+	opts.SetPassword(ctl.Password)
+	opts.SetConnectTimeout(cfg.Timeout)
+	if ctl.Token.Enabled {
+		var err error
+		if c.controlToken, err = c.newBrokerToken(ctl.Token, ctl.Username, "control"); err != nil {
+			return nil, fmt.Errorf("failed to set up access token: %w", err)
+		}
+		opts.SetCredentialsProvider(c.credentials(c.controlToken))
+	}
+	opts.SetKeepAlive(60 * time.Second)
+	opts.SetCleanSession(!cfg.Session.Persistent)
+
+	tlsOpts := cfg.TLS
+	tlsOpts.CertFile, tlsOpts.KeyFile = ctl.CertFile, ctl.KeyFile
+	tlsCfg, err := mqttTLSConfig(tlsOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+	opts.SetTLSConfig(tlsCfg)
+
+	sessionDir := ""
+	if cfg.Session.Persistent && cfg.Session.Store == "file" {
+		sessionDir = filepath.Join(sessionRoot, "mqtt-control-session")
+		if err := os.MkdirAll(sessionDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create session store: %w", err)
+		}
+		opts.SetStore(mqtt.NewFileStore(sessionDir))
+	}
+
+	// Link quality describes the telemetry connection only
+	opts.SetCustomOpenConnectionFn(func(uri *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+		return c.dialBroker(uri, options, func(time.Duration, time.Duration) {})
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		c.logger.WithError(err).Warn("MQTT control connection lost")
+	})
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		c.logger.WithField("client_id", ctl.ClientID).Info("Connected to MQTT broker for commands and configuration")
+		c.subscribeControl(client)
+	})
+
+	if cfg.Protocol == "5" {
+		return mqtt5.NewClient(opts, mqtt5.Options{
+			UserProperties: userProperties(c.config),
+			SessionExpiry:  cfg.Session.Expiry,
+			SessionDir:     sessionDir,
+
+			ReconnectBackoff: c.reconnect.Delay,
+		}), nil
+	}
+	return mqtt.NewClient(opts), nil
+}
+
+// routeControl registers the handlers of the control topics enabled, so
+// messages the broker queued in a persistent session while the collector
+// was offline are handled as soon as the session resumes
+func (c *Collector) routeControl(client mqtt.Client) {
+	if c.config.Decoders.Enabled {
+		client.AddRoute(c.decoderTopic(), c.handleDecoderMessage)
+	}
+	if c.config.Workloads.Enabled {
+		client.AddRoute(c.workloadTopic(), c.handleWorkloadMessage)
+	}
+	if c.config.OutputPush.Enabled {
+		client.AddRoute(c.outputPushTopic(), c.handleOutputMessage)
+	}
+	if c.config.Uploads.Enabled {
+		client.AddRoute(c.uploadTopic(), c.handleUploadMessage)
+	}
+	if c.config.Drift.Enabled {
+		client.AddRoute(c.driftTopic(), c.handleBaselineMessage)
+	}
+	if c.config.Compliance.Enabled {
+		client.AddRoute(c.complianceTopic(), c.handleComplianceMessage)
+	}
+	if c.config.Update.Enabled {
+		client.AddRoute(c.updateTopic(), c.handleUpdateMessage)
+	}
+	if c.config.GPIO.Enabled {
+		client.AddRoute(c.gpioTopic(), c.handleGPIOMessage)
+	}
+	if c.config.Acks.Enabled {
+		client.AddRoute(c.ackTopic(), c.handleAckMessage)
+	}
+	if c.config.CloudThrottle.Enabled {
+		client.AddRoute(c.throttleTopic(), c.handleThrottleMessage)
+	}
+}
+
+// subscribeControl subscribes to the control topics enabled
+func (c *Collector) subscribeControl(client mqtt.Client) {
+	if c.config.Decoders.Enabled {
+		c.subscribeDecoders(client)
+	}
+	if c.config.Workloads.Enabled {
+		c.subscribeWorkloads(client)
+	}
+	if c.config.OutputPush.Enabled {
+		c.subscribeOutputs(client)
+	}
+	if c.config.Uploads.Enabled {
+		c.subscribeUploads(client)
+	}
+	if c.config.Drift.Enabled {
+		c.subscribeDrift(client)
+	}
+	if c.config.Compliance.Enabled {
+		c.subscribeCompliance(client)
+	}
+	if c.config.Update.Enabled {
+		c.subscribeUpdates(client)
+	}
+	if c.config.GPIO.Enabled {
+		c.subscribeGPIO(client)
+	}
+	if c.config.Acks.Enabled {
+		c.subscribeAcks(client)
+	}
+	if c.config.CloudThrottle.Enabled {
+		c.subscribeThrottle(client)
+	}
+}
+
+// connectControl connects the control connection. A failed first attempt
+// is retried with the reconnect backoff while the telemetry connection is
+// up; once connected the client reconnects by itself
+func (c *Collector) connectControl() {
+	for n := 1; ; n++ {
+		token := c.control.Connect()
+		if token.Wait() && token.Error() == nil {
+			return
+		}
+		c.logger.WithError(token.Error()).Warn("Failed to connect to MQTT broker for commands and configuration")
+		c.reportError("control", token.Error())
+
+		timer := time.NewTimer(c.reconnect.Delay(n))
+		select {
+		case <-timer.C:
+		case <-c.stopCh:
+			timer.Stop()
+			return
+		}
+		if !c.mqttClient.IsConnected() {
+			return
+		}
+	}
+}
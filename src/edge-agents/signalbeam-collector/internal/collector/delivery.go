@@ -0,0 +1,97 @@
+package collector
+
+import "sync"
+
+// outputCounters holds delivery counters for a single output
+type outputCounters struct {
+	Published int64 `json:"published"`
+	Acked     int64 `json:"acked"`
+	Retried   int64 `json:"retried"`
+	Dropped   int64 `json:"dropped"`
+	Expired   int64 `json:"expired"` // No acknowledgement within the publish timeout
+	RawBytes  int64 `json:"raw_bytes"`
+	WireBytes int64 `json:"wire_bytes"`
+}
+
+// deliveryStats tracks per-output delivery counters for self-telemetry
+type deliveryStats struct {
+	mu      sync.Mutex
+	outputs map[string]*outputCounters
+}
+
+// counters returns the counters for an output, creating them on first use.
+// The caller must hold d.mu.
+func (d *deliveryStats) counters(output string) *outputCounters {
+	if d.outputs == nil {
+		d.outputs = make(map[string]*outputCounters)
+	}
+	oc, ok := d.outputs[output]
+	if !ok {
+		oc = &outputCounters{}
+		d.outputs[output] = oc
+	}
+	return oc
+}
+
+// published records a publish attempt with its size before and after encoding
+func (d *deliveryStats) published(output string, rawBytes, wireBytes int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	oc := d.counters(output)
+	oc.Published++
+	oc.RawBytes += int64(rawBytes)
+	oc.WireBytes += int64(wireBytes)
+}
+
+// acked records a delivery confirmed by the output
+func (d *deliveryStats) acked(output string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counters(output).Acked++
+}
+
+// retried records a redelivery attempt
+func (d *deliveryStats) retried(output string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counters(output).Retried++
+}
+
+// dropped records a message the output gave up on
+func (d *deliveryStats) dropped(output string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counters(output).Dropped++
+}
+
+// expired records a message the output did not acknowledge in time
+func (d *deliveryStats) expired(output string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counters(output).Expired++
+}
+
+// Map returns the per-output counters including the compression ratio
+func (d *deliveryStats) Map() map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	m := make(map[string]interface{}, len(d.outputs))
+	for name, oc := range d.outputs {
+		ratio := 1.0
+		if oc.RawBytes > 0 {
+			ratio = float64(oc.WireBytes) / float64(oc.RawBytes)
+		}
+		m[name] = map[string]interface{}{
+			"published":         oc.Published,
+			"acked":             oc.Acked,
+			"retried":           oc.Retried,
+			"dropped":           oc.Dropped,
+			"expired":           oc.Expired,
+			"bytes":             oc.WireBytes,
+			"raw_bytes":         oc.RawBytes,
+			"compression_ratio": ratio,
+		}
+	}
+	return m
+}
@@ -0,0 +1,151 @@
+package collector
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// diagnosticEntry aggregates repeated occurrences of the same error
+type diagnosticEntry struct {
+	Source    string    `json:"source"`
+	Message   string    `json:"message"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// diagnostics collects collector-side errors between flushes, deduplicating
+// identical source/message pairs
+type diagnostics struct {
+	mu      sync.Mutex
+	entries map[string]*diagnosticEntry
+	dropped map[string]int64
+}
+
+// newDiagnostics creates an empty diagnostics aggregator
+func newDiagnostics() *diagnostics {
+	return &diagnostics{
+		entries: make(map[string]*diagnosticEntry),
+		dropped: make(map[string]int64),
+	}
+}
+
+// record adds an error occurrence for the given source
+func (d *diagnostics) record(source string, err error) {
+	now := time.Now().UTC()
+	key := source + "\x00" + err.Error()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.entries[key]; ok {
+		e.Count++
+		e.LastSeen = now
+		return
+	}
+	d.entries[key] = &diagnosticEntry{
+		Source:    source,
+		Message:   err.Error(),
+		Count:     1,
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+}
+
+// drop counts a record of the given data type that was not delivered
+func (d *diagnostics) drop(dataType string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dropped[dataType]++
+}
+
+// drain returns up to max entries (most frequent first) plus dropped counts,
+// resetting the aggregator. The number of entries suppressed by the limit is
+// also returned.
+func (d *diagnostics) drain(max int) ([]diagnosticEntry, map[string]int64, int) {
+	d.mu.Lock()
+	entries := make([]diagnosticEntry, 0, len(d.entries))
+	for _, e := range d.entries {
+		entries = append(entries, *e)
+	}
+	dropped := d.dropped
+	d.entries = make(map[string]*diagnosticEntry)
+	d.dropped = make(map[string]int64)
+	d.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Count > entries[j].Count
+	})
+
+	suppressed := 0
+	if max > 0 && len(entries) > max {
+		suppressed = len(entries) - max
+		entries = entries[:max]
+	}
+
+	return entries, dropped, suppressed
+}
+
+// reportError queues an error for the diagnostics topic
+func (c *Collector) reportError(source string, err error) {
+	if c.config.Diagnostics.Enabled {
+		c.diagnostics.record(source, err)
+	}
+}
+
+// reportDropped counts undelivered telemetry for the diagnostics topic
+func (c *Collector) reportDropped(dataType string) {
+	if c.config.Diagnostics.Enabled {
+		c.diagnostics.drop(dataType)
+	}
+}
+
+// diagnosticsLoop periodically publishes aggregated errors
+func (c *Collector) diagnosticsLoop(ctx context.Context) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.config.Diagnostics.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.sendDiagnostics()
+		case <-c.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendDiagnostics publishes the errors recorded since the last flush
+func (c *Collector) sendDiagnostics() {
+	entries, dropped, suppressed := c.diagnostics.drain(c.config.Diagnostics.MaxEntries)
+	auditEntries := c.pendingAudit()
+	if len(entries) == 0 && len(dropped) == 0 && len(auditEntries) == 0 {
+		return
+	}
+
+	data := map[string]interface{}{
+		"errors":     entries,
+		"dropped":    dropped,
+		"suppressed": suppressed,
+		"outputs":    c.delivery.Map(),
+	}
+	if len(auditEntries) > 0 {
+		seq, head := c.audit.Head()
+		data["audit"] = auditEntries
+		data["audit_head"] = map[string]interface{}{"seq": seq, "hash": head}
+	}
+
+	if err := c.sendTelemetry("diagnostics", c.newTelemetry("diagnostics", data)); err != nil {
+		c.logger.WithError(err).Warn("Failed to send diagnostics")
+		return
+	}
+	if n := len(auditEntries); n > 0 {
+		c.auditSent = auditEntries[n-1].Seq
+	}
+}
@@ -0,0 +1,191 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/display"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+// framebufferDir holds the framebuffer devices
+const framebufferDir = "/sys/class/graphics"
+
+// displayMonitor reports the displays of a kiosk and takes the screenshots
+// requested as uploads
+type displayMonitor struct {
+	shot display.Screenshot
+
+	mu        sync.Mutex
+	connected map[string]bool // Last state of each connector, for changes
+	lastShot  time.Time
+
+	scans       atomic.Int64
+	failed      atomic.Int64
+	screenshots atomic.Int64
+	refused     atomic.Int64 // Screenshots refused for min_interval
+}
+
+// newDisplay creates the monitor
+func (c *Collector) newDisplay() *displayMonitor {
+	s := c.config.Display.Screenshot
+	return &displayMonitor{
+		shot: display.Screenshot{
+			Command:  s.Command,
+			MaxWidth: s.MaxWidth,
+			Quality:  s.Quality,
+			Timeout:  s.Timeout,
+		},
+	}
+}
+
+// Map reports the connected displays and counters for the heartbeat
+func (d *displayMonitor) Map() map[string]interface{} {
+	d.mu.Lock()
+	connected := 0
+	for _, on := range d.connected {
+		if on {
+			connected++
+		}
+	}
+	d.mu.Unlock()
+	return map[string]interface{}{
+		"connected":   connected,
+		"scans":       d.scans.Load(),
+		"failed":      d.failed.Load(),
+		"screenshots": d.screenshots.Load(),
+		"refused":     d.refused.Load(),
+	}
+}
+
+// displayLoop reports the displays every interval
+func (c *Collector) displayLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.config.Display.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.scanDisplays()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// scanDisplays sends a display record and publishes a display_changed event
+// for each connector whose display was plugged in or unplugged since the
+// last scan
+func (c *Collector) scanDisplays() {
+	cfg := c.config.Display
+	d := c.display
+	span := c.resources.Start("input.display")
+	connectors, err := display.Scan(cfg.DRMDir)
+	span.End(1)
+	d.scans.Add(1)
+	if err != nil {
+		d.failed.Add(1)
+		c.logger.WithError(err).Warn("Failed to read display connectors")
+		c.reportError("display", err)
+		return
+	}
+	if len(cfg.Connectors) > 0 {
+		connectors = slices.DeleteFunc(connectors, func(conn display.Connector) bool {
+			return !slices.Contains(cfg.Connectors, conn.Name)
+		})
+	}
+
+	list := make([]interface{}, 0, len(connectors))
+	connected := 0
+	var changed []display.Connector
+	d.mu.Lock()
+	first := d.connected == nil
+	if first {
+		d.connected = make(map[string]bool, len(connectors))
+	}
+	for _, conn := range connectors {
+		list = append(list, conn.Map())
+		if conn.Connected {
+			connected++
+		}
+		if was, ok := d.connected[conn.Name]; !first && (!ok || was != conn.Connected) {
+			changed = append(changed, conn)
+		}
+		d.connected[conn.Name] = conn.Connected
+	}
+	d.mu.Unlock()
+
+	for _, conn := range changed {
+		fields := map[string]interface{}{"connector": conn.Name, "connected": conn.Connected}
+		if conn.Mode != "" {
+			fields["mode"] = conn.Mode
+		}
+		if conn.Monitor != "" {
+			fields["monitor"] = conn.Monitor
+		}
+		c.logger.WithFields(logrus.Fields(fields)).Info("Display changed")
+		c.publishEvent("display_changed", fields)
+	}
+
+	data := map[string]interface{}{
+		"connectors": list,
+		"connected":  connected,
+	}
+	if fb := display.Framebuffer(framebufferDir); fb != "" {
+		data["framebuffer"] = fb
+	}
+	if err := c.sendTelemetry("display", c.newTelemetry("display", data)); err != nil {
+		c.logger.WithError(err).Warn("Failed to send display status")
+	}
+}
+
+// screenshot captures the screen to a temporary JPEG file in the state
+// directory for upload. Screenshots must be enabled in the local file and
+// are at most one per min_interval
+func (c *Collector) screenshot(ctx context.Context) (*os.File, error) {
+	d := c.display
+	cfg := c.config.Display.Screenshot
+	if d == nil || !cfg.Enabled {
+		return nil, fmt.Errorf("screenshots are disabled, see display.screenshot.enabled")
+	}
+	d.mu.Lock()
+	if since := time.Since(d.lastShot); since < cfg.MinInterval {
+		d.mu.Unlock()
+		d.refused.Add(1)
+		return nil, fmt.Errorf("last screenshot was %s ago, less than display.screenshot.min_interval", since.Round(time.Second))
+	}
+	d.lastShot = time.Now()
+	d.mu.Unlock()
+
+	dir := state.Resolve(c.config.State).Dir
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, ".screenshot-*.jpg")
+	if err != nil {
+		return nil, err
+	}
+	size, err := d.shot.Capture(ctx, f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		d.failed.Add(1)
+		return nil, fmt.Errorf("failed to capture screenshot: %w", err)
+	}
+	d.screenshots.Add(1)
+	c.logger.WithFields(logrus.Fields{"width": size.X, "height": size.Y}).Info("Captured screenshot")
+	return f, nil
+}
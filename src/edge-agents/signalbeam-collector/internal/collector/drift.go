@@ -0,0 +1,259 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/drift"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+// driftMonitor checks the host against the baseline pushed by the cloud and
+// remembers the findings already reported
+type driftMonitor struct {
+	dir     string
+	checker *drift.Checker
+	trigger chan struct{}
+
+	mu        sync.Mutex
+	raw       []byte
+	baseline  *drift.Baseline
+	active    map[string]drift.Finding
+	lastCheck time.Time
+
+	checks atomic.Int64
+}
+
+// newDriftMonitor loads the baseline kept from the last push, so checks run
+// before the broker redelivers it
+func (c *Collector) newDriftMonitor() (*driftMonitor, error) {
+	dir := filepath.Join(state.Resolve(c.config.State).Dir, "drift")
+	d := &driftMonitor{
+		dir:     dir,
+		checker: drift.NewChecker(filepath.Join(dir, "copies")),
+		trigger: make(chan struct{}, 1),
+		active:  make(map[string]drift.Finding),
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "baseline"))
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.baseline, err = drift.Parse(raw); err != nil {
+		return nil, err
+	}
+	d.raw = raw
+	return d, nil
+}
+
+// Map returns the drift state for the heartbeat
+func (d *driftMonitor) Map() map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m := map[string]interface{}{
+		"checks":  d.checks.Load(),
+		"drifted": len(d.active),
+	}
+	if d.baseline != nil {
+		m["baseline"] = d.baseline.Version
+	}
+	if !d.lastCheck.IsZero() {
+		m["last_check"] = d.lastCheck.Unix()
+	}
+	return m
+}
+
+// set replaces the baseline, nil removing it along with the file copies,
+// and keeps it for restarts. Findings of the previous baseline are forgotten
+func (d *driftMonitor) set(raw []byte, baseline *drift.Baseline) error {
+	path := filepath.Join(d.dir, "baseline")
+	var err error
+	if baseline == nil {
+		if err = os.Remove(path); errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		if rErr := os.RemoveAll(filepath.Join(d.dir, "copies")); err == nil {
+			err = rErr
+		}
+	} else if err = os.MkdirAll(d.dir, 0o700); err == nil {
+		if err = os.WriteFile(path+".tmp", raw, 0o600); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+
+	d.mu.Lock()
+	d.raw, d.baseline = raw, baseline
+	d.active = make(map[string]drift.Finding)
+	d.mu.Unlock()
+
+	select {
+	case d.trigger <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// driftTopic returns the topic the baseline is pushed to
+func (c *Collector) driftTopic() string {
+	return c.expandTopic(c.config.Drift.Topic, "baseline")
+}
+
+// subscribeDrift receives the baseline pushed by the cloud
+func (c *Collector) subscribeDrift(client mqtt.Client) {
+	topic := c.driftTopic()
+	token := client.Subscribe(topic, 1, c.handleBaselineMessage)
+	if token.Wait() && token.Error() != nil {
+		c.logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to subscribe to baseline topic")
+		c.reportError("drift", token.Error())
+	}
+}
+
+// handleBaselineMessage replaces the baseline. An empty payload removes it;
+// a baseline delivered again after a reconnect changes nothing
+func (c *Collector) handleBaselineMessage(_ mqtt.Client, msg mqtt.Message) {
+	d := c.drift
+	d.mu.Lock()
+	same := bytes.Equal(d.raw, msg.Payload())
+	d.mu.Unlock()
+	if same {
+		return
+	}
+
+	action, version := "baseline.remove", ""
+	var baseline *drift.Baseline
+	var err error
+	if len(msg.Payload()) > 0 {
+		action = "baseline.update"
+		if baseline, err = drift.Parse(msg.Payload()); err == nil {
+			version = baseline.Version
+		}
+	}
+	if err == nil {
+		err = d.set(msg.Payload(), baseline)
+	}
+
+	result := "applied"
+	logger := c.logger.WithFields(logrus.Fields{"action": action, "version": version})
+	if err != nil {
+		result = "failed"
+		logger.WithError(err).Warn("Failed to apply pushed baseline")
+		c.reportError("drift", err)
+	} else {
+		logger.Info("Applied pushed baseline")
+	}
+	if _, aErr := c.audit.Append("control-plane", action, version, result, nil); aErr != nil {
+		c.logger.WithError(aErr).Warn("Failed to write audit entry")
+	}
+}
+
+// driftLoop checks the host every interval and whenever the baseline
+// changes
+func (c *Collector) driftLoop(ctx context.Context) {
+	defer c.wg.Done()
+	c.checkDrift()
+
+	ticker := time.NewTicker(c.config.Drift.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.checkDrift()
+		case <-c.drift.trigger:
+			c.checkDrift()
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// checkDrift compares the host with the baseline. A config_drift event is
+// sent when a difference appears or changes, config_drift_resolved when it
+// is gone
+func (c *Collector) checkDrift() {
+	d := c.drift
+	d.mu.Lock()
+	baseline := d.baseline
+	d.mu.Unlock()
+	if baseline == nil {
+		return
+	}
+
+	span := c.resources.Start("input.drift")
+	findings, err := d.checker.Check(baseline)
+	span.End(len(findings))
+	if err != nil {
+		c.reportError("drift", err)
+	}
+	d.checks.Add(1)
+
+	current := make(map[string]drift.Finding, len(findings))
+	for _, f := range findings {
+		current[f.Key()] = f
+	}
+
+	d.mu.Lock()
+	if d.baseline != baseline {
+		// Replaced while checking; the trigger checks again
+		d.mu.Unlock()
+		return
+	}
+	var appeared, resolved []drift.Finding
+	for key, f := range current {
+		if prev, ok := d.active[key]; !ok || prev.Actual != f.Actual {
+			appeared = append(appeared, f)
+		}
+	}
+	for key, f := range d.active {
+		if _, ok := current[key]; !ok {
+			resolved = append(resolved, f)
+		}
+	}
+	d.active = current
+	d.lastCheck = time.Now()
+	d.mu.Unlock()
+
+	for _, f := range appeared {
+		c.publishEvent("config_drift", driftFields(baseline, f))
+	}
+	for _, f := range resolved {
+		fields := driftFields(baseline, f)
+		delete(fields, "diff")
+		c.publishEvent("config_drift_resolved", fields)
+	}
+	if len(appeared) > 0 || len(resolved) > 0 {
+		c.logger.WithFields(logrus.Fields{
+			"drifted":  len(current),
+			"appeared": len(appeared),
+			"resolved": len(resolved),
+		}).Info("Configuration drift changed")
+	}
+}
+
+// driftFields describes a finding in an event
+func driftFields(baseline *drift.Baseline, f drift.Finding) map[string]interface{} {
+	fields := map[string]interface{}{
+		"baseline": baseline.Version,
+		"kind":     f.Kind,
+		"name":     f.Name,
+		"field":    f.Field,
+		"expected": f.Expected,
+		"actual":   f.Actual,
+	}
+	if len(f.Diff) > 0 {
+		fields["diff"] = f.Diff
+	}
+	return fields
+}
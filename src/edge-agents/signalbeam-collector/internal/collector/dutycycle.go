@@ -0,0 +1,137 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// runDutyCycle collects and transmits in short wake windows, holding the
+// MQTT connection only while publishing
+func (c *Collector) runDutyCycle(ctx context.Context) error {
+	c.logger.WithField("wake_interval", c.config.Power.WakeInterval).Info("Running in duty-cycle mode")
+
+	for {
+		start := time.Now()
+		if err := c.wakeWindow(ctx); err != nil {
+			c.logger.WithError(err).Warn("Wake window failed")
+		}
+
+		sleep := c.config.Power.WakeInterval - time.Since(start)
+		if sleep < 0 {
+			sleep = 0
+		}
+
+		if err := c.sleepUntilNextWake(ctx, sleep); err != nil {
+			return nil
+		}
+	}
+}
+
+// wakeWindow performs a single collect-connect-publish-disconnect cycle.
+// Settings that need the collector between windows are rejected by the
+// config, so everything collected is sent here
+func (c *Collector) wakeWindow(ctx context.Context) error {
+	// Collect before connecting so the radio is up for as short as possible
+	var (
+		metrics    TelemetryData
+		metricsTr  *trace.Trace
+		hasMetrics bool
+	)
+	if c.config.Collection.Metrics.Enabled {
+		var err error
+		metrics, metricsTr, err = c.gatherMetrics()
+		hasMetrics = err == nil
+	}
+
+	if err := c.connect(); err != nil {
+		return err
+	}
+	c.sendHeartbeat()
+
+	if hasMetrics {
+		if err := c.sendTraced("metrics", metrics, metricsTr); err != nil {
+			c.logger.WithError(err).Error("Failed to send metrics")
+		}
+	}
+	if c.logTailer != nil {
+		// Lines written since the last window
+		if err := c.gatherAndSendLogs(); err != nil {
+			c.logger.WithError(err).Warn("Failed to send logs")
+		}
+	}
+	if c.config.Diagnostics.Enabled {
+		c.sendDiagnostics()
+	}
+	if c.config.State.CheckpointInterval > 0 {
+		if err := c.saveCheckpoint(); err != nil {
+			c.logger.WithError(err).Warn("Failed to save collector state")
+			c.reportError("state", err)
+		}
+	}
+
+	if c.config.MQTT.Status.Enabled {
+		// Sleeping is a clean disconnect, which does not trigger the will
+		c.sendStatus("offline", "sleep")
+	}
+	// Publishes are asynchronous; the short quiesce of Disconnect would cut
+	// off those the broker has not answered yet
+	c.waitPublishes(ctx)
+	if c.control != nil {
+		c.control.Disconnect(250)
+	}
+	c.mqttClient.Disconnect(250)
+	return nil
+}
+
+// sleepUntilNextWake suspends the system when a suspend command is configured,
+// otherwise it idles until the next wake window. It returns ctx.Err() on shutdown.
+func (c *Collector) sleepUntilNextWake(ctx context.Context, d time.Duration) error {
+	if len(c.config.Power.SuspendCommand) > 0 && d >= time.Second {
+		if err := c.suspend(ctx, d); err != nil {
+			c.logger.WithError(err).Warn("System suspend failed, idling instead")
+		} else {
+			return ctx.Err()
+		}
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.stopCh:
+		return context.Canceled
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// suspend runs the configured suspend command, which is expected to block
+// until the system resumes (e.g. rtcwake -m mem -s {seconds})
+func (c *Collector) suspend(ctx context.Context, d time.Duration) error {
+	seconds := strconv.Itoa(int(d.Seconds()))
+	args := make([]string, len(c.config.Power.SuspendCommand))
+	for i, arg := range c.config.Power.SuspendCommand {
+		args[i] = strings.ReplaceAll(arg, "{seconds}", seconds)
+	}
+
+	c.logger.WithFields(logrus.Fields{
+		"command":  strings.Join(args, " "),
+		"duration": d,
+	}).Debug("Suspending system until next wake window")
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w (%s)", args[0], err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
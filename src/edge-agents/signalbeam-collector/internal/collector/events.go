@@ -0,0 +1,178 @@
+package collector
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/quality"
+)
+
+// eventWindow tracks repeats of an identical event within the dedup window
+type eventWindow struct {
+	name      string
+	fields    map[string]interface{}
+	count     int
+	firstSeen time.Time
+	lastSeen  time.Time
+	timer     *time.Timer // Closes the window
+}
+
+// eventDeduper suppresses identical events within a time window and
+// publishes a single summary with the repeat count when the window closes
+type eventDeduper struct {
+	mu      sync.Mutex
+	window  time.Duration
+	pending map[string]*eventWindow
+	closed  bool
+	flushes sync.WaitGroup // Windows whose timer has not finished
+}
+
+// newEventDeduper creates a deduper; a zero window disables deduplication
+func newEventDeduper(window time.Duration) *eventDeduper {
+	return &eventDeduper{
+		window:  window,
+		pending: make(map[string]*eventWindow),
+	}
+}
+
+// eventKey identifies identical events by name and their "key" field, or
+// all fields when no key is given
+func eventKey(name string, fields map[string]interface{}) string {
+	if key, ok := fields["key"]; ok {
+		b, _ := json.Marshal(key)
+		return name + "\x00" + string(b)
+	}
+	b, _ := json.Marshal(fields)
+	return name + "\x00" + string(b)
+}
+
+// observe records an event and reports whether it should be published now.
+// flush is called with the window summary when repeats were suppressed.
+func (d *eventDeduper) observe(name string, fields map[string]interface{}, flush func(*eventWindow)) bool {
+	if d.window <= 0 {
+		return true
+	}
+
+	key := eventKey(name, fields)
+	now := time.Now().UTC()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return true
+	}
+	if w, ok := d.pending[key]; ok {
+		w.count++
+		w.lastSeen = now
+		return false
+	}
+
+	w := &eventWindow{
+		name:      name,
+		fields:    fields,
+		count:     1,
+		firstSeen: now,
+		lastSeen:  now,
+	}
+	d.pending[key] = w
+
+	d.flushes.Add(1)
+	w.timer = time.AfterFunc(d.window, func() {
+		defer d.flushes.Done()
+		d.mu.Lock()
+		if d.pending[key] != w {
+			d.mu.Unlock()
+			return
+		}
+		delete(d.pending, key)
+		d.mu.Unlock()
+
+		if w.count > 1 {
+			flush(w)
+		}
+	})
+
+	return true
+}
+
+// close stops the window timers and flushes the summaries still pending,
+// then waits for timers already firing. Events observed afterwards are
+// published without deduplication
+func (d *eventDeduper) close(flush func(*eventWindow)) {
+	d.mu.Lock()
+	d.closed = true
+	var open []*eventWindow
+	for key, w := range d.pending {
+		// A timer that already fired flushes its window itself
+		if w.timer.Stop() {
+			d.flushes.Done()
+			open = append(open, w)
+			delete(d.pending, key)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, w := range open {
+		if w.count > 1 {
+			flush(w)
+		}
+	}
+	d.flushes.Wait()
+}
+
+// sendEvent publishes a collector event on the events topic, deduplicating
+// identical events within the configured window
+func (c *Collector) sendEvent(name string, fields map[string]interface{}) {
+	if !c.events.observe(name, fields, c.sendEventSummary) {
+		return
+	}
+	c.publishEvent(name, fields)
+}
+
+// sendEventSummary publishes the repeat count of a deduplicated event
+func (c *Collector) sendEventSummary(w *eventWindow) {
+	fields := make(map[string]interface{}, len(w.fields)+3)
+	for k, v := range w.fields {
+		fields[k] = v
+	}
+	fields["count"] = w.count
+	fields["first_seen"] = w.firstSeen
+	fields["last_seen"] = w.lastSeen
+
+	c.publishEvent(w.name, fields)
+}
+
+// publishEvent sends an event without deduplication
+func (c *Collector) publishEvent(name string, fields map[string]interface{}) {
+	data := map[string]interface{}{"event": name}
+	for k, v := range fields {
+		data[k] = v
+	}
+	c.resources.Count("input.events", 1)
+	c.notifyEvent(name, data)
+	c.applyGPIORules(name, data)
+
+	if err := c.sendTelemetry("events", c.newTelemetry("events", data)); err != nil {
+		c.logger.WithError(err).WithField("event", name).Warn("Failed to send event")
+	}
+}
+
+// sendQualityAlerts publishes a quality_alert event per changed value path
+func (c *Collector) sendQualityAlerts(changes []quality.Transition) {
+	for _, t := range changes {
+		fields := map[string]interface{}{
+			"data_type": t.Type,
+			"path":      t.Path,
+			"state":     t.State,
+		}
+		if len(t.Flags) > 0 {
+			fields["flags"] = t.Flags
+		}
+		if len(t.Previous) > 0 {
+			fields["previous"] = t.Previous
+		}
+		c.publishEvent("quality_alert", fields)
+	}
+}
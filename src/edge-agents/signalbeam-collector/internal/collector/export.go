@@ -0,0 +1,170 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/export"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/parquet"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/s3"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/trace"
+)
+
+// parquetExport writes records as Parquet files and uploads finished files
+// when S3 is configured
+type parquetExport struct {
+	cfg      config.ExportConfig
+	exporter *export.Exporter
+	types    map[string]bool
+	dir      string
+	pending  string // Files waiting for upload; empty without S3
+	bucket   *s3.Client
+
+	mu       sync.Mutex
+	uploaded int64
+}
+
+// newExport creates the exporter. With S3, files are written to a pending
+// directory and moved to the export directory or deleted after upload
+func (c *Collector) newExport(cfg config.ExportConfig) (*parquetExport, error) {
+	e := &parquetExport{
+		cfg:   cfg,
+		types: make(map[string]bool, len(cfg.Types)),
+		dir:   cfg.Dir,
+	}
+	for _, t := range cfg.Types {
+		e.types[t] = true
+	}
+	if e.dir == "" {
+		e.dir = filepath.Join(state.Resolve(c.config.State).Dir, "export")
+	}
+
+	out := e.dir
+	if cfg.S3.Enabled {
+		tlsCfg, err := mqttTLSConfig(cfg.S3.TLS)
+		if err != nil {
+			return nil, err
+		}
+		e.bucket, err = s3.New(s3.Options{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			PathStyle: cfg.S3.PathStyle,
+			Timeout:   cfg.S3.Timeout,
+			TLS:       tlsCfg,
+			Dial:      c.dialOutput,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("parquet_export.s3: %w", err)
+		}
+		e.pending = filepath.Join(e.dir, ".pending")
+		out = e.pending
+	}
+
+	codec := parquet.Gzip
+	if cfg.Compression == "none" {
+		codec = parquet.Uncompressed
+	}
+	e.exporter = export.New(export.Options{Dir: out, MaxRows: cfg.MaxRows, Codec: codec})
+	return e, nil
+}
+
+// exportRecord buffers a processed record for the Parquet export
+func (c *Collector) exportRecord(dataType string, telemetry TelemetryData, tr *trace.Trace) {
+	e := c.export
+	if e == nil || !e.types[dataType] {
+		return
+	}
+	if c.dryRun {
+		tr.Step("export.parquet", trace.Passed, "dry run, not written")
+		return
+	}
+
+	span := c.resources.Start("output.parquet")
+	n, err := e.exporter.Add(export.Record{
+		Type:      dataType,
+		DeviceID:  telemetry.DeviceID,
+		Timestamp: telemetry.Timestamp,
+		Data:      telemetry.Data,
+		Tags:      telemetry.Tags,
+		Units:     telemetry.Units,
+	})
+	span.End(n)
+	if err != nil {
+		tr.Step("export.parquet", trace.Failed, err.Error())
+		c.reportError("parquet_export", err)
+		return
+	}
+	tr.Step("export.parquet", trace.Passed, fmt.Sprintf("%d rows", n))
+}
+
+// exportLoop writes buffered rows every interval and once more on shutdown
+func (c *Collector) exportLoop(ctx context.Context) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.export.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.flushExport()
+			return
+		case <-c.stopCh:
+			c.flushExport()
+			return
+		case <-ticker.C:
+			c.flushExport()
+		}
+	}
+}
+
+// flushExport writes buffered rows and uploads pending files. Files that
+// fail to upload are retried on the next flush
+func (c *Collector) flushExport() {
+	e := c.export
+	if err := e.exporter.Flush(); err != nil {
+		c.logger.WithError(err).Warn("Failed to write Parquet export")
+		c.reportError("parquet_export", err)
+	}
+	if e.bucket == nil {
+		return
+	}
+
+	keep := ""
+	if e.cfg.S3.KeepLocal {
+		keep = e.dir
+	}
+	n, err := export.Upload(e.pending, keep, func(key string, data []byte) error {
+		ctx, cancel := context.WithTimeout(context.Background(), e.cfg.S3.Timeout)
+		defer cancel()
+		return e.bucket.Put(ctx, path.Join(e.cfg.S3.Prefix, c.config.Device.ID, key), data, "application/vnd.apache.parquet")
+	})
+
+	e.mu.Lock()
+	e.uploaded += int64(n)
+	e.mu.Unlock()
+	if err != nil {
+		c.logger.WithError(err).Warn("Failed to upload Parquet export")
+		c.reportError("parquet_export.s3", err)
+	}
+}
+
+// exportStatus reports the export for the heartbeat
+func (e *parquetExport) exportStatus() map[string]interface{} {
+	status := e.exporter.Map()
+	if e.bucket != nil {
+		e.mu.Lock()
+		status["files_uploaded"] = e.uploaded
+		e.mu.Unlock()
+	}
+	return status
+}
@@ -0,0 +1,187 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/httpsend"
+)
+
+// httpFallback buffers records for the ingestion service while the broker
+// is unreachable and posts them in batches
+type httpFallback struct {
+	cfg    config.FallbackConfig
+	sender *httpsend.Sender
+	kick   chan struct{}
+
+	mu      sync.Mutex
+	pending []httpsend.Record
+	evicted int64 // Records dropped from the front of pending
+	active  bool  // Records currently go over HTTPS
+}
+
+// newFallback creates the HTTPS sender. Connections go through the proxy
+// and follow the egress allowlist
+func (c *Collector) newFallback(cfg config.FallbackConfig) (*httpFallback, error) {
+	tlsCfg, err := mqttTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	px, err := c.newTransportProxy(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpFallback{
+		cfg: cfg,
+		sender: httpsend.New(httpsend.Options{
+			URL:      cfg.URL,
+			Token:    cfg.Token,
+			DeviceID: c.config.Device.ID,
+			Compress: cfg.Compress,
+			Timeout:  cfg.Timeout,
+			TLS:      tlsCfg,
+			Dial:     c.dial(px),
+			Proxy:    px.httpProxy(),
+		}),
+		kick: make(chan struct{}, 1),
+	}, nil
+}
+
+// output selects the transport for the next message: MQTT or gRPC, or HTTPS
+// while that connection is down. Switches are logged once
+func (c *Collector) output() string {
+	f := c.fallback
+	if f == nil {
+		return c.transport
+	}
+	connected := c.mqttClient.IsConnectionOpen()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case !connected && !f.active:
+		c.logger.WithField("transport", c.transport).Warn("Primary transport unreachable, sending telemetry over HTTPS")
+	case connected && f.active:
+		c.logger.WithField("transport", c.transport).Info("Primary transport reachable again, switching back from HTTPS")
+	}
+	f.active = !connected
+	if f.active {
+		return "https"
+	}
+	return c.transport
+}
+
+// enqueue buffers a message for the next HTTPS batch, dropping the oldest
+// when the buffer is full
+func (c *Collector) enqueue(topic string, qos byte, retained bool, data []byte, raw int) {
+	f := c.fallback
+	c.delivery.published("https", raw, len(data))
+
+	f.mu.Lock()
+	if len(f.pending) >= f.cfg.MaxBuffer {
+		f.pending = f.pending[1:]
+		f.evicted++
+		c.delivery.dropped("https")
+	}
+	f.pending = append(f.pending, httpsend.NewRecord(topic, qos, retained, data))
+	full := len(f.pending) >= f.cfg.BatchSize
+	f.mu.Unlock()
+
+	if full {
+		select {
+		case f.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// fallbackLoop posts buffered records every flush interval or as soon as a
+// batch is full. Records left over after a switch back to MQTT still go
+// over HTTPS, so nothing buffered is lost
+func (c *Collector) fallbackLoop(ctx context.Context) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.fallback.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.flushFallback(context.Background())
+			return
+		case <-c.stopCh:
+			c.flushFallback(context.Background())
+			return
+		case <-ticker.C:
+			c.flushFallback(ctx)
+		case <-c.fallback.kick:
+			c.flushFallback(ctx)
+		}
+	}
+}
+
+// flushFallback posts pending records batch by batch until the buffer is
+// empty or a request fails; failed batches are retried on the next flush
+func (c *Collector) flushFallback(ctx context.Context) {
+	f := c.fallback
+	for {
+		f.mu.Lock()
+		n := min(len(f.pending), f.cfg.BatchSize)
+		batch := append([]httpsend.Record(nil), f.pending[:n]...)
+		evicted := f.evicted
+		f.mu.Unlock()
+		if n == 0 {
+			return
+		}
+
+		if err := f.sender.Send(ctx, batch); err != nil {
+			c.logger.WithError(err).WithField("records", n).Warn("Failed to post telemetry over HTTPS")
+			c.reportError("https_fallback", err)
+			for range batch {
+				c.delivery.retried("https")
+			}
+			return
+		}
+
+		f.mu.Lock()
+		// Records evicted while the request was in flight were part of
+		// the batch and are already gone
+		sent := max(n-int(f.evicted-evicted), 0)
+		f.pending = f.pending[sent:]
+		f.mu.Unlock()
+		c.ackPosted(batch)
+		for range batch {
+			c.delivery.acked("https")
+		}
+	}
+}
+
+// connectLoop keeps trying to reach the broker after the initial connection
+// failed, while telemetry goes over HTTPS. Once connected the MQTT client
+// reconnects by itself
+func (c *Collector) connectLoop(ctx context.Context) {
+	defer c.wg.Done()
+
+	for attempt := 0; ; attempt++ {
+		timer := time.NewTimer(c.reconnect.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-c.stopCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := c.connect(); err != nil {
+			c.logger.WithError(err).Debug("MQTT broker still unreachable")
+			continue
+		}
+		c.startACLCheck(ctx)
+		return
+	}
+}
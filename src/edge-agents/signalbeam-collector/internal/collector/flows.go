@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/flows"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/supervisor"
+)
+
+// captureFlows returns the input counting the packets of one interface.
+// Capture errors restart it with the supervision backoff
+func (c *Collector) captureFlows(iface string) func(context.Context, *supervisor.Handle) error {
+	return func(ctx context.Context, h *supervisor.Handle) error {
+		capture, err := flows.Open(iface)
+		if err != nil {
+			c.reportError("flows."+iface, err)
+			return fmt.Errorf("capture on %s: %w", iface, err)
+		}
+		defer capture.Close()
+		h.OK()
+
+		if err := capture.Run(ctx, c.flows); err != nil {
+			c.reportError("flows."+iface, err)
+			return err
+		}
+		return nil
+	}
+}
+
+// flowsLoop reports the traffic counted every interval
+func (c *Collector) flowsLoop(ctx context.Context) {
+	defer c.wg.Done()
+
+	start := time.Now()
+	ticker := time.NewTicker(c.config.Flows.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			c.sendFlows(now.Sub(start))
+			start = now
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// sendFlows publishes the largest flows since the last report, with the
+// remaining traffic summed under "other"
+func (c *Collector) sendFlows(window time.Duration) {
+	span := c.resources.Start("input.flows")
+	summary := c.flows.Drain(c.config.Flows.MaxFlows)
+	span.End(len(summary.Flows))
+
+	items := make([]interface{}, 0, len(summary.Flows))
+	for _, f := range summary.Flows {
+		items = append(items, f.Map())
+	}
+	data := map[string]interface{}{
+		"window_seconds": window.Seconds(),
+		"flows":          items,
+		"other":          summary.Other.Map(),
+		"total":          summary.Total.Map(),
+		"drops":          summary.Drops,
+	}
+	if err := c.sendTelemetry("flows", c.newTelemetry("flows", data)); err != nil {
+		c.logger.WithError(err).Warn("Failed to send flow statistics")
+	}
+}
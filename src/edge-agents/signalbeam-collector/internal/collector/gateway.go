@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"context"
+	"net"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/gateway"
+)
+
+// newGatewayClient creates the gRPC transport to the Edge Gateway. It takes
+// the broker client's handlers, so link quality, reconnects and control
+// subscriptions work as with MQTT
+func (c *Collector) newGatewayClient(cfg config.GatewayConfig, opts *mqtt.ClientOptions) (*gateway.Client, error) {
+	px, err := c.newTransportProxy(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	dial := c.dial(px)
+	gw := gateway.Options{
+		Address:  cfg.Address,
+		Token:    cfg.Token,
+		DeviceID: c.config.Device.ID,
+		Dial: func(ctx context.Context, address string) (net.Conn, error) {
+			return c.dialGateway(ctx, dial, address)
+		},
+		ReconnectBackoff: c.reconnect.Delay,
+	}
+	if !cfg.Plaintext {
+		tlsCfg, err := mqttTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		gw.TLS = tlsCfg
+	}
+	return gateway.NewClient(opts, gw), nil
+}
+
+// dialGateway opens the TCP connection to the gateway, through the proxy
+// and egress policy, and records the dial time
+func (c *Collector) dialGateway(ctx context.Context, dial egress.DialFunc, address string) (net.Conn, error) {
+	start := time.Now()
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	c.link.dialed(time.Since(start), 0)
+	return conn, nil
+}
@@ -0,0 +1,253 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/gpio"
+	"github.com/sirupsen/logrus"
+)
+
+// gpioCommandTimeout bounds the programs of command-driven outputs
+const gpioCommandTimeout = 10 * time.Second
+
+// gpioOutputs switches outputs on commands from the Control Plane and on
+// events matching local rules
+type gpioOutputs struct {
+	ctl *gpio.Controller
+
+	applied  atomic.Int64
+	rejected atomic.Int64
+	failed   atomic.Int64
+}
+
+// newGPIO opens the drivers and sets the initial states
+func (c *Collector) newGPIO() (*gpioOutputs, error) {
+	var outputs []gpio.Output
+	for _, o := range c.config.GPIO.Outputs {
+		var driver gpio.Driver
+		switch o.Driver {
+		case "command":
+			driver = &gpio.Command{On: o.On, Off: o.Off, Timeout: gpioCommandTimeout}
+		case "poe", "pdu", "snmp":
+			agent := gpio.SNMP{
+				Target:    o.SNMP.Target,
+				Community: o.SNMP.Community,
+				Version:   o.SNMP.Version,
+				OID:       o.SNMP.OID,
+				OnValue:   o.SNMP.OnValue,
+				OffValue:  o.SNMP.OffValue,
+				Timeout:   o.SNMP.Timeout,
+			}
+			switch o.Driver {
+			case "poe":
+				driver = gpio.PoE(agent, o.Group, o.Port)
+			case "pdu":
+				d, err := gpio.PDU(agent, o.PDU, o.Outlet)
+				if err != nil {
+					return nil, fmt.Errorf("output %s: %w", o.Name, err)
+				}
+				driver = d
+			default:
+				driver = &agent
+			}
+		case "usb":
+			if o.Path != "" {
+				driver = &gpio.USBPort{Path: o.Path}
+			} else {
+				driver = gpio.NewUSBPort(o.Hub, o.Port)
+			}
+		default:
+			line := 0
+			if o.Line != nil {
+				line = *o.Line
+			}
+			s, err := gpio.NewSysfs(line, o.Path, o.ActiveLow)
+			if err != nil {
+				return nil, fmt.Errorf("output %s: %w", o.Name, err)
+			}
+			driver = s
+		}
+		outputs = append(outputs, gpio.Output{
+			Name:        o.Name,
+			Driver:      driver,
+			Initial:     o.Initial,
+			Remote:      o.Remote,
+			Exclusive:   o.Exclusive,
+			MinInterval: o.MinInterval,
+			MaxOn:       o.MaxOn,
+			MaxHold:     o.MaxHold,
+			CycleOff:    o.CycleOff,
+		})
+	}
+	ctl, err := gpio.NewController(outputs, c.gpioChanged)
+	if err != nil {
+		return nil, err
+	}
+	return &gpioOutputs{ctl: ctl}, nil
+}
+
+// Map reports the outputs and command counters for the heartbeat
+func (g *gpioOutputs) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"outputs":  g.ctl.States(),
+		"applied":  g.applied.Load(),
+		"rejected": g.rejected.Load(),
+		"failed":   g.failed.Load(),
+	}
+}
+
+// apply runs an action on an output
+func (g *gpioOutputs) apply(output, action string, hold time.Duration, source string, remote bool) error {
+	if action == gpio.Cycle {
+		return g.ctl.Cycle(output, hold, source, remote)
+	}
+	return g.ctl.Apply(output, action == gpio.On, hold, source, remote)
+}
+
+// gpioTopic returns the command topic
+func (c *Collector) gpioTopic() string {
+	return c.expandTopic(c.config.GPIO.Topic, "gpio")
+}
+
+// subscribeGPIO accepts output commands from the Control Plane
+func (c *Collector) subscribeGPIO(client mqtt.Client) {
+	topic := c.gpioTopic()
+	token := client.Subscribe(topic, 1, c.handleGPIOMessage)
+	if token.Wait() && token.Error() != nil {
+		c.logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to subscribe to GPIO topic")
+		c.reportError("gpio", token.Error())
+	}
+}
+
+// handleGPIOMessage switches an output on command. Every command is
+// answered with a gpio_command event and recorded in the audit log
+func (c *Collector) handleGPIOMessage(_ mqtt.Client, msg mqtt.Message) {
+	g := c.gpio
+	req, err := gpio.Parse(msg.Payload())
+	if err != nil {
+		g.rejected.Add(1)
+		c.logger.WithError(err).Warn("Ignoring invalid GPIO command")
+		c.reportError("gpio", err)
+		return
+	}
+
+	fields := map[string]interface{}{"command_id": req.ID, "output": req.Output, "action": req.Action}
+	if req.Hold() > 0 {
+		fields["duration"] = req.Duration
+	}
+	status, result := "applied", "applied"
+	if req.Expired(time.Now()) {
+		status, result = "expired", "rejected"
+		g.rejected.Add(1)
+	} else if err = g.apply(req.Output, req.Action, req.Hold(), "command", true); err != nil {
+		status, result = c.gpioFailure(err)
+		fields["error"] = err.Error()
+	} else {
+		g.applied.Add(1)
+	}
+
+	logger := c.logger.WithFields(logrus.Fields(fields))
+	if err != nil {
+		logger.Warn("GPIO command not applied")
+	} else if status == "applied" {
+		logger.Info("GPIO command applied")
+	}
+	if _, aErr := c.audit.Append("control-plane", "gpio", req.Output, result, fields); aErr != nil {
+		c.logger.WithError(aErr).Warn("Failed to write audit entry")
+	}
+	fields["status"] = status
+	c.publishEvent("gpio_command", fields)
+}
+
+// gpioFailure counts a refused or failed change and returns its status and
+// audit result
+func (c *Collector) gpioFailure(err error) (string, string) {
+	g := c.gpio
+	if errors.Is(err, gpio.ErrInterlock) || errors.Is(err, gpio.ErrNotRemote) || errors.Is(err, gpio.ErrUnknownOutput) {
+		g.rejected.Add(1)
+		return "rejected", "rejected"
+	}
+	g.failed.Add(1)
+	c.reportError("gpio", err)
+	return "failed", "failed"
+}
+
+// applyGPIORules switches the outputs of the rules an event matches. Rules
+// are subject to the interlocks of their output, which keep a recurring
+// event from toggling it
+func (c *Collector) applyGPIORules(name string, fields map[string]interface{}) {
+	if c.gpio == nil {
+		return
+	}
+	for _, r := range c.config.GPIO.Rules {
+		if r.Event != name || !matchFields(r.Match, fields) {
+			continue
+		}
+		source := "rule:" + r.Name
+		err := c.gpio.apply(r.Output, r.Action, r.Duration, source, false)
+		details := map[string]interface{}{"rule": r.Name, "event": name, "action": r.Action}
+		if r.Duration > 0 {
+			details["duration"] = r.Duration.String()
+		}
+		result := "applied"
+		if err != nil {
+			_, result = c.gpioFailure(err)
+			details["error"] = err.Error()
+			c.logger.WithError(err).WithFields(logrus.Fields{"rule": r.Name, "output": r.Output}).Info("GPIO rule not applied")
+		} else {
+			c.gpio.applied.Add(1)
+		}
+		if _, aErr := c.audit.Append("local", "gpio", r.Output, result, details); aErr != nil {
+			c.logger.WithError(aErr).Warn("Failed to write audit entry")
+		}
+	}
+}
+
+// matchFields reports whether the event has every field of match
+func matchFields(match map[string]string, fields map[string]interface{}) bool {
+	for name, want := range match {
+		v, ok := fields[name]
+		if !ok || fmt.Sprint(v) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// gpioChanged publishes a gpio_changed event for every switch. Automatic
+// restores and max-on switches are recorded in the audit log here, as
+// nobody asked for them
+func (c *Collector) gpioChanged(ch gpio.Change) {
+	state := gpio.Off
+	if ch.On {
+		state = gpio.On
+	}
+	fields := map[string]interface{}{"output": ch.Output, "state": state, "source": ch.Source}
+	logger := c.logger.WithFields(logrus.Fields(fields))
+	if ch.Err != nil {
+		fields["error"] = ch.Err.Error()
+		logger.WithError(ch.Err).Error("Failed to switch GPIO output")
+		c.reportError("gpio", ch.Err)
+	} else {
+		logger.Info("GPIO output switched")
+	}
+
+	switch ch.Source {
+	case "initial":
+		// Before the connection; the first heartbeat reports the state
+		return
+	case "restore", "max_on":
+		result := "applied"
+		if ch.Err != nil {
+			result = "failed"
+		}
+		if _, aErr := c.audit.Append("local", "gpio."+ch.Source, ch.Output, result, nil); aErr != nil {
+			c.logger.WithError(aErr).Warn("Failed to write audit entry")
+		}
+	}
+	c.publishEvent("gpio_changed", fields)
+}
@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/inventory"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+// inventoryState remembers the last snapshot sent, so a restart does not
+// send an unchanged inventory again
+type inventoryState struct {
+	Time     int64             `json:"time"`
+	Digest   string            `json:"digest"`
+	Sections map[string]string `json:"sections"` // Hash per section
+}
+
+// inventoryStatePath returns where the last snapshot is remembered
+func (c *Collector) inventoryStatePath() string {
+	return filepath.Join(state.Resolve(c.config.State).Dir, "inventory.json")
+}
+
+// inventoryLoop sends a snapshot at startup, unless the last one is recent
+// and nothing changed, and then every interval
+func (c *Collector) inventoryLoop(ctx context.Context) {
+	defer c.wg.Done()
+	c.sendInventory(false)
+
+	ticker := time.NewTicker(c.config.Inventory.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.sendInventory(true)
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// sendInventory collects and publishes a snapshot. The record lists the
+// sections changed since the last snapshot under "changed"
+func (c *Collector) sendInventory(force bool) {
+	span := c.resources.Start("input.inventory")
+	snapshot, errs := inventory.Collect(c.config.Inventory.Sections)
+	span.End(1)
+	for section, err := range errs {
+		c.reportError("inventory."+section, err)
+	}
+
+	hashes := inventory.Hashes(snapshot)
+	digest := inventory.Digest(hashes)
+	prev, err := c.loadInventoryState()
+	if err != nil {
+		c.logger.WithError(err).Warn("Failed to read last inventory snapshot")
+	}
+	if !force && prev != nil && prev.Digest == digest &&
+		time.Since(time.Unix(prev.Time, 0)) < c.config.Inventory.Interval {
+		c.logger.Debug("Inventory unchanged since the last snapshot")
+		return
+	}
+
+	var changed []string
+	if prev != nil {
+		for section, hash := range hashes {
+			if prev.Sections[section] != hash {
+				changed = append(changed, section)
+			}
+		}
+		sort.Strings(changed)
+	}
+
+	data := map[string]interface{}{
+		"digest":   digest,
+		"sections": hashes,
+		"changed":  changed,
+	}
+	for section, v := range snapshot {
+		data[section] = v
+	}
+	if err := c.sendTelemetry("inventory", c.newTelemetry("inventory", data)); err != nil {
+		c.logger.WithError(err).Warn("Failed to send inventory")
+		return
+	}
+	c.logger.WithFields(logrus.Fields{"digest": digest, "changed": changed}).Info("Sent inventory snapshot")
+
+	next := inventoryState{Time: time.Now().Unix(), Digest: digest, Sections: hashes}
+	if err := c.saveInventoryState(next); err != nil {
+		c.logger.WithError(err).Warn("Failed to remember inventory snapshot")
+	}
+}
+
+// loadInventoryState returns the last snapshot sent, nil before the first
+func (c *Collector) loadInventoryState() (*inventoryState, error) {
+	data, err := os.ReadFile(c.inventoryStatePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st inventoryState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// saveInventoryState remembers the snapshot sent
+func (c *Collector) saveInventoryState(st inventoryState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	path := c.inventoryStatePath()
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
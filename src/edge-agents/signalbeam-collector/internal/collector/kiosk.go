@@ -0,0 +1,181 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/kiosk"
+	"github.com/sirupsen/logrus"
+)
+
+// kioskCommandTimeout bounds the restart command
+const kioskCommandTimeout = time.Minute
+
+// kioskMonitor probes the kiosk application and restarts it when it stays
+// unhealthy
+type kioskMonitor struct {
+	probe *kiosk.Probe
+
+	mu          sync.Mutex
+	healthy     bool
+	failures    int       // Probes failed in a row
+	since       time.Time // Of the current health state
+	process     kiosk.ProcessResult
+	lastRestart time.Time
+
+	probes     atomic.Int64
+	restarts   atomic.Int64 // Process restarts seen
+	recoveries atomic.Int64 // Restart commands run
+}
+
+// newKiosk creates the monitor. The kiosk starts out healthy, so a probe
+// failing at startup takes failures probes to be reported
+func (c *Collector) newKiosk() *kioskMonitor {
+	cfg := c.config.Kiosk
+	return &kioskMonitor{
+		probe: &kiosk.Probe{
+			URL:      cfg.URL,
+			Status:   cfg.Status,
+			Contains: cfg.Contains,
+			Process:  cfg.Process,
+			CDP: kiosk.CDP{
+				URL:        cfg.CDP.URL,
+				Page:       cfg.CDP.Page,
+				Expression: cfg.CDP.Expression,
+			},
+			Timeout: cfg.Timeout,
+			Client:  &http.Client{},
+		},
+		healthy: true,
+		since:   time.Now(),
+	}
+}
+
+// Map reports the health and counters for the heartbeat
+func (k *kioskMonitor) Map() map[string]interface{} {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return map[string]interface{}{
+		"healthy":    k.healthy,
+		"failures":   k.failures,
+		"probes":     k.probes.Load(),
+		"restarts":   k.restarts.Load(),
+		"recoveries": k.recoveries.Load(),
+	}
+}
+
+// kioskLoop probes the kiosk every interval
+func (c *Collector) kioskLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.config.Kiosk.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.probeKiosk(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// probeKiosk runs the checks and sends their results as metrics. Events
+// report the kiosk turning unhealthy after failures probes in a row, its
+// recovery, and restarts of its process
+func (c *Collector) probeKiosk(ctx context.Context) {
+	cfg := c.config.Kiosk
+	k := c.kiosk
+	span := c.resources.Start("input.kiosk")
+	result := k.probe.Run(ctx)
+	span.End(1)
+	if ctx.Err() != nil {
+		return
+	}
+	k.probes.Add(1)
+	now := time.Now()
+
+	var events []func()
+	restart := false
+	k.mu.Lock()
+	if p := result.Process; p != nil && p.Running {
+		if prev := k.process; prev.Running && (prev.PID != p.PID || !prev.Started.Equal(p.Started)) {
+			k.restarts.Add(1)
+			fields := map[string]interface{}{"process": cfg.Process, "pid": p.PID, "previous_pid": prev.PID}
+			events = append(events, func() { c.publishEvent("kiosk_restarted", fields) })
+		}
+		k.process = *p
+	}
+	if result.Healthy {
+		k.failures = 0
+		if !k.healthy {
+			fields := map[string]interface{}{"unhealthy_s": int64(now.Sub(k.since).Seconds())}
+			k.healthy, k.since = true, now
+			events = append(events, func() {
+				c.logger.WithFields(logrus.Fields(fields)).Info("Kiosk recovered")
+				c.publishEvent("kiosk_healthy", fields)
+			})
+		}
+	} else {
+		k.failures++
+		if k.healthy && k.failures >= cfg.Failures {
+			fields := map[string]interface{}{"reason": result.Reason, "failures": k.failures}
+			k.healthy, k.since = false, now
+			events = append(events, func() {
+				c.logger.WithFields(logrus.Fields(fields)).Warn("Kiosk unhealthy")
+				c.publishEvent("kiosk_unhealthy", fields)
+			})
+		}
+		if !k.healthy && len(cfg.RestartCommand) > 0 && now.Sub(k.lastRestart) >= cfg.RestartInterval {
+			k.lastRestart = now
+			restart = true
+		}
+	}
+	k.mu.Unlock()
+
+	for _, publish := range events {
+		publish()
+	}
+	if restart {
+		c.restartKiosk(ctx, result.Reason)
+	}
+
+	data := map[string]interface{}{"kiosk": result.Map()}
+	if err := c.sendTelemetry("metrics", c.newTelemetry("metrics", data)); err != nil {
+		c.logger.WithError(err).Warn("Failed to send kiosk health")
+	}
+}
+
+// restartKiosk runs the restart command, answered with a kiosk_recovery
+// event and recorded in the audit log
+func (c *Collector) restartKiosk(ctx context.Context, reason string) {
+	args := c.config.Kiosk.RestartCommand
+	c.kiosk.recoveries.Add(1)
+	ctx, cancel := context.WithTimeout(ctx, kioskCommandTimeout)
+	defer cancel()
+
+	fields := map[string]interface{}{"reason": reason, "command": strings.Join(args, " ")}
+	status := "applied"
+	if out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput(); err != nil {
+		status = "failed"
+		err = fmt.Errorf("%s: %w (%s)", args[0], err, strings.TrimSpace(string(out)))
+		fields["error"] = err.Error()
+		c.logger.WithError(err).Warn("Failed to restart kiosk")
+		c.reportError("kiosk", err)
+	} else {
+		c.logger.WithField("reason", reason).Info("Restarted kiosk")
+	}
+	if _, aErr := c.audit.Append("local", "kiosk.restart", args[0], status, fields); aErr != nil {
+		c.logger.WithError(aErr).Warn("Failed to write audit entry")
+	}
+	fields["status"] = status
+	c.publishEvent("kiosk_recovery", fields)
+}
@@ -0,0 +1,289 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+)
+
+// linkQuality tracks uplink connection metrics so data gaps can be
+// attributed to the link rather than the device
+type linkQuality struct {
+	mu            sync.Mutex
+	attemptStart  time.Time
+	connectTime   time.Duration
+	dialTime      time.Duration
+	tlsHandshake  time.Duration
+	reconnects    int64
+	attempts      int64 // Connection attempts while the link was down
+	outage        int   // Attempts in the current outage
+	lost          bool
+	echoRTT       time.Duration
+	echoSent      int64
+	echoReceived  int64
+	lastConnected time.Time
+}
+
+// attempt marks the start of a connection attempt
+func (l *linkQuality) attempt() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attemptStart = time.Now()
+	if l.lost {
+		l.attempts++
+		l.outage++
+	}
+}
+
+// disconnected counts a lost connection; following attempts count as
+// reconnect attempts. Repeated calls within one outage are ignored
+func (l *linkQuality) disconnected() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lost {
+		return
+	}
+	l.reconnects++
+	l.lost = true
+	l.outage = 0
+}
+
+// outageAttempts returns the connection attempts made in the current outage
+func (l *linkQuality) outageAttempts() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.outage
+}
+
+// connected records the time from attempt to CONNACK and reports whether
+// the connection was re-established after it was lost
+func (l *linkQuality) connected() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.attemptStart.IsZero() {
+		l.connectTime = time.Since(l.attemptStart)
+	}
+	l.lastConnected = time.Now().UTC()
+	reconnected := l.lost
+	l.lost = false
+	return reconnected
+}
+
+// dialed records transport level timings
+func (l *linkQuality) dialed(dial, handshake time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dialTime = dial
+	l.tlsHandshake = handshake
+}
+
+// echoed records a round-trip through the broker
+func (l *linkQuality) echoed(rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.echoRTT = rtt
+	l.echoReceived++
+}
+
+// probeSent counts an echo probe
+func (l *linkQuality) probeSent() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.echoSent++
+}
+
+// Map returns the link metrics for the heartbeat payload
+func (l *linkQuality) Map() map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	m := map[string]interface{}{
+		"reconnects":         l.reconnects,
+		"reconnect_attempts": l.attempts,
+		"connect_ms":         durationMillis(l.connectTime),
+		"dial_ms":            durationMillis(l.dialTime),
+		"echo_sent":          l.echoSent,
+		"echo_received":      l.echoReceived,
+	}
+	if l.tlsHandshake > 0 {
+		m["tls_handshake_ms"] = durationMillis(l.tlsHandshake)
+	}
+	if l.echoRTT > 0 {
+		m["echo_rtt_ms"] = durationMillis(l.echoRTT)
+	}
+	if !l.lastConnected.IsZero() {
+		m["last_connected"] = l.lastConnected.Unix()
+	}
+	return m
+}
+
+// durationMillis converts a duration to fractional milliseconds
+func durationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// openConnection dials the broker and records dial and TLS handshake timings
+func (c *Collector) openConnection(uri *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+	return c.dialBroker(uri, options, c.link.dialed)
+}
+
+// dialBroker dials the broker through the proxy and egress allowlist and
+// passes the dial and TLS handshake timings to dialed
+func (c *Collector) dialBroker(uri *url.URL, options mqtt.ClientOptions, dialed func(dial, handshake time.Duration)) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: options.ConnectTimeout}
+
+	switch uri.Scheme {
+	case "ws", "wss":
+		if err := c.checkEgress(uri); err != nil {
+			return nil, err
+		}
+		dialURI := *uri
+		dialURI.User = nil
+		var tlsc *tls.Config
+		if uri.Scheme == "wss" {
+			tlsc = options.TLSConfig
+		}
+		wsOpts := options.WebsocketOptions
+		if proxy := c.mqttProxy.websocketProxy(); proxy != nil {
+			o := mqtt.WebsocketOptions{}
+			if wsOpts != nil {
+				o = *wsOpts
+			}
+			o.Proxy = proxy
+			wsOpts = &o
+		}
+		return mqtt.NewWebsocket(dialURI.String(), tlsc, options.ConnectTimeout, options.HTTPHeaders, wsOpts)
+	case "unix":
+		path := uri.Host
+		if path == "" {
+			path = uri.Path
+		}
+		return dialer.Dial("unix", path)
+	}
+
+	start := time.Now()
+	var conn net.Conn
+	var err error
+	if c.mqttProxy.dialer != nil {
+		ctx := context.Background()
+		if options.ConnectTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, options.ConnectTimeout)
+			defer cancel()
+		}
+		conn, err = c.dial(c.mqttProxy)(ctx, "tcp", uri.Host)
+	} else {
+		conn, err = c.egress.Dial(context.Background(), dialer, "tcp", uri.Host)
+		c.reportEgress(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dialTime := time.Since(start)
+
+	switch uri.Scheme {
+	case "mqtt", "tcp":
+		dialed(dialTime, 0)
+		return conn, nil
+	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps":
+		tlsc := options.TLSConfig
+		if tlsc == nil {
+			tlsc = &tls.Config{}
+		}
+		if tlsc.ServerName == "" {
+			tlsc = tlsc.Clone()
+			tlsc.ServerName = uri.Hostname()
+		}
+
+		start = time.Now()
+		tlsConn := tls.Client(conn, tlsc)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		dialed(dialTime, time.Since(start))
+		return tlsConn, nil
+	}
+
+	conn.Close()
+	return nil, errors.New("unknown protocol: " + uri.Scheme)
+}
+
+// checkEgress verifies a websocket broker URL against the egress allowlist
+func (c *Collector) checkEgress(uri *url.URL) error {
+	port, _ := strconv.Atoi(uri.Port())
+	if port == 0 {
+		port = 80
+		if uri.Scheme == "wss" {
+			port = 443
+		}
+	}
+	err := c.egress.Check(uri.Hostname(), port)
+	c.reportEgress(err)
+	return err
+}
+
+// waitReconnect delays reconnect attempt n of the current outage by the
+// jittered backoff. When the collector stops meanwhile the reconnect is
+// abandoned, which ends the client's retry loop after this attempt
+func (c *Collector) waitReconnect(n int) {
+	delay := c.reconnect.Delay(n)
+	c.logger.WithField("delay", delay.Round(time.Millisecond)).Info("Reconnecting to MQTT broker")
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-c.stopCh:
+		c.mqttClient.Disconnect(0)
+	}
+}
+
+// reportEgress records blocked connection attempts in diagnostics
+func (c *Collector) reportEgress(err error) {
+	var v *egress.Violation
+	if errors.As(err, &v) {
+		c.logger.WithField("address", v.Address).Warn("Blocked outbound connection not on the egress allowlist")
+		c.reportError("egress", err)
+	}
+}
+
+// subscribeEcho listens for echo probes returned by the broker
+func (c *Collector) subscribeEcho(client mqtt.Client) {
+	topic := c.getTopicName("echo")
+	token := client.Subscribe(topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+		sent, err := strconv.ParseInt(string(msg.Payload()), 10, 64)
+		if err != nil {
+			return
+		}
+		c.link.echoed(time.Since(time.Unix(0, sent)))
+	})
+	if token.Wait() && token.Error() != nil {
+		c.logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to subscribe to echo topic")
+	}
+}
+
+// sendEchoProbe publishes a timestamp to the echo topic to measure broker RTT
+func (c *Collector) sendEchoProbe() {
+	topic := c.getTopicName("echo")
+	payload := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	token := c.mqttClient.Publish(topic, 0, false, payload)
+	if !token.WaitTimeout(c.config.MQTT.PublishTimeout) {
+		c.logger.WithField("topic", topic).Debug("Echo probe not sent in time")
+		return
+	}
+	if token.Error() != nil {
+		c.logger.WithError(token.Error()).WithField("topic", topic).Debug("Failed to send echo probe")
+		return
+	}
+	c.link.probeSent()
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// localBridgeRetry is the delay between attempts to reach the local broker,
+// which may start after the collector
+const localBridgeRetry = 10 * time.Second
+
+// localBridge republishes what applications on the device publish on a
+// local broker as telemetry of the device
+type localBridge struct {
+	client mqtt.Client
+
+	forwarded atomic.Int64
+	dropped   atomic.Int64
+}
+
+// newLocalBridge creates the connection to the local broker. It connects
+// in the background and keeps retrying, so the collector does not depend
+// on the local broker being up
+func (c *Collector) newLocalBridge() *localBridge {
+	cfg := c.config.Bridge.Local
+	b := &localBridge{}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(cfg.Broker)
+	opts.SetClientID(cfg.ClientID)
+	opts.SetUsername(cfg.Username)
+	opts.SetPassword(cfg.Password)
+	opts.SetConnectTimeout(c.config.MQTT.Timeout)
+	opts.SetKeepAlive(30 * time.Second)
+	opts.SetCleanSession(true)
+	opts.SetConnectRetry(true)
+	opts.SetConnectRetryInterval(localBridgeRetry)
+	opts.SetMaxReconnectInterval(localBridgeRetry)
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		c.logger.WithError(err).Warn("Local MQTT broker connection lost")
+	})
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		c.logger.WithField("broker", cfg.Broker).Info("Connected to local MQTT broker")
+		for _, t := range cfg.Topics {
+			token := client.Subscribe(t.Filter, t.QoS, func(_ mqtt.Client, msg mqtt.Message) {
+				c.handleLocalPublish(t, msg)
+			})
+			if token.Wait() && token.Error() != nil {
+				c.logger.WithError(token.Error()).WithField("topic", t.Filter).Warn("Failed to subscribe on local MQTT broker")
+				c.reportError("bridge.local", token.Error())
+			}
+		}
+	})
+	b.client = mqtt.NewClient(opts)
+	return b
+}
+
+// Map reports the bridge for the heartbeat
+func (b *localBridge) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"connected": b.client.IsConnected(),
+		"forwarded": b.forwarded.Load(),
+		"dropped":   b.dropped.Load(),
+	}
+}
+
+// handleLocalPublish sends a local publish upstream as a record of the
+// topic's data type. A JSON object becomes the record's fields; any other
+// payload is sent as a string value. The local topic and the topic's tags
+// are added, the device, timestamp and device tags as for every record
+func (c *Collector) handleLocalPublish(t config.LocalBridgeTopic, msg mqtt.Message) {
+	b := c.localBridge
+	logger := c.logger.WithFields(logrus.Fields{"topic": msg.Topic(), "type": t.Type})
+	if n := len(msg.Payload()); n > c.config.Bridge.Local.MaxPayload {
+		b.dropped.Add(1)
+		logger.WithField("bytes", n).Warn("Dropping oversized local publish")
+		c.reportError("bridge.local", fmt.Errorf("publish on %s of %d bytes exceeds max_payload", msg.Topic(), n))
+		return
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(msg.Payload(), &fields); err != nil || fields == nil {
+		fields = map[string]interface{}{"value": string(msg.Payload())}
+	}
+	telemetry := c.newTelemetry(t.Type, map[string]interface{}{
+		"source": "local",
+		"topic":  msg.Topic(),
+		"fields": fields,
+	})
+	if len(t.Tags) > 0 {
+		tags := maps.Clone(telemetry.Tags)
+		if tags == nil {
+			tags = make(map[string]string, len(t.Tags))
+		}
+		maps.Copy(tags, t.Tags)
+		telemetry.Tags = tags
+	}
+
+	if err := c.sendTelemetry(t.Type, telemetry); err != nil {
+		b.dropped.Add(1)
+		logger.WithError(err).Warn("Failed to forward local publish")
+		return
+	}
+	b.forwarded.Add(1)
+}
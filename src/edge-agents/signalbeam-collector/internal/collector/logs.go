@@ -0,0 +1,108 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/logarchive"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/logtail"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/supervisor"
+)
+
+// newLogTailer follows the configured log files, keeping offsets in the
+// state directory
+func (c *Collector) newLogTailer(cfg config.LogsConfig) (*logtail.Tailer, error) {
+	return logtail.New(cfg.Paths, cfg.Exclude, cfg.MaxLines, state.Resolve(c.config.State).Offsets)
+}
+
+// newLogArchive opens the local log archive
+func (c *Collector) newLogArchive(cfg config.LogArchiveConfig) (*logarchive.Archive, error) {
+	return logarchive.Open(logarchive.Options{
+		Dir:          LogArchiveDir(c.config),
+		SegmentBytes: cfg.SegmentBytes,
+		SegmentAge:   cfg.SegmentAge,
+		MaxBytes:     cfg.MaxBytes,
+		MaxAge:       cfg.MaxAge,
+		MinAge:       cfg.MinAge,
+	})
+}
+
+// LogArchiveDir returns the directory of the local log archive
+func LogArchiveDir(cfg *config.Config) string {
+	if dir := cfg.Collection.Logs.Archive.Dir; dir != "" {
+		return dir
+	}
+	return filepath.Join(state.Resolve(cfg.State).Dir, "log-archive")
+}
+
+// collectLogs periodically sends the lines appended to the log files
+func (c *Collector) collectLogs(ctx context.Context, h *supervisor.Handle) error {
+	interval := c.collectionInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.gatherAndSendLogs(); err != nil {
+				h.Fail(err)
+			} else {
+				h.OK()
+			}
+			interval = c.adaptTicker(ticker, interval)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// gatherAndSendLogs sends one logs record per file with new lines. Lines
+// are archived before they are published, so the archive keeps them when
+// the uplink is down
+func (c *Collector) gatherAndSendLogs() error {
+	span := c.resources.Start("input.logs")
+	batches, err := c.logTailer.Read()
+	span.End(len(batches))
+	if err != nil {
+		c.reportError("logs", err)
+	}
+
+	now := time.Now().UTC()
+	for _, b := range batches {
+		if c.logArchive != nil {
+			if aErr := c.logArchive.Append(b.Path, now, b.Lines); aErr != nil {
+				c.reportError("logs.archive", aErr)
+			}
+		}
+
+		data := map[string]interface{}{
+			"source": b.Path,
+			"lines":  b.Lines,
+			"count":  len(b.Lines),
+		}
+		if b.Cut > 0 {
+			data["cut"] = b.Cut
+		}
+		if sErr := c.sendTelemetry("logs", c.newTelemetry("logs", data)); sErr != nil {
+			c.logger.WithError(sErr).WithField("source", b.Path).Warn("Failed to send logs")
+		}
+	}
+
+	if sErr := c.logTailer.Save(); sErr != nil {
+		c.reportError("logs.offsets", sErr)
+		return fmt.Errorf("failed to save log offsets: %w", sErr)
+	}
+	if c.logArchive != nil {
+		if aErr := c.logArchive.Tick(now); aErr != nil {
+			c.reportError("logs.archive", aErr)
+		}
+		if c.logArchive.OverBudget() {
+			c.reportError("logs.archive", fmt.Errorf("archive exceeds max_bytes, segments are kept for min_age"))
+		}
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/notify"
+	"github.com/sirupsen/logrus"
+)
+
+// notifications relays critical events to local mail and SMS gateways
+type notifications struct {
+	cfg      config.NotifyConfig
+	throttle *notify.Throttle
+	senders  []notify.Sender
+	queue    chan notify.Notification
+
+	sent       atomic.Int64
+	failed     atomic.Int64
+	duplicate  atomic.Int64
+	suppressed atomic.Int64
+	dropped    atomic.Int64
+}
+
+// newNotifications sets up the enabled gateways. Mail goes through the
+// egress allowlist
+func (c *Collector) newNotifications(cfg config.NotifyConfig) (*notifications, error) {
+	n := &notifications{
+		cfg:      cfg,
+		throttle: notify.NewThrottle(cfg.DedupWindow, cfg.Cooldown),
+		queue:    make(chan notify.Notification, 64),
+	}
+	if cfg.SMTP.Enabled {
+		s := &notify.SMTP{
+			Address:  cfg.SMTP.Address,
+			From:     cfg.SMTP.From,
+			To:       cfg.SMTP.To,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			Dial:     c.dialOutput,
+		}
+		if cfg.SMTP.StartTLS {
+			tlsCfg, err := mqttTLSConfig(cfg.SMTP.TLS)
+			if err != nil {
+				return nil, fmt.Errorf("notifications.smtp.tls: %w", err)
+			}
+			s.TLS = tlsCfg
+		}
+		n.senders = append(n.senders, s)
+	}
+	if cfg.SMS.Enabled {
+		n.senders = append(n.senders, &notify.Modem{
+			Device: cfg.SMS.Device,
+			Baud:   cfg.SMS.Baud,
+			PIN:    cfg.SMS.PIN,
+			To:     cfg.SMS.To,
+		})
+	}
+	return n, nil
+}
+
+// Map returns the notification counters for the heartbeat
+func (n *notifications) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"sent":       n.sent.Load(),
+		"failed":     n.failed.Load(),
+		"duplicate":  n.duplicate.Load(),
+		"suppressed": n.suppressed.Load(),
+		"dropped":    n.dropped.Load(),
+	}
+}
+
+// notifyEvent queues a notification for a configured event. While the
+// uplink is up the cloud is trusted to alert, unless notifications.always
+// is set
+func (c *Collector) notifyEvent(name string, fields map[string]interface{}) {
+	n := c.notifications
+	if n == nil || !slices.Contains(n.cfg.Events, name) {
+		return
+	}
+	if !n.cfg.Always && c.mqttClient.IsConnectionOpen() {
+		return
+	}
+
+	key := name
+	subject := []string{fmt.Sprintf("SignalBeam %s: %s", c.config.Device.ID, name)}
+	for _, field := range []string{"state", "path"} {
+		if v, ok := fields[field]; ok {
+			subject = append(subject, fmt.Sprint(v))
+		}
+	}
+	if path, ok := fields["path"]; ok {
+		key += ":" + fmt.Sprint(path)
+	}
+	note := notify.Notification{
+		Key:     key,
+		Subject: strings.Join(subject, " "),
+		Fields:  fields,
+		Time:    time.Now(),
+	}
+
+	switch n.throttle.Check(&note) {
+	case notify.Duplicate:
+		n.duplicate.Add(1)
+		return
+	case notify.Suppressed:
+		n.suppressed.Add(1)
+		return
+	}
+	select {
+	case n.queue <- note:
+	default:
+		n.dropped.Add(1)
+		c.logger.WithField("event", name).Warn("Notification queue full, dropping notification")
+	}
+}
+
+// notifyLoop delivers queued notifications through every gateway
+func (c *Collector) notifyLoop(ctx context.Context) {
+	defer c.wg.Done()
+	n := c.notifications
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		case note := <-n.queue:
+			for _, s := range n.senders {
+				sendCtx, cancel := context.WithTimeout(ctx, n.cfg.Timeout)
+				err := s.Send(sendCtx, note)
+				cancel()
+
+				logger := c.logger.WithFields(logrus.Fields{"gateway": s.Name(), "subject": note.Subject})
+				if err != nil {
+					n.failed.Add(1)
+					logger.WithError(err).Warn("Failed to send notification")
+					c.reportError("notifications."+s.Name(), err)
+					continue
+				}
+				n.sent.Add(1)
+				logger.Info("Sent notification")
+			}
+		}
+	}
+}
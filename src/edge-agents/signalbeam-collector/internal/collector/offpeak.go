@@ -0,0 +1,132 @@
+package collector
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/schedule"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// offPeakQueue holds records of heavy data types until an off-peak window
+type offPeakQueue struct {
+	windows schedule.Windows
+
+	mu       sync.Mutex
+	held     []heldRecord
+	deferred int64 // Records held since the start
+	released int64
+	dropped  int64 // Oldest records dropped at the limit
+}
+
+// heldRecord is a record waiting for its window
+type heldRecord struct {
+	dataType  string
+	telemetry TelemetryData
+}
+
+// newOffPeakQueue parses the windows, validated with the configuration
+func (c *Collector) newOffPeakQueue() (*offPeakQueue, error) {
+	windows, err := schedule.Parse(c.config.OffPeak.Windows)
+	if err != nil {
+		return nil, err
+	}
+	return &offPeakQueue{windows: windows}, nil
+}
+
+// Map reports the held records for the heartbeat
+func (q *offPeakQueue) Map() map[string]interface{} {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := map[string]interface{}{
+		"open":     q.windows.Contains(now),
+		"held":     len(q.held),
+		"deferred": q.deferred,
+		"released": q.released,
+		"dropped":  q.dropped,
+	}
+	if next := q.windows.Next(now); !next.IsZero() {
+		out["next_window"] = next.Unix()
+	}
+	return out
+}
+
+// holdOffPeak keeps a record of a heavy data type back while no off-peak
+// window is open and reports whether it did
+func (c *Collector) holdOffPeak(dataType string, telemetry TelemetryData, tr *trace.Trace) bool {
+	q := c.offPeak
+	if q == nil || !slices.Contains(c.config.OffPeak.Types, dataType) || q.windows.Contains(time.Now()) {
+		return false
+	}
+	tr.Step("off_peak", trace.Passed, "held until the next off-peak window")
+	if c.dryRun {
+		return true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.held) >= c.config.OffPeak.MaxRecords {
+		q.held[0] = heldRecord{}
+		q.held = q.held[1:]
+		q.dropped++
+		c.reportDropped(dataType)
+	}
+	q.held = append(q.held, heldRecord{dataType: dataType, telemetry: telemetry})
+	q.deferred++
+	return true
+}
+
+// offPeakLoop releases the held records once a window opens
+func (c *Collector) offPeakLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.releaseOffPeak(ctx)
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// releaseOffPeak sends held records, oldest first, while the window stays
+// open. Records go through the pipeline from where they were held
+func (c *Collector) releaseOffPeak(ctx context.Context) {
+	q := c.offPeak
+	sent := 0
+	for ctx.Err() == nil && q.windows.Contains(time.Now()) {
+		q.mu.Lock()
+		if len(q.held) == 0 {
+			q.mu.Unlock()
+			break
+		}
+		r := q.held[0]
+		q.held[0] = heldRecord{}
+		q.held = q.held[1:]
+		q.released++
+		q.mu.Unlock()
+
+		if err := c.sendTelemetry(r.dataType, r.telemetry); err != nil {
+			c.logger.WithError(err).WithField("type", r.dataType).Warn("Failed to send off-peak record")
+		}
+		sent++
+
+		select {
+		case <-c.stopCh:
+			return
+		default:
+		}
+	}
+	if sent > 0 {
+		c.logger.WithFields(logrus.Fields{"records": sent}).Info("Sent records held for the off-peak window")
+	}
+}
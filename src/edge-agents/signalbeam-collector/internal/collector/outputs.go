@@ -0,0 +1,259 @@
+package collector
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/output"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/routing"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/trace"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// dialOutput opens connections for network outputs through the egress
+// policy
+func (c *Collector) dialOutput(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := c.egress.Dial(ctx, &net.Dialer{}, network, address)
+	c.reportEgress(err)
+	return conn, err
+}
+
+// brokerSink is the primary transport as a sink: MQTT, the Edge Gateway or
+// the HTTPS fallback, whichever is current when a record is published
+type brokerSink struct {
+	c *Collector
+}
+
+// Publish hands the message over and returns; a delivery that fails later
+// is reported for its data type. With the buffer, messages the broker
+// cannot take now are kept for later
+func (b brokerSink) Publish(_ context.Context, msg output.Message) error {
+	c := b.c
+	transport := c.output()
+	if c.buffers(transport, msg) {
+		return c.bufferMessage(msg)
+	}
+	err := c.publishTo(transport, msg.Topic, msg.QoS, msg.Retained, msg.Payload, cmp.Or(msg.RawSize, len(msg.Payload)), func(err error) {
+		if err == nil {
+			return
+		}
+		// An unacknowledged publish may still arrive; buffering it again
+		// would duplicate it
+		if c.buffer != nil && !errors.Is(err, errPublishExpired) && c.bufferMessage(msg) == nil {
+			return
+		}
+		c.reportError("publish."+msg.Type, err)
+		c.reportDropped(msg.Type)
+	})
+	if errors.Is(err, errPublishQueueFull) && c.buffer != nil {
+		return c.bufferMessage(msg)
+	}
+	return err
+}
+
+// Close leaves the connection to the collector, which owns it
+func (b brokerSink) Close() error {
+	return nil
+}
+
+// publishRecord hands an encoded record to every sink, the primary
+// transport first and then the outputs beside it. Sinks fail independently;
+// the error returned is the primary transport's
+func (c *Collector) publishRecord(primary, dataType, deviceID string, route routing.Route, data []byte, raw int, tr *trace.Trace) error {
+	if err := c.checkTenant(route.Topic); err != nil {
+		tr.Step("tenant", trace.Dropped, err.Error())
+		c.reportError("tenant."+dataType, err)
+		c.reportDropped(dataType)
+		return err
+	}
+	if c.dryRun {
+		tr.Step("output."+primary, trace.Published, "dry run, not sent")
+		for _, o := range c.config.Outputs {
+			if route.Outputs != nil && slices.Contains(route.Outputs, o.Name) || route.Outputs == nil && (len(o.Types) == 0 || slices.Contains(o.Types, dataType)) {
+				tr.Step("output."+o.Name, trace.Published, "dry run, not sent")
+			}
+		}
+		return nil
+	}
+	if err := c.checkBudget(dataType, len(data), tr); err != nil {
+		return err
+	}
+
+	msg := output.Message{
+		Type:     dataType,
+		DeviceID: deviceID,
+		Topic:    route.Topic,
+		QoS:      route.QoS,
+		Retained: route.Retained,
+		Payload:  data,
+		RawSize:  raw,
+		Outputs:  route.Outputs,
+		Time:     time.Now(),
+	}
+	err := c.broker.Publish(c.publishCtx, msg)
+	if err != nil {
+		tr.Step("output."+primary, trace.Failed, err.Error())
+		c.reportError("publish."+dataType, err)
+		c.reportDropped(dataType)
+	} else {
+		c.resources.Count("output."+primary, 1)
+		tr.Step("output."+primary, trace.Published, "")
+	}
+
+	c.outputs.Publish(c.publishCtx, msg, func(name string, err error) {
+		if errors.Is(err, output.ErrBreakerOpen) {
+			c.delivery.dropped(name)
+			tr.Step("output."+name, trace.Dropped, err.Error())
+			return
+		}
+		c.delivery.published(name, raw, len(data))
+		if err != nil {
+			c.delivery.dropped(name)
+			c.reportError("output."+name, err)
+			tr.Step("output."+name, trace.Failed, err.Error())
+			return
+		}
+		c.delivery.acked(name)
+		c.resources.Count("output."+name, 1)
+		tr.Step("output."+name, trace.Published, "")
+	})
+	return err
+}
+
+// breakerChanged reports an output whose circuit opened or closed again
+func (c *Collector) breakerChanged(name, state string, err error) {
+	fields := map[string]interface{}{"output": name}
+	if state == output.BreakerClosed {
+		c.logger.WithFields(logrus.Fields(fields)).Info("Output recovered, circuit closed")
+		c.publishEvent("breaker_closed", fields)
+		return
+	}
+	fields["error"] = err.Error()
+	c.logger.WithFields(logrus.Fields(fields)).Warn("Output keeps failing, circuit open")
+	c.reportError("output."+name, fmt.Errorf("circuit open: %w", err))
+	c.publishEvent("breaker_opened", fields)
+}
+
+// ReloadOutputs applies the outputs of a reloaded configuration file.
+// Pushed outputs keep running
+func (c *Collector) ReloadOutputs(cfgs []config.OutputConfig) error {
+	changed, err := c.outputs.Sync(cfgs, output.FromConfig)
+	result := "applied"
+	if err != nil {
+		result = "failed"
+	}
+	if _, aErr := c.audit.Append("local", "outputs.reload", strings.Join(changed, ","), result, nil); aErr != nil {
+		c.logger.WithError(aErr).Warn("Failed to write audit entry")
+	}
+	if err != nil {
+		return err
+	}
+	c.logger.WithField("changed", changed).Info("Reloaded outputs")
+	return nil
+}
+
+// outputPushTopic returns the topic output definitions are pushed to
+func (c *Collector) outputPushTopic() string {
+	return c.expandTopic(c.config.OutputPush.Topic, "outputs")
+}
+
+// subscribeOutputs accepts output definitions pushed by the Control Plane
+func (c *Collector) subscribeOutputs(client mqtt.Client) {
+	topic := c.outputPushTopic()
+	token := client.Subscribe(topic, 1, c.handleOutputMessage)
+	if token.Wait() && token.Error() != nil {
+		c.logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to subscribe to output topic")
+	}
+}
+
+// handleOutputMessage starts, replaces or stops the output named by the last
+// topic level. An empty payload stops it. Definitions delivered again, e.g.
+// retained messages after a reconnect, change nothing and are not audited
+func (c *Collector) handleOutputMessage(_ mqtt.Client, msg mqtt.Message) {
+	name := msg.Topic()[strings.LastIndex(msg.Topic(), "/")+1:]
+
+	action := "output.remove"
+	var err error
+	if len(msg.Payload()) == 0 {
+		var existed bool
+		if existed, err = c.outputs.Remove(name); !existed && err == nil {
+			return
+		}
+	} else {
+		action, err = c.putPushedOutput(name, msg.Payload())
+	}
+	if action == "" {
+		return
+	}
+
+	result := "applied"
+	logger := c.logger.WithFields(logrus.Fields{"output": name, "action": action})
+	if err != nil {
+		result = "failed"
+		logger.WithError(err).Warn("Failed to apply pushed output")
+		c.reportError("outputs", err)
+	} else {
+		logger.Info("Applied pushed output")
+	}
+
+	if _, aErr := c.audit.Append("control-plane", action, name, result, nil); aErr != nil {
+		c.logger.WithError(aErr).Warn("Failed to write audit entry")
+	}
+}
+
+// putPushedOutput starts or replaces an output from a pushed definition and
+// returns the audited action, empty when the output already runs as defined
+func (c *Collector) putPushedOutput(name string, payload []byte) (string, error) {
+	var cfg config.OutputConfig
+	if err := yaml.Unmarshal(payload, &cfg); err != nil {
+		return "output.add", fmt.Errorf("invalid output definition: %w", err)
+	}
+	cfg.Name = name
+	if err := c.checkPushedOutput(cfg); err != nil {
+		return "output.add", err
+	}
+
+	if c.outputs.Running(cfg, output.FromPush) {
+		return "", nil
+	}
+	replaced, err := c.outputs.Put(cfg, output.FromPush)
+	if replaced {
+		return "output.update", err
+	}
+	return "output.add", err
+}
+
+// checkPushedOutput confines pushed file outputs to the allowed directories
+func (c *Collector) checkPushedOutput(cfg config.OutputConfig) error {
+	if cfg.Type != "file" {
+		return nil
+	}
+	roots := c.config.OutputPush.FileRoots
+	if len(roots) == 0 {
+		roots = []string{filepath.Join(state.Resolve(c.config.State).Dir, "outputs")}
+	}
+
+	for _, root := range roots {
+		if within(root, cfg.File.Path) {
+			return nil
+		}
+	}
+	return fmt.Errorf("file output path %s is outside the allowed roots %v", cfg.File.Path, roots)
+}
+
+// within reports whether path lies inside dir
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
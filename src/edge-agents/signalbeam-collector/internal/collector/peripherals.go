@@ -0,0 +1,270 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/peripheral"
+	"github.com/sirupsen/logrus"
+)
+
+// peripheralMonitor polls printers and watches USB peripherals
+type peripheralMonitor struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	printers map[string]*printerState
+	usb      map[string]bool                 // Presence of the expected devices by name
+	attached map[string]peripheral.USBDevice // By path, when no devices are expected
+
+	probes atomic.Int64
+	failed atomic.Int64 // Printer queries that failed
+}
+
+// printerState is what the last probe of a printer found, for changes
+type printerState struct {
+	healthy bool
+	paper   string
+	since   time.Time // Of the current health state
+}
+
+// newPeripherals creates the monitor. Printers and expected USB devices
+// start out healthy and present, so problems at startup are reported
+func (c *Collector) newPeripherals() *peripheralMonitor {
+	cfg := c.config.Peripherals
+	p := &peripheralMonitor{
+		client:   &http.Client{Timeout: cfg.Timeout},
+		printers: make(map[string]*printerState, len(cfg.Printers)),
+	}
+	for _, pr := range cfg.Printers {
+		p.printers[pr.Name] = &printerState{healthy: true, paper: "ok", since: time.Now()}
+	}
+	if len(cfg.USB.Devices) > 0 {
+		p.usb = make(map[string]bool, len(cfg.USB.Devices))
+		for _, d := range cfg.USB.Devices {
+			p.usb[d.Name] = true
+		}
+	}
+	return p
+}
+
+// Map reports the health of the peripherals and counters for the heartbeat
+func (p *peripheralMonitor) Map() map[string]interface{} {
+	p.mu.Lock()
+	unhealthy, present := 0, 0
+	for _, s := range p.printers {
+		if !s.healthy {
+			unhealthy++
+		}
+	}
+	for _, on := range p.usb {
+		if on {
+			present++
+		}
+	}
+	present += len(p.attached)
+	m := map[string]interface{}{
+		"printers":           len(p.printers),
+		"printers_unhealthy": unhealthy,
+		"usb_present":        present,
+		"probes":             p.probes.Load(),
+		"failed":             p.failed.Load(),
+	}
+	p.mu.Unlock()
+	return m
+}
+
+// peripheralsLoop probes the peripherals every interval
+func (c *Collector) peripheralsLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.config.Peripherals.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.probePeripherals(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// probePeripherals queries the printers and scans the USB bus, sending the
+// results as a metrics record with a peripherals section
+func (c *Collector) probePeripherals(ctx context.Context) {
+	cfg := c.config.Peripherals
+	p := c.peripherals
+	span := c.resources.Start("input.peripherals")
+	data := make(map[string]interface{})
+	if len(cfg.Printers) > 0 {
+		printers := make([]interface{}, 0, len(cfg.Printers))
+		for _, pr := range cfg.Printers {
+			printers = append(printers, c.probePrinter(ctx, pr.Name, pr.URI))
+		}
+		data["printers"] = printers
+	}
+	if cfg.USB.Enabled {
+		if usb, ok := c.scanUSB(); ok {
+			data["usb"] = usb
+		}
+	}
+	span.End(1)
+	if ctx.Err() != nil {
+		return
+	}
+	p.probes.Add(1)
+
+	record := map[string]interface{}{"peripherals": data}
+	if err := c.sendTelemetry("metrics", c.newTelemetry("metrics", record)); err != nil {
+		c.logger.WithError(err).Warn("Failed to send peripheral health")
+	}
+}
+
+// probePrinter queries a printer and returns its status. Events report the
+// printer turning unhealthy, its recovery and changes of the paper
+func (c *Collector) probePrinter(ctx context.Context, name, uri string) map[string]interface{} {
+	p := c.peripherals
+	status, err := peripheral.Printer{URI: uri, Client: p.client}.Status(ctx)
+	out := map[string]interface{}{"name": name, "reachable": err == nil}
+	healthy, paper := false, ""
+	if err != nil {
+		if ctx.Err() != nil {
+			return out
+		}
+		p.failed.Add(1)
+		out["error"] = err.Error()
+		c.reportError("peripherals", err)
+	} else {
+		for k, v := range status.Map() {
+			out[k] = v
+		}
+		healthy, paper = status.Healthy(), status.Paper
+	}
+
+	now := time.Now()
+	var events []func()
+	p.mu.Lock()
+	s := p.printers[name]
+	if paper != "" && paper != s.paper {
+		fields := map[string]interface{}{"printer": name, "paper": paper, "previous": s.paper}
+		s.paper = paper
+		events = append(events, func() { c.publishEvent("printer_paper", fields) })
+	}
+	switch {
+	case healthy && !s.healthy:
+		fields := map[string]interface{}{"printer": name, "unhealthy_s": int64(now.Sub(s.since).Seconds())}
+		s.healthy, s.since = true, now
+		events = append(events, func() {
+			c.logger.WithFields(logrus.Fields(fields)).Info("Printer recovered")
+			c.publishEvent("printer_recovered", fields)
+		})
+	case !healthy && s.healthy:
+		fields := map[string]interface{}{"printer": name}
+		if err != nil {
+			fields["error"] = err.Error()
+		} else {
+			fields["state"] = status.State
+			fields["errors"] = strings.Join(status.Errors, ",")
+			if status.Message != "" {
+				fields["message"] = status.Message
+			}
+		}
+		s.healthy, s.since = false, now
+		events = append(events, func() {
+			c.logger.WithFields(logrus.Fields(fields)).Warn("Printer unhealthy")
+			c.publishEvent("printer_error", fields)
+		})
+	}
+	p.mu.Unlock()
+
+	for _, publish := range events {
+		publish()
+	}
+	return out
+}
+
+// scanUSB lists the USB peripherals and publishes usb_connected and
+// usb_disconnected events for changes. With devices listed in the config
+// only those are reported, each with whether it is present
+func (c *Collector) scanUSB() ([]interface{}, bool) {
+	cfg := c.config.Peripherals.USB
+	p := c.peripherals
+	devices, err := peripheral.ScanUSB(cfg.SysfsDir)
+	if err != nil {
+		c.logger.WithError(err).Warn("Failed to read USB devices")
+		c.reportError("peripherals", err)
+		return nil, false
+	}
+
+	list := make([]interface{}, 0, len(devices))
+	var events []func()
+	changed := func(name string, connected bool, d peripheral.USBDevice) {
+		event := "usb_disconnected"
+		if connected {
+			event = "usb_connected"
+		}
+		fields := map[string]interface{}{"device": name, "id": d.ID}
+		if d.Product != "" {
+			fields["product"] = d.Product
+		}
+		events = append(events, func() {
+			c.logger.WithFields(logrus.Fields(fields)).Info("USB peripheral changed")
+			c.publishEvent(event, fields)
+		})
+	}
+
+	p.mu.Lock()
+	if len(cfg.Devices) > 0 {
+		for _, want := range cfg.Devices {
+			found, ok := peripheral.USBDevice{ID: want.ID}, false
+			for _, d := range devices {
+				if strings.EqualFold(d.ID, want.ID) && (want.Serial == "" || d.Serial == want.Serial) {
+					found, ok = d, true
+					break
+				}
+			}
+			entry := map[string]interface{}{"name": want.Name, "present": ok}
+			if ok {
+				for k, v := range found.Map() {
+					entry[k] = v
+				}
+			} else {
+				entry["id"] = want.ID
+			}
+			list = append(list, entry)
+			if p.usb[want.Name] != ok {
+				changed(want.Name, ok, found)
+			}
+			p.usb[want.Name] = ok
+		}
+	} else {
+		first := p.attached == nil
+		seen := make(map[string]peripheral.USBDevice, len(devices))
+		for _, d := range devices {
+			list = append(list, d.Map())
+			seen[d.Path] = d
+			if _, ok := p.attached[d.Path]; !first && !ok {
+				changed(d.Path, true, d)
+			}
+		}
+		for path, d := range p.attached {
+			if _, ok := seen[path]; !ok {
+				changed(path, false, d)
+			}
+		}
+		p.attached = seen
+	}
+	p.mu.Unlock()
+
+	for _, publish := range events {
+		publish()
+	}
+	return list, true
+}
@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// prepareMetrics applies series limits and unit normalization to a metrics
+// record before it enters the shared pipeline
+func (c *Collector) prepareMetrics(telemetry *TelemetryData, tr *trace.Trace) {
+	if len(c.config.Cardinality.Paths) > 0 {
+		if limited := c.enforceCardinality(telemetry.Data); len(limited) > 0 {
+			tr.Step("cardinality.series", trace.Modified, "series limit reached for "+strings.Join(limited, ", "))
+		} else {
+			tr.Step("cardinality.series", trace.Passed, "")
+		}
+	}
+
+	if c.config.Units.Enabled {
+		telemetry.Units = c.units.Process(telemetry.Data)
+		tr.Step("units", trace.Modified, fmt.Sprintf("units for %d paths", len(telemetry.Units)))
+	}
+}
+
+// finishTrace logs and keeps a sampled trace. Dry runs attach the record as
+// it left the processors
+func (c *Collector) finishTrace(tr *trace.Trace, telemetry *TelemetryData) {
+	if tr == nil {
+		return
+	}
+	if c.dryRun {
+		tr.Record = *telemetry
+		return
+	}
+
+	c.tracer.Finish(tr)
+	c.logger.WithFields(logrus.Fields{
+		"type":    tr.Type,
+		"outcome": tr.Outcome,
+		"steps":   tr.Steps,
+		"topic":   tr.Topic,
+	}).Info("Pipeline trace")
+}
+
+// Traces returns the most recent sampled traces for the local API
+func (c *Collector) Traces() []trace.Trace {
+	return c.tracer.Recent()
+}
+
+// NewDryRun creates a collector that runs records through the configured
+// processors and routing without connecting to the broker or writing state
+func NewDryRun(cfg *config.Config, logger *logrus.Entry) (*Collector, error) {
+	c := &Collector{
+		config:      cfg,
+		logger:      logger,
+		diagnostics: newDiagnostics(),
+		events:      newEventDeduper(cfg.Collection.Events.DedupWindow),
+		startedAt:   time.Now(),
+		dryRun:      true,
+	}
+	if err := c.initPipeline(); err != nil {
+		return nil, err
+	}
+	if cfg.Export.Enabled {
+		// Shows the export step in traces; dry runs write no files
+		export, err := c.newExport(cfg.Export)
+		if err != nil {
+			return nil, err
+		}
+		c.export = export
+	}
+	return c, nil
+}
+
+// DryRun traces a record through the pipeline. Missing envelope fields are
+// filled in as the collector would for its own data
+func (c *Collector) DryRun(telemetry TelemetryData) *trace.Trace {
+	if telemetry.DeviceID == "" {
+		telemetry.DeviceID = c.config.Device.ID
+	}
+	if telemetry.Timestamp.IsZero() {
+		telemetry.Timestamp = time.Now().UTC()
+	}
+	if telemetry.Tags == nil {
+		telemetry.Tags = c.config.Device.Tags
+	}
+
+	tr := trace.New(telemetry.Type, telemetry.DeviceID)
+	if telemetry.Type == "metrics" && telemetry.DeviceID == c.config.Device.ID {
+		c.prepareMetrics(&telemetry, tr)
+	}
+	if err := c.sendTraced(telemetry.Type, telemetry, tr); err != nil {
+		c.logger.WithError(err).Debug("Dry run record was not published")
+	}
+	return tr
+}
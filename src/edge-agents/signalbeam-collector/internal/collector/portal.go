@@ -0,0 +1,135 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/portal"
+	"github.com/sirupsen/logrus"
+)
+
+// portalMonitor checks whether a captive portal holds the uplink
+type portalMonitor struct {
+	detector *portal.Detector
+	kick     chan struct{}
+
+	mu         sync.Mutex
+	last       portal.Result
+	since      time.Time // Start of the current state
+	checks     int64
+	detections int64
+}
+
+// newPortalMonitor creates the detector. Probes follow the egress allowlist
+func (c *Collector) newPortalMonitor() *portalMonitor {
+	cfg := c.config.Portal
+	probes := make([]portal.Probe, len(cfg.Probes))
+	for i, p := range cfg.Probes {
+		probes[i] = portal.Probe(p)
+	}
+	return &portalMonitor{
+		detector: portal.New(probes, cfg.Timeout, c.dialOutput),
+		kick:     make(chan struct{}, 1),
+	}
+}
+
+// Map returns the state of the network path for the heartbeat
+func (m *portalMonitor) Map() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := map[string]interface{}{
+		"checks":     m.checks,
+		"detections": m.detections,
+	}
+	if m.last.State != "" {
+		out["state"] = m.last.State
+		out["since"] = m.since.Unix()
+	}
+	if m.last.PortalURL != "" {
+		out["portal_url"] = m.last.PortalURL
+	}
+	return out
+}
+
+// checkPortal asks for a check now, as when the connection was lost
+func (c *Collector) checkPortal() {
+	if c.portal == nil {
+		return
+	}
+	select {
+	case c.portal.kick <- struct{}{}:
+	default:
+	}
+}
+
+// portalLoop checks the network path at startup, every interval and when
+// the connection is lost
+func (c *Collector) portalLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.config.Portal.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.runPortalCheck(ctx)
+		select {
+		case <-ticker.C:
+		case <-c.portal.kick:
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// runPortalCheck runs the probes and publishes an event when a captive
+// portal appears or goes away. Events raised while the uplink is held are
+// buffered and delivered once it is free, and reach local notifications
+// at once
+func (c *Collector) runPortalCheck(ctx context.Context) {
+	m := c.portal
+	span := c.resources.Start("input.portal")
+	result := m.detector.Check(ctx)
+	span.End(1)
+	if ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	prev, since := m.last, m.since
+	m.checks++
+	if result.State != prev.State {
+		m.since = now
+		if result.State == portal.Captive {
+			m.detections++
+		}
+	}
+	m.last = result
+	m.mu.Unlock()
+	if result.State == prev.State {
+		return
+	}
+
+	logger := c.logger.WithFields(logrus.Fields{"state": result.State, "probe": result.Probe})
+	switch {
+	case result.State == portal.Captive:
+		fields := map[string]interface{}{"probe": result.Probe, "status": result.Status}
+		if result.PortalURL != "" {
+			fields["portal_url"] = result.PortalURL
+		}
+		logger.WithFields(logrus.Fields(fields)).Warn("Captive portal detected, the uplink needs a sign-in")
+		c.publishEvent("captive_portal_detected", fields)
+	case prev.State == portal.Captive:
+		logger.Info("Captive portal cleared")
+		c.publishEvent("captive_portal_cleared", map[string]interface{}{
+			"state":            result.State,
+			"duration_seconds": int64(now.Sub(since).Seconds()),
+		})
+	case result.State == portal.Unreachable:
+		logger.WithError(result.Err).Warn("Captive portal probes unreachable")
+	default:
+		logger.Debug("Network path open")
+	}
+}
@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/proxy"
+)
+
+// transportProxy is how a transport reaches its destination
+type transportProxy struct {
+	dialer *proxy.Dialer // nil connects directly
+	set    bool          // A proxy or "direct" is configured, overriding the environment
+}
+
+// newTransportProxy resolves the proxy of a transport, its own or the
+// global one
+func (c *Collector) newTransportProxy(own config.ProxyConfig) (transportProxy, error) {
+	p := c.config.TransportProxy(own)
+	switch p.URL {
+	case "":
+		return transportProxy{}, nil
+	case config.ProxyDirect:
+		return transportProxy{set: true}, nil
+	}
+	d, err := proxy.New(p.URL, p.Username, p.Password, nil)
+	if err != nil {
+		return transportProxy{}, err
+	}
+	return transportProxy{dialer: d, set: true}, nil
+}
+
+// dial opens a TCP connection through the proxy, or directly without one.
+// The destination must be on the egress allowlist either way; the proxy
+// itself is set by the operator and always allowed
+func (c *Collector) dial(p transportProxy) egress.DialFunc {
+	if p.dialer == nil {
+		return c.dialOutput
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, portStr, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		port, _ := strconv.Atoi(portStr)
+		if err := c.egress.Check(host, port); err != nil {
+			c.reportEgress(err)
+			return nil, err
+		}
+		return p.dialer.DialContext(ctx, network, address)
+	}
+}
+
+// httpProxy returns the proxy selection for HTTP clients that dial with
+// c.dial: none once a proxy is configured, since the dial goes through it,
+// or else the environment's
+func (p transportProxy) httpProxy() func(*http.Request) (*url.URL, error) {
+	if !p.set {
+		return nil
+	}
+	return func(*http.Request) (*url.URL, error) { return nil, nil }
+}
+
+// websocketProxy returns the proxy selection for websocket brokers, which
+// connect through the proxy themselves. SOCKS5 proxies resolve host names
+// as with other transports
+func (p transportProxy) websocketProxy() func(*http.Request) (*url.URL, error) {
+	if !p.set {
+		return nil
+	}
+	var u *url.URL
+	if p.dialer != nil {
+		u = p.dialer.URL()
+	}
+	return func(*http.Request) (*url.URL, error) { return u, nil }
+}
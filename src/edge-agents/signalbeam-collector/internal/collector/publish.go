@@ -0,0 +1,125 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of publishes that got no answer from the broker
+var (
+	errPublishQueueFull = errors.New("too many publishes awaiting the broker")
+	errPublishExpired   = errors.New("broker did not acknowledge the publish in time")
+)
+
+// publisher bounds the publishes awaiting the broker, so a stalled broker
+// holds up neither collection nor memory
+type publisher struct {
+	slots   chan struct{} // Holds a token per publish in flight
+	pending sync.WaitGroup
+}
+
+// newPublisher creates a publisher allowing maxInFlight publishes at once
+func newPublisher(maxInFlight int) *publisher {
+	return &publisher{slots: make(chan struct{}, maxInFlight)}
+}
+
+// publish sends a message over the current output
+func (c *Collector) publish(topic string, qos byte, retained bool, data []byte) error {
+	return c.publishTo(c.output(), topic, qos, retained, data, len(data), nil)
+}
+
+// publishTo hands a message to an output without waiting for the broker's
+// acknowledgement. done, when set, is called with the outcome: nil once
+// acknowledged, the broker's error, or errPublishExpired when there was no
+// answer within the publish timeout. The error returned is for messages
+// that could not be handed over, as too many are in flight. HTTPS messages are buffered for the
+// next batch. raw is the size of data before compression, for the delivery
+// counters
+func (c *Collector) publishTo(output, topic string, qos byte, retained bool, data []byte, raw int, done func(error)) error {
+	if err := c.checkTenant(topic); err != nil {
+		return err
+	}
+	if output == "https" {
+		c.enqueue(topic, qos, retained, data, raw)
+		if done != nil {
+			done(nil)
+		}
+		return nil
+	}
+
+	p := c.publisher
+	timeout := c.config.MQTT.PublishTimeout
+	c.delivery.published(output, raw, len(data))
+
+	// Drop rather than wait while the broker is not keeping up
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		c.delivery.dropped(output)
+		return errPublishQueueFull
+	}
+	c.stats.inFlight.Add(1)
+
+	start := time.Now()
+	token := c.mqttClient.Publish(topic, qos, retained, data)
+	p.pending.Add(1)
+	go func() {
+		defer p.pending.Done()
+
+		expiry := time.NewTimer(timeout)
+		defer expiry.Stop()
+		var err error
+		select {
+		case <-token.Done():
+			err = token.Error()
+		case <-expiry.C:
+			err = errPublishExpired
+		}
+		<-p.slots
+		c.stats.inFlight.Add(-1)
+
+		switch {
+		case errors.Is(err, errPublishExpired):
+			c.delivery.expired(output)
+		case err != nil:
+			c.delivery.dropped(output)
+		default:
+			c.delivery.acked(output)
+			c.stats.recordPublish(time.Since(start))
+		}
+		if done != nil {
+			done(err)
+		}
+	}()
+	return nil
+}
+
+// waitPublishes waits until the broker has answered every publish in
+// flight, or the context ends
+func (c *Collector) waitPublishes(ctx context.Context) {
+	settled := make(chan struct{})
+	go func() {
+		c.publisher.pending.Wait()
+		close(settled)
+	}()
+	select {
+	case <-settled:
+	case <-ctx.Done():
+		c.logger.WithField("in_flight", c.stats.inFlight.Load()).Warn("Stopped waiting for publishes in flight")
+	}
+}
+
+// checkTenant refuses topics outside the organization's topic root, so
+// neither a routing rule nor a pushed configuration publishes into another
+// fleet on a shared broker
+func (c *Collector) checkTenant(topic string) error {
+	if c.tenantRoot == "" || strings.HasPrefix(topic, c.tenantRoot) {
+		return nil
+	}
+	c.tenantViolations.Add(1)
+	return fmt.Errorf("topic %s is outside organization %s", topic, c.config.Device.Org)
+}
@@ -0,0 +1,156 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/output"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/queue"
+)
+
+// ReplayBuffer sends the records of the disk or SQLite buffer to the broker,
+// or to the output named to, oldest first, and returns how many it sent.
+// Only the buffer and the destination are opened: nothing is collected,
+// subscribed or announced. A record leaves the buffer once its delivery is
+// confirmed; the first failure stops the replay with the rest kept. The
+// buffer is locked meanwhile, so a running collector fails it with
+// queue.ErrLocked
+func ReplayBuffer(ctx context.Context, cfg *config.Config, to string) (int64, error) {
+	q, err := OpenBuffer(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer q.Close()
+
+	if to != "" {
+		return replayToOutput(ctx, cfg, q, to)
+	}
+	return replayToBroker(ctx, cfg, q)
+}
+
+// replayToBroker sends the buffer over a connection of its own, waiting for
+// the broker to acknowledge each record before removing it
+func replayToBroker(ctx context.Context, cfg *config.Config, q queue.Queue) (int64, error) {
+	if cfg.Gateway.Enabled {
+		return 0, errors.New("the buffer cannot be replayed through the Edge Gateway; use -to or -out")
+	}
+	if err := checkBrokerEgress(cfg); err != nil {
+		return 0, err
+	}
+	tlsCfg, err := mqttTLSConfig(cfg.MQTT.TLS)
+	if err != nil {
+		return 0, fmt.Errorf("failed to configure MQTT TLS: %w", err)
+	}
+
+	// A separate client ID keeps the replay from taking over the persistent
+	// session of the collector
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(cfg.MQTT.Broker)
+	opts.SetClientID(cfg.MQTT.ClientID + "-replay")
+	opts.SetUsername(cfg.MQTT.Username)
+	opts.SetPassword(cfg.MQTT.Password)
+	opts.SetConnectTimeout(cfg.MQTT.Timeout)
+	opts.SetTLSConfig(tlsCfg)
+	opts.SetCleanSession(true)
+	opts.SetAutoReconnect(false)
+	if t := cfg.MQTT.Token; t.Enabled {
+		tctx, cancel := context.WithTimeout(ctx, t.Timeout)
+		token, err := tokenSource(t, &http.Client{}).Token(tctx)
+		cancel()
+		if err != nil {
+			return 0, fmt.Errorf("failed to obtain MQTT access token: %w", err)
+		}
+		opts.SetPassword(token.AccessToken)
+	}
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(cfg.MQTT.Timeout) {
+		return 0, errors.New("timed out connecting to MQTT broker")
+	}
+	if token.Error() != nil {
+		return 0, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+	defer client.Disconnect(250)
+
+	return ReplayQueue(ctx, q, cfg.Replay.Downsample, func(msg output.Message) error {
+		token := client.Publish(msg.Topic, msg.QoS, msg.Retained, msg.Payload)
+		if !token.WaitTimeout(cfg.MQTT.PublishTimeout) {
+			return fmt.Errorf("timed out publishing to %s", msg.Topic)
+		}
+		return token.Error()
+	})
+}
+
+// replayToOutput sends the buffer to one output, flushing it after each
+// record. The data types of the output do not apply: every record is sent
+func replayToOutput(ctx context.Context, cfg *config.Config, q queue.Queue, name string) (int64, error) {
+	i := slices.IndexFunc(cfg.Outputs, func(o config.OutputConfig) bool { return o.Name == name })
+	if i < 0 {
+		return 0, fmt.Errorf("no output named %s", name)
+	}
+
+	var policy *egress.Policy
+	if cfg.Egress.Enabled {
+		var err error
+		if policy, err = egress.New(cfg.Egress.Allowlist); err != nil {
+			return 0, fmt.Errorf("failed to create egress policy: %w", err)
+		}
+	}
+	sink, err := output.New(cfg.Outputs[i], output.Env{
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return policy.Dial(ctx, &net.Dialer{}, network, address)
+		},
+		TLS: mqttTLSConfig,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sent, err := ReplayQueue(ctx, q, cfg.Replay.Downsample, func(msg output.Message) error {
+		if err := sink.Publish(ctx, msg); err != nil {
+			return err
+		}
+		f, ok := sink.(output.Flusher)
+		if !ok {
+			return nil
+		}
+		fctx, cancel := context.WithTimeout(ctx, cfg.Shutdown.Timeout)
+		defer cancel()
+		return f.Flush(fctx)
+	})
+	if closeErr := sink.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("output %s: %w", name, closeErr)
+	}
+	return sent, err
+}
+
+// ReplayQueue hands the records of a queue to send, oldest first, and
+// removes each once send returned nil, downsampling old metrics per ds.
+// send must only return nil once the record is delivered or written. It
+// returns how many records left the queue
+func ReplayQueue(ctx context.Context, q queue.Queue, ds config.DownsampleConfig, send func(output.Message) error) (int64, error) {
+	b := newBacklog(ds)
+	var sent int64
+	for ctx.Err() == nil {
+		ok, err := b.next(q, send)
+		if err != nil || !ok {
+			if putErr := b.putBack(q); err == nil {
+				err = putErr
+			}
+			return sent, err
+		}
+		sent++
+	}
+	if err := b.putBack(q); err != nil {
+		return sent, err
+	}
+	return sent, ctx.Err()
+}
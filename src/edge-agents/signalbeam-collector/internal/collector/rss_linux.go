@@ -0,0 +1,14 @@
+//go:build linux
+
+package collector
+
+import "syscall"
+
+// peakRSS returns the largest resident set the process had
+func peakRSS() (uint64, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, false
+	}
+	return uint64(ru.Maxrss) * 1024, true // Reported in KiB
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package collector
+
+// peakRSS is not reported on this platform
+func peakRSS() (uint64, bool) {
+	return 0, false
+}
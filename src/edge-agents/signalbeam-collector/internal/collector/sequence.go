@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/sequence"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/trace"
+)
+
+// sequenceStats counts numbered records that were never delivered, the
+// gaps the ingestion pipeline will see
+type sequenceStats struct {
+	gaps          atomic.Int64 // Numbered records dropped before the broker took them
+	reserveFailed atomic.Int64 // Numbers issued while the reservation could not be saved
+}
+
+// stampRecord gives a record its message ID and the next sequence number of
+// its device. Records that already carry them keep them, so a
+// retransmission is recognised as a duplicate
+func (c *Collector) stampRecord(telemetry *TelemetryData, tr *trace.Trace) {
+	if c.sequence == nil {
+		return
+	}
+	if telemetry.MessageID == "" {
+		telemetry.MessageID = sequence.NewID()
+	}
+	if telemetry.Sequence == 0 {
+		n, err := c.sequence.Next(telemetry.DeviceID)
+		if err != nil {
+			c.sequenceStats.reserveFailed.Add(1)
+			c.reportError("sequence", err)
+		}
+		telemetry.Sequence = n
+	}
+	if tr != nil {
+		tr.Step("sequence", trace.Modified, fmt.Sprintf("sequence %d, message %s", telemetry.Sequence, telemetry.MessageID))
+	}
+}
+
+// sequenceGap counts numbered records that were dropped
+func (c *Collector) sequenceGap(n int) {
+	if c.sequence != nil {
+		c.sequenceStats.gaps.Add(int64(n))
+	}
+}
+
+// sequenceMap reports the numbering for the heartbeat
+func (c *Collector) sequenceMap() map[string]interface{} {
+	return map[string]interface{}{
+		"issued":         c.sequence.Issued(),
+		"last":           c.sequence.Last(),
+		"gaps":           c.sequenceStats.gaps.Load(),
+		"reserve_errors": c.sequenceStats.reserveFailed.Load(),
+	}
+}
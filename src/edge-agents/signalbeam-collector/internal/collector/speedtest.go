@@ -0,0 +1,208 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/speedtest"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+// speedtestUsage is the data used by tests in the current calendar month,
+// kept across restarts so the cap holds
+type speedtestUsage struct {
+	Month   string `json:"month"` // 2006-01
+	Bytes   int64  `json:"bytes"`
+	LastRun int64  `json:"last_run"` // Unix time of the last test or skip
+}
+
+// speedtestMonitor runs the scheduled link tests
+type speedtestMonitor struct {
+	tester *speedtest.Tester
+	path   string
+
+	mu      sync.Mutex
+	usage   speedtestUsage
+	runs    int64
+	failed  int64
+	skipped int64 // Tests skipped for the monthly cap
+}
+
+// newSpeedtest creates the tester. Connections follow the egress allowlist
+func (c *Collector) newSpeedtest() (*speedtestMonitor, error) {
+	cfg := c.config.Speedtest
+	tlsCfg, err := mqttTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	m := &speedtestMonitor{
+		tester: speedtest.New(speedtest.Options{
+			DownloadURL: cfg.DownloadURL,
+			UploadURL:   cfg.UploadURL,
+			Bytes:       cfg.Bytes,
+			Timeout:     cfg.Timeout,
+			TLS:         tlsCfg,
+			Dial:        c.dialOutput,
+		}),
+		path: filepath.Join(state.Resolve(c.config.State).Dir, "speedtest.json"),
+	}
+
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.usage); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Map returns the tests run and the data used this month for the heartbeat
+func (m *speedtestMonitor) Map() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := map[string]interface{}{
+		"runs":        m.runs,
+		"failed":      m.failed,
+		"skipped":     m.skipped,
+		"month_bytes": m.monthBytes(time.Now()),
+	}
+	if m.usage.LastRun > 0 {
+		out["last_run"] = m.usage.LastRun
+	}
+	return out
+}
+
+// monthBytes returns the data used in the month of now
+func (m *speedtestMonitor) monthBytes(now time.Time) int64 {
+	if m.usage.Month != now.UTC().Format("2006-01") {
+		return 0
+	}
+	return m.usage.Bytes
+}
+
+// record adds the data a test used, or marks a skipped test, and saves the
+// usage
+func (m *speedtestMonitor) record(now time.Time, bytes int64) error {
+	m.mu.Lock()
+	month := now.UTC().Format("2006-01")
+	if m.usage.Month != month {
+		m.usage = speedtestUsage{Month: month}
+	}
+	m.usage.Bytes += bytes
+	m.usage.LastRun = now.Unix()
+	data, err := json.Marshal(m.usage)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(m.path+".tmp", m.path)
+}
+
+// speedtestLoop tests the link every interval, resuming the schedule after
+// a restart. A random delay of up to a twentieth of the interval keeps a
+// fleet from testing at the same moment
+func (c *Collector) speedtestLoop(ctx context.Context) {
+	defer c.wg.Done()
+	interval := c.config.Speedtest.Interval
+	splay := func() time.Duration { return time.Duration(rand.Int63n(int64(interval / 20))) }
+
+	c.speedtest.mu.Lock()
+	last := time.Unix(c.speedtest.usage.LastRun, 0)
+	c.speedtest.mu.Unlock()
+	timer := time.NewTimer(max(time.Until(last.Add(interval)), 0) + splay())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			c.runSpeedtest(ctx)
+			timer.Reset(interval + splay())
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// runSpeedtest tests the link unless the test could exceed the monthly cap,
+// and sends a speedtest record with the result
+func (c *Collector) runSpeedtest(ctx context.Context) {
+	cfg := c.config.Speedtest
+	m := c.speedtest
+	now := time.Now()
+
+	need := int64(0)
+	for _, u := range []string{cfg.DownloadURL, cfg.UploadURL} {
+		if u != "" {
+			need += cfg.Bytes
+		}
+	}
+	m.mu.Lock()
+	used := m.monthBytes(now)
+	capped := cfg.MonthlyCap > 0 && used+need > cfg.MonthlyCap
+	if capped {
+		m.skipped++
+	}
+	m.mu.Unlock()
+	if capped {
+		c.logger.WithFields(logrus.Fields{"month_bytes": used, "monthly_cap": cfg.MonthlyCap}).Info("Skipping speed test, monthly data cap reached")
+		if err := m.record(now, 0); err != nil {
+			c.logger.WithError(err).Warn("Failed to save speed test usage")
+		}
+		return
+	}
+
+	span := c.resources.Start("input.speedtest")
+	result, err := m.tester.Run(ctx)
+	span.End(1)
+	if rErr := m.record(now, result.Bytes()); rErr != nil {
+		c.logger.WithError(rErr).Warn("Failed to save speed test usage")
+	}
+
+	m.mu.Lock()
+	m.runs++
+	if err != nil {
+		m.failed++
+	}
+	m.mu.Unlock()
+	if err != nil {
+		c.logger.WithError(err).Warn("Speed test failed")
+		c.reportError("speedtest", err)
+		return
+	}
+
+	data := result.Map()
+	target := cfg.DownloadURL
+	if target == "" {
+		target = cfg.UploadURL
+	}
+	if u, err := url.Parse(target); err == nil {
+		data["server"] = u.Host
+	}
+	data["month_bytes"] = used + result.Bytes()
+	if err := c.sendTelemetry("speedtest", c.newTelemetry("speedtest", data)); err != nil {
+		c.logger.WithError(err).Warn("Failed to send speed test result")
+		return
+	}
+	c.logger.WithFields(logrus.Fields(data)).Info("Ran speed test")
+}
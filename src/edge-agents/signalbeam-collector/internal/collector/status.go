@@ -0,0 +1,265 @@
+package collector
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// linkStats tracks publish outcomes reported in the heartbeat
+type linkStats struct {
+	inFlight atomic.Int64
+
+	mu          sync.Mutex
+	lastPublish time.Time
+	lastRTT     time.Duration
+}
+
+// recordPublish stores the time and round-trip of a successful publish.
+// For QoS 1/2 the round-trip covers the broker acknowledgement.
+func (s *linkStats) recordPublish(rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastPublish = time.Now().UTC()
+	s.lastRTT = rtt
+}
+
+// snapshot returns the last publish time and round-trip
+func (s *linkStats) snapshot() (time.Time, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastPublish, s.lastRTT
+}
+
+// status reports "degraded" when collection runs but some inputs fail
+func (c *Collector) status() string {
+	if len(c.metrics.Failures()) > 0 || len(c.supervisor.Unhealthy()) > 0 {
+		return "degraded"
+	}
+	return "online"
+}
+
+// addOperationalState enriches a heartbeat with device and agent health
+func (c *Collector) addOperationalState(heartbeat map[string]interface{}) {
+	heartbeat["ip_addresses"] = localAddresses()
+	heartbeat["agent_uptime"] = int64(time.Since(c.startedAt).Seconds())
+	heartbeat["buffer_depth"] = c.outboundQueue()
+	heartbeat["config_hash"] = c.config.Hash()
+	heartbeat["mqtt_protocol"] = c.config.MQTT.Protocol
+	heartbeat["memory"] = agentMemory()
+
+	if failures := c.metrics.Failures(); len(failures) > 0 {
+		heartbeat["failing_inputs"] = failures
+	}
+	if health := c.supervisor.Health(); len(health) > 0 {
+		heartbeat["inputs"] = health
+	}
+
+	if uptime, err := host.Uptime(); err == nil {
+		heartbeat["uptime"] = uptime
+	}
+
+	heartbeat["link"] = c.link.Map()
+	if c.control != nil {
+		heartbeat["control_connected"] = c.control.IsConnected()
+	}
+	if c.mqttToken != nil || c.controlToken != nil {
+		tokens := make(map[string]interface{})
+		for _, t := range []*brokerToken{c.mqttToken, c.controlToken} {
+			if t != nil {
+				tokens[t.connection] = t.Map()
+			}
+		}
+		heartbeat["access_tokens"] = tokens
+	}
+	heartbeat["outputs"] = c.delivery.Map()
+	if c.codec.Binary() {
+		heartbeat["encoding"] = c.codec.Name()
+	}
+	if c.batcher != nil {
+		heartbeat["batching"] = c.batcher.Map()
+	}
+	if c.compressor != nil {
+		heartbeat["compression"] = c.compressor.Status()
+	}
+	if names := c.outputs.Names(); len(names) > 0 {
+		heartbeat["output_sources"] = names
+	}
+	if breakers := c.outputs.Breakers(); len(breakers) > 0 {
+		heartbeat["breakers"] = breakers
+	}
+	if c.export != nil {
+		heartbeat["parquet_export"] = c.export.exportStatus()
+	}
+	if c.logArchive != nil {
+		heartbeat["log_archive"] = c.logArchive.Status()
+	}
+	if c.fallback != nil || c.transport != "mqtt" {
+		heartbeat["transport"] = c.output()
+	}
+
+	if c.deadband != nil {
+		heartbeat["suppressed_messages"] = c.suppressed.Load()
+	}
+
+	if c.qualityAlerts != nil {
+		heartbeat["quality_alerts"] = c.qualityAlerts.Active()
+	}
+
+	if c.workloads != nil {
+		heartbeat["workloads"] = c.workloads.Map()
+	}
+
+	if c.uploads != nil {
+		heartbeat["uploads"] = c.uploads.Map()
+	}
+
+	if c.notifications != nil {
+		heartbeat["notifications"] = c.notifications.Map()
+	}
+	if c.drift != nil {
+		heartbeat["drift"] = c.drift.Map()
+	}
+	if c.compliance != nil {
+		heartbeat["compliance"] = c.compliance.Map()
+	}
+	if c.speedtest != nil {
+		heartbeat["speedtest"] = c.speedtest.Map()
+	}
+	if c.usage != nil {
+		heartbeat["data_usage"] = c.usage.Map()
+	}
+	if c.portal != nil {
+		heartbeat["captive_portal"] = c.portal.Map()
+	}
+	if c.uplink != nil {
+		heartbeat["uplink"] = c.uplink.Map()
+	}
+	if c.offPeak != nil {
+		heartbeat["off_peak"] = c.offPeak.Map()
+	}
+	if c.updates != nil {
+		heartbeat["update"] = c.updates.Map()
+	}
+	if c.gpio != nil {
+		heartbeat["gpio"] = c.gpio.Map()
+	}
+	if c.buffer != nil {
+		heartbeat["buffer"] = c.buffer.Map()
+	}
+	if c.display != nil {
+		heartbeat["display"] = c.display.Map()
+	}
+	if c.kiosk != nil {
+		heartbeat["kiosk"] = c.kiosk.Map()
+	}
+	if c.peripherals != nil {
+		heartbeat["peripherals"] = c.peripherals.Map()
+	}
+	if c.backpressure != nil {
+		heartbeat["backpressure"] = c.backpressureMap()
+	}
+	if c.cloudThrottle != nil {
+		heartbeat["cloud_throttle"] = c.cloudThrottle.Map()
+	}
+	if c.aclCheck != nil {
+		heartbeat["acl"] = c.aclCheck.Map()
+	}
+	if c.acks != nil {
+		heartbeat["acks"] = c.acks.Map()
+	}
+	if c.localBridge != nil {
+		heartbeat["local_bridge"] = c.localBridge.Map()
+	}
+	if c.budget != nil {
+		heartbeat["budget"] = c.budget.Map()
+	}
+	if c.sequence != nil {
+		heartbeat["sequence"] = c.sequenceMap()
+	}
+
+	if c.resources != nil {
+		resources := make(map[string]interface{})
+		for name, usage := range c.resources.Snapshot() {
+			resources[name] = usage.Map()
+		}
+		heartbeat["resources"] = resources
+	}
+
+	if c.egress != nil {
+		heartbeat["egress_violations"] = c.egress.Violations()
+	}
+	if c.tenantRoot != "" {
+		heartbeat["tenant_violations"] = c.tenantViolations.Load()
+	}
+
+	lastPublish, rtt := c.stats.snapshot()
+	if !lastPublish.IsZero() {
+		heartbeat["last_publish"] = lastPublish.Unix()
+		heartbeat["rtt_ms"] = float64(rtt.Microseconds()) / 1000
+	}
+}
+
+// localAddresses returns the non-loopback IP addresses of active interfaces
+func localAddresses() []string {
+	addrs := []string{}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return addrs
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range ifaceAddrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+				addrs = append(addrs, ipNet.IP.String())
+			}
+		}
+	}
+
+	return addrs
+}
+
+// Status returns the current heartbeat payload for the local API
+func (c *Collector) Status() map[string]interface{} {
+	return c.heartbeat()
+}
+
+// CollectNow runs a metrics collection outside the regular interval
+func (c *Collector) CollectNow() {
+	_ = c.gatherAndSendMetrics()
+}
+
+// agentMemory reports the memory of the collector itself: the resident set
+// and its peak where the platform tells them, and the Go heap
+func agentMemory() map[string]interface{} {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	m := map[string]interface{}{
+		"heap":       ms.HeapAlloc,
+		"go_sys":     ms.Sys,
+		"goroutines": runtime.NumGoroutine(),
+	}
+	if p, err := process.NewProcess(int32(os.Getpid())); err == nil {
+		if info, err := p.MemoryInfo(); err == nil {
+			m["rss"] = info.RSS
+		}
+	}
+	if peak, ok := peakRSS(); ok {
+		m["rss_peak"] = peak
+	}
+	return m
+}
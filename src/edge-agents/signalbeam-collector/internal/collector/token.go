@@ -0,0 +1,219 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/oauth"
+)
+
+// tokenRetryInterval spaces token requests after a failure
+const tokenRetryInterval = time.Minute
+
+// tokenIssuer issues the tokens a connection authenticates with: an OAuth
+// client, or an IoT Hub device key
+type tokenIssuer interface {
+	Token(ctx context.Context) (oauth.Token, error)
+}
+
+// brokerToken is the access token a connection authenticates with
+type brokerToken struct {
+	connection string // "telemetry" or "control"
+	username   string
+	source     tokenIssuer
+	cfg        config.TokenConfig
+
+	mu        sync.Mutex
+	token     oauth.Token
+	refreshed time.Time
+	err       string
+}
+
+// tokenSource builds the client credentials request of cfg
+func tokenSource(cfg config.TokenConfig, client *http.Client) *oauth.ClientCredentials {
+	return &oauth.ClientCredentials{
+		URL:      cfg.URL,
+		ClientID: cfg.ClientID,
+		Secret: func() (string, error) {
+			if cfg.ClientSecretFile == "" {
+				return cfg.ClientSecret, nil
+			}
+			data, err := os.ReadFile(cfg.ClientSecretFile)
+			return strings.TrimSpace(string(data)), err
+		},
+		Scopes:   cfg.Scopes,
+		Audience: cfg.Audience,
+		Client:   client,
+	}
+}
+
+// newBrokerToken requests tokens through the global proxy and the egress
+// allowlist
+func (c *Collector) newBrokerToken(cfg config.TokenConfig, username, connection string) (*brokerToken, error) {
+	p, err := c.newTransportProxy(config.ProxyConfig{})
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = c.dial(p)
+	if proxy := p.httpProxy(); proxy != nil {
+		transport.Proxy = proxy
+	}
+	return &brokerToken{
+		connection: connection,
+		username:   username,
+		source:     tokenSource(cfg, &http.Client{Transport: transport}),
+		cfg:        cfg,
+	}, nil
+}
+
+// Map reports the token state for the heartbeat
+func (t *brokerToken) Map() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := map[string]interface{}{}
+	if !t.refreshed.IsZero() {
+		m["refreshed_at"] = t.refreshed.UTC().Format(time.RFC3339)
+	}
+	if !t.token.Expiry.IsZero() {
+		m["expires_at"] = t.token.Expiry.UTC().Format(time.RFC3339)
+	}
+	if t.err != "" {
+		m["error"] = t.err
+	}
+	return m
+}
+
+// refreshAt returns when the token is due for a refresh, zero when it does
+// not expire
+func (t *brokerToken) refreshAt() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token.AccessToken == "" {
+		return time.Now()
+	}
+	if t.token.Expiry.IsZero() {
+		return time.Time{}
+	}
+	return t.token.Expiry.Add(-t.cfg.RefreshBefore)
+}
+
+// refresh requests a new token
+func (t *brokerToken) refresh(c *Collector) error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.cfg.Timeout)
+	defer cancel()
+	token, err := t.source.Token(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.err = err.Error()
+		c.logger.WithError(err).WithField("connection", t.connection).Warn("Failed to obtain MQTT access token")
+		c.reportError("token", err)
+		return err
+	}
+	t.token, t.refreshed, t.err = token, time.Now(), ""
+	entry := c.logger.WithField("connection", t.connection)
+	if !token.Expiry.IsZero() {
+		entry = entry.WithField("expires_at", token.Expiry.UTC().Format(time.RFC3339))
+	}
+	entry.Debug("Obtained MQTT access token")
+	return nil
+}
+
+// credentials returns the username and token of the next connect. A token
+// due for a refresh is replaced first; when that fails the current one is
+// sent as long as it has not expired
+func (c *Collector) credentials(t *brokerToken) mqtt.CredentialsProvider {
+	return func() (string, string) {
+		if at := t.refreshAt(); !at.IsZero() && !time.Now().Before(at) {
+			t.refresh(c)
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return t.username, t.token.AccessToken
+	}
+}
+
+// tokenLoop re-establishes the connection of client with a fresh token
+// before the current one expires, so the broker never sees an expired token
+// on a live connection. While disconnected the next connect attempt
+// obtains the token itself
+func (c *Collector) tokenLoop(ctx context.Context, t *brokerToken, client mqtt.Client) {
+	defer c.wg.Done()
+	for {
+		at := t.refreshAt()
+		if at.IsZero() {
+			// Without an expiry the token lasts as long as the connection
+			return
+		}
+		timer := time.NewTimer(max(time.Until(at), 0))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-c.stopCh:
+			timer.Stop()
+			return
+		}
+
+		if !client.IsConnected() {
+			if !c.sleep(ctx, tokenRetryInterval) {
+				return
+			}
+			continue
+		}
+		if err := t.refresh(c); err != nil {
+			if !c.sleep(ctx, tokenRetryInterval) {
+				return
+			}
+			continue
+		}
+		c.reconnectWithToken(ctx, t, client)
+	}
+}
+
+// reconnectWithToken disconnects cleanly, so the broker does not publish
+// the will, and connects again with the new token. The client's connect
+// handler restores the subscriptions
+func (c *Collector) reconnectWithToken(ctx context.Context, t *brokerToken, client mqtt.Client) {
+	select {
+	case <-c.stopCh:
+		return
+	default:
+	}
+	c.logger.WithField("connection", t.connection).Info("Reconnecting to MQTT broker with a fresh access token")
+	client.Disconnect(250)
+	for n := 1; ; n++ {
+		token := client.Connect()
+		if token.Wait() && token.Error() == nil {
+			return
+		}
+		c.logger.WithError(token.Error()).WithField("connection", t.connection).Warn("Failed to reconnect to MQTT broker with a fresh access token")
+		c.reportError("token", token.Error())
+		if !c.sleep(ctx, c.reconnect.Delay(n)) {
+			return
+		}
+	}
+}
+
+// sleep waits for d and reports false when the collector stops meanwhile
+func (c *Collector) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-c.stopCh:
+		return false
+	}
+}
@@ -0,0 +1,519 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/update"
+	"github.com/sirupsen/logrus"
+)
+
+// Version is the agent version, reported in heartbeats and compared with
+// rolled out versions. Release builds set it with -ldflags -X
+var Version = "0.1.0"
+
+// Update states reported in the heartbeat
+const (
+	updateCurrent     = "current"      // Running the version of the rollout or a newer one
+	updateNotSelected = "not_selected" // Outside the cohorts or percentage
+	updateDownloading = "downloading"
+	updateInstalled   = "installed" // Waiting for the restart
+	updateFailed      = "failed"
+	updateHalted      = "halted"
+	updateUnsupported = "unsupported" // No binary for this platform
+)
+
+// imageStatusTimeout bounds queries and confirmation of the A/B update
+// system
+const imageStatusTimeout = time.Minute
+
+// updater follows the rollouts of the device's release channel
+type updater struct {
+	client *http.Client
+	dir    string
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	raw      []byte
+	state    string
+	rollout  string
+	target   string
+	err      string
+	download context.CancelFunc // Cancels the download in progress
+	outcome  map[string]interface{}
+	event    string // Outcome of an update installed before the restart
+
+	image        update.ImageSystem // nil without an A/B update system
+	slots        update.Slots
+	slotsErr     string
+	imageRecord  string // Version of the image last confirmed
+	confirmImage bool   // The booted slot is confirmed once connected
+}
+
+// pendingUpdate is kept while an installed update waits for the restart, so
+// the new process can report whether it came up
+type pendingUpdate struct {
+	Rollout string `json:"rollout"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Kind    string `json:"kind,omitempty"`
+	System  string `json:"system,omitempty"` // Image update system
+	Slot    string `json:"slot,omitempty"`   // Slot booted before the image was installed
+}
+
+// imageRecord keeps the version of the confirmed image, for systems that
+// do not report it
+type imageRecord struct {
+	Version string `json:"version"`
+}
+
+// newUpdater sets up downloads through the global proxy and the egress
+// allowlist, finds the A/B update system and checks an update installed
+// before the restart
+func (c *Collector) newUpdater() (*updater, error) {
+	p, err := c.newTransportProxy(config.ProxyConfig{})
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = c.dial(p)
+	if proxy := p.httpProxy(); proxy != nil {
+		transport.Proxy = proxy
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	u := &updater{
+		client: &http.Client{Transport: transport},
+		dir:    filepath.Join(state.Resolve(c.config.State).Dir, "update"),
+		ctx:    ctx,
+		cancel: cancel,
+		state:  updateCurrent,
+	}
+
+	img := c.config.Update.Image
+	if u.image, err = update.DetectImageSystem(img.System, img.SwupdateSelect); err != nil {
+		return nil, err
+	}
+	if u.image != nil {
+		var record imageRecord
+		if data, err := os.ReadFile(filepath.Join(u.dir, "image.json")); err == nil {
+			if err := json.Unmarshal(data, &record); err != nil {
+				return nil, err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		u.imageRecord = record.Version
+		if err := u.refreshSlots(); err != nil {
+			c.logger.WithError(err).WithField("system", u.image.Name()).Warn("Failed to read A/B slot status")
+		}
+	}
+
+	path := filepath.Join(u.dir, "pending.json")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pending pendingUpdate
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, err
+	}
+	u.rollout, u.target = pending.Rollout, pending.To
+	u.outcome = map[string]interface{}{"rollout": pending.Rollout, "from": pending.From, "version": pending.To}
+	if pending.Kind == update.KindImage {
+		u.outcome["kind"], u.outcome["system"], u.outcome["slot"] = update.KindImage, pending.System, u.slots.Booted
+		switch {
+		case u.image == nil || u.image.Name() != pending.System:
+			u.state, u.err = updateFailed, "image update system "+pending.System+" is gone"
+		case u.slots.Booted == "" || u.slots.Booted == pending.Slot:
+			u.state, u.err = updateFailed, "booted slot "+pending.Slot+" again, the bootloader fell back"
+		default:
+			// Kept until confirmed: a reboot before the collector connects
+			// falls back and is then reported as failed
+			u.state, u.confirmImage, u.event = updateInstalled, true, "update_completed"
+			return u, nil
+		}
+		u.event = "update_failed"
+		u.outcome["error"] = u.err
+		return u, os.Remove(path)
+	}
+	if update.Compare(Version, pending.To) == 0 {
+		u.event = "update_completed"
+	} else {
+		u.state, u.err = updateFailed, "restarted as "+Version+" instead of "+pending.To
+		u.event = "update_failed"
+		u.outcome["error"] = u.err
+	}
+	return u, os.Remove(path)
+}
+
+// refreshSlots reads the A/B slot status
+func (u *updater) refreshSlots() error {
+	ctx, cancel := context.WithTimeout(u.ctx, imageStatusTimeout)
+	defer cancel()
+	slots, err := u.image.Slots(ctx)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err != nil {
+		u.slotsErr = err.Error()
+		return err
+	}
+	u.slots, u.slotsErr = slots, ""
+	return nil
+}
+
+// imageVersion returns the version of the running image: the one recorded
+// when it was confirmed, or else the one the update system reports
+func (u *updater) imageVersion() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.imageRecord != "" {
+		return u.imageRecord
+	}
+	return u.slots.Version
+}
+
+// Map reports the update state for the heartbeat
+func (u *updater) Map() map[string]interface{} {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	m := map[string]interface{}{"state": u.state}
+	if u.rollout != "" {
+		m["rollout"] = u.rollout
+		m["target"] = u.target
+	}
+	if u.err != "" {
+		m["error"] = u.err
+	}
+	if u.image != nil {
+		image := map[string]interface{}{"system": u.image.Name(), "booted": u.slots.Booted}
+		if v := u.imageRecord; v != "" {
+			image["version"] = v
+		} else if v := u.slots.Version; v != "" {
+			image["version"] = v
+		}
+		if len(u.slots.States) > 0 {
+			image["slots"] = u.slots.States
+		}
+		if u.slotsErr != "" {
+			image["error"] = u.slotsErr
+		}
+		m["image"] = image
+	}
+	return m
+}
+
+// set records the update state
+func (u *updater) set(state, rollout, target, errMsg string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.state, u.rollout, u.target, u.err = state, rollout, target, errMsg
+}
+
+// updateTopic returns the topic the rollouts of the channel arrive on
+func (c *Collector) updateTopic() string {
+	topic := strings.ReplaceAll(c.config.Update.Topic, "{channel}", c.config.Update.Channel)
+	return c.expandTopic(topic, "updates")
+}
+
+// subscribeUpdates receives the rollout directive of the channel
+func (c *Collector) subscribeUpdates(client mqtt.Client) {
+	topic := c.updateTopic()
+	token := client.Subscribe(topic, 1, c.handleUpdateMessage)
+	if token.Wait() && token.Error() != nil {
+		c.logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to subscribe to update topic")
+		c.reportError("update", token.Error())
+	}
+}
+
+// reportUpdateOutcome publishes whether an update installed before the
+// restart came up, once connected. A new image is confirmed first, so the
+// bootloader keeps its slot
+func (c *Collector) reportUpdateOutcome() {
+	u := c.updates
+	u.mu.Lock()
+	event, fields, confirm := u.event, u.outcome, u.confirmImage
+	u.event, u.confirmImage = "", false
+	u.mu.Unlock()
+	if event == "" {
+		return
+	}
+	if confirm {
+		if err := c.confirmImage(fields["version"].(string)); err != nil {
+			event = "update_failed"
+			fields["error"] = "failed to confirm the booted slot: " + err.Error()
+			u.set(updateFailed, u.rollout, u.target, fields["error"].(string))
+		} else {
+			u.set(updateCurrent, u.rollout, u.target, "")
+		}
+	}
+	fields["channel"] = c.config.Update.Channel
+	if event == "update_completed" {
+		c.logger.WithFields(logrus.Fields(fields)).Info("Update completed")
+	} else {
+		c.logger.WithFields(logrus.Fields(fields)).Warn("Update failed")
+	}
+	c.publishEvent(event, fields)
+}
+
+// confirmImage marks the booted slot good and records its version
+func (c *Collector) confirmImage(version string) error {
+	u := c.updates
+	ctx, cancel := context.WithTimeout(u.ctx, imageStatusTimeout)
+	defer cancel()
+	if err := u.image.Confirm(ctx); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(u.dir, "pending.json"))
+
+	data, err := json.Marshal(imageRecord{Version: version})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(u.dir, "image.json")
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	u.imageRecord = version
+	u.mu.Unlock()
+	if err := u.refreshSlots(); err != nil {
+		c.logger.WithError(err).Warn("Failed to read A/B slot status")
+	}
+	return nil
+}
+
+// handleUpdateMessage applies a rollout directive: a halted rollout stops
+// the download in progress, a newer version selecting this device is
+// downloaded and installed. A directive delivered again after a reconnect
+// changes nothing
+func (c *Collector) handleUpdateMessage(_ mqtt.Client, msg mqtt.Message) {
+	u := c.updates
+	u.mu.Lock()
+	same := bytes.Equal(u.raw, msg.Payload())
+	u.raw = bytes.Clone(msg.Payload())
+	installing := u.state == updateInstalled
+	u.mu.Unlock()
+	if same || installing || len(msg.Payload()) == 0 {
+		return
+	}
+
+	d, err := update.Parse(msg.Payload())
+	if err == nil && d.Channel != c.config.Update.Channel {
+		err = errors.New("rollout " + d.ID + " is for channel " + d.Channel)
+	}
+	if err != nil {
+		c.logger.WithError(err).Warn("Ignoring invalid rollout directive")
+		c.reportError("update", err)
+		return
+	}
+
+	current, artifact, ok := Version, update.Artifact{}, false
+	timeout := c.config.Update.Timeout
+	if d.Kind == update.KindImage {
+		current, timeout = u.imageVersion(), c.config.Update.Image.Timeout
+		if u.image != nil {
+			if deviceType, err := u.image.DeviceType(); err == nil {
+				artifact, ok = d.Image(deviceType)
+			}
+		}
+	} else {
+		artifact, ok = d.Artifact()
+	}
+
+	fields := map[string]interface{}{"rollout": d.ID, "version": d.Version, "channel": d.Channel, "from": current, "kind": d.Kind}
+	u.mu.Lock()
+	if u.download != nil {
+		u.download()
+		u.download = nil
+	}
+	u.mu.Unlock()
+
+	switch {
+	case update.Compare(d.Version, current) <= 0:
+		u.set(updateCurrent, d.ID, d.Version, "")
+		return
+	case d.Halted:
+		u.set(updateHalted, d.ID, d.Version, "")
+		c.logger.WithFields(logrus.Fields(fields)).Info("Rollout halted")
+		return
+	case !d.Selects(c.config.Device.ID, c.config.Device.Tags):
+		u.set(updateNotSelected, d.ID, d.Version, "")
+		return
+	case !ok:
+		u.set(updateUnsupported, d.ID, d.Version, "")
+		c.logger.WithFields(logrus.Fields(fields)).Warn("Rollout has no artifact for this device")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(u.ctx, timeout)
+	u.mu.Lock()
+	u.download = cancel
+	u.mu.Unlock()
+	u.set(updateDownloading, d.ID, d.Version, "")
+	c.logger.WithFields(logrus.Fields(fields)).Info("Starting update")
+	c.publishEvent("update_started", fields)
+
+	go func() {
+		defer cancel()
+		var err error
+		if d.Kind == update.KindImage {
+			err = c.installImage(ctx, d, artifact)
+		} else {
+			err = c.installUpdate(ctx, d, artifact)
+		}
+		if errors.Is(err, context.Canceled) {
+			// Replaced by a newer directive, which reports its own state,
+			// or stopping
+			return
+		}
+
+		result := "applied"
+		if err != nil {
+			result = "failed"
+			u.set(updateFailed, d.ID, d.Version, err.Error())
+			fields["error"] = err.Error()
+			c.logger.WithError(err).WithFields(logrus.Fields{"rollout": d.ID, "version": d.Version}).Warn("Update failed")
+			c.reportError("update", err)
+			c.publishEvent("update_failed", fields)
+		} else {
+			u.set(updateInstalled, d.ID, d.Version, "")
+			if d.Kind == update.KindImage {
+				c.logger.WithFields(logrus.Fields(fields)).Info("Image installed, rebooting into the new slot")
+			} else {
+				c.logger.WithFields(logrus.Fields(fields)).Info("Update installed, restarting")
+			}
+			c.publishEvent("update_installed", fields)
+		}
+		if _, aErr := c.audit.Append("control-plane", "update", d.ID, result, fields); aErr != nil {
+			c.logger.WithError(aErr).Warn("Failed to write audit entry")
+		}
+		switch {
+		case err != nil:
+		case d.Kind == update.KindImage:
+			c.reboot()
+		default:
+			c.requestRestart()
+		}
+	}()
+}
+
+// installUpdate downloads and verifies the binary, replaces the running one
+// and records the update for the restart
+func (c *Collector) installUpdate(ctx context.Context, d *update.Directive, artifact update.Artifact) error {
+	u := c.updates
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	downloaded, err := update.Download(ctx, u.client, artifact, u.dir, c.config.Update.MaxBytes)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		os.Remove(downloaded)
+		return err
+	}
+
+	data, err := json.Marshal(pendingUpdate{Rollout: d.ID, From: Version, To: d.Version})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(u.dir, "pending.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		os.Remove(downloaded)
+		return err
+	}
+	if err := update.Install(exe, downloaded); err != nil {
+		os.Remove(path)
+		os.Remove(downloaded)
+		return err
+	}
+	return nil
+}
+
+// installImage downloads and verifies the image and installs it into the
+// inactive slot. The pending update records the slot booted now, so the
+// next start can tell whether the bootloader fell back
+func (c *Collector) installImage(ctx context.Context, d *update.Directive, artifact update.Artifact) error {
+	u := c.updates
+	downloaded, err := update.Download(ctx, u.client, artifact, u.dir, c.config.Update.Image.MaxBytes)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(downloaded)
+
+	u.mu.Lock()
+	booted := u.slots.Booted
+	u.mu.Unlock()
+	data, err := json.Marshal(pendingUpdate{
+		Rollout: d.ID,
+		From:    u.imageVersion(),
+		To:      d.Version,
+		Kind:    update.KindImage,
+		System:  u.image.Name(),
+		Slot:    booted,
+	})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(u.dir, "pending.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	if err := u.image.Install(ctx, downloaded); err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err := u.refreshSlots(); err != nil {
+		c.logger.WithError(err).Warn("Failed to read A/B slot status")
+	}
+	return nil
+}
+
+// reboot runs the reboot command so the bootloader tries the new slot. When
+// it fails the slot is tried on the next reboot
+func (c *Collector) reboot() {
+	args := c.config.Update.Image.RebootCommand
+	if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+		c.logger.WithError(err).WithField("output", strings.TrimSpace(string(out))).Error("Failed to reboot into the new slot")
+		c.reportError("update", err)
+	}
+}
+
+// requestRestart asks the process to stop so the service manager starts
+// the new binary
+func (c *Collector) requestRestart() {
+	select {
+	case c.restart <- struct{}{}:
+	default:
+	}
+}
+
+// RestartRequested is signalled when the collector needs a restart, such as
+// after installing an update
+func (c *Collector) RestartRequested() <-chan struct{} {
+	return c.restart
+}
@@ -0,0 +1,188 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/uplink"
+	"github.com/sirupsen/logrus"
+)
+
+// uplinkMonitor follows the uplink carrying the device's traffic
+type uplinkMonitor struct {
+	detector *uplink.Detector
+	kick     chan struct{}
+
+	mu        sync.Mutex
+	path      uplink.Path // Empty while there is no default route
+	since     time.Time   // Since the current path carries traffic
+	tags      map[string]string
+	checks    int64
+	failovers int64
+	err       error // Why the last check failed
+}
+
+// newUplinkMonitor creates the detector
+func (c *Collector) newUplinkMonitor() *uplinkMonitor {
+	return &uplinkMonitor{
+		detector: uplink.New(c.config.Uplink.Kinds),
+		kick:     make(chan struct{}, 1),
+	}
+}
+
+// Map returns the active uplink for the heartbeat
+func (m *uplinkMonitor) Map() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := map[string]interface{}{
+		"checks":    m.checks,
+		"failovers": m.failovers,
+	}
+	if m.path.Interface != "" {
+		out["interface"] = m.path.Interface
+		out["kind"] = m.path.Kind
+		out["since"] = m.since.Unix()
+		if m.path.Gateway != "" {
+			out["gateway"] = m.path.Gateway
+		}
+	}
+	if m.err != nil {
+		out["error"] = m.err.Error()
+	}
+	return out
+}
+
+// telemetryTags returns the tags of the device's own records: the device
+// tags, with the active uplink when it is followed
+func (c *Collector) telemetryTags() map[string]string {
+	if c.uplink == nil {
+		return c.config.Device.Tags
+	}
+	c.uplink.mu.Lock()
+	defer c.uplink.mu.Unlock()
+	if c.uplink.tags == nil {
+		return c.config.Device.Tags
+	}
+	return c.uplink.tags
+}
+
+// checkUplink asks for a check now, as when the connection was lost
+func (c *Collector) checkUplink() {
+	if c.uplink == nil {
+		return
+	}
+	select {
+	case c.uplink.kick <- struct{}{}:
+	default:
+	}
+}
+
+// uplinkLoop checks the active uplink at startup, every interval and when
+// the connection is lost
+func (c *Collector) uplinkLoop(ctx context.Context) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.config.Uplink.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.runUplinkCheck()
+		select {
+		case <-ticker.C:
+		case <-c.uplink.kick:
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// runUplinkCheck finds the active uplink and publishes an event when
+// traffic moves to another one, or when there is none
+func (c *Collector) runUplinkCheck() {
+	m := c.uplink
+	span := c.resources.Start("input.uplink")
+	path, err := m.detector.Active()
+	span.End(1)
+	if err != nil && !errors.Is(err, uplink.ErrNoRoute) {
+		m.mu.Lock()
+		first := m.err == nil
+		m.checks++
+		m.err = err
+		m.mu.Unlock()
+		if first {
+			c.logger.WithError(err).Warn("Failed to detect the active uplink")
+			c.reportError("uplink", err)
+		}
+		return
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	prev, since := m.path, m.since
+	m.checks++
+	m.err = nil
+	changed := path != prev
+	if changed {
+		m.path = path
+		m.since = now
+		m.tags = nil
+		if path.Interface != "" {
+			// Shared by records, so replaced rather than changed
+			m.tags = maps.Clone(c.config.Device.Tags)
+			if m.tags == nil {
+				m.tags = make(map[string]string, 2)
+			}
+			m.tags["uplink"] = path.Kind
+			m.tags["uplink_interface"] = path.Interface
+		}
+		if prev.Interface != "" && path.Interface != "" && prev.Interface != path.Interface {
+			m.failovers++
+		}
+	}
+	m.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	logger := c.logger.WithFields(logrus.Fields{"interface": path.Interface, "kind": path.Kind})
+	switch {
+	case since.IsZero():
+		// The first uplink found is no failover
+		logger.Info("Active uplink detected")
+	case path.Interface == "":
+		logger = c.logger.WithFields(logrus.Fields{"interface": prev.Interface, "kind": prev.Kind})
+		logger.Warn("Uplink lost, no default route")
+		c.publishEvent("uplink_lost", map[string]interface{}{
+			"interface":        prev.Interface,
+			"kind":             prev.Kind,
+			"duration_seconds": int64(now.Sub(since).Seconds()),
+		})
+	case prev.Interface == "":
+		logger.Info("Uplink restored")
+		fields := uplinkFields(path)
+		fields["down_seconds"] = int64(now.Sub(since).Seconds())
+		c.publishEvent("uplink_restored", fields)
+	case prev.Interface != path.Interface:
+		logger.WithField("from", prev.Interface).Warn("Uplink failover")
+		fields := uplinkFields(path)
+		fields["from_interface"] = prev.Interface
+		fields["from_kind"] = prev.Kind
+		fields["duration_seconds"] = int64(now.Sub(since).Seconds())
+		c.publishEvent("uplink_failover", fields)
+	default:
+		logger.WithField("gateway", path.Gateway).Info("Uplink gateway changed")
+	}
+}
+
+// uplinkFields describes an uplink in events
+func uplinkFields(path uplink.Path) map[string]interface{} {
+	fields := map[string]interface{}{"interface": path.Interface, "kind": path.Kind}
+	if path.Gateway != "" {
+		fields["gateway"] = path.Gateway
+	}
+	return fields
+}
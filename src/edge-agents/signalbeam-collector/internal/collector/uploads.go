@@ -0,0 +1,321 @@
+package collector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/s3"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/upload"
+	"github.com/sirupsen/logrus"
+)
+
+// uploads runs bulk uploads requested by the Control Plane, one at a time
+type uploads struct {
+	cfg    config.UploadsConfig
+	client *s3.Client
+	roots  []string
+	busy   chan struct{} // Holds a token while an upload runs
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	completed atomic.Int64
+	failed    atomic.Int64
+	rejected  atomic.Int64
+	bytes     atomic.Int64
+}
+
+// newUploads creates the uploader. Connections follow the egress allowlist
+func (c *Collector) newUploads(cfg config.UploadsConfig) (*uploads, error) {
+	opts := s3.Options{Dial: c.dialOutput}
+	if cfg.S3.Enabled {
+		tlsCfg, err := mqttTLSConfig(cfg.S3.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.Endpoint = cfg.S3.Endpoint
+		opts.Region = cfg.S3.Region
+		opts.Bucket = cfg.S3.Bucket
+		opts.AccessKey = cfg.S3.AccessKey
+		opts.SecretKey = cfg.S3.SecretKey
+		opts.PathStyle = cfg.S3.PathStyle
+		opts.TLS = tlsCfg
+	}
+	client, err := s3.New(opts)
+	if err != nil {
+		return nil, fmt.Errorf("uploads.s3: %w", err)
+	}
+
+	roots := cfg.Roots
+	if len(roots) == 0 {
+		roots = []string{state.Resolve(c.config.State).Dir}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &uploads{
+		cfg:    cfg,
+		client: client,
+		roots:  roots,
+		busy:   make(chan struct{}, 1),
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// Map returns the upload counters for the heartbeat
+func (u *uploads) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"running":   len(u.busy),
+		"completed": u.completed.Load(),
+		"failed":    u.failed.Load(),
+		"rejected":  u.rejected.Load(),
+		"bytes":     u.bytes.Load(),
+	}
+}
+
+// uploadTopic returns the topic upload requests arrive on
+func (c *Collector) uploadTopic() string {
+	return c.expandTopic(c.config.Uploads.Topic, "uploads")
+}
+
+// subscribeUploads accepts upload requests from the Control Plane
+func (c *Collector) subscribeUploads(client mqtt.Client) {
+	topic := c.uploadTopic()
+	token := client.Subscribe(topic, 1, c.handleUploadMessage)
+	if token.Wait() && token.Error() != nil {
+		c.logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to subscribe to upload topic")
+		c.reportError("uploads", token.Error())
+	}
+}
+
+// handleUploadMessage starts a requested upload in the background. Requests
+// arriving while one runs are answered busy so the Control Plane can retry
+func (c *Collector) handleUploadMessage(_ mqtt.Client, msg mqtt.Message) {
+	u := c.uploads
+	req, err := upload.Parse(msg.Payload())
+	if err != nil {
+		u.rejected.Add(1)
+		c.logger.WithError(err).Warn("Ignoring invalid upload request")
+		c.reportError("uploads", err)
+		return
+	}
+	if req.Expired(time.Now()) {
+		u.rejected.Add(1)
+		c.sendUploadResult(req, "expired", 0, "", 0, nil)
+		return
+	}
+
+	select {
+	case u.busy <- struct{}{}:
+	default:
+		u.rejected.Add(1)
+		c.sendUploadResult(req, "busy", 0, "", 0, nil)
+		return
+	}
+
+	go func() {
+		defer func() { <-u.busy }()
+
+		start := time.Now()
+		n, sum, err := c.runUpload(req)
+		if u.ctx.Err() != nil {
+			return
+		}
+
+		status, result := "completed", "applied"
+		logger := c.logger.WithFields(logrus.Fields{"upload": req.ID, "artifact": req.Artifact, "destination": req.Destination()})
+		if err != nil {
+			status, result = "failed", "failed"
+			u.failed.Add(1)
+			logger.WithError(err).Warn("Upload failed")
+			c.reportError("uploads", err)
+		} else {
+			u.completed.Add(1)
+			u.bytes.Add(n)
+			logger.WithField("bytes", n).Info("Upload completed")
+		}
+
+		details := map[string]interface{}{"artifact": req.Artifact, "destination": req.Destination(), "bytes": n}
+		if _, aErr := c.audit.Append("control-plane", "upload", req.ID, result, details); aErr != nil {
+			c.logger.WithError(aErr).Warn("Failed to write audit entry")
+		}
+		c.sendUploadResult(req, status, n, sum, time.Since(start), err)
+	}()
+}
+
+// runUpload sends the requested artifact and returns its size and SHA-256
+func (c *Collector) runUpload(req upload.Request) (int64, string, error) {
+	u := c.uploads
+	f, cleanup, err := c.openArtifact(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer cleanup()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	size := info.Size()
+	if size > u.cfg.MaxBytes {
+		return 0, "", fmt.Errorf("artifact is %d bytes, more than uploads.max_bytes", size)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(u.ctx, u.cfg.Timeout)
+	defer cancel()
+	if req.URL != "" {
+		header := make(http.Header, len(req.Headers))
+		for name, value := range req.Headers {
+			header.Set(name, value)
+		}
+		err = u.client.PutURL(ctx, req.URL, header, f, size)
+	} else {
+		key := path.Join(u.cfg.S3.Prefix, c.config.Device.ID, req.Key)
+		err = u.client.PutReader(ctx, key, f, size, artifactContentType(req))
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return size, sum, nil
+}
+
+// openArtifact opens the file to upload, building the diagnostics bundle
+// or capturing the screenshot first when requested. cleanup closes and
+// removes what was opened
+func (c *Collector) openArtifact(req upload.Request) (*os.File, func(), error) {
+	switch req.Artifact {
+	case "diagnostics":
+		f, err := c.diagnosticsBundle()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to build diagnostics bundle: %w", err)
+		}
+		return f, func() {
+			f.Close()
+			os.Remove(f.Name())
+		}, nil
+	case "screenshot":
+		f, err := c.screenshot(c.uploads.ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, func() {
+			f.Close()
+			os.Remove(f.Name())
+		}, nil
+	}
+
+	// Symlinks are resolved so a link inside a root cannot reach outside it
+	resolved, err := filepath.EvalSymlinks(req.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	allowed := false
+	for _, root := range c.uploads.roots {
+		if r, err := filepath.EvalSymlinks(root); err == nil && within(r, resolved) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, nil, fmt.Errorf("%s is outside the allowed roots %v", req.Path, c.uploads.roots)
+	}
+
+	f, err := os.Open(resolved)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s is not a regular file", req.Path)
+	}
+	return f, func() { f.Close() }, nil
+}
+
+// diagnosticsBundle writes the current heartbeat, recent traces and the
+// audit log to a temporary gzipped tar file in the state directory
+func (c *Collector) diagnosticsBundle() (*os.File, error) {
+	heartbeat, err := json.MarshalIndent(c.heartbeat(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	entries := []upload.Entry{{Name: "heartbeat.json", Data: heartbeat}}
+	if c.tracer != nil {
+		traces, err := json.MarshalIndent(c.Traces(), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, upload.Entry{Name: "traces.json", Data: traces})
+	}
+	paths := state.Resolve(c.config.State)
+	entries = append(entries, upload.Entry{Name: "audit.log", Path: paths.AuditFile})
+
+	f, err := os.CreateTemp(paths.Dir, ".diagnostics-*.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+	if err := upload.WriteBundle(f, entries); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// artifactContentType returns the Content-Type for bucket uploads
+func artifactContentType(req upload.Request) string {
+	switch {
+	case req.Artifact == "diagnostics":
+		return "application/gzip"
+	case req.Artifact == "screenshot":
+		return "image/jpeg"
+	case filepath.Ext(req.Path) == ".parquet":
+		return "application/vnd.apache.parquet"
+	}
+	return "application/octet-stream"
+}
+
+// sendUploadResult reports the outcome of a request as an event
+func (c *Collector) sendUploadResult(req upload.Request, status string, n int64, sum string, took time.Duration, uploadErr error) {
+	fields := map[string]interface{}{
+		"upload_id":   req.ID,
+		"artifact":    req.Artifact,
+		"destination": req.Destination(),
+		"status":      status,
+	}
+	if status == "completed" {
+		fields["bytes"] = n
+		fields["sha256"] = sum
+	}
+	if took > 0 {
+		fields["duration_ms"] = durationMillis(took)
+	}
+	if uploadErr != nil {
+		fields["error"] = uploadErr.Error()
+	}
+	c.publishEvent("upload", fields)
+}
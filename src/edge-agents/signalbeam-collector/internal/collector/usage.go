@@ -0,0 +1,190 @@
+package collector
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	psnet "github.com/shirou/gopsutil/v3/net"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/datausage"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+// usageMonitor accounts the traffic of metered interfaces and thins out
+// telemetry that is not critical as the quota runs out
+type usageMonitor struct {
+	meter      *datausage.Meter
+	throttling atomic.Bool  // Throttled types are being thinned out
+	dropped    atomic.Int64 // Records dropped by throttling
+
+	mu     sync.Mutex
+	seen   map[string]int64 // Records of throttled types while throttling
+	share  float64          // Of the quota used, at the last update
+	totals datausage.Counters
+}
+
+// newUsageMonitor loads the usage of the current billing cycle
+func (c *Collector) newUsageMonitor() (*usageMonitor, error) {
+	path := filepath.Join(state.Resolve(c.config.State).Dir, "data-usage.json")
+	meter, err := datausage.Open(path, c.config.DataUsage.ResetDay)
+	if err != nil {
+		return nil, err
+	}
+	return &usageMonitor{meter: meter, seen: make(map[string]int64)}, nil
+}
+
+// Map returns the usage of the cycle for the heartbeat
+func (m *usageMonitor) Map() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return map[string]interface{}{
+		"used":      m.totals.Total(),
+		"share":     m.share,
+		"throttled": m.throttling.Load(),
+		"dropped":   m.dropped.Load(),
+	}
+}
+
+// throttle reports whether a record is dropped to save data: while
+// throttling, only one in keep_every records of the throttled types passes
+func (c *Collector) throttle(dataType string) bool {
+	m := c.usage
+	if m == nil || !m.throttling.Load() {
+		return false
+	}
+	cfg := c.config.DataUsage.Throttle
+	if !slices.Contains(cfg.Types, dataType) {
+		return false
+	}
+	m.mu.Lock()
+	n := m.seen[dataType]
+	m.seen[dataType]++
+	m.mu.Unlock()
+	if cfg.KeepEvery > 0 && n%int64(cfg.KeepEvery) == 0 {
+		return false
+	}
+	m.dropped.Add(1)
+	return true
+}
+
+// usageLoop accounts the interfaces and publishes the usage every interval
+func (c *Collector) usageLoop(ctx context.Context) {
+	defer c.wg.Done()
+	// The first reading sets the baseline of interfaces not seen before
+	c.updateUsage(false)
+
+	ticker := time.NewTicker(c.config.DataUsage.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.updateUsage(true)
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// updateUsage reads the interface counters, switches throttling and sends
+// a usage record. Passing a share of the quota raises an event once a cycle
+func (c *Collector) updateUsage(send bool) {
+	cfg := c.config.DataUsage
+	m := c.usage
+	now := time.Now()
+
+	span := c.resources.Start("input.usage")
+	counters, err := psnet.IOCounters(true)
+	if err != nil {
+		span.End(0)
+		c.reportError("usage", err)
+		return
+	}
+	readings := make(map[string]datausage.Counters, len(cfg.Interfaces))
+	for _, io := range counters {
+		if slices.Contains(cfg.Interfaces, io.Name) {
+			readings[io.Name] = datausage.Counters{Sent: io.BytesSent, Recv: io.BytesRecv}
+		}
+	}
+	span.End(len(readings))
+	if err := m.meter.Update(readings, now); err != nil {
+		c.logger.WithError(err).Warn("Failed to save data usage")
+		c.reportError("usage", err)
+	}
+
+	snap := m.meter.Snapshot(now)
+	used := snap.Total.Total()
+	share := 0.0
+	if cfg.Quota > 0 {
+		share = float64(used) / float64(cfg.Quota)
+	}
+	m.mu.Lock()
+	m.share, m.totals = share, snap.Total
+	m.mu.Unlock()
+
+	throttle := len(cfg.Throttle.Types) > 0 && share >= cfg.Throttle.At
+	if was := m.throttling.Swap(throttle); was != throttle {
+		logger := c.logger.WithFields(logrus.Fields{"used": used, "quota": cfg.Quota, "types": cfg.Throttle.Types})
+		if throttle {
+			logger.Warn("Data quota nearly used, throttling telemetry")
+		} else {
+			m.mu.Lock()
+			m.seen = make(map[string]int64)
+			m.mu.Unlock()
+			logger.Info("Data usage below the throttle level, telemetry resumed")
+		}
+	}
+
+	fields := map[string]interface{}{
+		"used":        used,
+		"quota":       cfg.Quota,
+		"share":       share,
+		"cycle_start": snap.CycleStart.Unix(),
+		"cycle_end":   snap.CycleEnd.Unix(),
+		"throttled":   throttle,
+	}
+	if cfg.Quota > 0 {
+		levels := []struct {
+			share float64
+			event string
+		}{{cfg.Throttle.At, "data_quota_warning"}, {1, "data_quota_exceeded"}}
+		for _, l := range levels {
+			if share >= l.share && m.meter.Notify(l.share) {
+				c.publishEvent(l.event, fields)
+			}
+		}
+	}
+	if !send {
+		return
+	}
+
+	data := map[string]interface{}{
+		"sent":       snap.Total.Sent,
+		"recv":       snap.Total.Recv,
+		"dropped":    m.dropped.Load(),
+		"interfaces": toPlainCounters(snap.Interfaces),
+	}
+	for k, v := range fields {
+		data[k] = v
+	}
+	if cfg.Quota > 0 {
+		data["remaining"] = max(cfg.Quota-int64(used), 0)
+	}
+	if err := c.sendTelemetry("usage", c.newTelemetry("usage", data)); err != nil {
+		c.logger.WithError(err).Warn("Failed to send data usage")
+	}
+}
+
+// toPlainCounters converts usage per interface to telemetry fields
+func toPlainCounters(in map[string]datausage.Counters) map[string]interface{} {
+	out := make(map[string]interface{}, len(in))
+	for name, c := range in {
+		out[name] = c.Map()
+	}
+	return out
+}
@@ -0,0 +1,155 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// knownTypes lists the telemetry types accepted by the ingestion pipeline
+var knownTypes = map[string]bool{
+	"metrics":     true,
+	"logs":        true,
+	"events":      true,
+	"diagnostics": true,
+	"sensors":     true,
+	"polls":       true,
+	"inventory":   true,
+	"compliance":  true,
+	"flows":       true,
+	"speedtest":   true,
+	"usage":       true,
+	"display":     true,
+}
+
+// validateTelemetry checks a record against the telemetry schema: required
+// envelope fields, JSON-representable data and tag limits
+func (c *Collector) validateTelemetry(t TelemetryData) error {
+	limits := c.config.Validation
+
+	if t.DeviceID == "" {
+		return fmt.Errorf("device_id is required")
+	}
+	if t.Timestamp.IsZero() {
+		return fmt.Errorf("timestamp is required")
+	}
+	if !knownTypes[t.Type] {
+		return fmt.Errorf("unknown type %q", t.Type)
+	}
+	if t.Data == nil {
+		return fmt.Errorf("data is required")
+	}
+
+	if limits.MaxTags > 0 && len(t.Tags) > limits.MaxTags {
+		return fmt.Errorf("%d tags exceeds limit of %d", len(t.Tags), limits.MaxTags)
+	}
+	for k, v := range t.Tags {
+		if k == "" {
+			return fmt.Errorf("empty tag key")
+		}
+		if limits.MaxTagLength > 0 && (len(k) > limits.MaxTagLength || len(v) > limits.MaxTagLength) {
+			return fmt.Errorf("tag %q exceeds length limit of %d", k, limits.MaxTagLength)
+		}
+	}
+
+	return validateValue("data", reflect.ValueOf(t.Data))
+}
+
+// validateValue rejects values that cannot be encoded as JSON
+func validateValue(path string, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Invalid, reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("%s: non-finite number", path)
+		}
+		return nil
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return validateValue(path, v.Elem())
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("%s: map keys must be strings", path)
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := validateValue(path+"."+iter.Key().String(), iter.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(fmt.Sprintf("%s[%d]", path, i), v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if err := validateValue(path+"."+v.Type().Field(i).Name, v.Field(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s: unsupported type %s", path, v.Type())
+	}
+}
+
+// quarantine stores invalid records locally for later inspection
+type quarantine struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+}
+
+// store appends a rejected record with its reason to the daily quarantine file
+func (q *quarantine) store(t TelemetryData, reason error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	path := filepath.Join(q.dir, fmt.Sprintf("quarantine-%s.ndjson", time.Now().UTC().Format("20060102")))
+	if info, err := os.Stat(path); err == nil && q.maxBytes > 0 && info.Size() >= q.maxBytes {
+		return fmt.Errorf("quarantine file %s is full", path)
+	}
+
+	entry := map[string]interface{}{
+		"quarantined_at": time.Now().UTC(),
+		"reason":         reason.Error(),
+		"record":         t,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		// The record itself is not encodable, keep a textual copy instead
+		entry["record"] = fmt.Sprintf("%+v", t)
+		if line, err = json.Marshal(entry); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(q.dir, 0o750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,204 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/expr"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/units"
+)
+
+// virtualDevice computes metrics from values observed in other telemetry
+type virtualDevice struct {
+	cfg     config.VirtualDevice
+	metrics map[string]*expr.Expr
+	missing []string // Inputs unavailable at the last evaluation
+}
+
+// observedValue is the latest value seen for a virtual device input
+type observedValue struct {
+	value float64
+	at    time.Time
+}
+
+// virtualDevices tracks the inputs of every virtual device
+type virtualDevices struct {
+	mu       sync.Mutex
+	devices  []*virtualDevice
+	watched  map[string]bool // Input keys referenced by any device
+	observed map[string]observedValue
+}
+
+// newVirtualDevices prepares the configured virtual devices, parsing the
+// expression of every metric
+func newVirtualDevices(cfg []config.VirtualDevice) (*virtualDevices, error) {
+	v := &virtualDevices{
+		watched:  make(map[string]bool),
+		observed: make(map[string]observedValue),
+	}
+
+	for _, dc := range cfg {
+		d := &virtualDevice{cfg: dc, metrics: make(map[string]*expr.Expr)}
+		for name, source := range dc.Metrics {
+			e, err := expr.Parse(source)
+			if err != nil {
+				return nil, fmt.Errorf("virtual device %s metric %s: %w", dc.ID, name, err)
+			}
+			d.metrics[name] = e
+		}
+		for _, in := range dc.Inputs {
+			v.watched[inputKey(in.Type, in.Source, in.Path)] = true
+		}
+		v.devices = append(v.devices, d)
+	}
+
+	return v, nil
+}
+
+// inputKey identifies a value across telemetry types and bridge sources
+func inputKey(dataType, source, path string) string {
+	return dataType + "|" + source + "|" + path
+}
+
+// observe records watched values from an outgoing telemetry payload
+func (v *virtualDevices) observe(dataType string, data map[string]interface{}, at time.Time) {
+	source, _ := data["source"].(string)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	units.Walk(data, nil, func(path []string, _ map[string]interface{}, _ string, value interface{}) {
+		key := inputKey(dataType, source, strings.Join(path, "."))
+		if !v.watched[key] {
+			return
+		}
+		if f, ok := units.ToFloat(value); ok {
+			v.observed[key] = observedValue{value: f, at: at}
+		}
+	})
+}
+
+// evaluate computes a device's metrics from fresh inputs. Metrics whose
+// inputs are missing or stale are omitted
+func (v *virtualDevices) evaluate(d *virtualDevice, now time.Time) (map[string]interface{}, []error) {
+	v.mu.Lock()
+	vars := make(map[string]float64, len(d.cfg.Inputs))
+	var missing []string
+	for name, in := range d.cfg.Inputs {
+		obs, ok := v.observed[inputKey(in.Type, in.Source, in.Path)]
+		if !ok || now.Sub(obs.at) > d.cfg.StaleAfter {
+			missing = append(missing, name)
+			continue
+		}
+		vars[name] = obs.value
+	}
+	sort.Strings(missing)
+	d.missing = missing
+	v.mu.Unlock()
+
+	data := make(map[string]interface{}, len(d.metrics))
+	var errs []error
+	for name, e := range d.metrics {
+		value, err := e.Eval(vars)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		data[name] = value
+	}
+	return data, errs
+}
+
+// sendVirtualMetrics publishes the computed metrics of every virtual device
+func (c *Collector) sendVirtualMetrics() {
+	now := time.Now().UTC()
+	for _, d := range c.virtual.devices {
+		span := c.resources.Start("input.virtual")
+		data, errs := c.virtual.evaluate(d, now)
+		span.End(min(len(data), 1))
+		for _, err := range errs {
+			c.reportError("virtual."+d.cfg.ID, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		telemetry := c.newTelemetry("metrics", data)
+		telemetry.DeviceID = d.cfg.ID
+		telemetry.Tags = d.cfg.Tags
+		if err := c.sendTelemetry("metrics", telemetry); err != nil {
+			c.logger.WithError(err).WithField("virtual_device", d.cfg.ID).Warn("Failed to send virtual device metrics")
+		}
+	}
+}
+
+// sendVirtualHeartbeats publishes a heartbeat for every virtual device.
+// status is "offline" on shutdown so the platform can track their lifecycle
+func (c *Collector) sendVirtualHeartbeats(offline bool) {
+	for _, d := range c.virtual.devices {
+		c.virtual.mu.Lock()
+		missing := d.missing
+		c.virtual.mu.Unlock()
+
+		status := "online"
+		switch {
+		case offline:
+			status = "offline"
+		case len(missing) > 0:
+			status = "degraded"
+		}
+
+		heartbeat := map[string]interface{}{
+			"device_id":   d.cfg.ID,
+			"device_name": d.cfg.Name,
+			"location":    d.cfg.Location,
+			"timestamp":   time.Now().UTC().Unix(),
+			"status":      status,
+			"virtual":     true,
+			"parent":      c.config.Device.ID,
+		}
+		if org := c.config.Device.Org; org != "" {
+			heartbeat["org"] = org
+		}
+		if len(missing) > 0 {
+			heartbeat["missing_inputs"] = missing
+		}
+
+		data, err := json.Marshal(heartbeat)
+		if err != nil {
+			continue
+		}
+		topic := c.deviceTopic(d.cfg.ID, "heartbeat")
+		qos, retained := c.config.MQTT.Delivery("heartbeat")
+		if err := c.publish(topic, qos, retained, data); err != nil {
+			c.reportError("publish.heartbeat."+d.cfg.ID, err)
+		}
+	}
+}
+
+// virtualLoop periodically evaluates virtual devices
+func (c *Collector) virtualLoop(ctx context.Context) {
+	defer c.wg.Done()
+
+	interval := c.collectionInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.sendVirtualMetrics()
+			interval = c.adaptTicker(ticker, interval)
+		case <-c.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
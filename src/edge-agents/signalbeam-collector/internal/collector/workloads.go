@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/workload"
+	"github.com/sirupsen/logrus"
+)
+
+// workloads runs polling jobs the cloud assigns to the collector group
+type workloads struct {
+	group  string
+	runner *workload.Runner
+	slots  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	completed atomic.Int64
+	failed    atomic.Int64
+	busy      atomic.Int64
+	expired   atomic.Int64
+}
+
+// newWorkloads creates the job runner. Outbound connections follow the
+// egress allowlist
+func (c *Collector) newWorkloads(cfg config.WorkloadsConfig) *workloads {
+	check := func(host string, port int) error {
+		err := c.egress.Check(host, port)
+		c.reportEgress(err)
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workloads{
+		group:  cfg.Group,
+		runner: workload.NewRunner(cfg.Timeout, c.dialOutput, check),
+		slots:  make(chan struct{}, cfg.Concurrency),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Map returns the job counters for the heartbeat
+func (w *workloads) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"group":     w.group,
+		"running":   len(w.slots),
+		"completed": w.completed.Load(),
+		"failed":    w.failed.Load(),
+		"busy":      w.busy.Load(),
+		"expired":   w.expired.Load(),
+	}
+}
+
+// workloadTopic returns the job topic of the collector group. Handlers are
+// routed by this filter; the subscription itself is shared
+func (c *Collector) workloadTopic() string {
+	return strings.NewReplacer(
+		"{prefix}", c.config.MQTT.Topics.Prefix,
+		"{org}", c.config.Device.Org,
+		"{group}", c.config.Workloads.Group,
+	).Replace(c.config.Workloads.Topic)
+}
+
+// subscribeWorkloads joins the group's shared job subscription
+func (c *Collector) subscribeWorkloads(client mqtt.Client) {
+	topic := "$share/" + c.config.Workloads.Group + "/" + c.workloadTopic()
+	token := client.Subscribe(topic, 1, c.handleWorkloadMessage)
+	if token.Wait() && token.Error() != nil {
+		c.logger.WithError(token.Error()).WithField("topic", topic).Warn("Failed to subscribe to workload topic")
+		c.reportError("workloads", token.Error())
+	}
+}
+
+// handleWorkloadMessage starts a job received on the shared subscription.
+// When every slot is taken the job is reported busy so the cloud can
+// reassign it instead of it queueing behind slow targets
+func (c *Collector) handleWorkloadMessage(_ mqtt.Client, msg mqtt.Message) {
+	job, err := workload.Parse(msg.Payload())
+	if err != nil {
+		c.logger.WithError(err).Warn("Ignoring invalid workload job")
+		c.reportError("workloads", err)
+		return
+	}
+
+	w := c.workloads
+	if job.Expired(time.Now()) {
+		w.expired.Add(1)
+		c.logger.WithField("job", job.ID).Debug("Skipping expired workload job")
+		return
+	}
+
+	select {
+	case w.slots <- struct{}{}:
+	default:
+		w.busy.Add(1)
+		c.sendJobResult(job, "busy", 0, nil, nil)
+		return
+	}
+
+	go func() {
+		defer func() { <-w.slots }()
+
+		start := time.Now()
+		result, err := w.runner.Run(w.ctx, job)
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		status := "ok"
+		if err != nil {
+			status = "error"
+			w.failed.Add(1)
+			c.logger.WithError(err).WithFields(logrus.Fields{"job": job.ID, "target": job.Target}).Debug("Workload job failed")
+		} else {
+			w.completed.Add(1)
+		}
+		c.resources.Count("input.workloads", 1)
+		c.sendJobResult(job, status, time.Since(start), result, err)
+	}()
+}
+
+// sendJobResult publishes the outcome of a job as polls telemetry
+func (c *Collector) sendJobResult(job workload.Job, status string, took time.Duration, result map[string]interface{}, jobErr error) {
+	data := map[string]interface{}{
+		"job_id": job.ID,
+		"kind":   job.Kind,
+		"target": job.Target,
+		"group":  c.config.Workloads.Group,
+		"status": status,
+	}
+	if status != "busy" {
+		data["duration_ms"] = durationMillis(took)
+	}
+	if jobErr != nil {
+		data["error"] = jobErr.Error()
+	}
+	if len(result) > 0 {
+		data["result"] = result
+	}
+
+	if err := c.sendTelemetry("polls", c.newTelemetry("polls", data)); err != nil {
+		c.logger.WithError(err).WithField("job", job.ID).Warn("Failed to send workload result")
+	}
+}
@@ -0,0 +1,369 @@
+// Package compliance runs declarative checks in the style of CIS
+// benchmarks: file permissions and owners, settings in configuration files,
+// kernel parameters, services, the firewall and, when allowed, commands
+package compliance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/sysprobe"
+	"gopkg.in/yaml.v3"
+)
+
+// Check results
+const (
+	Pass  = "pass"
+	Fail  = "fail"
+	Error = "error"
+)
+
+// Profile is a set of checks
+type Profile struct {
+	Name    string  `yaml:"profile"`
+	Version string  `yaml:"version"`
+	Checks  []Check `yaml:"checks"`
+}
+
+// Check is one declarative check. Type selects the fields that apply
+type Check struct {
+	ID       string `yaml:"id"`
+	Title    string `yaml:"title"`
+	Severity string `yaml:"severity"`
+	Type     string `yaml:"type"` // file, setting, sysctl, service, firewall or command
+
+	// file: the file must exist, with permissions no wider than MaxMode and
+	// the given owner and group. setting: the file the key is set in
+	Path    string `yaml:"path"`
+	MaxMode string `yaml:"max_mode"` // Octal, e.g. "0640"
+	Owner   string `yaml:"owner"`    // User name or uid
+	Group   string `yaml:"group"`    // Group name or gid
+	Absent  bool   `yaml:"absent"`   // file: the file must not exist
+
+	// setting: the first "Key value" or "key=value" line for Key. Default
+	// applies when the key is not set. sysctl: the kernel parameter
+	Key     string `yaml:"key"`
+	Value   string `yaml:"value"`
+	Default string `yaml:"default"`
+
+	// service: the systemd unit and its expected state
+	Name    string `yaml:"name"`
+	State   string `yaml:"state"` // "running" or "stopped"
+	Enabled *bool  `yaml:"enabled"`
+
+	// firewall: whether packet filtering must be active, default true
+	Active *bool `yaml:"active"`
+
+	// command: passes when the command exits zero
+	Command []string `yaml:"command"`
+}
+
+// Result is the outcome of a check
+type Result struct {
+	ID       string `json:"id"`
+	Title    string `json:"title,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	Type     string `json:"type"`
+	Status   string `json:"status"` // pass, fail or error
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Map returns the result as telemetry fields
+func (r Result) Map() map[string]interface{} {
+	m := map[string]interface{}{"id": r.ID, "type": r.Type, "status": r.Status}
+	for key, value := range map[string]string{
+		"title":    r.Title,
+		"severity": r.Severity,
+		"expected": r.Expected,
+		"actual":   r.Actual,
+		"error":    r.Error,
+	} {
+		if value != "" {
+			m[key] = value
+		}
+	}
+	return m
+}
+
+// Parse reads a profile in JSON or YAML
+func Parse(data []byte) (*Profile, error) {
+	var p Profile
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid compliance profile: %w", err)
+	}
+	ids := make(map[string]bool, len(p.Checks))
+	for i, c := range p.Checks {
+		if c.ID == "" {
+			return nil, fmt.Errorf("checks[%d].id is required", i)
+		}
+		if ids[c.ID] {
+			return nil, fmt.Errorf("checks[%d]: duplicate id %q", i, c.ID)
+		}
+		ids[c.ID] = true
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("check %s: %w", c.ID, err)
+		}
+	}
+	return &p, nil
+}
+
+// validate checks that a check has the fields its type needs
+func (c Check) validate() error {
+	switch c.Type {
+	case "file":
+		if !filepath.IsAbs(c.Path) {
+			return errors.New("path must be absolute")
+		}
+		if c.MaxMode != "" {
+			if _, err := strconv.ParseUint(c.MaxMode, 8, 32); err != nil {
+				return errors.New("max_mode must be octal")
+			}
+		}
+	case "setting":
+		if !filepath.IsAbs(c.Path) || c.Key == "" {
+			return errors.New("setting needs an absolute path and a key")
+		}
+	case "sysctl":
+		if c.Key == "" {
+			return errors.New("sysctl needs a key")
+		}
+	case "service":
+		if c.Name == "" {
+			return errors.New("service needs a name")
+		}
+		if c.State != "" && c.State != "running" && c.State != "stopped" {
+			return errors.New("state must be running or stopped")
+		}
+	case "firewall":
+	case "command":
+		if len(c.Command) == 0 {
+			return errors.New("command must not be empty")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", c.Type)
+	}
+	return nil
+}
+
+// Runner runs checks. Command checks run only when allowed, since they
+// execute what the profile names
+type Runner struct {
+	AllowCommands bool
+	Timeout       time.Duration // Per command
+}
+
+// Run runs every check of a profile
+func (r *Runner) Run(p *Profile) []Result {
+	results := make([]Result, 0, len(p.Checks))
+	for _, c := range p.Checks {
+		res := Result{ID: c.ID, Title: c.Title, Severity: c.Severity, Type: c.Type}
+		expected, actual, ok, err := r.run(c)
+		res.Expected, res.Actual = expected, actual
+		switch {
+		case err != nil:
+			res.Status, res.Error = Error, err.Error()
+		case ok:
+			res.Status = Pass
+		default:
+			res.Status = Fail
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// run runs one check, returning what was expected and found
+func (r *Runner) run(c Check) (expected, actual string, ok bool, err error) {
+	switch c.Type {
+	case "file":
+		return checkFile(c)
+	case "setting":
+		return checkSetting(c)
+	case "sysctl":
+		got, err := sysprobe.Sysctl(c.Key)
+		if err != nil {
+			return c.Value, "", false, err
+		}
+		return c.Value, got, strings.Join(strings.Fields(got), " ") == strings.Join(strings.Fields(c.Value), " "), nil
+	case "service":
+		return checkService(c)
+	case "firewall":
+		want := c.Active == nil || *c.Active
+		active, detail, err := sysprobe.Firewall()
+		if err != nil {
+			return "", "", false, err
+		}
+		expected = "active"
+		if !want {
+			expected = "inactive"
+		}
+		return expected, detail, active == want, nil
+	case "command":
+		return r.runCommand(c)
+	}
+	return "", "", false, fmt.Errorf("unknown type %q", c.Type)
+}
+
+// checkFile compares a file's permissions and ownership with the limits
+func checkFile(c Check) (expected, actual string, ok bool, err error) {
+	info, err := os.Stat(c.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		if c.Absent {
+			return "absent", "absent", true, nil
+		}
+		return "present", "absent", false, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	if c.Absent {
+		return "absent", "present", false, nil
+	}
+
+	var want, got []string
+	ok = true
+	mode := uint64(info.Mode().Perm())
+	if c.MaxMode != "" {
+		limit, _ := strconv.ParseUint(c.MaxMode, 8, 32)
+		want = append(want, fmt.Sprintf("mode<=%04o", limit))
+		ok = ok && mode&^limit == 0
+	}
+	got = append(got, fmt.Sprintf("mode=%04o", mode))
+
+	if c.Owner != "" || c.Group != "" {
+		uid, gid, err := fileOwner(info)
+		if err != nil {
+			return "", "", false, err
+		}
+		if c.Owner != "" {
+			want = append(want, "owner="+c.Owner)
+			match, err := matchUser(c.Owner, uid)
+			if err != nil {
+				return "", "", false, err
+			}
+			ok = ok && match
+		}
+		if c.Group != "" {
+			want = append(want, "group="+c.Group)
+			match, err := matchGroup(c.Group, gid)
+			if err != nil {
+				return "", "", false, err
+			}
+			ok = ok && match
+		}
+		got = append(got, fmt.Sprintf("owner=%d", uid), fmt.Sprintf("group=%d", gid))
+	}
+	return strings.Join(want, " "), strings.Join(got, " "), ok, nil
+}
+
+// checkSetting finds the first line setting a key in a configuration file,
+// as sshd does, ignoring comments and the case of the key
+func checkSetting(c Check) (expected, actual string, ok bool, err error) {
+	data, err := os.ReadFile(c.Path)
+	if err != nil {
+		return c.Value, "", false, err
+	}
+	value, found := c.Default, false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() && !found {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, rest, cut := strings.Cut(line, "=")
+		if !cut || strings.ContainsAny(key, " \t") {
+			fields := strings.Fields(line)
+			key, rest = fields[0], strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		}
+		if strings.EqualFold(strings.TrimSpace(key), c.Key) {
+			value, found = strings.TrimSpace(rest), true
+		}
+	}
+	return c.Value, value, strings.EqualFold(value, c.Value), nil
+}
+
+// checkService compares a systemd unit with its expected state
+func checkService(c Check) (expected, actual string, ok bool, err error) {
+	var want, got []string
+	ok = true
+	if c.State != "" {
+		active, err := sysprobe.Systemctl("is-active", c.Name)
+		if err != nil {
+			return "", "", false, err
+		}
+		want, got = append(want, c.State), append(got, active)
+		ok = ok && (active == "active") == (c.State == "running")
+	}
+	if c.Enabled != nil {
+		enabled, err := sysprobe.Systemctl("is-enabled", c.Name)
+		if err != nil {
+			return "", "", false, err
+		}
+		if *c.Enabled {
+			want = append(want, "enabled")
+		} else {
+			want = append(want, "disabled")
+		}
+		got = append(got, enabled)
+		ok = ok && (enabled == "enabled") == *c.Enabled
+	}
+	return strings.Join(want, " "), strings.Join(got, " "), ok, nil
+}
+
+// runCommand runs a command check, which passes on exit status zero
+func (r *Runner) runCommand(c Check) (expected, actual string, ok bool, err error) {
+	if !r.AllowCommands {
+		return "", "", false, errors.New("command checks are not allowed on this device")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...).CombinedOutput()
+	actual = strings.TrimSpace(string(out))
+	if len(actual) > 256 {
+		actual = actual[:256]
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "exit 0", actual, true, nil
+	case errors.As(err, &exitErr) && ctx.Err() == nil:
+		return "exit 0", fmt.Sprintf("exit %d: %s", exitErr.ExitCode(), actual), false, nil
+	}
+	return "exit 0", actual, false, err
+}
+
+// matchUser reports whether a user name or uid is the given uid
+func matchUser(name string, uid uint32) (bool, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id) == uid, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return false, err
+	}
+	return u.Uid == strconv.FormatUint(uint64(uid), 10), nil
+}
+
+// matchGroup reports whether a group name or gid is the given gid
+func matchGroup(name string, gid uint32) (bool, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id) == gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return false, err
+	}
+	return g.Gid == strconv.FormatUint(uint64(gid), 10), nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package compliance
+
+import (
+	"errors"
+	"os"
+)
+
+// fileOwner is not available where files have no uid and gid
+func fileOwner(info os.FileInfo) (uid, gid uint32, err error) {
+	return 0, 0, errors.New("file owners are not supported on this platform")
+}
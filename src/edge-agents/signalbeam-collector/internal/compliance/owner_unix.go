@@ -0,0 +1,18 @@
+//go:build unix
+
+package compliance
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the uid and gid owning a file
+func fileOwner(info os.FileInfo) (uid, gid uint32, err error) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, errors.New("file owner is not available")
+	}
+	return st.Uid, st.Gid, nil
+}
@@ -0,0 +1,185 @@
+// Package compress shrinks telemetry payloads for links billed by volume.
+// A compressed payload is published as an envelope naming the algorithm, in
+// the encoding of the payload, so signing, encryption and the outputs handle
+// it like any other and the ingestion service knows how to decompress it
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/codec"
+)
+
+// Algorithms
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// maxSize bounds decompressed payloads, against envelopes that expand
+// without limit
+const maxSize = 64 << 20
+
+// Compressed is the envelope published in place of a compressed payload
+type Compressed struct {
+	Algorithm string `json:"zip"`
+	Size      int    `json:"size"` // Uncompressed bytes
+	Data      []byte `json:"data"` // Base64 in JSON
+}
+
+// Compressor compresses payloads of at least a minimum size and counts the
+// bytes saved
+type Compressor struct {
+	algorithm string
+	minBytes  int
+	codec     codec.Codec
+	gzips     sync.Pool
+	zstd      *zstd.Encoder
+
+	compressed atomic.Int64
+	skipped    atomic.Int64
+	rawBytes   atomic.Int64
+	wireBytes  atomic.Int64
+}
+
+// New creates a compressor writing envelopes in the given encoding.
+// Payloads smaller than minBytes are left as they are, since the envelope
+// would outweigh the savings
+func New(algorithm string, minBytes int, enc codec.Codec) (*Compressor, error) {
+	c := &Compressor{algorithm: algorithm, minBytes: minBytes, codec: enc}
+	switch algorithm {
+	case Gzip:
+		c.gzips.New = func() interface{} { return gzip.NewWriter(io.Discard) }
+	case Zstd:
+		zenc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		c.zstd = zenc
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q", algorithm)
+	}
+	return c, nil
+}
+
+// Algorithm returns the algorithm payloads are compressed with
+func (c *Compressor) Algorithm() string {
+	return c.algorithm
+}
+
+// Compress returns the envelope of a compressed payload. The payload is
+// returned unchanged, with false, when it is below the minimum size or does
+// not shrink
+func (c *Compressor) Compress(payload []byte) ([]byte, bool, error) {
+	if len(payload) < c.minBytes {
+		c.skip(len(payload))
+		return payload, false, nil
+	}
+
+	var packed []byte
+	switch c.algorithm {
+	case Gzip:
+		var buf bytes.Buffer
+		zw := c.gzips.Get().(*gzip.Writer)
+		zw.Reset(&buf)
+		if _, err := zw.Write(payload); err != nil {
+			return nil, false, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, false, err
+		}
+		c.gzips.Put(zw)
+		packed = buf.Bytes()
+	case Zstd:
+		packed = c.zstd.EncodeAll(payload, nil)
+	}
+
+	var data []byte
+	var err error
+	if c.codec.Binary() {
+		data, err = c.codec.Encode(map[string]interface{}{"zip": c.algorithm, "size": len(payload), "data": packed})
+	} else {
+		data, err = json.Marshal(Compressed{Algorithm: c.algorithm, Size: len(payload), Data: packed})
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) >= len(payload) {
+		c.skip(len(payload))
+		return payload, false, nil
+	}
+
+	c.compressed.Add(1)
+	c.rawBytes.Add(int64(len(payload)))
+	c.wireBytes.Add(int64(len(data)))
+	return data, true, nil
+}
+
+// skip counts a payload sent uncompressed
+func (c *Compressor) skip(n int) {
+	c.skipped.Add(1)
+	c.rawBytes.Add(int64(n))
+	c.wireBytes.Add(int64(n))
+}
+
+// Status reports the payloads compressed and the bytes saved
+func (c *Compressor) Status() map[string]interface{} {
+	raw, wire := c.rawBytes.Load(), c.wireBytes.Load()
+	ratio := 1.0
+	if raw > 0 {
+		ratio = float64(wire) / float64(raw)
+	}
+	return map[string]interface{}{
+		"algorithm":  c.algorithm,
+		"compressed": c.compressed.Load(),
+		"skipped":    c.skipped.Load(),
+		"raw_bytes":  raw,
+		"bytes":      wire,
+		"ratio":      ratio,
+	}
+}
+
+// Decompress returns the payload inside a JSON compressed envelope.
+// Payloads that are not compressed are returned as they are
+func Decompress(data []byte) ([]byte, error) {
+	var env Compressed
+	if json.Unmarshal(data, &env) != nil || env.Algorithm == "" || len(env.Data) == 0 {
+		return data, nil
+	}
+	packed := env.Data
+
+	var r io.Reader
+	switch env.Algorithm {
+	case Gzip:
+		zr, err := gzip.NewReader(bytes.NewReader(packed))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	case Zstd:
+		dec, err := zstd.NewReader(bytes.NewReader(packed), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		r = dec
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", env.Algorithm)
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > maxSize {
+		return nil, fmt.Errorf("compressed payload expands beyond %d bytes", maxSize)
+	}
+	return payload, nil
+}
@@ -1,24 +1,124 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"maps"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/azure"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/calibration"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/counter"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/expr"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/gpio"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/hwinfo"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/portal"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/proxy"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/schedule"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/topics"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/units"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/update"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/uplink"
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the edge collector configuration
 type Config struct {
-	Device     DeviceConfig     `yaml:"device"`
-	MQTT       MQTTConfig       `yaml:"mqtt"`
-	Collection CollectionConfig `yaml:"collection"`
-	Logging    LoggingConfig    `yaml:"logging"`
+	Device      DeviceConfig      `yaml:"device"`
+	MQTT        MQTTConfig        `yaml:"mqtt"`
+	Collection  CollectionConfig  `yaml:"collection"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	State       StateConfig       `yaml:"state"`
+	Power       PowerConfig       `yaml:"power"`
+	Runtime     RuntimeConfig     `yaml:"runtime"`
+	Heartbeat   HeartbeatConfig   `yaml:"heartbeat"`
+	Diagnostics DiagnosticsConfig `yaml:"diagnostics"`
+	Validation  ValidationConfig  `yaml:"validation"`
+	Cardinality CardinalityConfig `yaml:"cardinality"`
+	Units       UnitsConfig       `yaml:"units"`
+	Replay      ReplayConfig      `yaml:"replay"`
+	Routing     RoutingConfig     `yaml:"routing"`
+	Encoding    string            `yaml:"encoding"` // Telemetry payloads: "json" (default), "cbor" or "msgpack"
+	Compression CompressionConfig `yaml:"compression"`
+	Encryption  EncryptionConfig  `yaml:"encryption"`
+	Signing     SigningConfig     `yaml:"signing"`
+	LocalAPI    LocalAPIConfig    `yaml:"local_api"`
+	Egress      EgressConfig      `yaml:"egress"`
+	Decoders    DecodersConfig    `yaml:"decoders"`
+	Bridge      BridgeConfig      `yaml:"bridge"`
+	Quality     QualityConfig     `yaml:"quality"`
+	Exception   ExceptionConfig   `yaml:"report_by_exception"`
+	Virtual     []VirtualDevice   `yaml:"virtual_devices"`
+	Supervision SupervisionConfig `yaml:"supervision"`
+	Bootstrap   BootstrapConfig   `yaml:"bootstrap"`
+	Workloads   WorkloadsConfig   `yaml:"workloads"`
+	Accounting  AccountingConfig  `yaml:"accounting"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Fallback    FallbackConfig    `yaml:"https_fallback"`
+	Gateway     GatewayConfig     `yaml:"grpc"`
+	Export      ExportConfig      `yaml:"parquet_export"`
+	Outputs     []OutputConfig    `yaml:"outputs"`
+	OutputPush  OutputPushConfig  `yaml:"output_push"`
+	Uploads     UploadsConfig     `yaml:"uploads"`
+	Notify      NotifyConfig      `yaml:"notifications"`
+	Inventory   InventoryConfig   `yaml:"inventory"`
+	Drift       DriftConfig       `yaml:"drift"`
+	Compliance  ComplianceConfig  `yaml:"compliance"`
+	Flows       FlowsConfig       `yaml:"flows"`
+	Speedtest   SpeedtestConfig   `yaml:"speedtest"`
+	DataUsage   DataUsageConfig   `yaml:"data_usage"`
+	Portal      PortalConfig      `yaml:"captive_portal"`
+	Uplink      UplinkConfig      `yaml:"uplink"`
+	OffPeak     OffPeakConfig     `yaml:"off_peak"`
+	Budget      BudgetConfig      `yaml:"budget"`
+	Update      UpdateConfig      `yaml:"update"`
+	GPIO        GPIOConfig        `yaml:"gpio"`
+	Buffer      BufferConfig      `yaml:"buffer"`
+	Display     DisplayConfig     `yaml:"display"`
+	Kiosk       KioskConfig       `yaml:"kiosk"`
+	Peripherals PeripheralsConfig `yaml:"peripherals"`
+	Acks        AcksConfig        `yaml:"acks"`
+	Ingestion   IngestionConfig   `yaml:"ingestion"`
+	Labels      LabelsConfig      `yaml:"labels"`
+	Shutdown    ShutdownConfig    `yaml:"shutdown"`
+	// Backpressure stretches the collection interval while the outbound
+	// queue is long
+	Backpressure BackpressureConfig `yaml:"backpressure"`
+	// CloudThrottle lets the ingestion service slow the device down
+	CloudThrottle CloudThrottleConfig `yaml:"cloud_throttle"`
+	// Breaker stops publishing to an output that keeps failing
+	Breaker BreakerConfig `yaml:"breaker"`
+	// ACLCheck verifies the broker grants the topics the device uses
+	ACLCheck ACLCheckConfig `yaml:"acl_check"`
+	// Proxy carries the connections of the MQTT, HTTPS fallback and gRPC
+	// transports unless a transport sets its own
+	Proxy ProxyConfig `yaml:"proxy"`
+
+	// Profile selects a preset applied on top of the file ("default" or "minimal")
+	Profile string `yaml:"profile"`
+
+	// Bootstrapped is set when a bootstrapped document was layered over the file
+	Bootstrapped bool `yaml:"-"`
 }
 
 // DeviceConfig contains device-specific settings
 type DeviceConfig struct {
+	// Org is the organization (tenant) owning the device. When set, every
+	// topic lies under {prefix}/{org}, so one broker serves many fleets
+	Org      string            `yaml:"org"`
 	ID       string            `yaml:"id"`
+	IDSource string            `yaml:"id_source"` // "hostname" or "hardware", used when id is empty
 	Name     string            `yaml:"name"`
 	Location string            `yaml:"location"`
 	Tags     map[string]string `yaml:"tags"`
@@ -33,146 +133,3471 @@ type MQTTConfig struct {
 	QoS      byte          `yaml:"qos"`
 	Retained bool          `yaml:"retained"`
 	Timeout  time.Duration `yaml:"timeout"`
+	TLS      MQTTTLSConfig `yaml:"tls"`
 	Topics   TopicsConfig  `yaml:"topics"`
+	// PublishTimeout is how long a publish may await the broker's
+	// acknowledgement before it counts as expired
+	PublishTimeout time.Duration `yaml:"publish_timeout"`
+	MaxInFlight    int           `yaml:"max_in_flight"` // Publishes awaiting the broker at once; more are dropped
+	// Streams overrides qos and retained per data type
+	Streams  map[string]StreamConfig `yaml:"streams"`
+	Protocol string                  `yaml:"protocol"` // "3.1.1" or "5"
+	V5       MQTT5Config             `yaml:"v5"`
+	Session  SessionConfig           `yaml:"session"`
+	Will     WillConfig              `yaml:"will"`
+	Status   StatusConfig            `yaml:"status"`
+	// Reconnect sets the delay between reconnect attempts
+	Reconnect ReconnectConfig `yaml:"reconnect"`
+	// EchoProbe measures broker round-trip time via the echo topic
+	EchoProbe bool        `yaml:"echo_probe"`
+	Proxy     ProxyConfig `yaml:"proxy"` // Overrides the global proxy
+	// Control receives commands and pushed configuration on a connection
+	// of its own, so the credentials above only need to publish
+	Control ControlConfig `yaml:"control"`
+	// Token replaces the password with an access token that is refreshed
+	// before it expires
+	Token TokenConfig `yaml:"token"`
+	// Cloud adapts the connection to a managed IoT service
+	Cloud CloudConfig `yaml:"cloud"`
 }
 
-// TopicsConfig defines MQTT topic structure
-type TopicsConfig struct {
-	Prefix    string `yaml:"prefix"`
-	Metrics   string `yaml:"metrics"`
-	Logs      string `yaml:"logs"`
-	Events    string `yaml:"events"`
-	Heartbeat string `yaml:"heartbeat"`
+// CloudConfig selects the preset of a managed IoT service. It fills in the
+// client ID, username, topic template and TLS settings the service expects
+// where they are left unset, and rejects settings it does not support
+type CloudConfig struct {
+	Provider string         `yaml:"provider"` // "aws_iot", "azure_iot_hub" or empty for other brokers
+	Azure    AzureIoTConfig `yaml:"azure"`
 }
 
-// CollectionConfig defines what data to collect and how often
-type CollectionConfig struct {
-	Interval time.Duration     `yaml:"interval"`
-	Metrics  MetricsConfig     `yaml:"metrics"`
-	Logs     LogsConfig        `yaml:"logs"`
-	Events   EventsConfig      `yaml:"events"`
+// AzureIoTConfig authenticates to IoT Hub with SAS tokens signed by the
+// device key, unless mqtt.tls holds an X.509 client certificate. A token is
+// replaced RefreshBefore it expires, which reconnects
+type AzureIoTConfig struct {
+	SharedAccessKey     string        `yaml:"shared_access_key"`      // Base64 device key
+	SharedAccessKeyFile string        `yaml:"shared_access_key_file"` // Read for every token
+	TokenLifetime       time.Duration `yaml:"token_lifetime"`
+	RefreshBefore       time.Duration `yaml:"refresh_before"`
+	APIVersion          string        `yaml:"api_version"`
+	// Twin reports the collector version and configuration as reported
+	// properties of the device twin on every connect
+	Twin bool `yaml:"twin"`
 }
 
-// MetricsConfig defines system metrics collection
-type MetricsConfig struct {
-	Enabled bool `yaml:"enabled"`
-	CPU     bool `yaml:"cpu"`
-	Memory  bool `yaml:"memory"`
-	Disk    bool `yaml:"disk"`
-	Network bool `yaml:"network"`
-	Load    bool `yaml:"load"`
+// ControlConfig sets the credentials of the control connection. It shares
+// the broker, TLS trust and session settings of the telemetry connection
+type ControlConfig struct {
+	Enabled  bool        `yaml:"enabled"`
+	ClientID string      `yaml:"client_id"` // Default {mqtt.client_id}-control
+	Username string      `yaml:"username"`
+	Password string      `yaml:"password"`
+	CertFile string      `yaml:"cert_file"` // Client certificate for mutual TLS
+	KeyFile  string      `yaml:"key_file"`
+	Token    TokenConfig `yaml:"token"`
 }
 
-// LogsConfig defines log collection settings
-type LogsConfig struct {
-	Enabled bool     `yaml:"enabled"`
-	Paths   []string `yaml:"paths"`
-	Exclude []string `yaml:"exclude"`
+// TokenConfig fetches an access token with the OAuth 2.0 client credentials
+// grant and sends it as the MQTT password. The connection is re-established
+// with a fresh token RefreshBefore the current one expires
+type TokenConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	URL          string `yaml:"url"` // Token endpoint, https
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// ClientSecretFile is read on every request, so the secret can be
+	// rotated without a restart
+	ClientSecretFile string        `yaml:"client_secret_file"`
+	Scopes           []string      `yaml:"scopes"`
+	Audience         string        `yaml:"audience"`
+	RefreshBefore    time.Duration `yaml:"refresh_before"`
+	Timeout          time.Duration `yaml:"timeout"` // Per token request
 }
 
-// EventsConfig defines system event collection
-type EventsConfig struct {
-	Enabled bool `yaml:"enabled"`
-	Types   []string `yaml:"types"`
+// validate checks an enabled token source; section prefixes the messages.
+// The token takes the place of the password, so both cannot be set
+func (t TokenConfig) validate(section, password string) error {
+	if !t.Enabled {
+		return nil
+	}
+	u, err := url.Parse(t.URL)
+	switch {
+	case err != nil || u.Scheme != "https" || u.Host == "":
+		return fmt.Errorf("%s.url must be an https URL", section)
+	case t.ClientID == "":
+		return fmt.Errorf("%s.client_id is required", section)
+	case (t.ClientSecret == "") == (t.ClientSecretFile == ""):
+		return fmt.Errorf("%s needs one of client_secret and client_secret_file", section)
+	case password != "":
+		return fmt.Errorf("%s replaces the password, which must not be set", section)
+	case t.RefreshBefore <= 0 || t.Timeout <= 0:
+		return fmt.Errorf("%s.refresh_before and timeout must be positive", section)
+	}
+	return nil
 }
 
-// LoggingConfig defines collector logging settings
-type LoggingConfig struct {
-	Level  string `yaml:"level"`
-	Format string `yaml:"format"`
+// StreamConfig sets the delivery of one data type. Unset fields fall back to
+// mqtt.qos and mqtt.retained
+type StreamConfig struct {
+	QoS      *byte       `yaml:"qos"`
+	Retained *bool       `yaml:"retained"`
+	Batch    BatchConfig `yaml:"batch"`
 }
 
-// Load reads and parses the configuration file
-func Load(path string) (*Config, error) {
-	// Set defaults
-	cfg := &Config{
-		Device: DeviceConfig{
-			ID:   generateDeviceID(),
-			Name: "SignalBeam Edge Device",
-		},
-		MQTT: MQTTConfig{
-			Broker:   "tcp://localhost:1883",
-			ClientID: "",
-			QoS:      1,
-			Retained: false,
-			Timeout:  30 * time.Second,
-			Topics: TopicsConfig{
-				Prefix:    "signalbeam",
-				Metrics:   "metrics",
-				Logs:      "logs", 
-				Events:    "events",
-				Heartbeat: "heartbeat",
-			},
-		},
-		Collection: CollectionConfig{
-			Interval: 30 * time.Second,
-			Metrics: MetricsConfig{
-				Enabled: true,
-				CPU:     true,
-				Memory:  true,
-				Disk:    true,
-				Network: true,
-				Load:    true,
-			},
-			Logs: LogsConfig{
-				Enabled: false,
-				Paths:   []string{},
-			},
-			Events: EventsConfig{
-				Enabled: false,
-				Types:   []string{},
-			},
-		},
-		Logging: LoggingConfig{
-			Level:  "info",
-			Format: "text",
-		},
+// BatchConfig publishes the records of a stream together, as one message
+// holding an array. A batch is sent once it holds Size records or its first
+// record has waited Interval. Batching is off when Size is zero
+type BatchConfig struct {
+	Size     int           `yaml:"size"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// streamTypes lists the data types that accept stream settings
+var streamTypes = map[string]bool{
+	"metrics":     true,
+	"logs":        true,
+	"events":      true,
+	"heartbeat":   true,
+	"diagnostics": true,
+	"sensors":     true,
+	"polls":       true,
+	"inventory":   true,
+	"compliance":  true,
+	"flows":       true,
+	"speedtest":   true,
+	"usage":       true,
+	"display":     true,
+}
+
+// inventorySections lists the inventory sections that can be collected
+var inventorySections = map[string]bool{
+	"os":       true,
+	"packages": true,
+	"firmware": true,
+	"network":  true,
+	"disks":    true,
+	"usb":      true,
+	"pci":      true,
+}
+
+// TopicFields are the device fields topic templates may use besides tags
+var TopicFields = []string{"prefix", "org", "device_id", "device_name", "location", "type", "topic"}
+
+// validateTopicTemplate checks that the topic template keeps devices and
+// data types apart and that every device, virtual ones included, has the
+// tags it uses
+func (c *Config) validateTopicTemplate() error {
+	t := c.MQTT.Topics.Template
+	names, err := topics.Names(t)
+	if err != nil {
+		return fmt.Errorf("mqtt.topics.template: %w", err)
+	}
+	if !slices.Contains(names, "device_id") || (!slices.Contains(names, "type") && !slices.Contains(names, "topic")) {
+		return fmt.Errorf("mqtt.topics.template must contain {device_id} and {type} or {topic}")
+	}
+	if c.Device.Org != "" {
+		if _, err := c.TenantRoot(); err != nil {
+			return fmt.Errorf("mqtt.topics.template: %w", err)
+		}
 	}
+	return c.checkPlaceholders("mqtt.topics.template", names)
+}
 
-	// Read config file if it exists
-	if _, err := os.Stat(path); err == nil {
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read config file: %w", err)
+// checkPlaceholders checks that every device, virtual ones included, has
+// the tags among the placeholder names of the setting
+func (c *Config) checkPlaceholders(setting string, names []string) error {
+	for _, name := range names {
+		// Without an organization, {org} is the device tag of that name
+		if slices.Contains(TopicFields, name) && (name != "org" || c.Device.Org != "") {
+			continue
+		}
+		if _, ok := c.Device.Tags[name]; !ok {
+			return fmt.Errorf("%s: {%s} is neither a device field nor a tag in device.tags", setting, name)
+		}
+		for _, v := range c.Virtual {
+			if _, ok := v.Tags[name]; !ok {
+				return fmt.Errorf("%s: virtual device %s has no tag %q", setting, v.ID, name)
+			}
 		}
+	}
+	return nil
+}
 
-		if err := yaml.Unmarshal(data, cfg); err != nil {
-			return nil, fmt.Errorf("failed to parse config file: %w", err)
+// validateLabels checks the retention of the labels and the rule names
+func (c *Config) validateLabels() error {
+	check := func(setting string, l RecordLabels) error {
+		if l.Retention != "" && !validRetention(l.Retention) {
+			return fmt.Errorf("%s.retention must be a number with unit h, d, w or y, e.g. 30d", setting)
+		}
+		return nil
+	}
+	for _, typ := range slices.Sorted(maps.Keys(c.Labels.Types)) {
+		if !streamTypes[typ] {
+			return fmt.Errorf("labels.types: unknown data type %q", typ)
+		}
+		if err := check("labels.types."+typ, c.Labels.Types[typ]); err != nil {
+			return err
+		}
+	}
+	names := make(map[string]bool)
+	for i, r := range c.Labels.Rules {
+		switch {
+		case r.Name == "":
+			return fmt.Errorf("labels.rules[%d]: name is required", i)
+		case names[r.Name]:
+			return fmt.Errorf("labels.rules: duplicate name %q", r.Name)
+		case r.Match.Type != "" && !streamTypes[r.Match.Type]:
+			return fmt.Errorf("labels.rules.%s: unknown data type %q", r.Name, r.Match.Type)
+		}
+		if r.Match.Expression != "" {
+			if _, err := expr.Parse(r.Match.Expression); err != nil {
+				return fmt.Errorf("labels.rules.%s.match.expression: %w", r.Name, err)
+			}
+		}
+		names[r.Name] = true
+		if err := check("labels.rules."+r.Name, r.RecordLabels); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// Set client ID if empty
-	if cfg.MQTT.ClientID == "" {
-		cfg.MQTT.ClientID = fmt.Sprintf("signalbeam-%s", cfg.Device.ID)
+// validRetention reports whether s is a retention such as 30d
+func validRetention(s string) bool {
+	n, err := strconv.Atoi(s[:len(s)-1])
+	return err == nil && n > 0 && strings.ContainsAny(s[len(s)-1:], "hdwy")
+}
+
+// validateIngestion checks the placeholders of the ingestion metadata
+func (c *Config) validateIngestion() error {
+	check := func(setting string, r IngestionRouting) error {
+		fields := []struct{ name, value string }{{"dataset", r.Dataset}, {"pipeline", r.Pipeline}, {"retention_class", r.RetentionClass}}
+		for _, f := range fields {
+			names, err := topics.Names(f.value)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", setting, f.name, err)
+			}
+			if err := c.checkPlaceholders(setting+"."+f.name, names); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
+	if err := check("ingestion", c.Ingestion.IngestionRouting); err != nil {
+		return err
+	}
+	for _, typ := range slices.Sorted(maps.Keys(c.Ingestion.Types)) {
+		if !streamTypes[typ] {
+			return fmt.Errorf("ingestion.types: unknown data type %q", typ)
+		}
+		if err := check("ingestion.types."+typ, c.Ingestion.Types[typ]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// Validate configuration
-	if err := cfg.validate(); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
+// TenantRoot returns the topic levels every topic of the organization
+// starts with, the template up to {org} with a trailing separator. Only
+// {prefix} may come before {org}, so the root is the same for every device
+func (c *Config) TenantRoot() (string, error) {
+	t := c.MQTT.Topics.Template
+	i := strings.Index(t, "{org}/")
+	if i < 0 {
+		return "", fmt.Errorf("must contain {org}/ when device.org is set")
+	}
+	head := t[:i]
+	if strings.Contains(strings.ReplaceAll(head, "{prefix}", ""), "{") {
+		return "", fmt.Errorf("only {prefix} may come before {org}")
 	}
+	return strings.ReplaceAll(head, "{prefix}", c.MQTT.Topics.Prefix) + c.Device.Org + "/", nil
+}
 
-	return cfg, nil
+// applyTenant moves topics under the organization: templates starting with
+// {prefix}/ and without {org} get it inserted after the prefix, defaults
+// included
+func (c *Config) applyTenant() {
+	if c.Device.Org == "" {
+		return
+	}
+	templates := []*string{
+		&c.MQTT.Topics.Template, &c.Decoders.Topic, &c.Workloads.Topic, &c.Bootstrap.Topic,
+		&c.OutputPush.Topic, &c.Uploads.Topic, &c.Drift.Topic, &c.Compliance.Topic, &c.Update.Topic,
+		&c.GPIO.Topic, &c.Acks.Topic,
+	}
+	for i := range c.Routing.Rules {
+		templates = append(templates, &c.Routing.Rules[i].Topic)
+	}
+	for _, t := range templates {
+		if strings.HasPrefix(*t, "{prefix}/") && !strings.Contains(*t, "{org}") {
+			*t = "{prefix}/{org}/" + strings.TrimPrefix(*t, "{prefix}/")
+		}
+	}
 }
 
-// validate checks if the configuration is valid
-func (c *Config) validate() error {
-	if c.Device.ID == "" {
-		return fmt.Errorf("device.id is required")
+// Managed IoT services of mqtt.cloud.provider
+const (
+	CloudAWSIoT      = "aws_iot"
+	CloudAzureIoTHub = "azure_iot_hub"
+)
+
+// awsIoTALPN lets AWS IoT Core take MQTT with client certificates on port
+// 443
+const awsIoTALPN = "x-amzn-mqtt-ca"
+
+// defaultTopicTemplate is the topic template unless configured
+const defaultTopicTemplate = "{prefix}/{device_id}/{topic}/{type}"
+
+// applyCloud fills in what the managed IoT service expects where it is
+// unset: the device ID as client ID, ALPN for AWS IoT on port 443, and the
+// username and device-to-cloud topic of IoT Hub
+func (c *Config) applyCloud() {
+	m := &c.MQTT
+	if m.Cloud.Provider != CloudAWSIoT && m.Cloud.Provider != CloudAzureIoTHub {
+		return
 	}
-	if c.MQTT.Broker == "" {
-		return fmt.Errorf("mqtt.broker is required")
+	// AWS IoT policies commonly match the client ID to the thing name, and
+	// IoT Hub requires the device ID
+	if m.ClientID == "" {
+		m.ClientID = c.Device.ID
 	}
-	if c.Collection.Interval <= 0 {
-		return fmt.Errorf("collection.interval must be positive")
+	broker, err := url.Parse(m.Broker)
+	if err != nil {
+		return // Reported by validate
+	}
+
+	switch m.Cloud.Provider {
+	case CloudAWSIoT:
+		if broker.Port() == "443" && len(m.TLS.ALPN) == 0 {
+			m.TLS.ALPN = []string{awsIoTALPN}
+		}
+	case CloudAzureIoTHub:
+		if m.Username == "" {
+			m.Username = azure.IoTHubUsername(broker.Hostname(), c.Device.ID, m.Cloud.Azure.APIVersion)
+		}
+		if m.Topics.Template == defaultTopicTemplate {
+			// The property bag lets IoT Hub routes select by type, and by
+			// body when it is plain JSON
+			bag := "type={type}"
+			if c.Encoding == "json" && c.Compression.Algorithm == "none" && !c.Encryption.Enabled {
+				bag = "$.ct=application%2Fjson&$.ce=utf-8&" + bag
+			}
+			m.Topics.Template = azure.EventsTopic("{device_id}") + bag
+		}
+		// IoT Hub does not let devices subscribe to their telemetry
+		m.EchoProbe = false
+	}
+}
+
+// validateCloud checks the connection against what the managed IoT service
+// supports
+func (c *Config) validateCloud() error {
+	m := c.MQTT
+	provider := m.Cloud.Provider
+	if provider == "" {
+		return nil
+	}
+	broker, err := url.Parse(m.Broker)
+	switch {
+	case provider != CloudAWSIoT && provider != CloudAzureIoTHub:
+		return fmt.Errorf("mqtt.cloud.provider must be %s or %s", CloudAWSIoT, CloudAzureIoTHub)
+	case c.Gateway.Enabled:
+		return fmt.Errorf("mqtt.cloud does not apply to the gRPC transport")
+	case err != nil || !slices.Contains([]string{"ssl", "tls", "mqtts", "mqtt+ssl", "tcps"}, broker.Scheme):
+		return fmt.Errorf("%s needs a TLS broker URL such as ssl://host:8883", provider)
+	case m.Token.Enabled || m.Control.Token.Enabled:
+		return fmt.Errorf("mqtt.token does not apply to %s", provider)
+	}
+
+	// Neither service supports QoS 2
+	qos := []byte{m.QoS}
+	for _, s := range m.Streams {
+		if s.QoS != nil {
+			qos = append(qos, *s.QoS)
+		}
+	}
+	for _, r := range c.Routing.Rules {
+		if r.QoS != nil {
+			qos = append(qos, *r.QoS)
+		}
+	}
+	if m.Status.Enabled {
+		qos = append(qos, m.Status.QoS)
+	}
+	if slices.ContainsFunc(qos, func(q byte) bool { return q > 1 }) {
+		return fmt.Errorf("%s accepts QoS 0 and 1 only", provider)
+	}
+
+	if provider == CloudAWSIoT {
+		switch {
+		case m.TLS.CertFile == "":
+			return fmt.Errorf("aws_iot needs an X.509 client certificate in mqtt.tls.cert_file")
+		case m.Username != "" || m.Password != "":
+			return fmt.Errorf("aws_iot authenticates with the client certificate, mqtt.username and mqtt.password must not be set")
+		case m.Control.Enabled && m.Control.CertFile == "":
+			return fmt.Errorf("aws_iot needs a client certificate in mqtt.control.cert_file")
+		}
+		// Topics after {prefix} expansion; names and tags do not add levels
+		template := strings.ReplaceAll(m.Topics.Template, "{prefix}", m.Topics.Prefix)
+		if strings.Count(template, "/") > 7 {
+			return fmt.Errorf("mqtt.topics.template: aws_iot allows at most 8 topic levels")
+		}
+		return nil
+	}
+
+	a := m.Cloud.Azure
+	sas := a.SharedAccessKey != "" || a.SharedAccessKeyFile != ""
+	events := azure.EventsTopic("{device_id}")
+	switch {
+	case m.Protocol != "3.1.1":
+		return fmt.Errorf("azure_iot_hub needs mqtt.protocol 3.1.1")
+	case a.SharedAccessKey != "" && a.SharedAccessKeyFile != "":
+		return fmt.Errorf("mqtt.cloud.azure needs one of shared_access_key and shared_access_key_file")
+	case sas == (m.TLS.CertFile != ""):
+		return fmt.Errorf("azure_iot_hub needs either a shared access key or an X.509 client certificate in mqtt.tls.cert_file")
+	case m.Password != "":
+		return fmt.Errorf("azure_iot_hub authenticates with SAS tokens or the client certificate, mqtt.password must not be set")
+	case sas && (a.RefreshBefore <= 0 || a.TokenLifetime <= a.RefreshBefore):
+		return fmt.Errorf("mqtt.cloud.azure.refresh_before must be positive and token_lifetime longer")
+	case a.APIVersion == "":
+		return fmt.Errorf("mqtt.cloud.azure.api_version is required")
+	case m.ClientID != c.Device.ID:
+		return fmt.Errorf("azure_iot_hub requires mqtt.client_id to be the device ID")
+	case m.Control.Enabled:
+		return fmt.Errorf("azure_iot_hub allows one connection per device, mqtt.control must be disabled")
+	case c.Device.Org != "":
+		return fmt.Errorf("device.org does not apply to azure_iot_hub, which isolates devices itself")
+	case len(c.Virtual) > 0:
+		return fmt.Errorf("azure_iot_hub only accepts messages of the connected device, virtual_devices need identities of their own")
+	case !strings.HasPrefix(m.Topics.Template, events):
+		return fmt.Errorf("mqtt.topics.template must start with %s for azure_iot_hub", events)
+	}
+	if a.SharedAccessKey != "" {
+		if _, err := base64.StdEncoding.DecodeString(a.SharedAccessKey); err != nil {
+			return fmt.Errorf("mqtt.cloud.azure.shared_access_key is not base64")
+		}
+	}
+	for _, r := range c.Routing.Rules {
+		if r.Topic != "" && !strings.HasPrefix(r.Topic, events) {
+			return fmt.Errorf("routing rule %s: topic must start with %s for azure_iot_hub", r.Name, events)
+		}
+	}
+
+	// Devices may only subscribe to the topics of IoT Hub itself
+	for _, f := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"bootstrap", c.Bootstrap.Enabled},
+		{"decoders", c.Decoders.Enabled},
+		{"workloads", c.Workloads.Enabled},
+		{"output_push", c.OutputPush.Enabled},
+		{"uploads", c.Uploads.Enabled},
+		{"drift", c.Drift.Enabled},
+		{"compliance", c.Compliance.Enabled},
+		{"update", c.Update.Enabled},
+		{"gpio", c.GPIO.Enabled},
+		{"acks", c.Acks.Enabled},
+		{"cloud_throttle", c.CloudThrottle.Enabled},
+		{"bridge", len(c.Bridge.Inputs) > 0},
+	} {
+		if f.enabled {
+			return fmt.Errorf("%s subscribes to topics azure_iot_hub does not offer and must be disabled", f.name)
+		}
 	}
 	return nil
 }
 
-// generateDeviceID creates a unique device identifier
-func generateDeviceID() string {
-	hostname, err := os.Hostname()
-	if err != nil {
-		return fmt.Sprintf("device-%d", time.Now().Unix())
+// Delivery returns the QoS and retained flag used to publish a data type
+func (m MQTTConfig) Delivery(dataType string) (byte, bool) {
+	qos, retained := m.QoS, m.Retained
+	if s, ok := m.Streams[dataType]; ok {
+		if s.QoS != nil {
+			qos = *s.QoS
+		}
+		if s.Retained != nil {
+			retained = *s.Retained
+		}
 	}
-	return hostname
-}
\ No newline at end of file
+	return qos, retained
+}
+
+// Batching returns the batch settings of a data type
+func (m MQTTConfig) Batching(dataType string) BatchConfig {
+	return m.Streams[dataType].Batch
+}
+
+// ReconnectConfig is the exponential backoff with jitter used after the
+// broker connection drops
+type ReconnectConfig struct {
+	InitialInterval time.Duration `yaml:"initial_interval"`
+	MaxInterval     time.Duration `yaml:"max_interval"`
+	Multiplier      float64       `yaml:"multiplier"`
+	Jitter          float64       `yaml:"jitter"` // 0 disables, 1 randomises the whole delay
+}
+
+// WillConfig sets the Last Will and Testament: the broker publishes an
+// offline heartbeat when the collector disconnects unexpectedly
+type WillConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Delay   time.Duration `yaml:"delay"` // MQTT 5 will delay, tolerates brief drops
+}
+
+// StatusConfig publishes a retained "online" message on the status topic on
+// every connect. The will replaces it with "offline" when the collector
+// drops, so new subscribers see the device state without waiting for a
+// heartbeat
+type StatusConfig struct {
+	Enabled bool `yaml:"enabled"`
+	QoS     byte `yaml:"qos"`
+}
+
+// SessionConfig keeps the MQTT session across disconnects so the broker
+// queues QoS 1/2 messages for the collector's subscriptions while it is offline
+type SessionConfig struct {
+	Persistent bool          `yaml:"persistent"`
+	Expiry     time.Duration `yaml:"expiry"` // MQTT 5 session expiry; 3.1.1 brokers apply their own
+	// Store keeps messages in flight in "memory" or in "file"s in the state
+	// directory, so the session also survives restarts
+	Store string `yaml:"store"`
+}
+
+// MQTT5Config configures MQTT 5 features, used when protocol is "5"
+type MQTT5Config struct {
+	TopicAliases   uint16            `yaml:"topic_aliases"`   // Aliases per connection, capped by the broker; 0 disables
+	UserProperties map[string]string `yaml:"user_properties"` // Added to every publish after the device metadata
+}
+
+// MQTTTLSConfig configures TLS for tls://, ssl://, mqtts:// and wss:// brokers
+type MQTTTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`              // PEM bundle; system roots when empty
+	CertFile           string `yaml:"cert_file"`            // Client certificate for mutual TLS
+	KeyFile            string `yaml:"key_file"`             // Client private key for mutual TLS
+	ServerName         string `yaml:"server_name"`          // Overrides the name verified in the broker certificate
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // Testing only
+	// ALPN protocols offered in the handshake, e.g. x-amzn-mqtt-ca for AWS
+	// IoT Core on port 443
+	ALPN []string `yaml:"alpn"`
+}
+
+// TopicsConfig defines MQTT topic structure
+type TopicsConfig struct {
+	// Template builds the topic of every data type. Placeholders are
+	// {prefix}, {device_id}, {device_name}, {location}, {type}, {topic}
+	// (the name set below for the type) and any device tag
+	Template    string `yaml:"template"`
+	Prefix      string `yaml:"prefix"`
+	Metrics     string `yaml:"metrics"`
+	Logs        string `yaml:"logs"`
+	Events      string `yaml:"events"`
+	Heartbeat   string `yaml:"heartbeat"`
+	Diagnostics string `yaml:"diagnostics"`
+	Echo        string `yaml:"echo"`
+	Sensors     string `yaml:"sensors"`
+	Polls       string `yaml:"polls"`
+	Inventory   string `yaml:"inventory"`
+	Compliance  string `yaml:"compliance"`
+	Flows       string `yaml:"flows"`
+	Speedtest   string `yaml:"speedtest"`
+	Usage       string `yaml:"usage"`
+	Display     string `yaml:"display"`
+	Status      string `yaml:"status"`
+}
+
+// HeartbeatConfig defines how often the device reports its status
+type HeartbeatConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+// DiagnosticsConfig defines publishing of collector-side errors to the cloud.
+// Identical errors are deduplicated and at most MaxEntries are sent per Interval.
+type DiagnosticsConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Interval   time.Duration `yaml:"interval"`
+	MaxEntries int           `yaml:"max_entries"`
+}
+
+// ValidationConfig defines schema checks applied to telemetry before publish.
+// Invalid records are quarantined under the state directory.
+type ValidationConfig struct {
+	Enabled            bool  `yaml:"enabled"`
+	MaxTags            int   `yaml:"max_tags"`
+	MaxTagLength       int   `yaml:"max_tag_length"`
+	MaxQuarantineBytes int64 `yaml:"max_quarantine_bytes"` // Per daily file
+}
+
+// CardinalityConfig caps unique label values per metric. Paths are dotted
+// locations of per-label maps in the metrics payload (e.g. network.interfaces).
+type CardinalityConfig struct {
+	MaxSeries int      `yaml:"max_series"` // 0 disables the guard
+	Policy    string   `yaml:"policy"`     // "drop" or "aggregate"
+	Paths     []string `yaml:"paths"`
+}
+
+// UnitsConfig attaches units to metrics and normalizes values before publish
+type UnitsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Declare adds or overrides the unit of a metric path pattern
+	Declare   map[string]string `yaml:"declare"`
+	Normalize []UnitRule        `yaml:"normalize"`
+}
+
+// UnitRule converts values at a path pattern ("*" matches one segment)
+type UnitRule struct {
+	Path string `yaml:"path"`
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// BufferConfig keeps records while the broker is unreachable and sends them
+// in order once it is reachable again. The disk buffer survives restarts;
+// the memory buffer suits devices without writable storage; the SQLite
+// buffer also keeps sent records as history to inspect
+type BufferConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Type         string        `yaml:"type"`          // "disk" (in state.buffer_dir), "memory" or "sqlite"
+	SegmentBytes int64         `yaml:"segment_bytes"` // Size of each segment file
+	MaxBytes     int64         `yaml:"max_bytes"`     // Disk and SQLite: oldest records are dropped above this
+	MaxAge       time.Duration `yaml:"max_age"`       // Older records are dropped; 0 keeps them
+
+	// Memory bounds the memory buffer
+	Memory MemoryBufferConfig `yaml:"memory"`
+	SQLite SQLiteBufferConfig `yaml:"sqlite"`
+}
+
+// SQLiteBufferConfig locates the database of the SQLite buffer
+type SQLiteBufferConfig struct {
+	Path   string `yaml:"path"`   // Default {state.buffer_dir}/telemetry.db
+	Vacuum bool   `yaml:"vacuum"` // Compact the database on startup
+}
+
+// DisplayConfig reports the displays of kiosk and signage devices. Screenshots
+// show what is on the screen, so they are off unless enabled in the local
+// file, and are only taken when the Control Plane requests an upload
+type DisplayConfig struct {
+	Enabled    bool             `yaml:"enabled"`
+	Interval   time.Duration    `yaml:"interval"`   // Between display records
+	DRMDir     string           `yaml:"drm_dir"`    // Default /sys/class/drm
+	Connectors []string         `yaml:"connectors"` // Reported connectors, e.g. HDMI-A-1; default all
+	Screenshot ScreenshotConfig `yaml:"screenshot"`
+}
+
+// KioskConfig probes a local kiosk application. Checks without settings are
+// skipped; at least one is required
+type KioskConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"` // Between probes
+	Timeout  time.Duration `yaml:"timeout"`  // Per check
+	URL      string        `yaml:"url"`      // HTTP endpoint of the application
+	Status   int           `yaml:"status"`   // Expected status; 0 accepts any below 400
+	Contains string        `yaml:"contains"` // Text the response must contain
+	Process  string        `yaml:"process"`  // Process name, e.g. chromium
+	CDP      KioskCDP      `yaml:"cdp"`
+	// Failures is how many probes in a row must fail before the kiosk is
+	// reported unhealthy
+	Failures int `yaml:"failures"`
+	// RestartCommand runs when the kiosk turns unhealthy, and again every
+	// RestartInterval while it stays so
+	RestartCommand  []string      `yaml:"restart_command"`
+	RestartInterval time.Duration `yaml:"restart_interval"`
+}
+
+// KioskCDP checks the page of a browser over the Chrome DevTools Protocol
+type KioskCDP struct {
+	URL        string `yaml:"url"`        // DevTools endpoint, e.g. http://127.0.0.1:9222
+	Page       string `yaml:"page"`       // Text the page URL must contain; default the first page
+	Expression string `yaml:"expression"` // JavaScript that is true once the page is ready
+}
+
+// IngestionConfig adds routing metadata to every record, so the Ingestion
+// Service can pick the table and TTL of a record without reading its data.
+// Values may use the placeholders of topic templates, such as {site}
+type IngestionConfig struct {
+	IngestionRouting `yaml:",inline"`
+	// Types overrides the metadata per data type; fields left empty keep
+	// the defaults above
+	Types map[string]IngestionRouting `yaml:"types"`
+}
+
+// IngestionRouting is the metadata carried in the record envelope
+type IngestionRouting struct {
+	Dataset        string `yaml:"dataset"`
+	Pipeline       string `yaml:"pipeline"`
+	RetentionClass string `yaml:"retention_class"`
+}
+
+// LabelsConfig labels records with their privacy and retention classes,
+// so storage downstream can enforce its policies from the point of
+// collection
+type LabelsConfig struct {
+	Types map[string]RecordLabels `yaml:"types"` // Per data type
+	// Rules label matching records, such as those of one input by its
+	// tags; the first match overrides the labels of the data type
+	Rules []LabelRule `yaml:"rules"`
+}
+
+// RecordLabels are the labels of a record; empty ones are left out
+type RecordLabels struct {
+	PII       *bool  `yaml:"pii"`       // Holds personal data
+	Privacy   string `yaml:"privacy"`   // Class, e.g. "internal" or "confidential"
+	Retention string `yaml:"retention"` // Keep for, e.g. 30d; units h, d, w and y
+}
+
+// LabelRule labels the records matching all its conditions
+type LabelRule struct {
+	Name         string     `yaml:"name"`
+	Match        RouteMatch `yaml:"match"`
+	RecordLabels `yaml:",inline"`
+}
+
+// BackpressureConfig stretches the collection interval of metrics, logs and
+// virtual devices while the outbound queue, the records in the offline
+// buffer and the publishes in flight, holds more than High records. Each
+// check over High doubles the interval up to MaxFactor times
+// collection.interval; each check below Low halves it again
+type BackpressureConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	High          int64         `yaml:"high"`
+	Low           int64         `yaml:"low"`
+	MaxFactor     int           `yaml:"max_factor"`
+	CheckInterval time.Duration `yaml:"check_interval"`
+}
+
+// CloudThrottleConfig accepts requests of the ingestion service to slow down
+// during incidents. A request on Topic stretches the collection interval by
+// a factor, at most MaxFactor, for a duration, at most MaxDuration. Records
+// meanwhile go to the offline buffer, which sends its backlog at ReplayRate
+// records per second divided by the factor
+type CloudThrottleConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Topic       string        `yaml:"topic"` // Supports {prefix}, {org} and {device_id}
+	MaxFactor   int           `yaml:"max_factor"`
+	MaxDuration time.Duration `yaml:"max_duration"`
+	ReplayRate  float64       `yaml:"replay_rate"`
+}
+
+// BreakerConfig opens the circuit of an output after Failures failed
+// publishes in a row: records for it are dropped without an attempt until
+// Cooldown has passed, when one record probes the output. A failed probe
+// opens the circuit again for twice as long, up to MaxCooldown; a delivered
+// one closes it
+type BreakerConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Failures    int           `yaml:"failures"`
+	Cooldown    time.Duration `yaml:"cooldown"`
+	MaxCooldown time.Duration `yaml:"max_cooldown"`
+}
+
+// ACLCheckConfig verifies after the first connection, and on request over
+// the local API, that the broker lets the device subscribe to its control
+// topics and publish to its telemetry topics. Publish rights are only
+// tested with PublishTest, which sends a test message of type acl_test to
+// each topic the ingestion service has to ignore
+type ACLCheckConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	PublishTest bool          `yaml:"publish_test"`
+	Timeout     time.Duration `yaml:"timeout"` // Per topic
+}
+
+// ShutdownConfig bounds a graceful shutdown. Within Drain the collector
+// sends the records it still holds before it disconnects
+type ShutdownConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+	Drain   time.Duration `yaml:"drain"` // 0 only flushes batches
+}
+
+// PeripheralsConfig reports the health of printers, polled over IPP, and
+// of USB peripherals such as scanners and card readers
+type PeripheralsConfig struct {
+	Enabled  bool            `yaml:"enabled"`
+	Interval time.Duration   `yaml:"interval"` // Between probes
+	Timeout  time.Duration   `yaml:"timeout"`  // Per printer
+	Printers []PrinterConfig `yaml:"printers"`
+	USB      USBConfig       `yaml:"usb"`
+}
+
+// PrinterConfig is a CUPS queue or network printer
+type PrinterConfig struct {
+	Name string `yaml:"name"`
+	URI  string `yaml:"uri"` // e.g. ipp://localhost:631/printers/receipt
+}
+
+// USBConfig watches the USB devices attached to the host. With devices
+// listed only those are reported, and missing ones are reported absent
+type USBConfig struct {
+	Enabled  bool              `yaml:"enabled"`
+	SysfsDir string            `yaml:"sysfs_dir"` // Default /sys/bus/usb/devices
+	Devices  []USBDeviceConfig `yaml:"devices"`
+}
+
+// USBDeviceConfig is an expected USB peripheral
+type USBDeviceConfig struct {
+	Name   string `yaml:"name"`
+	ID     string `yaml:"id"`     // vendor:product in hex, e.g. 04b8:0e28
+	Serial string `yaml:"serial"` // Tells devices of the same model apart
+}
+
+// ScreenshotConfig captures the screen for upload requests of the
+// screenshot artifact
+type ScreenshotConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Command     []string      `yaml:"command"`      // Writes a PNG or JPEG image to stdout
+	MaxWidth    int           `yaml:"max_width"`    // Wider captures are scaled down
+	Quality     int           `yaml:"quality"`      // JPEG quality, 1-100
+	Timeout     time.Duration `yaml:"timeout"`      // Of the command
+	MinInterval time.Duration `yaml:"min_interval"` // Between screenshots
+}
+
+// MemoryBufferConfig bounds the memory buffer and selects what happens to a
+// record when it is full
+type MemoryBufferConfig struct {
+	MaxRecords int    `yaml:"max_records"`
+	MaxBytes   int64  `yaml:"max_bytes"`
+	Overflow   string `yaml:"overflow"` // "drop_oldest" (default), "drop_newest" or "block"
+	// BlockTimeout is how long a full buffer holds up collection before
+	// the record is dropped
+	BlockTimeout time.Duration `yaml:"block_timeout"`
+}
+
+// ReplayConfig controls how an offline backlog is sent once the uplink returns
+type ReplayConfig struct {
+	Downsample DownsampleConfig `yaml:"downsample"`
+}
+
+// DownsampleConfig reduces metrics older than OlderThan during replay, either
+// keeping one in KeepEvery samples ("nth") or averaging per Window ("window")
+type DownsampleConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	OlderThan time.Duration `yaml:"older_than"`
+	Mode      string        `yaml:"mode"`
+	KeepEvery int           `yaml:"keep_every"`
+	Window    time.Duration `yaml:"window"`
+}
+
+// RoutingConfig holds content-based routing rules, evaluated in order
+type RoutingConfig struct {
+	Rules []RouteRule `yaml:"rules"`
+}
+
+// RouteRule sends records matching all conditions to a dedicated topic,
+// and with Outputs to the named outputs instead of those of their type.
+// Topic may use {prefix}, {device_id} and {type} placeholders.
+type RouteRule struct {
+	Name     string     `yaml:"name"`
+	Match    RouteMatch `yaml:"match"`
+	Topic    string     `yaml:"topic"`
+	QoS      *byte      `yaml:"qos"`
+	Retained *bool      `yaml:"retained"`
+	Outputs  []string   `yaml:"outputs"`
+}
+
+// RouteMatch defines the conditions of a routing rule
+type RouteMatch struct {
+	Type   string            `yaml:"type"`
+	Tags   map[string]string `yaml:"tags"`
+	Fields map[string]string `yaml:"fields"` // Dotted data path -> value
+	// Expression over the numeric data values by dotted path, such as
+	// "cpu.usage_percent > 90 && memory.used_percent > 80"
+	Expression string `yaml:"expression"`
+}
+
+// CompressionConfig compresses telemetry payloads before they are
+// encrypted, signed and published, for links billed by volume
+type CompressionConfig struct {
+	Algorithm string `yaml:"algorithm"` // "none" (default), "gzip" or "zstd"
+	MinBytes  int    `yaml:"min_bytes"` // Smaller payloads are sent uncompressed
+}
+
+// EncryptionConfig enables end-to-end payload encryption. Each payload is
+// encrypted with a data key wrapped by the device key identified by KeyID;
+// rotate device keys by deploying a new key file under a new KeyID.
+type EncryptionConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	KeyID           string        `yaml:"key_id"`
+	KeyFile         string        `yaml:"key_file"` // 32-byte key, hex or base64
+	DataKeyRotation time.Duration `yaml:"data_key_rotation"`
+}
+
+// SigningConfig enables Ed25519 signatures over published telemetry so the
+// ingestion service can reject data spoofed by other broker clients
+type SigningConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	KeyID          string `yaml:"key_id"`
+	PrivateKeyFile string `yaml:"private_key_file"` // PKCS#8 PEM or hex/base64 seed
+}
+
+// LocalAPIConfig defines the local HTTP API (health, status, actions).
+// Every endpoint requires a bearer token or a verified client certificate.
+type LocalAPIConfig struct {
+	Enabled bool              `yaml:"enabled"`
+	Listen  string            `yaml:"listen"`
+	Tokens  []LocalAPIToken   `yaml:"tokens"`
+	TLS     LocalAPITLSConfig `yaml:"tls"`
+}
+
+// LocalAPIToken grants a role to the bearer token with the given SHA-256 hash
+type LocalAPIToken struct {
+	Name   string `yaml:"name"`
+	SHA256 string `yaml:"sha256"`
+	Role   string `yaml:"role"` // "read" or "admin"
+}
+
+// LocalAPITLSConfig enables HTTPS and mTLS client authentication
+type LocalAPITLSConfig struct {
+	CertFile     string            `yaml:"cert_file"`
+	KeyFile      string            `yaml:"key_file"`
+	ClientCAFile string            `yaml:"client_ca_file"`
+	ClientRoles  map[string]string `yaml:"client_roles"` // Certificate CN -> role
+}
+
+// EgressConfig restricts the destinations the collector may connect to
+type EgressConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Allowlist []string `yaml:"allowlist"` // host[:port], *.domain[:port], ip or cidr[:port]
+}
+
+// DecodersConfig controls decoders pushed by the Control Plane
+type DecodersConfig struct {
+	// Enabled subscribes to Topic; the last topic level names the decoder and
+	// the payload is its layout definition or WASM module (empty payload
+	// removes it)
+	Enabled bool               `yaml:"enabled"`
+	Topic   string             `yaml:"topic"`
+	WASM    WASMDecodersConfig `yaml:"wasm"`
+}
+
+// WASMDecodersConfig limits what a WASM decoder may use per payload
+type WASMDecodersConfig struct {
+	MaxMemory int64         `yaml:"max_memory"` // Bytes of linear memory
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// OutputConfig is an additional output that receives published records
+// beside the broker. Outputs can be added, changed and removed at runtime
+type OutputConfig struct {
+	Name    string              `yaml:"name"`
+	Type    string              `yaml:"type"`  // "file", "kafka", "nats", "amqp" or "webhook"
+	Types   []string            `yaml:"types"` // Data types delivered, all when empty
+	File    FileOutputConfig    `yaml:"file"`
+	Kafka   KafkaOutputConfig   `yaml:"kafka"`
+	NATS    NATSOutputConfig    `yaml:"nats"`
+	AMQP    AMQPOutputConfig    `yaml:"amqp"`
+	Webhook WebhookOutputConfig `yaml:"webhook"`
+	Retry   OutputRetryConfig   `yaml:"retry"`
+}
+
+// OutputRetryConfig publishes a record again when the output fails it.
+// Network outputs buffer and retry on their own; this covers failures
+// reported right away, such as a file on a drive that is being replugged
+type OutputRetryConfig struct {
+	Attempts   int           `yaml:"attempts"`    // Retries per record, 0 disables
+	Backoff    time.Duration `yaml:"backoff"`     // First delay, default 1s, doubling
+	MaxBackoff time.Duration `yaml:"max_backoff"` // Default 30s
+}
+
+// FileOutputConfig appends records as NDJSON to a local file. The file is
+// rotated when it reaches MaxBytes or MaxAge; rotated files are renamed with
+// a UTC timestamp next to it
+type FileOutputConfig struct {
+	Path     string        `yaml:"path"`
+	MaxBytes int64         `yaml:"max_bytes"` // 0 disables size rotation
+	MaxAge   time.Duration `yaml:"max_age"`   // 0 disables time rotation
+	Compress bool          `yaml:"compress"`  // gzip rotated files
+	MaxFiles int           `yaml:"max_files"` // Rotated files kept, 0 keeps all
+	// MountPoint makes the output write only while this path is a mounted
+	// filesystem (USB drive, NAS share), so an unplugged drive does not fill
+	// the root filesystem. Path must lie below it
+	MountPoint string `yaml:"mount_point"`
+}
+
+// KafkaOutputConfig produces records to a Kafka cluster. Topic may use the
+// {device_id} and {type} placeholders
+type KafkaOutputConfig struct {
+	Brokers     []string        `yaml:"brokers"`      // Seed brokers as host:port
+	Topic       string          `yaml:"topic"`        // Default "signalbeam.{type}"
+	PartitionBy string          `yaml:"partition_by"` // Record key: "device_id" (default), "type" or "none"
+	ClientID    string          `yaml:"client_id"`    // Default "signalbeam-collector"
+	Acks        string          `yaml:"acks"`         // "all" (default), "leader" or "none"
+	Compression string          `yaml:"compression"`  // "snappy" (default), "gzip", "lz4", "zstd" or "none"
+	Timeout     time.Duration   `yaml:"timeout"`      // Delivery timeout per record, default 30s
+	MaxBuffered int             `yaml:"max_buffered"` // Records held while brokers are unreachable, default 10000
+	TLS         OutputTLSConfig `yaml:"tls"`
+	SASL        KafkaSASLConfig `yaml:"sasl"`
+}
+
+// OutputTLSConfig enables TLS for a network output
+type OutputTLSConfig struct {
+	Enabled       bool `yaml:"enabled"`
+	MQTTTLSConfig `yaml:",inline"`
+}
+
+// KafkaSASLConfig authenticates to the brokers
+type KafkaSASLConfig struct {
+	Mechanism string `yaml:"mechanism"` // "PLAIN", "SCRAM-SHA-256" or "SCRAM-SHA-512"; empty disables SASL
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+// NATSOutputConfig publishes records to NATS subjects, optionally persisted
+// in JetStream. Subject may use the {device_id} and {type} placeholders
+type NATSOutputConfig struct {
+	URLs       []string            `yaml:"urls"`       // e.g. nats://nats.site:4222
+	Subject    string              `yaml:"subject"`    // Default "signalbeam.{device_id}.{type}"
+	Name       string              `yaml:"name"`       // Connection name shown by the server, default "signalbeam-collector"
+	CredsFile  string              `yaml:"creds_file"` // Credentials file holding the user JWT and NKey seed
+	Token      string              `yaml:"token"`
+	Username   string              `yaml:"username"`
+	Password   string              `yaml:"password"`
+	TLS        OutputTLSConfig     `yaml:"tls"`
+	JetStream  NATSJetStreamConfig `yaml:"jetstream"`
+	Timeout    time.Duration       `yaml:"timeout"`     // Acknowledgement timeout per JetStream message, default 30s
+	MaxPending int                 `yaml:"max_pending"` // Messages awaiting a JetStream acknowledgement, default 10000
+}
+
+// NATSJetStreamConfig publishes with JetStream acknowledgements, so messages
+// are only counted once a stream has stored them
+type NATSJetStreamConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Stream  string `yaml:"stream"` // Expected stream; publishes landing elsewhere fail
+}
+
+// AMQPOutputConfig sends records over AMQP 1.0 to an Azure Event Hub or a
+// Service Bus queue or topic, authenticating with a shared access policy
+// connection string or a SAS token
+type AMQPOutputConfig struct {
+	ConnectionString string          `yaml:"connection_string"` // Endpoint=sb://...;SharedAccessKeyName=...;SharedAccessKey=...
+	SASToken         string          `yaml:"sas_token"`         // SharedAccessSignature sr=...; needs URL
+	URL              string          `yaml:"url"`               // amqps://host[:port] for SASToken
+	Address          string          `yaml:"address"`           // Event hub or queue, EntityPath of the connection string by default
+	PartitionBy      string          `yaml:"partition_by"`      // Partition key: "device_id" (default), "type" or "none"
+	Timeout          time.Duration   `yaml:"timeout"`           // Delivery timeout per message, default 30s
+	MaxPending       int             `yaml:"max_pending"`       // Messages queued while disconnected, default 10000
+	TLS              OutputTLSConfig `yaml:"tls"`               // CA and client certificate for amqps
+}
+
+// WebhookOutputConfig sends each record as an HTTP request. Body is a Go
+// template over the record; requests that fail are retried with doubling
+// backoff
+type WebhookOutputConfig struct {
+	URL         string            `yaml:"url"`
+	Method      string            `yaml:"method"` // "POST" (default) or "PUT"
+	Headers     map[string]string `yaml:"headers"`
+	Events      []string          `yaml:"events"`       // Event names delivered from events records, all when empty
+	Body        string            `yaml:"body"`         // Request body template, the record itself when empty
+	ContentType string            `yaml:"content_type"` // Default application/json
+	Timeout     time.Duration     `yaml:"timeout"`      // Per request, default 10s
+	Retries     int               `yaml:"retries"`      // Attempts after the first, default 3
+	Backoff     time.Duration     `yaml:"backoff"`      // Wait before the first retry, default 1s
+	MaxPending  int               `yaml:"max_pending"`  // Requests queued for delivery, default 1000
+	TLS         OutputTLSConfig   `yaml:"tls"`
+}
+
+// kafkaPlaceholders are removed before a topic template is checked
+var kafkaPlaceholders = strings.NewReplacer("{device_id}", "", "{type}", "")
+
+// validKafkaTopic reports whether a topic template only uses characters
+// Kafka allows in topic names
+func validKafkaTopic(topic string) bool {
+	for _, r := range kafkaPlaceholders.Replace(topic) {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// validNATSSubject reports whether a subject template expands to a subject
+// that can be published to. An empty template selects the default
+func validNATSSubject(subject string) bool {
+	if subject == "" {
+		return true
+	}
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks an output definition
+func (o OutputConfig) Validate() error {
+	switch {
+	case o.Name == "" || strings.ContainsAny(o.Name, "/+# "):
+		return fmt.Errorf("output name must be set and must not contain /, +, # or spaces")
+	case o.Name == "mqtt" || o.Name == "grpc" || o.Name == "https":
+		return fmt.Errorf("output name %q is reserved", o.Name)
+	}
+	for _, t := range o.Types {
+		if !streamTypes[t] {
+			return fmt.Errorf("output %s: unknown data type %q", o.Name, t)
+		}
+	}
+	if o.Retry.Attempts < 0 || o.Retry.Backoff < 0 || o.Retry.MaxBackoff < 0 {
+		return fmt.Errorf("output %s: retry.attempts, retry.backoff and retry.max_backoff must not be negative", o.Name)
+	}
+
+	switch o.Type {
+	case "file":
+		f := o.File
+		switch {
+		case f.Path == "":
+			return fmt.Errorf("output %s: file.path is required", o.Name)
+		case f.MaxBytes < 0 || f.MaxAge < 0 || f.MaxFiles < 0:
+			return fmt.Errorf("output %s: file.max_bytes, file.max_age and file.max_files must not be negative", o.Name)
+		case f.MountPoint != "" && !strings.HasPrefix(filepath.Clean(f.Path), filepath.Clean(f.MountPoint)+string(filepath.Separator)):
+			return fmt.Errorf("output %s: file.path must lie below file.mount_point", o.Name)
+		}
+	case "kafka":
+		k := o.Kafka
+		if len(k.Brokers) == 0 {
+			return fmt.Errorf("output %s: kafka.brokers is required", o.Name)
+		}
+		for _, b := range k.Brokers {
+			if _, _, err := net.SplitHostPort(b); err != nil {
+				return fmt.Errorf("output %s: kafka broker %q must be host:port", o.Name, b)
+			}
+		}
+		switch {
+		case !validKafkaTopic(k.Topic):
+			return fmt.Errorf("output %s: kafka.topic may only contain letters, digits, '.', '_', '-' and placeholders", o.Name)
+		case k.PartitionBy != "" && k.PartitionBy != "device_id" && k.PartitionBy != "type" && k.PartitionBy != "none":
+			return fmt.Errorf("output %s: kafka.partition_by must be device_id, type or none", o.Name)
+		case k.Acks != "" && k.Acks != "all" && k.Acks != "leader" && k.Acks != "none":
+			return fmt.Errorf("output %s: kafka.acks must be all, leader or none", o.Name)
+		case k.Compression != "" && !slices.Contains([]string{"snappy", "gzip", "lz4", "zstd", "none"}, k.Compression):
+			return fmt.Errorf("output %s: kafka.compression must be snappy, gzip, lz4, zstd or none", o.Name)
+		case k.Timeout < 0 || k.MaxBuffered < 0:
+			return fmt.Errorf("output %s: kafka.timeout and kafka.max_buffered must not be negative", o.Name)
+		}
+		switch k.SASL.Mechanism {
+		case "":
+		case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+			if k.SASL.Username == "" {
+				return fmt.Errorf("output %s: kafka.sasl.username is required", o.Name)
+			}
+		default:
+			return fmt.Errorf("output %s: kafka.sasl.mechanism must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512", o.Name)
+		}
+	case "nats":
+		n := o.NATS
+		if len(n.URLs) == 0 {
+			return fmt.Errorf("output %s: nats.urls is required", o.Name)
+		}
+		for _, raw := range n.URLs {
+			u, err := url.Parse(raw)
+			if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+				return fmt.Errorf("output %s: nats url %q must be nats://host:port or tls://host:port", o.Name, raw)
+			}
+		}
+		auth := 0
+		for _, set := range []bool{n.CredsFile != "", n.Token != "", n.Username != ""} {
+			if set {
+				auth++
+			}
+		}
+		switch {
+		case !validNATSSubject(n.Subject):
+			return fmt.Errorf("output %s: nats.subject must be dot separated tokens without spaces or wildcards", o.Name)
+		case auth > 1:
+			return fmt.Errorf("output %s: only one of nats.creds_file, nats.token and nats.username may be set", o.Name)
+		case n.Timeout < 0 || n.MaxPending < 0:
+			return fmt.Errorf("output %s: nats.timeout and nats.max_pending must not be negative", o.Name)
+		}
+	case "amqp":
+		a := o.AMQP
+		address := a.Address
+		switch {
+		case (a.ConnectionString == "") == (a.SASToken == ""):
+			return fmt.Errorf("output %s: exactly one of amqp.connection_string and amqp.sas_token is required", o.Name)
+		case a.ConnectionString != "":
+			cs, err := azure.ParseConnectionString(a.ConnectionString)
+			if err != nil {
+				return fmt.Errorf("output %s: amqp.connection_string: %w", o.Name, err)
+			}
+			if address == "" {
+				address = cs.EntityPath
+			}
+		default:
+			if _, err := azure.ParseSASToken(a.SASToken); err != nil {
+				return fmt.Errorf("output %s: amqp.sas_token: %w", o.Name, err)
+			}
+			u, err := url.Parse(a.URL)
+			if err != nil || (u.Scheme != "amqps" && u.Scheme != "amqp") || u.Host == "" {
+				return fmt.Errorf("output %s: amqp.url must be amqps://host[:port] or amqp://host[:port]", o.Name)
+			}
+		}
+		switch {
+		case address == "":
+			return fmt.Errorf("output %s: amqp.address is required without an EntityPath", o.Name)
+		case a.PartitionBy != "" && a.PartitionBy != "device_id" && a.PartitionBy != "type" && a.PartitionBy != "none":
+			return fmt.Errorf("output %s: amqp.partition_by must be device_id, type or none", o.Name)
+		case a.Timeout < 0 || a.MaxPending < 0:
+			return fmt.Errorf("output %s: amqp.timeout and amqp.max_pending must not be negative", o.Name)
+		}
+	case "webhook":
+		w := o.Webhook
+		u, err := url.Parse(w.URL)
+		switch {
+		case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+			return fmt.Errorf("output %s: webhook.url must be an http or https URL", o.Name)
+		case w.Method != "" && w.Method != "POST" && w.Method != "PUT":
+			return fmt.Errorf("output %s: webhook.method must be POST or PUT", o.Name)
+		case len(w.Events) > 0 && len(o.Types) > 0 && !slices.Contains(o.Types, "events"):
+			return fmt.Errorf("output %s: webhook.events requires the events type", o.Name)
+		case w.Timeout < 0 || w.Retries < 0 || w.Backoff < 0 || w.MaxPending < 0:
+			return fmt.Errorf("output %s: webhook.timeout, webhook.retries, webhook.backoff and webhook.max_pending must not be negative", o.Name)
+		}
+	default:
+		return fmt.Errorf("output %s: type must be file, kafka, nats, amqp or webhook", o.Name)
+	}
+	return nil
+}
+
+// OutputPushConfig accepts output definitions pushed by the Control Plane
+type OutputPushConfig struct {
+	// Enabled subscribes to Topic; the last topic level names the output and
+	// the payload is its definition (empty payload removes it)
+	Enabled bool   `yaml:"enabled"`
+	Topic   string `yaml:"topic"`
+	// FileRoots are the directories pushed file outputs may write under
+	FileRoots []string `yaml:"file_roots"`
+}
+
+// BridgeConfig defines inputs that decode raw sensor payloads from MQTT topics
+type BridgeConfig struct {
+	Inputs []BridgeInput `yaml:"inputs"`
+	// Local republishes what on-device applications publish on a local
+	// broker
+	Local LocalBridgeConfig `yaml:"local"`
+}
+
+// LocalBridgeConfig accepts publishes on a broker on the device and sends
+// them upstream as telemetry of the device, so applications share the
+// collector's authenticated uplink instead of holding credentials of their own
+type LocalBridgeConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Broker   string `yaml:"broker"`    // e.g. tcp://127.0.0.1:1883
+	ClientID string `yaml:"client_id"` // Default {mqtt.client_id}-local
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// MaxPayload drops larger publishes, default 64 KiB
+	MaxPayload int                `yaml:"max_payload"`
+	Topics     []LocalBridgeTopic `yaml:"topics"`
+}
+
+// LocalBridgeTopic is a topic filter on the local broker and the data type
+// its publishes are sent as, which selects the upstream topic
+type LocalBridgeTopic struct {
+	Filter string `yaml:"filter"` // e.g. apps/+/telemetry
+	Type   string `yaml:"type"`   // Default "app"
+	QoS    byte   `yaml:"qos"`    // Of the local subscription
+	// Tags are added to the tags of the records
+	Tags map[string]string `yaml:"tags"`
+}
+
+// BridgeInput subscribes to a topic carrying raw payloads from a gateway
+type BridgeInput struct {
+	Name     string `yaml:"name"`
+	Topic    string `yaml:"topic"`
+	Decoder  string `yaml:"decoder"`  // Built-in (cayenne-lpp, ruuvi-v5) or pushed decoder name
+	Encoding string `yaml:"encoding"` // raw, hex or base64
+
+	// HealthTimeout restarts the input when no payload decoded or failed for
+	// this long (0 disables)
+	HealthTimeout time.Duration `yaml:"health_timeout"`
+
+	// Counters per decoded field, corrected for wraparound and device
+	// resets before calibration
+	Counters map[string]CounterConfig `yaml:"counters"`
+
+	// Calibration per decoded field (channel), applied before publish
+	Calibration map[string]CalibrationConfig `yaml:"calibration"`
+}
+
+// CounterConfig describes a cumulative counter register of an external device
+type CounterConfig struct {
+	Width    int     `yaml:"width"`     // 16, 32 or 64 bits (default 32)
+	MaxDelta float64 `yaml:"max_delta"` // Largest plausible increase; bigger wraps are resets
+}
+
+// Spec converts the configuration to a counter spec
+func (c CounterConfig) Spec() counter.Spec {
+	return counter.Spec{Width: c.Width, MaxDelta: c.MaxDelta}
+}
+
+// CalibrationConfig calibrates one input channel using a lookup table, a
+// polynomial, or gain and offset (value = raw*gain + offset)
+type CalibrationConfig struct {
+	Gain       float64      `yaml:"gain"`
+	Offset     float64      `yaml:"offset"`
+	Polynomial []float64    `yaml:"polynomial"` // c0 + c1*x + c2*x^2 + ...
+	Table      [][2]float64 `yaml:"table"`      // [raw, value] pairs, raw increasing
+	ID         string       `yaml:"id"`         // Certificate or procedure reference
+	Date       string       `yaml:"date"`       // When the channel was calibrated
+}
+
+// QualityConfig controls data quality flags attached to telemetry
+type QualityConfig struct {
+	Enabled bool           `yaml:"enabled"`
+	Bounds  []QualityBound `yaml:"bounds"`
+	// Alerts publishes a quality_alert event whenever the flags on a value
+	// path are raised, change or clear
+	Alerts bool `yaml:"alerts"`
+}
+
+// QualityBound declares the valid range of values at a path pattern
+type QualityBound struct {
+	Type       string        `yaml:"type"` // Telemetry type, defaults to metrics
+	Path       string        `yaml:"path"` // Dotted path, "*" matches one segment
+	Min        *float64      `yaml:"min"`
+	Max        *float64      `yaml:"max"`
+	StaleAfter time.Duration `yaml:"stale_after"` // Flag values unchanged for longer
+}
+
+// Channel converts the configuration to a calibration channel
+func (c CalibrationConfig) Channel() calibration.Channel {
+	ch := calibration.Channel{
+		Gain:       c.Gain,
+		Offset:     c.Offset,
+		Polynomial: c.Polynomial,
+		ID:         c.ID,
+		Date:       c.Date,
+	}
+	for _, p := range c.Table {
+		ch.Table = append(ch.Table, calibration.Point{Raw: p[0], Value: p[1]})
+	}
+	return ch
+}
+
+// SupervisionConfig sets the restart policy of supervised inputs (metrics
+// collection and bridge inputs)
+type SupervisionConfig struct {
+	InitialBackoff   time.Duration `yaml:"initial_backoff"`
+	MaxBackoff       time.Duration `yaml:"max_backoff"`
+	MaxRestarts      int           `yaml:"max_restarts"` // Within window, then quarantine
+	Window           time.Duration `yaml:"window"`
+	Quarantine       time.Duration `yaml:"quarantine"`        // 0 keeps the input stopped until the agent restarts
+	FailureThreshold int           `yaml:"failure_threshold"` // Consecutive failures before a restart, 0 disables
+}
+
+// BootstrapConfig fetches the configuration from a retained message on a
+// per-device topic at startup. The fetched document is layered over the local
+// file, which only needs the broker address and credentials
+type BootstrapConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Topic   string        `yaml:"topic"`   // Supports {prefix} and {device_id}
+	Timeout time.Duration `yaml:"timeout"` // How long to wait for the retained message
+}
+
+// WorkloadsConfig joins a collector group that splits polling jobs assigned
+// by the cloud. Jobs are received through an MQTT shared subscription, so the
+// broker hands each job to one member and rebalances as members come and go
+type WorkloadsConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Group       string        `yaml:"group"`
+	Topic       string        `yaml:"topic"`       // Supports {prefix}, {org} and {group}
+	Concurrency int           `yaml:"concurrency"` // Jobs run at once; further jobs are reported busy
+	Timeout     time.Duration `yaml:"timeout"`     // Per-job timeout unless the job sets one
+}
+
+// AccountingConfig tracks CPU time, allocations and records per input,
+// processor and output and reports them in the heartbeat
+type AccountingConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// FallbackConfig posts telemetry to the ingestion service over HTTPS while
+// the MQTT connection is down, for networks that block 1883 and 8883
+type FallbackConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	URL           string        `yaml:"url"`
+	Token         string        `yaml:"token"` // Bearer token
+	TLS           MQTTTLSConfig `yaml:"tls"`
+	Compress      bool          `yaml:"compress"`       // gzip request bodies
+	BatchSize     int           `yaml:"batch_size"`     // Records per request
+	FlushInterval time.Duration `yaml:"flush_interval"` // Longest a record waits for its batch
+	Timeout       time.Duration `yaml:"timeout"`
+	MaxBuffer     int           `yaml:"max_buffer"` // Records kept while the service is unreachable; oldest dropped first
+	Proxy         ProxyConfig   `yaml:"proxy"`      // Overrides the global proxy
+}
+
+// GatewayConfig sends telemetry to the Edge Gateway's Telemetry service over
+// gRPC instead of the MQTT broker. Control topics are received through the
+// gateway's Subscribe call
+type GatewayConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Address   string        `yaml:"address"`   // host:port
+	Token     string        `yaml:"token"`     // Bearer token
+	Plaintext bool          `yaml:"plaintext"` // Disable TLS, e.g. for a gateway on the same host
+	TLS       MQTTTLSConfig `yaml:"tls"`
+	Proxy     ProxyConfig   `yaml:"proxy"` // Overrides the global proxy
+}
+
+// ProxyConfig sends connections through a SOCKS5 or HTTP CONNECT proxy
+type ProxyConfig struct {
+	// URL is socks5://host:port or http://host:port. On a transport,
+	// "direct" bypasses the global proxy and empty inherits it
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"` // Overrides credentials in the URL
+	Password string `yaml:"password"`
+}
+
+// ProxyDirect is the proxy URL of a transport that bypasses the global proxy
+const ProxyDirect = "direct"
+
+// TransportProxy returns the proxy of a transport: its own, or the global
+// one when it sets none. The URL is empty when no proxy is configured and
+// ProxyDirect when the transport bypasses it
+func (c *Config) TransportProxy(p ProxyConfig) ProxyConfig {
+	if p.URL == "" {
+		return c.Proxy
+	}
+	return p
+}
+
+// ExportConfig periodically writes records as Parquet files for offline
+// analysis, partitioned by data type and day, optionally uploading them to
+// S3-compatible storage
+type ExportConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Dir         string        `yaml:"dir"`         // Default {state.dir}/export
+	Types       []string      `yaml:"types"`       // Data types to export
+	Interval    time.Duration `yaml:"interval"`    // How often buffered rows are written
+	MaxRows     int           `yaml:"max_rows"`    // Rows per file; a full partition is written early
+	Compression string        `yaml:"compression"` // "gzip" or "none"
+	S3          S3Config      `yaml:"s3"`
+}
+
+// S3Config uploads exported files to an S3-compatible bucket
+type S3Config struct {
+	Enabled   bool          `yaml:"enabled"`
+	Endpoint  string        `yaml:"endpoint"` // e.g. https://s3.eu-central-1.amazonaws.com or http://minio:9000
+	Region    string        `yaml:"region"`
+	Bucket    string        `yaml:"bucket"`
+	Prefix    string        `yaml:"prefix"` // Key prefix; the device ID is appended
+	AccessKey string        `yaml:"access_key"`
+	SecretKey string        `yaml:"secret_key"`
+	PathStyle bool          `yaml:"path_style"` // Bucket in the path, as MinIO expects
+	TLS       MQTTTLSConfig `yaml:"tls"`
+	Timeout   time.Duration `yaml:"timeout"`
+	KeepLocal bool          `yaml:"keep_local"` // Keep files after upload
+}
+
+// UploadsConfig moves large artifacts (diagnostics bundles, capture files,
+// Parquet batches) to S3-compatible storage when the Control Plane requests
+// it, keeping them off MQTT. Requests carry a presigned URL or an object key
+// for the bucket configured in S3
+type UploadsConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Topic    string        `yaml:"topic"`     // Supports {prefix} and {device_id}
+	Roots    []string      `yaml:"roots"`     // Directories files may be uploaded from; default {state.dir}
+	MaxBytes int64         `yaml:"max_bytes"` // Largest artifact uploaded
+	Timeout  time.Duration `yaml:"timeout"`   // Per upload
+	S3       S3Config      `yaml:"s3"`        // timeout and keep_local do not apply
+}
+
+// InventoryConfig reports what is installed on the host, for vulnerability
+// and drift analysis across the fleet
+type InventoryConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"` // Default 24h
+	// Sections collected: os, packages, firmware, network, disks, usb and
+	// pci. Default all
+	Sections []string `yaml:"sections"`
+}
+
+// DriftConfig checks the host against a baseline the cloud pushes: expected
+// file hashes, sysctl values and service states
+type DriftConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Topic    string        `yaml:"topic"`    // Retained baseline; supports {prefix} and {device_id}
+	Interval time.Duration `yaml:"interval"` // Between checks
+}
+
+// ComplianceConfig runs a profile of declarative checks the cloud pushes and
+// reports the results
+type ComplianceConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Topic    string        `yaml:"topic"`    // Retained profile; supports {prefix} and {device_id}
+	Interval time.Duration `yaml:"interval"` // Between runs
+	// AllowCommands runs command checks, which execute what the profile
+	// names. Off by default
+	AllowCommands  bool          `yaml:"allow_commands"`
+	CommandTimeout time.Duration `yaml:"command_timeout"`
+}
+
+// FlowsConfig summarizes the traffic on network interfaces by protocol,
+// peer and port, from packet headers only
+type FlowsConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Interfaces []string      `yaml:"interfaces"`
+	Interval   time.Duration `yaml:"interval"`  // Between reports
+	MaxFlows   int           `yaml:"max_flows"` // Largest flows reported; the rest are summed
+}
+
+// SpeedtestConfig measures the latency and throughput of the uplink against
+// HTTP test servers on a schedule, within a monthly data allowance
+type SpeedtestConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	DownloadURL string        `yaml:"download_url"` // Read up to bytes by GET
+	UploadURL   string        `yaml:"upload_url"`   // Sent bytes by POST
+	TLS         MQTTTLSConfig `yaml:"tls"`
+	Bytes       int64         `yaml:"bytes"`    // Per direction
+	Interval    time.Duration `yaml:"interval"` // Between tests
+	Timeout     time.Duration `yaml:"timeout"`  // Per test
+	// MonthlyCap skips tests that could take the data used by tests this
+	// calendar month past it. 0 is unlimited
+	MonthlyCap int64 `yaml:"monthly_cap"`
+}
+
+// DataUsageConfig accounts the traffic of metered interfaces against a
+// quota per billing cycle
+type DataUsageConfig struct {
+	Enabled    bool           `yaml:"enabled"`
+	Interfaces []string       `yaml:"interfaces"`
+	Quota      int64          `yaml:"quota"`     // Bytes per cycle, both directions; 0 tracks without a quota
+	ResetDay   int            `yaml:"reset_day"` // Day of the month the cycle starts, 1-28
+	Interval   time.Duration  `yaml:"interval"`  // Between usage records
+	Throttle   ThrottleConfig `yaml:"throttle"`
+}
+
+// ThrottleConfig thins out telemetry that is not critical once a share of
+// the quota is used. Throttling is off without types
+type ThrottleConfig struct {
+	At        float64  `yaml:"at"`         // Share of the quota, e.g. 0.9
+	Types     []string `yaml:"types"`      // Data types thinned out
+	KeepEvery int      `yaml:"keep_every"` // Records of those types kept: one in this many, 0 none
+}
+
+// PortalConfig detects captive portals and walled gardens holding the
+// uplink, which otherwise only look like a lost connection
+type PortalConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"` // Between checks; a lost connection checks at once
+	Timeout  time.Duration `yaml:"timeout"`  // Per probe
+	Probes   []PortalProbe `yaml:"probes"`
+}
+
+// PortalProbe is a plain HTTP URL with a known answer
+type PortalProbe struct {
+	URL    string `yaml:"url"`
+	Status int    `yaml:"status"` // Expected status, 0 any
+	Body   string `yaml:"body"`   // Expected body without surrounding white space, empty any
+}
+
+// UplinkConfig tags telemetry with the uplink carrying it and reports
+// failovers between ethernet, Wi-Fi and cellular
+type UplinkConfig struct {
+	Enabled  bool              `yaml:"enabled"`
+	Interval time.Duration     `yaml:"interval"` // Between checks; a lost connection checks at once
+	Kinds    map[string]string `yaml:"kinds"`    // Kind of interfaces not recognized, e.g. usb0: cellular
+}
+
+// OffPeakConfig holds bandwidth-heavy data types until an off-peak window,
+// such as nights when the uplink is idle or cheaper, while the rest stays
+// real-time
+type OffPeakConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Windows    []string `yaml:"windows"`     // "[days ]HH:MM-HH:MM" in local time, e.g. "mon-fri 22:00-06:00"
+	Types      []string `yaml:"types"`       // Data types held outside the windows
+	MaxRecords int      `yaml:"max_records"` // Held at most; oldest dropped first
+}
+
+// BudgetConfig caps the publish rate and the bytes sent per day and month.
+// Low-priority streams are dropped first as a budget runs out
+type BudgetConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	MaxRate   float64  `yaml:"max_rate"`      // Publishes per second, 0 unlimited
+	Burst     int      `yaml:"burst"`         // Publishes allowed at once above max_rate
+	Daily     int64    `yaml:"daily_bytes"`   // 0 unlimited
+	Monthly   int64    `yaml:"monthly_bytes"` // 0 unlimited
+	ResetDay  int      `yaml:"reset_day"`     // Day of the month the monthly budget starts, 1-28
+	DegradeAt float64  `yaml:"degrade_at"`    // Share of a byte budget from which low-priority streams are dropped
+	HardCap   bool     `yaml:"hard_cap"`      // Stop critical streams too once a byte budget is used, for metered links
+	Low       []string `yaml:"low"`           // Data types dropped first
+	Critical  []string `yaml:"critical"`      // Data types kept while a byte budget is used, unless hard_cap
+}
+
+// UpdateConfig installs agent versions rolled out by the Control Plane. The
+// device follows one release channel and takes part in staged rollouts by
+// percentage and tag cohorts
+type UpdateConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Channel  string        `yaml:"channel"`   // "stable", "beta" or "canary"
+	Topic    string        `yaml:"topic"`     // Retained rollout directive; supports {prefix}, {org} and {channel}
+	MaxBytes int64         `yaml:"max_bytes"` // Largest binary downloaded
+	Timeout  time.Duration `yaml:"timeout"`   // Per download
+	// Image installs system images rolled out to the channel through an A/B
+	// update system
+	Image ImageUpdateConfig `yaml:"image"`
+}
+
+// ImageUpdateConfig selects the A/B update system. The new slot boots after
+// a reboot and is confirmed once the collector connects from it; otherwise
+// the bootloader falls back to the previous slot
+type ImageUpdateConfig struct {
+	System        string        `yaml:"system"`    // "auto", "rauc", "mender", "swupdate" or "none"
+	MaxBytes      int64         `yaml:"max_bytes"` // Largest image downloaded
+	Timeout       time.Duration `yaml:"timeout"`   // Per download
+	RebootCommand []string      `yaml:"reboot_command"`
+	// SwupdateSelect maps the booted root device to the software set
+	// passed to swupdate with -e, e.g. /dev/mmcblk0p2: stable,copy2
+	SwupdateSelect map[string]string `yaml:"swupdate_select"`
+}
+
+// AcksConfig keeps published records until the ingestion service
+// acknowledges them by message ID on Topic, and sends them again with the
+// same message ID when no acknowledgement arrives within Timeout. With
+// ExactlyOnce every message carries a batch ID derived from the sequence
+// numbers of its records, acknowledged as a whole, and the records awaiting
+// acks are kept in the state directory across restarts
+type AcksConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Topic      string        `yaml:"topic"`       // Supports {prefix}, {org} and {device_id}
+	Timeout    time.Duration `yaml:"timeout"`     // Before a record is sent again
+	TTL        time.Duration `yaml:"ttl"`         // After the first send, the record is given up
+	MaxPending int           `yaml:"max_pending"` // Records awaiting acks; the oldest stop being tracked above this
+	Types      []string      `yaml:"types"`       // Data types acknowledged; default all but heartbeats
+	// ExactlyOnce acks messages by batch ID, also when posted over HTTPS
+	ExactlyOnce bool `yaml:"exactly_once"`
+}
+
+// GPIOConfig switches GPIO lines and relays on commands from the Control
+// Plane and on events of the collector. Each output carries its own
+// interlocks; outputs and rules always come from the local file
+type GPIOConfig struct {
+	Enabled bool         `yaml:"enabled"`
+	Topic   string       `yaml:"topic"` // Commands; supports {prefix}, {org} and {device_id}
+	Outputs []GPIOOutput `yaml:"outputs"`
+	Rules   []GPIORule   `yaml:"rules"`
+}
+
+// GPIOOutput is one switchable output. The sysfs driver writes a GPIO line
+// or a value file; the command driver runs a program per state. The poe,
+// pdu and snmp drivers switch the power of attached devices over SNMP and
+// the usb driver a USB hub port
+type GPIOOutput struct {
+	Name      string   `yaml:"name"`
+	Driver    string   `yaml:"driver"` // "sysfs" (default), "command", "poe", "pdu", "snmp" or "usb"
+	Line      *int     `yaml:"line"`   // GPIO number, exported when needed
+	Path      string   `yaml:"path"`   // Value file instead of a line, e.g. /sys/class/leds/relay1/brightness
+	ActiveLow bool     `yaml:"active_low"`
+	On        []string `yaml:"on"` // Command driver
+	Off       []string `yaml:"off"`
+	SNMP      GPIOSNMP `yaml:"snmp"`    // Switch or PDU of the poe, pdu and snmp drivers
+	Port      int      `yaml:"port"`    // PoE switch port or USB hub port
+	Group     int      `yaml:"group"`   // PoE port group, default 1
+	PDU       string   `yaml:"pdu"`     // PDU model: apc or raritan
+	Outlet    int      `yaml:"outlet"`  // PDU outlet
+	Hub       string   `yaml:"hub"`     // USB hub device, e.g. 1-1, or usb1 for a root hub
+	Initial   string   `yaml:"initial"` // "on", "off" or empty to keep the state
+	Remote    bool     `yaml:"remote"`  // Accepts commands from the Control Plane
+	// Exclusive names outputs that must be off while this one is on
+	Exclusive   []string      `yaml:"exclusive"`
+	MinInterval time.Duration `yaml:"min_interval"` // Between changes asked for
+	MaxOn       time.Duration `yaml:"max_on"`       // Switched off after this long on
+	MaxHold     time.Duration `yaml:"max_hold"`     // Longest timed change, default 5m
+	CycleOff    time.Duration `yaml:"cycle_off"`    // Off time of a cycle, default 10s
+}
+
+// GPIOSNMP is the SNMP agent of a PoE switch or PDU. The snmp driver sets
+// OID to OnValue or OffValue; the poe and pdu drivers know their object
+type GPIOSNMP struct {
+	Target    string        `yaml:"target"`    // host or host:port
+	Community string        `yaml:"community"` // Write community, default private
+	Version   string        `yaml:"version"`   // "1" or "2c" (default)
+	OID       string        `yaml:"oid"`
+	OnValue   int           `yaml:"on_value"`
+	OffValue  int           `yaml:"off_value"`
+	Timeout   time.Duration `yaml:"timeout"` // Per request, default 5s
+}
+
+// GPIORule switches an output when the collector publishes an event whose
+// fields match. With a duration the output returns to its previous state
+// afterwards
+type GPIORule struct {
+	Name     string            `yaml:"name"`
+	Event    string            `yaml:"event"`
+	Match    map[string]string `yaml:"match"` // Event fields that must equal, e.g. state: alert
+	Output   string            `yaml:"output"`
+	Action   string            `yaml:"action"` // "on", "off" or "cycle"
+	Duration time.Duration     `yaml:"duration"`
+}
+
+// NotifyConfig sends critical events by mail or SMS through gateways on the
+// local network, so operators are reached while the uplink is down
+type NotifyConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Events      []string      `yaml:"events"`       // Event names that notify
+	Always      bool          `yaml:"always"`       // Also notify while the uplink is up
+	Cooldown    time.Duration `yaml:"cooldown"`     // Between notifications about the same subject
+	DedupWindow time.Duration `yaml:"dedup_window"` // Identical notifications within it are dropped
+	Timeout     time.Duration `yaml:"timeout"`      // Per delivery
+	SMTP        SMTPConfig    `yaml:"smtp"`
+	SMS         SMSConfig     `yaml:"sms"`
+}
+
+// SMTPConfig delivers notifications through a mail relay
+type SMTPConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Address  string        `yaml:"address"` // host:port
+	From     string        `yaml:"from"`
+	To       []string      `yaml:"to"`
+	Username string        `yaml:"username"` // PLAIN authentication, needs STARTTLS unless the relay is local
+	Password string        `yaml:"password"`
+	StartTLS bool          `yaml:"starttls"`
+	TLS      MQTTTLSConfig `yaml:"tls"` // CA for STARTTLS
+}
+
+// SMSConfig delivers notifications through a GSM modem on a serial port
+type SMSConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Device  string   `yaml:"device"` // e.g. /dev/ttyUSB2
+	Baud    int      `yaml:"baud"`
+	PIN     string   `yaml:"pin"` // SIM PIN, when the SIM asks for one
+	To      []string `yaml:"to"`  // Phone numbers in international format
+}
+
+// validate checks an enabled bucket; section prefixes the messages
+func (s S3Config) validate(section string) error {
+	if !s.Enabled {
+		return nil
+	}
+	u, err := url.Parse(s.Endpoint)
+	switch {
+	case err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "":
+		return fmt.Errorf("%s.endpoint must be an http or https URL", section)
+	case s.Bucket == "" || s.Region == "":
+		return fmt.Errorf("%s.bucket and region are required", section)
+	case s.AccessKey == "" || s.SecretKey == "":
+		return fmt.Errorf("%s.access_key and secret_key are required", section)
+	case s.Timeout <= 0:
+		return fmt.Errorf("%s.timeout must be positive", section)
+	}
+	return nil
+}
+
+// TracingConfig logs the path of sampled records through the pipeline: which
+// processors touched them, what filtered them and which outputs got them
+type TracingConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	SampleEvery int  `yaml:"sample_every"` // Trace one record in this many
+	Keep        int  `yaml:"keep"`         // Recent traces served by the local API
+}
+
+// ExceptionConfig enables report-by-exception: values are only published
+// when they move past a deadband or their max interval elapses
+type ExceptionConfig struct {
+	Enabled bool           `yaml:"enabled"`
+	Rules   []DeadbandRule `yaml:"rules"`
+}
+
+// DeadbandRule sets the change threshold for values at a path pattern
+type DeadbandRule struct {
+	Type        string        `yaml:"type"` // Telemetry type, defaults to metrics
+	Path        string        `yaml:"path"` // Dotted path, "*" matches one segment
+	Absolute    float64       `yaml:"absolute"`
+	Percent     float64       `yaml:"percent"`
+	MaxInterval time.Duration `yaml:"max_interval"` // Publish at least this often
+}
+
+// VirtualDevice is a device whose metrics are computed from other inputs.
+// It is published under its own device ID with its own heartbeat
+type VirtualDevice struct {
+	ID       string                  `yaml:"id"`
+	Name     string                  `yaml:"name"`
+	Location string                  `yaml:"location"`
+	Tags     map[string]string       `yaml:"tags"`
+	Inputs   map[string]VirtualInput `yaml:"inputs"`  // Variable name -> source value
+	Metrics  map[string]string       `yaml:"metrics"` // Metric name -> expression over inputs
+	// StaleAfter treats inputs older than this as missing (default 3x collection.interval)
+	StaleAfter time.Duration `yaml:"stale_after"`
+}
+
+// VirtualInput selects a value from the telemetry the collector publishes
+type VirtualInput struct {
+	Type   string `yaml:"type"`   // Telemetry type, defaults to metrics
+	Source string `yaml:"source"` // Bridge input name for sensors telemetry
+	Path   string `yaml:"path"`   // Dotted path within the data
+}
+
+// CollectionConfig defines what data to collect and how often
+type CollectionConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	Metrics  MetricsConfig `yaml:"metrics"`
+	Logs     LogsConfig    `yaml:"logs"`
+	Events   EventsConfig  `yaml:"events"`
+}
+
+// MetricsConfig defines system metrics collection
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	CPU     bool `yaml:"cpu"`
+	Memory  bool `yaml:"memory"`
+	Disk    bool `yaml:"disk"`
+	Network bool `yaml:"network"`
+	Load    bool `yaml:"load"`
+	EBPF    bool `yaml:"ebpf"` // Linux only, requires kernel BTF and CAP_BPF
+	Battery bool `yaml:"battery"`
+
+	// Fine-grained switches for the more expensive collectors
+	CPUInfo             bool `yaml:"cpu_info"`
+	DiskIO              bool `yaml:"disk_io"`
+	NetworkPerInterface bool `yaml:"network_per_interface"`
+
+	// DiskPaths lists mount points to report usage for (default "/")
+	DiskPaths []string `yaml:"disk_paths"`
+	// Processes lists process names to report presence and usage for
+	Processes []string `yaml:"processes"`
+}
+
+// LogsConfig defines log collection settings
+type LogsConfig struct {
+	Enabled  bool             `yaml:"enabled"`
+	Paths    []string         `yaml:"paths"`     // Glob patterns of files to follow
+	Exclude  []string         `yaml:"exclude"`   // Glob patterns of file names to skip
+	MaxLines int              `yaml:"max_lines"` // Lines read per file and interval
+	Archive  LogArchiveConfig `yaml:"archive"`
+}
+
+// LogArchiveConfig keeps collected log lines in local gzip segments with a
+// hash-chained manifest, for sites that must retain logs themselves
+type LogArchiveConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Dir          string        `yaml:"dir"`           // Default {state.dir}/log-archive
+	SegmentBytes int64         `yaml:"segment_bytes"` // Compressed size at which a segment is sealed
+	SegmentAge   time.Duration `yaml:"segment_age"`   // Age at which a segment is sealed
+	MaxBytes     int64         `yaml:"max_bytes"`     // Size of sealed segments kept, 0 unbounded
+	MaxAge       time.Duration `yaml:"max_age"`       // Age of sealed segments kept, 0 unbounded
+	MinAge       time.Duration `yaml:"min_age"`       // Segments kept regardless of max_bytes
+}
+
+// EventsConfig defines system event collection
+type EventsConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Types   []string `yaml:"types"`
+	// DedupWindow collapses identical events into one summary, 0 disables
+	DedupWindow time.Duration `yaml:"dedup_window"`
+}
+
+// LoggingConfig defines collector logging settings
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// StateConfig defines where the collector keeps writable data.
+// Individual paths default to locations under Dir when left empty.
+type StateConfig struct {
+	Dir         string `yaml:"dir"`
+	BufferDir   string `yaml:"buffer_dir"`
+	OffsetsFile string `yaml:"offsets_file"`
+	CrashDir    string `yaml:"crash_dir"`
+	StateFile   string `yaml:"state_file"`
+	// CheckpointInterval is how often counter baselines and last published
+	// values are written to StateFile; 0 writes them only on shutdown
+	CheckpointInterval time.Duration `yaml:"checkpoint_interval"`
+
+	QuarantineDir string `yaml:"quarantine_dir"`
+	AuditFile     string `yaml:"audit_file"`
+	DecoderDir    string `yaml:"decoder_dir"`
+	BootstrapFile string `yaml:"bootstrap_file"`
+}
+
+// PowerConfig defines energy saving behaviour for battery/solar devices.
+// In "duty_cycle" mode the collector wakes every WakeInterval, collects,
+// transmits over a short-lived MQTT connection and goes back to sleep.
+type PowerConfig struct {
+	Mode           string        `yaml:"mode"` // "always_on" or "duty_cycle"
+	WakeInterval   time.Duration `yaml:"wake_interval"`
+	SuspendCommand []string      `yaml:"suspend_command"` // {seconds} is replaced with the sleep duration
+}
+
+// RuntimeConfig tunes the Go runtime for constrained devices
+type RuntimeConfig struct {
+	GCPercent   int   `yaml:"gc_percent"`   // 0 keeps the Go default
+	MemoryLimit int64 `yaml:"memory_limit"` // Soft limit in bytes, 0 disables
+	MaxProcs    int   `yaml:"max_procs"`    // 0 keeps the Go default
+}
+
+// Load reads and parses the configuration file
+func Load(path string) (*Config, error) {
+	return load(path, nil)
+}
+
+// LoadWithOverlay loads the configuration file and layers a bootstrapped
+// YAML document over it
+func LoadWithOverlay(path string, overlay []byte) (*Config, error) {
+	return load(path, overlay)
+}
+
+// load reads the configuration file and an optional overlay over the defaults
+func load(path string, overlay []byte) (*Config, error) {
+	// Set defaults
+	cfg := &Config{
+		Device: DeviceConfig{
+			IDSource: "hostname",
+			Name:     "SignalBeam Edge Device",
+		},
+		MQTT: MQTTConfig{
+			Broker:         "tcp://localhost:1883",
+			ClientID:       "",
+			QoS:            1,
+			Retained:       false,
+			Timeout:        30 * time.Second,
+			EchoProbe:      true,
+			PublishTimeout: 10 * time.Second,
+			MaxInFlight:    100,
+			Protocol:       "3.1.1",
+			V5: MQTT5Config{
+				TopicAliases: 16,
+			},
+			Session: SessionConfig{
+				Expiry: time.Hour,
+				Store:  "file",
+			},
+			Will: WillConfig{
+				Enabled: true,
+			},
+			Status: StatusConfig{
+				QoS: 1,
+			},
+			Control: ControlConfig{
+				Token: TokenConfig{
+					RefreshBefore: 5 * time.Minute,
+					Timeout:       30 * time.Second,
+				},
+			},
+			Token: TokenConfig{
+				RefreshBefore: 5 * time.Minute,
+				Timeout:       30 * time.Second,
+			},
+			Cloud: CloudConfig{
+				Azure: AzureIoTConfig{
+					TokenLifetime: time.Hour,
+					RefreshBefore: 5 * time.Minute,
+					APIVersion:    azure.IoTHubAPIVersion,
+				},
+			},
+			Reconnect: ReconnectConfig{
+				InitialInterval: time.Second,
+				MaxInterval:     2 * time.Minute,
+				Multiplier:      2,
+				Jitter:          1,
+			},
+			Topics: TopicsConfig{
+				Template:    defaultTopicTemplate,
+				Prefix:      "signalbeam",
+				Metrics:     "metrics",
+				Logs:        "logs",
+				Events:      "events",
+				Heartbeat:   "heartbeat",
+				Diagnostics: "diagnostics",
+				Echo:        "echo",
+				Sensors:     "sensors",
+				Polls:       "polls",
+				Inventory:   "inventory",
+				Compliance:  "compliance",
+				Flows:       "flows",
+				Speedtest:   "speedtest",
+				Usage:       "usage",
+				Display:     "display",
+				Status:      "status",
+			},
+		},
+		Collection: CollectionConfig{
+			Interval: 30 * time.Second,
+			Metrics: MetricsConfig{
+				Enabled: true,
+				CPU:     true,
+				Memory:  true,
+				Disk:    true,
+				Network: true,
+				Load:    true,
+
+				CPUInfo:             true,
+				DiskIO:              true,
+				NetworkPerInterface: true,
+			},
+			Logs: LogsConfig{
+				Enabled:  false,
+				Paths:    []string{},
+				MaxLines: 1000,
+				Archive: LogArchiveConfig{
+					SegmentBytes: 16 << 20,
+					SegmentAge:   24 * time.Hour,
+					MaxBytes:     1 << 30,
+					MaxAge:       90 * 24 * time.Hour,
+				},
+			},
+			Events: EventsConfig{
+				Enabled:     false,
+				Types:       []string{},
+				DedupWindow: 60 * time.Second,
+			},
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		State: StateConfig{
+			Dir:                "data",
+			CheckpointInterval: time.Minute,
+		},
+		Power: PowerConfig{
+			Mode:         "always_on",
+			WakeInterval: 15 * time.Minute,
+		},
+		Heartbeat: HeartbeatConfig{
+			Interval: 60 * time.Second,
+		},
+		Diagnostics: DiagnosticsConfig{
+			Enabled:    true,
+			Interval:   60 * time.Second,
+			MaxEntries: 20,
+		},
+		Validation: ValidationConfig{
+			Enabled:            true,
+			MaxTags:            32,
+			MaxTagLength:       256,
+			MaxQuarantineBytes: 10 << 20,
+		},
+		Cardinality: CardinalityConfig{
+			MaxSeries: 64,
+			Policy:    "aggregate",
+			Paths: []string{
+				"network.interfaces",
+				"disk.io",
+				"disk.mounts",
+				"processes",
+				"battery",
+			},
+		},
+		Units: UnitsConfig{
+			Enabled: true,
+		},
+		Replay: ReplayConfig{
+			Downsample: DownsampleConfig{
+				Enabled:   false,
+				OlderThan: 6 * time.Hour,
+				Mode:      "window",
+				KeepEvery: 10,
+				Window:    5 * time.Minute,
+			},
+		},
+		Encryption: EncryptionConfig{
+			DataKeyRotation: time.Hour,
+		},
+		Decoders: DecodersConfig{
+			Topic: "{prefix}/{device_id}/decoders/+",
+			WASM: WASMDecodersConfig{
+				MaxMemory: 16 << 20,
+				Timeout:   100 * time.Millisecond,
+			},
+		},
+		LocalAPI: LocalAPIConfig{
+			Listen: "127.0.0.1:8787",
+		},
+		Supervision: SupervisionConfig{
+			InitialBackoff:   time.Second,
+			MaxBackoff:       time.Minute,
+			MaxRestarts:      5,
+			Window:           10 * time.Minute,
+			Quarantine:       30 * time.Minute,
+			FailureThreshold: 10,
+		},
+		Workloads: WorkloadsConfig{
+			Topic:       "{prefix}/groups/{group}/jobs",
+			Concurrency: 4,
+			Timeout:     10 * time.Second,
+		},
+		Bootstrap: BootstrapConfig{
+			Topic:   "{prefix}/{device_id}/config",
+			Timeout: 30 * time.Second,
+		},
+		Accounting: AccountingConfig{
+			Enabled: true,
+		},
+		Tracing: TracingConfig{
+			SampleEvery: 1000,
+			Keep:        20,
+		},
+		OutputPush: OutputPushConfig{
+			Topic: "{prefix}/{device_id}/outputs/+",
+		},
+		Export: ExportConfig{
+			Types:       []string{"metrics"},
+			Interval:    time.Hour,
+			MaxRows:     100000,
+			Compression: "gzip",
+			S3: S3Config{
+				Region:  "us-east-1",
+				Prefix:  "exports",
+				Timeout: 60 * time.Second,
+			},
+		},
+		Uploads: UploadsConfig{
+			Topic:    "{prefix}/{device_id}/uploads",
+			MaxBytes: 1 << 30,
+			Timeout:  30 * time.Minute,
+			S3: S3Config{
+				Region:  "us-east-1",
+				Prefix:  "uploads",
+				Timeout: 60 * time.Second,
+			},
+		},
+		Encoding: "json",
+		Compression: CompressionConfig{
+			Algorithm: "none",
+			MinBytes:  256,
+		},
+		Inventory: InventoryConfig{
+			Interval: 24 * time.Hour,
+			Sections: []string{"os", "packages", "firmware", "network", "disks", "usb", "pci"},
+		},
+		Drift: DriftConfig{
+			Topic:    "{prefix}/{device_id}/baseline",
+			Interval: 15 * time.Minute,
+		},
+		Compliance: ComplianceConfig{
+			Topic:          "{prefix}/{device_id}/checks",
+			Interval:       6 * time.Hour,
+			CommandTimeout: 30 * time.Second,
+		},
+		Flows: FlowsConfig{
+			Interval: time.Minute,
+			MaxFlows: 50,
+		},
+		Speedtest: SpeedtestConfig{
+			Bytes:      10 << 20,
+			Interval:   24 * time.Hour,
+			Timeout:    2 * time.Minute,
+			MonthlyCap: 1 << 30,
+		},
+		DataUsage: DataUsageConfig{
+			ResetDay: 1,
+			Interval: 5 * time.Minute,
+			Throttle: ThrottleConfig{At: 0.9, KeepEvery: 10},
+		},
+		Portal: PortalConfig{
+			Interval: 5 * time.Minute,
+			Timeout:  10 * time.Second,
+			Probes: []PortalProbe{
+				{URL: "http://connectivitycheck.gstatic.com/generate_204", Status: 204},
+				{URL: "http://detectportal.firefox.com/success.txt", Status: 200, Body: "success"},
+			},
+		},
+		Uplink: UplinkConfig{
+			Interval: 30 * time.Second,
+		},
+		OffPeak: OffPeakConfig{
+			Types:      []string{"logs", "inventory", "diagnostics"},
+			MaxRecords: 10000,
+		},
+		GPIO: GPIOConfig{
+			Topic: "{prefix}/{device_id}/gpio",
+		},
+		Buffer: BufferConfig{
+			Type:         "disk",
+			SegmentBytes: 4 << 20,
+			MaxBytes:     256 << 20,
+			MaxAge:       7 * 24 * time.Hour,
+			Memory: MemoryBufferConfig{
+				MaxRecords:   10000,
+				MaxBytes:     16 << 20,
+				Overflow:     "drop_oldest",
+				BlockTimeout: 5 * time.Second,
+			},
+			SQLite: SQLiteBufferConfig{Vacuum: true},
+		},
+		Display: DisplayConfig{
+			Interval: time.Minute,
+			DRMDir:   "/sys/class/drm",
+			Screenshot: ScreenshotConfig{
+				Command:     []string{"grim", "-"},
+				MaxWidth:    640,
+				Quality:     60,
+				Timeout:     10 * time.Second,
+				MinInterval: time.Minute,
+			},
+		},
+		Acks: AcksConfig{
+			Topic:      "{prefix}/{device_id}/acks",
+			Timeout:    time.Minute,
+			TTL:        time.Hour,
+			MaxPending: 10000,
+		},
+		Kiosk: KioskConfig{
+			Interval:        30 * time.Second,
+			Timeout:         5 * time.Second,
+			Failures:        3,
+			RestartInterval: 5 * time.Minute,
+			CDP: KioskCDP{
+				Expression: "document.readyState === 'complete'",
+			},
+		},
+		Backpressure: BackpressureConfig{
+			High:          10000,
+			Low:           1000,
+			MaxFactor:     8,
+			CheckInterval: 30 * time.Second,
+		},
+		Breaker: BreakerConfig{
+			Enabled:     true,
+			Failures:    5,
+			Cooldown:    30 * time.Second,
+			MaxCooldown: 10 * time.Minute,
+		},
+		ACLCheck: ACLCheckConfig{
+			Timeout: 10 * time.Second,
+		},
+		CloudThrottle: CloudThrottleConfig{
+			Topic:       "{prefix}/{device_id}/throttle",
+			MaxFactor:   16,
+			MaxDuration: time.Hour,
+			ReplayRate:  20,
+		},
+		Shutdown: ShutdownConfig{
+			Timeout: 30 * time.Second,
+			Drain:   10 * time.Second,
+		},
+		Peripherals: PeripheralsConfig{
+			Interval: time.Minute,
+			Timeout:  10 * time.Second,
+			USB: USBConfig{
+				SysfsDir: "/sys/bus/usb/devices",
+			},
+		},
+		Bridge: BridgeConfig{
+			Local: LocalBridgeConfig{
+				Broker:     "tcp://127.0.0.1:1883",
+				MaxPayload: 64 << 10,
+			},
+		},
+		Update: UpdateConfig{
+			Channel:  "stable",
+			Topic:    "{prefix}/updates/{channel}",
+			MaxBytes: 200 << 20,
+			Timeout:  10 * time.Minute,
+			Image: ImageUpdateConfig{
+				System:        "auto",
+				MaxBytes:      4 << 30,
+				Timeout:       time.Hour,
+				RebootCommand: []string{"systemctl", "reboot"},
+			},
+		},
+		Budget: BudgetConfig{
+			Burst:     20,
+			ResetDay:  1,
+			DegradeAt: 0.8,
+			Low:       []string{"logs", "flows", "inventory", "diagnostics"},
+			Critical:  []string{"heartbeat", "events"},
+		},
+		Notify: NotifyConfig{
+			Events:      []string{"quality_alert"},
+			Cooldown:    15 * time.Minute,
+			DedupWindow: time.Hour,
+			Timeout:     2 * time.Minute,
+			SMS:         SMSConfig{Baud: 115200},
+		},
+		Fallback: FallbackConfig{
+			Compress:      true,
+			BatchSize:     100,
+			FlushInterval: 5 * time.Second,
+			Timeout:       30 * time.Second,
+			MaxBuffer:     10000,
+		},
+		Profile: "default",
+	}
+
+	// Read config file if it exists
+	if _, err := os.Stat(path); err == nil {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+
+	// Layer the bootstrapped configuration over the file
+	if len(overlay) > 0 {
+		if err := cfg.applyOverlay(overlay); err != nil {
+			return nil, err
+		}
+		cfg.Bootstrapped = true
+	}
+
+	// Generate device ID if empty
+	if cfg.Device.ID == "" {
+		cfg.Device.ID = generateDeviceID(cfg.Device.IDSource)
+	}
+
+	// Apply the runtime profile
+	if err := cfg.applyProfile(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// Fill in the preset of a managed IoT service
+	cfg.applyCloud()
+
+	// Place the topics under the organization
+	cfg.applyTenant()
+
+	// Set client ID if empty
+	if cfg.MQTT.ClientID == "" {
+		cfg.MQTT.ClientID = fmt.Sprintf("signalbeam-%s", cfg.Device.ID)
+	}
+	if cfg.MQTT.Control.ClientID == "" {
+		cfg.MQTT.Control.ClientID = cfg.MQTT.ClientID + "-control"
+	}
+	if cfg.Bridge.Local.ClientID == "" {
+		cfg.Bridge.Local.ClientID = cfg.MQTT.ClientID + "-local"
+	}
+
+	// Validate configuration
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// applyOverlay merges a bootstrapped document into the configuration. The
+// device identity, broker, local broker and gateway connections, HTTPS fallback, proxies,
+// state location and bootstrap settings always come from the local file so a bad
+// document cannot strand the device. Upload roots and screenshots are local
+// too, so a document cannot open further files to upload or capture the
+// screen
+func (c *Config) applyOverlay(overlay []byte) error {
+	device := c.Device
+	mqtt := c.MQTT
+	st := c.State
+	bootstrap := c.Bootstrap
+	fallback := c.Fallback
+	gateway := c.Gateway
+	px := c.Proxy
+	uploadRoots := c.Uploads.Roots
+	gpio := c.GPIO
+	localBridge := c.Bridge.Local
+	screenshot := c.Display.Screenshot
+
+	if err := yaml.Unmarshal(overlay, c); err != nil {
+		return fmt.Errorf("failed to parse bootstrapped config: %w", err)
+	}
+
+	c.Device.Org = device.Org
+	c.Device.ID = device.ID
+	c.Device.IDSource = device.IDSource
+	c.MQTT.Broker = mqtt.Broker
+	c.MQTT.ClientID = mqtt.ClientID
+	c.MQTT.Username = mqtt.Username
+	c.MQTT.Password = mqtt.Password
+	c.MQTT.TLS = mqtt.TLS
+	c.MQTT.Protocol = mqtt.Protocol
+	c.MQTT.Proxy = mqtt.Proxy
+	c.MQTT.Control = mqtt.Control
+	c.MQTT.Token = mqtt.Token
+	c.MQTT.Cloud = mqtt.Cloud
+	c.Proxy = px
+	c.State = st
+	c.Bootstrap = bootstrap
+	c.Fallback = fallback
+	c.Gateway = gateway
+	c.Uploads.Roots = uploadRoots
+	c.GPIO = gpio
+	c.Bridge.Local = localBridge
+	c.Display.Screenshot = screenshot
+	return nil
+}
+
+// Hash returns a short fingerprint of the effective configuration
+func (c *Config) Hash() string {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// validate checks if the configuration is valid
+func (c *Config) validate() error {
+	if c.Device.ID == "" {
+		return fmt.Errorf("device.id is required")
+	}
+	if c.MQTT.Broker == "" {
+		return fmt.Errorf("mqtt.broker is required")
+	}
+	if c.MQTT.Protocol != "3.1.1" && c.MQTT.Protocol != "5" {
+		return fmt.Errorf("mqtt.protocol must be 3.1.1 or 5")
+	}
+	if c.MQTT.Session.Persistent && (c.MQTT.Session.Expiry < time.Second || c.MQTT.Session.Expiry.Seconds() >= math.MaxUint32) {
+		return fmt.Errorf("mqtt.session.expiry must be at least 1s and at most 4294967295s")
+	}
+	if s := c.MQTT.Session.Store; s != "memory" && s != "file" {
+		return fmt.Errorf("mqtt.session.store must be memory or file")
+	}
+	if c.MQTT.Will.Delay < 0 || c.MQTT.Will.Delay.Seconds() >= math.MaxUint32 {
+		return fmt.Errorf("mqtt.will.delay must not be negative and at most 4294967295s")
+	}
+	if c.MQTT.Status.Enabled && c.MQTT.Status.QoS > 2 {
+		return fmt.Errorf("mqtt.status.qos must be 0, 1 or 2")
+	}
+	if r := c.MQTT.Reconnect; r.InitialInterval <= 0 || r.MaxInterval < r.InitialInterval {
+		return fmt.Errorf("mqtt.reconnect.initial_interval must be positive and not exceed max_interval")
+	}
+	if r := c.MQTT.Reconnect; r.Multiplier < 1 || r.Jitter < 0 || r.Jitter > 1 {
+		return fmt.Errorf("mqtt.reconnect.multiplier must be at least 1 and jitter between 0 and 1")
+	}
+	if c.MQTT.PublishTimeout <= 0 || c.MQTT.MaxInFlight < 1 {
+		return fmt.Errorf("mqtt.publish_timeout must be positive and mqtt.max_in_flight at least 1")
+	}
+	if o := c.Device.Org; o != "" && (topics.Level(o) != o || strings.ContainsAny(o, "{}")) {
+		return fmt.Errorf("device.org must not contain /, +, #, { or }")
+	}
+	if err := c.validateTopicTemplate(); err != nil {
+		return err
+	}
+	if err := c.validateIngestion(); err != nil {
+		return err
+	}
+	if err := c.validateLabels(); err != nil {
+		return err
+	}
+	if (c.MQTT.TLS.CertFile == "") != (c.MQTT.TLS.KeyFile == "") {
+		return fmt.Errorf("mqtt.tls.cert_file and mqtt.tls.key_file must be set together")
+	}
+	if ctl := c.MQTT.Control; ctl.Enabled {
+		switch {
+		case c.Gateway.Enabled:
+			return fmt.Errorf("mqtt.control does not apply to the gRPC transport")
+		case (ctl.CertFile == "") != (ctl.KeyFile == ""):
+			return fmt.Errorf("mqtt.control.cert_file and mqtt.control.key_file must be set together")
+		case ctl.Username == "" && ctl.CertFile == "" && !ctl.Token.Enabled:
+			return fmt.Errorf("mqtt.control needs a username, a client certificate or a token")
+		case ctl.ClientID == c.MQTT.ClientID:
+			return fmt.Errorf("mqtt.control.client_id must differ from mqtt.client_id")
+		case ctl.Username == c.MQTT.Username && ctl.CertFile == c.MQTT.TLS.CertFile &&
+			(!ctl.Token.Enabled || ctl.Token.ClientID == c.MQTT.Token.ClientID && ctl.Token.URL == c.MQTT.Token.URL):
+			return fmt.Errorf("mqtt.control credentials must differ from the telemetry credentials")
+		}
+		if err := ctl.Token.validate("mqtt.control.token", ctl.Password); err != nil {
+			return err
+		}
+	}
+	if c.MQTT.Token.Enabled && c.Gateway.Enabled {
+		return fmt.Errorf("mqtt.token does not apply to the gRPC transport")
+	}
+	if err := c.MQTT.Token.validate("mqtt.token", c.MQTT.Password); err != nil {
+		return err
+	}
+	if err := c.validateCloud(); err != nil {
+		return err
+	}
+	if c.Collection.Interval <= 0 {
+		return fmt.Errorf("collection.interval must be positive")
+	}
+	if logs := c.Collection.Logs; logs.Enabled {
+		a := logs.Archive
+		switch {
+		case len(logs.Paths) == 0:
+			return fmt.Errorf("collection.logs.paths is required")
+		case logs.MaxLines <= 0:
+			return fmt.Errorf("collection.logs.max_lines must be positive")
+		case a.SegmentBytes < 0 || a.SegmentAge < 0 || a.MaxBytes < 0 || a.MaxAge < 0 || a.MinAge < 0:
+			return fmt.Errorf("collection.logs.archive sizes and ages must not be negative")
+		case a.Enabled && a.MaxAge > 0 && a.MinAge > a.MaxAge:
+			return fmt.Errorf("collection.logs.archive.min_age must not exceed max_age")
+		}
+	} else if logs.Archive.Enabled {
+		return fmt.Errorf("collection.logs.archive requires collection.logs.enabled")
+	}
+	if c.Heartbeat.Interval < time.Second {
+		return fmt.Errorf("heartbeat.interval must be at least 1s")
+	}
+	if c.Diagnostics.Enabled && c.Diagnostics.Interval <= 0 {
+		return fmt.Errorf("diagnostics.interval must be positive")
+	}
+	if c.Cardinality.Policy != "drop" && c.Cardinality.Policy != "aggregate" {
+		return fmt.Errorf("cardinality.policy must be drop or aggregate")
+	}
+	for i, r := range c.Units.Normalize {
+		if err := (units.Rule{Path: r.Path, From: r.From, To: r.To}).Validate(); err != nil {
+			return fmt.Errorf("units.normalize[%d]: %w", i, err)
+		}
+	}
+	if ds := c.Replay.Downsample; ds.Enabled {
+		switch {
+		case ds.Mode != "nth" && ds.Mode != "window":
+			return fmt.Errorf("replay.downsample.mode must be nth or window")
+		case ds.Mode == "nth" && ds.KeepEvery < 1:
+			return fmt.Errorf("replay.downsample.keep_every must be at least 1")
+		case ds.Mode == "window" && ds.Window <= 0:
+			return fmt.Errorf("replay.downsample.window must be positive")
+		}
+	}
+	if f := c.Fallback; f.Enabled {
+		u, err := url.Parse(f.URL)
+		switch {
+		case err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "":
+			return fmt.Errorf("https_fallback.url must be an http or https URL")
+		case f.BatchSize < 1 || f.MaxBuffer < f.BatchSize:
+			return fmt.Errorf("https_fallback.batch_size must be at least 1 and at most max_buffer")
+		case f.FlushInterval <= 0 || f.Timeout <= 0:
+			return fmt.Errorf("https_fallback.flush_interval and timeout must be positive")
+		}
+	}
+	if g := c.Gateway; g.Enabled {
+		if _, port, err := net.SplitHostPort(g.Address); err != nil || port == "" {
+			return fmt.Errorf("grpc.address must be host:port")
+		}
+		if (g.TLS.CertFile == "") != (g.TLS.KeyFile == "") {
+			return fmt.Errorf("grpc.tls.cert_file and grpc.tls.key_file must be set together")
+		}
+	}
+	if e := c.Export; e.Enabled {
+		for _, t := range e.Types {
+			if !streamTypes[t] || t == "heartbeat" {
+				return fmt.Errorf("parquet_export.types: %q is not an exported data type", t)
+			}
+		}
+		switch {
+		case e.Interval <= 0 || e.MaxRows < 1:
+			return fmt.Errorf("parquet_export.interval must be positive and max_rows at least 1")
+		case e.Compression != "gzip" && e.Compression != "none":
+			return fmt.Errorf("parquet_export.compression must be gzip or none")
+		}
+		if err := e.S3.validate("parquet_export.s3"); err != nil {
+			return err
+		}
+	}
+	if u := c.Uploads; u.Enabled {
+		switch {
+		case u.Topic == "":
+			return fmt.Errorf("uploads.topic is required")
+		case u.MaxBytes < 1 || u.Timeout <= 0:
+			return fmt.Errorf("uploads.max_bytes and uploads.timeout must be positive")
+		}
+		for _, root := range u.Roots {
+			if !filepath.IsAbs(root) {
+				return fmt.Errorf("uploads.roots: %q must be absolute", root)
+			}
+		}
+		if err := u.S3.validate("uploads.s3"); err != nil {
+			return err
+		}
+	}
+	if inv := c.Inventory; inv.Enabled {
+		if inv.Interval < time.Minute {
+			return fmt.Errorf("inventory.interval must be at least 1m")
+		}
+		for _, section := range inv.Sections {
+			if !inventorySections[section] {
+				return fmt.Errorf("inventory.sections: unknown section %q", section)
+			}
+		}
+	}
+	if d := c.Drift; d.Enabled {
+		if d.Topic == "" {
+			return fmt.Errorf("drift.topic is required when drift detection is enabled")
+		}
+		if d.Interval < 10*time.Second {
+			return fmt.Errorf("drift.interval must be at least 10s")
+		}
+	}
+	if cc := c.Compliance; cc.Enabled {
+		switch {
+		case cc.Topic == "":
+			return fmt.Errorf("compliance.topic is required when compliance checks are enabled")
+		case cc.Interval < time.Minute:
+			return fmt.Errorf("compliance.interval must be at least 1m")
+		case cc.CommandTimeout <= 0:
+			return fmt.Errorf("compliance.command_timeout must be positive")
+		}
+	}
+	if f := c.Flows; f.Enabled {
+		switch {
+		case len(f.Interfaces) == 0:
+			return fmt.Errorf("flows.interfaces is required when flow statistics are enabled")
+		case f.Interval < 10*time.Second:
+			return fmt.Errorf("flows.interval must be at least 10s")
+		case f.MaxFlows < 1:
+			return fmt.Errorf("flows.max_flows must be at least 1")
+		}
+	}
+	if st := c.Speedtest; st.Enabled {
+		switch {
+		case st.DownloadURL == "" && st.UploadURL == "":
+			return fmt.Errorf("speedtest.download_url or speedtest.upload_url is required when speed tests are enabled")
+		case st.Bytes < 1<<10:
+			return fmt.Errorf("speedtest.bytes must be at least 1024")
+		case st.Interval < 10*time.Minute:
+			return fmt.Errorf("speedtest.interval must be at least 10m")
+		case st.Timeout <= 0:
+			return fmt.Errorf("speedtest.timeout must be positive")
+		case st.MonthlyCap < 0:
+			return fmt.Errorf("speedtest.monthly_cap must not be negative")
+		}
+		for _, u := range []string{st.DownloadURL, st.UploadURL} {
+			if parsed, err := url.Parse(u); u != "" && (err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "") {
+				return fmt.Errorf("speedtest: %q is not an http or https URL", u)
+			}
+		}
+	}
+	if du := c.DataUsage; du.Enabled {
+		switch {
+		case len(du.Interfaces) == 0:
+			return fmt.Errorf("data_usage.interfaces is required when data usage accounting is enabled")
+		case du.Quota < 0:
+			return fmt.Errorf("data_usage.quota must not be negative")
+		case du.ResetDay < 1 || du.ResetDay > 28:
+			return fmt.Errorf("data_usage.reset_day must be between 1 and 28")
+		case du.Interval < 10*time.Second:
+			return fmt.Errorf("data_usage.interval must be at least 10s")
+		}
+		if t := du.Throttle; len(t.Types) > 0 {
+			switch {
+			case du.Quota == 0:
+				return fmt.Errorf("data_usage.throttle needs a quota")
+			case t.At <= 0 || t.At > 1:
+				return fmt.Errorf("data_usage.throttle.at must be above 0 and at most 1")
+			case t.KeepEvery < 0:
+				return fmt.Errorf("data_usage.throttle.keep_every must not be negative")
+			}
+			for _, typ := range t.Types {
+				if !streamTypes[typ] || typ == "heartbeat" || typ == "usage" {
+					return fmt.Errorf("data_usage.throttle.types: %q cannot be throttled", typ)
+				}
+			}
+		}
+	}
+	if p := c.Portal; p.Enabled {
+		switch {
+		case p.Interval < 10*time.Second:
+			return fmt.Errorf("captive_portal.interval must be at least 10s")
+		case p.Timeout <= 0:
+			return fmt.Errorf("captive_portal.timeout must be positive")
+		case len(p.Probes) == 0:
+			return fmt.Errorf("captive_portal.probes is required when captive portal detection is enabled")
+		}
+		for i, probe := range p.Probes {
+			if err := portal.Validate(portal.Probe(probe)); err != nil {
+				return fmt.Errorf("captive_portal.probes[%d]: %w", i, err)
+			}
+		}
+	}
+	proxies := []struct {
+		name   string
+		config ProxyConfig
+	}{{"proxy", c.Proxy}, {"mqtt.proxy", c.MQTT.Proxy}, {"https_fallback.proxy", c.Fallback.Proxy}, {"grpc.proxy", c.Gateway.Proxy}}
+	for i, p := range proxies {
+		switch {
+		case p.config.URL == "":
+			if p.config.Username != "" {
+				return fmt.Errorf("%s.url is required with credentials", p.name)
+			}
+		case p.config.URL == ProxyDirect:
+			if i == 0 {
+				return fmt.Errorf("proxy.url %q only applies to a transport; leave it empty for no proxy", ProxyDirect)
+			}
+		default:
+			if _, err := proxy.Parse(p.config.URL); err != nil {
+				return fmt.Errorf("%s.url: %w", p.name, err)
+			}
+			if len(p.config.Username) > 255 || len(p.config.Password) > 255 {
+				return fmt.Errorf("%s credentials must be at most 255 bytes", p.name)
+			}
+		}
+	}
+	if u := c.Uplink; u.Enabled {
+		if u.Interval < time.Second {
+			return fmt.Errorf("uplink.interval must be at least 1s")
+		}
+		for name, kind := range u.Kinds {
+			if err := uplink.ValidKind(kind); err != nil {
+				return fmt.Errorf("uplink.kinds.%s: %w", name, err)
+			}
+		}
+	}
+	if o := c.OffPeak; o.Enabled {
+		switch {
+		case len(o.Windows) == 0:
+			return fmt.Errorf("off_peak.windows is required when off-peak scheduling is enabled")
+		case len(o.Types) == 0:
+			return fmt.Errorf("off_peak.types is required when off-peak scheduling is enabled")
+		case o.MaxRecords <= 0:
+			return fmt.Errorf("off_peak.max_records must be positive")
+		case slices.Contains(o.Types, "heartbeat"):
+			return fmt.Errorf("off_peak.types cannot hold heartbeats")
+		}
+		if _, err := schedule.Parse(o.Windows); err != nil {
+			return fmt.Errorf("off_peak.windows: %w", err)
+		}
+	}
+	if b := c.Budget; b.Enabled {
+		switch {
+		case b.MaxRate < 0 || b.Daily < 0 || b.Monthly < 0:
+			return fmt.Errorf("budget.max_rate, daily_bytes and monthly_bytes must not be negative")
+		case b.MaxRate == 0 && b.Daily == 0 && b.Monthly == 0:
+			return fmt.Errorf("budget needs max_rate, daily_bytes or monthly_bytes when enabled")
+		case b.MaxRate > 0 && b.Burst < 1:
+			return fmt.Errorf("budget.burst must be at least 1")
+		case b.ResetDay < 1 || b.ResetDay > 28:
+			return fmt.Errorf("budget.reset_day must be between 1 and 28")
+		case b.DegradeAt <= 0 || b.DegradeAt > 1:
+			return fmt.Errorf("budget.degrade_at must be above 0 and at most 1")
+		case b.HardCap && b.Daily == 0 && b.Monthly == 0:
+			return fmt.Errorf("budget.hard_cap needs daily_bytes or monthly_bytes")
+		}
+		for _, typ := range b.Low {
+			if !streamTypes[typ] {
+				return fmt.Errorf("budget.low: unknown data type %q", typ)
+			}
+			if slices.Contains(b.Critical, typ) {
+				return fmt.Errorf("budget: %q is both low and critical", typ)
+			}
+		}
+		for _, typ := range b.Critical {
+			if !streamTypes[typ] {
+				return fmt.Errorf("budget.critical: unknown data type %q", typ)
+			}
+		}
+	}
+	if b := c.Buffer; b.Enabled {
+		switch {
+		case b.Type != "disk" && b.Type != "memory" && b.Type != "sqlite":
+			return fmt.Errorf("buffer.type must be disk, memory or sqlite")
+		case b.Type == "sqlite" && b.MaxBytes <= 0:
+			return fmt.Errorf("buffer.max_bytes must be positive")
+		case b.Type == "disk" && (b.SegmentBytes <= 0 || b.MaxBytes < b.SegmentBytes):
+			return fmt.Errorf("buffer.segment_bytes must be positive and not exceed max_bytes")
+		case b.MaxAge < 0:
+			return fmt.Errorf("buffer.max_age must not be negative")
+		}
+		if m := b.Memory; b.Type == "memory" {
+			switch {
+			case m.MaxRecords <= 0 || m.MaxBytes <= 0:
+				return fmt.Errorf("buffer.memory.max_records and max_bytes must be positive")
+			case m.Overflow != "drop_oldest" && m.Overflow != "drop_newest" && m.Overflow != "block":
+				return fmt.Errorf("buffer.memory.overflow must be drop_oldest, drop_newest or block")
+			case m.Overflow == "block" && m.BlockTimeout <= 0:
+				return fmt.Errorf("buffer.memory.block_timeout must be positive")
+			}
+		}
+	}
+	if d := c.Display; d.Enabled {
+		switch {
+		case d.Interval < 10*time.Second:
+			return fmt.Errorf("display.interval must be at least 10s")
+		case d.DRMDir == "":
+			return fmt.Errorf("display.drm_dir is required")
+		}
+		if s := d.Screenshot; s.Enabled {
+			switch {
+			case !c.Uploads.Enabled:
+				return fmt.Errorf("display.screenshot requires uploads to be enabled")
+			case len(s.Command) == 0:
+				return fmt.Errorf("display.screenshot.command is required")
+			case s.MaxWidth < 16:
+				return fmt.Errorf("display.screenshot.max_width must be at least 16")
+			case s.Quality < 1 || s.Quality > 100:
+				return fmt.Errorf("display.screenshot.quality must be between 1 and 100")
+			case s.Timeout <= 0:
+				return fmt.Errorf("display.screenshot.timeout must be positive")
+			case s.MinInterval < 0:
+				return fmt.Errorf("display.screenshot.min_interval must not be negative")
+			}
+		}
+	}
+	if a := c.Acks; a.Enabled {
+		switch {
+		case a.Topic == "":
+			return fmt.Errorf("acks.topic is required")
+		case a.Timeout < time.Second:
+			return fmt.Errorf("acks.timeout must be at least 1s")
+		case a.TTL < a.Timeout:
+			return fmt.Errorf("acks.ttl must not be shorter than acks.timeout")
+		case a.MaxPending < 1:
+			return fmt.Errorf("acks.max_pending must be at least 1")
+		}
+		for _, typ := range a.Types {
+			if !streamTypes[typ] || typ == "heartbeat" {
+				return fmt.Errorf("acks.types: unknown data type %q", typ)
+			}
+		}
+	}
+	if b := c.Breaker; b.Enabled {
+		switch {
+		case b.Failures < 1:
+			return fmt.Errorf("breaker.failures must be at least 1")
+		case b.Cooldown < time.Second:
+			return fmt.Errorf("breaker.cooldown must be at least 1s")
+		case b.MaxCooldown < b.Cooldown:
+			return fmt.Errorf("breaker.max_cooldown must not be below breaker.cooldown")
+		}
+	}
+	if a := c.ACLCheck; a.Enabled {
+		switch {
+		case a.Timeout < time.Second:
+			return fmt.Errorf("acl_check.timeout must be at least 1s")
+		case c.Gateway.Enabled:
+			return fmt.Errorf("acl_check needs an MQTT broker, the Edge Gateway has no ACLs of its own")
+		}
+	}
+	if t := c.CloudThrottle; t.Enabled {
+		switch {
+		case t.Topic == "":
+			return fmt.Errorf("cloud_throttle.topic is required")
+		case t.MaxFactor < 2:
+			return fmt.Errorf("cloud_throttle.max_factor must be at least 2")
+		case t.MaxDuration <= 0:
+			return fmt.Errorf("cloud_throttle.max_duration must be positive")
+		case t.ReplayRate <= 0:
+			return fmt.Errorf("cloud_throttle.replay_rate must be positive")
+		}
+	}
+	if b := c.Backpressure; b.Enabled {
+		switch {
+		case b.High < 1:
+			return fmt.Errorf("backpressure.high must be at least 1")
+		case b.Low < 0 || b.Low >= b.High:
+			return fmt.Errorf("backpressure.low must not be negative and must be below backpressure.high")
+		case b.MaxFactor < 2:
+			return fmt.Errorf("backpressure.max_factor must be at least 2")
+		case b.CheckInterval < time.Second:
+			return fmt.Errorf("backpressure.check_interval must be at least 1s")
+		}
+	}
+	switch s := c.Shutdown; {
+	case s.Timeout <= 0:
+		return fmt.Errorf("shutdown.timeout must be positive")
+	case s.Drain < 0 || s.Drain > s.Timeout:
+		return fmt.Errorf("shutdown.drain must not be negative or exceed shutdown.timeout")
+	}
+	if k := c.Kiosk; k.Enabled {
+		switch {
+		case k.URL == "" && k.Process == "" && k.CDP.URL == "":
+			return fmt.Errorf("kiosk.url, kiosk.process or kiosk.cdp.url is required when the kiosk probe is enabled")
+		case k.Interval < time.Second:
+			return fmt.Errorf("kiosk.interval must be at least 1s")
+		case k.Timeout <= 0 || k.Timeout > k.Interval:
+			return fmt.Errorf("kiosk.timeout must be positive and not exceed the interval")
+		case k.Failures < 1:
+			return fmt.Errorf("kiosk.failures must be at least 1")
+		case len(k.RestartCommand) > 0 && k.RestartInterval < k.Interval:
+			return fmt.Errorf("kiosk.restart_interval must not be shorter than the interval")
+		}
+		for _, f := range []struct{ name, url string }{{"url", k.URL}, {"cdp.url", k.CDP.URL}} {
+			if parsed, err := url.Parse(f.url); f.url != "" && (err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "") {
+				return fmt.Errorf("kiosk.%s must be an http or https URL", f.name)
+			}
+		}
+	}
+	if p := c.Peripherals; p.Enabled {
+		switch {
+		case len(p.Printers) == 0 && !p.USB.Enabled:
+			return fmt.Errorf("peripherals.printers or peripherals.usb is required when peripherals are enabled")
+		case p.Interval < 10*time.Second:
+			return fmt.Errorf("peripherals.interval must be at least 10s")
+		case p.Timeout <= 0 || p.Timeout > p.Interval:
+			return fmt.Errorf("peripherals.timeout must be positive and not exceed the interval")
+		case p.USB.Enabled && p.USB.SysfsDir == "":
+			return fmt.Errorf("peripherals.usb.sysfs_dir is required")
+		}
+		names := make(map[string]bool)
+		for i, pr := range p.Printers {
+			u, err := url.Parse(pr.URI)
+			switch {
+			case pr.Name == "":
+				return fmt.Errorf("peripherals.printers[%d]: name is required", i)
+			case names[pr.Name]:
+				return fmt.Errorf("peripherals.printers: duplicate name %q", pr.Name)
+			case err != nil || u.Host == "" || !slices.Contains([]string{"ipp", "ipps", "http", "https"}, u.Scheme):
+				return fmt.Errorf("peripherals.printers[%d]: uri must be an ipp, ipps, http or https URL", i)
+			}
+			names[pr.Name] = true
+		}
+		names = make(map[string]bool)
+		for i, d := range p.USB.Devices {
+			switch {
+			case d.Name == "":
+				return fmt.Errorf("peripherals.usb.devices[%d]: name is required", i)
+			case names[d.Name]:
+				return fmt.Errorf("peripherals.usb.devices: duplicate name %q", d.Name)
+			case !validUSBID(d.ID):
+				return fmt.Errorf("peripherals.usb.devices[%d]: id must be vendor:product in hex, e.g. 04b8:0e28", i)
+			}
+			names[d.Name] = true
+		}
+	}
+	if c.GPIO.Enabled {
+		if err := c.validateGPIO(); err != nil {
+			return err
+		}
+	}
+	if u := c.Update; u.Enabled {
+		switch {
+		case !update.ValidChannel(u.Channel):
+			return fmt.Errorf("update.channel must be one of %s", strings.Join(update.Channels, ", "))
+		case u.Topic == "":
+			return fmt.Errorf("update.topic is required when updates are enabled")
+		case u.MaxBytes <= 0 || u.Timeout <= 0:
+			return fmt.Errorf("update.max_bytes and update.timeout must be positive")
+		case u.Image.System != "auto" && u.Image.System != "none" && !slices.Contains(update.ImageSystems, u.Image.System):
+			return fmt.Errorf("update.image.system must be auto, none or one of %s", strings.Join(update.ImageSystems, ", "))
+		case u.Image.System != "none" && (u.Image.MaxBytes <= 0 || u.Image.Timeout <= 0 || len(u.Image.RebootCommand) == 0):
+			return fmt.Errorf("update.image.max_bytes and timeout must be positive and reboot_command set")
+		}
+	}
+	if n := c.Notify; n.Enabled {
+		switch {
+		case len(n.Events) == 0:
+			return fmt.Errorf("notifications.events must not be empty")
+		case !n.SMTP.Enabled && !n.SMS.Enabled:
+			return fmt.Errorf("notifications need smtp or sms enabled")
+		case n.Cooldown < 0 || n.DedupWindow < 0 || n.Timeout <= 0:
+			return fmt.Errorf("notifications.cooldown and dedup_window must not be negative and timeout must be positive")
+		}
+		if m := n.SMTP; m.Enabled {
+			if _, _, err := net.SplitHostPort(m.Address); err != nil {
+				return fmt.Errorf("notifications.smtp.address must be host:port")
+			}
+			if m.From == "" || len(m.To) == 0 {
+				return fmt.Errorf("notifications.smtp.from and to are required")
+			}
+		}
+		if m := n.SMS; m.Enabled {
+			switch {
+			case !filepath.IsAbs(m.Device):
+				return fmt.Errorf("notifications.sms.device must be an absolute path")
+			case len(m.To) == 0:
+				return fmt.Errorf("notifications.sms.to is required")
+			case !slices.Contains([]int{9600, 19200, 38400, 57600, 115200, 230400, 460800, 921600}, m.Baud):
+				return fmt.Errorf("notifications.sms.baud must be a standard rate from 9600 to 921600")
+			}
+		}
+	}
+	names := make(map[string]bool, len(c.Outputs))
+	for i, o := range c.Outputs {
+		if err := o.Validate(); err != nil {
+			return fmt.Errorf("outputs[%d]: %w", i, err)
+		}
+		if names[o.Name] {
+			return fmt.Errorf("outputs[%d]: duplicate name %q", i, o.Name)
+		}
+		names[o.Name] = true
+	}
+	if t := c.Tracing; t.Enabled && (t.SampleEvery < 1 || t.Keep < 0) {
+		return fmt.Errorf("tracing.sample_every must be at least 1 and tracing.keep must not be negative")
+	}
+	for name, s := range c.MQTT.Streams {
+		if !streamTypes[name] {
+			return fmt.Errorf("mqtt.streams.%s: unknown data type", name)
+		}
+		if s.QoS != nil && *s.QoS > 2 {
+			return fmt.Errorf("mqtt.streams.%s.qos must be 0, 1 or 2", name)
+		}
+		if b := s.Batch; b.Size != 0 || b.Interval != 0 {
+			switch {
+			case name == "heartbeat":
+				return fmt.Errorf("mqtt.streams.heartbeat.batch is not supported, heartbeats signal liveness")
+			case b.Size < 2:
+				return fmt.Errorf("mqtt.streams.%s.batch.size must be at least 2", name)
+			case b.Interval <= 0:
+				return fmt.Errorf("mqtt.streams.%s.batch.interval must be positive", name)
+			}
+		}
+	}
+	for i, r := range c.Routing.Rules {
+		if r.QoS != nil && *r.QoS > 2 {
+			return fmt.Errorf("routing.rules[%d].qos must be 0, 1 or 2", i)
+		}
+		if r.Match.Expression != "" {
+			if _, err := expr.Parse(r.Match.Expression); err != nil {
+				return fmt.Errorf("routing.rules[%d].match.expression: %w", i, err)
+			}
+		}
+		if slices.Contains(r.Outputs, "") {
+			return fmt.Errorf("routing.rules[%d].outputs must name outputs", i)
+		}
+	}
+	if w := c.Decoders.WASM; w.MaxMemory < 64<<10 || w.Timeout <= 0 {
+		return fmt.Errorf("decoders.wasm.max_memory must be at least 64 KiB and decoders.wasm.timeout positive")
+	}
+	if !slices.Contains([]string{"json", "cbor", "msgpack"}, c.Encoding) {
+		return fmt.Errorf("encoding must be json, cbor or msgpack")
+	}
+	if c.Encoding != "json" && c.Signing.Enabled && !c.Encryption.Enabled {
+		return fmt.Errorf("signing needs JSON payloads: use encoding json or enable encryption")
+	}
+	if !slices.Contains([]string{"none", "gzip", "zstd"}, c.Compression.Algorithm) {
+		return fmt.Errorf("compression.algorithm must be none, gzip or zstd")
+	}
+	if c.Compression.MinBytes < 0 {
+		return fmt.Errorf("compression.min_bytes must not be negative")
+	}
+	if c.Encryption.Enabled && (c.Encryption.KeyID == "" || c.Encryption.KeyFile == "") {
+		return fmt.Errorf("encryption.key_id and encryption.key_file are required when encryption is enabled")
+	}
+	if c.Signing.Enabled && (c.Signing.KeyID == "" || c.Signing.PrivateKeyFile == "") {
+		return fmt.Errorf("signing.key_id and signing.private_key_file are required when signing is enabled")
+	}
+	if c.LocalAPI.Enabled {
+		if len(c.LocalAPI.Tokens) == 0 && c.LocalAPI.TLS.ClientCAFile == "" {
+			return fmt.Errorf("local_api requires at least one token or a client CA")
+		}
+		for i, t := range c.LocalAPI.Tokens {
+			if len(t.SHA256) != 64 {
+				return fmt.Errorf("local_api.tokens[%d].sha256 must be a hex SHA-256 hash", i)
+			}
+			if t.Role != "read" && t.Role != "admin" {
+				return fmt.Errorf("local_api.tokens[%d].role must be read or admin", i)
+			}
+		}
+		if c.LocalAPI.TLS.ClientCAFile != "" && (c.LocalAPI.TLS.CertFile == "" || c.LocalAPI.TLS.KeyFile == "") {
+			return fmt.Errorf("local_api.tls.cert_file and key_file are required for client certificate auth")
+		}
+		for cn, role := range c.LocalAPI.TLS.ClientRoles {
+			if role != "read" && role != "admin" {
+				return fmt.Errorf("local_api.tls.client_roles[%s] must be read or admin", cn)
+			}
+		}
+	}
+	if c.Egress.Enabled {
+		if len(c.Egress.Allowlist) == 0 {
+			return fmt.Errorf("egress.allowlist must not be empty when egress restriction is enabled")
+		}
+		if err := egress.Validate(c.Egress.Allowlist); err != nil {
+			return fmt.Errorf("egress.allowlist: %w", err)
+		}
+	}
+	for i := range c.Quality.Bounds {
+		b := &c.Quality.Bounds[i]
+		if b.Path == "" {
+			return fmt.Errorf("quality.bounds[%d].path is required", i)
+		}
+		if b.Type == "" {
+			b.Type = "metrics"
+		}
+		if b.Min != nil && b.Max != nil && *b.Min > *b.Max {
+			return fmt.Errorf("quality.bounds[%d].min must not exceed max", i)
+		}
+	}
+	for i := range c.Exception.Rules {
+		r := &c.Exception.Rules[i]
+		if r.Path == "" {
+			return fmt.Errorf("report_by_exception.rules[%d].path is required", i)
+		}
+		if r.Type == "" {
+			r.Type = "metrics"
+		}
+		if r.Absolute < 0 || r.Percent < 0 {
+			return fmt.Errorf("report_by_exception.rules[%d] thresholds must not be negative", i)
+		}
+	}
+	seen := map[string]bool{c.Device.ID: true}
+	for i := range c.Virtual {
+		v := &c.Virtual[i]
+		if v.ID == "" || seen[v.ID] {
+			return fmt.Errorf("virtual_devices[%d].id must be set and unique", i)
+		}
+		seen[v.ID] = true
+		if len(v.Metrics) == 0 {
+			return fmt.Errorf("virtual_devices[%d].metrics must not be empty", i)
+		}
+		if v.StaleAfter <= 0 {
+			v.StaleAfter = 3 * c.Collection.Interval
+		}
+		for name, in := range v.Inputs {
+			if in.Path == "" {
+				return fmt.Errorf("virtual_devices[%d].inputs.%s.path is required", i, name)
+			}
+			if in.Type == "" {
+				in.Type = "metrics"
+				v.Inputs[name] = in
+			}
+		}
+		for metric, source := range v.Metrics {
+			e, err := expr.Parse(source)
+			if err != nil {
+				return fmt.Errorf("virtual_devices[%d].metrics.%s: %w", i, metric, err)
+			}
+			for _, name := range e.Vars() {
+				if _, ok := v.Inputs[name]; !ok {
+					return fmt.Errorf("virtual_devices[%d].metrics.%s: unknown input %s", i, metric, name)
+				}
+			}
+		}
+	}
+	for i := range c.Bridge.Inputs {
+		in := &c.Bridge.Inputs[i]
+		if in.Name == "" || in.Topic == "" || in.Decoder == "" {
+			return fmt.Errorf("bridge.inputs[%d] requires name, topic and decoder", i)
+		}
+		if in.Encoding == "" {
+			in.Encoding = "raw"
+		}
+		switch in.Encoding {
+		case "raw", "hex", "base64":
+		default:
+			return fmt.Errorf("bridge.inputs[%d].encoding must be raw, hex or base64", i)
+		}
+		for field, cnt := range in.Counters {
+			if cnt.Width == 0 {
+				cnt.Width = 32
+				in.Counters[field] = cnt
+			}
+			if err := cnt.Spec().Validate(); err != nil {
+				return fmt.Errorf("bridge.inputs[%d].counters.%s: %w", i, field, err)
+			}
+		}
+		for field, cal := range in.Calibration {
+			if err := cal.Channel().Validate(); err != nil {
+				return fmt.Errorf("bridge.inputs[%d].calibration.%s: %w", i, field, err)
+			}
+		}
+	}
+	if c.Bridge.Local.Enabled {
+		if err := c.validateLocalBridge(); err != nil {
+			return err
+		}
+	}
+	s := c.Supervision
+	if s.InitialBackoff <= 0 || s.MaxBackoff < s.InitialBackoff {
+		return fmt.Errorf("supervision.initial_backoff must be positive and not exceed max_backoff")
+	}
+	if s.MaxRestarts < 0 || s.Window <= 0 || s.Quarantine < 0 || s.FailureThreshold < 0 {
+		return fmt.Errorf("supervision limits must not be negative and window must be positive")
+	}
+	if c.Bootstrap.Enabled && (c.Bootstrap.Topic == "" || c.Bootstrap.Timeout <= 0) {
+		return fmt.Errorf("bootstrap.topic and a positive bootstrap.timeout are required when bootstrap is enabled")
+	}
+	if w := c.Workloads; w.Enabled {
+		switch {
+		case w.Group == "" || strings.ContainsAny(w.Group, "/+#"):
+			return fmt.Errorf("workloads.group is required and must not contain /, + or #")
+		case w.Topic == "":
+			return fmt.Errorf("workloads.topic is required")
+		case w.Concurrency < 1:
+			return fmt.Errorf("workloads.concurrency must be at least 1")
+		case w.Timeout <= 0:
+			return fmt.Errorf("workloads.timeout must be positive")
+		case c.Power.Mode == "duty_cycle":
+			return fmt.Errorf("workloads require power.mode always_on")
+		}
+	}
+	if c.State.Dir == "" {
+		return fmt.Errorf("state.dir is required")
+	}
+	if c.State.CheckpointInterval < 0 {
+		return fmt.Errorf("state.checkpoint_interval must not be negative")
+	}
+	switch c.Power.Mode {
+	case "always_on":
+	case "duty_cycle":
+		if c.Power.WakeInterval <= 0 {
+			return fmt.Errorf("power.wake_interval must be positive in duty_cycle mode")
+		}
+		if names := c.dutyCycleConflicts(); len(names) > 0 {
+			return fmt.Errorf("power.mode duty_cycle does not support %s", strings.Join(names, ", "))
+		}
+	default:
+		return fmt.Errorf("power.mode must be always_on or duty_cycle")
+	}
+	return nil
+}
+
+// dutyCycleConflicts lists the enabled settings that need the collector
+// running or connected between wake windows
+func (c *Config) dutyCycleConflicts() []string {
+	settings := []struct {
+		name    string
+		enabled bool
+	}{
+		{"buffer", c.Buffer.Enabled},
+		{"acks", c.Acks.Enabled},
+		{"outputs", len(c.Outputs) > 0},
+		{"https_fallback", c.Fallback.Enabled},
+		{"parquet_export", c.Export.Enabled},
+		{"notifications", c.Notify.Enabled},
+		{"uploads", c.Uploads.Enabled},
+		{"update", c.Update.Enabled},
+		{"local_api", c.LocalAPI.Enabled},
+		{"bridge.inputs", len(c.Bridge.Inputs) > 0},
+		{"bridge.local", c.Bridge.Local.Enabled},
+		{"virtual_devices", len(c.Virtual) > 0},
+		{"gpio", c.GPIO.Enabled},
+		{"inventory", c.Inventory.Enabled},
+		{"drift", c.Drift.Enabled},
+		{"compliance", c.Compliance.Enabled},
+		{"flows", c.Flows.Enabled},
+		{"speedtest", c.Speedtest.Enabled},
+		{"data_usage", c.DataUsage.Enabled},
+		{"captive_portal", c.Portal.Enabled},
+		{"uplink", c.Uplink.Enabled},
+		{"off_peak", c.OffPeak.Enabled},
+		{"budget", c.Budget.Enabled},
+		{"display", c.Display.Enabled},
+		{"kiosk", c.Kiosk.Enabled},
+		{"peripherals", c.Peripherals.Enabled},
+		{"backpressure", c.Backpressure.Enabled},
+	}
+	var names []string
+	for _, s := range settings {
+		if s.enabled {
+			names = append(names, s.name)
+		}
+	}
+	return names
+}
+
+// validateLocalBridge checks the local broker and topics and sets the
+// default data type. Records cannot be sent as the types the collector
+// reports its own state with
+func (c *Config) validateLocalBridge() error {
+	l := &c.Bridge.Local
+	broker, err := url.Parse(l.Broker)
+	switch {
+	case err != nil || broker.Host == "":
+		return fmt.Errorf("bridge.local.broker must be a broker URL")
+	case l.Broker == c.MQTT.Broker:
+		return fmt.Errorf("bridge.local.broker must not be the upstream broker")
+	case l.MaxPayload <= 0:
+		return fmt.Errorf("bridge.local.max_payload must be positive")
+	case len(l.Topics) == 0:
+		return fmt.Errorf("bridge.local.topics must not be empty")
+	}
+	for i := range l.Topics {
+		t := &l.Topics[i]
+		if t.Type == "" {
+			t.Type = "app"
+		}
+		switch {
+		case t.Filter == "":
+			return fmt.Errorf("bridge.local.topics[%d].filter is required", i)
+		case t.QoS > 2:
+			return fmt.Errorf("bridge.local.topics[%d].qos must be 0, 1 or 2", i)
+		case topics.Level(t.Type) != t.Type:
+			return fmt.Errorf("bridge.local.topics[%d].type must be a single topic level", i)
+		case t.Type == "heartbeat" || t.Type == "status" || t.Type == "echo":
+			return fmt.Errorf("bridge.local.topics[%d].type cannot be %s", i, t.Type)
+		}
+	}
+	return nil
+}
+
+// validateGPIO checks the outputs and rules and sets the default longest
+// hold. Rules cannot react to GPIO events, so they cannot loop
+func (c *Config) validateGPIO() error {
+	g := &c.GPIO
+	if g.Topic == "" {
+		return fmt.Errorf("gpio.topic is required")
+	}
+	outputs := make(map[string]*GPIOOutput, len(g.Outputs))
+	for i := range g.Outputs {
+		o := &g.Outputs[i]
+		if o.Driver == "" {
+			o.Driver = "sysfs"
+		}
+		if o.MaxHold == 0 {
+			o.MaxHold = 5 * time.Minute
+		}
+		if o.CycleOff == 0 {
+			o.CycleOff = 10 * time.Second
+		}
+		switch {
+		case o.Name == "":
+			return fmt.Errorf("gpio.outputs[%d].name is required", i)
+		case outputs[o.Name] != nil:
+			return fmt.Errorf("gpio.outputs: duplicate name %q", o.Name)
+		case o.Initial != "" && o.Initial != "on" && o.Initial != "off":
+			return fmt.Errorf("gpio output %s: initial must be on, off or empty", o.Name)
+		case o.MinInterval < 0 || o.MaxOn < 0 || o.MaxHold < 0 || o.CycleOff < 0:
+			return fmt.Errorf("gpio output %s: durations must not be negative", o.Name)
+		case o.CycleOff > o.MaxHold:
+			return fmt.Errorf("gpio output %s: cycle_off exceeds max_hold", o.Name)
+		}
+		if err := o.validateDriver(); err != nil {
+			return err
+		}
+		outputs[o.Name] = o
+	}
+	for _, o := range g.Outputs {
+		for _, name := range o.Exclusive {
+			if outputs[name] == nil || name == o.Name {
+				return fmt.Errorf("gpio output %s: exclusive output %q is not another output", o.Name, name)
+			}
+		}
+	}
+	for i, r := range g.Rules {
+		o := outputs[r.Output]
+		switch {
+		case r.Name == "":
+			return fmt.Errorf("gpio.rules[%d].name is required", i)
+		case r.Event == "":
+			return fmt.Errorf("gpio rule %s: event is required", r.Name)
+		case strings.HasPrefix(r.Event, "gpio"):
+			return fmt.Errorf("gpio rule %s: rules cannot react to gpio events", r.Name)
+		case o == nil:
+			return fmt.Errorf("gpio rule %s: unknown output %q", r.Name, r.Output)
+		case r.Action != "on" && r.Action != "off" && r.Action != "cycle":
+			return fmt.Errorf("gpio rule %s: action must be on, off or cycle", r.Name)
+		case r.Duration < 0 || r.Duration > o.MaxHold:
+			return fmt.Errorf("gpio rule %s: duration must be between 0 and the output's max_hold", r.Name)
+		case r.Action == "on" && o.MaxOn > 0 && r.Duration > o.MaxOn:
+			return fmt.Errorf("gpio rule %s: duration exceeds the output's max_on", r.Name)
+		}
+	}
+	return nil
+}
+
+// validateDriver checks the settings of an output's driver and sets the
+// SNMP defaults
+func (o *GPIOOutput) validateDriver() error {
+	switch o.Driver {
+	case "poe", "pdu", "snmp":
+		s := &o.SNMP
+		if s.Community == "" {
+			s.Community = "private"
+		}
+		if s.Version == "" {
+			s.Version = "2c"
+		}
+		if s.Timeout == 0 {
+			s.Timeout = 5 * time.Second
+		}
+		switch {
+		case s.Target == "":
+			return fmt.Errorf("gpio output %s: snmp.target is required", o.Name)
+		case s.Version != "1" && s.Version != "2c":
+			return fmt.Errorf("gpio output %s: snmp.version must be 1 or 2c", o.Name)
+		case s.Timeout < 0:
+			return fmt.Errorf("gpio output %s: snmp.timeout must not be negative", o.Name)
+		}
+	}
+
+	switch o.Driver {
+	case "sysfs":
+		switch {
+		case (o.Line == nil) == (o.Path == ""):
+			return fmt.Errorf("gpio output %s needs one of line and path", o.Name)
+		case o.Line != nil && *o.Line < 0:
+			return fmt.Errorf("gpio output %s: line must not be negative", o.Name)
+		case o.Path != "" && !filepath.IsAbs(o.Path):
+			return fmt.Errorf("gpio output %s: path must be absolute", o.Name)
+		}
+	case "command":
+		if len(o.On) == 0 || len(o.Off) == 0 {
+			return fmt.Errorf("gpio output %s needs on and off commands", o.Name)
+		}
+	case "poe":
+		if o.Group == 0 {
+			o.Group = 1
+		}
+		if o.Port < 1 || o.Group < 1 {
+			return fmt.Errorf("gpio output %s: port and group must be positive", o.Name)
+		}
+	case "pdu":
+		switch {
+		case gpio.PDUs[o.PDU].OID == "":
+			return fmt.Errorf("gpio output %s: pdu must be apc or raritan", o.Name)
+		case o.Outlet < 1:
+			return fmt.Errorf("gpio output %s: outlet must be positive", o.Name)
+		}
+	case "snmp":
+		switch {
+		case o.SNMP.OID == "":
+			return fmt.Errorf("gpio output %s: snmp.oid is required", o.Name)
+		case o.SNMP.OnValue == o.SNMP.OffValue:
+			return fmt.Errorf("gpio output %s: snmp.on_value and off_value must differ", o.Name)
+		}
+	case "usb":
+		switch {
+		case (o.Hub == "") == (o.Path == ""):
+			return fmt.Errorf("gpio output %s needs one of hub and path", o.Name)
+		case o.Hub != "" && o.Port < 1:
+			return fmt.Errorf("gpio output %s: port must be positive", o.Name)
+		case o.Path != "" && !filepath.IsAbs(o.Path):
+			return fmt.Errorf("gpio output %s: path must be absolute", o.Name)
+		}
+	default:
+		return fmt.Errorf("gpio output %s: driver must be sysfs, command, poe, pdu, snmp or usb", o.Name)
+	}
+	return nil
+}
+
+// applyProfile overrides settings according to the selected profile
+func (c *Config) applyProfile() error {
+	switch c.Profile {
+	case "", "default":
+	case "minimal":
+		// Tuned for Pi 3 / ARM32 devices to stay under ~25MB RSS
+		c.Collection.Metrics.CPUInfo = false
+		c.Collection.Metrics.DiskIO = false
+		c.Collection.Metrics.NetworkPerInterface = false
+		if c.Runtime.GCPercent == 0 {
+			c.Runtime.GCPercent = 50
+		}
+		if c.Runtime.MemoryLimit == 0 {
+			c.Runtime.MemoryLimit = 20 << 20
+		}
+		if c.Runtime.MaxProcs == 0 {
+			c.Runtime.MaxProcs = 1
+		}
+
+		// Smaller in-memory buffers and queues, unless the file set them
+		shrink(&c.Buffer.Memory.MaxRecords, 10000, 1000)
+		shrink(&c.Buffer.Memory.MaxBytes, 16<<20, 2<<20)
+		shrink(&c.Buffer.SegmentBytes, 4<<20, 1<<20)
+		shrink(&c.Fallback.BatchSize, 100, 25)
+		shrink(&c.Fallback.MaxBuffer, 10000, 1000)
+		shrink(&c.OffPeak.MaxRecords, 10000, 1000)
+		shrink(&c.Acks.MaxPending, 10000, 1000)
+		for i := range c.Outputs {
+			o := &c.Outputs[i]
+			shrink(&o.Kafka.MaxBuffered, 0, 1000)
+			shrink(&o.NATS.MaxPending, 0, 1000)
+			shrink(&o.AMQP.MaxPending, 0, 1000)
+			shrink(&o.Webhook.MaxPending, 0, 100)
+		}
+	default:
+		return fmt.Errorf("unknown profile %q", c.Profile)
+	}
+	return nil
+}
+
+// shrink lowers a setting to small when it still holds its default def
+func shrink[T comparable](v *T, def, small T) {
+	if *v == def {
+		*v = small
+	}
+}
+
+// generateDeviceID creates a unique device identifier, preferring a stable
+// hardware-derived ID when requested and falling back to the hostname
+func generateDeviceID(source string) string {
+	if source == "hardware" {
+		if id := hwinfo.Detect().StableID(); id != "" {
+			return id
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Sprintf("device-%d", time.Now().Unix())
+	}
+	return hostname
+}
+
+// validUSBID reports whether id is a vendor:product pair of four hex digits
+// each, as sysfs reports them
+func validUSBID(id string) bool {
+	vendor, product, ok := strings.Cut(id, ":")
+	if !ok || len(vendor) != 4 || len(product) != 4 {
+		return false
+	}
+	_, err := strconv.ParseUint(vendor+product, 16, 32)
+	return err == nil
+}
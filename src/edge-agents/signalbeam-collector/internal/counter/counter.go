@@ -0,0 +1,134 @@
+package counter
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Spec describes a hardware counter register
+type Spec struct {
+	Width int // Register width in bits: 16, 32 or 64
+
+	// MaxDelta is the largest increase plausible between two readings. A
+	// decrease whose wrapped delta exceeds it is treated as a device reset
+	// rather than a wraparound. Defaults to half the counter range
+	MaxDelta float64
+}
+
+// Validate checks the counter width and delta
+func (s Spec) Validate() error {
+	switch s.Width {
+	case 16, 32, 64:
+	default:
+		return fmt.Errorf("width must be 16, 32 or 64")
+	}
+	if s.MaxDelta < 0 {
+		return fmt.Errorf("max_delta must not be negative")
+	}
+	return nil
+}
+
+// modulus returns the counter range, 2^width
+func (s Spec) modulus() float64 {
+	return math.Exp2(float64(s.Width))
+}
+
+// Reading is the corrected value of a counter
+type Reading struct {
+	Raw    float64 // Register value as read
+	Total  float64 // Monotonic total across wraps and resets
+	Delta  float64 // Increase since the previous reading
+	Wraps  int     // Wraparounds seen since the counter was first read
+	Resets int     // Device resets seen since the counter was first read
+	Event  string  // "wrap" or "reset" when this reading crossed one
+}
+
+// state is the tracked history of one counter
+type state struct {
+	last   float64
+	total  float64
+	wraps  int
+	resets int
+}
+
+// Baseline is the tracked history of one counter as kept across restarts
+type Baseline struct {
+	Last   float64 `json:"last"`
+	Total  float64 `json:"total"`
+	Wraps  int     `json:"wraps,omitempty"`
+	Resets int     `json:"resets,omitempty"`
+}
+
+// Tracker turns raw counter readings into monotonic totals
+type Tracker struct {
+	mu       sync.Mutex
+	counters map[string]*state
+}
+
+// NewTracker creates an empty tracker
+func NewTracker() *Tracker {
+	return &Tracker{counters: make(map[string]*state)}
+}
+
+// Update records a raw reading of the counter identified by key. The first
+// reading starts the total at the raw value
+func (t *Tracker) Update(key string, spec Spec, raw float64) Reading {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, ok := t.counters[key]
+	if !ok {
+		t.counters[key] = &state{last: raw, total: raw}
+		return Reading{Raw: raw, Total: raw}
+	}
+
+	r := Reading{Raw: raw}
+	switch {
+	case raw >= st.last:
+		r.Delta = raw - st.last
+	default:
+		maxDelta := spec.MaxDelta
+		if maxDelta == 0 {
+			maxDelta = spec.modulus() / 2
+		}
+		if wrapped := spec.modulus() - st.last + raw; wrapped <= maxDelta {
+			r.Delta = wrapped
+			r.Event = "wrap"
+			st.wraps++
+		} else {
+			// The device restarted counting from zero
+			r.Delta = raw
+			r.Event = "reset"
+			st.resets++
+		}
+	}
+
+	st.last = raw
+	st.total += r.Delta
+	r.Total = st.total
+	r.Wraps = st.wraps
+	r.Resets = st.resets
+	return r
+}
+
+// Snapshot returns the history of every counter by key
+func (t *Tracker) Snapshot() map[string]Baseline {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]Baseline, len(t.counters))
+	for key, st := range t.counters {
+		out[key] = Baseline{Last: st.last, Total: st.total, Wraps: st.wraps, Resets: st.resets}
+	}
+	return out
+}
+
+// Restore continues the counters of a snapshot, so the first reading after
+// a restart adds its delta to the total instead of starting over
+func (t *Tracker) Restore(baselines map[string]Baseline) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, b := range baselines {
+		t.counters[key] = &state{last: b.Last, total: b.Total, wraps: b.Wraps, resets: b.Resets}
+	}
+}
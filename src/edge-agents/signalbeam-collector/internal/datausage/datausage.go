@@ -0,0 +1,174 @@
+// Package datausage accounts the traffic of network interfaces over billing
+// cycles, from the interface counters, and keeps the totals across restarts
+// and reboots
+package datausage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Counters are bytes sent and received
+type Counters struct {
+	Sent uint64 `json:"sent"`
+	Recv uint64 `json:"recv"`
+}
+
+// Total returns the bytes in both directions
+func (c Counters) Total() uint64 {
+	return c.Sent + c.Recv
+}
+
+// Map returns the counters as telemetry fields
+func (c Counters) Map() map[string]interface{} {
+	return map[string]interface{}{"sent": c.Sent, "recv": c.Recv}
+}
+
+// iface is the usage of one interface in the current cycle
+type iface struct {
+	Cycle Counters `json:"cycle"`
+	Last  Counters `json:"last"` // Last counter readings
+}
+
+// state is what the meter keeps on disk
+type state struct {
+	CycleStart time.Time         `json:"cycle_start"`
+	Interfaces map[string]*iface `json:"interfaces"`
+	Notified   float64           `json:"notified"` // Highest share of the quota reported this cycle
+}
+
+// Snapshot is the usage of the current cycle
+type Snapshot struct {
+	CycleStart time.Time
+	CycleEnd   time.Time
+	Total      Counters
+	Interfaces map[string]Counters
+}
+
+// Meter accounts interface traffic
+type Meter struct {
+	path     string
+	resetDay int
+
+	mu    sync.Mutex
+	state state
+}
+
+// Open loads the usage kept at path. Cycles start at midnight on resetDay,
+// local time
+func Open(path string, resetDay int) (*Meter, error) {
+	m := &Meter{path: path, resetDay: resetDay}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &m.state); err != nil {
+			return nil, err
+		}
+	}
+	if m.state.Interfaces == nil {
+		m.state.Interfaces = make(map[string]*iface)
+	}
+	return m, nil
+}
+
+// CycleStart returns the start of the billing cycle holding t
+func CycleStart(t time.Time, resetDay int) time.Time {
+	start := time.Date(t.Year(), t.Month(), resetDay, 0, 0, 0, 0, t.Location())
+	if start.After(t) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// Update adds the traffic since the last readings and saves the usage. A
+// reading below the last one means the counter was reset, by a reboot or a
+// recreated interface, and counts from zero
+func (m *Meter) Update(readings map[string]Counters, now time.Time) error {
+	m.mu.Lock()
+	m.roll(now)
+	for name, r := range readings {
+		u := m.state.Interfaces[name]
+		if u == nil {
+			// The first reading is the baseline; earlier traffic is unknown
+			m.state.Interfaces[name] = &iface{Last: r}
+			continue
+		}
+		u.Cycle.Sent += delta(u.Last.Sent, r.Sent)
+		u.Cycle.Recv += delta(u.Last.Recv, r.Recv)
+		u.Last = r
+	}
+	data, err := json.Marshal(m.state)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(m.path+".tmp", m.path)
+}
+
+// roll starts a new cycle once the reset day has passed. The caller must
+// hold m.mu
+func (m *Meter) roll(now time.Time) {
+	start := CycleStart(now, m.resetDay)
+	if m.state.CycleStart.Equal(start) {
+		return
+	}
+	m.state.CycleStart = start
+	m.state.Notified = 0
+	for _, u := range m.state.Interfaces {
+		u.Cycle = Counters{}
+	}
+}
+
+// Snapshot returns the usage of the current cycle
+func (m *Meter) Snapshot(now time.Time) Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.roll(now)
+
+	s := Snapshot{
+		CycleStart: m.state.CycleStart,
+		CycleEnd:   CycleStart(m.state.CycleStart.AddDate(0, 1, 0), m.resetDay),
+		Interfaces: make(map[string]Counters, len(m.state.Interfaces)),
+	}
+	for name, u := range m.state.Interfaces {
+		s.Interfaces[name] = u.Cycle
+		s.Total.Sent += u.Cycle.Sent
+		s.Total.Recv += u.Cycle.Recv
+	}
+	return s
+}
+
+// Notify records that a share of the quota was reported, returning false
+// when that share or a higher one already was this cycle. The share is
+// saved with the next update
+func (m *Meter) Notify(share float64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if share <= m.state.Notified {
+		return false
+	}
+	m.state.Notified = share
+	return true
+}
+
+// delta returns the growth of a counter
+func delta(last, now uint64) uint64 {
+	if now < last {
+		return now
+	}
+	return now - last
+}
@@ -0,0 +1,155 @@
+package deadband
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/units"
+)
+
+// Rule suppresses values at a path pattern until they move by more than the
+// deadband or MaxInterval has elapsed since they were last published
+type Rule struct {
+	Type        string
+	Path        string  // Dotted pattern, "*" matches one segment
+	Absolute    float64 // Minimum absolute change
+	Percent     float64 // Minimum change relative to the last published value
+	MaxInterval time.Duration
+}
+
+// published is the last value sent for a series
+type published struct {
+	value float64
+	at    time.Time
+}
+
+// Last is the last value published for a series as kept across restarts
+type Last struct {
+	Value float64   `json:"value"`
+	At    time.Time `json:"at"`
+}
+
+// Filter applies report-by-exception rules to telemetry payloads
+type Filter struct {
+	rules []Rule
+
+	mu   sync.Mutex
+	last map[string]published
+}
+
+// New creates a filter for the given rules
+func New(rules []Rule) *Filter {
+	return &Filter{rules: rules, last: make(map[string]published)}
+}
+
+// Apply removes values that have not changed meaningfully from data. It
+// returns false when every numeric value was suppressed, meaning the
+// message need not be published at all. scope separates series with the
+// same path, e.g. the bridge input name
+func (f *Filter) Apply(dataType, scope string, data map[string]interface{}, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	numeric, suppressed := 0, 0
+	var removals []func()
+
+	units.Walk(data, nil, func(path []string, parent map[string]interface{}, key string, v interface{}) {
+		value, ok := units.ToFloat(v)
+		if !ok {
+			return
+		}
+		numeric++
+
+		rule, ok := f.match(dataType, path)
+		if !ok {
+			return
+		}
+
+		series := dataType + "|" + scope + "|" + strings.Join(path, ".")
+		if last, seen := f.last[series]; seen && !rule.exceeded(last, value, now) {
+			suppressed++
+			removals = append(removals, func() { delete(parent, key) })
+			return
+		}
+		f.last[series] = published{value: value, at: now}
+	})
+
+	if numeric > 0 && suppressed == numeric {
+		return false
+	}
+	for _, remove := range removals {
+		remove()
+	}
+	if len(removals) > 0 {
+		prune(data)
+	}
+	return true
+}
+
+// Snapshot returns the last published value of every series
+func (f *Filter) Snapshot() map[string]Last {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]Last, len(f.last))
+	for series, p := range f.last {
+		out[series] = Last{Value: p.value, At: p.at}
+	}
+	return out
+}
+
+// Restore takes the last published values of a snapshot, so values that
+// did not move while the collector restarted stay suppressed
+func (f *Filter) Restore(last map[string]Last) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for series, l := range last {
+		f.last[series] = published{value: l.Value, at: l.At}
+	}
+}
+
+// match returns the first rule for a path
+func (f *Filter) match(dataType string, path []string) (Rule, bool) {
+	for _, r := range f.rules {
+		if r.Type == dataType && units.Match(r.Path, path) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// exceeded reports whether value should be published
+func (r Rule) exceeded(last published, value float64, now time.Time) bool {
+	if r.MaxInterval > 0 && now.Sub(last.at) >= r.MaxInterval {
+		return true
+	}
+
+	delta := math.Abs(value - last.value)
+	if r.Absolute > 0 && delta > r.Absolute {
+		return true
+	}
+	if r.Percent > 0 {
+		if last.value == 0 {
+			return delta > 0
+		}
+		if delta/math.Abs(last.value)*100 > r.Percent {
+			return true
+		}
+	}
+	return r.Absolute == 0 && r.Percent == 0 && delta > 0
+}
+
+// prune removes maps left empty by suppression
+func prune(m map[string]interface{}) {
+	for k, v := range m {
+		child, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		prune(child)
+		if len(child) == 0 {
+			delete(m, k)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package decoder
+
+import "fmt"
+
+// bthomeObject describes one BTHome v2 object type
+type bthomeObject struct {
+	name   string
+	size   int
+	factor float64 // Raw value times factor is the value
+	signed bool
+}
+
+// bthomeObjects maps BTHome v2 object IDs to their layout. Binary sensors
+// are reported as 0 or 1
+var bthomeObjects = map[byte]bthomeObject{
+	0x00: {name: "packet_id", size: 1, factor: 1},
+	0x01: {name: "battery", size: 1, factor: 1},
+	0x02: {name: "temperature", size: 2, factor: 0.01, signed: true},
+	0x03: {name: "humidity", size: 2, factor: 0.01},
+	0x04: {name: "pressure", size: 3, factor: 0.01},
+	0x05: {name: "illuminance", size: 3, factor: 0.01},
+	0x06: {name: "mass_kg", size: 2, factor: 0.01},
+	0x07: {name: "mass_lb", size: 2, factor: 0.01},
+	0x08: {name: "dewpoint", size: 2, factor: 0.01, signed: true},
+	0x09: {name: "count", size: 1, factor: 1},
+	0x0A: {name: "energy", size: 3, factor: 0.001},
+	0x0B: {name: "power", size: 3, factor: 0.01},
+	0x0C: {name: "voltage", size: 2, factor: 0.001},
+	0x0D: {name: "pm2_5", size: 2, factor: 1},
+	0x0E: {name: "pm10", size: 2, factor: 1},
+	0x0F: {name: "generic_boolean", size: 1, factor: 1},
+	0x10: {name: "power_on", size: 1, factor: 1},
+	0x11: {name: "opening", size: 1, factor: 1},
+	0x12: {name: "co2", size: 2, factor: 1},
+	0x13: {name: "tvoc", size: 2, factor: 1},
+	0x14: {name: "moisture", size: 2, factor: 0.01},
+	0x15: {name: "battery_low", size: 1, factor: 1},
+	0x16: {name: "battery_charging", size: 1, factor: 1},
+	0x17: {name: "carbon_monoxide", size: 1, factor: 1},
+	0x18: {name: "cold", size: 1, factor: 1},
+	0x19: {name: "connectivity", size: 1, factor: 1},
+	0x1A: {name: "door", size: 1, factor: 1},
+	0x1B: {name: "garage_door", size: 1, factor: 1},
+	0x1C: {name: "gas", size: 1, factor: 1},
+	0x1D: {name: "heat", size: 1, factor: 1},
+	0x1E: {name: "light", size: 1, factor: 1},
+	0x1F: {name: "lock", size: 1, factor: 1},
+	0x20: {name: "moisture_detected", size: 1, factor: 1},
+	0x21: {name: "motion", size: 1, factor: 1},
+	0x22: {name: "moving", size: 1, factor: 1},
+	0x23: {name: "occupancy", size: 1, factor: 1},
+	0x24: {name: "plug", size: 1, factor: 1},
+	0x25: {name: "presence", size: 1, factor: 1},
+	0x26: {name: "problem", size: 1, factor: 1},
+	0x27: {name: "running", size: 1, factor: 1},
+	0x28: {name: "safety", size: 1, factor: 1},
+	0x29: {name: "smoke", size: 1, factor: 1},
+	0x2A: {name: "sound", size: 1, factor: 1},
+	0x2B: {name: "tamper", size: 1, factor: 1},
+	0x2C: {name: "vibration", size: 1, factor: 1},
+	0x2D: {name: "window", size: 1, factor: 1},
+	0x2E: {name: "humidity", size: 1, factor: 1},
+	0x2F: {name: "moisture", size: 1, factor: 1},
+	0x3A: {name: "button", size: 1, factor: 1},
+	0x3D: {name: "count", size: 2, factor: 1},
+	0x3E: {name: "count", size: 4, factor: 1},
+	0x3F: {name: "rotation", size: 2, factor: 0.1, signed: true},
+	0x40: {name: "distance_mm", size: 2, factor: 1},
+	0x41: {name: "distance_m", size: 2, factor: 0.1},
+	0x43: {name: "current", size: 2, factor: 0.001},
+	0x45: {name: "temperature", size: 2, factor: 0.1, signed: true},
+	0x46: {name: "uv_index", size: 1, factor: 0.1},
+	0x4A: {name: "voltage", size: 2, factor: 0.1},
+}
+
+// BTHomeV2 decodes unencrypted BTHome v2 BLE advertisements: the service
+// data of UUID 0xFCD2 after the UUID, starting with the device information
+// byte. A type that occurs more than once is numbered from the second
+// occurrence on, e.g. temperature_2
+type BTHomeV2 struct{}
+
+// Decode implements Decoder
+func (BTHomeV2) Decode(payload []byte) (map[string]interface{}, error) {
+	if len(payload) < 1 {
+		return nil, fmt.Errorf("payload is empty")
+	}
+	info := payload[0]
+	if version := info >> 5; version != 2 {
+		return nil, fmt.Errorf("unsupported BTHome version %d", version)
+	}
+	if info&0x01 != 0 {
+		return nil, fmt.Errorf("encrypted BTHome advertisements are not supported")
+	}
+
+	fields := make(map[string]interface{})
+	seen := make(map[string]int)
+	for i := 1; i < len(payload); {
+		id := payload[i]
+		obj, ok := bthomeObjects[id]
+		if !ok {
+			// The size of an unknown object is unknown, so nothing after it
+			// can be read
+			return nil, fmt.Errorf("unknown object id 0x%02x at byte %d", id, i)
+		}
+		i++
+		if i+obj.size > len(payload) {
+			return nil, fmt.Errorf("truncated %s value at byte %d", obj.name, i)
+		}
+
+		var raw uint64
+		for b := obj.size - 1; b >= 0; b-- {
+			raw = raw<<8 | uint64(payload[i+b])
+		}
+		i += obj.size
+		v := float64(raw)
+		if obj.signed && raw&(1<<(8*obj.size-1)) != 0 {
+			v -= float64(uint64(1) << (8 * obj.size))
+		}
+
+		name := obj.name
+		if seen[name]++; seen[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, seen[name])
+		}
+		fields[name] = v * obj.factor
+	}
+	return fields, nil
+}
@@ -0,0 +1,98 @@
+package decoder
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// cayenneType describes one Cayenne LPP data type
+type cayenneType struct {
+	name   string
+	size   int
+	div    float64 // Raw value divisor (resolution is 1/div)
+	signed bool
+	axes   int // Values per reading (3 for accelerometer, gyrometer and GPS)
+}
+
+// cayenneTypes maps LPP type IDs to their layout
+var cayenneTypes = map[byte]cayenneType{
+	0:   {name: "digital_input", size: 1, div: 1},
+	1:   {name: "digital_output", size: 1, div: 1},
+	2:   {name: "analog_input", size: 2, div: 100, signed: true},
+	3:   {name: "analog_output", size: 2, div: 100, signed: true},
+	101: {name: "illuminance", size: 2, div: 1},
+	102: {name: "presence", size: 1, div: 1},
+	103: {name: "temperature", size: 2, div: 10, signed: true},
+	104: {name: "humidity", size: 1, div: 2},
+	113: {name: "accelerometer", size: 2, div: 1000, signed: true, axes: 3},
+	115: {name: "barometer", size: 2, div: 10},
+	134: {name: "gyrometer", size: 2, div: 100, signed: true, axes: 3},
+	136: {name: "gps", size: 3, div: 10000, signed: true, axes: 3}, // Altitude resolution is 0.01
+}
+
+// CayenneLPP decodes the Cayenne Low Power Payload format. Fields are named
+// {type}_{channel}, e.g. temperature_1
+type CayenneLPP struct{}
+
+// Decode implements Decoder
+func (CayenneLPP) Decode(payload []byte) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+
+	for i := 0; i < len(payload); {
+		if i+2 > len(payload) {
+			return nil, fmt.Errorf("truncated header at byte %d", i)
+		}
+		channel, typeID := payload[i], payload[i+1]
+		i += 2
+
+		t, ok := cayenneTypes[typeID]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %d at byte %d", typeID, i-1)
+		}
+
+		axes := t.axes
+		if axes == 0 {
+			axes = 1
+		}
+		if i+t.size*axes > len(payload) {
+			return nil, fmt.Errorf("truncated %s value at byte %d", t.name, i)
+		}
+
+		key := t.name + "_" + strconv.Itoa(int(channel))
+		raw := make([]int64, axes)
+		values := make([]float64, axes)
+		for a := range values {
+			raw[a] = readInt(payload[i:i+t.size], t.signed)
+			values[a] = float64(raw[a]) / t.div
+			i += t.size
+		}
+
+		switch {
+		case typeID == 136:
+			fields[key] = map[string]interface{}{
+				"latitude":  values[0],
+				"longitude": values[1],
+				"altitude":  float64(raw[2]) / 100,
+			}
+		case axes == 3:
+			fields[key] = map[string]interface{}{"x": values[0], "y": values[1], "z": values[2]}
+		default:
+			fields[key] = values[0]
+		}
+	}
+
+	return fields, nil
+}
+
+// readInt reads a big-endian integer of 1 to 4 bytes
+func readInt(b []byte, signed bool) int64 {
+	var v uint64
+	for _, x := range b {
+		v = v<<8 | uint64(x)
+	}
+	if signed {
+		shift := 64 - 8*len(b)
+		return int64(v<<shift) >> shift
+	}
+	return int64(v)
+}
@@ -0,0 +1,201 @@
+package decoder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// Decoder turns a raw sensor payload into named fields
+type Decoder interface {
+	Decode(payload []byte) (map[string]interface{}, error)
+}
+
+// validName restricts decoder names so they are safe to use as file names
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// Registry holds the built-in decoders and those installed at runtime
+type Registry struct {
+	mu       sync.RWMutex
+	dir      string
+	limits   WASMLimits
+	runtime  wazero.Runtime // Created with the first WASM decoder
+	decoders map[string]Decoder
+}
+
+// NewRegistry creates a registry with the built-in decoders. Installed
+// layout and WASM decoders are persisted in dir; WASM decoders run within
+// limits
+func NewRegistry(dir string, limits WASMLimits) *Registry {
+	return &Registry{
+		dir:    dir,
+		limits: limits,
+		decoders: map[string]Decoder{
+			"bthome-v2":   BTHomeV2{},
+			"cayenne-lpp": CayenneLPP{},
+			"ibeacon":     IBeacon{},
+			"ruuvi-v5":    RuuviV5{},
+		},
+	}
+}
+
+// installed reports whether a decoder was installed rather than built in
+func installed(d Decoder) bool {
+	switch d.(type) {
+	case *Layout, *WASM:
+		return true
+	}
+	return false
+}
+
+// fileExt returns the extension a definition is persisted with
+func fileExt(def []byte) string {
+	if isWASM(def) {
+		return ".wasm"
+	}
+	return ".yaml"
+}
+
+// Get returns the decoder registered under name
+func (r *Registry) Get(name string) (Decoder, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, ok := r.decoders[name]
+	return d, ok
+}
+
+// Names returns the registered decoder names in order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.decoders))
+	for name := range r.decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load registers every decoder previously persisted in the directory
+func (r *Registry) Load() error {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.wasm"} {
+		matches, err := filepath.Glob(filepath.Join(r.dir, pattern))
+		if err != nil {
+			return err
+		}
+		files = append(files, matches...)
+	}
+	for _, file := range files {
+		def, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read decoder %s: %w", file, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if err := r.register(name, def); err != nil {
+			return fmt.Errorf("decoder %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Install registers a layout or WASM decoder and persists it so it
+// survives restarts. A WASM module is told apart by its magic number
+func (r *Registry) Install(name string, def []byte) error {
+	if err := r.register(name, def); err != nil {
+		return err
+	}
+	ext := fileExt(def)
+	if err := os.WriteFile(filepath.Join(r.dir, name+ext), def, 0o600); err != nil {
+		return fmt.Errorf("failed to persist decoder: %w", err)
+	}
+	// A decoder of the other kind under the same name was replaced
+	other := map[string]string{".yaml": ".wasm", ".wasm": ".yaml"}[ext]
+	if err := os.Remove(filepath.Join(r.dir, name+other)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete replaced decoder: %w", err)
+	}
+	return nil
+}
+
+// Remove unregisters an installed decoder. Built-in decoders cannot be removed
+func (r *Registry) Remove(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid decoder name %q", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.decoders[name]
+	if !ok || !installed(d) {
+		return fmt.Errorf("decoder %q is not an installed decoder", name)
+	}
+	delete(r.decoders, name)
+	if w, ok := d.(*WASM); ok {
+		w.close()
+	}
+
+	for _, ext := range []string{".yaml", ".wasm"} {
+		if err := os.Remove(filepath.Join(r.dir, name+ext)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete decoder: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close releases the WASM decoders
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runtime == nil {
+		return nil
+	}
+	return r.runtime.Close(context.Background())
+}
+
+// register parses a layout decoder or compiles a WASM decoder and
+// registers it
+func (r *Registry) register(name string, def []byte) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid decoder name %q", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.decoders[name]
+	if ok && !installed(old) {
+		return fmt.Errorf("cannot replace built-in decoder %q", name)
+	}
+
+	var d Decoder
+	if isWASM(def) {
+		if r.runtime == nil {
+			r.runtime = newWASMRuntime(r.limits)
+		}
+		w, err := compileWASM(r.runtime, def, r.limits.Timeout)
+		if err != nil {
+			return err
+		}
+		d = w
+	} else {
+		layout, err := ParseLayout(def)
+		if err != nil {
+			return err
+		}
+		d = layout
+	}
+
+	r.decoders[name] = d
+	if w, ok := old.(*WASM); ok {
+		w.close()
+	}
+	return nil
+}
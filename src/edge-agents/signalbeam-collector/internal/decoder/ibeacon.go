@@ -0,0 +1,31 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// IBeacon decodes Apple iBeacon advertisements: the manufacturer specific
+// data with or without the company ID 0x004C in front
+type IBeacon struct{}
+
+// Decode implements Decoder
+func (IBeacon) Decode(payload []byte) (map[string]interface{}, error) {
+	if len(payload) >= 2 && payload[0] == 0x4C && payload[1] == 0x00 {
+		payload = payload[2:]
+	}
+	if len(payload) < 23 {
+		return nil, fmt.Errorf("payload too short: %d bytes, want 23", len(payload))
+	}
+	if payload[0] != 0x02 || payload[1] != 0x15 {
+		return nil, fmt.Errorf("not an iBeacon advertisement")
+	}
+
+	u := payload[2:18]
+	return map[string]interface{}{
+		"uuid":     fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16]),
+		"major":    int(binary.BigEndian.Uint16(payload[18:20])),
+		"minor":    int(binary.BigEndian.Uint16(payload[20:22])),
+		"tx_power": int(int8(payload[22])),
+	}, nil
+}
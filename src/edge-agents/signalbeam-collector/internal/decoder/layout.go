@@ -0,0 +1,106 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fieldSizes maps layout field types to their width in bytes
+var fieldSizes = map[string]int{
+	"uint8": 1, "int8": 1,
+	"uint16": 2, "int16": 2,
+	"uint32": 4, "int32": 4,
+	"float32": 4,
+}
+
+// Field is one value in a fixed binary layout
+type Field struct {
+	Name         string  `yaml:"name"`
+	Offset       int     `yaml:"offset"`
+	Type         string  `yaml:"type"` // uint8, int8, uint16, int16, uint32, int32, float32
+	LittleEndian bool    `yaml:"little_endian"`
+	Scale        float64 `yaml:"scale"` // Defaults to 1
+	Add          float64 `yaml:"add"`   // Applied after scaling
+}
+
+// Layout is a declarative decoder for fixed binary payloads, pushed by the
+// Control Plane as YAML or JSON so new sensor models need no agent release:
+//
+//	fields:
+//	  - {name: temperature, offset: 0, type: int16, scale: 0.1}
+//	  - {name: battery, offset: 2, type: uint8}
+type Layout struct {
+	Fields []Field `yaml:"fields"`
+}
+
+// ParseLayout parses and validates a layout definition
+func ParseLayout(def []byte) (*Layout, error) {
+	var l Layout
+	if err := yaml.Unmarshal(def, &l); err != nil {
+		return nil, fmt.Errorf("failed to parse layout: %w", err)
+	}
+	if len(l.Fields) == 0 {
+		return nil, fmt.Errorf("layout has no fields")
+	}
+
+	for i := range l.Fields {
+		f := &l.Fields[i]
+		if f.Name == "" {
+			return nil, fmt.Errorf("fields[%d].name is required", i)
+		}
+		if _, ok := fieldSizes[f.Type]; !ok {
+			return nil, fmt.Errorf("fields[%d].type %q is not supported", i, f.Type)
+		}
+		if f.Offset < 0 {
+			return nil, fmt.Errorf("fields[%d].offset must not be negative", i)
+		}
+		if f.Scale == 0 {
+			f.Scale = 1
+		}
+	}
+
+	return &l, nil
+}
+
+// Decode implements Decoder
+func (l *Layout) Decode(payload []byte) (map[string]interface{}, error) {
+	fields := make(map[string]interface{}, len(l.Fields))
+
+	for _, f := range l.Fields {
+		size := fieldSizes[f.Type]
+		if f.Offset+size > len(payload) {
+			return nil, fmt.Errorf("payload too short for field %s", f.Name)
+		}
+		b := payload[f.Offset : f.Offset+size]
+
+		var order binary.ByteOrder = binary.BigEndian
+		if f.LittleEndian {
+			order = binary.LittleEndian
+		}
+
+		var v float64
+		switch f.Type {
+		case "uint8":
+			v = float64(b[0])
+		case "int8":
+			v = float64(int8(b[0]))
+		case "uint16":
+			v = float64(order.Uint16(b))
+		case "int16":
+			v = float64(int16(order.Uint16(b)))
+		case "uint32":
+			v = float64(order.Uint32(b))
+		case "int32":
+			v = float64(int32(order.Uint32(b)))
+		case "float32":
+			v = float64(math.Float32frombits(order.Uint32(b)))
+		}
+
+		fields[f.Name] = v*f.Scale + f.Add
+	}
+
+	return fields, nil
+}
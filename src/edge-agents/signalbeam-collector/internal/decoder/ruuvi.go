@@ -0,0 +1,58 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// RuuviV5 decodes RuuviTag BLE advertisements in data format 5 (RAWv2).
+// Fields the sensor reports as invalid are omitted
+type RuuviV5 struct{}
+
+// Decode implements Decoder
+func (RuuviV5) Decode(payload []byte) (map[string]interface{}, error) {
+	if len(payload) < 24 {
+		return nil, fmt.Errorf("payload too short: %d bytes, want 24", len(payload))
+	}
+	if payload[0] != 5 {
+		return nil, fmt.Errorf("unsupported data format %d", payload[0])
+	}
+
+	fields := make(map[string]interface{})
+
+	if t := int16(binary.BigEndian.Uint16(payload[1:3])); t != math.MinInt16 {
+		fields["temperature"] = float64(t) * 0.005
+	}
+	if h := binary.BigEndian.Uint16(payload[3:5]); h != math.MaxUint16 {
+		fields["humidity"] = float64(h) * 0.0025
+	}
+	if p := binary.BigEndian.Uint16(payload[5:7]); p != math.MaxUint16 {
+		fields["pressure"] = float64(p) + 50000
+	}
+
+	for i, axis := range []string{"acceleration_x", "acceleration_y", "acceleration_z"} {
+		off := 7 + 2*i
+		if a := int16(binary.BigEndian.Uint16(payload[off : off+2])); a != math.MinInt16 {
+			fields[axis] = float64(a) / 1000
+		}
+	}
+
+	power := binary.BigEndian.Uint16(payload[13:15])
+	if v := power >> 5; v != 0x7FF {
+		fields["battery_voltage"] = float64(v+1600) / 1000
+	}
+	if tx := power & 0x1F; tx != 0x1F {
+		fields["tx_power"] = int(tx)*2 - 40
+	}
+	if m := payload[15]; m != math.MaxUint8 {
+		fields["movement_counter"] = int(m)
+	}
+	if s := binary.BigEndian.Uint16(payload[16:18]); s != math.MaxUint16 {
+		fields["sequence"] = int(s)
+	}
+	fields["mac"] = fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x",
+		payload[18], payload[19], payload[20], payload[21], payload[22], payload[23])
+
+	return fields, nil
+}
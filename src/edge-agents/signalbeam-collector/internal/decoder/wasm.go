@@ -0,0 +1,143 @@
+package decoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// wasmMagic starts every WebAssembly module
+var wasmMagic = []byte{0x00, 'a', 's', 'm'}
+
+// maxWASMResult is the largest decoded record a WASM decoder may return
+const maxWASMResult = 64 << 10
+
+// WASMLimits bound what a WASM decoder may use for one payload
+type WASMLimits struct {
+	MaxMemory int64         // Bytes of linear memory; rounded down to 64 KiB pages
+	Timeout   time.Duration // Per payload
+}
+
+// WASM is a decoder compiled to WebAssembly and pushed by the Control Plane,
+// for formats a layout cannot describe. The module gets no host functions,
+// so it can neither reach the file system nor the network, and runs in a
+// fresh instance for every payload within the memory and time limits. It
+// exports its memory and two functions:
+//
+//	alloc(size i32) -> ptr i32            // Room for the payload
+//	decode(ptr i32, len i32) -> i64       // Result: ptr << 32 | len
+//
+// The result is a JSON object of the decoded fields; a length of 0 fails
+// the payload
+type WASM struct {
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+	timeout time.Duration
+}
+
+// newWASMRuntime creates the runtime WASM decoders are compiled for. A
+// cancelled context stops a running decoder
+func newWASMRuntime(limits WASMLimits) wazero.Runtime {
+	cfg := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if pages := limits.MaxMemory / 65536; pages > 0 {
+		cfg = cfg.WithMemoryLimitPages(uint32(min(pages, 65536)))
+	}
+	return wazero.NewRuntimeWithConfig(context.Background(), cfg)
+}
+
+// isWASM reports whether a pushed definition is a WebAssembly module
+func isWASM(def []byte) bool {
+	return bytes.HasPrefix(def, wasmMagic)
+}
+
+// compileWASM compiles and checks a WASM decoder
+func compileWASM(runtime wazero.Runtime, def []byte, timeout time.Duration) (*WASM, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	module, err := runtime.CompileModule(ctx, def)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile WASM decoder: %w", err)
+	}
+	if len(module.ImportedFunctions()) > 0 {
+		module.Close(ctx)
+		return nil, errors.New("WASM decoder imports host functions")
+	}
+	exports := module.ExportedFunctions()
+	for name, params := range map[string]int{"alloc": 1, "decode": 2} {
+		fn, ok := exports[name]
+		if !ok || len(fn.ParamTypes()) != params || len(fn.ResultTypes()) != 1 {
+			module.Close(ctx)
+			return nil, fmt.Errorf("WASM decoder must export %s with %d parameters and one result", name, params)
+		}
+	}
+	if _, ok := module.ExportedMemories()["memory"]; !ok {
+		module.Close(ctx)
+		return nil, errors.New("WASM decoder must export its memory")
+	}
+	return &WASM{runtime: runtime, module: module, timeout: timeout}, nil
+}
+
+// Decode implements Decoder
+func (w *WASM) Decode(payload []byte) (map[string]interface{}, error) {
+	ctx := context.Background()
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+
+	mod, err := w.runtime.InstantiateModule(ctx, w.module, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start WASM decoder: %w", err)
+	}
+	defer mod.Close(context.Background())
+
+	res, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(payload)))
+	if err != nil {
+		return nil, wasmError(ctx, err)
+	}
+	ptr := uint32(res[0])
+	mem := mod.ExportedMemory("memory")
+	if !mem.Write(ptr, payload) {
+		return nil, errors.New("WASM decoder allocated memory out of range")
+	}
+
+	res, err = mod.ExportedFunction("decode").Call(ctx, uint64(ptr), uint64(len(payload)))
+	if err != nil {
+		return nil, wasmError(ctx, err)
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	switch {
+	case outLen == 0:
+		return nil, errors.New("WASM decoder rejected the payload")
+	case outLen > maxWASMResult:
+		return nil, fmt.Errorf("WASM decoder result of %d bytes is too large", outLen)
+	}
+	out, ok := mem.Read(outPtr, outLen)
+	if !ok {
+		return nil, errors.New("WASM decoder result out of range")
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(out, &fields); err != nil {
+		return nil, fmt.Errorf("WASM decoder result is not a JSON object: %w", err)
+	}
+	return fields, nil
+}
+
+// wasmError explains a failed call, naming the time limit when it ran out
+func wasmError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return errors.New("WASM decoder exceeded its time limit")
+	}
+	return fmt.Errorf("WASM decoder failed: %w", err)
+}
+
+// close releases the compiled module
+func (w *WASM) close() {
+	w.module.Close(context.Background())
+}
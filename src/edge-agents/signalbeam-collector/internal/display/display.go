@@ -0,0 +1,117 @@
+// Package display reports the displays attached to a kiosk or signage
+// device from the kernel's DRM connectors, and captures downscaled
+// screenshots to verify the screen shows content
+package display
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Connector is a video output of a graphics card, such as HDMI-A-1
+type Connector struct {
+	Name      string `json:"name"`
+	Connected bool   `json:"connected"`
+	Enabled   bool   `json:"enabled"`
+	DPMS      string `json:"dpms,omitempty"` // "On", "Standby", "Suspend" or "Off"
+	Mode      string `json:"mode,omitempty"` // Preferred mode of the display, e.g. 1920x1080
+	Monitor   string `json:"monitor,omitempty"`
+}
+
+// Map returns the connector as a payload map
+func (c Connector) Map() map[string]interface{} {
+	m := map[string]interface{}{
+		"name":      c.Name,
+		"connected": c.Connected,
+		"enabled":   c.Enabled,
+	}
+	if c.DPMS != "" {
+		m["dpms"] = c.DPMS
+	}
+	if c.Mode != "" {
+		m["mode"] = c.Mode
+	}
+	if c.Monitor != "" {
+		m["monitor"] = c.Monitor
+	}
+	return m
+}
+
+// Scan reads the connectors below dir, usually /sys/class/drm. Names drop
+// the card prefix, so card0-HDMI-A-1 is HDMI-A-1; writeback and virtual
+// connectors are left out
+func Scan(dir string) ([]Connector, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "card*-*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var out []Connector
+	for _, p := range paths {
+		status := readAttr(filepath.Join(p, "status"))
+		if status == "" {
+			continue
+		}
+		name := filepath.Base(p)
+		name = name[strings.Index(name, "-")+1:]
+		if strings.HasPrefix(name, "Writeback") || strings.HasPrefix(name, "Virtual") {
+			continue
+		}
+		c := Connector{
+			Name:      name,
+			Connected: status == "connected",
+			Enabled:   readAttr(filepath.Join(p, "enabled")) == "enabled",
+			DPMS:      readAttr(filepath.Join(p, "dpms")),
+		}
+		if c.Connected {
+			if modes := readAttr(filepath.Join(p, "modes")); modes != "" {
+				c.Mode, _, _ = strings.Cut(modes, "\n")
+			}
+			if edid, err := os.ReadFile(filepath.Join(p, "edid")); err == nil {
+				c.Monitor = MonitorName(edid)
+			}
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// Framebuffer returns the size of the first framebuffer, e.g. 1920x1080, or
+// "" without one. It is the resolution the console or a framebuffer
+// application draws at
+func Framebuffer(dir string) string {
+	size := readAttr(filepath.Join(dir, "fb0", "virtual_size"))
+	if size == "" {
+		return ""
+	}
+	return strings.Replace(size, ",", "x", 1)
+}
+
+// MonitorName returns the name a display reports in its EDID, or "" when it
+// reports none
+func MonitorName(edid []byte) string {
+	if len(edid) < 128 || !bytes.Equal(edid[:8], []byte{0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0}) {
+		return ""
+	}
+	// Four 18-byte descriptors; 0xfc holds the name, ended by a newline
+	for off := 54; off+18 <= 126; off += 18 {
+		d := edid[off : off+18]
+		if d[0] == 0 && d[1] == 0 && d[3] == 0xfc {
+			name, _, _ := bytes.Cut(d[5:], []byte{'\n'})
+			return strings.TrimSpace(string(name))
+		}
+	}
+	return ""
+}
+
+// readAttr reads a sysfs attribute, trimmed; "" when it cannot be read
+func readAttr(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,77 @@
+package display
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png" // Capture programs write PNG
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Screenshot captures the screen by a program writing a PNG or JPEG image
+// to standard output, such as grim on Wayland or import on X11
+type Screenshot struct {
+	Command  []string
+	MaxWidth int // Wider captures are scaled down to this
+	Quality  int // JPEG quality, 1-100
+	Timeout  time.Duration
+}
+
+// Capture runs the program and writes the image, scaled down, as JPEG to w.
+// It returns the size of the image written
+func (s Screenshot) Capture(ctx context.Context, w io.Writer) (image.Point, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.Command[0], s.Command[1:]...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return image.Point{}, fmt.Errorf("%s: %w: %s", s.Command[0], err, msg)
+		}
+		return image.Point{}, fmt.Errorf("%s: %w", s.Command[0], err)
+	}
+	img, _, err := image.Decode(&stdout)
+	if err != nil {
+		return image.Point{}, fmt.Errorf("%s: %w", s.Command[0], err)
+	}
+
+	img = Scale(img, s.MaxWidth)
+	if err := jpeg.Encode(w, img, &jpeg.Options{Quality: s.Quality}); err != nil {
+		return image.Point{}, err
+	}
+	return img.Bounds().Size(), nil
+}
+
+// Scale shrinks an image wider than width, keeping its aspect ratio, by
+// averaging the pixels each output pixel covers. Narrower images are
+// returned as they are
+func Scale(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if width <= 0 || b.Dx() <= width {
+		return img
+	}
+	height := max(b.Dy()*width/b.Dx(), 1)
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/height, b.Min.Y+(y+1)*b.Dy()/height
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/width, b.Min.X+(x+1)*b.Dx()/width
+			var r, g, bl, n uint64
+			for sy := y0; sy < max(y1, y0+1); sy++ {
+				for sx := x0; sx < max(x1, x0+1); sx++ {
+					pr, pg, pb, _ := img.At(sx, sy).RGBA()
+					r, g, bl, n = r+uint64(pr), g+uint64(pg), bl+uint64(pb), n+1
+				}
+			}
+			out.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), 0xff})
+		}
+	}
+	return out
+}
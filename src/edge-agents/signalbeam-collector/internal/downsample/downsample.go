@@ -0,0 +1,160 @@
+package downsample
+
+import (
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/units"
+)
+
+// Mode selects how old samples are reduced
+type Mode string
+
+const (
+	// ModeNth keeps one in every N samples
+	ModeNth Mode = "nth"
+	// ModeWindow averages samples into fixed time windows
+	ModeWindow Mode = "window"
+)
+
+// Sample is a buffered metrics record considered for downsampling
+type Sample struct {
+	Timestamp time.Time
+	Type      string
+	Data      map[string]interface{}
+	// Ref lets callers map results back to their own records
+	Ref interface{}
+	// Interpolated is set when Data was averaged from several samples, so
+	// replay can flag the values as estimated
+	Interpolated bool
+}
+
+// Downsampler reduces old metrics samples during backlog replay so a long
+// outage does not take days of uplink time to recover from
+type Downsampler struct {
+	OlderThan time.Duration
+	Mode      Mode
+	KeepEvery int
+	Window    time.Duration
+}
+
+// Stream applies a Downsampler to samples handed over one at a time, oldest
+// first, as a buffer drains
+type Stream struct {
+	Downsampler
+	seen   int      // Old samples counted for ModeNth
+	window []Sample // Old samples of the open window for ModeWindow
+}
+
+// Add takes the next sample. It returns the average of a window the sample
+// closed, if any, and whether the sample itself is to be sent. Samples newer
+// than OlderThan and non-metrics samples are always sent; of older metrics
+// ModeNth sends one in KeepEvery, and ModeWindow holds them until the
+// window is closed. Samples without Data cannot be averaged and are sent
+func (s *Stream) Add(sample Sample, now time.Time) (closed []Sample, send bool) {
+	old := sample.Type == "metrics" && sample.Timestamp.Before(now.Add(-s.OlderThan))
+	if s.Mode != ModeWindow {
+		if !old || s.KeepEvery <= 1 {
+			return nil, true
+		}
+		s.seen++
+		return nil, (s.seen-1)%s.KeepEvery == 0
+	}
+
+	if !old || sample.Data == nil || s.Window <= 0 {
+		return s.Flush(), true
+	}
+	if len(s.window) > 0 && !s.window[0].Timestamp.Truncate(s.Window).Equal(sample.Timestamp.Truncate(s.Window)) {
+		closed = s.Flush()
+	}
+	s.window = append(s.window, sample)
+	return closed, false
+}
+
+// Flush returns the average of the open window, if any, and closes it
+func (s *Stream) Flush() []Sample {
+	if len(s.window) == 0 {
+		return nil
+	}
+	samples := s.windowed(s.window)
+	s.window = nil
+	return samples
+}
+
+// windowed averages numeric values of samples falling in the same window.
+// Non-numeric values take the last sample's value.
+func (d Downsampler) windowed(samples []Sample) []Sample {
+	if d.Window <= 0 {
+		return samples
+	}
+
+	type bucket struct {
+		start   time.Time
+		samples []Sample
+	}
+	buckets := make(map[int64]*bucket)
+	var order []int64
+
+	for _, s := range samples {
+		start := s.Timestamp.Truncate(d.Window)
+		key := start.UnixNano()
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{start: start}
+			buckets[key] = b
+			order = append(order, key)
+		}
+		b.samples = append(b.samples, s)
+	}
+
+	result := make([]Sample, 0, len(order))
+	for _, key := range order {
+		b := buckets[key]
+		maps := make([]map[string]interface{}, len(b.samples))
+		for i, s := range b.samples {
+			maps[i] = s.Data
+		}
+
+		last := b.samples[len(b.samples)-1]
+		result = append(result, Sample{
+			Timestamp: b.start,
+			Type:      last.Type,
+			Data:      average(maps),
+			Ref:       last.Ref,
+
+			Interpolated: len(b.samples) > 1,
+		})
+	}
+	return result
+}
+
+// average merges maps, averaging numeric leaves recursively
+func average(maps []map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	children := make(map[string][]map[string]interface{})
+
+	for _, m := range maps {
+		for k, v := range m {
+			switch val := v.(type) {
+			case map[string]interface{}:
+				children[k] = append(children[k], val)
+			default:
+				if f, ok := units.ToFloat(val); ok {
+					sums[k] += f
+					counts[k]++
+				} else {
+					out[k] = val
+				}
+			}
+		}
+	}
+
+	for k, sum := range sums {
+		out[k] = sum / float64(counts[k])
+	}
+	for k, c := range children {
+		out[k] = average(c)
+	}
+	return out
+}
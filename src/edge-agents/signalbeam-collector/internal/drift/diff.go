@@ -0,0 +1,78 @@
+package drift
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxDiffLines bounds the changed lines reported per file
+	maxDiffLines = 50
+	// maxDiffCells bounds the comparison table, files with more lines are
+	// reported without a diff
+	maxDiffCells = 4 << 20
+)
+
+// diffLines lists the lines removed from a and added in b, prefixed with
+// "-" or "+" and their line number
+func diffLines(a, b string) []string {
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+
+	// Common lines at either end need no table
+	start := 0
+	for start < len(x) && start < len(y) && x[start] == y[start] {
+		start++
+	}
+	endX, endY := len(x), len(y)
+	for endX > start && endY > start && x[endX-1] == y[endY-1] {
+		endX--
+		endY--
+	}
+	mx, my := x[start:endX], y[start:endY]
+	if (len(mx)+1)*(len(my)+1) > maxDiffCells {
+		return []string{fmt.Sprintf("@@ %d lines differ, too many to compare", len(mx)+len(my))}
+	}
+
+	// lcs[i][j] is the longest common subsequence of mx[i:] and my[j:]
+	lcs := make([][]int32, len(mx)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(my)+1)
+	}
+	for i := len(mx) - 1; i >= 0; i-- {
+		for j := len(my) - 1; j >= 0; j-- {
+			if mx[i] == my[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out []string
+	changed := 0
+	add := func(line string) {
+		if changed < maxDiffLines {
+			out = append(out, line)
+		}
+		changed++
+	}
+	i, j := 0, 0
+	for i < len(mx) || j < len(my) {
+		switch {
+		case i < len(mx) && j < len(my) && mx[i] == my[j]:
+			i++
+			j++
+		case i < len(mx) && (j == len(my) || lcs[i+1][j] >= lcs[i][j+1]):
+			add(fmt.Sprintf("-%d: %s", start+i+1, mx[i]))
+			i++
+		default:
+			add(fmt.Sprintf("+%d: %s", start+j+1, my[j]))
+			j++
+		}
+	}
+	if changed > maxDiffLines {
+		out = append(out, fmt.Sprintf("@@ %d more changed lines", changed-maxDiffLines))
+	}
+	return out
+}
@@ -0,0 +1,276 @@
+// Package drift compares the host with a baseline pushed by the cloud:
+// expected file hashes and modes, sysctl values and service states
+package drift
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/sysprobe"
+	"gopkg.in/yaml.v3"
+)
+
+// maxCopy bounds the files kept for diffs
+const maxCopy = 64 << 10
+
+// Baseline is the expected state of the host
+type Baseline struct {
+	Version  string            `yaml:"version"`
+	Files    []File            `yaml:"files"`
+	Sysctl   map[string]string `yaml:"sysctl"`
+	Services []Service         `yaml:"services"`
+}
+
+// File is the expected state of a file. Empty fields are not checked
+type File struct {
+	Path   string `yaml:"path"`
+	SHA256 string `yaml:"sha256"`
+	Mode   string `yaml:"mode"`   // Octal permission bits, e.g. "0644"
+	Absent bool   `yaml:"absent"` // The file must not exist
+	// Diff keeps a copy of the file while it matches, to report the lines
+	// changed when it drifts. Leave off for files holding secrets
+	Diff bool `yaml:"diff"`
+}
+
+// Service is the expected state of a systemd unit. Empty fields are not
+// checked
+type Service struct {
+	Name    string `yaml:"name"`
+	State   string `yaml:"state"` // "running" or "stopped"
+	Enabled *bool  `yaml:"enabled"`
+}
+
+// Finding is one difference between the host and the baseline
+type Finding struct {
+	Kind     string   `json:"kind"`  // file, sysctl or service
+	Name     string   `json:"name"`  // Path, key or unit
+	Field    string   `json:"field"` // What differs, e.g. sha256 or state
+	Expected string   `json:"expected"`
+	Actual   string   `json:"actual"`
+	Diff     []string `json:"diff,omitempty"` // Changed lines of text files
+}
+
+// Key identifies what a finding is about, across checks
+func (f Finding) Key() string {
+	return f.Kind + ":" + f.Name + ":" + f.Field
+}
+
+// Parse reads a baseline in JSON or YAML
+func Parse(data []byte) (*Baseline, error) {
+	var b Baseline
+	if err := yaml.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("invalid baseline: %w", err)
+	}
+	for i, f := range b.Files {
+		switch {
+		case !filepath.IsAbs(f.Path):
+			return nil, fmt.Errorf("files[%d].path must be absolute", i)
+		case f.SHA256 != "" && !isSHA256(f.SHA256):
+			return nil, fmt.Errorf("files[%d].sha256 must be a hex SHA-256 hash", i)
+		case f.Absent && (f.SHA256 != "" || f.Mode != ""):
+			return nil, fmt.Errorf("files[%d]: an absent file has no sha256 or mode", i)
+		}
+		if f.Mode != "" {
+			if _, err := strconv.ParseUint(f.Mode, 8, 32); err != nil {
+				return nil, fmt.Errorf("files[%d].mode must be octal", i)
+			}
+		}
+	}
+	for i, s := range b.Services {
+		if s.Name == "" {
+			return nil, fmt.Errorf("services[%d].name is required", i)
+		}
+		if s.State != "" && s.State != "running" && s.State != "stopped" {
+			return nil, fmt.Errorf("services[%d].state must be running or stopped", i)
+		}
+	}
+	return &b, nil
+}
+
+// Checker compares the host with baselines. Copies of files that match
+// their expected hash are kept in a directory, named by the hash
+type Checker struct {
+	dir string
+}
+
+// NewChecker creates a checker keeping file copies in dir
+func NewChecker(dir string) *Checker {
+	return &Checker{dir: dir}
+}
+
+// Check returns the differences between the host and the baseline. Errors
+// are checks that could not run; the other checks still do
+func (c *Checker) Check(b *Baseline) ([]Finding, error) {
+	var findings []Finding
+	var errs []error
+	keep := make(map[string]bool)
+
+	for _, f := range b.Files {
+		found, err := c.checkFile(f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("file %s: %w", f.Path, err))
+		}
+		findings = append(findings, found...)
+		if f.Diff && f.SHA256 != "" {
+			keep[strings.ToLower(f.SHA256)] = true
+		}
+	}
+
+	for key, want := range b.Sysctl {
+		got, err := sysprobe.Sysctl(key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sysctl %s: %w", key, err))
+			continue
+		}
+		if normalize(got) != normalize(want) {
+			findings = append(findings, Finding{Kind: "sysctl", Name: key, Field: "value", Expected: want, Actual: got})
+		}
+	}
+
+	for _, s := range b.Services {
+		found, err := checkService(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %w", s.Name, err))
+		}
+		findings = append(findings, found...)
+	}
+
+	if err := c.prune(keep); err != nil {
+		errs = append(errs, err)
+	}
+	return findings, errors.Join(errs...)
+}
+
+// checkFile compares a file with its expected state
+func (c *Checker) checkFile(f File) ([]Finding, error) {
+	info, err := os.Stat(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		if f.Absent {
+			return nil, nil
+		}
+		return []Finding{{Kind: "file", Name: f.Path, Field: "exists", Expected: "true", Actual: "false"}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if f.Absent {
+		return []Finding{{Kind: "file", Name: f.Path, Field: "exists", Expected: "false", Actual: "true"}}, nil
+	}
+
+	var findings []Finding
+	if f.Mode != "" {
+		want, _ := strconv.ParseUint(f.Mode, 8, 32)
+		if got := uint64(info.Mode().Perm()); got != want {
+			findings = append(findings, Finding{
+				Kind: "file", Name: f.Path, Field: "mode",
+				Expected: fmt.Sprintf("%04o", want), Actual: fmt.Sprintf("%04o", got),
+			})
+		}
+	}
+	if f.SHA256 == "" {
+		return findings, nil
+	}
+
+	content, sum, err := readFile(f.Path, f.Diff && info.Size() <= maxCopy)
+	if err != nil {
+		return findings, err
+	}
+	want := strings.ToLower(f.SHA256)
+	if sum == want {
+		if content != nil {
+			return findings, c.keepCopy(want, content)
+		}
+		return findings, nil
+	}
+
+	finding := Finding{Kind: "file", Name: f.Path, Field: "sha256", Expected: want, Actual: sum}
+	if content != nil {
+		if good, err := os.ReadFile(filepath.Join(c.dir, want)); err == nil && isText(good) && isText(content) {
+			finding.Diff = diffLines(string(good), string(content))
+		}
+	}
+	return append(findings, finding), nil
+}
+
+// keepCopy stores the content of a file matching its baseline, for later
+// diffs
+func (c *Checker) keepCopy(sum string, content []byte) error {
+	path := filepath.Join(c.dir, sum)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// prune removes copies no file of the baseline refers to
+func (c *Checker) prune(keep map[string]bool) error {
+	entries, err := os.ReadDir(c.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !keep[e.Name()] {
+			if err := os.Remove(filepath.Join(c.dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readFile hashes a file, returning its content too when asked
+func readFile(path string, withContent bool) ([]byte, string, error) {
+	if withContent {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		sum := sha256.Sum256(data)
+		return data, hex.EncodeToString(sum[:]), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, "", err
+	}
+	return nil, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// normalize collapses whitespace, as sysctl separates multiple values with
+// tabs
+func normalize(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// isText reports whether data can be diffed line by line
+func isText(data []byte) bool {
+	return utf8.Valid(data) && !strings.ContainsRune(string(data), 0)
+}
+
+// isSHA256 reports whether s is a hex SHA-256 hash
+func isSHA256(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
@@ -0,0 +1,34 @@
+package drift
+
+import (
+	"strconv"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/sysprobe"
+)
+
+// checkService asks systemd for the state of a unit
+func checkService(s Service) ([]Finding, error) {
+	var findings []Finding
+	if s.State != "" {
+		active, err := sysprobe.Systemctl("is-active", s.Name)
+		if err != nil {
+			return nil, err
+		}
+		if running := active == "active"; running != (s.State == "running") {
+			findings = append(findings, Finding{Kind: "service", Name: s.Name, Field: "state", Expected: s.State, Actual: active})
+		}
+	}
+	if s.Enabled != nil {
+		enabled, err := sysprobe.Systemctl("is-enabled", s.Name)
+		if err != nil {
+			return findings, err
+		}
+		if (enabled == "enabled") != *s.Enabled {
+			findings = append(findings, Finding{
+				Kind: "service", Name: s.Name, Field: "enabled",
+				Expected: strconv.FormatBool(*s.Enabled), Actual: enabled,
+			})
+		}
+	}
+	return findings, nil
+}
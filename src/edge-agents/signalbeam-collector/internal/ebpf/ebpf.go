@@ -0,0 +1,107 @@
+package ebpf
+
+import "time"
+
+// Capability describes whether the host can run the CO-RE eBPF probes
+type Capability struct {
+	Supported bool   `json:"supported"`
+	BTF       bool   `json:"btf"`
+	CapBPF    bool   `json:"cap_bpf"`
+	Kernel    string `json:"kernel,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Map returns the capability as a metrics map
+func (c Capability) Map() map[string]interface{} {
+	m := map[string]interface{}{
+		"supported": c.Supported,
+		"btf":       c.BTF,
+		"cap_bpf":   c.CapBPF,
+	}
+	if c.Kernel != "" {
+		m["kernel"] = c.Kernel
+	}
+	if c.Reason != "" {
+		m["reason"] = c.Reason
+	}
+	return m
+}
+
+// maxExecs is the most exec events kept between two collections
+const maxExecs = 256
+
+// Process is the TCP and UDP traffic of one process since the last collection
+type Process struct {
+	PID     uint32
+	Comm    string
+	TxBytes uint64
+	RxBytes uint64
+}
+
+// Retransmit counts the TCP retransmits to one remote since the last
+// collection
+type Retransmit struct {
+	Remote string
+	Count  uint64
+}
+
+// Exec is a program started since the last collection, seen even when it
+// exited long before
+type Exec struct {
+	Time     time.Time
+	PID      uint32
+	PPID     uint32
+	UID      uint32
+	Comm     string
+	Filename string
+}
+
+// Snapshot is what the probes saw since the last collection
+type Snapshot struct {
+	Processes    []Process
+	Retransmits  []Retransmit
+	Execs        []Exec
+	DroppedExecs uint64            // Exec events beyond maxExecs
+	Unavailable  map[string]string // Probes the kernel refused, with why
+}
+
+// Map returns the snapshot as a metrics map
+func (s Snapshot) Map() map[string]interface{} {
+	processes := make([]map[string]interface{}, 0, len(s.Processes))
+	for _, p := range s.Processes {
+		processes = append(processes, map[string]interface{}{
+			"pid":      p.PID,
+			"comm":     p.Comm,
+			"tx_bytes": p.TxBytes,
+			"rx_bytes": p.RxBytes,
+		})
+	}
+	retransmits := make([]map[string]interface{}, 0, len(s.Retransmits))
+	for _, r := range s.Retransmits {
+		retransmits = append(retransmits, map[string]interface{}{
+			"remote": r.Remote,
+			"count":  r.Count,
+		})
+	}
+	execs := make([]map[string]interface{}, 0, len(s.Execs))
+	for _, e := range s.Execs {
+		execs = append(execs, map[string]interface{}{
+			"time":     e.Time.UTC().Format(time.RFC3339Nano),
+			"pid":      e.PID,
+			"ppid":     e.PPID,
+			"uid":      e.UID,
+			"comm":     e.Comm,
+			"filename": e.Filename,
+		})
+	}
+	m := map[string]interface{}{
+		"processes":     processes,
+		"retransmits":   retransmits,
+		"execs":         execs,
+		"dropped_execs": s.DroppedExecs,
+	}
+	if len(s.Unavailable) > 0 {
+		m["unavailable"] = s.Unavailable
+	}
+	return m
+}
@@ -0,0 +1,69 @@
+//go:build linux
+
+package ebpf
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	btfPath = "/sys/kernel/btf/vmlinux"
+
+	capSysAdmin = 21
+	capBPF      = 39
+)
+
+// Probe checks for kernel BTF (required for CO-RE) and the capabilities
+// needed to load programs (CAP_BPF, or CAP_SYS_ADMIN on older kernels)
+func Probe() Capability {
+	c := Capability{}
+
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err == nil {
+		c.Kernel = unix.ByteSliceToString(uts.Release[:])
+	}
+
+	if _, err := os.Stat(btfPath); err == nil {
+		c.BTF = true
+	}
+
+	caps, err := effectiveCaps()
+	if err == nil {
+		c.CapBPF = caps&(1<<capBPF) != 0 || caps&(1<<capSysAdmin) != 0
+	}
+
+	switch {
+	case !c.BTF:
+		c.Reason = "kernel BTF not available at " + btfPath
+	case !c.CapBPF:
+		c.Reason = "missing CAP_BPF or CAP_SYS_ADMIN"
+	default:
+		c.Supported = true
+	}
+
+	return c
+}
+
+// effectiveCaps reads the effective capability mask of the current process
+func effectiveCaps() (uint64, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "CapEff:") {
+			return strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		}
+	}
+
+	return 0, scanner.Err()
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+package ebpf
+
+import "errors"
+
+// Probe reports eBPF as unsupported on non-Linux platforms
+func Probe() Capability {
+	return Capability{Reason: "eBPF is only available on Linux"}
+}
+
+// Insight is not available on non-Linux platforms
+type Insight struct{}
+
+// Start fails on non-Linux platforms
+func Start() (*Insight, error) {
+	return nil, errors.New("eBPF is only available on Linux")
+}
+
+// Collect returns nothing on non-Linux platforms
+func (*Insight) Collect() (Snapshot, error) {
+	return Snapshot{}, nil
+}
+
+// Close does nothing on non-Linux platforms
+func (*Insight) Close() error {
+	return nil
+}
@@ -0,0 +1,629 @@
+//go:build linux
+
+package ebpf
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	maxProcesses = 4096     // Processes tracked; the least recently active are evicted
+	maxRemotes   = 1024     // Remotes tracked for retransmits, evicted likewise
+	maxReported  = 64       // Busiest processes reported per collection
+	execBuffer   = 64 << 10 // Ring buffer for exec events
+
+	afInet  = 2
+	afInet6 = 10
+
+	commLen     = 16
+	filenameLen = 256
+	execSize    = 16 + commLen + filenameLen // pid, ppid, uid, pad, comm, filename
+
+	license = "Dual BSD/GPL"
+)
+
+// trafficValue is the traffic map value: bytes sent and received by a
+// process and its name
+type trafficValue struct {
+	Tx   uint64
+	Rx   uint64
+	Comm [commLen]byte
+}
+
+// remoteKey is the retransmit map key: the remote address, its port in
+// network order and the address family
+type remoteKey struct {
+	Addr   [16]byte
+	Port   [2]byte
+	Family uint16
+	_      [4]byte
+}
+
+// offsets are the kernel struct fields the programs read. They are taken
+// from the BTF of the running kernel when the programs are built, which
+// relocates them the way CO-RE does, so one binary runs on any kernel with
+// BTF and no compiler is needed on the device
+type offsets struct {
+	skcFamily    int32
+	skcDport     int32
+	skcDaddr     int32
+	skcV6Daddr   int32 // -1 without IPv6
+	taskTgid     int32
+	taskParent   int32
+	bprmFilename int32
+}
+
+// Insight runs the eBPF programs: per-process TCP and UDP throughput, TCP
+// retransmits per remote and exec events, none of which /proc sampling can
+// see reliably
+type Insight struct {
+	traffic *ebpf.Map
+	remotes *ebpf.Map
+	execs   *ebpf.Map
+	links   []link.Link
+	reader  *ringbuf.Reader
+	done    chan struct{}
+
+	lastTraffic map[uint32]trafficValue
+	lastRemotes map[remoteKey]uint64
+
+	unavailable map[string]string // Program groups the kernel refused, with why
+
+	mu      sync.Mutex
+	pending []Exec
+	dropped uint64
+}
+
+// Start loads and attaches the programs. Probe should report support first
+func Start() (*Insight, error) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return nil, fmt.Errorf("failed to lift memlock limit: %w", err)
+	}
+	spec, err := btf.LoadKernelSpec()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kernel BTF: %w", err)
+	}
+	// The parsed BTF takes several MB and is only needed to load
+	defer btf.FlushKernelSpec()
+	off, err := kernelOffsets(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &Insight{
+		done:        make(chan struct{}),
+		lastTraffic: make(map[uint32]trafficValue),
+		lastRemotes: make(map[remoteKey]uint64),
+	}
+	if err := in.load(off); err != nil {
+		in.Close()
+		return nil, err
+	}
+	go in.readExecs()
+	return in, nil
+}
+
+// trafficFuncs are the kernel functions that count traffic, with the
+// argument slot holding the byte count
+var trafficFuncs = []struct {
+	fn     string
+	arg    int
+	signed bool
+	dir    int32 // 0 sent, 8 received
+}{
+	{"tcp_sendmsg", 2, false, 0},     // (sk, msg, size_t size)
+	{"udp_sendmsg", 2, false, 0},     // (sk, msg, size_t len)
+	{"tcp_cleanup_rbuf", 1, true, 8}, // (sk, int copied)
+	{"skb_consume_udp", 2, true, 8},  // (sk, skb, int len)
+}
+
+// load creates the maps and attaches the programs. A group of programs the
+// kernel refuses is reported unavailable; load fails when all are
+func (in *Insight) load(off offsets) error {
+	var err error
+	if in.traffic, err = ebpf.NewMap(&ebpf.MapSpec{
+		Name: "sb_traffic", Type: ebpf.LRUHash, KeySize: 4, ValueSize: 32, MaxEntries: maxProcesses,
+	}); err != nil {
+		return fmt.Errorf("failed to create traffic map: %w", err)
+	}
+	if in.remotes, err = ebpf.NewMap(&ebpf.MapSpec{
+		Name: "sb_retrans", Type: ebpf.LRUHash, KeySize: 24, ValueSize: 8, MaxEntries: maxRemotes,
+	}); err != nil {
+		return fmt.Errorf("failed to create retransmit map: %w", err)
+	}
+	if in.execs, err = ebpf.NewMap(&ebpf.MapSpec{
+		Name: "sb_execs", Type: ebpf.RingBuf, MaxEntries: execBuffer,
+	}); err != nil {
+		return fmt.Errorf("failed to create exec ring buffer: %w", err)
+	}
+
+	in.unavailable = make(map[string]string)
+	if err := in.attachTraffic(); err != nil {
+		in.unavailable["throughput"] = err.Error()
+	}
+	if l, err := attachRawTracepoint("tcp_retransmit_skb", retransmitProgram(in.remotes, off)); err != nil {
+		in.unavailable["retransmits"] = err.Error()
+	} else {
+		in.links = append(in.links, l)
+	}
+	if l, err := attachRawTracepoint("sched_process_exec", execProgram(in.execs, off)); err != nil {
+		in.unavailable["execs"] = err.Error()
+	} else {
+		in.links = append(in.links, l)
+	}
+	if len(in.links) == 0 {
+		return fmt.Errorf("no eBPF program could be attached: %s", in.unavailable["throughput"])
+	}
+
+	if in.reader, err = ringbuf.NewReader(in.execs); err != nil {
+		return fmt.Errorf("failed to read exec ring buffer: %w", err)
+	}
+	return nil
+}
+
+// attachTraffic counts throughput with fentry programs, or with kprobes
+// where the kernel or architecture has no BPF trampolines
+func (in *Insight) attachTraffic() error {
+	var links []link.Link
+	closeLinks := func() {
+		for _, l := range links {
+			l.Close()
+		}
+		links = nil
+	}
+
+	var fentryErr error
+	for _, f := range trafficFuncs {
+		l, err := attachFEntry(f.fn, trafficProgram(in.traffic, int16(f.arg*8), asm.DWord, f.signed, f.dir))
+		if err != nil {
+			fentryErr = err
+			closeLinks()
+			break
+		}
+		links = append(links, l)
+	}
+	if fentryErr == nil {
+		in.links = append(in.links, links...)
+		return nil
+	}
+	if kprobeArgs == nil {
+		return fentryErr
+	}
+
+	for _, f := range trafficFuncs {
+		l, err := attachKprobe(f.fn, trafficProgram(in.traffic, kprobeArgs[f.arg], kprobeArgSize, f.signed, f.dir))
+		if err != nil {
+			closeLinks()
+			return fmt.Errorf("%v; %w", fentryErr, err)
+		}
+		links = append(links, l)
+	}
+	in.links = append(in.links, links...)
+	return nil
+}
+
+// attachFEntry loads a program and runs it on entry to a kernel function
+func attachFEntry(fn string, insns asm.Instructions) (link.Link, error) {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:         ebpf.Tracing,
+		AttachType:   ebpf.AttachTraceFEntry,
+		AttachTo:     fn,
+		Instructions: insns,
+		License:      license,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load fentry program for %s: %w", fn, err)
+	}
+	defer prog.Close()
+	l, err := link.AttachTracing(link.TracingOptions{Program: prog})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach to %s: %w", fn, err)
+	}
+	return l, nil
+}
+
+// attachKprobe loads a program and runs it on a kprobe of a kernel function
+func attachKprobe(fn string, insns asm.Instructions) (link.Link, error) {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:         ebpf.Kprobe,
+		Instructions: insns,
+		License:      license,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kprobe program for %s: %w", fn, err)
+	}
+	defer prog.Close()
+	l, err := link.Kprobe(fn, prog, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach kprobe to %s: %w", fn, err)
+	}
+	return l, nil
+}
+
+// attachRawTracepoint loads a program and runs it on a tracepoint
+func attachRawTracepoint(name string, insns asm.Instructions) (link.Link, error) {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:         ebpf.RawTracepoint,
+		Instructions: insns,
+		License:      license,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s program: %w", name, err)
+	}
+	defer prog.Close()
+	l, err := link.AttachRawTracepoint(link.RawTracepointOptions{Name: name, Program: prog})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach to %s: %w", name, err)
+	}
+	return l, nil
+}
+
+// trafficProgram adds the byte count at offset arg of the context to the
+// sent (dir 0) or received (dir 8) bytes of the current process
+func trafficProgram(m *ebpf.Map, arg int16, size asm.Size, signed bool, dir int32) asm.Instructions {
+	insns := asm.Instructions{asm.LoadMem(asm.R6, asm.R1, arg, size)}
+	if signed {
+		insns = append(insns, asm.LSh.Imm(asm.R6, 32), asm.ArSh.Imm(asm.R6, 32))
+	}
+	return append(insns,
+		asm.JSLE.Imm(asm.R6, 0, "exit"),
+
+		// Key at fp-4: the process ID
+		asm.FnGetCurrentPidTgid.Call(),
+		asm.RSh.Imm(asm.R0, 32),
+		asm.StoreMem(asm.RFP, -4, asm.R0, asm.Word),
+		asm.LoadMapPtr(asm.R1, m.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -4),
+		asm.FnMapLookupElem.Call(),
+		asm.JNE.Imm(asm.R0, 0, "add"),
+
+		// First traffic of the process: value at fp-40 with its name
+		asm.StoreImm(asm.RFP, -40, 0, asm.DWord),
+		asm.StoreImm(asm.RFP, -32, 0, asm.DWord),
+		asm.Mov.Reg(asm.R1, asm.RFP),
+		asm.Add.Imm(asm.R1, -24),
+		asm.Mov.Imm(asm.R2, commLen),
+		asm.FnGetCurrentComm.Call(),
+		asm.LoadMapPtr(asm.R1, m.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -4),
+		asm.Mov.Reg(asm.R3, asm.RFP),
+		asm.Add.Imm(asm.R3, -40),
+		asm.Mov.Imm(asm.R4, int32(ebpf.UpdateNoExist)),
+		asm.FnMapUpdateElem.Call(),
+		asm.LoadMapPtr(asm.R1, m.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -4),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "exit"),
+
+		asm.Add.Imm(asm.R0, dir).WithSymbol("add"),
+		asm.StoreXAdd(asm.R0, asm.R6, asm.DWord),
+		asm.Mov.Imm(asm.R0, 0).WithSymbol("exit"),
+		asm.Return(),
+	)
+}
+
+// retransmitProgram counts the retransmits of tcp_retransmit_skb(sk, skb)
+// per remote
+func retransmitProgram(m *ebpf.Map, off offsets) asm.Instructions {
+	insns := asm.Instructions{
+		asm.LoadMem(asm.R6, asm.R1, 0, asm.DWord),
+
+		// Key at fp-24: address, port at fp-8, family at fp-6
+		asm.StoreImm(asm.RFP, -24, 0, asm.DWord),
+		asm.StoreImm(asm.RFP, -16, 0, asm.DWord),
+		asm.StoreImm(asm.RFP, -8, 0, asm.DWord),
+	}
+	insns = append(insns, readKernel(-6, 2, asm.R6, off.skcFamily)...)
+	insns = append(insns, readKernel(-8, 2, asm.R6, off.skcDport)...)
+	insns = append(insns,
+		asm.LoadMem(asm.R1, asm.RFP, -6, asm.Half),
+		asm.JEq.Imm(asm.R1, afInet, "v4"),
+	)
+	if off.skcV6Daddr >= 0 {
+		insns = append(insns, asm.JEq.Imm(asm.R1, afInet6, "v6"))
+	}
+	insns = append(insns, asm.Ja.Label("exit"))
+
+	v4 := readKernel(-24, 4, asm.R6, off.skcDaddr)
+	v4[0] = v4[0].WithSymbol("v4")
+	insns = append(append(insns, v4...), asm.Ja.Label("count"))
+	if off.skcV6Daddr >= 0 {
+		v6 := readKernel(-24, 16, asm.R6, off.skcV6Daddr)
+		v6[0] = v6[0].WithSymbol("v6")
+		insns = append(insns, v6...)
+	}
+
+	return append(insns,
+		asm.LoadMapPtr(asm.R1, m.FD()).WithSymbol("count"),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -24),
+		asm.FnMapLookupElem.Call(),
+		asm.JNE.Imm(asm.R0, 0, "add"),
+
+		asm.StoreImm(asm.RFP, -32, 0, asm.DWord),
+		asm.LoadMapPtr(asm.R1, m.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -24),
+		asm.Mov.Reg(asm.R3, asm.RFP),
+		asm.Add.Imm(asm.R3, -32),
+		asm.Mov.Imm(asm.R4, int32(ebpf.UpdateNoExist)),
+		asm.FnMapUpdateElem.Call(),
+		asm.LoadMapPtr(asm.R1, m.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -24),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "exit"),
+
+		asm.Mov.Imm(asm.R1, 1).WithSymbol("add"),
+		asm.StoreXAdd(asm.R0, asm.R1, asm.DWord),
+		asm.Mov.Imm(asm.R0, 0).WithSymbol("exit"),
+		asm.Return(),
+	)
+}
+
+// execProgram sends an event for sched_process_exec(p, old_pid, bprm)
+func execProgram(m *ebpf.Map, off offsets) asm.Instructions {
+	// Event at fp-296, below a pointer slot at fp-8
+	const ev = -8 - execSize
+	insns := asm.Instructions{
+		asm.LoadMem(asm.R6, asm.R1, 0, asm.DWord),
+		asm.LoadMem(asm.R7, asm.R1, 16, asm.DWord),
+	}
+	for i := int16(0); i < execSize; i += 8 {
+		insns = append(insns, asm.StoreImm(asm.RFP, ev+i, 0, asm.DWord))
+	}
+
+	insns = append(insns, readKernel(ev, 4, asm.R6, off.taskTgid)...)
+	insns = append(insns, readKernel(-8, 8, asm.R6, off.taskParent)...)
+	insns = append(insns, asm.LoadMem(asm.R8, asm.RFP, -8, asm.DWord))
+	insns = append(insns, readKernel(ev+4, 4, asm.R8, off.taskTgid)...)
+	insns = append(insns,
+		asm.FnGetCurrentUidGid.Call(),
+		asm.StoreMem(asm.RFP, ev+8, asm.R0, asm.Word),
+		asm.Mov.Reg(asm.R1, asm.RFP),
+		asm.Add.Imm(asm.R1, ev+16),
+		asm.Mov.Imm(asm.R2, commLen),
+		asm.FnGetCurrentComm.Call(),
+	)
+	insns = append(insns, readKernel(-8, 8, asm.R7, off.bprmFilename)...)
+	return append(insns,
+		asm.Mov.Reg(asm.R1, asm.RFP),
+		asm.Add.Imm(asm.R1, ev+32),
+		asm.Mov.Imm(asm.R2, filenameLen),
+		asm.LoadMem(asm.R3, asm.RFP, -8, asm.DWord),
+		asm.FnProbeReadKernelStr.Call(),
+
+		asm.LoadMapPtr(asm.R1, m.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, ev),
+		asm.Mov.Imm(asm.R3, execSize),
+		asm.Mov.Imm(asm.R4, 0),
+		asm.FnRingbufOutput.Call(),
+		asm.Mov.Imm(asm.R0, 0),
+		asm.Return(),
+	)
+}
+
+// readKernel copies size bytes at base+off in kernel memory to the stack at
+// fp+dst. A failed read leaves zeroes
+func readKernel(dst int16, size int32, base asm.Register, off int32) asm.Instructions {
+	return asm.Instructions{
+		asm.Mov.Reg(asm.R1, asm.RFP),
+		asm.Add.Imm(asm.R1, int32(dst)),
+		asm.Mov.Imm(asm.R2, size),
+		asm.Mov.Reg(asm.R3, base),
+		asm.Add.Imm(asm.R3, off),
+		asm.FnProbeReadKernel.Call(),
+	}
+}
+
+// kernelOffsets looks up the fields the programs read in the kernel BTF
+func kernelOffsets(spec *btf.Spec) (offsets, error) {
+	off := offsets{skcV6Daddr: -1}
+	common, err := fieldOffset(spec, "sock", "__sk_common")
+	if err != nil {
+		return off, err
+	}
+	fields := []struct {
+		dst        *int32
+		typ, field string
+		add        int32
+	}{
+		{&off.skcFamily, "sock_common", "skc_family", common},
+		{&off.skcDport, "sock_common", "skc_dport", common},
+		{&off.skcDaddr, "sock_common", "skc_daddr", common},
+		{&off.taskTgid, "task_struct", "tgid", 0},
+		{&off.taskParent, "task_struct", "real_parent", 0},
+		{&off.bprmFilename, "linux_binprm", "filename", 0},
+	}
+	for _, f := range fields {
+		o, err := fieldOffset(spec, f.typ, f.field)
+		if err != nil {
+			return off, err
+		}
+		*f.dst = f.add + o
+	}
+	// Kernels without IPv6 lack the field; their retransmits are all IPv4
+	if o, err := fieldOffset(spec, "sock_common", "skc_v6_daddr"); err == nil {
+		off.skcV6Daddr = common + o
+	}
+	return off, nil
+}
+
+// fieldOffset returns the byte offset of a field in a kernel struct,
+// looking into anonymous structs and unions
+func fieldOffset(spec *btf.Spec, typ, field string) (int32, error) {
+	var s *btf.Struct
+	if err := spec.TypeByName(typ, &s); err != nil {
+		return 0, fmt.Errorf("kernel BTF lacks struct %s: %w", typ, err)
+	}
+	off, ok := memberOffset(s.Members, field)
+	if !ok {
+		return 0, fmt.Errorf("kernel BTF lacks field %s.%s", typ, field)
+	}
+	return int32(off), nil
+}
+
+func memberOffset(members []btf.Member, field string) (uint32, bool) {
+	for _, m := range members {
+		if m.Name == field {
+			return m.Offset.Bytes(), true
+		}
+		if m.Name != "" {
+			continue
+		}
+		var inner []btf.Member
+		switch t := btf.UnderlyingType(m.Type).(type) {
+		case *btf.Struct:
+			inner = t.Members
+		case *btf.Union:
+			inner = t.Members
+		}
+		if off, ok := memberOffset(inner, field); ok {
+			return m.Offset.Bytes() + off, true
+		}
+	}
+	return 0, false
+}
+
+// readExecs takes exec events off the ring buffer until it is closed,
+// keeping up to maxExecs for the next collection
+func (in *Insight) readExecs() {
+	defer close(in.done)
+	for {
+		rec, err := in.reader.Read()
+		if err != nil {
+			return
+		}
+		raw := rec.RawSample
+		if len(raw) < execSize {
+			continue
+		}
+		e := Exec{
+			Time:     time.Now(),
+			PID:      binary.NativeEndian.Uint32(raw[0:]),
+			PPID:     binary.NativeEndian.Uint32(raw[4:]),
+			UID:      binary.NativeEndian.Uint32(raw[8:]),
+			Comm:     unix.ByteSliceToString(raw[16:32]),
+			Filename: unix.ByteSliceToString(raw[32:execSize]),
+		}
+
+		in.mu.Lock()
+		if len(in.pending) < maxExecs {
+			in.pending = append(in.pending, e)
+		} else {
+			in.dropped++
+		}
+		in.mu.Unlock()
+	}
+}
+
+// Collect returns what the programs saw since the last collection: the
+// busiest processes, retransmits per remote and the execs
+func (in *Insight) Collect() (Snapshot, error) {
+	s := Snapshot{Unavailable: in.unavailable}
+
+	traffic := make(map[uint32]trafficValue)
+	var pid uint32
+	var v trafficValue
+	it := in.traffic.Iterate()
+	for it.Next(&pid, &v) {
+		traffic[pid] = v
+		last := in.lastTraffic[pid]
+		tx, rx := delta(v.Tx, last.Tx), delta(v.Rx, last.Rx)
+		if tx > 0 || rx > 0 {
+			s.Processes = append(s.Processes, Process{
+				PID:     pid,
+				Comm:    unix.ByteSliceToString(v.Comm[:]),
+				TxBytes: tx,
+				RxBytes: rx,
+			})
+		}
+	}
+	if err := it.Err(); err != nil {
+		return s, fmt.Errorf("failed to read traffic map: %w", err)
+	}
+	in.lastTraffic = traffic
+	slices.SortFunc(s.Processes, func(a, b Process) int {
+		return cmp.Compare(b.TxBytes+b.RxBytes, a.TxBytes+a.RxBytes)
+	})
+	if len(s.Processes) > maxReported {
+		s.Processes = s.Processes[:maxReported]
+	}
+
+	remotes := make(map[remoteKey]uint64)
+	var key remoteKey
+	var count uint64
+	it = in.remotes.Iterate()
+	for it.Next(&key, &count) {
+		remotes[key] = count
+		if n := delta(count, in.lastRemotes[key]); n > 0 {
+			s.Retransmits = append(s.Retransmits, Retransmit{Remote: key.String(), Count: n})
+		}
+	}
+	if err := it.Err(); err != nil {
+		return s, fmt.Errorf("failed to read retransmit map: %w", err)
+	}
+	in.lastRemotes = remotes
+
+	in.mu.Lock()
+	s.Execs, in.pending = in.pending, nil
+	s.DroppedExecs, in.dropped = in.dropped, 0
+	in.mu.Unlock()
+	return s, nil
+}
+
+// delta is the growth of a counter, which restarts when the map evicted
+// its entry
+func delta(cur, last uint64) uint64 {
+	if cur < last {
+		return cur
+	}
+	return cur - last
+}
+
+// String formats the remote as host:port
+func (k remoteKey) String() string {
+	ip := net.IP(k.Addr[:16])
+	if k.Family == afInet {
+		ip = net.IP(k.Addr[:4])
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(binary.BigEndian.Uint16(k.Port[:]))))
+}
+
+// Close detaches the programs and releases the maps
+func (in *Insight) Close() error {
+	var errs []error
+	for _, l := range in.links {
+		errs = append(errs, l.Close())
+	}
+	if in.reader != nil {
+		errs = append(errs, in.reader.Close())
+		<-in.done
+	}
+	for _, m := range []*ebpf.Map{in.traffic, in.remotes, in.execs} {
+		if m != nil {
+			errs = append(errs, m.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,11 @@
+//go:build linux
+
+package ebpf
+
+import "github.com/cilium/ebpf/asm"
+
+// kprobeArgs are the offsets of the first function arguments in pt_regs:
+// di, si, dx
+var kprobeArgs = []int16{112, 104, 96}
+
+const kprobeArgSize = asm.DWord
@@ -0,0 +1,12 @@
+//go:build linux
+
+package ebpf
+
+import "github.com/cilium/ebpf/asm"
+
+// kprobeArgs are the offsets of the first function arguments in pt_regs:
+// r0, r1, r2. ARM has no BPF trampolines, so traffic is always counted with
+// kprobes
+var kprobeArgs = []int16{0, 4, 8}
+
+const kprobeArgSize = asm.Word
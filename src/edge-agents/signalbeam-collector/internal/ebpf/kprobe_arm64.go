@@ -0,0 +1,11 @@
+//go:build linux
+
+package ebpf
+
+import "github.com/cilium/ebpf/asm"
+
+// kprobeArgs are the offsets of the first function arguments in pt_regs:
+// x0, x1, x2
+var kprobeArgs = []int16{0, 8, 16}
+
+const kprobeArgSize = asm.DWord
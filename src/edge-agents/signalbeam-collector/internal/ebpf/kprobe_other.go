@@ -0,0 +1,10 @@
+//go:build linux && !amd64 && !arm64 && !arm && !riscv64
+
+package ebpf
+
+import "github.com/cilium/ebpf/asm"
+
+// kprobeArgs is unknown here, so traffic is only counted with fentry
+var kprobeArgs []int16
+
+const kprobeArgSize = asm.DWord
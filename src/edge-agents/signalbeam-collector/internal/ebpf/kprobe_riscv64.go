@@ -0,0 +1,11 @@
+//go:build linux
+
+package ebpf
+
+import "github.com/cilium/ebpf/asm"
+
+// kprobeArgs are the offsets of the first function arguments in pt_regs:
+// a0, a1, a2
+var kprobeArgs = []int16{80, 88, 96}
+
+const kprobeArgSize = asm.DWord
@@ -0,0 +1,196 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// Violation is returned when a destination is not on the allowlist
+type Violation struct {
+	Address string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("egress to %s is not allowed by the outbound allowlist", v.Address)
+}
+
+// DialFunc opens a network connection, applying any outbound policy. Outputs,
+// probes and jobs reaching the network take one from the collector
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// rule is one allowlist entry. Exactly one of host or network is set; a
+// zero port matches any port
+type rule struct {
+	host    string // exact name or "*.suffix"
+	network *net.IPNet
+	port    int
+}
+
+// Policy restricts outbound connections to an allowlist of destinations
+type Policy struct {
+	rules      []rule
+	violations atomic.Int64
+}
+
+// New parses allowlist entries of the form "host", "host:port", "*.domain:port",
+// "ip", "cidr" or "cidr:port". A nil policy allows everything
+func New(entries []string) (*Policy, error) {
+	p := &Policy{}
+	for _, entry := range entries {
+		r, err := parseRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		p.rules = append(p.rules, r)
+	}
+	return p, nil
+}
+
+// Validate checks allowlist entries without building a policy
+func Validate(entries []string) error {
+	_, err := New(entries)
+	return err
+}
+
+// parseRule parses a single allowlist entry
+func parseRule(entry string) (rule, error) {
+	host, portStr, err := net.SplitHostPort(entry)
+	if err != nil {
+		host, portStr = entry, ""
+	}
+
+	var r rule
+	if portStr != "" && portStr != "*" {
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return rule{}, fmt.Errorf("invalid port in egress entry %q", entry)
+		}
+		r.port = port
+	}
+
+	switch {
+	case host == "":
+		return rule{}, fmt.Errorf("empty host in egress entry %q", entry)
+	case strings.Contains(host, "/"):
+		_, network, err := net.ParseCIDR(host)
+		if err != nil {
+			return rule{}, fmt.Errorf("invalid network in egress entry %q: %w", entry, err)
+		}
+		r.network = network
+	case net.ParseIP(host) != nil:
+		ip := net.ParseIP(host)
+		bits := 8 * len(ip.To4())
+		if bits == 0 {
+			bits = 128
+		} else {
+			ip = ip.To4()
+		}
+		r.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	default:
+		r.host = strings.ToLower(strings.TrimSuffix(host, "."))
+	}
+
+	return r, nil
+}
+
+// Dial connects to address if the allowlist permits it. Host names are
+// matched before resolution; otherwise every resolved IP is checked just
+// before the socket connects
+func (p *Policy) Dial(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	if p == nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	if p.allowedHost(host, port) {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	d := *dialer
+	d.Control = func(_, resolved string, _ syscall.RawConn) error {
+		ipStr, _, err := net.SplitHostPort(resolved)
+		if err != nil {
+			return err
+		}
+		if !p.allowedIP(net.ParseIP(ipStr), port) {
+			return &Violation{Address: address}
+		}
+		return nil
+	}
+
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		var v *Violation
+		if errors.As(err, &v) {
+			p.violations.Add(1)
+			return nil, v
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Check reports whether host:port may be contacted without dialing, for
+// clients that manage their own connections. Names are not resolved
+func (p *Policy) Check(host string, port int) error {
+	if p == nil {
+		return nil
+	}
+	if p.allowedHost(host, port) || p.allowedIP(net.ParseIP(host), port) {
+		return nil
+	}
+	p.violations.Add(1)
+	return &Violation{Address: net.JoinHostPort(host, strconv.Itoa(port))}
+}
+
+// Violations returns the number of blocked connection attempts
+func (p *Policy) Violations() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.violations.Load()
+}
+
+// allowedHost matches a host name against the name rules
+func (p *Policy) allowedHost(host string, port int) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, r := range p.rules {
+		if r.host == "" || (r.port != 0 && r.port != port) {
+			continue
+		}
+		if r.host == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(r.host, "*"); ok && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedIP matches an address against the IP and network rules
+func (p *Policy) allowedIP(ip net.IP, port int) bool {
+	if ip == nil {
+		return false
+	}
+	for _, r := range p.rules {
+		if r.network == nil || (r.port != 0 && r.port != port) {
+			continue
+		}
+		if r.network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
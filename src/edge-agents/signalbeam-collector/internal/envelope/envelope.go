@@ -0,0 +1,190 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Algorithm identifies the envelope format in published payloads
+const Algorithm = "aes-256-gcm+kw-aes-256-gcm"
+
+// Envelope is the encrypted payload published in place of plaintext JSON.
+// The payload is encrypted with a data key, which is itself wrapped with the
+// device key identified by KeyID.
+type Envelope struct {
+	Algorithm  string `json:"enc"`
+	KeyID      string `json:"kid"`
+	WrappedKey string `json:"wrapped_key"`
+	KeyNonce   string `json:"key_nonce"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// Key is a device key-encryption key
+type Key struct {
+	ID  string
+	Key []byte
+}
+
+// dataKey is a cached data key and its wrapped form
+type dataKey struct {
+	aead      cipher.AEAD
+	wrapped   []byte
+	keyNonce  []byte
+	createdAt time.Time
+}
+
+// Sealer encrypts payloads with rotating data keys wrapped by the active
+// device key
+type Sealer struct {
+	mu       sync.Mutex
+	active   Key
+	kek      cipher.AEAD
+	rotation time.Duration
+	current  *dataKey
+}
+
+// NewSealer creates a sealer using the given active device key. Data keys
+// are regenerated every rotation interval (0 generates one per payload).
+func NewSealer(active Key, rotation time.Duration) (*Sealer, error) {
+	kek, err := newAEAD(active.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device key %q: %w", active.ID, err)
+	}
+	return &Sealer{active: active, kek: kek, rotation: rotation}, nil
+}
+
+// LoadKey reads a 32-byte key stored as hex or base64 in a file
+func LoadKey(id, path string) (Key, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to read key file: %w", err)
+	}
+	text := strings.TrimSpace(string(raw))
+
+	key, err := hex.DecodeString(text)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return Key{}, fmt.Errorf("key file %s is neither hex nor base64", path)
+		}
+	}
+	if len(key) != 32 {
+		return Key{}, fmt.Errorf("key file %s must contain a 32-byte key, got %d bytes", path, len(key))
+	}
+
+	return Key{ID: id, Key: key}, nil
+}
+
+// Seal encrypts a payload and returns the JSON envelope
+func (s *Sealer) Seal(plaintext []byte) ([]byte, error) {
+	dk, err := s.dataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, dk.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	env := Envelope{
+		Algorithm:  Algorithm,
+		KeyID:      s.active.ID,
+		WrappedKey: base64.StdEncoding.EncodeToString(dk.wrapped),
+		KeyNonce:   base64.StdEncoding.EncodeToString(dk.keyNonce),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(dk.aead.Seal(nil, nonce, plaintext, []byte(s.active.ID))),
+	}
+	return json.Marshal(env)
+}
+
+// Open decrypts an envelope with the matching key from the keyring
+func Open(data []byte, keyring map[string][]byte) ([]byte, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("invalid envelope: %w", err)
+	}
+	if env.Algorithm != Algorithm {
+		return nil, fmt.Errorf("unsupported algorithm %q", env.Algorithm)
+	}
+
+	key, ok := keyring[env.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", env.KeyID)
+	}
+	kek, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([][]byte, 4)
+	for i, v := range []string{env.WrappedKey, env.KeyNonce, env.Nonce, env.Ciphertext} {
+		if fields[i], err = base64.StdEncoding.DecodeString(v); err != nil {
+			return nil, fmt.Errorf("invalid envelope encoding: %w", err)
+		}
+	}
+
+	rawKey, err := kek.Open(nil, fields[1], fields[0], []byte(env.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	aead, err := newAEAD(rawKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, fields[2], fields[3], []byte(env.KeyID))
+}
+
+// dataKey returns the current data key, rotating it when expired
+func (s *Sealer) dataKey() (*dataKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != nil && s.rotation > 0 && time.Since(s.current.createdAt) < s.rotation {
+		return s.current, nil
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	keyNonce := make([]byte, s.kek.NonceSize())
+	if _, err := rand.Read(keyNonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	s.current = &dataKey{
+		aead:      aead,
+		wrapped:   s.kek.Seal(nil, keyNonce, raw, []byte(s.active.ID)),
+		keyNonce:  keyNonce,
+		createdAt: time.Now(),
+	}
+	return s.current, nil
+}
+
+// newAEAD creates an AES-GCM cipher for a 256-bit key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
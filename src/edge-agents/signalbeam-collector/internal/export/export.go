@@ -0,0 +1,285 @@
+// Package export writes collected records as Parquet files for offline
+// analysis. Files are partitioned Hive-style by data type and UTC day
+// (type=metrics/date=2024-01-31/part-*.parquet) and hold one row per
+// numeric value
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/parquet"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/units"
+)
+
+// partStamp names files; it sorts chronologically
+const partStamp = "20060102T150405.000Z"
+
+// Record is a processed record as the collector publishes it
+type Record struct {
+	Type      string
+	DeviceID  string
+	Timestamp time.Time
+	Data      map[string]interface{}
+	Tags      map[string]string
+	Units     map[string]string // Unit per metric path pattern
+}
+
+// Options configure an exporter
+type Options struct {
+	// Dir receives the finished files
+	Dir string
+	// MaxRows per file; a partition reaching it is written right away
+	MaxRows int
+	Codec   parquet.Codec
+}
+
+// partition identifies the file a row goes to
+type partition struct {
+	dataType string
+	day      string
+}
+
+// rows buffers the columns of one partition
+type rows struct {
+	timestamps []int64
+	devices    []string
+	metrics    []string
+	values     []float64
+	units      []string
+	noUnit     []bool
+	tags       []string
+	noTags     []bool
+}
+
+// Exporter buffers rows per partition and writes them as Parquet files
+type Exporter struct {
+	opts Options
+
+	mu       sync.Mutex
+	buffered map[partition]*rows
+	count    int
+	written  int64
+	failed   int64
+	seq      int64 // Keeps names unique within a millisecond
+}
+
+// New creates an exporter
+func New(opts Options) *Exporter {
+	return &Exporter{opts: opts, buffered: make(map[partition]*rows)}
+}
+
+// Add buffers a record's numeric values and returns how many rows it added.
+// Partitions that reach MaxRows are written before Add returns
+func (e *Exporter) Add(r Record) (int, error) {
+	ts := r.Timestamp.UTC()
+	p := partition{dataType: r.Type, day: ts.Format("2006-01-02")}
+
+	var tags string
+	if len(r.Tags) > 0 {
+		data, err := json.Marshal(r.Tags)
+		if err != nil {
+			return 0, err
+		}
+		tags = string(data)
+	}
+
+	type value struct {
+		metric string
+		v      float64
+		unit   string
+	}
+	var values []value
+	units.Walk(r.Data, nil, func(path []string, _ map[string]interface{}, _ string, v interface{}) {
+		f, ok := units.ToFloat(v)
+		if !ok {
+			return
+		}
+		values = append(values, value{metric: strings.Join(path, "."), v: f, unit: unitOf(r.Units, path)})
+	})
+	sort.Slice(values, func(i, j int) bool { return values[i].metric < values[j].metric })
+
+	e.mu.Lock()
+	b := e.buffered[p]
+	if b == nil {
+		b = &rows{}
+		e.buffered[p] = b
+	}
+	for _, v := range values {
+		b.timestamps = append(b.timestamps, ts.UnixMilli())
+		b.devices = append(b.devices, r.DeviceID)
+		b.metrics = append(b.metrics, v.metric)
+		b.values = append(b.values, v.v)
+		b.units = append(b.units, v.unit)
+		b.noUnit = append(b.noUnit, v.unit == "")
+		b.tags = append(b.tags, tags)
+		b.noTags = append(b.noTags, tags == "")
+	}
+	e.count += len(values)
+	var full *rows
+	if len(b.timestamps) >= e.opts.MaxRows {
+		full = b
+		e.count -= len(b.timestamps)
+		delete(e.buffered, p)
+	}
+	e.mu.Unlock()
+
+	if full != nil {
+		return len(values), e.write(p, full)
+	}
+	return len(values), nil
+}
+
+// unitOf returns the unit of the first pattern matching path
+func unitOf(patterns map[string]string, path []string) string {
+	for pattern, unit := range patterns {
+		if units.Match(pattern, path) {
+			return unit
+		}
+	}
+	return ""
+}
+
+// Flush writes every buffered partition. Partitions that fail to write are
+// dropped and counted, so a broken disk cannot grow the buffer
+func (e *Exporter) Flush() error {
+	e.mu.Lock()
+	buffered := e.buffered
+	e.buffered = make(map[partition]*rows)
+	e.count = 0
+	e.mu.Unlock()
+
+	var first error
+	for p, b := range buffered {
+		if err := e.write(p, b); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// write encodes a partition to a new file, written under a temporary name
+// and renamed once complete
+func (e *Exporter) write(p partition, b *rows) error {
+	columns := []parquet.Column{
+		{Name: "timestamp", Kind: parquet.Timestamp, Int64s: b.timestamps},
+		{Name: "device_id", Kind: parquet.String, Strings: b.devices},
+		{Name: "metric", Kind: parquet.String, Strings: b.metrics},
+		{Name: "value", Kind: parquet.Double, Doubles: b.values},
+		{Name: "unit", Kind: parquet.String, Optional: true, Strings: b.units, Nulls: b.noUnit},
+		{Name: "tags", Kind: parquet.String, Optional: true, Strings: b.tags, Nulls: b.noTags},
+	}
+
+	e.mu.Lock()
+	e.seq++
+	name := fmt.Sprintf("part-%s-%d.parquet", time.Now().UTC().Format(partStamp), e.seq)
+	e.mu.Unlock()
+
+	var buf bytes.Buffer
+	err := parquet.Write(&buf, columns, e.opts.Codec, "signalbeam-collector")
+	if err == nil {
+		err = e.save(p, name, buf.Bytes())
+	}
+
+	e.mu.Lock()
+	if err != nil {
+		e.failed++
+	} else {
+		e.written++
+	}
+	e.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to export %s rows for %s: %w", p.dataType, p.day, err)
+	}
+	return nil
+}
+
+// save writes a file into its partition directory
+func (e *Exporter) save(p partition, name string, data []byte) error {
+	dir := filepath.Join(e.opts.Dir, "type="+p.dataType, "date="+p.day)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".part-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Upload hands every finished file below dir to put, keyed by its slash
+// separated path relative to dir, then removes it or moves it below keepDir
+// when set. Files that fail to upload stay for the next call
+func Upload(dir, keepDir string, put func(key string, data []byte) error) (int, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() && strings.HasSuffix(path, ".parquet") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(files)
+
+	uploaded := 0
+	for _, path := range files {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return uploaded, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return uploaded, err
+		}
+		if err := put(filepath.ToSlash(rel), data); err != nil {
+			return uploaded, err
+		}
+		uploaded++
+
+		if keepDir == "" {
+			err = os.Remove(path)
+		} else if err = os.MkdirAll(filepath.Dir(filepath.Join(keepDir, rel)), 0750); err == nil {
+			err = os.Rename(path, filepath.Join(keepDir, rel))
+		}
+		if err != nil {
+			return uploaded, err
+		}
+	}
+	return uploaded, nil
+}
+
+// Map reports buffered rows and file counts for the heartbeat
+func (e *Exporter) Map() map[string]interface{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return map[string]interface{}{
+		"buffered_rows": e.count,
+		"files_written": e.written,
+		"files_failed":  e.failed,
+	}
+}
@@ -0,0 +1,394 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode"
+)
+
+// Expr is a parsed arithmetic expression over named variables. It supports
+// + - * /, unary minus, parentheses, numbers and the functions min, max and
+// abs. Comparisons (< <= > >= == !=) and the logical operators && and ||
+// yield 1 for true and 0 for false, so an expression can serve as a
+// condition. Names may be dotted paths such as cpu.usage_percent
+type Expr struct {
+	source string
+	root   node
+	vars   map[string]bool
+}
+
+// node is an element of the expression tree
+type node interface {
+	eval(vars map[string]float64) (float64, error)
+}
+
+type number float64
+
+type variable string
+
+type unary struct {
+	x node
+}
+
+type binary struct {
+	op   string
+	l, r node
+}
+
+type call struct {
+	fn   string
+	args []node
+}
+
+// Parse parses an expression such as "good / total * 100"
+func Parse(s string) (*Expr, error) {
+	p := &parser{src: s, vars: make(map[string]bool)}
+	p.next()
+
+	root, err := p.expr()
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", s, err)
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("expression %q: unexpected %q", s, p.tok)
+	}
+
+	return &Expr{source: s, root: root, vars: p.vars}, nil
+}
+
+// String returns the expression source
+func (e *Expr) String() string {
+	return e.source
+}
+
+// True evaluates the expression as a condition: any result but 0 holds
+func (e *Expr) True(vars map[string]float64) (bool, error) {
+	v, err := e.Eval(vars)
+	return v != 0, err
+}
+
+// Vars returns the variable names referenced by the expression
+func (e *Expr) Vars() []string {
+	names := make([]string, 0, len(e.vars))
+	for name := range e.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Eval evaluates the expression. Missing variables, division by zero and
+// non-finite results are errors
+func (e *Expr) Eval(vars map[string]float64) (float64, error) {
+	v, err := e.root.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("result is not finite")
+	}
+	return v, nil
+}
+
+func (n number) eval(map[string]float64) (float64, error) {
+	return float64(n), nil
+}
+
+func (v variable) eval(vars map[string]float64) (float64, error) {
+	x, ok := vars[string(v)]
+	if !ok {
+		return 0, fmt.Errorf("missing value for %s", string(v))
+	}
+	return x, nil
+}
+
+func (u unary) eval(vars map[string]float64) (float64, error) {
+	x, err := u.x.eval(vars)
+	return -x, err
+}
+
+func (b binary) eval(vars map[string]float64) (float64, error) {
+	l, err := b.l.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	// The right side of a decided && or || is not evaluated, so it may
+	// refer to values that are missing
+	switch {
+	case b.op == "&&" && l == 0:
+		return 0, nil
+	case b.op == "||" && l != 0:
+		return 1, nil
+	}
+	r, err := b.r.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case "<":
+		return truth(l < r), nil
+	case "<=":
+		return truth(l <= r), nil
+	case ">":
+		return truth(l > r), nil
+	case ">=":
+		return truth(l >= r), nil
+	case "==":
+		return truth(l == r), nil
+	case "!=":
+		return truth(l != r), nil
+	default: // && and ||
+		return truth(r != 0), nil
+	}
+}
+
+// truth returns 1 for true and 0 for false
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c call) eval(vars map[string]float64) (float64, error) {
+	values := make([]float64, len(c.args))
+	for i, arg := range c.args {
+		v, err := arg.eval(vars)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = v
+	}
+
+	switch c.fn {
+	case "abs":
+		return math.Abs(values[0]), nil
+	case "min":
+		result := values[0]
+		for _, v := range values[1:] {
+			result = math.Min(result, v)
+		}
+		return result, nil
+	default:
+		result := values[0]
+		for _, v := range values[1:] {
+			result = math.Max(result, v)
+		}
+		return result, nil
+	}
+}
+
+// twoCharOps are the operators of two characters
+var twoCharOps = map[string]bool{"<=": true, ">=": true, "==": true, "!=": true, "&&": true, "||": true}
+
+// parser is a recursive descent parser over a simple tokenizer
+type parser struct {
+	src  string
+	pos  int
+	tok  string
+	vars map[string]bool
+}
+
+// next advances to the next token; tok is empty at the end of input
+func (p *parser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return
+	}
+
+	start := p.pos
+	ch := rune(p.src[p.pos])
+	switch {
+	case unicode.IsDigit(ch) || ch == '.':
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+	case unicode.IsLetter(ch) || ch == '_':
+		for p.pos < len(p.src) && (unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '_' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+	case p.pos+1 < len(p.src) && twoCharOps[p.src[p.pos:p.pos+2]]:
+		p.pos += 2
+	default:
+		p.pos++
+	}
+	p.tok = p.src[start:p.pos]
+}
+
+// expr := and ("||" and)*
+func (p *parser) expr() (node, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "||" {
+		p.next()
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+// and := comparison ("&&" comparison)*
+func (p *parser) and() (node, error) {
+	l, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "&&" {
+		p.next()
+		r, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: "&&", l: l, r: r}
+	}
+	return l, nil
+}
+
+// comparison := sum [("<" | "<=" | ">" | ">=" | "==" | "!=") sum]
+func (p *parser) comparison() (node, error) {
+	l, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.tok; op {
+	case "<", "<=", ">", ">=", "==", "!=":
+		p.next()
+		r, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		return binary{op: op, l: l, r: r}, nil
+	}
+	return l, nil
+}
+
+// sum := term (("+" | "-") term)*
+func (p *parser) sum() (node, error) {
+	l, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok
+		p.next()
+		r, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+// term := factor (("*" | "/") factor)*
+func (p *parser) term() (node, error) {
+	l, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "*" || p.tok == "/" {
+		op := p.tok
+		p.next()
+		r, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+// factor := number | name | name "(" args ")" | "(" expr ")" | "-" factor
+func (p *parser) factor() (node, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case tok == "-":
+		p.next()
+		x, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return unary{x: x}, nil
+	case tok == "(":
+		p.next()
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.next()
+		return x, nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tok)
+		}
+		p.next()
+		return number(v), nil
+	case unicode.IsLetter(rune(tok[0])) || tok[0] == '_':
+		p.next()
+		if p.tok != "(" {
+			p.vars[tok] = true
+			return variable(tok), nil
+		}
+		return p.call(tok)
+	default:
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+}
+
+// call parses the arguments of a function call
+func (p *parser) call(fn string) (node, error) {
+	if fn != "min" && fn != "max" && fn != "abs" {
+		return nil, fmt.Errorf("unknown function %s", fn)
+	}
+	p.next()
+
+	var args []node
+	for {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.tok != "," {
+			break
+		}
+		p.next()
+	}
+	if p.tok != ")" {
+		return nil, fmt.Errorf("missing ) after %s arguments", fn)
+	}
+	p.next()
+
+	if fn == "abs" && len(args) != 1 {
+		return nil, fmt.Errorf("abs takes one argument")
+	}
+	return call{fn: fn, args: args}, nil
+}
@@ -0,0 +1,17 @@
+//go:build !fips
+
+package fips
+
+import "crypto/tls"
+
+// Enabled reports whether this binary was built in FIPS mode
+const Enabled = false
+
+// Check verifies the FIPS module is active; always nil in standard builds
+func Check() error {
+	return nil
+}
+
+// Restrict limits a TLS config to FIPS-approved settings; a no-op in
+// standard builds
+func Restrict(cfg *tls.Config) {}
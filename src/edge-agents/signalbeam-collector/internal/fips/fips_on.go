@@ -0,0 +1,36 @@
+//go:build fips && go1.24
+
+package fips
+
+import (
+	"crypto/fips140"
+	"crypto/tls"
+	"errors"
+)
+
+// Enabled reports whether this binary was built in FIPS mode
+const Enabled = true
+
+// Check verifies the Go FIPS 140-3 module is active. It fails when the build
+// was made without GOFIPS140 or FIPS mode was disabled with GODEBUG
+func Check() error {
+	if !fips140.Enabled() {
+		return errors.New("FIPS build but FIPS 140-3 mode is not enabled (build with GOFIPS140 and do not set GODEBUG=fips140=off)")
+	}
+	return nil
+}
+
+// Restrict limits a TLS config to TLS 1.2+ with FIPS-approved cipher
+// suites and curves
+func Restrict(cfg *tls.Config) {
+	if cfg.MinVersion < tls.VersionTLS12 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	cfg.CipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+	cfg.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+}
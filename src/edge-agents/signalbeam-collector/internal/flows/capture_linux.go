@@ -0,0 +1,90 @@
+//go:build linux
+
+package flows
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Capture reads packet headers from one interface through a packet socket.
+// It needs CAP_NET_RAW
+type Capture struct {
+	iface string
+	fd    int
+}
+
+// Open starts capturing on an interface
+func Open(iface string) (*Capture, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	// A datagram packet socket strips the link layer header, so packets
+	// start at the IP header whatever the interface type
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("open packet socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifi.Index}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("bind to %s: %w", iface, err)
+	}
+	// Wake up every second to notice cancellation
+	tv := unix.Timeval{Sec: 1}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &Capture{iface: iface, fd: fd}, nil
+}
+
+// Run counts packets in the table until the context ends
+func (c *Capture) Run(ctx context.Context, t *Table) error {
+	buf := make([]byte, snapLen)
+	lastStats := time.Now()
+	for ctx.Err() == nil {
+		if time.Since(lastStats) >= time.Second {
+			// The kernel resets the statistics on every read
+			if stats, err := unix.GetsockoptTpacketStats(c.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS); err == nil {
+				t.addDrops(uint64(stats.Drops))
+			}
+			lastStats = time.Now()
+		}
+		// MSG_TRUNC returns the full length of the packet, of which only
+		// the headers were copied
+		n, from, err := unix.Recvfrom(c.fd, buf, unix.MSG_TRUNC)
+		switch {
+		case errors.Is(err, unix.EAGAIN), errors.Is(err, unix.EINTR):
+			continue
+		case err != nil:
+			return fmt.Errorf("read from %s: %w", c.iface, err)
+		}
+		ll, ok := from.(*unix.SockaddrLinklayer)
+		if !ok || ll.Pkttype == unix.PACKET_OTHERHOST {
+			continue
+		}
+		if k, ok := parse(c.iface, buf[:min(n, snapLen)], ll.Pkttype == unix.PACKET_OUTGOING); ok {
+			t.Add(k, ll.Pkttype == unix.PACKET_OUTGOING, n)
+		}
+	}
+	return nil
+}
+
+// Close stops capturing
+func (c *Capture) Close() error {
+	return unix.Close(c.fd)
+}
+
+// htons converts to network byte order
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package flows
+
+import "context"
+
+// Capture is unavailable on this platform
+type Capture struct{}
+
+// Open reports capture as unsupported
+func Open(string) (*Capture, error) {
+	return nil, ErrUnsupported
+}
+
+// Run does nothing
+func (c *Capture) Run(context.Context, *Table) error {
+	return ErrUnsupported
+}
+
+// Close does nothing
+func (c *Capture) Close() error {
+	return nil
+}
@@ -0,0 +1,212 @@
+// Package flows summarizes network traffic by protocol, peer and port from
+// packet headers. At most the first 64 bytes of a packet are copied and
+// nothing past the ports is read, so payloads are never inspected
+package flows
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ErrUnsupported is returned where packets cannot be captured
+var ErrUnsupported = errors.New("packet capture is only available on Linux")
+
+// snapLen is the most of a packet read: an IPv6 header and the ports
+const snapLen = 64
+
+// maxEntries bounds the flows counted between reports. Traffic of further
+// flows is summed under Other
+const maxEntries = 10000
+
+// Key identifies a flow
+type Key struct {
+	Interface string
+	Protocol  string     // tcp, udp, icmp, icmpv6 or the IP protocol number
+	Peer      netip.Addr // The remote address
+	// Port is the service port: the lower of the two ports, which is the
+	// listening side for all but unusual services. 0 without ports
+	Port uint16
+}
+
+// Counters is the traffic of a flow in each direction
+type Counters struct {
+	PacketsIn  uint64
+	PacketsOut uint64
+	BytesIn    uint64
+	BytesOut   uint64
+}
+
+// add counts a packet
+func (c *Counters) add(outgoing bool, size int) {
+	if outgoing {
+		c.PacketsOut++
+		c.BytesOut += uint64(size)
+	} else {
+		c.PacketsIn++
+		c.BytesIn += uint64(size)
+	}
+}
+
+// merge adds the counts of another flow
+func (c *Counters) merge(o Counters) {
+	c.PacketsIn += o.PacketsIn
+	c.PacketsOut += o.PacketsOut
+	c.BytesIn += o.BytesIn
+	c.BytesOut += o.BytesOut
+}
+
+// bytes returns the traffic in both directions
+func (c Counters) bytes() uint64 {
+	return c.BytesIn + c.BytesOut
+}
+
+// Map returns the counters as telemetry fields
+func (c Counters) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"packets_in":  c.PacketsIn,
+		"packets_out": c.PacketsOut,
+		"bytes_in":    c.BytesIn,
+		"bytes_out":   c.BytesOut,
+	}
+}
+
+// Flow is the traffic of one flow over a report interval
+type Flow struct {
+	Key
+	Counters
+}
+
+// Map returns the flow as telemetry fields
+func (f Flow) Map() map[string]interface{} {
+	m := f.Counters.Map()
+	m["interface"] = f.Interface
+	m["protocol"] = f.Protocol
+	m["peer"] = f.Peer.String()
+	if f.Port != 0 {
+		m["port"] = f.Port
+	}
+	return m
+}
+
+// Summary is the traffic seen since the last report
+type Summary struct {
+	Flows []Flow   // Largest first
+	Other Counters // Flows beyond the report or table limits
+	Total Counters
+	Drops uint64 // Packets the kernel dropped before they were counted
+}
+
+// Table counts packets by flow between reports
+type Table struct {
+	mu    sync.Mutex
+	flows map[Key]*Counters
+	other Counters
+	drops uint64
+}
+
+// NewTable creates an empty table
+func NewTable() *Table {
+	return &Table{flows: make(map[Key]*Counters)}
+}
+
+// Add counts a packet of a flow
+func (t *Table) Add(k Key, outgoing bool, size int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.flows[k]
+	if c == nil {
+		if len(t.flows) >= maxEntries {
+			t.other.add(outgoing, size)
+			return
+		}
+		c = &Counters{}
+		t.flows[k] = c
+	}
+	c.add(outgoing, size)
+}
+
+// addDrops counts packets lost by a capture
+func (t *Table) addDrops(n uint64) {
+	t.mu.Lock()
+	t.drops += n
+	t.mu.Unlock()
+}
+
+// Drain returns the largest flows counted since the last call, up to limit,
+// and starts counting anew
+func (t *Table) Drain(limit int) Summary {
+	t.mu.Lock()
+	flows, other, drops := t.flows, t.other, t.drops
+	t.flows, t.other, t.drops = make(map[Key]*Counters, len(flows)), Counters{}, 0
+	t.mu.Unlock()
+
+	s := Summary{Other: other, Total: other, Drops: drops}
+	for k, c := range flows {
+		s.Flows = append(s.Flows, Flow{Key: k, Counters: *c})
+		s.Total.merge(*c)
+	}
+	sort.Slice(s.Flows, func(i, j int) bool {
+		return s.Flows[i].bytes() > s.Flows[j].bytes()
+	})
+	if len(s.Flows) > limit {
+		for _, f := range s.Flows[limit:] {
+			s.Other.merge(f.Counters)
+		}
+		s.Flows = s.Flows[:limit]
+	}
+	return s
+}
+
+// parse reads the flow of an IP packet. Outgoing packets have the peer as
+// destination
+func parse(iface string, packet []byte, outgoing bool) (Key, bool) {
+	k := Key{Interface: iface}
+	var src, dst netip.Addr
+	var proto byte
+	var transport []byte
+
+	switch {
+	case len(packet) >= 20 && packet[0]>>4 == 4:
+		ihl := int(packet[0]&0x0f) * 4
+		proto = packet[9]
+		src = netip.AddrFrom4([4]byte(packet[12:16]))
+		dst = netip.AddrFrom4([4]byte(packet[16:20]))
+		// Only the first fragment carries the ports
+		if offset := binary.BigEndian.Uint16(packet[6:8]) & 0x1fff; offset == 0 && ihl >= 20 && len(packet) > ihl {
+			transport = packet[ihl:]
+		}
+	case len(packet) >= 40 && packet[0]>>4 == 6:
+		proto = packet[6]
+		src = netip.AddrFrom16([16]byte(packet[8:24]))
+		dst = netip.AddrFrom16([16]byte(packet[24:40]))
+		transport = packet[40:]
+	default:
+		return k, false
+	}
+
+	k.Peer = src
+	if outgoing {
+		k.Peer = dst
+	}
+	switch proto {
+	case 1:
+		k.Protocol = "icmp"
+	case 58:
+		k.Protocol = "icmpv6"
+	case 6, 17:
+		k.Protocol = "tcp"
+		if proto == 17 {
+			k.Protocol = "udp"
+		}
+		if len(transport) >= 4 {
+			k.Port = min(binary.BigEndian.Uint16(transport[0:2]), binary.BigEndian.Uint16(transport[2:4]))
+		}
+	default:
+		k.Protocol = strconv.Itoa(int(proto))
+	}
+	return k, true
+}
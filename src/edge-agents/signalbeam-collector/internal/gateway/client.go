@@ -0,0 +1,587 @@
+// Package gateway is a gRPC transport to the Edge Gateway's Telemetry
+// service (proto/signalbeam/edge/v1/telemetry.proto)
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/mqtt5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	uploadMethod    = "/signalbeam.edge.v1.Telemetry/Upload"
+	subscribeMethod = "/signalbeam.edge.v1.Telemetry/Subscribe"
+)
+
+var (
+	uploadDesc    = &grpc.StreamDesc{StreamName: "Upload", ClientStreams: true, ServerStreams: true}
+	subscribeDesc = &grpc.StreamDesc{StreamName: "Subscribe", ServerStreams: true}
+
+	errNotConnected = errors.New("not connected")
+)
+
+// Options configure the connection to the gateway
+type Options struct {
+	// Address is the gateway's host:port
+	Address string
+
+	// Token is sent as a bearer token with every call
+	Token string
+
+	// DeviceID is sent in the x-signalbeam-device-id header
+	DeviceID string
+
+	// TLS secures the connection; nil connects in plaintext
+	TLS *tls.Config
+
+	// Dial opens the TCP connection; defaults to a plain dialer
+	Dial func(ctx context.Context, address string) (net.Conn, error)
+
+	// ReconnectBackoff returns the delay before reconnect attempt n, counted
+	// from 0 after the connection drops
+	ReconnectBackoff func(n int) time.Duration
+}
+
+// Client streams messages to the gateway, implementing the paho MQTT client
+// interface so the collector can use it in place of a broker connection. It
+// runs the connect, connection lost and reconnecting handlers of the client
+// options. Publishes with QoS above 0 complete when the gateway acks them;
+// subscriptions are served by the gateway's Subscribe call
+type Client struct {
+	opts   *mqtt.ClientOptions
+	reader mqtt.ClientOptionsReader
+	gw     Options
+
+	sendMu sync.Mutex // Serializes sends on the upload stream
+
+	mu        sync.Mutex
+	conn      *grpc.ClientConn
+	ctx       context.Context // Lives until Disconnect
+	cancel    context.CancelFunc
+	upload    grpc.ClientStream
+	closeConn context.CancelFunc // Ends the current upload and its subscriptions
+	connCtx   context.Context
+	connected bool
+	seq       uint64
+	pending   map[uint64]*token
+	routes    map[string]mqtt.MessageHandler
+	subs      []*subscription
+}
+
+// subscription is a Subscribe call covering some topic filters
+type subscription struct {
+	filters map[string]bool
+	cancel  context.CancelFunc
+}
+
+// NewClient creates a gateway client from paho client options
+func NewClient(opts *mqtt.ClientOptions, gw Options) *Client {
+	return &Client{
+		opts:    opts,
+		reader:  mqtt.NewClient(opts).OptionsReader(),
+		gw:      gw,
+		pending: make(map[uint64]*token),
+		routes:  make(map[string]mqtt.MessageHandler),
+	}
+}
+
+// IsConnected reports whether the upload stream is open
+func (c *Client) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+// IsConnectionOpen reports whether the upload stream is open
+func (c *Client) IsConnectionOpen() bool {
+	return c.IsConnected()
+}
+
+// OptionsReader returns the options the client was created from
+func (c *Client) OptionsReader() mqtt.ClientOptionsReader {
+	return c.reader
+}
+
+// Connect opens the upload stream. The token fails if the first attempt
+// fails; once connected the client reopens the stream after it drops
+func (c *Client) Connect() mqtt.Token {
+	t := newToken()
+
+	creds := insecure.NewCredentials()
+	if c.gw.TLS != nil {
+		creds = credentials.NewTLS(c.gw.TLS)
+	}
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})),
+		grpc.WithUserAgent("signalbeam-collector"),
+	}
+	target := c.gw.Address
+	if c.gw.Dial != nil {
+		// Hand the address to the dialer unresolved, so it sees the host
+		// name as configured
+		target = "passthrough:///" + target
+		dialOpts = append(dialOpts, grpc.WithContextDialer(c.gw.Dial))
+	}
+	conn, err := grpc.NewClient(target, dialOpts...)
+	if err != nil {
+		t.complete(err)
+		return t
+	}
+
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(context.Background(),
+		"authorization", "Bearer "+c.gw.Token,
+		"x-signalbeam-device-id", c.gw.DeviceID,
+	))
+	c.mu.Lock()
+	c.conn, c.ctx, c.cancel = conn, ctx, cancel
+	c.mu.Unlock()
+
+	go func() {
+		if err := c.open(); err != nil {
+			cancel()
+			conn.Close()
+			t.complete(err)
+			return
+		}
+		t.complete(nil)
+	}()
+	return t
+}
+
+// open starts an upload stream and waits for the gateway to accept it
+func (c *Client) open() error {
+	c.mu.Lock()
+	conn, parent := c.conn, c.ctx
+	c.mu.Unlock()
+
+	if c.opts.OnConnectAttempt != nil {
+		c.opts.OnConnectAttempt(&url.URL{Scheme: "grpc", Host: c.gw.Address}, c.gw.TLS)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	stream, err := conn.NewStream(ctx, uploadDesc, uploadMethod)
+	if err == nil {
+		err = awaitAccept(stream, c.opts.ConnectTimeout)
+	}
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	c.mu.Lock()
+	c.upload, c.connCtx, c.closeConn = stream, ctx, cancel
+	c.connected = true
+	c.seq = 0
+	c.mu.Unlock()
+
+	go c.receive(stream)
+	if c.opts.OnConnect != nil {
+		go c.opts.OnConnect(c)
+	}
+	return nil
+}
+
+// awaitAccept waits for the Ack with seq 0 the gateway sends once it accepts
+// an upload stream. Rejections, e.g. of the token, fail the stream instead
+func awaitAccept(stream grpc.ClientStream, timeout time.Duration) error {
+	return await(timeout, func() error {
+		var ack Ack
+		if err := stream.RecvMsg(&ack); err != nil {
+			return err
+		}
+		if ack.Seq != 0 || ack.Error != "" {
+			return fmt.Errorf("unexpected handshake from gateway: seq %d %s", ack.Seq, ack.Error)
+		}
+		return nil
+	})
+}
+
+// awaitHeader waits for the response headers the gateway sends once it
+// accepts a Subscribe call
+func awaitHeader(stream grpc.ClientStream, timeout time.Duration) error {
+	return await(timeout, func() error {
+		_, err := stream.Header()
+		return err
+	})
+}
+
+// await runs fn with a timeout
+func await(timeout time.Duration, fn func() error) error {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("connection not established within %s", timeout)
+	}
+}
+
+// receive settles pending publishes as acks arrive. When the stream ends it
+// fails what is still pending and reconnects
+func (c *Client) receive(stream grpc.ClientStream) {
+	for {
+		var ack Ack
+		err := stream.RecvMsg(&ack)
+		if err != nil {
+			c.connectionLost(stream, err)
+			return
+		}
+		if ack.Error != "" {
+			c.settle(ack.Seq, fmt.Errorf("rejected by gateway: %s", ack.Error))
+		} else {
+			c.settle(ack.Seq, nil)
+		}
+	}
+}
+
+// connectionLost closes the dropped stream's connection state and starts
+// reconnecting unless the client was disconnected
+func (c *Client) connectionLost(stream grpc.ClientStream, err error) {
+	c.mu.Lock()
+	if c.upload != stream {
+		c.mu.Unlock()
+		return
+	}
+	wasConnected := c.connected
+	c.connected = false
+	c.upload = nil
+	c.closeConn()
+	pending := c.pending
+	c.pending = make(map[uint64]*token)
+	ctx := c.ctx
+	c.mu.Unlock()
+
+	for _, t := range pending {
+		t.complete(fmt.Errorf("connection lost: %w", err))
+	}
+	if !wasConnected || ctx.Err() != nil {
+		return
+	}
+	if c.opts.OnConnectionLost != nil {
+		go c.opts.OnConnectionLost(c, err)
+	}
+	go c.reconnect(ctx)
+}
+
+// reconnect reopens the upload stream with backoff until it succeeds or the
+// client is disconnected
+func (c *Client) reconnect(ctx context.Context) {
+	for attempt := 0; ; attempt++ {
+		delay := time.Second << min(attempt, 6)
+		if c.gw.ReconnectBackoff != nil {
+			delay = c.gw.ReconnectBackoff(attempt)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if c.opts.OnReconnecting != nil {
+			c.opts.OnReconnecting(c, c.opts)
+		}
+		if err := c.open(); err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Disconnect closes the upload stream, waiting up to quiesce milliseconds
+// for outstanding acks
+func (c *Client) Disconnect(quiesce uint) {
+	c.mu.Lock()
+	cancel, conn, stream := c.cancel, c.conn, c.upload
+	c.connected = false
+	c.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+
+	if stream != nil {
+		c.sendMu.Lock()
+		_ = stream.CloseSend()
+		c.sendMu.Unlock()
+
+		deadline := time.Now().Add(time.Duration(quiesce) * time.Millisecond)
+		for time.Now().Before(deadline) {
+			c.mu.Lock()
+			n := len(c.pending)
+			c.mu.Unlock()
+			if n == 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	cancel()
+	conn.Close()
+}
+
+// Publish sends a message on the upload stream. With QoS above 0 the token
+// completes when the gateway acks the message
+func (c *Client) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	t := newToken()
+
+	var body []byte
+	switch p := payload.(type) {
+	case []byte:
+		body = p
+	case string:
+		body = []byte(p)
+	default:
+		t.complete(fmt.Errorf("unknown payload type %T", payload))
+		return t
+	}
+
+	c.mu.Lock()
+	if !c.connected {
+		c.mu.Unlock()
+		t.complete(errNotConnected)
+		return t
+	}
+	c.seq++
+	seq, stream := c.seq, c.upload
+	if qos > 0 {
+		c.pending[seq] = t
+	}
+	c.mu.Unlock()
+
+	c.sendMu.Lock()
+	err := stream.SendMsg(&Record{Seq: seq, Topic: topic, QoS: qos, Retained: retained, Payload: body})
+	c.sendMu.Unlock()
+
+	switch {
+	case qos == 0:
+		t.complete(err)
+	case err != nil:
+		c.settle(seq, err)
+	default:
+		go func() {
+			if !t.WaitTimeout(c.timeout()) {
+				c.settle(seq, fmt.Errorf("no ack from gateway within %s", c.timeout()))
+			}
+		}()
+	}
+	return t
+}
+
+// settle completes a pending publish once
+func (c *Client) settle(seq uint64, err error) {
+	c.mu.Lock()
+	t, ok := c.pending[seq]
+	delete(c.pending, seq)
+	c.mu.Unlock()
+	if ok {
+		t.complete(err)
+	}
+}
+
+// Subscribe subscribes to a topic filter
+func (c *Client) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return c.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+// SubscribeMultiple asks the gateway for messages on topic filters. The
+// subscription ends with the connection and is renewed by the connect
+// handler, as with a clean MQTT session
+func (c *Client) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	t := newToken()
+
+	req := &SubscribeRequest{}
+	sub := &subscription{filters: make(map[string]bool, len(filters))}
+	c.mu.Lock()
+	for topic := range filters {
+		if callback != nil {
+			c.routes[topic] = callback
+		}
+		req.Filters = append(req.Filters, topic)
+		sub.filters[topic] = true
+	}
+	connCtx, conn := c.connCtx, c.conn
+	connected := c.connected
+	c.mu.Unlock()
+	if !connected {
+		t.complete(errNotConnected)
+		return t
+	}
+
+	ctx, cancel := context.WithCancel(connCtx)
+	sub.cancel = cancel
+	go func() {
+		stream, err := conn.NewStream(ctx, subscribeDesc, subscribeMethod)
+		if err == nil {
+			err = stream.SendMsg(req)
+		}
+		if err == nil {
+			err = stream.CloseSend()
+		}
+		if err == nil {
+			err = awaitHeader(stream, c.timeout())
+		}
+		if err != nil {
+			cancel()
+			t.complete(fmt.Errorf("subscription to %v failed: %w", req.Filters, err))
+			return
+		}
+
+		c.mu.Lock()
+		c.subs = append(c.subs, sub)
+		c.mu.Unlock()
+		t.complete(nil)
+
+		for {
+			var msg Message
+			if err := stream.RecvMsg(&msg); err != nil {
+				cancel()
+				return
+			}
+			c.dispatch(&msg)
+		}
+	}()
+	return t
+}
+
+// Unsubscribe removes handlers and ends Subscribe calls left without filters
+func (c *Client) Unsubscribe(topics ...string) mqtt.Token {
+	t := newToken()
+
+	c.mu.Lock()
+	kept := c.subs[:0]
+	for _, sub := range c.subs {
+		for _, topic := range topics {
+			delete(sub.filters, topic)
+		}
+		if len(sub.filters) == 0 {
+			sub.cancel()
+			continue
+		}
+		kept = append(kept, sub)
+	}
+	c.subs = kept
+	for _, topic := range topics {
+		delete(c.routes, topic)
+	}
+	c.mu.Unlock()
+
+	t.complete(nil)
+	return t
+}
+
+// AddRoute registers a handler for a topic filter without subscribing
+func (c *Client) AddRoute(topic string, callback mqtt.MessageHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.routes[topic] = callback
+}
+
+// dispatch routes a received message to the handlers of matching filters
+func (c *Client) dispatch(m *Message) {
+	c.mu.Lock()
+	var handlers []mqtt.MessageHandler
+	for filter, handler := range c.routes {
+		if mqtt5.Match(filter, m.Topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(handlers) == 0 && c.opts.DefaultPublishHandler != nil {
+		handlers = append(handlers, c.opts.DefaultPublishHandler)
+	}
+	msg := &message{msg: m}
+	for _, h := range handlers {
+		h(c, msg)
+	}
+}
+
+// timeout bounds acks and subscribe round trips
+func (c *Client) timeout() time.Duration {
+	if c.opts.WriteTimeout > 0 {
+		return c.opts.WriteTimeout
+	}
+	if c.opts.ConnectTimeout > 0 {
+		return c.opts.ConnectTimeout
+	}
+	return 30 * time.Second
+}
+
+// message adapts a gateway message to the paho message interface
+type message struct {
+	msg *Message
+}
+
+func (m *message) Duplicate() bool   { return false }
+func (m *message) Qos() byte         { return m.msg.QoS }
+func (m *message) Retained() bool    { return m.msg.Retained }
+func (m *message) Topic() string     { return m.msg.Topic }
+func (m *message) MessageID() uint16 { return 0 }
+func (m *message) Payload() []byte   { return m.msg.Payload }
+func (m *message) Ack()              {}
+
+// token implements the paho token interface for asynchronous operations
+type token struct {
+	done chan struct{}
+	err  error
+}
+
+func newToken() *token {
+	return &token{done: make(chan struct{})}
+}
+
+// complete finishes the token with the operation's result
+func (t *token) complete(err error) {
+	t.err = err
+	close(t.done)
+}
+
+func (t *token) Wait() bool {
+	<-t.done
+	return true
+}
+
+func (t *token) WaitTimeout(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (t *token) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *token) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
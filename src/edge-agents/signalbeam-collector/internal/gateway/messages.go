@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The messages of proto/signalbeam/edge/v1/telemetry.proto, encoded by hand
+// with protowire so the build needs no generated code
+
+// Record is one message as it would be published to the broker
+type Record struct {
+	Seq      uint64
+	Topic    string
+	QoS      byte
+	Retained bool
+	Payload  []byte
+}
+
+// Ack confirms or rejects a record
+type Ack struct {
+	Seq   uint64
+	Error string
+}
+
+// SubscribeRequest asks for messages on topic filters
+type SubscribeRequest struct {
+	Filters []string
+}
+
+// Message is a control message delivered to the collector
+type Message struct {
+	Topic    string
+	QoS      byte
+	Retained bool
+	Payload  []byte
+}
+
+func (r *Record) marshal() []byte {
+	b := appendVarint(nil, 1, r.Seq)
+	b = appendString(b, 2, r.Topic)
+	b = appendVarint(b, 3, uint64(r.QoS))
+	b = appendBool(b, 4, r.Retained)
+	b = appendBytes(b, 5, r.Payload)
+	return b
+}
+
+func (r *SubscribeRequest) marshal() []byte {
+	var b []byte
+	for _, f := range r.Filters {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, f)
+	}
+	return b
+}
+
+func (a *Ack) unmarshal(b []byte) error {
+	*a = Ack{}
+	return parse(b, func(num protowire.Number, v uint64, data []byte) {
+		switch num {
+		case 1:
+			a.Seq = v
+		case 2:
+			a.Error = string(data)
+		}
+	})
+}
+
+func (m *Message) unmarshal(b []byte) error {
+	*m = Message{}
+	return parse(b, func(num protowire.Number, v uint64, data []byte) {
+		switch num {
+		case 1:
+			m.Topic = string(data)
+		case 2:
+			m.QoS = byte(v)
+		case 3:
+			m.Retained = v != 0
+		case 4:
+			m.Payload = append([]byte(nil), data...)
+		}
+	})
+}
+
+// parse walks the fields of a message, passing varints as v and
+// length-delimited fields as data. Unknown fields are skipped
+func parse(b []byte, field func(num protowire.Number, v uint64, data []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			field(num, v, nil)
+			b = b[n:]
+		case protowire.BytesType:
+			data, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			field(num, 0, data)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendBytes(b []byte, num protowire.Number, data []byte) []byte {
+	if len(data) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, data)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendVarint(b, num, 1)
+}
+
+// codec encodes the hand-written messages for grpc. It is named "proto" so
+// requests carry the standard application/grpc+proto content type
+type codec struct{}
+
+func (codec) Name() string { return "proto" }
+
+func (codec) Marshal(v any) ([]byte, error) {
+	switch m := v.(type) {
+	case *Record:
+		return m.marshal(), nil
+	case *SubscribeRequest:
+		return m.marshal(), nil
+	}
+	return nil, fmt.Errorf("gateway: cannot marshal %T", v)
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	switch m := v.(type) {
+	case *Ack:
+		return m.unmarshal(data)
+	case *Message:
+		return m.unmarshal(data)
+	}
+	return fmt.Errorf("gateway: cannot unmarshal into %T", v)
+}
@@ -0,0 +1,273 @@
+package gpio
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reasons a change is refused
+var (
+	ErrUnknownOutput = errors.New("unknown output")
+	ErrNotRemote     = errors.New("output does not accept remote commands")
+	ErrInterlock     = errors.New("interlock")
+)
+
+// Output is a switchable output and its interlocks
+type Output struct {
+	Name   string
+	Driver Driver
+	// Initial is set when the controller starts: "on", "off" or empty to
+	// keep the state the output has
+	Initial string
+	// Remote lets cloud commands switch the output; local rules always may
+	Remote bool
+	// Exclusive names outputs that must be off while this one is on; the
+	// controller applies it both ways
+	Exclusive   []string
+	MinInterval time.Duration // Between changes asked for
+	MaxOn       time.Duration // Switched off after this long on; 0 for no limit
+	MaxHold     time.Duration // Longest timed change
+	CycleOff    time.Duration // Off time of a cycle that sets none
+}
+
+// Change is reported after every switch, including the automatic ones
+type Change struct {
+	Output string
+	On     bool
+	Source string // "command", "rule:<name>", "restore", "max_on" or "initial"
+	Err    error  // Set when an automatic switch failed
+}
+
+// Controller switches outputs within their interlocks
+type Controller struct {
+	mu      sync.Mutex
+	outputs map[string]*output
+	changed func(Change)
+}
+
+// output is the state of one output
+type output struct {
+	Output
+	on        *bool // nil while unknown
+	changedAt time.Time
+	source    string
+	timer     *time.Timer // Restore or max-on switch pending
+	restore   *bool       // State a timed change returns to
+	timerAt   time.Time
+	gen       int // Counts changes, so a superseded timer does nothing
+}
+
+// NewController sets the initial states. changed is called outside the
+// controller's lock
+func NewController(outputs []Output, changed func(Change)) (*Controller, error) {
+	c := &Controller{outputs: make(map[string]*output, len(outputs)), changed: changed}
+	for _, o := range outputs {
+		c.outputs[o.Name] = &output{Output: o}
+	}
+	for _, o := range c.outputs {
+		for _, name := range o.Exclusive {
+			other, ok := c.outputs[name]
+			if !ok {
+				return nil, fmt.Errorf("output %s: exclusive output %s: %w", o.Name, name, ErrUnknownOutput)
+			}
+			if !slices.Contains(other.Exclusive, o.Name) {
+				other.Exclusive = append(other.Exclusive, o.Name)
+			}
+		}
+	}
+
+	var changes []Change
+	for _, o := range c.outputs {
+		switch o.Initial {
+		case On, Off:
+			on := o.Initial == On
+			if err := o.Driver.Set(on); err != nil {
+				return nil, fmt.Errorf("output %s: %w", o.Name, err)
+			}
+			c.switched(o, on, "initial")
+			changes = append(changes, Change{Output: o.Name, On: on, Source: "initial"})
+		default:
+			if r, ok := o.Driver.(Reader); ok {
+				if on, err := r.Get(); err == nil {
+					o.on = &on
+				}
+			}
+		}
+	}
+	for _, ch := range changes {
+		c.changed(ch)
+	}
+	return c, nil
+}
+
+// Apply switches an output, for hold and then back when hold is set.
+// remote marks cloud commands
+func (c *Controller) Apply(name string, on bool, hold time.Duration, source string, remote bool) error {
+	c.mu.Lock()
+	o, ok := c.outputs[name]
+	if !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("%w %s", ErrUnknownOutput, name)
+	}
+	if err := c.check(o, on, hold, remote); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	if err := o.Driver.Set(on); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	previous := o.on
+	c.switched(o, on, source)
+	if hold > 0 {
+		// An output of unknown state returns to the opposite
+		back := !on
+		if previous != nil {
+			back = *previous
+		}
+		if o.timer != nil {
+			o.timer.Stop() // Max-on, which the shorter hold precedes
+		}
+		gen := o.gen
+		o.restore = &back
+		o.timerAt = time.Now().Add(hold)
+		o.timer = time.AfterFunc(hold, func() { c.automatic(o, gen, back, "restore") })
+	}
+	c.mu.Unlock()
+
+	c.changed(Change{Output: name, On: on, Source: source})
+	return nil
+}
+
+// Cycle switches an output off for hold, or its cycle time when hold is
+// zero, and then back to its previous state; an output of unknown state is
+// switched on
+func (c *Controller) Cycle(name string, hold time.Duration, source string, remote bool) error {
+	if hold == 0 {
+		c.mu.Lock()
+		if o, ok := c.outputs[name]; ok {
+			hold = o.CycleOff
+		}
+		c.mu.Unlock()
+	}
+	return c.Apply(name, false, hold, source, remote)
+}
+
+// check applies the interlocks of a change
+func (c *Controller) check(o *output, on bool, hold time.Duration, remote bool) error {
+	switch {
+	case remote && !o.Remote:
+		return fmt.Errorf("%s: %w", o.Name, ErrNotRemote)
+	case hold > o.MaxHold:
+		return fmt.Errorf("%w: %s holds a change for at most %s", ErrInterlock, o.Name, o.MaxHold)
+	case on && o.MaxOn > 0 && hold > o.MaxOn:
+		return fmt.Errorf("%w: %s stays on for at most %s", ErrInterlock, o.Name, o.MaxOn)
+	case !o.changedAt.IsZero() && time.Since(o.changedAt) < o.MinInterval:
+		return fmt.Errorf("%w: %s changed less than %s ago", ErrInterlock, o.Name, o.MinInterval)
+	}
+	if on {
+		for _, name := range o.Exclusive {
+			if other := c.outputs[name]; other.on != nil && *other.on {
+				return fmt.Errorf("%w: %s is on", ErrInterlock, name)
+			}
+		}
+	}
+	return nil
+}
+
+// switched records a new state and schedules the max-on switch. Called
+// with the lock held
+func (c *Controller) switched(o *output, on bool, source string) {
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer, o.restore, o.timerAt = nil, nil, time.Time{}
+	}
+	o.gen++
+	o.on, o.changedAt, o.source = &on, time.Now(), source
+	if on && o.MaxOn > 0 {
+		gen := o.gen
+		o.timerAt = time.Now().Add(o.MaxOn)
+		o.timer = time.AfterFunc(o.MaxOn, func() { c.automatic(o, gen, false, "max_on") })
+	}
+}
+
+// automatic restores a timed change or enforces max-on, skipping the
+// interlocks asked-for changes are subject to. It does nothing once the
+// output changed again since gen
+func (c *Controller) automatic(o *output, gen int, on bool, source string) {
+	c.mu.Lock()
+	if o.gen != gen {
+		c.mu.Unlock()
+		return
+	}
+	err := o.Driver.Set(on)
+	if err == nil {
+		c.switched(o, on, source)
+	} else {
+		o.timer, o.restore, o.timerAt = nil, nil, time.Time{}
+	}
+	c.mu.Unlock()
+	c.changed(Change{Output: o.Name, On: on, Source: source, Err: err})
+}
+
+// States reports each output for the heartbeat
+func (c *Controller) States() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]interface{}, len(c.outputs))
+	for name, o := range c.outputs {
+		s := map[string]interface{}{"state": "unknown"}
+		if o.on != nil {
+			s["state"] = Off
+			if *o.on {
+				s["state"] = On
+			}
+		}
+		if !o.changedAt.IsZero() {
+			s["changed_at"] = o.changedAt.UTC().Format(time.RFC3339)
+			s["source"] = o.source
+		}
+		if !o.timerAt.IsZero() {
+			if o.restore != nil {
+				s["restore_at"] = o.timerAt.UTC().Format(time.RFC3339)
+			} else {
+				s["off_at"] = o.timerAt.UTC().Format(time.RFC3339)
+			}
+		}
+		m[name] = s
+	}
+	return m
+}
+
+// Close completes pending timed changes and switches off outputs with a
+// longest on time, which nobody enforces once the collector stops. A
+// power-cycled device is not left off
+func (c *Controller) Close() {
+	type final struct {
+		o      *output
+		gen    int
+		on     bool
+		source string
+	}
+	var pending []final
+	c.mu.Lock()
+	for _, o := range c.outputs {
+		if o.timer == nil || !o.timer.Stop() {
+			continue
+		}
+		if o.restore != nil {
+			pending = append(pending, final{o, o.gen, *o.restore, "restore"})
+		} else {
+			pending = append(pending, final{o, o.gen, false, "max_on"})
+		}
+	}
+	c.mu.Unlock()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].o.Name < pending[j].o.Name })
+	for _, p := range pending {
+		c.automatic(p.o, p.gen, p.on, p.source)
+	}
+}
@@ -0,0 +1,164 @@
+// Package gpio switches GPIO lines, relays, PoE switch ports, smart PDU
+// outlets and USB hub ports on command. A controller
+// enforces the interlocks of each output: exclusive groups, a minimum time
+// between changes and a longest on time, and restores the previous state
+// after a timed change such as a power cycle
+package gpio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Actions of a command. Cycle switches the output off and back on
+const (
+	On    = "on"
+	Off   = "off"
+	Cycle = "cycle"
+)
+
+// Driver switches one output
+type Driver interface {
+	Set(on bool) error
+}
+
+// Reader is a driver that can read the current state back
+type Reader interface {
+	Get() (bool, error)
+}
+
+// Sysfs drives a line through a value file, such as
+// /sys/class/gpio/gpio17/value or the brightness of a relay exposed as an
+// LED. A numbered line is exported and made an output first
+type Sysfs struct {
+	Path      string
+	ActiveLow bool // The relay closes when the line is low
+}
+
+// NewSysfs returns the driver of a line number, exporting it when needed,
+// or of a value file when path is set
+func NewSysfs(line int, path string, activeLow bool) (*Sysfs, error) {
+	if path != "" {
+		return &Sysfs{Path: path, ActiveLow: activeLow}, nil
+	}
+	dir := fmt.Sprintf("/sys/class/gpio/gpio%d", line)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile("/sys/class/gpio/export", []byte(strconv.Itoa(line)), 0o200); err != nil {
+			return nil, fmt.Errorf("export GPIO %d: %w", line, err)
+		}
+	}
+	// Writing "low" or "high" sets the initial level with the direction;
+	// the state is set by the controller afterwards, so keep it
+	direction := filepath.Join(dir, "direction")
+	current, err := os.ReadFile(direction)
+	if err != nil {
+		return nil, fmt.Errorf("GPIO %d: %w", line, err)
+	}
+	if strings.TrimSpace(string(current)) != "out" {
+		if err := os.WriteFile(direction, []byte("low"), 0o200); err != nil {
+			return nil, fmt.Errorf("GPIO %d direction: %w", line, err)
+		}
+	}
+	return &Sysfs{Path: filepath.Join(dir, "value"), ActiveLow: activeLow}, nil
+}
+
+// Set writes the level for the state
+func (s *Sysfs) Set(on bool) error {
+	level := "0"
+	if on != s.ActiveLow {
+		level = "1"
+	}
+	return os.WriteFile(s.Path, []byte(level), 0o200)
+}
+
+// Get reads the level back
+func (s *Sysfs) Get() (bool, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return false, err
+	}
+	level := strings.TrimSpace(string(data))
+	return (level != "0") != s.ActiveLow, nil
+}
+
+// Command runs a program to switch the output, for relays behind libgpiod
+// tools, USB relay boards or vendor utilities
+type Command struct {
+	On      []string
+	Off     []string
+	Timeout time.Duration
+}
+
+// Set runs the command of the state
+func (c *Command) Set(on bool) error {
+	args := c.Off
+	if on {
+		args = c.On
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %w: %s", args[0], err, msg)
+		}
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	return nil
+}
+
+// Request switches an output. With a duration the output returns to its
+// previous state afterwards: "off" for 10s power-cycles what it feeds, as
+// does "cycle" for the output's cycle time
+type Request struct {
+	ID       string `json:"id"`
+	Output   string `json:"output"`
+	Action   string `json:"action"`             // "on", "off" or "cycle"
+	Duration string `json:"duration,omitempty"` // e.g. "10s"
+	Expires  int64  `json:"expires,omitempty"`  // Unix time after which the request is skipped
+
+	hold time.Duration
+}
+
+// Parse decodes and validates a request
+func Parse(payload []byte) (Request, error) {
+	var r Request
+	if err := json.Unmarshal(payload, &r); err != nil {
+		return Request{}, fmt.Errorf("invalid GPIO request: %w", err)
+	}
+	switch {
+	case r.ID == "":
+		return Request{}, errors.New("GPIO request id is required")
+	case r.Output == "":
+		return Request{}, fmt.Errorf("GPIO request %s: output is required", r.ID)
+	case r.Action != On && r.Action != Off && r.Action != Cycle:
+		return Request{}, fmt.Errorf("GPIO request %s: action must be on, off or cycle", r.ID)
+	}
+	if r.Duration != "" {
+		d, err := time.ParseDuration(r.Duration)
+		if err != nil || d <= 0 {
+			return Request{}, fmt.Errorf("GPIO request %s: invalid duration %q", r.ID, r.Duration)
+		}
+		r.hold = d
+	}
+	return r, nil
+}
+
+// Hold returns how long the state is held before it is restored, zero to
+// keep it
+func (r Request) Hold() time.Duration {
+	return r.hold
+}
+
+// Expired reports whether the request should no longer be run
+func (r Request) Expired(now time.Time) bool {
+	return r.Expires > 0 && now.Unix() > r.Expires
+}
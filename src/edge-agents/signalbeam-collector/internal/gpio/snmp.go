@@ -0,0 +1,133 @@
+package gpio
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// pethPsePortAdminEnable switches PoE on a switch port (RFC 3621), indexed
+// by group and port: true(1) powers the port, false(2) cuts it
+const pethPsePortAdminEnable = "1.3.6.1.2.1.105.1.1.1.3"
+
+// PDUs maps smart PDU models to the outlet control object, indexed by
+// outlet, and its on and off values
+var PDUs = map[string]struct {
+	OID     string
+	On, Off int
+}{
+	// PowerNet-MIB rPDUOutletControlOutletCommand: immediateOn, immediateOff
+	"apc": {"1.3.6.1.4.1.318.1.1.12.3.3.1.1.4", 1, 2},
+	// PDU2-MIB switchingOperation of the first PDU: on, off
+	"raritan": {"1.3.6.1.4.1.13742.6.4.1.2.1.2.1", 1, 0},
+}
+
+// SNMP switches an output by setting an integer object, such as the PoE
+// state of a switch port or an outlet of a smart PDU
+type SNMP struct {
+	Target    string // host or host:port
+	Community string // Write community
+	Version   string // "1" or "2c"
+	OID       string
+	OnValue   int
+	OffValue  int
+	Timeout   time.Duration
+}
+
+// PoE returns the driver of a PoE switch port on the agent
+func PoE(agent SNMP, group, port int) *SNMP {
+	agent.OID = fmt.Sprintf("%s.%d.%d", pethPsePortAdminEnable, group, port)
+	agent.OnValue, agent.OffValue = 1, 2
+	return &agent
+}
+
+// PDU returns the driver of an outlet of a known PDU model on the agent
+func PDU(agent SNMP, model string, outlet int) (*SNMP, error) {
+	m, ok := PDUs[model]
+	if !ok {
+		return nil, fmt.Errorf("unknown PDU model %q", model)
+	}
+	agent.OID = fmt.Sprintf("%s.%d", m.OID, outlet)
+	agent.OnValue, agent.OffValue = m.On, m.Off
+	return &agent, nil
+}
+
+// Set writes the value of the state
+func (s *SNMP) Set(on bool) error {
+	value := s.OffValue
+	if on {
+		value = s.OnValue
+	}
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Conn.Close()
+
+	packet, err := client.Set([]gosnmp.SnmpPDU{{Name: s.OID, Type: gosnmp.Integer, Value: value}})
+	if err != nil {
+		return fmt.Errorf("SNMP set %s on %s: %w", s.OID, s.Target, err)
+	}
+	if packet.Error != gosnmp.NoError {
+		return fmt.Errorf("SNMP set %s on %s: agent returned %s", s.OID, s.Target, packet.Error)
+	}
+	return nil
+}
+
+// Get reads the value back. Values other than the on and off values, such
+// as a PDU outlet that is cycling, are an error
+func (s *SNMP) Get() (bool, error) {
+	client, err := s.connect()
+	if err != nil {
+		return false, err
+	}
+	defer client.Conn.Close()
+
+	packet, err := client.Get([]string{s.OID})
+	if err != nil {
+		return false, fmt.Errorf("SNMP get %s on %s: %w", s.OID, s.Target, err)
+	}
+	if packet.Error != gosnmp.NoError || len(packet.Variables) != 1 || packet.Variables[0].Type != gosnmp.Integer {
+		return false, fmt.Errorf("SNMP get %s on %s: no integer value", s.OID, s.Target)
+	}
+	switch v := gosnmp.ToBigInt(packet.Variables[0].Value); {
+	case v.Cmp(big.NewInt(int64(s.OnValue))) == 0:
+		return true, nil
+	case v.Cmp(big.NewInt(int64(s.OffValue))) == 0:
+		return false, nil
+	default:
+		return false, fmt.Errorf("SNMP get %s on %s: unexpected value %s", s.OID, s.Target, v)
+	}
+}
+
+// connect opens the UDP session to the agent
+func (s *SNMP) connect() (*gosnmp.GoSNMP, error) {
+	host, port := s.Target, 161
+	if h, p, err := net.SplitHostPort(s.Target); err == nil {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid port in target %q", s.Target)
+		}
+		host, port = h, n
+	}
+	version := gosnmp.Version2c
+	if s.Version == "1" {
+		version = gosnmp.Version1
+	}
+	client := &gosnmp.GoSNMP{
+		Target:    host,
+		Port:      uint16(port),
+		Community: s.Community,
+		Version:   version,
+		Timeout:   s.Timeout,
+		Retries:   1,
+	}
+	if err := client.Connect(); err != nil {
+		return nil, fmt.Errorf("SNMP %s: %w", s.Target, err)
+	}
+	return client, nil
+}
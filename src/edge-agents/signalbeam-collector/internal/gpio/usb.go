@@ -0,0 +1,42 @@
+package gpio
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// USBPort switches the power of a USB hub port through the kernel's port
+// disable attribute. Only hubs that switch power per port cut the power;
+// on others the device is merely disconnected
+type USBPort struct {
+	Path string
+}
+
+// NewUSBPort returns the driver of port on hub, a USB device name such as
+// "1-1", or "usb1" for the root hub of bus 1
+func NewUSBPort(hub string, port int) *USBPort {
+	iface := hub + ":1.0"
+	if bus, ok := strings.CutPrefix(hub, "usb"); ok {
+		iface = bus + "-0:1.0"
+	}
+	return &USBPort{Path: fmt.Sprintf("/sys/bus/usb/devices/%s/%s-port%d/disable", iface, hub, port)}
+}
+
+// Set enables or disables the port
+func (u *USBPort) Set(on bool) error {
+	value := "1"
+	if on {
+		value = "0"
+	}
+	return os.WriteFile(u.Path, []byte(value), 0o200)
+}
+
+// Get reads whether the port is enabled
+func (u *USBPort) Get() (bool, error) {
+	data, err := os.ReadFile(u.Path)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) == "0", nil
+}
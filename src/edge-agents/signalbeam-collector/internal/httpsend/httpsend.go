@@ -0,0 +1,132 @@
+package httpsend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+)
+
+// Record is one message as it would have been published over MQTT.
+// Payloads that are not JSON are sent in base64 as payload_base64
+type Record struct {
+	Topic    string          `json:"topic"`
+	QoS      byte            `json:"qos"`
+	Retained bool            `json:"retained,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Binary   []byte          `json:"payload_base64,omitempty"`
+}
+
+// NewRecord creates a record, placing the payload by its encoding
+func NewRecord(topic string, qos byte, retained bool, payload []byte) Record {
+	r := Record{Topic: topic, QoS: qos, Retained: retained}
+	if json.Valid(payload) {
+		r.Payload = payload
+	} else {
+		r.Binary = payload
+	}
+	return r
+}
+
+// Options configures a sender
+type Options struct {
+	URL      string
+	Token    string // Sent as a bearer token when set
+	DeviceID string
+	Compress bool // gzip request bodies
+	Timeout  time.Duration
+	TLS      *tls.Config
+	Dial     egress.DialFunc
+	// Proxy selects the proxy of a request as in http.Transport; nil uses
+	// the environment's
+	Proxy func(*http.Request) (*url.URL, error)
+}
+
+// Sender posts record batches to the ingestion service as NDJSON
+type Sender struct {
+	opts   Options
+	client *http.Client
+}
+
+// New creates a sender
+func New(opts Options) *Sender {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = opts.TLS
+	if opts.Dial != nil {
+		transport.DialContext = opts.Dial
+	}
+	if opts.Proxy != nil {
+		transport.Proxy = opts.Proxy
+	}
+
+	return &Sender{
+		opts:   opts,
+		client: &http.Client{Transport: transport, Timeout: opts.Timeout},
+	}
+}
+
+// Send posts a batch. Any 2xx response acknowledges the whole batch
+func (s *Sender) Send(ctx context.Context, batch []Record) error {
+	body, err := s.encode(batch)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("User-Agent", "signalbeam-collector/0.1.0")
+	req.Header.Set("X-SignalBeam-Device-ID", s.opts.DeviceID)
+	if s.opts.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if s.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.opts.Token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("ingestion service returned %s", resp.Status)
+	}
+	return nil
+}
+
+// encode writes one record per line, compressed when configured
+func (s *Sender) encode(batch []Record) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if s.opts.Compress {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+
+	enc := json.NewEncoder(w)
+	for _, r := range batch {
+		if err := enc.Encode(r); err != nil {
+			return nil, fmt.Errorf("failed to encode record: %w", err)
+		}
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,55 @@
+package hwinfo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Identity describes the physical hardware the collector runs on
+type Identity struct {
+	Vendor      string `json:"vendor,omitempty"`
+	Model       string `json:"model,omitempty"`
+	Board       string `json:"board,omitempty"`
+	Serial      string `json:"serial,omitempty"`
+	ProductUUID string `json:"product_uuid,omitempty"`
+	CPUSerial   string `json:"cpu_serial,omitempty"`
+	Source      string `json:"source,omitempty"` // "dmi", "device-tree", "cpuinfo" or "host"
+}
+
+// StableID returns an identifier derived from hardware serials, or "" when
+// no serial source is available
+func (i Identity) StableID() string {
+	var seed string
+	switch {
+	case i.ProductUUID != "":
+		seed = i.ProductUUID
+	case i.Serial != "":
+		seed = i.Serial
+	case i.CPUSerial != "":
+		seed = i.CPUSerial
+	default:
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(seed))
+	return "hw-" + hex.EncodeToString(sum[:8])
+}
+
+// Map returns the identity as a payload map
+func (i Identity) Map() map[string]interface{} {
+	m := make(map[string]interface{})
+	for k, v := range map[string]string{
+		"vendor":       i.Vendor,
+		"model":        i.Model,
+		"board":        i.Board,
+		"serial":       i.Serial,
+		"product_uuid": i.ProductUUID,
+		"cpu_serial":   i.CPUSerial,
+		"source":       i.Source,
+	} {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,77 @@
+//go:build linux
+
+package hwinfo
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+const (
+	dmiDir        = "/sys/class/dmi/id"
+	deviceTreeDir = "/proc/device-tree"
+)
+
+// Detect reads hardware identity from DMI, the device tree and /proc/cpuinfo
+func Detect() Identity {
+	id := Identity{}
+
+	// x86 and most UEFI boards expose DMI
+	if model := readAttr(dmiDir + "/product_name"); model != "" {
+		id.Vendor = readAttr(dmiDir + "/sys_vendor")
+		id.Model = model
+		id.Board = readAttr(dmiDir + "/board_name")
+		id.Serial = readAttr(dmiDir + "/product_serial")
+		id.ProductUUID = readAttr(dmiDir + "/product_uuid")
+		id.Source = "dmi"
+	}
+
+	// ARM SBCs (Raspberry Pi, Jetson, ...) describe themselves in the device tree
+	if model := readAttr(deviceTreeDir + "/model"); model != "" && id.Model == "" {
+		id.Model = model
+		id.Board = readAttr(deviceTreeDir + "/compatible")
+		id.Serial = readAttr(deviceTreeDir + "/serial-number")
+		id.Source = "device-tree"
+	}
+
+	id.CPUSerial = cpuSerial()
+	if id.Source == "" && id.CPUSerial != "" {
+		id.Source = "cpuinfo"
+	}
+
+	return id
+}
+
+// readAttr reads a sysfs/procfs attribute, stripping NULs and whitespace.
+// Files like product_serial are root-only; failures return "".
+func readAttr(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	s := strings.ReplaceAll(string(data), "\x00", ",")
+	return strings.Trim(strings.TrimSpace(s), ",")
+}
+
+// cpuSerial returns the Serial line from /proc/cpuinfo (Raspberry Pi)
+func cpuSerial() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(key) == "Serial" {
+			serial := strings.TrimSpace(value)
+			if strings.Trim(serial, "0") == "" {
+				return ""
+			}
+			return serial
+		}
+	}
+	return ""
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package hwinfo
+
+import "github.com/shirou/gopsutil/v3/host"
+
+// Detect falls back to the platform host ID where DMI and device-tree are unavailable
+func Detect() Identity {
+	id := Identity{}
+	if info, err := host.Info(); err == nil && info.HostID != "" {
+		id.ProductUUID = info.HostID
+		id.Model = info.Platform
+		id.Source = "host"
+	}
+	return id
+}
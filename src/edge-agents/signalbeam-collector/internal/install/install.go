@@ -0,0 +1,230 @@
+package install
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/state"
+)
+
+// Options controls where the service is installed
+type Options struct {
+	User       string
+	Binary     string
+	ConfigPath string
+	UnitPath   string
+	WorkDir    string // Relative state paths resolve against this
+}
+
+// Plan is the least-privilege service setup derived from a configuration
+type Plan struct {
+	Options      Options
+	Capabilities []string
+	Groups       []string // Supplementary groups for device access
+	Families     []string // Socket address families the service may open
+	Syscalls     []string
+	WritePaths   []string
+	Notes        []string
+}
+
+// Build derives the service privileges required by the enabled inputs
+func Build(cfg *config.Config, opts Options) Plan {
+	p := Plan{
+		Options:  opts,
+		Families: []string{"AF_INET", "AF_INET6", "AF_UNIX", "AF_NETLINK"},
+		Syscalls: []string{"@system-service"},
+	}
+
+	if cfg.Collection.Metrics.Enabled && cfg.Collection.Metrics.EBPF {
+		p.Capabilities = append(p.Capabilities, "CAP_BPF", "CAP_PERFMON")
+		p.Syscalls = append(p.Syscalls, "bpf", "perf_event_open")
+		p.Notes = append(p.Notes, "eBPF enabled: granting CAP_BPF and CAP_PERFMON")
+	}
+
+	if cfg.Flows.Enabled {
+		p.Capabilities = append(p.Capabilities, "CAP_NET_RAW")
+		p.Families = append(p.Families, "AF_PACKET")
+		p.Notes = append(p.Notes, "flows enabled: granting CAP_NET_RAW and packet sockets")
+	}
+
+	if cfg.LocalAPI.Enabled && privilegedPort(cfg.LocalAPI.Listen) {
+		p.Capabilities = append(p.Capabilities, "CAP_NET_BIND_SERVICE")
+		p.Notes = append(p.Notes, "local API listens on a privileged port: granting CAP_NET_BIND_SERVICE")
+	}
+
+	if cfg.Power.Mode == "duty_cycle" && len(cfg.Power.SuspendCommand) > 0 {
+		p.Notes = append(p.Notes, "power.suspend_command usually needs root; grant it through sudoers or a helper unit")
+	}
+
+	if cfg.GPIO.Enabled {
+		p.Notes = append(p.Notes, "gpio enabled: the service user needs write access to the GPIO lines, e.g. through the gpio group")
+		for _, o := range cfg.GPIO.Outputs {
+			if o.Driver == "usb" {
+				p.Notes = append(p.Notes, "usb outputs write the port disable attributes under /sys/bus/usb, which are owned by root; grant access through a udev rule")
+				break
+			}
+		}
+	}
+
+	if cfg.Notify.Enabled && cfg.Notify.SMS.Enabled {
+		p.Groups = append(p.Groups, "dialout")
+		p.Notes = append(p.Notes, "sms notifications enabled: adding the dialout group for the modem at "+cfg.Notify.SMS.Device)
+	}
+
+	p.WritePaths = []string{filepath.Clean(opts.WorkDir)}
+	if cfg.Update.Enabled {
+		// Updates replace the binary and keep the previous one beside it
+		p.WritePaths = appendPath(p.WritePaths, filepath.Dir(absolute(opts.Binary, opts.WorkDir)))
+		p.Notes = append(p.Notes, "updates enabled: the binary directory is writable")
+		if cfg.Update.Image.System != "none" {
+			p.Notes = append(p.Notes, "image updates call rauc, mender or swupdate and update.image.reboot_command, which usually need root; grant them through sudoers or polkit")
+		}
+	}
+	paths := state.Resolve(cfg.State)
+	for _, path := range []string{
+		paths.Dir, paths.BufferDir, paths.CrashDir, paths.QuarantineDir,
+		filepath.Dir(paths.Offsets), filepath.Dir(paths.StateFile), filepath.Dir(paths.AuditFile), paths.DecoderDir,
+		filepath.Dir(paths.BootstrapFile),
+	} {
+		p.WritePaths = appendPath(p.WritePaths, absolute(path, opts.WorkDir))
+	}
+
+	sort.Strings(p.Capabilities)
+	return p
+}
+
+// Unit renders the hardened systemd unit
+func (p Plan) Unit() string {
+	caps := strings.Join(p.Capabilities, " ")
+
+	return fmt.Sprintf(`[Unit]
+Description=SignalBeam Edge Collector
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+User=%s
+Group=%s
+SupplementaryGroups=%s
+WorkingDirectory=%s
+ExecStart=%s -config %s
+Restart=always
+RestartSec=10
+UMask=0077
+
+# Capabilities (empty unless an enabled input needs one)
+CapabilityBoundingSet=%s
+AmbientCapabilities=%s
+NoNewPrivileges=yes
+
+# Filesystem
+ProtectSystem=strict
+ProtectHome=read-only
+PrivateTmp=yes
+ReadWritePaths=%s
+
+# Kernel and process isolation
+ProtectKernelModules=yes
+ProtectKernelLogs=yes
+ProtectControlGroups=yes
+ProtectClock=yes
+ProtectHostname=yes
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+RestrictAddressFamilies=%s
+
+# Seccomp
+SystemCallArchitectures=native
+SystemCallFilter=%s
+SystemCallErrorNumber=EPERM
+
+[Install]
+WantedBy=multi-user.target
+`, p.Options.User, p.Options.User, strings.Join(p.Groups, " "), p.Options.WorkDir, p.Options.Binary, p.Options.ConfigPath,
+		caps, caps, strings.Join(p.WritePaths, " "), strings.Join(p.Families, " "), strings.Join(p.Syscalls, " "))
+}
+
+// Apply creates the service user and state directories, writes the unit and
+// reloads systemd
+func (p Plan) Apply() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("install is only supported on systemd Linux; see init/ for BSD rc scripts")
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("install must run as root")
+	}
+
+	if _, err := user.Lookup(p.Options.User); err != nil {
+		cmd := exec.Command("useradd", "--system", "--no-create-home", "--shell", "/usr/sbin/nologin", p.Options.User)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to create user %s: %w: %s", p.Options.User, err, out)
+		}
+	}
+
+	u, err := user.Lookup(p.Options.User)
+	if err != nil {
+		return fmt.Errorf("failed to look up user %s: %w", p.Options.User, err)
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+
+	for _, dir := range p.WritePaths {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return fmt.Errorf("failed to chown %s: %w", dir, err)
+		}
+	}
+
+	if err := os.WriteFile(p.Options.UnitPath, []byte(p.Unit()), 0o644); err != nil {
+		return fmt.Errorf("failed to write unit: %w", err)
+	}
+
+	if out, err := exec.Command("systemctl", "daemon-reload").CombinedOutput(); err != nil {
+		return fmt.Errorf("systemctl daemon-reload failed: %w: %s", err, out)
+	}
+
+	return nil
+}
+
+// privilegedPort reports whether a listen address uses a port below 1024
+func privilegedPort(addr string) bool {
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.Atoi(portStr)
+	return err == nil && port > 0 && port < 1024
+}
+
+// absolute resolves a relative state path against base
+func absolute(path, base string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(base, path)
+}
+
+// appendPath appends path unless it is already covered by an entry
+func appendPath(list []string, path string) []string {
+	for _, v := range list {
+		if path == v || strings.HasPrefix(path, v+string(filepath.Separator)) {
+			return list
+		}
+	}
+	return append(list, path)
+}
@@ -0,0 +1,203 @@
+// Package inventory takes a snapshot of what is installed on the host:
+// packages, kernel and firmware versions, network configuration, disk layout
+// and attached USB and PCI devices
+package inventory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// Sections that can be collected
+var Sections = []string{"os", "packages", "firmware", "network", "disks", "usb", "pci"}
+
+// Package is one installed package
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch,omitempty"`
+	Manager string `json:"manager"` // dpkg, apk, opkg or rpm
+}
+
+// Interface is a network interface and its addresses
+type Interface struct {
+	Name  string   `json:"name"`
+	MAC   string   `json:"mac,omitempty"`
+	MTU   int      `json:"mtu"`
+	Up    bool     `json:"up"`
+	Addrs []string `json:"addrs,omitempty"`
+}
+
+// Disk is a block device and its partitions
+type Disk struct {
+	Name       string      `json:"name"`
+	Size       uint64      `json:"size"`
+	Model      string      `json:"model,omitempty"`
+	Removable  bool        `json:"removable"`
+	Rotational bool        `json:"rotational"`
+	Partitions []Partition `json:"partitions,omitempty"`
+	Mount      string      `json:"mount,omitempty"`
+	FSType     string      `json:"fstype,omitempty"`
+}
+
+// Partition is a partition of a disk
+type Partition struct {
+	Name   string `json:"name"`
+	Size   uint64 `json:"size"`
+	Mount  string `json:"mount,omitempty"`
+	FSType string `json:"fstype,omitempty"`
+}
+
+// USBDevice is a device on a USB bus
+type USBDevice struct {
+	Path         string `json:"path"` // Bus and port, e.g. 1-1.3
+	VendorID     string `json:"vendor_id"`
+	ProductID    string `json:"product_id"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Product      string `json:"product,omitempty"`
+	Serial       string `json:"serial,omitempty"`
+}
+
+// PCIDevice is a device on a PCI bus
+type PCIDevice struct {
+	Slot     string `json:"slot"`
+	VendorID string `json:"vendor_id"`
+	DeviceID string `json:"device_id"`
+	Class    string `json:"class"`
+	Driver   string `json:"driver,omitempty"`
+}
+
+// Collect gathers the requested sections. Sections that cannot be read on
+// this platform are left out; errors are returned per section
+func Collect(sections []string) (map[string]interface{}, map[string]error) {
+	snapshot := make(map[string]interface{}, len(sections))
+	errs := make(map[string]error)
+	for _, section := range sections {
+		var v interface{}
+		var err error
+		switch section {
+		case "os":
+			v, err = collectOS()
+		case "packages":
+			v, err = packages()
+		case "firmware":
+			v, err = firmware()
+		case "network":
+			v, err = network()
+		case "disks":
+			v, err = disks()
+		case "usb":
+			v, err = usbDevices()
+		case "pci":
+			v, err = pciDevices()
+		default:
+			err = fmt.Errorf("unknown section")
+		}
+		if err != nil {
+			errs[section] = err
+			continue
+		}
+		if v != nil {
+			snapshot[section] = toPlain(v)
+		}
+	}
+	return snapshot, errs
+}
+
+// Hashes returns the SHA-256 of each section, to tell which changed between
+// snapshots
+func Hashes(snapshot map[string]interface{}) map[string]string {
+	hashes := make(map[string]string, len(snapshot))
+	for section, v := range snapshot {
+		data, _ := json.Marshal(v) // Map keys are sorted, so this is canonical
+		sum := sha256.Sum256(data)
+		hashes[section] = hex.EncodeToString(sum[:])
+	}
+	return hashes
+}
+
+// Digest combines section hashes into one hash of the snapshot
+func Digest(hashes map[string]string) string {
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\n", name, hashes[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// collectOS reports the operating system and kernel
+func collectOS() (map[string]interface{}, error) {
+	info, err := host.Info()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"os":               info.OS,
+		"platform":         info.Platform,
+		"platform_family":  info.PlatformFamily,
+		"platform_version": info.PlatformVersion,
+		"kernel_version":   info.KernelVersion,
+		"kernel_arch":      info.KernelArch,
+		"hostname":         info.Hostname,
+		"virtualization":   info.VirtualizationSystem,
+	}, nil
+}
+
+// interfaces lists the network interfaces and their addresses
+func interfaces() ([]Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Interface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		i := Interface{
+			Name: iface.Name,
+			MAC:  iface.HardwareAddr.String(),
+			MTU:  iface.MTU,
+			Up:   iface.Flags&net.FlagUp != 0,
+		}
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, a := range addrs {
+				i.Addrs = append(i.Addrs, a.String())
+			}
+		}
+		out = append(out, i)
+	}
+	return out, nil
+}
+
+// sortPackages orders packages by manager and name
+func sortPackages(pkgs []Package) {
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Manager != pkgs[j].Manager {
+			return pkgs[i].Manager < pkgs[j].Manager
+		}
+		return pkgs[i].Name < pkgs[j].Name
+	})
+}
+
+// toPlain turns typed values into maps and slices, the form telemetry
+// processors walk
+func toPlain(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var plain interface{}
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return nil
+	}
+	return plain
+}
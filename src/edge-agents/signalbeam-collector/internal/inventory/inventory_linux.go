@@ -0,0 +1,350 @@
+//go:build linux
+
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dmiDir  = "/sys/class/dmi/id"
+	usbDir  = "/sys/bus/usb/devices"
+	pciDir  = "/sys/bus/pci/devices"
+	blockFS = "/sys/block"
+)
+
+// packages reads the databases of the package managers present
+func packages() ([]Package, error) {
+	var pkgs []Package
+	var errs []error
+	for _, db := range []struct {
+		manager, path string
+		parse         func([]byte, string) []Package
+	}{
+		{"dpkg", "/var/lib/dpkg/status", parseControl},
+		{"opkg", "/usr/lib/opkg/status", parseControl},
+		{"apk", "/lib/apk/db/installed", parseAPK},
+	} {
+		data, err := os.ReadFile(db.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pkgs = append(pkgs, db.parse(data, db.manager)...)
+	}
+
+	if _, err := exec.LookPath("rpm"); err == nil {
+		rpms, err := rpmPackages()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		pkgs = append(pkgs, rpms...)
+	}
+	sortPackages(pkgs)
+	return pkgs, errors.Join(errs...)
+}
+
+// parseControl reads a dpkg or opkg status file: stanzas of "Field: value"
+// lines separated by blank lines. Only installed packages are kept
+func parseControl(data []byte, manager string) []Package {
+	var pkgs []Package
+	for _, stanza := range bytes.Split(data, []byte("\n\n")) {
+		fields := make(map[string]string)
+		for _, line := range strings.Split(string(stanza), "\n") {
+			if key, value, ok := strings.Cut(line, ":"); ok && !strings.HasPrefix(line, " ") {
+				fields[key] = strings.TrimSpace(value)
+			}
+		}
+		if fields["Package"] == "" || !strings.HasSuffix(fields["Status"], " installed") {
+			continue
+		}
+		pkgs = append(pkgs, Package{
+			Name:    fields["Package"],
+			Version: fields["Version"],
+			Arch:    fields["Architecture"],
+			Manager: manager,
+		})
+	}
+	return pkgs
+}
+
+// parseAPK reads the Alpine installed database, where P: names a package
+// and V: its version
+func parseAPK(data []byte, manager string) []Package {
+	var pkgs []Package
+	var p Package
+	flush := func() {
+		if p.Name != "" {
+			p.Manager = manager
+			pkgs = append(pkgs, p)
+		}
+		p = Package{}
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "P:"):
+			p.Name = line[2:]
+		case strings.HasPrefix(line, "V:"):
+			p.Version = line[2:]
+		case strings.HasPrefix(line, "A:"):
+			p.Arch = line[2:]
+		}
+	}
+	flush()
+	return pkgs
+}
+
+// rpmPackages asks rpm for the installed packages
+func rpmPackages() ([]Package, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "rpm", "-qa", "--qf", `%{NAME}\t%{VERSION}-%{RELEASE}\t%{ARCH}\n`).Output()
+	if err != nil {
+		return nil, fmt.Errorf("rpm: %w", err)
+	}
+	var pkgs []Package
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Split(line, "\t")
+		if len(f) == 3 {
+			pkgs = append(pkgs, Package{Name: f[0], Version: f[1], Arch: f[2], Manager: "rpm"})
+		}
+	}
+	return pkgs, nil
+}
+
+// firmware reports BIOS, device tree and CPU microcode versions
+func firmware() (map[string]string, error) {
+	fw := make(map[string]string)
+	for _, attr := range []string{"bios_vendor", "bios_version", "bios_date", "bios_release", "ec_firmware_release"} {
+		if v := readAttr(filepath.Join(dmiDir, attr)); v != "" {
+			fw[attr] = v
+		}
+	}
+	if v := readAttr("/proc/device-tree/model"); v != "" {
+		fw["device_tree_model"] = v
+	}
+	if v := cpuinfoField("microcode"); v != "" {
+		fw["microcode"] = v
+	}
+	return fw, nil
+}
+
+// network reports interfaces, the default gateway and DNS servers
+func network() (map[string]interface{}, error) {
+	ifaces, err := interfaces()
+	if err != nil {
+		return nil, err
+	}
+	n := map[string]interface{}{"interfaces": ifaces}
+	if gw := defaultGateways(); len(gw) > 0 {
+		n["gateways"] = gw
+	}
+	if dns := nameservers(); len(dns) > 0 {
+		n["dns"] = dns
+	}
+	return n, nil
+}
+
+// defaultGateways reads the default routes from /proc/net/route
+func defaultGateways() []map[string]string {
+	data, err := os.ReadFile("/proc/net/route")
+	if err != nil {
+		return nil
+	}
+	var out []map[string]string
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		f := strings.Fields(line)
+		if len(f) < 3 || f[1] != "00000000" {
+			continue
+		}
+		gw, err := strconv.ParseUint(f[2], 16, 32)
+		if err != nil {
+			continue
+		}
+		// The address is stored little-endian
+		ip := fmt.Sprintf("%d.%d.%d.%d", byte(gw), byte(gw>>8), byte(gw>>16), byte(gw>>24))
+		out = append(out, map[string]string{"interface": f[0], "gateway": ip})
+	}
+	return out
+}
+
+// nameservers reads the DNS servers from resolv.conf
+func nameservers() []string {
+	data, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if f := strings.Fields(line); len(f) >= 2 && f[0] == "nameserver" {
+			out = append(out, f[1])
+		}
+	}
+	return out
+}
+
+// disks lists block devices with their partitions and mounts, leaving out
+// loop and RAM devices
+func disks() ([]Disk, error) {
+	entries, err := os.ReadDir(blockFS)
+	if err != nil {
+		return nil, err
+	}
+	mounts := mountTable()
+
+	var out []Disk
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "zram") {
+			continue
+		}
+		dir := filepath.Join(blockFS, name)
+		d := Disk{
+			Name:       name,
+			Size:       sectors(dir),
+			Model:      readAttr(filepath.Join(dir, "device", "model")),
+			Removable:  readAttr(filepath.Join(dir, "removable")) == "1",
+			Rotational: readAttr(filepath.Join(dir, "queue", "rotational")) == "1",
+		}
+		if m, ok := mounts["/dev/"+name]; ok {
+			d.Mount, d.FSType = m[0], m[1]
+		}
+
+		parts, _ := os.ReadDir(dir)
+		for _, p := range parts {
+			if _, err := os.Stat(filepath.Join(dir, p.Name(), "partition")); err != nil {
+				continue
+			}
+			part := Partition{Name: p.Name(), Size: sectors(filepath.Join(dir, p.Name()))}
+			if m, ok := mounts["/dev/"+p.Name()]; ok {
+				part.Mount, part.FSType = m[0], m[1]
+			}
+			d.Partitions = append(d.Partitions, part)
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// sectors returns the size of a block device in bytes
+func sectors(dir string) uint64 {
+	n, _ := strconv.ParseUint(readAttr(filepath.Join(dir, "size")), 10, 64)
+	return n * 512
+}
+
+// mountTable maps devices to their first mount point and filesystem type
+func mountTable() map[string][2]string {
+	mounts := make(map[string][2]string)
+	data, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return mounts
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 3 || !strings.HasPrefix(f[0], "/dev/") {
+			continue
+		}
+		if _, ok := mounts[f[0]]; !ok {
+			mounts[f[0]] = [2]string{f[1], f[2]}
+		}
+	}
+	return mounts
+}
+
+// usbDevices lists USB devices; sysfs entries of their interfaces are
+// left out
+func usbDevices() ([]USBDevice, error) {
+	entries, err := os.ReadDir(usbDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []USBDevice
+	for _, e := range entries {
+		dir := filepath.Join(usbDir, e.Name())
+		vendor := readAttr(filepath.Join(dir, "idVendor"))
+		if vendor == "" {
+			continue // An interface, not a device
+		}
+		out = append(out, USBDevice{
+			Path:         e.Name(),
+			VendorID:     vendor,
+			ProductID:    readAttr(filepath.Join(dir, "idProduct")),
+			Manufacturer: readAttr(filepath.Join(dir, "manufacturer")),
+			Product:      readAttr(filepath.Join(dir, "product")),
+			Serial:       readAttr(filepath.Join(dir, "serial")),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+// pciDevices lists PCI devices with their bound driver
+func pciDevices() ([]PCIDevice, error) {
+	entries, err := os.ReadDir(pciDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []PCIDevice
+	for _, e := range entries {
+		dir := filepath.Join(pciDir, e.Name())
+		d := PCIDevice{
+			Slot:     e.Name(),
+			VendorID: strings.TrimPrefix(readAttr(filepath.Join(dir, "vendor")), "0x"),
+			DeviceID: strings.TrimPrefix(readAttr(filepath.Join(dir, "device")), "0x"),
+			Class:    strings.TrimPrefix(readAttr(filepath.Join(dir, "class")), "0x"),
+		}
+		if target, err := os.Readlink(filepath.Join(dir, "driver")); err == nil {
+			d.Driver = filepath.Base(target)
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// readAttr reads a sysfs attribute, stripping NULs and whitespace
+func readAttr(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", ""))
+}
+
+// cpuinfoField returns the first value of a /proc/cpuinfo field
+func cpuinfoField(name string) string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(key) == name {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
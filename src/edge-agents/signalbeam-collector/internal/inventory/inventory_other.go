@@ -0,0 +1,51 @@
+//go:build !linux
+
+package inventory
+
+import "github.com/shirou/gopsutil/v3/disk"
+
+// packages is only read from Linux package databases
+func packages() ([]Package, error) {
+	return nil, nil
+}
+
+// firmware versions come from sysfs, which only Linux has
+func firmware() (map[string]string, error) {
+	return nil, nil
+}
+
+// network reports the interfaces
+func network() (map[string]interface{}, error) {
+	ifaces, err := interfaces()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"interfaces": ifaces}, nil
+}
+
+// disks reports mounted partitions, the layout the platform exposes
+func disks() ([]Disk, error) {
+	parts, err := disk.Partitions(false)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Disk, 0, len(parts))
+	for _, p := range parts {
+		d := Disk{Name: p.Device, Mount: p.Mountpoint, FSType: p.Fstype}
+		if usage, err := disk.Usage(p.Mountpoint); err == nil {
+			d.Size = usage.Total
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// usbDevices are listed from sysfs, which only Linux has
+func usbDevices() ([]USBDevice, error) {
+	return nil, nil
+}
+
+// pciDevices are listed from sysfs, which only Linux has
+func pciDevices() ([]PCIDevice, error) {
+	return nil, nil
+}
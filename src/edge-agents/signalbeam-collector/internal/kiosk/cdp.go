@@ -0,0 +1,132 @@
+package kiosk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultExpression is true once the page and its resources have loaded
+const DefaultExpression = "document.readyState === 'complete'"
+
+// CDP checks the page of a browser started with --remote-debugging-port
+type CDP struct {
+	URL        string // DevTools endpoint, e.g. http://127.0.0.1:9222
+	Page       string // Text the page URL must contain; default the first page
+	Expression string // JavaScript evaluated in the page; the page is ready when it is true
+}
+
+// PageResult describes the page checked
+type PageResult struct {
+	URL   string
+	Title string
+	Ready bool
+}
+
+// target is an entry of the DevTools target list
+type target struct {
+	Type                 string `json:"type"`
+	URL                  string `json:"url"`
+	Title                string `json:"title"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// Check finds the page and evaluates the expression in it
+func (c CDP) Check(ctx context.Context, client *http.Client) (PageResult, error) {
+	page, err := c.findPage(ctx, client)
+	if err != nil {
+		return PageResult{}, err
+	}
+	r := PageResult{URL: page.URL, Title: page.Title}
+	if page.WebSocketDebuggerURL == "" {
+		// Another DevTools client is attached to the page
+		return r, fmt.Errorf("page %s is attached to another debugger", page.URL)
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, page.WebSocketDebuggerURL, nil)
+	if err != nil {
+		return r, fmt.Errorf("CDP: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+		conn.SetWriteDeadline(deadline)
+	}
+
+	expression := c.Expression
+	if expression == "" {
+		expression = DefaultExpression
+	}
+	err = conn.WriteJSON(map[string]interface{}{
+		"id":     1,
+		"method": "Runtime.evaluate",
+		"params": map[string]interface{}{"expression": expression, "returnByValue": true},
+	})
+	if err != nil {
+		return r, fmt.Errorf("CDP: %w", err)
+	}
+	for {
+		var reply struct {
+			ID     int `json:"id"`
+			Result struct {
+				Result struct {
+					Value json.RawMessage `json:"value"`
+				} `json:"result"`
+				ExceptionDetails *struct {
+					Text string `json:"text"`
+				} `json:"exceptionDetails"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := conn.ReadJSON(&reply); err != nil {
+			return r, fmt.Errorf("CDP: %w", err)
+		}
+		if reply.ID != 1 {
+			// An event of the page
+			continue
+		}
+		switch {
+		case reply.Error != nil:
+			return r, fmt.Errorf("CDP: %s", reply.Error.Message)
+		case reply.Result.ExceptionDetails != nil:
+			return r, fmt.Errorf("CDP: expression failed: %s", reply.Result.ExceptionDetails.Text)
+		}
+		r.Ready = string(reply.Result.Result.Value) == "true"
+		return r, nil
+	}
+}
+
+// findPage lists the targets of the browser and returns the page checked
+func (c CDP) findPage(ctx context.Context, client *http.Client) (target, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.URL, "/")+"/json/list", nil)
+	if err != nil {
+		return target{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return target{}, fmt.Errorf("CDP: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return target{}, fmt.Errorf("CDP: target list returned status %d", resp.StatusCode)
+	}
+	var targets []target
+	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
+		return target{}, fmt.Errorf("CDP: invalid target list: %w", err)
+	}
+	for _, t := range targets {
+		if t.Type == "page" && strings.Contains(t.URL, c.Page) {
+			return t, nil
+		}
+	}
+	if c.Page != "" {
+		return target{}, fmt.Errorf("CDP: no page with %q in its URL", c.Page)
+	}
+	return target{}, fmt.Errorf("CDP: browser has no page open")
+}
@@ -0,0 +1,198 @@
+// Package kiosk probes a local kiosk application: its HTTP endpoint, the
+// presence of its process and, over the Chrome DevTools Protocol, whether
+// the page in the browser finished loading
+package kiosk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// maxBodyBytes bounds how much of a response body is searched
+const maxBodyBytes = 1 << 20
+
+// Probe describes the checks; those left empty are skipped
+type Probe struct {
+	URL      string // HTTP endpoint of the application
+	Status   int    // Expected status; 0 accepts any below 400
+	Contains string // Text the response body must contain
+	Process  string // Process name, e.g. chromium
+	CDP      CDP
+	Timeout  time.Duration // Per check
+	Client   *http.Client
+}
+
+// Result is the outcome of one run of the checks
+type Result struct {
+	Healthy bool
+	Reason  string // Of the first failed check
+	HTTP    *HTTPResult
+	Process *ProcessResult
+	Page    *PageResult
+}
+
+// HTTPResult is the outcome of the HTTP check
+type HTTPResult struct {
+	Status  int
+	Latency time.Duration
+	Err     error
+}
+
+// ProcessResult describes the oldest process of the name
+type ProcessResult struct {
+	Running bool
+	PID     int32
+	Started time.Time
+	RSS     uint64
+	CPU     float64 // Percent since the process started
+}
+
+// Map returns the results as a payload map
+func (r Result) Map() map[string]interface{} {
+	m := map[string]interface{}{"healthy": r.Healthy}
+	if r.Reason != "" {
+		m["reason"] = r.Reason
+	}
+	if h := r.HTTP; h != nil {
+		check := map[string]interface{}{"ok": h.Err == nil, "latency_ms": float64(h.Latency.Microseconds()) / 1000}
+		if h.Status != 0 {
+			check["status_code"] = h.Status
+		}
+		m["http"] = check
+	}
+	if p := r.Process; p != nil {
+		proc := map[string]interface{}{"running": p.Running}
+		if p.Running {
+			proc["pid"] = p.PID
+			proc["uptime_s"] = int64(time.Since(p.Started).Seconds())
+			proc["rss"] = p.RSS
+			proc["cpu_percent"] = p.CPU
+		}
+		m["process"] = proc
+	}
+	if p := r.Page; p != nil {
+		page := map[string]interface{}{"ready": p.Ready}
+		if p.URL != "" {
+			page["url"] = p.URL
+		}
+		if p.Title != "" {
+			page["title"] = p.Title
+		}
+		m["page"] = page
+	}
+	return m
+}
+
+// Run runs the checks. The result is healthy when every configured check
+// passed
+func (p *Probe) Run(ctx context.Context) Result {
+	r := Result{Healthy: true}
+	fail := func(reason string) {
+		if r.Healthy {
+			r.Healthy, r.Reason = false, reason
+		}
+	}
+
+	if p.Process != "" {
+		proc, err := findProcess(p.Process)
+		switch {
+		case err != nil:
+			fail(err.Error())
+		case !proc.Running:
+			fail(fmt.Sprintf("process %s is not running", p.Process))
+		}
+		r.Process = &proc
+	}
+	if p.URL != "" {
+		h := p.checkHTTP(ctx)
+		if h.Err != nil {
+			fail(h.Err.Error())
+		}
+		r.HTTP = &h
+	}
+	if p.CDP.URL != "" {
+		cctx, cancel := context.WithTimeout(ctx, p.Timeout)
+		page, err := p.CDP.Check(cctx, p.Client)
+		cancel()
+		switch {
+		case err != nil:
+			fail(err.Error())
+		case !page.Ready:
+			fail(fmt.Sprintf("page %s is not ready", page.URL))
+		}
+		r.Page = &page
+	}
+	return r
+}
+
+// checkHTTP requests the endpoint
+func (p *Probe) checkHTTP(ctx context.Context) HTTPResult {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return HTTPResult{Err: err}
+	}
+	req.Header.Set("User-Agent", "signalbeam-collector/0.1.0")
+
+	start := time.Now()
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return HTTPResult{Latency: time.Since(start), Err: err}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	r := HTTPResult{Status: resp.StatusCode, Latency: time.Since(start)}
+	switch {
+	case err != nil:
+		r.Err = fmt.Errorf("failed to read response: %w", err)
+	case p.Status != 0 && resp.StatusCode != p.Status:
+		r.Err = fmt.Errorf("unexpected status %d, expected %d", resp.StatusCode, p.Status)
+	case p.Status == 0 && resp.StatusCode >= 400:
+		r.Err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+	case p.Contains != "" && !strings.Contains(string(body), p.Contains):
+		r.Err = fmt.Errorf("response does not contain %q", p.Contains)
+	}
+	return r
+}
+
+// findProcess looks up the oldest process of the name, the parent of a
+// browser's helper processes
+func findProcess(name string) (ProcessResult, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return ProcessResult{}, fmt.Errorf("failed to list processes: %w", err)
+	}
+	var found *process.Process
+	var started int64
+	for _, proc := range procs {
+		if n, err := proc.Name(); err != nil || n != name {
+			continue
+		}
+		created, err := proc.CreateTime()
+		if err != nil {
+			continue
+		}
+		if found == nil || created < started {
+			found, started = proc, created
+		}
+	}
+	if found == nil {
+		return ProcessResult{}, nil
+	}
+
+	r := ProcessResult{Running: true, PID: found.Pid, Started: time.UnixMilli(started)}
+	if mem, err := found.MemoryInfo(); err == nil {
+		r.RSS = mem.RSS
+	}
+	if cpu, err := found.CPUPercent(); err == nil {
+		r.CPU = cpu
+	}
+	return r, nil
+}
@@ -0,0 +1,261 @@
+package localapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/audit"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/fips"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// Role controls what a local API client may do
+type Role string
+
+const (
+	// RoleRead may query health and status
+	RoleRead Role = "read"
+	// RoleAdmin may additionally trigger actions
+	RoleAdmin Role = "admin"
+)
+
+// Provider exposes collector state and actions to the local API
+type Provider interface {
+	Status() map[string]interface{}
+	CollectNow()
+	Traces() []trace.Trace
+	CheckACL(ctx context.Context) (map[string]interface{}, error)
+}
+
+// Server is the local HTTP API protected by token or mTLS authentication
+type Server struct {
+	cfg      config.LocalAPIConfig
+	provider Provider
+	logger   *logrus.Entry
+	audit    *audit.Log
+	http     *http.Server
+	tokens   map[string]config.LocalAPIToken // sha256 hex -> token
+}
+
+// identity is an authenticated caller
+type identity struct {
+	name string
+	role Role
+}
+
+// New creates a local API server; it does not start listening
+func New(cfg config.LocalAPIConfig, provider Provider, auditLog *audit.Log, logger *logrus.Entry) (*Server, error) {
+	s := &Server{
+		cfg:      cfg,
+		provider: provider,
+		audit:    auditLog,
+		logger:   logger.WithField("component", "local-api"),
+		tokens:   make(map[string]config.LocalAPIToken),
+	}
+
+	for _, t := range cfg.Tokens {
+		s.tokens[strings.ToLower(t.SHA256)] = t
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/health", s.require(RoleRead, http.HandlerFunc(s.handleHealth)))
+	mux.Handle("/api/v1/status", s.require(RoleRead, http.HandlerFunc(s.handleStatus)))
+	mux.Handle("/api/v1/collect", s.require(RoleAdmin, http.HandlerFunc(s.handleCollect)))
+	mux.Handle("/api/v1/audit", s.require(RoleAdmin, http.HandlerFunc(s.handleAudit)))
+	mux.Handle("/api/v1/traces", s.require(RoleAdmin, http.HandlerFunc(s.handleTraces)))
+	mux.Handle("/api/v1/acl-check", s.require(RoleAdmin, http.HandlerFunc(s.handleACLCheck)))
+
+	s.http = &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if cfg.TLS.CertFile != "" {
+		tlsCfg, err := serverTLS(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		s.http.TLSConfig = tlsCfg
+	}
+
+	return s, nil
+}
+
+// Start listens in the background until Shutdown is called
+func (s *Server) Start() {
+	go func() {
+		var err error
+		if s.http.TLSConfig != nil {
+			err = s.http.ListenAndServeTLS(s.cfg.TLS.CertFile, s.cfg.TLS.KeyFile)
+		} else {
+			err = s.http.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.WithError(err).Error("Local API stopped")
+		}
+	}()
+	s.logger.WithField("listen", s.cfg.Listen).Info("Local API listening")
+}
+
+// Shutdown stops the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
+}
+
+// require wraps a handler with authentication and a minimum role. Every
+// request to an admin endpoint is audited, including rejected ones
+func (s *Server) require(min Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := s.authenticate(r)
+		if !ok {
+			if min == RoleAdmin {
+				s.record(identity{name: "anonymous"}, r, "denied")
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="signalbeam"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		if min == RoleAdmin && id.role != RoleAdmin {
+			s.record(id, r, "denied")
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		if min == RoleAdmin && r.Method != http.MethodGet {
+			s.record(id, r, "accepted")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// record appends an audit entry for a local API request
+func (s *Server) record(id identity, r *http.Request, result string) {
+	details := map[string]interface{}{
+		"method": r.Method,
+		"remote": r.RemoteAddr,
+	}
+	if _, err := s.audit.Append("local-api:"+id.name, "api.request", r.URL.Path, result, details); err != nil {
+		s.logger.WithError(err).Warn("Failed to write audit entry")
+	}
+}
+
+// authenticate resolves the caller from a bearer token or a verified client
+// certificate
+func (s *Server) authenticate(r *http.Request) (identity, bool) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if role, ok := s.cfg.TLS.ClientRoles[cn]; ok {
+			return identity{name: "cert:" + cn, role: Role(role)}, true
+		}
+	}
+
+	auth := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok || token == "" {
+		return identity{}, false
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	presented := hex.EncodeToString(sum[:])
+	for hash, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(presented)) == 1 {
+			return identity{name: "token:" + t.Name, role: Role(t.Role)}, true
+		}
+	}
+	return identity{}, false
+}
+
+// handleHealth reports liveness
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleStatus returns the current heartbeat payload
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.provider.Status())
+}
+
+// handleCollect triggers an immediate metrics collection
+func (s *Server) handleCollect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	go s.provider.CollectNow()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "collecting"})
+}
+
+// handleAudit returns audit entries after the optional since sequence number
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	entries, err := s.audit.Since(since, 0)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleTraces returns the most recent sampled pipeline traces
+func (s *Server) handleTraces(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.provider.Traces())
+}
+
+// handleACLCheck checks the broker ACLs of the device and returns the
+// outcome per topic
+func (s *Server) handleACLCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	report, err := s.provider.CheckACL(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// serverTLS builds the TLS config, requiring client certificates when a
+// client CA is configured
+func serverTLS(cfg config.LocalAPITLSConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	fips.Restrict(tlsCfg)
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAFile)
+		}
+		tlsCfg.ClientCAs = pool
+		// Tokens remain usable, so certificates are verified but optional
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsCfg, nil
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,513 @@
+// Package logarchive keeps a local copy of collected log lines in gzip
+// segments. Sealed segments are recorded with their SHA-256 in a hash-chained
+// manifest, so altering or deleting a segment outside the retention rules is
+// detected
+package logarchive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// manifestName is the chained list of sealed and expired segments
+const manifestName = "manifest.ndjson"
+
+// segmentStamp names segments; it sorts chronologically
+const segmentStamp = "20060102T150405.000Z"
+
+// Manifest actions
+const (
+	Sealed  = "seal"
+	Expired = "expire"
+)
+
+// Options bound the archive. Zero values disable a bound
+type Options struct {
+	Dir          string
+	SegmentBytes int64         // Compressed size at which a segment is sealed
+	SegmentAge   time.Duration // Age at which a segment is sealed
+	MaxBytes     int64         // Total size of sealed segments
+	MaxAge       time.Duration // Age of sealed segments, counted from sealing
+	// MinAge protects segments from deletion for size, for sites that must
+	// keep logs for a fixed period
+	MinAge time.Duration
+}
+
+// Entry is one manifest record. Hash covers every other field including
+// Prev, as in the audit log
+type Entry struct {
+	Seq    int64  `json:"seq"`
+	Action string `json:"action"`
+	File   string `json:"file"`
+	Time   int64  `json:"time"`             // When the entry was written
+	Opened int64  `json:"opened,omitempty"` // First line archived in the segment
+	Lines  int64  `json:"lines,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Reason string `json:"reason,omitempty"` // Why a segment expired
+	Prev   string `json:"prev"`
+	Hash   string `json:"hash"`
+}
+
+// line is one archived log line
+type line struct {
+	Time   string `json:"time"`
+	Source string `json:"source"`
+	Line   string `json:"line"`
+}
+
+// Archive appends log lines to the open segment
+type Archive struct {
+	opts Options
+
+	mu     sync.Mutex
+	seq    int64
+	head   string
+	sealed []Entry // Sealed segments not yet expired, oldest first
+
+	file    *os.File
+	counter *countingWriter
+	gz      *gzip.Writer
+	name    string
+	opened  time.Time
+	lines   int64
+
+	// overBudget is set while segments are kept beyond MaxBytes for MinAge
+	overBudget bool
+}
+
+// Open verifies the manifest and seals a segment left open by a previous
+// run
+func Open(opts Options) (*Archive, error) {
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create log archive: %w", err)
+	}
+	a := &Archive{opts: opts}
+
+	entries, err := readManifest(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyChain(entries); err != nil {
+		return nil, fmt.Errorf("log archive %s: %w", opts.Dir, err)
+	}
+	if n := len(entries); n > 0 {
+		a.seq, a.head = entries[n-1].Seq, entries[n-1].Hash
+	}
+	a.sealed = live(entries)
+
+	known := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		known[e.File] = true
+	}
+	for _, name := range segments(opts.Dir) {
+		if !known[name] {
+			if err := a.seal(name, time.Time{}, -1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return a, nil
+}
+
+// Append archives lines read from source at t
+func (a *Archive) Append(source string, t time.Time, lines []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.gz != nil && a.due(t) {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	if a.gz == nil {
+		if err := a.create(t); err != nil {
+			return err
+		}
+	}
+
+	enc := json.NewEncoder(a.gz)
+	stamp := t.UTC().Format(time.RFC3339Nano)
+	for _, l := range lines {
+		if err := enc.Encode(line{Time: stamp, Source: source, Line: l}); err != nil {
+			return fmt.Errorf("failed to archive log lines: %w", err)
+		}
+	}
+	a.lines += int64(len(lines))
+	// Flushed so a crash loses no archived line, only the gzip trailer
+	if err := a.gz.Flush(); err != nil {
+		return fmt.Errorf("failed to archive log lines: %w", err)
+	}
+	return nil
+}
+
+// Tick seals the open segment once it is old enough and applies retention
+func (a *Archive) Tick(now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.gz != nil && a.due(now) {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	return a.expire(now)
+}
+
+// Close seals the open segment
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.gz == nil {
+		return nil
+	}
+	return a.rotate()
+}
+
+// Status reports the archive for the heartbeat. The chain head lets the
+// Control Plane notice a rewritten manifest
+func (a *Archive) Status() map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var bytes int64
+	for _, e := range a.sealed {
+		bytes += e.Bytes
+	}
+	status := map[string]interface{}{
+		"segments":    len(a.sealed),
+		"bytes":       bytes,
+		"head_seq":    a.seq,
+		"head":        a.head,
+		"over_budget": a.overBudget,
+	}
+	if len(a.sealed) > 0 {
+		status["oldest"] = a.sealed[0].Opened
+	}
+	return status
+}
+
+// OverBudget reports whether segments younger than MinAge are kept beyond
+// MaxBytes
+func (a *Archive) OverBudget() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.overBudget
+}
+
+// due reports whether the open segment has reached a bound
+func (a *Archive) due(now time.Time) bool {
+	return (a.opts.SegmentBytes > 0 && a.counter.n >= a.opts.SegmentBytes) ||
+		(a.opts.SegmentAge > 0 && now.Sub(a.opened) >= a.opts.SegmentAge)
+}
+
+// create opens a new segment
+func (a *Archive) create(t time.Time) error {
+	name := "segment-" + t.UTC().Format(segmentStamp) + ".ndjson.gz"
+	f, err := os.OpenFile(filepath.Join(a.opts.Dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create log segment: %w", err)
+	}
+	a.file, a.name, a.opened, a.lines = f, name, t, 0
+	a.counter = &countingWriter{w: f}
+	a.gz = gzip.NewWriter(a.counter)
+	return nil
+}
+
+// rotate closes the open segment and records it in the manifest
+func (a *Archive) rotate() error {
+	err := a.gz.Close()
+	if cErr := a.file.Close(); err == nil {
+		err = cErr
+	}
+	name, opened, lines := a.name, a.opened, a.lines
+	a.gz, a.file, a.counter, a.name = nil, nil, nil, ""
+	if err != nil {
+		return fmt.Errorf("failed to close log segment %s: %w", name, err)
+	}
+	return a.seal(name, opened, lines)
+}
+
+// seal hashes a closed segment and appends it to the manifest. Segments
+// left by a crash have their lines counted here
+func (a *Archive) seal(name string, opened time.Time, lines int64) error {
+	path := filepath.Join(a.opts.Dir, name)
+	sum, size, err := hashFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to hash log segment %s: %w", name, err)
+	}
+	if lines < 0 {
+		lines, opened = recount(path)
+	}
+	e := Entry{Action: Sealed, File: name, Lines: lines, Bytes: size, SHA256: sum}
+	if !opened.IsZero() {
+		e.Opened = opened.UTC().Unix()
+	}
+	e, err = a.append(e)
+	if err != nil {
+		return err
+	}
+	a.sealed = append(a.sealed, e)
+	return nil
+}
+
+// expire deletes sealed segments beyond the retention bounds, oldest first
+func (a *Archive) expire(now time.Time) error {
+	var total int64
+	for _, e := range a.sealed {
+		total += e.Bytes
+	}
+
+	a.overBudget = false
+	for len(a.sealed) > 0 {
+		oldest := a.sealed[0]
+		age := now.Sub(time.Unix(oldest.Time, 0))
+		reason := ""
+		switch {
+		case a.opts.MaxAge > 0 && age >= a.opts.MaxAge:
+			reason = "max_age"
+		case a.opts.MaxBytes > 0 && total > a.opts.MaxBytes:
+			if age < a.opts.MinAge {
+				a.overBudget = true
+				return nil
+			}
+			reason = "max_bytes"
+		}
+		if reason == "" {
+			return nil
+		}
+
+		if err := os.Remove(filepath.Join(a.opts.Dir, oldest.File)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete log segment %s: %w", oldest.File, err)
+		}
+		if _, err := a.append(Entry{Action: Expired, File: oldest.File, Reason: reason}); err != nil {
+			return err
+		}
+		total -= oldest.Bytes
+		a.sealed = a.sealed[1:]
+	}
+	return nil
+}
+
+// append chains e to the manifest and returns the stored entry
+func (a *Archive) append(e Entry) (Entry, error) {
+	e.Seq = a.seq + 1
+	e.Time = time.Now().UTC().Unix()
+	e.Prev = a.head
+	hash, err := e.digest()
+	if err != nil {
+		return Entry{}, err
+	}
+	e.Hash = hash
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return Entry{}, err
+	}
+	f, err := os.OpenFile(filepath.Join(a.opts.Dir, manifestName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to open log archive manifest: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return Entry{}, fmt.Errorf("failed to write log archive manifest: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return Entry{}, fmt.Errorf("failed to sync log archive manifest: %w", err)
+	}
+	a.seq, a.head = e.Seq, e.Hash
+	return e, nil
+}
+
+// Problem is a finding of Verify
+type Problem struct {
+	File   string
+	Reason string
+}
+
+// Verify checks the manifest chain and every live segment against its hash.
+// Segments on disk that are not in the manifest are reported too, except a
+// segment still open
+func Verify(dir string) ([]Entry, []Problem, error) {
+	entries, err := readManifest(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := verifyChain(entries); err != nil {
+		return entries, nil, err
+	}
+
+	var problems []Problem
+	known := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		known[e.File] = true
+	}
+	for _, e := range live(entries) {
+		sum, _, err := hashFile(filepath.Join(dir, e.File))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			problems = append(problems, Problem{e.File, "missing"})
+		case err != nil:
+			problems = append(problems, Problem{e.File, err.Error()})
+		case sum != e.SHA256:
+			problems = append(problems, Problem{e.File, "hash mismatch"})
+		}
+	}
+	files := segments(dir)
+	for i, name := range files {
+		if !known[name] && i < len(files)-1 {
+			problems = append(problems, Problem{name, "not in manifest"})
+		}
+	}
+	return entries, problems, nil
+}
+
+// verifyChain checks that entries form an unbroken hash chain
+func verifyChain(entries []Entry) error {
+	prev := ""
+	for _, e := range entries {
+		if e.Prev != prev {
+			return fmt.Errorf("manifest chain broken at seq %d", e.Seq)
+		}
+		hash, err := e.digest()
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash {
+			return fmt.Errorf("manifest hash mismatch at seq %d", e.Seq)
+		}
+		prev = e.Hash
+	}
+	return nil
+}
+
+// live returns the sealed entries that have not expired, oldest first
+func live(entries []Entry) []Entry {
+	expired := make(map[string]bool)
+	for _, e := range entries {
+		if e.Action == Expired {
+			expired[e.File] = true
+		}
+	}
+	var out []Entry
+	for _, e := range entries {
+		if e.Action == Sealed && !expired[e.File] {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// readManifest loads the manifest entries
+func readManifest(dir string) ([]Entry, error) {
+	f, err := os.Open(filepath.Join(dir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log archive manifest: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("corrupt log archive manifest after seq %d: %w", len(entries), err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read log archive manifest: %w", err)
+	}
+	return entries, nil
+}
+
+// segments lists the segment files in the directory, oldest first
+func segments(dir string) []string {
+	matches, _ := filepath.Glob(filepath.Join(dir, "segment-*.ndjson.gz"))
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, filepath.Base(m))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hashFile returns the SHA-256 and size of a file
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// recount reads a segment left open by a crash, up to where it ends, for
+// its line count and the time of its first line
+func recount(path string) (int64, time.Time) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, time.Time{}
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, time.Time{}
+	}
+
+	var lines int64
+	var first time.Time
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if lines == 0 {
+			var l line
+			if json.Unmarshal(scanner.Bytes(), &l) == nil {
+				first, _ = time.Parse(time.RFC3339Nano, l.Time)
+			}
+		}
+		lines++
+	}
+	return lines, first
+}
+
+// digest hashes the entry with its Hash field cleared
+func (e Entry) digest() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal manifest entry: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,209 @@
+// Package logtail follows log files and returns the lines appended since the
+// last read. Read positions are kept in an offsets file so a restart neither
+// skips nor repeats lines
+package logtail
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// maxLine bounds a single line; longer lines are cut
+const maxLine = 16 * 1024
+
+// Batch is the lines read from one file
+type Batch struct {
+	Path  string
+	Lines []string
+	// Cut counts lines shortened to the maximum line length
+	Cut int
+}
+
+// offset is the read position in a file. File identifies the file so a
+// rotated log is read from the start
+type offset struct {
+	File   uint64 `json:"file"`
+	Offset int64  `json:"offset"`
+}
+
+// Tailer reads new lines from the files matching its patterns
+type Tailer struct {
+	paths    []string
+	exclude  []string
+	maxLines int
+	store    string
+
+	offsets map[string]offset
+	// fresh is set until the first read when no offsets were stored: files
+	// found then are read from their end instead of shipping their history
+	fresh bool
+}
+
+// New creates a tailer for the glob patterns in paths, skipping files whose
+// name matches a pattern in exclude. Offsets are kept in store
+func New(paths, exclude []string, maxLines int, store string) (*Tailer, error) {
+	t := &Tailer{
+		paths:    paths,
+		exclude:  exclude,
+		maxLines: maxLines,
+		store:    store,
+		offsets:  make(map[string]offset),
+	}
+
+	data, err := os.ReadFile(store)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		t.fresh = true
+	case err != nil:
+		return nil, fmt.Errorf("failed to read log offsets: %w", err)
+	default:
+		if err := json.Unmarshal(data, &t.offsets); err != nil {
+			return nil, fmt.Errorf("corrupt log offsets %s: %w", store, err)
+		}
+	}
+	return t, nil
+}
+
+// Read returns up to maxLines new complete lines per file. A line still
+// being written is left for the next read
+func (t *Tailer) Read() ([]Batch, error) {
+	files, err := t.files()
+	if err != nil {
+		return nil, err
+	}
+
+	var batches []Batch
+	var errs []error
+	seen := make(map[string]bool, len(files))
+	for _, path := range files {
+		seen[path] = true
+		b, err := t.read(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		if len(b.Lines) > 0 {
+			batches = append(batches, b)
+		}
+	}
+	for path := range t.offsets {
+		if !seen[path] {
+			delete(t.offsets, path)
+		}
+	}
+	t.fresh = false
+	return batches, errors.Join(errs...)
+}
+
+// Save stores the read positions. Call it once the batches were handled
+func (t *Tailer) Save() error {
+	data, err := json.Marshal(t.offsets)
+	if err != nil {
+		return err
+	}
+	tmp := t.store + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write log offsets: %w", err)
+	}
+	return os.Rename(tmp, t.store)
+}
+
+// files expands the patterns, in a stable order
+func (t *Tailer) files() ([]string, error) {
+	var files []string
+	for _, pattern := range t.paths {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log path %q: %w", pattern, err)
+		}
+	next:
+		for _, path := range matches {
+			for _, ex := range t.exclude {
+				if ok, _ := filepath.Match(ex, filepath.Base(path)); ok {
+					continue next
+				}
+			}
+			files = append(files, path)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// read collects the new lines of one file
+func (t *Tailer) read(path string) (Batch, error) {
+	b := Batch{Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return b, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return b, err
+	}
+	if !info.Mode().IsRegular() {
+		return b, nil
+	}
+
+	id := fileID(info)
+	pos, known := t.offsets[path]
+	switch {
+	case !known && t.fresh:
+		pos = offset{File: id, Offset: info.Size()}
+	case !known || pos.File != id || info.Size() < pos.Offset:
+		// New, replaced by rotation, or truncated
+		pos = offset{File: id}
+	}
+	if _, err := f.Seek(pos.Offset, io.SeekStart); err != nil {
+		return b, err
+	}
+
+	r := bufio.NewReaderSize(f, 64*1024)
+	for len(b.Lines) < t.maxLines {
+		line, n, err := readLine(r)
+		if err != nil {
+			break // EOF or a partial line
+		}
+		pos.Offset += int64(n)
+		if len(line) > maxLine {
+			line = line[:maxLine]
+			b.Cut++
+		}
+		b.Lines = append(b.Lines, string(line))
+	}
+	t.offsets[path] = pos
+	return b, nil
+}
+
+// readLine returns the next line without its line ending and the number of
+// bytes consumed. A line without a newline yet is an error
+func readLine(r *bufio.Reader) ([]byte, int, error) {
+	var line []byte
+	n := 0
+	for {
+		chunk, err := r.ReadSlice('\n')
+		n += len(chunk)
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, 0, err
+		}
+		if err == nil {
+			chunk = chunk[:len(chunk)-1]
+			if l := len(chunk); l > 0 && chunk[l-1] == '\r' {
+				chunk = chunk[:l-1]
+			}
+		}
+		if len(line) <= maxLine {
+			line = append(line, chunk...)
+		}
+		if err == nil {
+			return line, n, nil
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const powerSupplyDir = "/sys/class/power_supply"
+
+// getBatteryMetrics reads battery state from sysfs (Linux and Android)
+func (c *Collector) getBatteryMetrics() (map[string]interface{}, error) {
+	entries, err := os.ReadDir(powerSupplyDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read power supplies: %w", err)
+	}
+
+	batteries := make(map[string]interface{})
+	for _, entry := range entries {
+		dir := filepath.Join(powerSupplyDir, entry.Name())
+		if readSysfs(dir, "type") != "Battery" {
+			continue
+		}
+
+		battery := map[string]interface{}{
+			"status": readSysfs(dir, "status"),
+			"health": readSysfs(dir, "health"),
+		}
+		if v, ok := readSysfsInt(dir, "capacity"); ok {
+			battery["capacity_percent"] = v
+		}
+		if v, ok := readSysfsInt(dir, "temp"); ok {
+			battery["temperature_celsius"] = float64(v) / 10
+		}
+		if v, ok := readSysfsInt(dir, "voltage_now"); ok {
+			battery["voltage_volts"] = float64(v) / 1e6
+		}
+		if v, ok := readSysfsInt(dir, "current_now"); ok {
+			battery["current_amps"] = float64(v) / 1e6
+		}
+
+		batteries[entry.Name()] = battery
+	}
+
+	if len(batteries) == 0 {
+		return nil, fmt.Errorf("no battery found")
+	}
+
+	return batteries, nil
+}
+
+// readSysfs returns the trimmed contents of a sysfs attribute
+func readSysfs(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readSysfsInt returns a sysfs attribute parsed as an integer
+func readSysfsInt(dir, name string) (int64, bool) {
+	v, err := strconv.ParseInt(readSysfs(dir, name), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
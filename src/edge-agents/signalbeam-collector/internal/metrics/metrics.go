@@ -3,6 +3,7 @@ package metrics
 import (
 	"fmt"
 	"runtime"
+	"sync"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
@@ -11,12 +12,20 @@ import (
 	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/net"
 	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/ebpf"
 	"github.com/sirupsen/logrus"
 )
 
 // Collector handles system metrics collection
 type Collector struct {
 	logger *logrus.Entry
+
+	ebpfOnce sync.Once
+	ebpfCap  ebpf.Capability
+	ebpf     *ebpf.Insight
+
+	mu       sync.Mutex
+	failures map[string]string
 }
 
 // New creates a new metrics collector
@@ -29,15 +38,18 @@ func New(logger *logrus.Entry) (*Collector, error) {
 // Collect gathers system metrics based on configuration
 func (c *Collector) Collect(cfg config.MetricsConfig) (map[string]interface{}, error) {
 	metrics := make(map[string]interface{})
+	failures := make(map[string]string)
+	defer c.setFailures(failures)
 
 	// Add system info
 	metrics["system"] = c.getSystemInfo()
 
 	// Collect CPU metrics
 	if cfg.CPU {
-		cpuMetrics, err := c.getCPUMetrics()
+		cpuMetrics, err := c.getCPUMetrics(cfg.CPUInfo)
 		if err != nil {
 			c.logger.WithError(err).Warn("Failed to collect CPU metrics")
+			failures["cpu"] = err.Error()
 		} else {
 			metrics["cpu"] = cpuMetrics
 		}
@@ -48,6 +60,7 @@ func (c *Collector) Collect(cfg config.MetricsConfig) (map[string]interface{}, e
 		memMetrics, err := c.getMemoryMetrics()
 		if err != nil {
 			c.logger.WithError(err).Warn("Failed to collect memory metrics")
+			failures["memory"] = err.Error()
 		} else {
 			metrics["memory"] = memMetrics
 		}
@@ -55,9 +68,10 @@ func (c *Collector) Collect(cfg config.MetricsConfig) (map[string]interface{}, e
 
 	// Collect disk metrics
 	if cfg.Disk {
-		diskMetrics, err := c.getDiskMetrics()
+		diskMetrics, err := c.getDiskMetrics(cfg.DiskPaths, cfg.DiskIO)
 		if err != nil {
 			c.logger.WithError(err).Warn("Failed to collect disk metrics")
+			failures["disk"] = err.Error()
 		} else {
 			metrics["disk"] = diskMetrics
 		}
@@ -65,9 +79,10 @@ func (c *Collector) Collect(cfg config.MetricsConfig) (map[string]interface{}, e
 
 	// Collect network metrics
 	if cfg.Network {
-		netMetrics, err := c.getNetworkMetrics()
+		netMetrics, err := c.getNetworkMetrics(cfg.NetworkPerInterface)
 		if err != nil {
 			c.logger.WithError(err).Warn("Failed to collect network metrics")
+			failures["network"] = err.Error()
 		} else {
 			metrics["network"] = netMetrics
 		}
@@ -78,46 +93,130 @@ func (c *Collector) Collect(cfg config.MetricsConfig) (map[string]interface{}, e
 		loadMetrics, err := c.getLoadMetrics()
 		if err != nil {
 			c.logger.WithError(err).Warn("Failed to collect load metrics")
+			failures["load"] = err.Error()
 		} else {
 			metrics["load"] = loadMetrics
 		}
 	}
 
+	// Collect battery metrics
+	if cfg.Battery {
+		batteryMetrics, err := c.getBatteryMetrics()
+		if err != nil {
+			c.logger.WithError(err).Warn("Failed to collect battery metrics")
+			failures["battery"] = err.Error()
+		} else {
+			metrics["battery"] = batteryMetrics
+		}
+	}
+
+	// Collect watched process metrics
+	if len(cfg.Processes) > 0 {
+		procMetrics, err := c.getProcessMetrics(cfg.Processes)
+		if err != nil {
+			c.logger.WithError(err).Warn("Failed to collect process metrics")
+			failures["processes"] = err.Error()
+		} else {
+			metrics["processes"] = procMetrics
+		}
+	}
+
+	// eBPF insight is gated behind a one-time capability check
+	if cfg.EBPF {
+		metrics["ebpf"] = c.getEBPFStatus()
+	}
+
 	return metrics, nil
 }
 
+// Failures returns the inputs that failed during the last collection,
+// keyed by input name
+func (c *Collector) Failures() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failures
+}
+
+// setFailures replaces the failures recorded for the last collection
+func (c *Collector) setFailures(failures map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = failures
+}
+
+// getEBPFStatus probes eBPF support once, starting the programs when the
+// host can run them, and reports the result with what the programs saw
+// since the last collection
+func (c *Collector) getEBPFStatus() map[string]interface{} {
+	c.ebpfOnce.Do(func() {
+		c.ebpfCap = ebpf.Probe()
+		if c.ebpfCap.Supported {
+			insight, err := ebpf.Start()
+			if err != nil {
+				c.ebpfCap.Supported = false
+				c.ebpfCap.Reason = err.Error()
+			}
+			c.ebpf = insight
+		}
+		if !c.ebpfCap.Supported {
+			c.logger.WithField("reason", c.ebpfCap.Reason).Warn("eBPF insight disabled")
+		}
+	})
+
+	status := c.ebpfCap.Map()
+	if c.ebpf == nil {
+		return status
+	}
+	snapshot, err := c.ebpf.Collect()
+	if err != nil {
+		c.logger.WithError(err).Warn("Failed to read eBPF maps")
+	}
+	for k, v := range snapshot.Map() {
+		status[k] = v
+	}
+	return status
+}
+
+// Close detaches the eBPF programs
+func (c *Collector) Close() error {
+	if c.ebpf == nil {
+		return nil
+	}
+	return c.ebpf.Close()
+}
+
 // getSystemInfo returns basic system information
 func (c *Collector) getSystemInfo() map[string]interface{} {
 	info, err := host.Info()
 	if err != nil {
 		c.logger.WithError(err).Warn("Failed to get host info")
 		return map[string]interface{}{
-			"os":       runtime.GOOS,
-			"arch":     runtime.GOARCH,
-			"cpus":     runtime.NumCPU(),
+			"os":         runtime.GOOS,
+			"arch":       runtime.GOARCH,
+			"cpus":       runtime.NumCPU(),
 			"goroutines": runtime.NumGoroutine(),
 		}
 	}
 
 	return map[string]interface{}{
-		"hostname":          info.Hostname,
-		"uptime":           info.Uptime,
-		"boot_time":        info.BootTime,
-		"procs":            info.Procs,
-		"os":               info.OS,
-		"platform":         info.Platform,
-		"platform_family":  info.PlatformFamily,
-		"platform_version": info.PlatformVersion,
-		"kernel_version":   info.KernelVersion,
-		"kernel_arch":      info.KernelArch,
+		"hostname":              info.Hostname,
+		"uptime":                info.Uptime,
+		"boot_time":             info.BootTime,
+		"procs":                 info.Procs,
+		"os":                    info.OS,
+		"platform":              info.Platform,
+		"platform_family":       info.PlatformFamily,
+		"platform_version":      info.PlatformVersion,
+		"kernel_version":        info.KernelVersion,
+		"kernel_arch":           info.KernelArch,
 		"virtualization_system": info.VirtualizationSystem,
 		"virtualization_role":   info.VirtualizationRole,
-		"host_id":          info.HostID,
+		"host_id":               info.HostID,
 	}
 }
 
 // getCPUMetrics returns CPU usage metrics
-func (c *Collector) getCPUMetrics() (map[string]interface{}, error) {
+func (c *Collector) getCPUMetrics(withInfo bool) (map[string]interface{}, error) {
 	// Get CPU percentages
 	percentages, err := cpu.Percent(0, false)
 	if err != nil {
@@ -130,15 +229,23 @@ func (c *Collector) getCPUMetrics() (map[string]interface{}, error) {
 		return nil, fmt.Errorf("failed to get CPU times: %w", err)
 	}
 
-	// Get CPU info
-	info, err := cpu.Info()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get CPU info: %w", err)
+	// Get CPU info (parses /proc/cpuinfo, skipped in the minimal profile)
+	var info []cpu.InfoStat
+	if withInfo {
+		info, err = cpu.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get CPU info: %w", err)
+		}
+	}
+
+	count := len(info)
+	if !withInfo {
+		count = runtime.NumCPU()
 	}
 
 	metrics := map[string]interface{}{
 		"usage_percent": 0.0,
-		"count":         len(info),
+		"count":         count,
 	}
 
 	if len(percentages) > 0 {
@@ -148,31 +255,32 @@ func (c *Collector) getCPUMetrics() (map[string]interface{}, error) {
 	if len(times) > 0 {
 		t := times[0]
 		metrics["times"] = map[string]interface{}{
-			"user":      t.User,
-			"system":    t.System,
-			"idle":      t.Idle,
-			"nice":      t.Nice,
-			"iowait":    t.Iowait,
-			"irq":       t.Irq,
-			"softirq":   t.Softirq,
-			"steal":     t.Steal,
-			"guest":     t.Guest,
+			"user":       t.User,
+			"system":     t.System,
+			"idle":       t.Idle,
+			"nice":       t.Nice,
+			"iowait":     t.Iowait,
+			"irq":        t.Irq,
+			"softirq":    t.Softirq,
+			"steal":      t.Steal,
+			"guest":      t.Guest,
 			"guest_nice": t.GuestNice,
 		}
+		dropUnsupported("cpu.times", metrics["times"].(map[string]interface{}))
 	}
 
 	if len(info) > 0 {
 		i := info[0]
 		metrics["info"] = map[string]interface{}{
-			"vendor_id":   i.VendorID,
-			"family":      i.Family,
-			"model":       i.Model,
-			"model_name":  i.ModelName,
-			"stepping":    i.Stepping,
-			"mhz":         i.Mhz,
-			"cache_size":  i.CacheSize,
-			"cores":       i.Cores,
-			"flags":       i.Flags,
+			"vendor_id":  i.VendorID,
+			"family":     i.Family,
+			"model":      i.Model,
+			"model_name": i.ModelName,
+			"stepping":   i.Stepping,
+			"mhz":        i.Mhz,
+			"cache_size": i.CacheSize,
+			"cores":      i.Cores,
+			"flags":      i.Flags,
 		}
 	}
 
@@ -193,19 +301,22 @@ func (c *Collector) getMemoryMetrics() (map[string]interface{}, error) {
 		return nil, fmt.Errorf("failed to get swap memory stats: %w", err)
 	}
 
+	virtual := map[string]interface{}{
+		"total":        vmem.Total,
+		"available":    vmem.Available,
+		"used":         vmem.Used,
+		"used_percent": vmem.UsedPercent,
+		"free":         vmem.Free,
+		"active":       vmem.Active,
+		"inactive":     vmem.Inactive,
+		"buffers":      vmem.Buffers,
+		"cached":       vmem.Cached,
+		"shared":       vmem.Shared,
+	}
+	dropUnsupported("memory.virtual", virtual)
+
 	return map[string]interface{}{
-		"virtual": map[string]interface{}{
-			"total":        vmem.Total,
-			"available":    vmem.Available,
-			"used":         vmem.Used,
-			"used_percent": vmem.UsedPercent,
-			"free":         vmem.Free,
-			"active":       vmem.Active,
-			"inactive":     vmem.Inactive,
-			"buffers":      vmem.Buffers,
-			"cached":       vmem.Cached,
-			"shared":       vmem.Shared,
-		},
+		"virtual": virtual,
 		"swap": map[string]interface{}{
 			"total":        swap.Total,
 			"used":         swap.Used,
@@ -216,17 +327,20 @@ func (c *Collector) getMemoryMetrics() (map[string]interface{}, error) {
 }
 
 // getDiskMetrics returns disk usage metrics
-func (c *Collector) getDiskMetrics() (map[string]interface{}, error) {
+func (c *Collector) getDiskMetrics(paths []string, withIO bool) (map[string]interface{}, error) {
 	// Get disk usage for root partition
 	usage, err := disk.Usage("/")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get disk usage: %w", err)
 	}
 
-	// Get disk IO stats
-	ioStats, err := disk.IOCounters()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get disk IO stats: %w", err)
+	// Get disk IO stats (restricted on Android, so not fatal)
+	var ioStats map[string]disk.IOCountersStat
+	if withIO {
+		ioStats, err = disk.IOCounters()
+		if err != nil {
+			c.logger.WithError(err).Debug("Failed to get disk IO stats")
+		}
 	}
 
 	metrics := map[string]interface{}{
@@ -241,15 +355,35 @@ func (c *Collector) getDiskMetrics() (map[string]interface{}, error) {
 		"io": make(map[string]interface{}),
 	}
 
+	// Add usage for additional mount points
+	if len(paths) > 0 {
+		mounts := make(map[string]interface{}, len(paths))
+		for _, path := range paths {
+			u, err := disk.Usage(path)
+			if err != nil {
+				c.logger.WithError(err).WithField("path", path).Warn("Failed to get disk usage")
+				continue
+			}
+			mounts[path] = map[string]interface{}{
+				"fstype":       u.Fstype,
+				"total":        u.Total,
+				"free":         u.Free,
+				"used":         u.Used,
+				"used_percent": u.UsedPercent,
+			}
+		}
+		metrics["mounts"] = mounts
+	}
+
 	// Add IO stats for each disk
 	for name, stat := range ioStats {
 		metrics["io"].(map[string]interface{})[name] = map[string]interface{}{
-			"read_count":   stat.ReadCount,
-			"read_bytes":   stat.ReadBytes,
-			"read_time":    stat.ReadTime,
-			"write_count":  stat.WriteCount,
-			"write_bytes":  stat.WriteBytes,
-			"write_time":   stat.WriteTime,
+			"read_count":  stat.ReadCount,
+			"read_bytes":  stat.ReadBytes,
+			"read_time":   stat.ReadTime,
+			"write_count": stat.WriteCount,
+			"write_bytes": stat.WriteBytes,
+			"write_time":  stat.WriteTime,
 		}
 	}
 
@@ -257,15 +391,15 @@ func (c *Collector) getDiskMetrics() (map[string]interface{}, error) {
 }
 
 // getNetworkMetrics returns network interface metrics
-func (c *Collector) getNetworkMetrics() (map[string]interface{}, error) {
-	// Get network IO stats
-	ioStats, err := net.IOCounters(true)
+func (c *Collector) getNetworkMetrics(perInterface bool) (map[string]interface{}, error) {
+	// Get network IO stats, aggregated into "all" unless per-interface is enabled
+	ioStats, err := net.IOCounters(perInterface)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get network IO stats: %w", err)
 	}
 
 	interfaces := make(map[string]interface{})
-	
+
 	for _, stat := range ioStats {
 		interfaces[stat.Name] = map[string]interface{}{
 			"bytes_sent":   stat.BytesSent,
@@ -296,4 +430,11 @@ func (c *Collector) getLoadMetrics() (map[string]interface{}, error) {
 		"load5":  loadAvg.Load5,
 		"load15": loadAvg.Load15,
 	}, nil
-}
\ No newline at end of file
+}
+
+// dropUnsupported removes fields the current platform cannot report
+func dropUnsupported(group string, fields map[string]interface{}) {
+	for _, name := range unsupportedFields[group] {
+		delete(fields, name)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build freebsd || openbsd
+
+package metrics
+
+// unsupportedFields lists metric fields that gopsutil reports as zero on the
+// BSDs because the kernel does not expose them. They are dropped rather than
+// published as misleading zeros.
+var unsupportedFields = map[string][]string{
+	"cpu.times":      {"iowait", "steal", "guest", "guest_nice", "softirq"},
+	"memory.virtual": {"shared", "buffers"},
+}
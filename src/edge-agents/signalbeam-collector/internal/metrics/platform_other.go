@@ -0,0 +1,6 @@
+//go:build !freebsd && !openbsd
+
+package metrics
+
+// unsupportedFields lists metric fields unavailable on this platform
+var unsupportedFields = map[string][]string{}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// getProcessMetrics reports presence and resource usage of the named processes
+func (c *Collector) getProcessMetrics(names []string) (map[string]interface{}, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	result := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		result[name] = map[string]interface{}{"running": false}
+	}
+
+	for _, p := range procs {
+		name, err := p.Name()
+		if err != nil {
+			continue
+		}
+		if _, wanted := result[name]; !wanted {
+			continue
+		}
+
+		entry := map[string]interface{}{
+			"running": true,
+			"pid":     p.Pid,
+		}
+		if cpuPercent, err := p.CPUPercent(); err == nil {
+			entry["cpu_percent"] = cpuPercent
+		}
+		if memInfo, err := p.MemoryInfo(); err == nil {
+			entry["rss"] = memInfo.RSS
+		}
+		result[name] = entry
+	}
+
+	return result, nil
+}
@@ -0,0 +1,793 @@
+package mqtt5
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/eclipse/paho.golang/paho/session/state"
+	"github.com/eclipse/paho.golang/paho/store/file"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Options are the MQTT 5 features layered on top of the client options
+type Options struct {
+	// TopicAliases is the number of topic aliases to use per connection,
+	// capped by the broker's Topic Alias Maximum. 0 disables aliases
+	TopicAliases uint16
+
+	// UserProperties are attached to every publish
+	UserProperties map[string]string
+
+	// SessionExpiry keeps the session on the broker for this long after a
+	// disconnect. Used when the client options disable clean sessions
+	SessionExpiry time.Duration
+
+	// SessionDir keeps the session state, messages in flight and packet
+	// IDs, in files so it survives restarts. Used with SessionExpiry; empty
+	// keeps it in memory
+	SessionDir string
+
+	// WillDelay holds back the will message for this long after an
+	// unexpected disconnect, so brief drops do not publish it
+	WillDelay time.Duration
+
+	// ReconnectBackoff returns the delay before reconnect attempt n, counted
+	// from 0 after the connection drops. Defaults to an exponential backoff
+	// up to the client options' MaxReconnectInterval
+	ReconnectBackoff func(n int) time.Duration
+}
+
+// Client is an MQTT 5 client implementing the paho MQTT 3.1.1 client
+// interface, so the collector can switch protocol versions from config. It
+// is configured from the same client options, including the connect,
+// connection lost, reconnecting and custom dialer handlers
+type Client struct {
+	opts   *mqtt.ClientOptions
+	reader mqtt.ClientOptionsReader
+	v5     Options
+	props  paho.UserProperties
+
+	mu        sync.Mutex
+	cm        *autopaho.ConnectionManager
+	cancel    context.CancelFunc
+	session   *state.State // File-backed session state, closed on disconnect
+	connected bool
+	lost      bool // Connection lost and not yet re-established
+	routes    map[string]mqtt.MessageHandler
+	aliases   map[string]uint16 // Topic aliases of the current connection
+	defined   map[string]bool   // Aliases the broker has been sent with their topic
+	aliasMax  uint16
+	conns     uint64 // Connections made, so a stale alias is not marked defined
+	resumed   bool   // The broker resumed the session on the last connect
+
+	// Publishes are handed to the connection one at a time and in order
+	sendMu  sync.Mutex
+	sendQ   []outgoing
+	kick    chan struct{}
+	written chan struct{} // Signalled when a PUBLISH packet was written
+}
+
+// outgoing is a publish waiting for the sender
+type outgoing struct {
+	pub *paho.Publish
+	t   *token
+}
+
+// NewClient creates an MQTT 5 client from paho client options
+func NewClient(opts *mqtt.ClientOptions, v5 Options) *Client {
+	c := &Client{
+		opts:    opts,
+		reader:  mqtt.NewClient(opts).OptionsReader(),
+		v5:      v5,
+		routes:  make(map[string]mqtt.MessageHandler),
+		kick:    make(chan struct{}, 1),
+		written: make(chan struct{}, 1),
+	}
+	for key, value := range v5.UserProperties {
+		c.props.Add(key, value)
+	}
+	return c
+}
+
+// IsConnected reports whether the client is connected to the broker
+func (c *Client) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+// IsConnectionOpen reports whether the client is connected to the broker
+func (c *Client) IsConnectionOpen() bool {
+	return c.IsConnected()
+}
+
+// OptionsReader returns the options the client was created from
+func (c *Client) OptionsReader() mqtt.ClientOptionsReader {
+	return c.reader
+}
+
+// Connect connects to the broker. Like the 3.1.1 client without connect
+// retry, the token fails if the first connection attempt fails; once
+// connected the client reconnects automatically
+func (c *Client) Connect() mqtt.Token {
+	t := newToken()
+
+	servers := make([]*url.URL, len(c.opts.Servers))
+	copy(servers, c.opts.Servers)
+
+	firstErr := make(chan error, 1)
+	maxDelay := c.opts.MaxReconnectInterval
+	if maxDelay <= 2*time.Second {
+		maxDelay = 10 * time.Minute
+	}
+
+	cfg := autopaho.ClientConfig{
+		ServerUrls:                    servers,
+		TlsCfg:                        c.opts.TLSConfig,
+		KeepAlive:                     uint16(c.opts.KeepAlive),
+		CleanStartOnInitialConnection: c.opts.CleanSession,
+		SessionExpiryInterval:         c.sessionExpiry(),
+		ConnectTimeout:                c.opts.ConnectTimeout,
+		ReconnectBackoff:              c.reconnectBackoff(autopaho.NewExponentialBackoff(time.Second, maxDelay, 2*time.Second, 2)),
+		ConnectUsername:               c.opts.Username,
+		ConnectPassword:               []byte(c.opts.Password),
+		AttemptConnection:             c.attemptConnection,
+		OnConnectionUp:                c.connectionUp,
+		OnConnectError: func(err error) {
+			select {
+			case firstErr <- err:
+			default:
+			}
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:           c.opts.ClientID,
+			OnPublishReceived:  []func(paho.PublishReceived) (bool, error){c.dispatch},
+			OnClientError:      c.connectionLost,
+			OnServerDisconnect: c.serverDisconnect,
+		},
+	}
+	if provide := c.opts.CredentialsProvider; provide != nil {
+		// Asked on every attempt, so a reconnect sends current credentials
+		cfg.ConnectPacketBuilder = func(cp *paho.Connect, _ *url.URL) (*paho.Connect, error) {
+			username, password := provide()
+			cp.Username, cp.UsernameFlag = username, username != ""
+			cp.Password, cp.PasswordFlag = []byte(password), password != ""
+			return cp, nil
+		}
+	}
+	if c.opts.WillEnabled {
+		cfg.SetWillMessage(c.opts.WillTopic, c.opts.WillPayload, c.opts.WillQos, c.opts.WillRetained)
+		if c.v5.WillDelay > 0 {
+			delay := uint32(c.v5.WillDelay / time.Second)
+			cfg.WillProperties = &paho.WillProperties{WillDelayInterval: &delay}
+		}
+	}
+
+	var session *state.State
+	if !c.opts.CleanSession && c.v5.SessionDir != "" {
+		var err error
+		if session, err = openSession(c.v5.SessionDir); err != nil {
+			t.complete(fmt.Errorf("failed to open session state: %w", err))
+			return t
+		}
+		cfg.Session = session
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cm, err := autopaho.NewConnection(ctx, cfg)
+	if err != nil {
+		cancel()
+		if session != nil {
+			session.Close()
+		}
+		t.complete(err)
+		return t
+	}
+
+	c.mu.Lock()
+	c.cm = cm
+	c.cancel = cancel
+	c.session = session
+	c.mu.Unlock()
+	go c.send(ctx, cm)
+
+	go func() {
+		waitCtx, waitCancel := context.WithTimeout(ctx, c.opts.ConnectTimeout)
+		defer waitCancel()
+
+		connected := make(chan error, 1)
+		go func() { connected <- cm.AwaitConnection(waitCtx) }()
+
+		select {
+		case err := <-connected:
+			if err == nil {
+				c.mu.Lock()
+				t.sessionPresent = c.resumed
+				c.mu.Unlock()
+				t.complete(nil)
+				return
+			}
+			err = fmt.Errorf("connection not established within %s", c.opts.ConnectTimeout)
+			select {
+			case cerr := <-firstErr:
+				err = cerr
+			default:
+			}
+			cancel()
+			t.complete(err)
+		case err := <-firstErr:
+			cancel()
+			t.complete(err)
+		}
+	}()
+
+	return t
+}
+
+// openSession opens session state kept in files in dir, messages sent to
+// the broker apart from those received from it
+func openSession(dir string) (*state.State, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	client, err := file.New(dir, "client-", ".pkt")
+	if err != nil {
+		return nil, err
+	}
+	server, err := file.New(dir, "server-", ".pkt")
+	if err != nil {
+		return nil, err
+	}
+	return state.New(client, server), nil
+}
+
+// Disconnect closes the connection, waiting up to quiesce milliseconds
+func (c *Client) Disconnect(quiesce uint) {
+	c.mu.Lock()
+	cm, cancel, session := c.cm, c.cancel, c.session
+	c.connected = false
+	c.mu.Unlock()
+	if cm == nil {
+		return
+	}
+
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(quiesce)*time.Millisecond)
+	defer done()
+	_ = cm.Disconnect(ctx)
+	cancel()
+	if session != nil {
+		<-cm.Done()
+		session.Close()
+	}
+}
+
+// SessionResumed reports whether the broker resumed the session on the
+// last connect
+func (c *Client) SessionResumed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.resumed
+}
+
+// Publish queues a message with the configured user properties for the
+// sender, which writes publishes in the order they were made, like the
+// 3.1.1 client. Rejections carry the MQTT 5 reason code as a *PublishError
+func (c *Client) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	t := newToken()
+
+	var body []byte
+	switch p := payload.(type) {
+	case []byte:
+		body = p
+	case string:
+		body = []byte(p)
+	default:
+		t.complete(fmt.Errorf("unknown payload type %T", payload))
+		return t
+	}
+
+	c.mu.Lock()
+	cm := c.cm
+	connected := c.connected
+	c.mu.Unlock()
+	if cm == nil || !connected {
+		t.complete(fmt.Errorf("not connected"))
+		return t
+	}
+
+	pub := &paho.Publish{
+		QoS:        qos,
+		Retain:     retained,
+		Topic:      topic,
+		Payload:    body,
+		Properties: &paho.PublishProperties{User: c.props},
+	}
+	c.sendMu.Lock()
+	c.sendQ = append(c.sendQ, outgoing{pub: pub, t: t})
+	c.sendMu.Unlock()
+	select {
+	case c.kick <- struct{}{}:
+	default:
+	}
+
+	return t
+}
+
+// send hands queued publishes to the connection one at a time. The next
+// publish starts once the previous one was written, so publishes reach the
+// broker in order while acknowledgements are awaited concurrently. Topic
+// aliases are assigned here, so a publish that sends only the alias always
+// follows the one that defined it
+func (c *Client) send(ctx context.Context, cm *autopaho.ConnectionManager) {
+	for {
+		select {
+		case <-c.kick:
+		case <-ctx.Done():
+			c.sendMu.Lock()
+			queued := c.sendQ
+			c.sendQ = nil
+			c.sendMu.Unlock()
+			for _, o := range queued {
+				o.t.complete(fmt.Errorf("not connected"))
+			}
+			return
+		}
+
+		for {
+			c.sendMu.Lock()
+			if len(c.sendQ) == 0 {
+				c.sendMu.Unlock()
+				break
+			}
+			o := c.sendQ[0]
+			c.sendQ = c.sendQ[1:]
+			c.sendMu.Unlock()
+
+			c.sendOne(ctx, cm, o)
+		}
+	}
+}
+
+// sendOne starts a publish and returns once it was written to the
+// connection or failed; the token completes with the broker's answer
+func (c *Client) sendOne(ctx context.Context, cm *autopaho.ConnectionManager, o outgoing) {
+	pub := o.pub
+	alias, known, conn := c.alias(pub.Topic)
+	if alias > 0 {
+		pub.Properties.TopicAlias = &alias
+		// Aliases do not survive a reconnect, so publishes that may be
+		// retransmitted in a resumed session keep their topic
+		if known && (pub.QoS == 0 || c.sessionExpiry() == 0) {
+			pub.Topic = ""
+		}
+	}
+
+	select {
+	case <-c.written:
+	default:
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+		defer cancel()
+
+		resp, err := cm.Publish(ctx, pub)
+		if resp != nil && resp.ReasonCode >= 0x80 {
+			reason := ""
+			if resp.Properties != nil {
+				reason = resp.Properties.ReasonString
+			}
+			err = &PublishError{ReasonCode: resp.ReasonCode, Reason: reason}
+		}
+		o.t.complete(err)
+	}()
+
+	select {
+	case <-c.written:
+	case <-done:
+		if o.t.err != nil {
+			return
+		}
+	case <-ctx.Done():
+		return
+	}
+	if alias > 0 && !known {
+		c.defineAlias(pub.Topic, conn)
+	}
+}
+
+// Subscribe subscribes to a topic filter
+func (c *Client) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return c.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+// SubscribeMultiple subscribes to several topic filters with one handler
+func (c *Client) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	t := newToken()
+
+	sub := &paho.Subscribe{}
+	c.mu.Lock()
+	for topic, qos := range filters {
+		if callback != nil {
+			c.routes[routeKey(topic)] = callback
+		}
+		sub.Subscriptions = append(sub.Subscriptions, paho.SubscribeOptions{Topic: topic, QoS: qos})
+	}
+	cm := c.cm
+	c.mu.Unlock()
+	if cm == nil {
+		t.complete(fmt.Errorf("not connected"))
+		return t
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+		defer cancel()
+
+		suback, err := cm.Subscribe(ctx, sub)
+		if err == nil && suback != nil {
+			for i, code := range suback.Reasons {
+				if code >= 0x80 && i < len(sub.Subscriptions) {
+					err = &SubscribeError{Topic: sub.Subscriptions[i].Topic, ReasonCode: code}
+					break
+				}
+			}
+		}
+		t.complete(err)
+	}()
+
+	return t
+}
+
+// Unsubscribe removes subscriptions and their handlers
+func (c *Client) Unsubscribe(topics ...string) mqtt.Token {
+	t := newToken()
+
+	c.mu.Lock()
+	for _, topic := range topics {
+		delete(c.routes, routeKey(topic))
+	}
+	cm := c.cm
+	c.mu.Unlock()
+	if cm == nil {
+		t.complete(fmt.Errorf("not connected"))
+		return t
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+		defer cancel()
+		_, err := cm.Unsubscribe(ctx, &paho.Unsubscribe{Topics: topics})
+		t.complete(err)
+	}()
+
+	return t
+}
+
+// AddRoute registers a handler for a topic filter without subscribing
+func (c *Client) AddRoute(topic string, callback mqtt.MessageHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.routes[topic] = callback
+}
+
+// sessionExpiry returns the session expiry interval in seconds
+func (c *Client) sessionExpiry() uint32 {
+	if c.opts.CleanSession {
+		return 0
+	}
+	return uint32(c.v5.SessionExpiry / time.Second)
+}
+
+// timeout bounds publish and subscribe round trips
+func (c *Client) timeout() time.Duration {
+	if c.opts.WriteTimeout > 0 {
+		return c.opts.WriteTimeout
+	}
+	if c.opts.ConnectTimeout > 0 {
+		return c.opts.ConnectTimeout
+	}
+	return 30 * time.Second
+}
+
+// alias returns the topic alias for a topic, whether the broker already
+// knows it and the connection it was assigned on. A new alias is assigned
+// while the connection has free aliases
+func (c *Client) alias(topic string) (uint16, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if a, ok := c.aliases[topic]; ok {
+		return a, c.defined[topic], c.conns
+	}
+	if uint16(len(c.aliases)) >= c.aliasMax {
+		return 0, false, c.conns
+	}
+	a := uint16(len(c.aliases) + 1)
+	c.aliases[topic] = a
+	return a, false, c.conns
+}
+
+// defineAlias records that the alias of topic was written with its topic on
+// connection conn. Until then, publishes keep sending the topic
+func (c *Client) defineAlias(topic string, conn uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn == c.conns {
+		c.defined[topic] = true
+	}
+}
+
+// reconnectBackoff returns the delay autopaho waits before a connection
+// attempt. Its attempt count restarts at 0 after a drop, which would
+// reconnect immediately; only the initial connect skips the delay
+func (c *Client) reconnectBackoff(fallback autopaho.Backoff) func(int) time.Duration {
+	return func(attempt int) time.Duration {
+		c.mu.Lock()
+		lost := c.lost
+		c.mu.Unlock()
+		if attempt == 0 && !lost {
+			return 0
+		}
+		if c.v5.ReconnectBackoff != nil {
+			return c.v5.ReconnectBackoff(attempt)
+		}
+		return fallback(attempt + 1)
+	}
+}
+
+// attemptConnection dials the broker using the custom dialer when set
+func (c *Client) attemptConnection(ctx context.Context, cfg autopaho.ClientConfig, u *url.URL) (net.Conn, error) {
+	c.mu.Lock()
+	reconnecting := c.lost
+	c.lost = false
+	c.mu.Unlock()
+	if reconnecting && c.opts.OnReconnecting != nil {
+		c.opts.OnReconnecting(c, c.opts)
+	}
+
+	opts := *c.opts
+	if c.opts.OnConnectAttempt != nil {
+		opts.TLSConfig = c.opts.OnConnectAttempt(u, c.opts.TLSConfig)
+	}
+
+	var conn net.Conn
+	var err error
+	if c.opts.CustomOpenConnectionFn != nil {
+		conn, err = c.opts.CustomOpenConnectionFn(u, opts)
+	} else {
+		conn, err = dial(ctx, u, opts.TLSConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &publishConn{Conn: conn, written: c.written}, nil
+}
+
+// publishConn serializes packet writes like packets.NewThreadSafeConn and
+// signals each PUBLISH packet written, for the sender to start the next
+type publishConn struct {
+	net.Conn
+	mu      sync.Mutex
+	first   bool // The next write starts a packet
+	publish bool // The packet being written is a PUBLISH
+	written chan<- struct{}
+}
+
+// Lock is taken by the packets for the whole of a packet write
+func (p *publishConn) Lock() {
+	p.mu.Lock()
+	p.first, p.publish = true, false
+}
+
+func (p *publishConn) Write(b []byte) (int, error) {
+	if p.first && len(b) > 0 {
+		p.first = false
+		p.publish = b[0]>>4 == packets.PUBLISH
+	}
+	return p.Conn.Write(b)
+}
+
+func (p *publishConn) Unlock() {
+	if p.publish {
+		select {
+		case p.written <- struct{}{}:
+		default:
+		}
+	}
+	p.mu.Unlock()
+}
+
+// dial opens a TCP or TLS connection to the broker
+func dial(ctx context.Context, u *url.URL, tlsCfg *tls.Config) (net.Conn, error) {
+	switch u.Scheme {
+	case "mqtt", "tcp", "":
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", u.Host)
+	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps":
+		d := tls.Dialer{Config: tlsCfg}
+		return d.DialContext(ctx, "tcp", u.Host)
+	}
+	return nil, fmt.Errorf("unsupported scheme %s", u.Scheme)
+}
+
+// connectionUp resets per-connection state and runs the connect handler
+func (c *Client) connectionUp(_ *autopaho.ConnectionManager, connack *paho.Connack) {
+	c.mu.Lock()
+	c.connected = true
+	c.resumed = connack != nil && connack.SessionPresent
+	c.aliases = make(map[string]uint16)
+	c.defined = make(map[string]bool)
+	c.conns++
+	c.aliasMax = 0
+	if connack != nil && connack.Properties != nil && connack.Properties.TopicAliasMaximum != nil {
+		c.aliasMax = min(c.v5.TopicAliases, *connack.Properties.TopicAliasMaximum)
+	}
+	c.mu.Unlock()
+
+	if c.opts.OnConnect != nil {
+		go c.opts.OnConnect(c)
+	}
+}
+
+// connectionLost records a dropped connection and runs the lost handler
+func (c *Client) connectionLost(err error) {
+	c.mu.Lock()
+	wasConnected := c.connected
+	c.connected = false
+	c.lost = wasConnected
+	c.mu.Unlock()
+
+	if wasConnected && c.opts.OnConnectionLost != nil {
+		go c.opts.OnConnectionLost(c, err)
+	}
+}
+
+// serverDisconnect handles a DISCONNECT sent by the broker
+func (c *Client) serverDisconnect(d *paho.Disconnect) {
+	reason := ""
+	if d.Properties != nil {
+		reason = d.Properties.ReasonString
+	}
+	c.connectionLost(fmt.Errorf("disconnected by broker: %s %s", reasonText(d.ReasonCode), reason))
+}
+
+// dispatch routes a received message to the handlers of matching filters
+func (c *Client) dispatch(pr paho.PublishReceived) (bool, error) {
+	msg := &message{pub: pr.Packet}
+
+	c.mu.Lock()
+	var handlers []mqtt.MessageHandler
+	for filter, handler := range c.routes {
+		if Match(filter, pr.Packet.Topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(handlers) == 0 && c.opts.DefaultPublishHandler != nil {
+		handlers = append(handlers, c.opts.DefaultPublishHandler)
+	}
+	for _, h := range handlers {
+		h(c, msg)
+	}
+	return len(handlers) > 0, nil
+}
+
+// routeKey returns the filter a subscription's handler is routed by. As in
+// the v3 client, shared subscriptions are routed by the filter without the
+// $share/{group}/ prefix
+func routeKey(topic string) string {
+	if strings.HasPrefix(topic, "$share/") {
+		if parts := strings.SplitN(topic, "/", 3); len(parts) == 3 {
+			return parts[2]
+		}
+	}
+	return topic
+}
+
+// Match reports whether a topic matches an MQTT topic filter
+func Match(filter, topic string) bool {
+	if strings.HasPrefix(filter, "$share/") {
+		// $share/{group}/{filter}
+		parts := strings.SplitN(filter, "/", 3)
+		if len(parts) < 3 {
+			return false
+		}
+		filter = parts[2]
+	}
+
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	for i, f := range fl {
+		switch {
+		case f == "#":
+			return true
+		case i >= len(tl):
+			return false
+		case f == "+":
+			continue
+		case f != tl[i]:
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
+
+// message adapts a received MQTT 5 publish to the paho message interface
+type message struct {
+	pub *paho.Publish
+}
+
+func (m *message) Duplicate() bool   { return false }
+func (m *message) Qos() byte         { return m.pub.QoS }
+func (m *message) Retained() bool    { return m.pub.Retain }
+func (m *message) Topic() string     { return m.pub.Topic }
+func (m *message) MessageID() uint16 { return m.pub.PacketID }
+func (m *message) Payload() []byte   { return m.pub.Payload }
+func (m *message) Ack()              {}
+
+// token implements the paho token interface for asynchronous operations
+type token struct {
+	done chan struct{}
+	err  error
+
+	sessionPresent bool // Set for connect tokens
+}
+
+func newToken() *token {
+	return &token{done: make(chan struct{})}
+}
+
+// complete finishes the token with the operation's result
+func (t *token) complete(err error) {
+	t.err = err
+	close(t.done)
+}
+
+func (t *token) Wait() bool {
+	<-t.done
+	return true
+}
+
+func (t *token) WaitTimeout(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (t *token) Done() <-chan struct{} {
+	return t.done
+}
+
+// SessionPresent reports whether the broker resumed an existing session,
+// like the 3.1.1 client's connect token
+func (t *token) SessionPresent() bool {
+	<-t.done
+	return t.sessionPresent
+}
+
+func (t *token) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,72 @@
+package mqtt5
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PublishError is a publish rejected by the broker with an MQTT 5 reason code
+type PublishError struct {
+	ReasonCode byte
+	Reason     string // Optional reason string sent by the broker
+}
+
+func (e *PublishError) Error() string {
+	msg := fmt.Sprintf("publish rejected: %s", reasonText(e.ReasonCode))
+	if e.Reason != "" && !strings.EqualFold(e.Reason, reasonNames[e.ReasonCode]) {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// SubscribeError is a subscription rejected by the broker with an MQTT 5
+// reason code
+type SubscribeError struct {
+	Topic      string
+	ReasonCode byte
+}
+
+func (e *SubscribeError) Error() string {
+	return fmt.Sprintf("subscription to %s rejected: %s", e.Topic, reasonText(e.ReasonCode))
+}
+
+// reasonNames are the MQTT 5 reason codes a broker may send to a client
+var reasonNames = map[byte]string{
+	0x04: "disconnect with will message",
+	0x80: "unspecified error",
+	0x81: "malformed packet",
+	0x82: "protocol error",
+	0x83: "implementation specific error",
+	0x87: "not authorized",
+	0x89: "server busy",
+	0x8B: "server shutting down",
+	0x8D: "keep alive timeout",
+	0x8E: "session taken over",
+	0x8F: "topic filter invalid",
+	0x90: "topic name invalid",
+	0x91: "packet identifier in use",
+	0x93: "receive maximum exceeded",
+	0x94: "topic alias invalid",
+	0x95: "packet too large",
+	0x96: "message rate too high",
+	0x97: "quota exceeded",
+	0x98: "administrative action",
+	0x99: "payload format invalid",
+	0x9A: "retain not supported",
+	0x9B: "QoS not supported",
+	0x9C: "use another server",
+	0x9D: "server moved",
+	0x9E: "shared subscriptions not supported",
+	0x9F: "connection rate exceeded",
+	0xA0: "maximum connect time",
+	0xA1: "subscription identifiers not supported",
+	0xA2: "wildcard subscriptions not supported",
+}
+
+// reasonText formats a reason code with its name
+func reasonText(code byte) string {
+	if name, ok := reasonNames[code]; ok {
+		return fmt.Sprintf("0x%02X %s", code, name)
+	}
+	return fmt.Sprintf("0x%02X", code)
+}
@@ -0,0 +1,130 @@
+// Package notify delivers critical notifications through gateways reachable
+// on the local network, an SMTP relay or a GSM modem, so operators hear about
+// problems while the uplink to the cloud is down
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification is one message to operators
+type Notification struct {
+	Key     string // What the notification is about, for cooldowns
+	Subject string
+	Fields  map[string]interface{}
+	Time    time.Time
+	// Suppressed counts notifications about Key held back by the cooldown
+	// since the last one sent
+	Suppressed int
+}
+
+// Body renders the fields as sorted "name: value" lines
+func (n Notification) Body() string {
+	names := make([]string, 0, len(n.Fields))
+	for name := range n.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %v\n", name, n.Fields[name])
+	}
+	fmt.Fprintf(&b, "time: %s\n", n.Time.UTC().Format(time.RFC3339))
+	if n.Suppressed > 0 {
+		fmt.Fprintf(&b, "suppressed: %d similar notifications during the cooldown\n", n.Suppressed)
+	}
+	return b.String()
+}
+
+// Short renders the notification on one line of at most max characters
+func (n Notification) Short(max int) string {
+	text := n.Subject
+	if n.Suppressed > 0 {
+		text += fmt.Sprintf(" (+%d)", n.Suppressed)
+	}
+	if len(text) > max {
+		text = text[:max]
+	}
+	return text
+}
+
+// Sender delivers notifications through one gateway
+type Sender interface {
+	Name() string
+	Send(ctx context.Context, n Notification) error
+}
+
+// Throttle drops repeated notifications. Identical notifications within the
+// dedup window are dropped; others about the same key are held back until
+// the cooldown since the last one sent has passed and counted instead
+type Throttle struct {
+	dedup    time.Duration
+	cooldown time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time // Last time each identical notification was seen
+	sent map[string]time.Time // Last notification sent per key
+	held map[string]int       // Notifications held back per key since the last sent
+}
+
+// NewThrottle creates a throttle. Zero durations disable the checks
+func NewThrottle(dedup, cooldown time.Duration) *Throttle {
+	return &Throttle{
+		dedup:    dedup,
+		cooldown: cooldown,
+		seen:     make(map[string]time.Time),
+		sent:     make(map[string]time.Time),
+		held:     make(map[string]int),
+	}
+}
+
+// Result of passing a notification through the throttle
+const (
+	Allowed    = "allowed"
+	Duplicate  = "duplicate"
+	Suppressed = "suppressed"
+)
+
+// Check decides whether n is sent. Allowed notifications get the number of
+// notifications held back before them in Suppressed
+func (t *Throttle) Check(n *Notification) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	identity := n.Key + "\n" + n.Subject + "\n" + fmt.Sprint(n.Fields)
+	if last, ok := t.seen[identity]; ok && t.dedup > 0 && n.Time.Sub(last) < t.dedup {
+		return Duplicate
+	}
+	t.seen[identity] = n.Time
+
+	if last, ok := t.sent[n.Key]; ok && t.cooldown > 0 && n.Time.Sub(last) < t.cooldown {
+		t.held[n.Key]++
+		return Suppressed
+	}
+	t.sent[n.Key] = n.Time
+	n.Suppressed = t.held[n.Key]
+	delete(t.held, n.Key)
+
+	t.expire(n.Time)
+	return Allowed
+}
+
+// expire forgets entries that can no longer affect a decision
+func (t *Throttle) expire(now time.Time) {
+	for identity, last := range t.seen {
+		if now.Sub(last) >= t.dedup {
+			delete(t.seen, identity)
+		}
+	}
+	for key, last := range t.sent {
+		if now.Sub(last) >= t.cooldown && t.held[key] == 0 {
+			delete(t.sent, key)
+		}
+	}
+}
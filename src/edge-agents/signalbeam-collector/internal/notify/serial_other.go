@@ -0,0 +1,10 @@
+//go:build !linux
+
+package notify
+
+import "errors"
+
+// openSerial is only implemented on Linux, where edge modems are attached
+func openSerial(device string, baud int) (serialPort, error) {
+	return nil, errors.New("SMS modems are only supported on Linux")
+}
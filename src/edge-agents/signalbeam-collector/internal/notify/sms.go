@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// smsLength is the length of a single text mode SMS
+const smsLength = 160
+
+// errATTimeout is returned when the modem does not answer in time
+var errATTimeout = errors.New("modem did not answer")
+
+// Modem sends notifications as SMS through a GSM modem on a serial port,
+// using AT commands in text mode
+type Modem struct {
+	Device string // e.g. /dev/ttyUSB2
+	Baud   int
+	PIN    string // SIM PIN, entered when the SIM asks for it
+	To     []string
+
+	mu sync.Mutex // One dialogue with the modem at a time
+}
+
+// Name identifies the gateway
+func (m *Modem) Name() string {
+	return "sms"
+}
+
+// Send texts n to every recipient
+func (m *Modem) Send(ctx context.Context, n Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	port, err := openSerial(m.Device, m.Baud)
+	if err != nil {
+		return err
+	}
+	defer port.Close()
+	at := &atSession{port: port, ctx: ctx}
+
+	if _, err := at.command("AT", 5*time.Second); err != nil {
+		return err
+	}
+	if m.PIN != "" {
+		status, err := at.command("AT+CPIN?", 5*time.Second)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(status, "SIM PIN") {
+			if _, err := at.command(fmt.Sprintf("AT+CPIN=\"%s\"", m.PIN), 10*time.Second); err != nil {
+				return fmt.Errorf("SIM PIN rejected: %w", err)
+			}
+		}
+	}
+	if _, err := at.command("AT+CMGF=1", 5*time.Second); err != nil {
+		return err
+	}
+
+	text := gsmText(n.Short(smsLength))
+	for _, to := range m.To {
+		if err := at.send(to, text); err != nil {
+			return fmt.Errorf("SMS to %s: %w", to, err)
+		}
+	}
+	return nil
+}
+
+// gsmText replaces characters outside printable ASCII, which the GSM default
+// alphabet may not hold
+func gsmText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+// serialPort is an open serial device with read deadlines
+type serialPort interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+}
+
+// atSession runs AT commands on an open port
+type atSession struct {
+	port serialPort
+	ctx  context.Context
+}
+
+// command sends cmd and returns the modem's answer once it ends in OK
+func (a *atSession) command(cmd string, timeout time.Duration) (string, error) {
+	if _, err := a.port.Write([]byte(cmd + "\r")); err != nil {
+		return "", err
+	}
+	return a.read(timeout, "OK\r\n")
+}
+
+// send submits one message: the modem prompts with "> " for the text, which
+// ends with Ctrl-Z
+func (a *atSession) send(to, text string) error {
+	if _, err := a.port.Write([]byte(fmt.Sprintf("AT+CMGS=\"%s\"\r", to))); err != nil {
+		return err
+	}
+	if _, err := a.read(10*time.Second, "> "); err != nil {
+		a.port.Write([]byte{0x1b}) // Escape aborts the message
+		return err
+	}
+	if _, err := a.port.Write([]byte(text + "\x1a")); err != nil {
+		return err
+	}
+	_, err := a.read(60*time.Second, "OK\r\n")
+	return err
+}
+
+// read collects the answer until it contains want, an error, or the
+// timeout passes
+func (a *atSession) read(timeout time.Duration, want string) (string, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := a.ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := a.port.SetReadDeadline(deadline); err != nil {
+		return "", err
+	}
+
+	var answer strings.Builder
+	buf := make([]byte, 256)
+	for {
+		n, err := a.port.Read(buf)
+		answer.Write(buf[:n])
+		s := answer.String()
+		switch {
+		case strings.Contains(s, want):
+			return s, nil
+		case strings.Contains(s, "ERROR"):
+			return s, fmt.Errorf("modem answered %q", strings.TrimSpace(s))
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			return s, errATTimeout
+		case err != nil:
+			return s, err
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Package oauth obtains access tokens with the OAuth 2.0 client credentials
+// grant (RFC 6749, section 4.4). Brokers that accept JWTs take the access
+// token as the MQTT password
+package oauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxResponseBytes bounds the token endpoint's response
+const maxResponseBytes = 1 << 20
+
+// ClientCredentials requests tokens for one client
+type ClientCredentials struct {
+	URL      string
+	ClientID string
+	// Secret returns the client secret for each request, so a rotated
+	// secret is picked up
+	Secret   func() (string, error)
+	Scopes   []string
+	Audience string // Sent for identity providers that require it
+	Client   *http.Client
+}
+
+// Token is an access token and when it expires
+type Token struct {
+	AccessToken string
+	Expiry      time.Time // Zero when neither the response nor the token tells
+}
+
+// tokenResponse is the endpoint's answer, successful or not
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Token requests a new access token. Its expiry comes from expires_in or,
+// when the response has none, from the exp claim of a JWT
+func (cc *ClientCredentials) Token(ctx context.Context) (Token, error) {
+	secret, err := cc.Secret()
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to read client secret: %w", err)
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(cc.Scopes) > 0 {
+		form.Set("scope", strings.Join(cc.Scopes, " "))
+	}
+	if cc.Audience != "" {
+		form.Set("audience", cc.Audience)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cc.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// The client ID and secret are form-encoded before basic authentication
+	req.SetBasicAuth(url.QueryEscape(cc.ClientID), url.QueryEscape(secret))
+
+	start := time.Now()
+	resp, err := cc.Client.Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return Token{}, err
+	}
+
+	var tr tokenResponse
+	jsonErr := json.Unmarshal(body, &tr)
+	switch {
+	case resp.StatusCode != http.StatusOK && tr.Error != "":
+		if tr.ErrorDescription != "" {
+			return Token{}, fmt.Errorf("token request refused: %s: %s", tr.Error, tr.ErrorDescription)
+		}
+		return Token{}, fmt.Errorf("token request refused: %s", tr.Error)
+	case resp.StatusCode != http.StatusOK:
+		return Token{}, fmt.Errorf("token request failed: %s", resp.Status)
+	case jsonErr != nil:
+		return Token{}, fmt.Errorf("invalid token response: %w", jsonErr)
+	case tr.AccessToken == "":
+		return Token{}, errors.New("token response without access_token")
+	}
+
+	t := Token{AccessToken: tr.AccessToken}
+	if tr.ExpiresIn > 0 {
+		// Counted from the request, so a slow response does not extend it
+		t.Expiry = start.Add(time.Duration(tr.ExpiresIn) * time.Second)
+	} else if exp, ok := Expiry(tr.AccessToken); ok {
+		t.Expiry = exp
+	}
+	return t, nil
+}
+
+// Expiry returns the exp claim of a JWT. It does not verify the token; the
+// broker does
+func Expiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp json.Number `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == "" {
+		return time.Time{}, false
+	}
+	exp, err := claims.Exp.Float64()
+	if err != nil || exp <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(exp), 0), true
+}
@@ -0,0 +1,363 @@
+package output
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/azure"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/backoff"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+)
+
+// AMQP output defaults
+const (
+	defaultAMQPTimeout    = 30 * time.Second
+	defaultAMQPMaxPending = 10000
+	amqpConnectTimeout    = 30 * time.Second
+)
+
+// errAMQPQueueFull is returned while the queue is full
+var errAMQPQueueFull = errors.New("AMQP queue is full")
+
+// amqpReconnect paces connection attempts while the service is unreachable
+var amqpReconnect = backoff.Policy{Initial: time.Second, Max: time.Minute, Multiplier: 2, Jitter: 0.5}
+
+// amqpMessage is a message waiting for delivery
+type amqpMessage struct {
+	msg    *amqp.Message
+	queued time.Time
+	// Closed by the sender in place of a message once the messages ahead
+	// are sent
+	flushed chan struct{}
+}
+
+// amqpOutput sends messages to an Azure Event Hub or Service Bus entity.
+// Messages are queued and sent in order by a background sender that
+// reconnects as needed; a message the service does not accept within the
+// timeout is reported as a failure by the next Write
+type amqpOutput struct {
+	dial        egress.DialFunc
+	address     string // host:port
+	hostname    string
+	tls         *tls.Config // nil for plain AMQP
+	sasl        amqp.SASLType
+	token       *azure.SASToken // Put to the claims-based security node when set
+	entity      string
+	partitionBy string
+	timeout     time.Duration
+
+	conn   *amqp.Conn // Owned by run
+	sender *amqp.Sender
+	failed int // Connection attempts failed in a row
+
+	queue    chan amqpMessage
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	failures failures
+}
+
+// newAMQP starts the sender. The service is contacted on the first message
+func newAMQP(cfg config.AMQPOutputConfig, env Env) (*amqpOutput, error) {
+	a := &amqpOutput{
+		dial:        env.Dial,
+		entity:      cfg.Address,
+		partitionBy: cfg.PartitionBy,
+		timeout:     cfg.Timeout,
+	}
+	if a.dial == nil {
+		a.dial = (&net.Dialer{}).DialContext
+	}
+	if a.partitionBy == "" {
+		a.partitionBy = "device_id"
+	}
+	if a.timeout == 0 {
+		a.timeout = defaultAMQPTimeout
+	}
+	maxPending := cfg.MaxPending
+	if maxPending == 0 {
+		maxPending = defaultAMQPMaxPending
+	}
+
+	secure := true
+	if cfg.ConnectionString != "" {
+		cs, err := azure.ParseConnectionString(cfg.ConnectionString)
+		if err != nil {
+			return nil, err
+		}
+		if a.entity == "" {
+			a.entity = cs.EntityPath
+		}
+		a.address = cs.Host
+		secure = !cs.Emulator
+		if cs.Signature != "" {
+			token, err := azure.ParseSASToken(cs.Signature)
+			if err != nil {
+				return nil, err
+			}
+			a.token = &token
+		} else {
+			// Shared access keys are accepted as SASL PLAIN credentials
+			a.sasl = amqp.SASLTypePlain(cs.KeyName, cs.Key)
+		}
+	} else {
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		token, err := azure.ParseSASToken(cfg.SASToken)
+		if err != nil {
+			return nil, err
+		}
+		a.address = u.Host
+		a.token = &token
+		secure = u.Scheme == "amqps"
+	}
+	if a.token != nil {
+		a.sasl = amqp.SASLTypeAnonymous()
+	}
+
+	a.hostname = a.address
+	if host, _, err := net.SplitHostPort(a.address); err == nil {
+		a.hostname = host
+	} else if secure {
+		a.address = net.JoinHostPort(a.address, "5671")
+	} else {
+		a.address = net.JoinHostPort(a.address, "5672")
+	}
+	if secure {
+		tlsCfg, err := env.tlsConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg = tlsCfg.Clone()
+		if tlsCfg.ServerName == "" {
+			tlsCfg.ServerName = a.hostname
+		}
+		a.tls = tlsCfg
+	}
+
+	a.queue = make(chan amqpMessage, maxPending)
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	a.done = make(chan struct{})
+	go a.run()
+	return a, nil
+}
+
+func (a *amqpOutput) Publish(_ context.Context, msg Message) error {
+	contentType := "application/json"
+	m := &amqp.Message{
+		Data:       [][]byte{msg.Payload},
+		Properties: &amqp.MessageProperties{ContentType: &contentType},
+		ApplicationProperties: map[string]any{
+			"type":       msg.Type,
+			"device_id":  msg.DeviceID,
+			"mqtt_topic": msg.Topic,
+		},
+	}
+	switch a.partitionBy {
+	case "device_id":
+		m.Annotations = amqp.Annotations{"x-opt-partition-key": msg.DeviceID}
+	case "type":
+		m.Annotations = amqp.Annotations{"x-opt-partition-key": msg.Type}
+	}
+
+	select {
+	case a.queue <- amqpMessage{msg: m, queued: time.Now()}:
+	default:
+		return errAMQPQueueFull
+	}
+	return a.failures.take()
+}
+
+// Flush waits until the messages queued so far are sent or failed
+func (a *amqpOutput) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case a.queue <- amqpMessage{flushed: flushed}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return a.failures.take()
+}
+
+// run sends queued messages in order until the queue is closed
+func (a *amqpOutput) run() {
+	defer close(a.done)
+	defer a.disconnect()
+	for m := range a.queue {
+		if m.flushed != nil {
+			close(m.flushed)
+			continue
+		}
+		if err := a.deliver(m); err != nil {
+			a.failures.add(err)
+		}
+	}
+}
+
+// deliver sends a message, reconnecting until it is settled or its timeout
+// has passed. Messages the service rejects are not retried
+func (a *amqpOutput) deliver(m amqpMessage) error {
+	ctx, cancel := context.WithDeadline(a.ctx, m.queued.Add(a.timeout))
+	defer cancel()
+
+	var err error
+	for {
+		if a.sender == nil {
+			if err = a.connect(ctx); err != nil {
+				a.failed++
+				select {
+				case <-time.After(amqpReconnect.Delay(a.failed - 1)):
+					continue
+				case <-ctx.Done():
+					return err
+				}
+			}
+			a.failed = 0
+		}
+
+		err = a.sender.Send(ctx, m.msg, nil)
+		var rejected *amqp.Error
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &rejected):
+			return err
+		case ctx.Err() != nil:
+			return err
+		}
+		// The link or connection is gone; start over on a new one
+		a.disconnect()
+	}
+}
+
+// connect opens the connection and the sender link, putting the SAS token
+// first when one is used
+func (a *amqpOutput) connect(ctx context.Context) error {
+	if a.token != nil && a.token.Expired(time.Now()) {
+		return fmt.Errorf("SAS token expired at %s", a.token.Expiry.UTC().Format(time.RFC3339))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, amqpConnectTimeout)
+	defer cancel()
+	netConn, err := a.dial(ctx, "tcp", a.address)
+	if err != nil {
+		return err
+	}
+	if a.tls != nil {
+		tlsConn := tls.Client(netConn, a.tls)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			netConn.Close()
+			return err
+		}
+		netConn = tlsConn
+	}
+
+	conn, err := amqp.NewConn(ctx, netConn, &amqp.ConnOptions{HostName: a.hostname, SASLType: a.sasl})
+	if err != nil {
+		netConn.Close()
+		return err
+	}
+	if a.token != nil {
+		if err := putToken(ctx, conn, a.token); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	session, err := conn.NewSession(ctx, nil)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	sender, err := session.NewSender(ctx, a.entity, nil)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	a.conn, a.sender = conn, sender
+	return nil
+}
+
+// disconnect drops the connection, if any
+func (a *amqpOutput) disconnect() {
+	if a.conn != nil {
+		a.conn.Close()
+	}
+	a.conn, a.sender = nil, nil
+}
+
+// putToken authorizes the connection for the token's audience through the
+// claims-based security node
+func putToken(ctx context.Context, conn *amqp.Conn, token *azure.SASToken) error {
+	session, err := conn.NewSession(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer session.Close(ctx)
+
+	const node = "$cbs"
+	replyTo := "cbs-reply"
+	sender, err := session.NewSender(ctx, node, nil)
+	if err != nil {
+		return err
+	}
+	receiver, err := session.NewReceiver(ctx, node, &amqp.ReceiverOptions{TargetAddress: replyTo})
+	if err != nil {
+		return err
+	}
+
+	req := &amqp.Message{
+		Properties: &amqp.MessageProperties{MessageID: "put-token", ReplyTo: &replyTo},
+		ApplicationProperties: map[string]any{
+			"operation": "put-token",
+			"type":      "servicebus.windows.net:sastoken",
+			"name":      token.Resource,
+		},
+		Value: token.Raw,
+	}
+	if err := sender.Send(ctx, req, nil); err != nil {
+		return fmt.Errorf("failed to put SAS token: %w", err)
+	}
+	resp, err := receiver.Receive(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to put SAS token: %w", err)
+	}
+	receiver.AcceptMessage(ctx, resp)
+
+	code, _ := resp.ApplicationProperties["status-code"].(int32)
+	if code != 200 && code != 202 {
+		description, _ := resp.ApplicationProperties["status-description"].(string)
+		return fmt.Errorf("SAS token rejected: %d %s", code, strings.TrimSpace(description))
+	}
+	return nil
+}
+
+// Close sends the queued messages, waiting up to the timeout before giving
+// up on the rest
+func (a *amqpOutput) Close() error {
+	close(a.queue)
+	select {
+	case <-a.done:
+	case <-time.After(a.timeout):
+		pending := len(a.queue)
+		a.cancel()
+		<-a.done
+		return fmt.Errorf("%d AMQP messages not sent", pending+1)
+	}
+	a.cancel()
+	return a.failures.take()
+}
@@ -0,0 +1,135 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+)
+
+// ErrBreakerOpen is returned for records an output whose circuit is open
+// did not attempt
+var ErrBreakerOpen = errors.New("circuit open, output not attempted")
+
+// States of a circuit breaker
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open" // One record probes the output
+)
+
+// breaker stops publishing to a sink that keeps failing, so a dead
+// transport does not cost a connection attempt or timeout per record, and
+// lets a record through now and then to find out whether it recovered
+type breaker struct {
+	Sink
+	name    string
+	cfg     config.BreakerConfig
+	changed func(name, state string, err error)
+
+	mu       sync.Mutex
+	state    string
+	failures int           // In a row
+	cooldown time.Duration // Of the current opening
+	until    time.Time     // When the next probe may go out
+	probing  bool
+	opened   int64 // Times opened from closed
+	rejected int64 // Records not attempted
+	lastErr  error
+}
+
+func newBreaker(sink Sink, name string, cfg config.BreakerConfig, changed func(string, string, error)) *breaker {
+	return &breaker{Sink: sink, name: name, cfg: cfg, changed: changed, state: BreakerClosed}
+}
+
+// Publish fails fast with ErrBreakerOpen while the circuit is open or a
+// probe is out. Records lost earlier count as failures too, as they are
+// what a network output reports for a transport that is down. Failures
+// caused by the collector stopping do not count
+func (b *breaker) Publish(ctx context.Context, msg Message) error {
+	b.mu.Lock()
+	switch {
+	case b.state == BreakerOpen && time.Now().Before(b.until), b.probing:
+		b.rejected++
+		b.mu.Unlock()
+		return ErrBreakerOpen
+	case b.state == BreakerOpen:
+		b.state, b.probing = BreakerHalfOpen, true
+	}
+	probe := b.probing
+	b.mu.Unlock()
+
+	err := b.Sink.Publish(ctx, msg)
+	if err != nil && ctx.Err() != nil {
+		if probe {
+			b.mu.Lock()
+			b.state, b.probing = BreakerOpen, false
+			b.mu.Unlock()
+		}
+		return err
+	}
+
+	b.mu.Lock()
+	state := b.record(err, probe)
+	b.mu.Unlock()
+	if state != "" && b.changed != nil {
+		b.changed(b.name, state, err)
+	}
+	return err
+}
+
+// record updates the circuit with the outcome of a publish and returns
+// the state it moved to when it opened or closed. A failed probe opens it
+// again without a change to report. The caller must hold b.mu
+func (b *breaker) record(err error, probe bool) string {
+	if probe {
+		b.probing = false
+	}
+	if err == nil {
+		b.failures = 0
+		if b.state == BreakerClosed {
+			return ""
+		}
+		b.state, b.cooldown, b.lastErr = BreakerClosed, 0, nil
+		return BreakerClosed
+	}
+
+	b.failures++
+	b.lastErr = err
+	switch {
+	case probe:
+		b.state = BreakerOpen
+		b.cooldown = min(b.cooldown*2, b.cfg.MaxCooldown)
+		b.until = time.Now().Add(b.cooldown)
+		return ""
+	case b.state == BreakerClosed && b.failures >= b.cfg.Failures:
+		b.state = BreakerOpen
+		b.cooldown = b.cfg.Cooldown
+		b.until = time.Now().Add(b.cooldown)
+		b.opened++
+		return BreakerOpen
+	}
+	return ""
+}
+
+// Map reports the circuit for the heartbeat
+func (b *breaker) Map() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := map[string]interface{}{
+		"state":    b.state,
+		"failures": b.failures,
+		"opened":   b.opened,
+		"rejected": b.rejected,
+	}
+	if b.state != BreakerClosed {
+		out["cooldown_s"] = b.cooldown.Seconds()
+		out["probe_in_s"] = max(time.Until(b.until), 0).Seconds()
+		if b.lastErr != nil {
+			out["last_error"] = b.lastErr.Error()
+		}
+	}
+	return out
+}
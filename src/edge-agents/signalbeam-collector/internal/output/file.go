@@ -0,0 +1,292 @@
+package output
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+)
+
+// rotatedStamp names rotated files; it sorts chronologically
+const rotatedStamp = "20060102T150405.000Z"
+
+// errNotMounted is returned while the configured mount point is absent
+var errNotMounted = errors.New("mount point is not mounted")
+
+// archiveMu serializes compression and pruning, which may run for an output
+// being replaced while its successor rotates the same file
+var archiveMu sync.Mutex
+
+// fileLine is one NDJSON record of a file output
+type fileLine struct {
+	Time     string          `json:"time"`
+	Type     string          `json:"type"`
+	Topic    string          `json:"topic"`
+	QoS      byte            `json:"qos"`
+	Retained bool            `json:"retained,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Binary   []byte          `json:"payload_base64,omitempty"` // Payloads that are not JSON
+}
+
+// file appends messages to a local NDJSON file and rotates it
+type file struct {
+	cfg config.FileOutputConfig
+
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+
+	// archiving tracks background compression and pruning
+	archiving sync.WaitGroup
+}
+
+// newFile opens the file for appending, creating its directory. With a mount
+// point the file is opened on first write once the mount is present
+func newFile(cfg config.FileOutputConfig) (*file, error) {
+	o := &file{cfg: cfg}
+	if err := o.open(); err != nil && !errors.Is(err, errNotMounted) {
+		return nil, err
+	}
+	o.archive()
+	return o, nil
+}
+
+// open opens the current file. Callers hold mu or own o exclusively
+func (o *file) open() error {
+	if o.cfg.MountPoint != "" && !mounted(o.cfg.MountPoint) {
+		return fmt.Errorf("%s: %w", o.cfg.MountPoint, errNotMounted)
+	}
+	if err := os.MkdirAll(filepath.Dir(o.cfg.Path), 0750); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	f, err := os.OpenFile(o.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	o.f, o.w, o.size, o.opened = f, bufio.NewWriter(f), info.Size(), time.Now()
+	return nil
+}
+
+// closeFile flushes and closes the current file. Callers hold mu
+func (o *file) closeFile() error {
+	if o.f == nil {
+		return nil
+	}
+	err := o.w.Flush()
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	o.f, o.w = nil, nil
+	return err
+}
+
+// Publish appends a message. Lines are flushed immediately so a crash loses
+// at most the line being written. A failed write closes the file so the next
+// message reopens it, e.g. after a drive was replugged
+func (o *file) Publish(_ context.Context, msg Message) error {
+	rec := fileLine{
+		Time:     msg.Time.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		Type:     msg.Type,
+		Topic:    msg.Topic,
+		QoS:      msg.QoS,
+		Retained: msg.Retained,
+	}
+	if json.Valid(msg.Payload) {
+		rec.Payload = msg.Payload
+	} else {
+		rec.Binary = msg.Payload
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.f != nil && o.due(len(line)) {
+		if err := o.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate %s: %w", o.cfg.Path, err)
+		}
+	}
+	if o.f == nil {
+		if err := o.open(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := o.w.Write(line); err == nil {
+		err = o.w.Flush()
+	}
+	if err != nil {
+		o.closeFile()
+		return err
+	}
+	o.size += int64(len(line))
+	return nil
+}
+
+// due reports whether the current file must be rotated before appending n
+// bytes. An empty file is never rotated
+func (o *file) due(n int) bool {
+	if o.size == 0 {
+		return false
+	}
+	return (o.cfg.MaxBytes > 0 && o.size+int64(n) > o.cfg.MaxBytes) ||
+		(o.cfg.MaxAge > 0 && time.Since(o.opened) >= o.cfg.MaxAge)
+}
+
+// rotate renames the current file with a timestamp and leaves the next write
+// to start a new one. Callers hold mu
+func (o *file) rotate() error {
+	if err := o.closeFile(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(o.cfg.Path)
+	stem := strings.TrimSuffix(o.cfg.Path, ext)
+	target := stem + "-" + time.Now().UTC().Format(rotatedStamp) + ext
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		target = fmt.Sprintf("%s-%s-%d%s", stem, time.Now().UTC().Format(rotatedStamp), i, ext)
+	}
+	if err := os.Rename(o.cfg.Path, target); err != nil {
+		return err
+	}
+	o.archive()
+	return nil
+}
+
+// archive compresses and prunes rotated files in the background
+func (o *file) archive() {
+	if !o.cfg.Compress && o.cfg.MaxFiles == 0 {
+		return
+	}
+	o.archiving.Add(1)
+	go func() {
+		defer o.archiving.Done()
+		archiveMu.Lock()
+		defer archiveMu.Unlock()
+		// Failures leave the files in place and are retried on the next rotation
+		_ = archiveRotated(o.cfg)
+	}()
+}
+
+// archiveRotated gzips uncompressed rotated files, including ones left by an
+// earlier run, and removes the oldest beyond MaxFiles
+func archiveRotated(cfg config.FileOutputConfig) error {
+	rotated, err := rotatedFiles(cfg.Path)
+	if err != nil {
+		return err
+	}
+	if cfg.Compress {
+		for i, name := range rotated {
+			if strings.HasSuffix(name, ".gz") {
+				continue
+			}
+			if err := gzipFile(name); err != nil {
+				return err
+			}
+			rotated[i] = name + ".gz"
+		}
+	}
+	if cfg.MaxFiles > 0 && len(rotated) > cfg.MaxFiles {
+		for _, name := range rotated[:len(rotated)-cfg.MaxFiles] {
+			if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rotatedFiles lists the rotated files of path, oldest first
+func rotatedFiles(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(filepath.Base(path), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var rotated []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		if stamp == strings.TrimSuffix(name, ".gz") {
+			continue
+		}
+		stamp = strings.TrimPrefix(stamp, prefix)
+		if len(stamp) < len(rotatedStamp) {
+			continue
+		}
+		if _, err := time.Parse(rotatedStamp, stamp[:len(rotatedStamp)]); err != nil {
+			continue
+		}
+		rotated = append(rotated, filepath.Join(filepath.Dir(path), name))
+	}
+	sort.Strings(rotated)
+	return rotated, nil
+}
+
+// gzipFile replaces name with name.gz
+func gzipFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Close flushes and closes the file and waits for background archiving
+func (o *file) Close() error {
+	o.mu.Lock()
+	err := o.closeFile()
+	o.mu.Unlock()
+	o.archiving.Wait()
+	return err
+}
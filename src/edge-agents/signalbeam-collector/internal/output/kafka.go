@@ -0,0 +1,181 @@
+package output
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// Kafka output defaults
+const (
+	defaultKafkaTopic       = "signalbeam.{type}"
+	defaultKafkaClientID    = "signalbeam-collector"
+	defaultKafkaTimeout     = 30 * time.Second
+	defaultKafkaMaxBuffered = 10000
+)
+
+// kafka produces messages to a Kafka cluster. Records are buffered and sent
+// in the background, so an unreachable cluster does not stall the pipeline;
+// a record that cannot be delivered within the timeout is reported as a
+// failure by the next Write
+type kafka struct {
+	client      *kgo.Client
+	topic       string
+	partitionBy string
+	timeout     time.Duration
+	failures    failures
+}
+
+// newKafka creates the producer. Brokers are contacted on the first write
+func newKafka(cfg config.KafkaOutputConfig, env Env) (*kafka, error) {
+	k := &kafka{
+		topic:       cfg.Topic,
+		partitionBy: cfg.PartitionBy,
+		timeout:     cfg.Timeout,
+	}
+	if k.topic == "" {
+		k.topic = defaultKafkaTopic
+	}
+	if k.partitionBy == "" {
+		k.partitionBy = "device_id"
+	}
+	if k.timeout == 0 {
+		k.timeout = defaultKafkaTimeout
+	}
+	clientID := cfg.ClientID
+	if clientID == "" {
+		clientID = defaultKafkaClientID
+	}
+	maxBuffered := cfg.MaxBuffered
+	if maxBuffered == 0 {
+		maxBuffered = defaultKafkaMaxBuffered
+	}
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.ClientID(clientID),
+		kgo.RecordDeliveryTimeout(k.timeout),
+		kgo.MaxBufferedRecords(maxBuffered),
+	}
+
+	switch cfg.Acks {
+	case "leader":
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()), kgo.DisableIdempotentWrite())
+	case "none":
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()), kgo.DisableIdempotentWrite())
+	}
+
+	switch cfg.Compression {
+	case "gzip":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.GzipCompression()))
+	case "lz4":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.Lz4Compression()))
+	case "zstd":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.ZstdCompression()))
+	case "none":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.NoCompression()))
+	default:
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.SnappyCompression()))
+	}
+
+	switch cfg.SASL.Mechanism {
+	case "PLAIN":
+		opts = append(opts, kgo.SASL(plain.Auth{User: cfg.SASL.Username, Pass: cfg.SASL.Password}.AsMechanism()))
+	case "SCRAM-SHA-256":
+		opts = append(opts, kgo.SASL(scram.Auth{User: cfg.SASL.Username, Pass: cfg.SASL.Password}.AsSha256Mechanism()))
+	case "SCRAM-SHA-512":
+		opts = append(opts, kgo.SASL(scram.Auth{User: cfg.SASL.Username, Pass: cfg.SASL.Password}.AsSha512Mechanism()))
+	}
+
+	// The dialer goes through the outbound policy; TLS is layered on top
+	dial := env.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	if cfg.TLS.Enabled {
+		tlsCfg, err := env.tlsConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		plainDial := dial
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := plainDial(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			c := tlsCfg.Clone()
+			if c.ServerName == "" {
+				c.ServerName, _, _ = net.SplitHostPort(address)
+			}
+			tlsConn := tls.Client(conn, c)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		}
+	}
+	opts = append(opts, kgo.Dialer(dial))
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	k.client = client
+	return k, nil
+}
+
+func (k *kafka) Publish(_ context.Context, msg Message) error {
+	record := &kgo.Record{
+		Topic:     strings.NewReplacer("{device_id}", msg.DeviceID, "{type}", msg.Type).Replace(k.topic),
+		Value:     msg.Payload,
+		Timestamp: msg.Time,
+		Headers: []kgo.RecordHeader{
+			{Key: "type", Value: []byte(msg.Type)},
+			{Key: "device_id", Value: []byte(msg.DeviceID)},
+			{Key: "mqtt_topic", Value: []byte(msg.Topic)},
+		},
+	}
+	switch k.partitionBy {
+	case "device_id":
+		record.Key = []byte(msg.DeviceID)
+	case "type":
+		record.Key = []byte(msg.Type)
+	}
+
+	// A full buffer fails the record right away, so its error is seen below
+	k.client.TryProduce(context.Background(), record, func(_ *kgo.Record, err error) {
+		if err != nil {
+			k.failures.add(err)
+		}
+	})
+	return k.failures.take()
+}
+
+// Flush waits until the buffered records are delivered or failed
+func (k *kafka) Flush(ctx context.Context) error {
+	if err := k.client.Flush(ctx); err != nil {
+		return err
+	}
+	return k.failures.take()
+}
+
+// Close delivers buffered records, waiting up to the delivery timeout
+func (k *kafka) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
+	defer cancel()
+	err := k.client.Flush(ctx)
+	k.client.Close()
+	if err != nil {
+		return fmt.Errorf("failed to deliver buffered records: %w", err)
+	}
+	return nil
+}
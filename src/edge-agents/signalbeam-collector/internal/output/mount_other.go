@@ -0,0 +1,12 @@
+//go:build !unix
+
+package output
+
+import "os"
+
+// mounted cannot tell mount points apart on this platform and only checks
+// that dir exists
+func mounted(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// mounted reports whether dir is the root of a mounted filesystem, i.e. it
+// lives on a different device than its parent
+func mounted(dir string) bool {
+	dir = filepath.Clean(dir)
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	parent, err := os.Stat(filepath.Dir(dir))
+	if err != nil {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	pst, pok := parent.Sys().(*syscall.Stat_t)
+	if !ok || !pok {
+		return false
+	}
+	return st.Dev != pst.Dev || st.Ino == pst.Ino
+}
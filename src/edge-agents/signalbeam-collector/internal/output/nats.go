@@ -0,0 +1,193 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+)
+
+// NATS output defaults
+const (
+	defaultNATSSubject    = "signalbeam.{device_id}.{type}"
+	defaultNATSName       = "signalbeam-collector"
+	defaultNATSTimeout    = 30 * time.Second
+	defaultNATSMaxPending = 10000
+	natsDialTimeout       = 10 * time.Second
+)
+
+// errNoNATSServer is returned until the first connection succeeds
+var errNoNATSServer = errors.New("no NATS server reached yet")
+
+// natsOutput publishes messages to NATS subjects. Core NATS buffers while
+// reconnecting; with JetStream, publishes are acknowledged asynchronously
+// and a message not stored within the timeout is reported as a failure by
+// the next Write
+type natsOutput struct {
+	conn     *nats.Conn
+	js       jetstream.JetStream // nil for core NATS
+	subject  string
+	publish  []jetstream.PublishOpt
+	timeout  time.Duration
+	failures failures
+}
+
+// natsDialer connects through the outbound policy. The client upgrades the
+// connection to TLS itself
+type natsDialer struct {
+	dial egress.DialFunc
+}
+
+func (d natsDialer) Dial(network, address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), natsDialTimeout)
+	defer cancel()
+	return d.dial(ctx, network, address)
+}
+
+// newNATS connects to the servers. An unreachable server does not fail the
+// output; the client keeps retrying in the background
+func newNATS(cfg config.NATSOutputConfig, env Env) (*natsOutput, error) {
+	n := &natsOutput{subject: cfg.Subject, timeout: cfg.Timeout}
+	if n.subject == "" {
+		n.subject = defaultNATSSubject
+	}
+	if n.timeout == 0 {
+		n.timeout = defaultNATSTimeout
+	}
+	name := cfg.Name
+	if name == "" {
+		name = defaultNATSName
+	}
+
+	opts := []nats.Option{
+		nats.Name(name),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	}
+	if env.Dial != nil {
+		opts = append(opts, nats.SetCustomDialer(natsDialer{dial: env.Dial}))
+	}
+
+	switch {
+	case cfg.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	case cfg.Token != "":
+		opts = append(opts, nats.Token(cfg.Token))
+	case cfg.Username != "":
+		opts = append(opts, nats.UserInfo(cfg.Username, cfg.Password))
+	}
+
+	secure := cfg.TLS.Enabled
+	for _, raw := range cfg.URLs {
+		if u, err := url.Parse(raw); err == nil && u.Scheme == "tls" {
+			secure = true
+		}
+	}
+	if secure {
+		tlsCfg, err := env.tlsConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.Secure(tlsCfg))
+	}
+
+	conn, err := nats.Connect(strings.Join(cfg.URLs, ","), opts...)
+	if err != nil {
+		return nil, err
+	}
+	n.conn = conn
+
+	if cfg.JetStream.Enabled {
+		maxPending := cfg.MaxPending
+		if maxPending == 0 {
+			maxPending = defaultNATSMaxPending
+		}
+		n.js, err = jetstream.New(conn,
+			jetstream.WithPublishAsyncErrHandler(func(_ jetstream.JetStream, _ *nats.Msg, err error) {
+				n.failures.add(err)
+			}),
+			jetstream.WithPublishAsyncMaxPending(maxPending),
+			jetstream.WithPublishAsyncTimeout(n.timeout),
+		)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if cfg.JetStream.Stream != "" {
+			n.publish = append(n.publish, jetstream.WithExpectStream(cfg.JetStream.Stream))
+		}
+	}
+	return n, nil
+}
+
+func (n *natsOutput) Publish(_ context.Context, msg Message) error {
+	// Messages are only buffered once a server has been reached; before
+	// that the client cannot know whether it supports headers
+	if n.conn.ConnectedServerVersion() == "" {
+		return errNoNATSServer
+	}
+
+	m := &nats.Msg{
+		Subject: strings.NewReplacer("{device_id}", msg.DeviceID, "{type}", msg.Type).Replace(n.subject),
+		Data:    msg.Payload,
+		Header: nats.Header{
+			"type":       []string{msg.Type},
+			"device_id":  []string{msg.DeviceID},
+			"mqtt_topic": []string{msg.Topic},
+		},
+	}
+
+	var err error
+	if n.js != nil {
+		_, err = n.js.PublishMsgAsync(m, n.publish...)
+	} else {
+		err = n.conn.PublishMsg(m)
+	}
+	if err != nil {
+		return err
+	}
+	return n.failures.take()
+}
+
+// Flush waits for outstanding acknowledgements and buffered messages
+func (n *natsOutput) Flush(ctx context.Context) error {
+	if n.js != nil {
+		select {
+		case <-n.js.PublishAsyncComplete():
+		case <-ctx.Done():
+			return fmt.Errorf("%d messages not acknowledged by JetStream", n.js.PublishAsyncPending())
+		}
+	}
+	if err := n.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("failed to flush buffered messages: %w", err)
+	}
+	return n.failures.take()
+}
+
+// Close waits up to the timeout for outstanding acknowledgements and
+// buffered messages
+func (n *natsOutput) Close() error {
+	defer n.conn.Close()
+
+	if n.js != nil {
+		select {
+		case <-n.js.PublishAsyncComplete():
+		case <-time.After(n.timeout):
+			return fmt.Errorf("%d messages not acknowledged by JetStream", n.js.PublishAsyncPending())
+		}
+	}
+	if n.conn.IsConnected() {
+		if err := n.conn.FlushTimeout(n.timeout); err != nil {
+			return fmt.Errorf("failed to flush buffered messages: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package output
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+)
+
+// Message is one encoded record as it was published to the broker
+type Message struct {
+	Type     string // The stream the record belongs to: metrics, logs, events, ...
+	DeviceID string
+	Topic    string
+	QoS      byte
+	Retained bool
+	Payload  []byte
+	RawSize  int // Size of the encoded record before compression, encryption and signing
+	Time     time.Time
+	// Outputs names the outputs a routing rule sent the record to; nil
+	// leaves the choice to the data types of the outputs
+	Outputs []string
+}
+
+// Sink is one destination of published records: the broker, or an output
+// beside it. Each sink handles its errors and retries on its own, so a
+// failing sink does not hold back the others
+type Sink interface {
+	// Publish delivers msg or hands it to the sink's buffer. ctx is cancelled
+	// when the collector stops
+	Publish(ctx context.Context, msg Message) error
+	// Close flushes buffered messages and releases the sink
+	Close() error
+}
+
+// Flusher is a sink that can wait for what it accepted. Outputs that send in
+// the background implement it
+type Flusher interface {
+	// Flush returns once every message handed to Publish so far is
+	// delivered or failed, with the failures
+	Flush(ctx context.Context) error
+}
+
+// Env is what outputs reaching the network take from the collector
+type Env struct {
+	// Dial opens connections through the outbound policy
+	Dial egress.DialFunc
+	// TLS builds a client TLS configuration
+	TLS func(cfg config.MQTTTLSConfig) (*tls.Config, error)
+	// Retried is told about each retry of an output, when set
+	Retried func(name string)
+	// Breaker wraps every output in a circuit breaker when enabled
+	Breaker config.BreakerConfig
+	// BreakerChanged is told when the circuit of an output opens or
+	// closes, with the error that opened it
+	BreakerChanged func(name, state string, err error)
+}
+
+// tlsConfig builds the client TLS configuration of an output
+func (env Env) tlsConfig(cfg config.OutputTLSConfig) (*tls.Config, error) {
+	if env.TLS == nil {
+		return &tls.Config{MinVersion: tls.VersionTLS12}, nil
+	}
+	return env.TLS(cfg.MQTTTLSConfig)
+}
+
+// New creates an output from its definition
+func New(cfg config.OutputConfig, env Env) (Sink, error) {
+	switch cfg.Type {
+	case "file":
+		return newFile(cfg.File)
+	case "kafka":
+		return newKafka(cfg.Kafka, env)
+	case "nats":
+		return newNATS(cfg.NATS, env)
+	case "amqp":
+		return newAMQP(cfg.AMQP, env)
+	case "webhook":
+		return newWebhook(cfg.Webhook, env)
+	}
+	return nil, fmt.Errorf("unsupported output type %q", cfg.Type)
+}
+
+// failures collects asynchronous delivery errors of a network output until
+// the next Write reports them
+type failures struct {
+	mu    sync.Mutex
+	n     int   // Messages lost since the last take
+	first error // First reason for those losses
+}
+
+func (f *failures) add(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.n == 0 {
+		f.first = err
+	}
+	f.n++
+}
+
+// take returns and resets the collected failures
+func (f *failures) take() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.n == 0 {
+		return nil
+	}
+	err := &LostError{N: f.n, Err: f.first}
+	f.n, f.first = 0, nil
+	return err
+}
+
+// LostError reports records an output accepted earlier and failed to
+// deliver. The record being published was accepted, so it is not retried
+type LostError struct {
+	N   int
+	Err error
+}
+
+func (e *LostError) Error() string {
+	return fmt.Sprintf("%d records not delivered: %v", e.N, e.Err)
+}
+
+func (e *LostError) Unwrap() error {
+	return e.Err
+}
+
+// Source tells where an output definition came from
+type Source string
+
+const (
+	// FromConfig outputs are defined in the configuration file
+	FromConfig Source = "config"
+	// FromPush outputs were pushed at runtime and are not persisted
+	FromPush Source = "push"
+)
+
+// entry is a running output
+type entry struct {
+	cfg    config.OutputConfig
+	source Source
+	out    Sink
+	types  map[string]bool
+}
+
+// Set holds the running outputs by name. Outputs can be added, replaced and
+// removed while messages flow: a replacement is opened before it is swapped
+// in and the previous output is closed afterwards, so nothing it buffered is
+// lost
+type Set struct {
+	env     Env
+	mu      sync.RWMutex
+	entries map[string]*entry
+}
+
+// NewSet creates an empty set
+func NewSet(env Env) *Set {
+	return &Set{env: env, entries: make(map[string]*entry)}
+}
+
+// Put starts an output or replaces the one with the same name. It reports
+// whether an output was replaced
+func (s *Set) Put(cfg config.OutputConfig, source Source) (bool, error) {
+	if err := cfg.Validate(); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	prev, exists := s.entries[cfg.Name]
+	s.mu.RUnlock()
+	if exists && prev.source == source && reflect.DeepEqual(prev.cfg, cfg) {
+		return false, nil
+	}
+
+	out, err := New(cfg, s.env)
+	if err != nil {
+		return false, fmt.Errorf("output %s: %w", cfg.Name, err)
+	}
+	if cfg.Retry.Attempts > 0 {
+		out = newRetrying(out, cfg.Name, cfg.Retry, s.env.Retried)
+	}
+	if s.env.Breaker.Enabled {
+		out = newBreaker(out, cfg.Name, s.env.Breaker, s.env.BreakerChanged)
+	}
+	e := &entry{cfg: cfg, source: source, out: out}
+	if len(cfg.Types) > 0 {
+		e.types = make(map[string]bool, len(cfg.Types))
+		for _, t := range cfg.Types {
+			e.types[t] = true
+		}
+	}
+
+	s.mu.Lock()
+	prev, exists = s.entries[cfg.Name]
+	s.entries[cfg.Name] = e
+	s.mu.Unlock()
+
+	if exists {
+		if err := prev.out.Close(); err != nil {
+			return true, fmt.Errorf("output %s: failed to close previous output: %w", cfg.Name, err)
+		}
+	}
+	return exists, nil
+}
+
+// Remove stops an output. It reports whether the output existed
+func (s *Set) Remove(name string) (bool, error) {
+	s.mu.Lock()
+	e, ok := s.entries[name]
+	delete(s.entries, name)
+	s.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	return true, e.out.Close()
+}
+
+// Sync makes the outputs from source match cfgs, leaving other sources'
+// outputs running. It returns the names that were started, replaced or
+// stopped
+func (s *Set) Sync(cfgs []config.OutputConfig, source Source) ([]string, error) {
+	var changed []string
+	wanted := make(map[string]bool, len(cfgs))
+	for _, cfg := range cfgs {
+		wanted[cfg.Name] = true
+		before := s.Running(cfg, source)
+		if _, err := s.Put(cfg, source); err != nil {
+			return changed, err
+		}
+		if !before {
+			changed = append(changed, cfg.Name)
+		}
+	}
+
+	s.mu.RLock()
+	var stale []string
+	for name, e := range s.entries {
+		if e.source == source && !wanted[name] {
+			stale = append(stale, name)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(stale)
+	for _, name := range stale {
+		if _, err := s.Remove(name); err != nil {
+			return changed, fmt.Errorf("output %s: %w", name, err)
+		}
+		changed = append(changed, name)
+	}
+	return changed, nil
+}
+
+// Running reports whether an output with this exact definition is running
+func (s *Set) Running(cfg config.OutputConfig, source Source) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.entries[cfg.Name]
+	return ok && e.source == source && reflect.DeepEqual(e.cfg, cfg)
+}
+
+// Publish hands a message to every output accepting its type, or to the
+// outputs it names, and reports each output's result to deliver. Outputs
+// fail independently. The lock is released before publishing, so a slow
+// output holds up neither Put and Remove nor other publishers. An output replaced or removed meanwhile may
+// still be handed the message
+func (s *Set) Publish(ctx context.Context, msg Message, deliver func(name string, err error)) {
+	s.mu.RLock()
+	targets := make(map[string]Sink, len(s.entries))
+	for name, e := range s.entries {
+		if msg.Outputs != nil && slices.Contains(msg.Outputs, name) || msg.Outputs == nil && (e.types == nil || e.types[msg.Type]) {
+			targets[name] = e.out
+		}
+	}
+	s.mu.RUnlock()
+
+	for name, out := range targets {
+		deliver(name, out.Publish(ctx, msg))
+	}
+}
+
+// Names returns the running outputs by source
+func (s *Set) Names() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make(map[string]string, len(s.entries))
+	for name, e := range s.entries {
+		names[name] = string(e.source)
+	}
+	return names
+}
+
+// Breakers reports the circuit of every output by name
+func (s *Set) Breakers() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make(map[string]interface{}, len(s.entries))
+	for name, e := range s.entries {
+		if b, ok := e.out.(*breaker); ok {
+			out[name] = b.Map()
+		}
+	}
+	return out
+}
+
+// Close stops every output
+func (s *Set) Close() error {
+	s.mu.Lock()
+	entries := s.entries
+	s.entries = make(map[string]*entry)
+	s.mu.Unlock()
+
+	var first error
+	for name, e := range entries {
+		if err := e.out.Close(); err != nil && first == nil {
+			first = fmt.Errorf("output %s: %w", name, err)
+		}
+	}
+	return first
+}
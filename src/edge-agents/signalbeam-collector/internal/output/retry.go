@@ -0,0 +1,168 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/backoff"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+)
+
+// retryBacklog is the most records waiting for a retry of one output
+const retryBacklog = 1000
+
+// errRetryBacklogFull is returned for records that find the backlog full
+var errRetryBacklogFull = errors.New("retry backlog full")
+
+// retrying publishes a message again when its sink fails it, backing off
+// between attempts. Retries run on a goroutine of their own, so a failing
+// output holds up neither the caller nor the other outputs. The records
+// behind a retry wait in the backlog, keeping their order; they suit sinks
+// that fail fast such as a file on a replugged drive
+type retrying struct {
+	Sink
+	name     string
+	attempts int
+	policy   backoff.Policy
+	retried  func(name string)
+	failures failures
+
+	mu      sync.Mutex
+	backlog []Message
+	kick    chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newRetrying(sink Sink, name string, cfg config.OutputRetryConfig, retried func(string)) *retrying {
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &retrying{
+		Sink:     sink,
+		name:     name,
+		attempts: cfg.Attempts,
+		policy:   backoff.Policy{Initial: cfg.Backoff, Max: cfg.MaxBackoff, Multiplier: 2, Jitter: 0.2},
+		retried:  retried,
+		kick:     make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Publish sends the message right away while nothing waits for a retry,
+// and otherwise queues it behind the backlog. A message the sink fails is
+// queued for the retry goroutine. Records that used up their retries are
+// reported as lost with a later message; lost records reported by the sink
+// are passed on without a retry
+func (r *retrying) Publish(ctx context.Context, msg Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.backlog) == 0 {
+		err := r.Sink.Publish(ctx, msg)
+		var lost *LostError
+		if err == nil || errors.As(err, &lost) || ctx.Err() != nil {
+			if err == nil {
+				err = r.failures.take()
+			}
+			return err
+		}
+	}
+	if len(r.backlog) >= retryBacklog {
+		return errRetryBacklogFull
+	}
+	r.backlog = append(r.backlog, msg)
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+	return r.failures.take()
+}
+
+// run retries the head of the backlog until it is accepted or its attempts
+// are used up, then moves on to the records behind it
+func (r *retrying) run() {
+	defer close(r.done)
+	for {
+		select {
+		case <-r.kick:
+		case <-r.ctx.Done():
+			return
+		}
+		for {
+			r.mu.Lock()
+			if len(r.backlog) == 0 {
+				r.mu.Unlock()
+				break
+			}
+			msg := r.backlog[0]
+			r.mu.Unlock()
+
+			err := r.retry(msg)
+			if r.ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				r.failures.add(err)
+			}
+			r.mu.Lock()
+			r.backlog = r.backlog[1:]
+			r.mu.Unlock()
+		}
+	}
+}
+
+// retry publishes msg up to the configured attempts, backing off before
+// each one
+func (r *retrying) retry(msg Message) error {
+	var err error
+	for n := 0; n < r.attempts; n++ {
+		select {
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		case <-time.After(r.policy.Delay(n)):
+		}
+		if r.retried != nil {
+			r.retried(r.name)
+		}
+		if err = r.Sink.Publish(r.ctx, msg); err == nil {
+			return nil
+		}
+		var lost *LostError
+		if errors.As(err, &lost) {
+			// The retried record itself was accepted
+			r.failures.add(err)
+			return nil
+		}
+	}
+	return err
+}
+
+// Close stops the retries, gives each record in the backlog one last
+// attempt and closes the sink
+func (r *retrying) Close() error {
+	r.cancel()
+	<-r.done
+
+	r.mu.Lock()
+	backlog := r.backlog
+	r.backlog = nil
+	r.mu.Unlock()
+	for _, msg := range backlog {
+		if err := r.Sink.Publish(context.Background(), msg); err != nil {
+			break
+		}
+	}
+	return r.Sink.Close()
+}
@@ -0,0 +1,282 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+)
+
+// Webhook output defaults
+const (
+	defaultWebhookMethod      = "POST"
+	defaultWebhookContentType = "application/json"
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookRetries     = 3
+	defaultWebhookBackoff     = time.Second
+	defaultWebhookMaxPending  = 1000
+	maxWebhookBackoff         = time.Minute
+)
+
+// errWebhookQueueFull is returned while the queue is full
+var errWebhookQueueFull = errors.New("webhook queue is full")
+
+// webhookFuncs are available to body templates
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// webhookRecord is what body templates are executed with
+type webhookRecord struct {
+	Type     string
+	DeviceID string
+	Topic    string
+	Time     time.Time
+	Event    string                 // Event name of events records
+	Record   map[string]interface{} // Decoded payload, nil when not a JSON object
+	Payload  string                 // Payload as published
+}
+
+// webhookRequest is a rendered request waiting for delivery
+type webhookRequest struct {
+	body []byte
+	// Closed by the sender in place of a request once the requests ahead
+	// are sent
+	flushed chan struct{}
+}
+
+// webhook sends records to an HTTP endpoint. Requests are queued and sent in
+// the background, so a slow endpoint does not stall the pipeline; a request
+// that still fails after its retries is reported by the next Write
+type webhook struct {
+	client      *http.Client
+	url         string
+	method      string
+	headers     map[string]string
+	contentType string
+	events      []string
+	body        *template.Template // nil sends the payload unchanged
+	retries     int
+	backoff     time.Duration
+	timeout     time.Duration
+
+	queue    chan webhookRequest
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	failures failures
+}
+
+// newWebhook parses the body template and starts the sender
+func newWebhook(cfg config.WebhookOutputConfig, env Env) (*webhook, error) {
+	w := &webhook{
+		url:         cfg.URL,
+		method:      cfg.Method,
+		headers:     cfg.Headers,
+		contentType: cfg.ContentType,
+		events:      cfg.Events,
+		retries:     cfg.Retries,
+		backoff:     cfg.Backoff,
+		timeout:     cfg.Timeout,
+	}
+	if w.method == "" {
+		w.method = defaultWebhookMethod
+	}
+	if w.contentType == "" {
+		w.contentType = defaultWebhookContentType
+	}
+	if w.retries == 0 {
+		w.retries = defaultWebhookRetries
+	}
+	if w.backoff == 0 {
+		w.backoff = defaultWebhookBackoff
+	}
+	if w.timeout == 0 {
+		w.timeout = defaultWebhookTimeout
+	}
+	maxPending := cfg.MaxPending
+	if maxPending == 0 {
+		maxPending = defaultWebhookMaxPending
+	}
+
+	if cfg.Body != "" {
+		tmpl, err := template.New("body").Funcs(webhookFuncs).Option("missingkey=zero").Parse(cfg.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook.body: %w", err)
+		}
+		w.body = tmpl
+	}
+
+	// Connections go through the outbound policy; proxies from the
+	// environment are not used
+	transport := &http.Transport{
+		DialContext:         env.Dial,
+		TLSHandshakeTimeout: w.timeout,
+		MaxIdleConnsPerHost: 1,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if transport.DialContext == nil {
+		transport.DialContext = (&net.Dialer{}).DialContext
+	}
+	if strings.HasPrefix(cfg.URL, "https:") {
+		tlsCfg, err := env.tlsConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsCfg
+	}
+	w.client = &http.Client{Transport: transport, Timeout: w.timeout}
+
+	w.queue = make(chan webhookRequest, maxPending)
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	w.done = make(chan struct{})
+	go w.run()
+	return w, nil
+}
+
+func (w *webhook) Publish(_ context.Context, msg Message) error {
+	rec := webhookRecord{
+		Type:     msg.Type,
+		DeviceID: msg.DeviceID,
+		Topic:    msg.Topic,
+		Time:     msg.Time,
+		Payload:  string(msg.Payload),
+	}
+	if err := json.Unmarshal(msg.Payload, &rec.Record); err == nil && msg.Type == "events" {
+		if data, ok := rec.Record["data"].(map[string]interface{}); ok {
+			rec.Event, _ = data["event"].(string)
+		}
+	}
+	// Events not listed are skipped; other types are not filtered
+	if len(w.events) > 0 && msg.Type == "events" && !slices.Contains(w.events, rec.Event) {
+		return w.failures.take()
+	}
+
+	body := msg.Payload
+	if w.body != nil {
+		var buf bytes.Buffer
+		if err := w.body.Execute(&buf, rec); err != nil {
+			return fmt.Errorf("failed to render webhook body: %w", err)
+		}
+		body = buf.Bytes()
+	}
+
+	select {
+	case w.queue <- webhookRequest{body: body}:
+	default:
+		return errWebhookQueueFull
+	}
+	return w.failures.take()
+}
+
+// Flush waits until the requests queued so far are sent or failed
+func (w *webhook) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case w.queue <- webhookRequest{flushed: flushed}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return w.failures.take()
+}
+
+// run sends queued requests in order until the queue is closed
+func (w *webhook) run() {
+	defer close(w.done)
+	for req := range w.queue {
+		if req.flushed != nil {
+			close(req.flushed)
+			continue
+		}
+		if err := w.deliver(req); err != nil {
+			w.failures.add(err)
+		}
+	}
+}
+
+// deliver sends a request, retrying network errors, 429 and 5xx responses
+func (w *webhook) deliver(req webhookRequest) error {
+	wait := w.backoff
+	var err error
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(wait):
+			case <-w.ctx.Done():
+				return err
+			}
+			wait = min(2*wait, maxWebhookBackoff)
+		}
+
+		var retry bool
+		retry, err = w.send(req)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// send makes one request and reports whether a failure may be retried
+func (w *webhook) send(req webhookRequest) (bool, error) {
+	r, err := http.NewRequestWithContext(w.ctx, w.method, w.url, bytes.NewReader(req.body))
+	if err != nil {
+		return false, err
+	}
+	r.Header.Set("Content-Type", w.contentType)
+	r.Header.Set("User-Agent", "signalbeam-collector")
+	for name, value := range w.headers {
+		r.Header.Set(name, value)
+	}
+
+	resp, err := w.client.Do(r)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+// Close sends the queued requests, waiting up to the request timeout before
+// giving up on the rest
+func (w *webhook) Close() error {
+	close(w.queue)
+	defer w.client.CloseIdleConnections()
+
+	select {
+	case <-w.done:
+	case <-time.After(w.timeout):
+		pending := len(w.queue)
+		w.cancel()
+		<-w.done
+		return fmt.Errorf("%d webhook requests not sent", pending+1)
+	}
+	w.cancel()
+	return w.failures.take()
+}
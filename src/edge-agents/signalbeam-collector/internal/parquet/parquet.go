@@ -0,0 +1,274 @@
+// Package parquet writes small Parquet files: one row group, one PLAIN
+// encoded data page per column and flat schemas of required or optional
+// columns. That covers the collector's exports without a Parquet library
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Kind is the type of a column
+type Kind int
+
+const (
+	// Int64 columns hold Int64s
+	Int64 Kind = iota
+	// Double columns hold Doubles
+	Double
+	// String columns hold UTF-8 Strings
+	String
+	// Timestamp columns hold milliseconds since the Unix epoch in Int64s
+	Timestamp
+)
+
+// Codec compresses pages
+type Codec int
+
+// Codecs, numbered as in the Parquet format
+const (
+	Uncompressed Codec = 0
+	Gzip         Codec = 2
+)
+
+// Physical and converted types from the Parquet format
+const (
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	repRequired = 0
+	repOptional = 1
+
+	encodingPlain = 0
+	encodingRLE   = 3
+)
+
+// Column holds the values of one column. Only the slice matching Kind is
+// used. Optional columns mark nulls in Nulls and leave a zero value in the
+// slice at that row
+type Column struct {
+	Name     string
+	Kind     Kind
+	Optional bool
+
+	Int64s  []int64
+	Doubles []float64
+	Strings []string
+	Nulls   []bool
+}
+
+// Len returns the number of rows in the column
+func (c *Column) Len() int {
+	switch c.Kind {
+	case Double:
+		return len(c.Doubles)
+	case String:
+		return len(c.Strings)
+	}
+	return len(c.Int64s)
+}
+
+func (c *Column) null(i int) bool {
+	return c.Optional && i < len(c.Nulls) && c.Nulls[i]
+}
+
+// physical returns the column's Parquet physical and converted type; the
+// converted type is -1 when there is none
+func (c *Column) physical() (int32, int32) {
+	switch c.Kind {
+	case Double:
+		return typeDouble, -1
+	case String:
+		return typeByteArray, convertedUTF8
+	case Timestamp:
+		return typeInt64, convertedTimestampMillis
+	}
+	return typeInt64, -1
+}
+
+// Write encodes the columns as a Parquet file. All columns must have the
+// same number of rows
+func Write(w io.Writer, columns []Column, codec Codec, createdBy string) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("parquet: no columns")
+	}
+	rows := columns[0].Len()
+	for _, c := range columns {
+		if c.Len() != rows {
+			return fmt.Errorf("parquet: column %s has %d rows, want %d", c.Name, c.Len(), rows)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("PAR1")
+
+	type chunk struct {
+		offset             int64
+		uncompressed, size int64
+	}
+	chunks := make([]chunk, len(columns))
+	for i := range columns {
+		page := encodePage(&columns[i])
+		body, err := compress(page, codec)
+		if err != nil {
+			return err
+		}
+
+		h := newThrift()
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(page)))
+		h.i32(3, int32(len(body)))
+		h.begin(5)
+		h.i32(1, int32(rows))
+		h.i32(2, encodingPlain)
+		h.i32(3, encodingRLE)
+		h.i32(4, encodingRLE)
+		h.end()
+		h.end()
+
+		chunks[i] = chunk{
+			offset:       int64(buf.Len()),
+			uncompressed: int64(len(h.buf) + len(page)),
+			size:         int64(len(h.buf) + len(body)),
+		}
+		buf.Write(h.buf)
+		buf.Write(body)
+	}
+
+	// FileMetaData
+	m := newThrift()
+	m.i32(1, 1)
+	m.list(2, tStruct, len(columns)+1)
+	m.begin(0)
+	m.str(4, "schema")
+	m.i32(5, int32(len(columns)))
+	m.end()
+	for _, c := range columns {
+		typ, converted := c.physical()
+		rep := int32(repRequired)
+		if c.Optional {
+			rep = repOptional
+		}
+		m.begin(0)
+		m.i32(1, typ)
+		m.i32(3, rep)
+		m.str(4, c.Name)
+		if converted >= 0 {
+			m.i32(6, converted)
+		}
+		m.end()
+	}
+	m.i64(3, int64(rows))
+
+	var total int64
+	for _, ch := range chunks {
+		total += ch.uncompressed
+	}
+	m.list(4, tStruct, 1)
+	m.begin(0)
+	m.list(1, tStruct, len(columns))
+	for i, c := range columns {
+		typ, _ := c.physical()
+		ch := chunks[i]
+		m.begin(0)
+		m.i64(2, ch.offset)
+		m.begin(3)
+		m.i32(1, typ)
+		m.listI32(2, encodingPlain, encodingRLE)
+		m.listStr(3, c.Name)
+		m.i32(4, int32(codec))
+		m.i64(5, int64(rows))
+		m.i64(6, ch.uncompressed)
+		m.i64(7, ch.size)
+		m.i64(9, ch.offset)
+		m.end()
+		m.end()
+	}
+	m.i64(2, total)
+	m.i64(3, int64(rows))
+	m.end()
+	m.str(6, createdBy)
+	m.end()
+
+	buf.Write(m.buf)
+	buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(m.buf))))
+	buf.WriteString("PAR1")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// encodePage returns a data page body: definition levels for optional
+// columns followed by the PLAIN encoded non-null values
+func encodePage(c *Column) []byte {
+	var page []byte
+	rows := c.Len()
+	if c.Optional {
+		levels := definitionLevels(c, rows)
+		page = binary.LittleEndian.AppendUint32(page, uint32(len(levels)))
+		page = append(page, levels...)
+	}
+
+	for i := 0; i < rows; i++ {
+		if c.null(i) {
+			continue
+		}
+		switch c.Kind {
+		case Double:
+			page = binary.LittleEndian.AppendUint64(page, math.Float64bits(c.Doubles[i]))
+		case String:
+			page = binary.LittleEndian.AppendUint32(page, uint32(len(c.Strings[i])))
+			page = append(page, c.Strings[i]...)
+		default:
+			page = binary.LittleEndian.AppendUint64(page, uint64(c.Int64s[i]))
+		}
+	}
+	return page
+}
+
+// definitionLevels encodes 1 for present and 0 for null values as RLE runs
+// with a bit width of 1
+func definitionLevels(c *Column, rows int) []byte {
+	var out []byte
+	for i := 0; i < rows; {
+		level := !c.null(i)
+		n := 1
+		for i+n < rows && !c.null(i+n) == level {
+			n++
+		}
+		out = binary.AppendUvarint(out, uint64(n)<<1)
+		if level {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+		i += n
+	}
+	return out
+}
+
+func compress(page []byte, codec Codec) ([]byte, error) {
+	switch codec {
+	case Uncompressed:
+		return page, nil
+	case Gzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(page); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("parquet: unsupported codec %d", codec)
+}
@@ -0,0 +1,162 @@
+package parquet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	ref "github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/deprecated"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// testColumns covers every kind, required and optional, with nulls in runs
+// and alone
+func testColumns() []Column {
+	return []Column{
+		{Name: "time", Kind: Timestamp, Int64s: []int64{1700000000000, 1700000001000, 1700000002000, 1700000003000, 1700000004000}},
+		{Name: "count", Kind: Int64, Int64s: []int64{0, -1, 42, 1 << 40, -(1 << 62)}},
+		{Name: "value", Kind: Double, Optional: true,
+			Doubles: []float64{1.5, 0, 0, -273.15, 1e300},
+			Nulls:   []bool{false, true, true, false, false}},
+		{Name: "device", Kind: String, Strings: []string{"a", "", "gateway-01", "ünïcödé", "x"}},
+		{Name: "note", Kind: String, Optional: true,
+			Strings: []string{"", "first", "", "", "last"},
+			Nulls:   []bool{true, false, true, true, false}},
+	}
+}
+
+func TestWriteReadBack(t *testing.T) {
+	for _, codec := range []Codec{Uncompressed, Gzip} {
+		columns := testColumns()
+		var buf bytes.Buffer
+		if err := Write(&buf, columns, codec, "signalbeam-test"); err != nil {
+			t.Fatalf("codec %d: Write: %v", codec, err)
+		}
+
+		f, err := ref.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("codec %d: reference reader rejects the file: %v", codec, err)
+		}
+		if f.NumRows() != 5 {
+			t.Fatalf("codec %d: NumRows = %d, want 5", codec, f.NumRows())
+		}
+		if got := f.Metadata().CreatedBy; got != "signalbeam-test" {
+			t.Errorf("codec %d: CreatedBy = %q", codec, got)
+		}
+		checkSchema(t, f.Metadata().Schema, columns)
+
+		rows := readRows(t, f)
+		if len(rows) != 5 {
+			t.Fatalf("codec %d: read %d rows, want 5", codec, len(rows))
+		}
+		for r, row := range rows {
+			for _, v := range row {
+				c := &columns[v.Column()]
+				if c.null(r) {
+					if !v.IsNull() {
+						t.Errorf("codec %d: %s[%d] = %v, want null", codec, c.Name, r, v)
+					}
+					continue
+				}
+				if v.IsNull() {
+					t.Errorf("codec %d: %s[%d] is null", codec, c.Name, r)
+					continue
+				}
+				switch c.Kind {
+				case Double:
+					if v.Double() != c.Doubles[r] {
+						t.Errorf("codec %d: %s[%d] = %v, want %v", codec, c.Name, r, v.Double(), c.Doubles[r])
+					}
+				case String:
+					if string(v.ByteArray()) != c.Strings[r] {
+						t.Errorf("codec %d: %s[%d] = %q, want %q", codec, c.Name, r, v.ByteArray(), c.Strings[r])
+					}
+				default:
+					if v.Int64() != c.Int64s[r] {
+						t.Errorf("codec %d: %s[%d] = %d, want %d", codec, c.Name, r, v.Int64(), c.Int64s[r])
+					}
+				}
+			}
+		}
+	}
+}
+
+func checkSchema(t *testing.T, schema []format.SchemaElement, columns []Column) {
+	t.Helper()
+	if len(schema) != len(columns)+1 {
+		t.Fatalf("schema has %d elements, want %d", len(schema), len(columns)+1)
+	}
+	want := map[Kind]struct {
+		typ       format.Type
+		converted *deprecated.ConvertedType
+	}{
+		Int64:     {format.Int64, nil},
+		Double:    {format.Double, nil},
+		String:    {format.ByteArray, ptr(deprecated.UTF8)},
+		Timestamp: {format.Int64, ptr(deprecated.TimestampMillis)},
+	}
+	for i, c := range columns {
+		e := schema[i+1]
+		w := want[c.Kind]
+		if e.Name != c.Name {
+			t.Errorf("column %d is named %q, want %q", i, e.Name, c.Name)
+		}
+		if e.Type == nil || *e.Type != w.typ {
+			t.Errorf("column %s has type %v, want %v", c.Name, e.Type, w.typ)
+		}
+		if (e.ConvertedType == nil) != (w.converted == nil) || e.ConvertedType != nil && *e.ConvertedType != *w.converted {
+			t.Errorf("column %s has converted type %v, want %v", c.Name, e.ConvertedType, w.converted)
+		}
+		rep := format.Required
+		if c.Optional {
+			rep = format.Optional
+		}
+		if e.RepetitionType == nil || *e.RepetitionType != rep {
+			t.Errorf("column %s has repetition %v, want %v", c.Name, e.RepetitionType, rep)
+		}
+	}
+}
+
+func readRows(t *testing.T, f *ref.File) []ref.Row {
+	t.Helper()
+	groups := f.RowGroups()
+	if len(groups) != 1 {
+		t.Fatalf("file has %d row groups, want 1", len(groups))
+	}
+	rows := groups[0].Rows()
+	defer rows.Close()
+
+	var out []ref.Row
+	buf := make([]ref.Row, 2)
+	for {
+		n, err := rows.ReadRows(buf)
+		for _, row := range buf[:n] {
+			out = append(out, row.Clone())
+		}
+		if errors.Is(err, io.EOF) {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("ReadRows: %v", err)
+		}
+	}
+}
+
+func TestWriteRejectsUnevenColumns(t *testing.T) {
+	columns := []Column{
+		{Name: "a", Kind: Int64, Int64s: []int64{1, 2}},
+		{Name: "b", Kind: Int64, Int64s: []int64{1}},
+	}
+	if err := Write(io.Discard, columns, Uncompressed, ""); err == nil {
+		t.Error("Write accepted columns of different lengths")
+	}
+	if err := Write(io.Discard, nil, Uncompressed, ""); err == nil {
+		t.Error("Write accepted no columns")
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
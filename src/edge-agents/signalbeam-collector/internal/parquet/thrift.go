@@ -0,0 +1,102 @@
+package parquet
+
+import (
+	"encoding/binary"
+)
+
+// Thrift compact protocol types used by the Parquet metadata
+const (
+	tI32    = 5
+	tI64    = 6
+	tBinary = 8
+	tList   = 9
+	tStruct = 12
+)
+
+// thrift writes Thrift compact protocol structs. Field IDs must increase
+// within a struct
+type thrift struct {
+	buf  []byte
+	last []int16 // Last field ID per open struct
+}
+
+func newThrift() *thrift {
+	return &thrift{last: []int16{0}}
+}
+
+func (t *thrift) field(id int16, typ byte) {
+	delta := id - t.last[len(t.last)-1]
+	if delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.varint(zigzag(int64(id)))
+	}
+	t.last[len(t.last)-1] = id
+}
+
+func (t *thrift) varint(v uint64) {
+	t.buf = binary.AppendUvarint(t.buf, v)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (t *thrift) i32(id int16, v int32) {
+	t.field(id, tI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.field(id, tI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thrift) str(id int16, s string) {
+	t.field(id, tBinary)
+	t.varint(uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// list starts a list field of n elements of type typ
+func (t *thrift) list(id int16, typ byte, n int) {
+	t.field(id, tList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|typ)
+	} else {
+		t.buf = append(t.buf, 0xf0|typ)
+		t.varint(uint64(n))
+	}
+}
+
+// listI32 writes a list of i32 values
+func (t *thrift) listI32(id int16, values ...int32) {
+	t.list(id, tI32, len(values))
+	for _, v := range values {
+		t.varint(zigzag(int64(v)))
+	}
+}
+
+// listStr writes a list of strings
+func (t *thrift) listStr(id int16, values ...string) {
+	t.list(id, tBinary, len(values))
+	for _, s := range values {
+		t.varint(uint64(len(s)))
+		t.buf = append(t.buf, s...)
+	}
+}
+
+// begin opens a struct, as a field when id is not 0 or as a list element
+func (t *thrift) begin(id int16) {
+	if id != 0 {
+		t.field(id, tStruct)
+	}
+	t.last = append(t.last, 0)
+}
+
+// end closes the innermost struct
+func (t *thrift) end() {
+	t.buf = append(t.buf, 0)
+	t.last = t.last[:len(t.last)-1]
+}
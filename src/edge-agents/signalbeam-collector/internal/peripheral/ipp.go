@@ -0,0 +1,480 @@
+// Package peripheral reports the health of peripherals of a point of sale
+// or kiosk: printers over IPP, which CUPS queues and network receipt
+// printers speak, and the USB devices attached to the host
+package peripheral
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxResponseBytes bounds an IPP response
+const maxResponseBytes = 1 << 20
+
+// IPP operations
+const (
+	opGetJobs              = 0x000a
+	opGetPrinterAttributes = 0x000b
+)
+
+// IPP delimiter and value tags
+const (
+	tagOperation    = 0x01
+	tagJob          = 0x02
+	tagEnd          = 0x03
+	tagPrinter      = 0x04
+	tagInteger      = 0x21
+	tagBoolean      = 0x22
+	tagEnum         = 0x23
+	tagURI          = 0x45
+	tagCharset      = 0x47
+	tagLanguage     = 0x48
+	tagKeyword      = 0x44
+	tagName         = 0x42
+	maxDelimiterTag = 0x0f
+	maxOutOfBandTag = 0x1f // no-value, unknown and unsupported
+)
+
+// printerStates names the values of printer-state
+var printerStates = map[int]string{3: "idle", 4: "processing", 5: "stopped"}
+
+// jobStates names the values of job-state
+var jobStates = map[int]string{
+	3: "pending", 4: "pending-held", 5: "processing", 6: "processing-stopped",
+	7: "canceled", 8: "aborted", 9: "completed",
+}
+
+// printerAttributes are requested with Get-Printer-Attributes
+var printerAttributes = []string{
+	"printer-state", "printer-state-reasons", "printer-state-message",
+	"printer-is-accepting-jobs", "queued-job-count", "printer-make-and-model",
+	"marker-names", "marker-levels",
+}
+
+// jobAttributes are requested with Get-Jobs
+var jobAttributes = []string{"job-id", "job-name", "job-state", "job-state-reasons", "time-at-completed"}
+
+// Printer is a print queue or network printer reached over IPP
+type Printer struct {
+	URI    string // ipp://localhost:631/printers/receipt; ipps:// for TLS
+	Client *http.Client
+}
+
+// PrinterStatus is what a printer reported
+type PrinterStatus struct {
+	State     string // "idle", "processing" or "stopped"
+	Accepting bool   // Accepts new jobs
+	Message   string
+	Reasons   []string // printer-state-reasons as reported, without "none"
+	Errors    []string // Reasons that stop printing, without their suffix
+	Warnings  []string
+	Paper     string // "ok", "low", "empty" or "jam"
+	Queued    int
+	Model     string
+	Supplies  map[string]int // Level of each marker in percent; negative when unknown
+	LastJob   *Job           // Last finished job; nil when the printer keeps no history
+}
+
+// Job is a print job
+type Job struct {
+	ID        int
+	Name      string
+	State     string // "completed", "canceled" or "aborted" once finished
+	Reasons   []string
+	Completed time.Time
+}
+
+// Healthy reports whether the printer can print
+func (s PrinterStatus) Healthy() bool {
+	return s.State != "stopped" && s.Accepting && len(s.Errors) == 0
+}
+
+// Map returns the status as a payload map
+func (s PrinterStatus) Map() map[string]interface{} {
+	m := map[string]interface{}{
+		"state":     s.State,
+		"healthy":   s.Healthy(),
+		"accepting": s.Accepting,
+		"paper":     s.Paper,
+		"queued":    s.Queued,
+	}
+	if s.Message != "" {
+		m["message"] = s.Message
+	}
+	if len(s.Reasons) > 0 {
+		m["reasons"] = s.Reasons
+	}
+	if len(s.Errors) > 0 {
+		m["errors"] = s.Errors
+	}
+	if len(s.Warnings) > 0 {
+		m["warnings"] = s.Warnings
+	}
+	if s.Model != "" {
+		m["model"] = s.Model
+	}
+	if len(s.Supplies) > 0 {
+		supplies := make(map[string]interface{}, len(s.Supplies))
+		for name, level := range s.Supplies {
+			supplies[name] = level
+		}
+		m["supplies"] = supplies
+	}
+	if j := s.LastJob; j != nil {
+		job := map[string]interface{}{"id": j.ID, "state": j.State}
+		if j.Name != "" {
+			job["name"] = j.Name
+		}
+		if len(j.Reasons) > 0 {
+			job["reasons"] = j.Reasons
+		}
+		if !j.Completed.IsZero() {
+			job["completed"] = j.Completed.UTC().Format(time.RFC3339)
+		}
+		m["last_job"] = job
+	}
+	return m
+}
+
+// Status queries the printer state and its last finished job. Printers
+// that do not support Get-Jobs are reported without a last job
+func (p Printer) Status(ctx context.Context) (PrinterStatus, error) {
+	groups, err := p.call(ctx, opGetPrinterAttributes, []attribute{
+		{tagKeyword, "requested-attributes", stringValues(printerAttributes)},
+	})
+	if err != nil {
+		return PrinterStatus{}, err
+	}
+	printer := firstGroup(groups, tagPrinter)
+	if printer == nil {
+		return PrinterStatus{}, fmt.Errorf("IPP: response has no printer attributes")
+	}
+
+	s := PrinterStatus{
+		State:     printerStates[printer.int("printer-state")],
+		Accepting: printer.bool("printer-is-accepting-jobs"),
+		Message:   printer.string("printer-state-message"),
+		Queued:    printer.int("queued-job-count"),
+		Model:     printer.string("printer-make-and-model"),
+		Paper:     "ok",
+	}
+	if s.State == "" {
+		s.State = "unknown"
+	}
+	for _, reason := range printer.strings("printer-state-reasons") {
+		if reason == "none" {
+			continue
+		}
+		s.Reasons = append(s.Reasons, reason)
+		// Reasons without a suffix are errors (RFC 8011, 5.4.12)
+		switch base, severity := splitReason(reason); severity {
+		case "report":
+			// Informational, such as offline-report of a sleeping printer
+		case "warning":
+			s.Warnings = append(s.Warnings, base)
+		default:
+			s.Errors = append(s.Errors, base)
+		}
+		s.Paper = worsePaper(s.Paper, paperStatus(reason))
+	}
+	names, levels := printer.strings("marker-names"), printer.ints("marker-levels")
+	for i, name := range names {
+		if i < len(levels) {
+			if s.Supplies == nil {
+				s.Supplies = make(map[string]int, len(names))
+			}
+			s.Supplies[name] = levels[i]
+		}
+	}
+
+	if job, err := p.lastJob(ctx); err == nil {
+		s.LastJob = job
+	}
+	return s, nil
+}
+
+// lastJob returns the finished job with the highest ID, or nil
+func (p Printer) lastJob(ctx context.Context) (*Job, error) {
+	groups, err := p.call(ctx, opGetJobs, []attribute{
+		{tagName, "requesting-user-name", []interface{}{"signalbeam"}},
+		{tagKeyword, "which-jobs", []interface{}{"completed"}},
+		{tagKeyword, "requested-attributes", stringValues(jobAttributes)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var last *Job
+	for _, g := range groups {
+		if g.tag != tagJob {
+			continue
+		}
+		j := &Job{
+			ID:      g.int("job-id"),
+			Name:    g.string("job-name"),
+			State:   jobStates[g.int("job-state")],
+			Reasons: g.strings("job-state-reasons"),
+		}
+		if at := g.int("time-at-completed"); at > 0 {
+			j.Completed = time.Unix(int64(at), 0)
+		}
+		if last == nil || j.ID > last.ID {
+			last = j
+		}
+	}
+	return last, nil
+}
+
+// splitReason splits a printer-state-reasons keyword into its base and
+// severity suffix
+func splitReason(reason string) (string, string) {
+	for _, severity := range []string{"report", "warning", "error"} {
+		if base, ok := strings.CutSuffix(reason, "-"+severity); ok {
+			return base, severity
+		}
+	}
+	return reason, ""
+}
+
+// paperStatus returns what a reason says about the paper, "ok" when nothing
+func paperStatus(reason string) string {
+	switch base, _ := splitReason(reason); base {
+	case "media-jam":
+		return "jam"
+	case "media-empty", "media-needed":
+		return "empty"
+	case "media-low":
+		return "low"
+	}
+	return "ok"
+}
+
+// worsePaper returns the more severe of two paper states
+func worsePaper(a, b string) string {
+	rank := map[string]int{"ok": 0, "low": 1, "empty": 2, "jam": 3}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// attribute is an IPP attribute with its values
+type attribute struct {
+	tag    byte
+	name   string
+	values []interface{} // string, int or bool
+}
+
+// group is an attribute group of a response
+type group struct {
+	tag   byte
+	attrs map[string][]interface{}
+}
+
+func (g *group) string(name string) string {
+	if v := g.attrs[name]; len(v) > 0 {
+		s, _ := v[0].(string)
+		return s
+	}
+	return ""
+}
+
+func (g *group) strings(name string) []string {
+	var out []string
+	for _, v := range g.attrs[name] {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (g *group) int(name string) int {
+	if v := g.attrs[name]; len(v) > 0 {
+		n, _ := v[0].(int)
+		return n
+	}
+	return 0
+}
+
+func (g *group) ints(name string) []int {
+	var out []int
+	for _, v := range g.attrs[name] {
+		if n, ok := v.(int); ok {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func (g *group) bool(name string) bool {
+	if v := g.attrs[name]; len(v) > 0 {
+		b, _ := v[0].(bool)
+		return b
+	}
+	return false
+}
+
+// firstGroup returns the first group with the tag, or nil
+func firstGroup(groups []*group, tag byte) *group {
+	for _, g := range groups {
+		if g.tag == tag {
+			return g
+		}
+	}
+	return nil
+}
+
+func stringValues(s []string) []interface{} {
+	out := make([]interface{}, len(s))
+	for i, v := range s {
+		out[i] = v
+	}
+	return out
+}
+
+// call sends an IPP request with the operation attributes every request
+// carries followed by attrs, and decodes the response
+func (p Printer) call(ctx context.Context, op uint16, attrs []attribute) ([]*group, error) {
+	u, err := url.Parse(p.URI)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := *u
+	switch u.Scheme {
+	case "ipp":
+		endpoint.Scheme = "http"
+	case "ipps":
+		endpoint.Scheme = "https"
+	}
+	if u.Port() == "" && (u.Scheme == "ipp" || u.Scheme == "ipps") {
+		endpoint.Host = u.Hostname() + ":631"
+	}
+
+	var body bytes.Buffer
+	body.Write([]byte{2, 0}) // IPP 2.0
+	binary.Write(&body, binary.BigEndian, op)
+	binary.Write(&body, binary.BigEndian, uint32(1))
+	body.WriteByte(tagOperation)
+	all := append([]attribute{
+		{tagCharset, "attributes-charset", []interface{}{"utf-8"}},
+		{tagLanguage, "attributes-natural-language", []interface{}{"en"}},
+		{tagURI, "printer-uri", []interface{}{p.URI}},
+	}, attrs...)
+	for _, a := range all {
+		for i, v := range a.values {
+			name := a.name
+			if i > 0 {
+				name = "" // Additional value of the attribute
+			}
+			writeValue(&body, a.tag, name, v.(string))
+		}
+	}
+	body.WriteByte(tagEnd)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ipp")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("IPP: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("IPP: HTTP status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("IPP: %w", err)
+	}
+	status, groups, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("IPP: %w", err)
+	}
+	// Status codes from 0x0100 on are errors; below are successful
+	if status >= 0x0100 {
+		msg := ""
+		if g := firstGroup(groups, tagOperation); g != nil {
+			msg = g.string("status-message")
+		}
+		return nil, fmt.Errorf("IPP: status 0x%04x %s", status, msg)
+	}
+	return groups, nil
+}
+
+// writeValue encodes one attribute value
+func writeValue(w *bytes.Buffer, tag byte, name, value string) {
+	w.WriteByte(tag)
+	binary.Write(w, binary.BigEndian, uint16(len(name)))
+	w.WriteString(name)
+	binary.Write(w, binary.BigEndian, uint16(len(value)))
+	w.WriteString(value)
+}
+
+// decode parses a response into its status code and attribute groups.
+// Integers and enums become int, booleans bool and everything else string
+func decode(data []byte) (uint16, []*group, error) {
+	if len(data) < 8 {
+		return 0, nil, errors.New("response too short")
+	}
+	status := binary.BigEndian.Uint16(data[2:4])
+	var groups []*group
+	var cur *group
+	last := ""
+	for i := 8; i < len(data); {
+		tag := data[i]
+		i++
+		if tag <= maxDelimiterTag {
+			if tag == tagEnd {
+				return status, groups, nil
+			}
+			cur = &group{tag: tag, attrs: make(map[string][]interface{})}
+			groups = append(groups, cur)
+			continue
+		}
+		if cur == nil || i+2 > len(data) {
+			return 0, nil, errors.New("malformed response")
+		}
+		n := int(binary.BigEndian.Uint16(data[i:]))
+		i += 2
+		if i+n+2 > len(data) {
+			return 0, nil, errors.New("malformed response")
+		}
+		name := string(data[i : i+n])
+		i += n
+		n = int(binary.BigEndian.Uint16(data[i:]))
+		i += 2
+		if i+n > len(data) {
+			return 0, nil, errors.New("malformed response")
+		}
+		raw := data[i : i+n]
+		i += n
+		if name == "" {
+			name = last // Additional value of the previous attribute
+		}
+		last = name
+
+		var v interface{}
+		switch {
+		case (tag == tagInteger || tag == tagEnum) && len(raw) == 4:
+			v = int(int32(binary.BigEndian.Uint32(raw)))
+		case tag == tagBoolean && len(raw) == 1:
+			v = raw[0] != 0
+		case tag <= maxOutOfBandTag:
+			continue
+		default:
+			v = string(raw)
+		}
+		cur.attrs[name] = append(cur.attrs[name], v)
+	}
+	return 0, nil, errors.New("response has no end tag")
+}
@@ -0,0 +1,72 @@
+package peripheral
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hubClass is the USB device class of hubs, which are not peripherals
+const hubClass = "09"
+
+// USBDevice is a device attached to the USB bus
+type USBDevice struct {
+	Path         string // Bus path, e.g. 1-1.2
+	ID           string // vendor:product in hex, e.g. 04b8:0e28
+	Manufacturer string
+	Product      string
+	Serial       string
+	Speed        string // Mbit/s, e.g. 480
+}
+
+// Map returns the device as a payload map
+func (d USBDevice) Map() map[string]interface{} {
+	m := map[string]interface{}{"path": d.Path, "id": d.ID}
+	for k, v := range map[string]string{"manufacturer": d.Manufacturer, "product": d.Product, "serial": d.Serial, "speed": d.Speed} {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// ScanUSB lists the USB devices below dir, usually /sys/bus/usb/devices,
+// leaving out hubs and the interfaces of devices
+func ScanUSB(dir string) ([]USBDevice, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []USBDevice
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		vendor := readAttr(filepath.Join(p, "idVendor"))
+		if vendor == "" || readAttr(filepath.Join(p, "bDeviceClass")) == hubClass {
+			continue
+		}
+		out = append(out, USBDevice{
+			Path:         e.Name(),
+			ID:           vendor + ":" + readAttr(filepath.Join(p, "idProduct")),
+			Manufacturer: readAttr(filepath.Join(p, "manufacturer")),
+			Product:      readAttr(filepath.Join(p, "product")),
+			Serial:       readAttr(filepath.Join(p, "serial")),
+			Speed:        readAttr(filepath.Join(p, "speed")),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+// readAttr reads a sysfs attribute, trimmed; "" when it cannot be read
+func readAttr(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,146 @@
+// Package portal detects captive portals and walled gardens, which hold the
+// uplink of hotel and venue networks until someone signs in, by requesting
+// known URLs over plain HTTP and comparing the answers with the expected ones
+package portal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+)
+
+// maxBody is the most of a response body read for comparison
+const maxBody = 4 << 10
+
+// States of the network path
+const (
+	Open        = "open"        // Every probe got the expected answer
+	Captive     = "captive"     // A probe was answered by something else
+	Unreachable = "unreachable" // No probe got an answer
+)
+
+// Probe is a URL with a known answer. Either the status or the body, or
+// both, must match
+type Probe struct {
+	URL    string
+	Status int    // Expected status, 0 any
+	Body   string // Expected body, compared after trimming white space; empty any
+}
+
+// Result is the outcome of a check
+type Result struct {
+	State     string
+	Probe     string // URL of the probe that decided the state
+	Status    int    // Status the probe got
+	PortalURL string // Where a captive portal redirected to, if it did
+	Err       error  // Why the last probe got no answer
+}
+
+// Detector runs the probes
+type Detector struct {
+	probes []Probe
+	client *http.Client
+}
+
+// New creates a detector. Redirects are not followed, since a redirect is
+// how most portals answer
+func New(probes []Probe, timeout time.Duration, dial egress.DialFunc) *Detector {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	if dial != nil {
+		transport.DialContext = dial
+	}
+	return &Detector{
+		probes: probes,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Check runs the probes in order. The path is captive as soon as one probe
+// gets an unexpected answer, since walled gardens often let some hosts
+// through, and open when the others got the expected one
+func (d *Detector) Check(ctx context.Context) Result {
+	result := Result{State: Unreachable}
+	for _, p := range d.probes {
+		r := d.probe(ctx, p)
+		switch r.State {
+		case Captive:
+			return r
+		case Open:
+			result = r
+		case Unreachable:
+			if result.State == Unreachable {
+				result = r
+			}
+		}
+	}
+	return result
+}
+
+// probe requests one URL
+func (d *Detector) probe(ctx context.Context, p Probe) Result {
+	r := Result{State: Unreachable, Probe: p.URL}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	r.Status = resp.StatusCode
+	switch {
+	case p.Status != 0 && resp.StatusCode != p.Status:
+	case p.Body != "" && string(bytes.TrimSpace(body)) != p.Body:
+	default:
+		r.State = Open
+		return r
+	}
+	r.State = Captive
+	if loc, err := resp.Location(); err == nil {
+		r.PortalURL = loc.String()
+	}
+	return r
+}
+
+// Validate checks a probe. Probes use plain HTTP: a portal cannot answer
+// for an HTTPS URL without failing the handshake, which looks unreachable
+func Validate(p Probe) error {
+	u, err := url.Parse(p.URL)
+	switch {
+	case p.URL == "":
+		return errors.New("url is required")
+	case err != nil:
+		return err
+	case u.Scheme != "http" || u.Host == "":
+		return errors.New("url must be an http:// URL")
+	case p.Status == 0 && p.Body == "":
+		return errors.New("status or body is required")
+	case p.Status != 0 && (p.Status < 100 || p.Status > 599):
+		return fmt.Errorf("status %d is not an HTTP status", p.Status)
+	}
+	return nil
+}
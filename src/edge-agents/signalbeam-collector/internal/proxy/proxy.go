@@ -0,0 +1,282 @@
+// Package proxy opens connections through SOCKS5 and HTTP CONNECT proxies,
+// for devices in networks that only reach the internet through one
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+)
+
+// Default ports when the proxy URL has none
+var defaultPorts = map[string]string{"socks5": "1080", "http": "3128"}
+
+// Dialer connects to destinations through a proxy
+type Dialer struct {
+	url      *url.URL // Without credentials
+	username string
+	password string
+	forward  egress.DialFunc
+}
+
+// New creates a dialer for a socks5:// or http:// proxy URL. Credentials
+// are taken from username and password, or else from the URL. forward
+// opens the connection to the proxy, by default a plain dialer
+func New(rawURL, username, password string, forward egress.DialFunc) (*Dialer, error) {
+	u, err := Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if username == "" && u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	u.User = nil
+	if forward == nil {
+		forward = (&net.Dialer{}).DialContext
+	}
+	return &Dialer{url: u, username: username, password: password, forward: forward}, nil
+}
+
+// Parse checks a proxy URL and adds the default port
+func Parse(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	port, ok := defaultPorts[u.Scheme]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, use socks5 or http", u.Scheme)
+	case u.Hostname() == "":
+		return nil, errors.New("proxy host is required")
+	case u.Path != "" && u.Path != "/":
+		return nil, errors.New("proxy URL must not have a path")
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	u.Path = ""
+	return u, nil
+}
+
+// URL returns the proxy URL with its credentials, for clients that connect
+// through proxies themselves
+func (d *Dialer) URL() *url.URL {
+	u := *d.url
+	if d.username != "" {
+		u.User = url.UserPassword(d.username, d.password)
+	}
+	return &u
+}
+
+// DialContext connects to address through the proxy. Host names are
+// resolved by the proxy, so destinations need not resolve locally
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("proxy: network %s not supported", network)
+	}
+	conn, err := d.forward(ctx, "tcp", d.url.Host)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", d.url.Host, err)
+	}
+	// The handshake is bounded by the context
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	if d.url.Scheme == "socks5" {
+		err = d.socks5(conn, address)
+	} else {
+		conn, err = d.connect(conn, address)
+	}
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("proxy %s: %w", d.url.Host, err)
+	}
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// socks5 runs the SOCKS5 handshake (RFC 1928), with username and password
+// authentication (RFC 1929) when credentials are set
+func (d *Dialer) socks5(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	methods := []byte{0x00}
+	if d.username != "" {
+		methods = []byte{0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	switch {
+	case reply[0] != 0x05:
+		return errors.New("not a SOCKS5 proxy")
+	case reply[1] == 0xff:
+		return errors.New("no acceptable authentication method")
+	case reply[1] != methods[0]:
+		return fmt.Errorf("unexpected authentication method %d", reply[1])
+	}
+
+	if d.username != "" {
+		if len(d.username) > 255 || len(d.password) > 255 {
+			return errors.New("SOCKS5 credentials longer than 255 bytes")
+		}
+		req := []byte{0x01, byte(len(d.username))}
+		req = append(req, d.username...)
+		req = append(req, byte(len(d.password)))
+		req = append(req, d.password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("authentication failed")
+		}
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(append(req, 0x01), ip4...)
+		} else {
+			req = append(append(req, 0x04), ip...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("host name longer than 255 bytes")
+		}
+		req = append(append(req, 0x03, byte(len(host))), host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var head [4]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return err
+	}
+	if head[1] != 0x00 {
+		return fmt.Errorf("connect to %s refused: %s", address, socksReply(head[1]))
+	}
+	// Skip the bound address
+	var skip int
+	switch head[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("unknown address type %d", head[3])
+	}
+	_, err = io.CopyN(io.Discard, conn, int64(skip+2))
+	return err
+}
+
+// socksReply describes a SOCKS5 reply code
+func socksReply(code byte) string {
+	switch code {
+	case 0x01:
+		return "general failure"
+	case 0x02:
+		return "not allowed by ruleset"
+	case 0x03:
+		return "network unreachable"
+	case 0x04:
+		return "host unreachable"
+	case 0x05:
+		return "connection refused"
+	case 0x06:
+		return "TTL expired"
+	case 0x07:
+		return "command not supported"
+	case 0x08:
+		return "address type not supported"
+	}
+	return fmt.Sprintf("reply %d", code)
+}
+
+// connect opens a tunnel with an HTTP CONNECT request, with basic
+// authentication when credentials are set. Bytes the proxy sent past its
+// response are kept in the returned connection
+func (d *Dialer) connect(conn net.Conn, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if d.username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(d.username + ":" + d.password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return conn, err
+	}
+	// The body of a CONNECT response is the tunnel, so it is not read
+	if resp.StatusCode/100 != 2 {
+		return conn, fmt.Errorf("CONNECT %s: %s", address, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads what was buffered during the handshake first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,86 @@
+package quality
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/units"
+)
+
+// Alert states of a value path
+const (
+	// Raised means flags appeared on a path that had none
+	Raised = "raised"
+	// Changed means the set of flags on a path changed
+	Changed = "changed"
+	// Cleared means a flagged path carried a good value again
+	Cleared = "cleared"
+)
+
+// Transition is a change of the flags on one value path
+type Transition struct {
+	Type     string
+	Path     string
+	State    string
+	Flags    []string // Flags now set, empty when cleared
+	Previous []string // Flags set before, empty when raised
+}
+
+// Alerts turns quality flags into alert state changes per value path
+type Alerts struct {
+	mu     sync.Mutex
+	active map[string][]string // Sorted flags by type and dotted path
+}
+
+// NewAlerts creates a tracker with no active alerts
+func NewAlerts() *Alerts {
+	return &Alerts{active: make(map[string][]string)}
+}
+
+// Observe compares the flags of a record with the active alerts of its type
+// and returns the changes. A path clears when the record carries a value for
+// it without flags; paths missing from the record keep their state
+func (a *Alerts) Observe(dataType string, data map[string]interface{}, flags map[string][]string) []Transition {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var changes []Transition
+	for path, f := range flags {
+		key := dataType + ":" + path
+		now := slices.Clone(f)
+		slices.Sort(now)
+		prev, ok := a.active[key]
+		switch {
+		case !ok:
+			changes = append(changes, Transition{Type: dataType, Path: path, State: Raised, Flags: now})
+		case !slices.Equal(prev, now):
+			changes = append(changes, Transition{Type: dataType, Path: path, State: Changed, Flags: now, Previous: prev})
+		default:
+			continue
+		}
+		a.active[key] = now
+	}
+
+	units.Walk(data, nil, func(path []string, _ map[string]interface{}, _ string, _ interface{}) {
+		dotted := strings.Join(path, ".")
+		if _, flagged := flags[dotted]; flagged {
+			return
+		}
+		key := dataType + ":" + dotted
+		if prev, ok := a.active[key]; ok {
+			delete(a.active, key)
+			changes = append(changes, Transition{Type: dataType, Path: dotted, State: Cleared, Previous: prev})
+		}
+	})
+
+	slices.SortFunc(changes, func(x, y Transition) int { return strings.Compare(x.Path, y.Path) })
+	return changes
+}
+
+// Active returns the number of paths with active alerts
+func (a *Alerts) Active() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.active)
+}
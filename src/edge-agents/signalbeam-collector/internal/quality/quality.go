@@ -0,0 +1,110 @@
+package quality
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/units"
+)
+
+// Quality flags attached to telemetry values
+const (
+	// Stale marks a value that has not changed for longer than expected
+	Stale = "stale"
+	// Interpolated marks a value that was estimated rather than measured
+	Interpolated = "interpolated"
+	// SensorFault marks a value that could not be read or is not a number
+	SensorFault = "sensor_fault"
+	// OutOfRange marks a value outside its configured bounds
+	OutOfRange = "out_of_range"
+)
+
+// Bound declares the valid range and staleness limit for values at a path
+// pattern. Pattern segments are separated by "." and "*" matches one segment
+type Bound struct {
+	Type       string
+	Path       string
+	Min        *float64
+	Max        *float64
+	StaleAfter time.Duration
+}
+
+// lastValue tracks when a value last changed
+type lastValue struct {
+	value   float64
+	changed time.Time
+}
+
+// Annotator derives quality flags for telemetry payloads
+type Annotator struct {
+	bounds []Bound
+
+	mu   sync.Mutex
+	last map[string]lastValue
+}
+
+// New creates an annotator for the given bounds
+func New(bounds []Bound) *Annotator {
+	return &Annotator{bounds: bounds, last: make(map[string]lastValue)}
+}
+
+// Annotate adds flags for the values in data to flags, keyed by dotted path,
+// and returns the result (nil when every value is good). Non-finite values
+// are replaced with nil so the payload stays valid JSON
+func (a *Annotator) Annotate(dataType string, data map[string]interface{}, at time.Time, flags map[string][]string) map[string][]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	mark := func(path, flag string) {
+		if flags == nil {
+			flags = make(map[string][]string)
+		}
+		for _, f := range flags[path] {
+			if f == flag {
+				return
+			}
+		}
+		flags[path] = append(flags[path], flag)
+	}
+
+	units.Walk(data, nil, func(path []string, parent map[string]interface{}, key string, v interface{}) {
+		f, ok := units.ToFloat(v)
+		if !ok {
+			return
+		}
+		dotted := strings.Join(path, ".")
+
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			parent[key] = nil
+			mark(dotted, SensorFault)
+			return
+		}
+
+		for _, b := range a.bounds {
+			if b.Type != dataType || !units.Match(b.Path, path) {
+				continue
+			}
+			if (b.Min != nil && f < *b.Min) || (b.Max != nil && f > *b.Max) {
+				mark(dotted, OutOfRange)
+			}
+			if b.StaleAfter > 0 && a.stale(dataType+":"+dotted, f, at, b.StaleAfter) {
+				mark(dotted, Stale)
+			}
+		}
+	})
+
+	return flags
+}
+
+// stale records a value and reports whether it has been unchanged for
+// longer than limit
+func (a *Annotator) stale(key string, v float64, at time.Time, limit time.Duration) bool {
+	last, ok := a.last[key]
+	if !ok || last.value != v {
+		a.last[key] = lastValue{value: v, changed: at}
+		return false
+	}
+	return at.Sub(last.changed) > limit
+}
@@ -0,0 +1,425 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/output"
+)
+
+// headerSize is the length and CRC-32 before each record
+const headerSize = 8
+
+// cursorEvery is how many acknowledgements pass between saves of the read
+// position. After a crash at most this many messages are sent again
+const cursorEvery = 100
+
+// DiskOptions sets the segment size and the retention of a disk queue
+type DiskOptions struct {
+	SegmentBytes int64
+	MaxBytes     int64         // Oldest segments are dropped above this
+	MaxAge       time.Duration // Older messages are dropped; 0 keeps them
+}
+
+// Disk is a queue of append-only segment files in a directory. Segments
+// are removed once read; the read position is saved beside them, so the
+// queue drains in order across restarts
+type Disk struct {
+	mu       sync.Mutex
+	dir      string
+	opts     DiskOptions
+	segments []*segment // Oldest first; read from the first, appended to the last
+	active   *os.File
+	reader   *os.File // Of the first segment, opened when needed
+	readOff  int64
+	head     *Entry
+	headSize int64
+	dropped  int64 // Over MaxBytes or unreadable
+	expired  int64 // Over MaxAge
+	acked    int   // Since the read position was saved
+	closed   bool
+}
+
+// segment is one file of the queue
+type segment struct {
+	id      uint64
+	size    int64
+	records int64
+	read    int64     // Records before the read position
+	newest  time.Time // Of the last record
+}
+
+// OpenDisk opens the queue in dir, creating it when needed. A record torn
+// by a crash is cut off
+func OpenDisk(dir string, opts DiskOptions) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	q := &Disk{dir: dir, opts: opts}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	cursorSeg, cursorOff := q.loadCursor()
+	for _, name := range names {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".seg"), 10, 64)
+		if err != nil {
+			continue
+		}
+		if id < cursorSeg {
+			// Read before the last save of the position
+			os.Remove(name)
+			continue
+		}
+		from := int64(0)
+		if id == cursorSeg {
+			from = cursorOff
+		}
+		s, err := scan(name, id, from)
+		if err != nil {
+			return nil, err
+		}
+		if id == cursorSeg {
+			q.readOff = min(from, s.size)
+		}
+		q.segments = append(q.segments, s)
+	}
+	if len(q.segments) == 0 {
+		q.segments = []*segment{{id: 1}}
+	}
+	last := q.segments[len(q.segments)-1]
+	if q.active, err = os.OpenFile(q.path(last.id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// scan counts the records of a segment file and those before from, and cuts
+// off a torn or corrupt tail. A record running past the end of the file can
+// only come from a corrupt header and is treated as the tail; the segment
+// size setting is not a bound, since it may have been lowered since
+func scan(path string, id uint64, from int64) (*segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &segment{id: id}
+	r := bufio.NewReader(f)
+	var header [headerSize]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			break
+		}
+		n := int64(binary.BigEndian.Uint32(header[:4]))
+		if s.size+headerSize+n > info.Size() {
+			break
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil || crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:]) {
+			break
+		}
+		msg, err := decode(body)
+		if err != nil {
+			break
+		}
+		if s.size < from {
+			s.read++
+		}
+		s.size += headerSize + int64(len(body))
+		s.records++
+		s.newest = msg.Time
+	}
+	if info.Size() > s.size {
+		if err := os.Truncate(path, s.size); err != nil {
+			return nil, fmt.Errorf("truncate %s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// path returns the file of a segment
+func (q *Disk) path(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%016d.seg", id))
+}
+
+// Append writes a message to the last segment, starting a new one when it
+// is full, and drops the oldest segments over the retention limits. A
+// message that does not fit into a segment of its own is refused
+func (q *Disk) Append(msg output.Message) error {
+	body := encode(msg)
+	if headerSize+int64(len(body)) > q.opts.SegmentBytes {
+		return ErrTooLarge
+	}
+	rec := make([]byte, headerSize, headerSize+len(body))
+	binary.BigEndian.PutUint32(rec[:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(rec[4:], crc32.ChecksumIEEE(body))
+	rec = append(rec, body...)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	last := q.segments[len(q.segments)-1]
+	if last.size > 0 && last.size+int64(len(rec)) > q.opts.SegmentBytes {
+		if err := q.roll(); err != nil {
+			return err
+		}
+		last = q.segments[len(q.segments)-1]
+	}
+	if _, err := q.active.Write(rec); err != nil {
+		return err
+	}
+	last.size += int64(len(rec))
+	last.records++
+	last.newest = msg.Time
+	q.enforce(time.Now())
+	return nil
+}
+
+// roll starts a new segment
+func (q *Disk) roll() error {
+	last := q.segments[len(q.segments)-1]
+	next := &segment{id: last.id + 1}
+	f, err := os.OpenFile(q.path(next.id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	q.active.Close()
+	q.active = f
+	q.segments = append(q.segments, next)
+	return nil
+}
+
+// enforce drops the oldest segments while the queue is over its size or
+// their newest record is over its age. The segment appended to stays
+func (q *Disk) enforce(now time.Time) {
+	for len(q.segments) > 1 {
+		var total int64
+		for _, s := range q.segments {
+			total += s.size
+		}
+		first := q.segments[0]
+		tooOld := q.opts.MaxAge > 0 && now.Sub(first.newest) > q.opts.MaxAge
+		if total <= q.opts.MaxBytes && !tooOld {
+			return
+		}
+		if tooOld {
+			q.expired += first.records - first.read
+		} else {
+			q.dropped += first.records - first.read
+		}
+		q.removeFirst()
+	}
+}
+
+// removeFirst deletes the first segment and moves the read position to the
+// next one
+func (q *Disk) removeFirst() {
+	if q.reader != nil {
+		q.reader.Close()
+		q.reader = nil
+	}
+	os.Remove(q.path(q.segments[0].id))
+	q.segments = q.segments[1:]
+	q.readOff, q.head = 0, nil
+	q.saveCursor()
+}
+
+// Next returns the oldest message not yet acknowledged. Messages over the
+// age limit are skipped and counted as expired
+func (q *Disk) Next() (Entry, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.next(time.Now())
+}
+
+// next reads the head. Called with the lock held
+func (q *Disk) next(now time.Time) (Entry, bool, error) {
+	if q.closed {
+		return Entry{}, false, ErrClosed
+	}
+	for q.head == nil {
+		first := q.segments[0]
+		if q.readOff >= first.size {
+			if len(q.segments) == 1 {
+				return Entry{}, false, nil
+			}
+			q.removeFirst()
+			continue
+		}
+		if q.reader == nil {
+			f, err := os.Open(q.path(first.id))
+			if err != nil {
+				return Entry{}, false, err
+			}
+			q.reader = f
+		}
+		msg, n, err := readAt(q.reader, q.readOff, first.size)
+		if err != nil {
+			// The rest of the segment is unreadable
+			q.dropped += first.records - first.read
+			first.read = first.records
+			q.readOff = first.size
+			continue
+		}
+		if q.opts.MaxAge > 0 && now.Sub(msg.Time) > q.opts.MaxAge {
+			q.expired++
+			first.read++
+			q.readOff += n
+			continue
+		}
+		q.head = &Entry{Message: msg, pos: position{segment: first.id, offset: q.readOff}}
+		q.headSize = n
+	}
+	return *q.head, true, nil
+}
+
+// readAt reads the record at off and returns it with its size on disk.
+// Records running past end, the size of the segment, are refused as corrupt
+func readAt(f *os.File, off, end int64) (output.Message, int64, error) {
+	var header [headerSize]byte
+	if _, err := f.ReadAt(header[:], off); err != nil {
+		return output.Message{}, 0, err
+	}
+	n := int64(binary.BigEndian.Uint32(header[:4]))
+	if off+headerSize+n > end {
+		return output.Message{}, 0, errors.New("record length exceeds the segment size")
+	}
+	body := make([]byte, n)
+	if _, err := f.ReadAt(body, off+headerSize); err != nil {
+		return output.Message{}, 0, err
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:]) {
+		return output.Message{}, 0, errors.New("record checksum mismatch")
+	}
+	msg, err := decode(body)
+	return msg, headerSize + int64(len(body)), err
+}
+
+// Ack moves past the head when e still is the head. A fully read queue
+// starts its segment over
+func (q *Disk) Ack(e Entry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	if q.head == nil || q.head.pos != e.pos {
+		return nil
+	}
+	first := q.segments[0]
+	first.read++
+	q.readOff += q.headSize
+	q.head = nil
+
+	if q.readOff >= first.size {
+		if len(q.segments) > 1 {
+			q.removeFirst()
+			return nil
+		}
+		// Nothing left: truncate rather than keep read records on disk
+		if err := q.active.Truncate(0); err != nil {
+			return err
+		}
+		if q.reader != nil {
+			q.reader.Close()
+			q.reader = nil
+		}
+		*first = segment{id: first.id}
+		q.readOff = 0
+		q.saveCursor()
+		return nil
+	}
+	if q.acked++; q.acked >= cursorEvery {
+		q.saveCursor()
+	}
+	return nil
+}
+
+// Len returns the number of messages not yet acknowledged, including
+// expired ones not yet skipped
+func (q *Disk) Len() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var n int64
+	for _, seg := range q.segments {
+		n += seg.records - seg.read
+	}
+	return n
+}
+
+// Stats reports what the queue holds
+func (q *Disk) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var s Stats
+	if e, ok, err := q.next(time.Now()); err == nil && ok {
+		s.Oldest = e.Time
+	}
+	s.Segments, s.Dropped, s.Expired = len(q.segments), q.dropped, q.expired
+	for _, seg := range q.segments {
+		s.Records += seg.records - seg.read
+		s.Bytes += seg.size
+	}
+	return s
+}
+
+// loadCursor reads the saved read position
+func (q *Disk) loadCursor() (uint64, int64) {
+	data, err := os.ReadFile(filepath.Join(q.dir, "cursor"))
+	if err != nil {
+		return 0, 0
+	}
+	var seg uint64
+	var off int64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &seg, &off); err != nil {
+		return 0, 0
+	}
+	return seg, off
+}
+
+// saveCursor writes the read position. A failure only means messages are
+// sent again after a restart
+func (q *Disk) saveCursor() {
+	q.acked = 0
+	path := filepath.Join(q.dir, "cursor")
+	data := fmt.Sprintf("%d %d\n", q.segments[0].id, q.readOff)
+	if err := os.WriteFile(path+".tmp", []byte(data), 0o600); err == nil {
+		os.Rename(path+".tmp", path)
+	}
+}
+
+// Close saves the read position and closes the files
+func (q *Disk) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.saveCursor()
+	if q.reader != nil {
+		q.reader.Close()
+	}
+	return q.active.Close()
+}
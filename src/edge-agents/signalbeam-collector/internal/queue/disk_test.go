@@ -0,0 +1,326 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/output"
+)
+
+// testOptions make segments of a few records each
+var testOptions = DiskOptions{SegmentBytes: 512, MaxBytes: 1 << 20}
+
+func testMessage(i int) output.Message {
+	return output.Message{
+		Type:     "metrics",
+		DeviceID: "dev-1",
+		Topic:    fmt.Sprintf("signalbeam/dev-1/metrics/%d", i),
+		Payload:  []byte(fmt.Sprintf(`{"seq":%d}`, i)),
+		QoS:      byte(i % 3),
+		Retained: i%2 == 1,
+		Time:     time.Unix(1700000000, int64(i)*1000),
+	}
+}
+
+func openDisk(t *testing.T, dir string) *Disk {
+	t.Helper()
+	q, err := OpenDisk(dir, testOptions)
+	if err != nil {
+		t.Fatalf("OpenDisk: %v", err)
+	}
+	return q
+}
+
+func appendN(t *testing.T, q Queue, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := q.Append(testMessage(i)); err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+	}
+}
+
+// drain takes up to n messages off q, acknowledging each
+func drain(t *testing.T, q Queue, n int) []output.Message {
+	t.Helper()
+	var msgs []output.Message
+	for len(msgs) < n {
+		e, ok, err := q.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if !ok {
+			break
+		}
+		msgs = append(msgs, e.Message)
+		if err := q.Ack(e); err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+	}
+	return msgs
+}
+
+// expectSeq checks that msgs are the test messages from, from+1, ...
+func expectSeq(t *testing.T, msgs []output.Message, from, to int) {
+	t.Helper()
+	if len(msgs) != to-from {
+		t.Fatalf("got %d messages, want %d", len(msgs), to-from)
+	}
+	for i, msg := range msgs {
+		want := testMessage(from + i)
+		if msg.Topic != want.Topic || !bytes.Equal(msg.Payload, want.Payload) ||
+			msg.Type != want.Type || msg.DeviceID != want.DeviceID ||
+			msg.QoS != want.QoS || msg.Retained != want.Retained || !msg.Time.Equal(want.Time) {
+			t.Fatalf("message %d = %+v, want %+v", i, msg, want)
+		}
+	}
+}
+
+// snapshot copies the queue files as a crash would leave them, without the
+// queue saving its position on Close
+func snapshot(t *testing.T, dir string) string {
+	t.Helper()
+	dst := t.TempDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, e.Name()), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dst
+}
+
+func lastSegment(t *testing.T, dir string) string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil || len(names) == 0 {
+		t.Fatalf("no segments in %s: %v", dir, err)
+	}
+	return names[len(names)-1]
+}
+
+func TestDiskReopen(t *testing.T) {
+	dir := t.TempDir()
+	q := openDisk(t, dir)
+	appendN(t, q, 0, 40)
+	if s := q.Stats(); s.Segments < 3 {
+		t.Fatalf("40 messages fill %d segments, want several", s.Segments)
+	}
+	expectSeq(t, drain(t, q, 15), 0, 15)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	q = openDisk(t, dir)
+	defer q.Close()
+	if n := q.Len(); n != 25 {
+		t.Fatalf("Len after reopen = %d, want 25", n)
+	}
+	appendN(t, q, 40, 45)
+	expectSeq(t, drain(t, q, 100), 15, 45)
+	if n := q.Len(); n != 0 {
+		t.Fatalf("Len after draining = %d", n)
+	}
+}
+
+func TestDiskUnackedHeadStays(t *testing.T) {
+	q := openDisk(t, t.TempDir())
+	defer q.Close()
+	appendN(t, q, 0, 3)
+	for i := 0; i < 3; i++ {
+		e, ok, err := q.Next()
+		if err != nil || !ok || e.Topic != testMessage(0).Topic {
+			t.Fatalf("Next = %v, %v, %v; want the first message again", e.Topic, ok, err)
+		}
+	}
+}
+
+func TestDiskTornTail(t *testing.T) {
+	dir := t.TempDir()
+	q := openDisk(t, dir)
+	appendN(t, q, 0, 3)
+	q.Close()
+
+	// A crash in the middle of an append: a header promising more than
+	// follows
+	seg := lastSegment(t, dir)
+	before, _ := os.Stat(seg)
+	f, err := os.OpenFile(seg, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header [headerSize]byte
+	binary.BigEndian.PutUint32(header[:4], 100)
+	f.Write(append(header[:], "partial"...))
+	f.Close()
+
+	q = openDisk(t, dir)
+	defer q.Close()
+	if after, _ := os.Stat(seg); after.Size() != before.Size() {
+		t.Fatalf("torn tail not cut: %d bytes, want %d", after.Size(), before.Size())
+	}
+	appendN(t, q, 3, 5)
+	expectSeq(t, drain(t, q, 100), 0, 5)
+}
+
+func TestDiskCorruptTail(t *testing.T) {
+	dir := t.TempDir()
+	q := openDisk(t, dir)
+	appendN(t, q, 0, 3)
+	q.Close()
+
+	// A flipped byte in the last record fails its checksum
+	seg := lastSegment(t, dir)
+	data, err := os.ReadFile(seg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-2] ^= 0xff
+	if err := os.WriteFile(seg, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	q = openDisk(t, dir)
+	defer q.Close()
+	if n := q.Len(); n != 2 {
+		t.Fatalf("Len = %d, want the 2 intact records", n)
+	}
+	appendN(t, q, 3, 4)
+	msgs := drain(t, q, 100)
+	expectSeq(t, msgs[:2], 0, 2)
+	expectSeq(t, msgs[2:], 3, 4)
+}
+
+func TestDiskCursorRecovery(t *testing.T) {
+	// One segment, so only the cursor saves the position; removing a read
+	// segment saves it as well
+	opts := DiskOptions{SegmentBytes: 1 << 20, MaxBytes: 1 << 20}
+	dir := t.TempDir()
+	q, err := OpenDisk(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	appendN(t, q, 0, 300)
+
+	reopen := func() *Disk {
+		t.Helper()
+		crashed, err := OpenDisk(snapshot(t, dir), opts)
+		if err != nil {
+			t.Fatalf("OpenDisk after crash: %v", err)
+		}
+		return crashed
+	}
+
+	// Fewer acknowledgements than cursorEvery are not saved yet: a crash
+	// sends them again
+	drain(t, q, cursorEvery-1)
+	crashed := reopen()
+	if n := crashed.Len(); n != 300 {
+		t.Fatalf("Len after crash = %d, want 300", n)
+	}
+	expectSeq(t, drain(t, crashed, 5), 0, 5)
+	crashed.Close()
+
+	// Every cursorEvery acknowledgements the position is saved
+	drain(t, q, cursorEvery+20)
+	crashed = reopen()
+	if n := crashed.Len(); n != 300-2*cursorEvery {
+		t.Fatalf("Len after crash = %d, want %d", n, 300-2*cursorEvery)
+	}
+	expectSeq(t, drain(t, crashed, 1000), 2*cursorEvery, 300)
+	crashed.Close()
+
+	// Close saves the exact position
+	q.Close()
+	q, err = OpenDisk(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSeq(t, drain(t, q, 1000), 2*cursorEvery+19, 300)
+}
+
+func TestDiskSegmentBytesLowered(t *testing.T) {
+	// Records written under a larger setting stay readable after it is
+	// lowered
+	dir := t.TempDir()
+	q, err := OpenDisk(dir, DiskOptions{SegmentBytes: 1 << 20, MaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := testMessage(0)
+	big.Payload = bytes.Repeat([]byte("x"), 2*int(testOptions.SegmentBytes))
+	if err := q.Append(big); err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, q, 1, 3)
+	q.Close()
+
+	q = openDisk(t, dir)
+	defer q.Close()
+	if n := q.Len(); n != 3 {
+		t.Fatalf("Len after lowering segment_bytes = %d, want 3", n)
+	}
+	msgs := drain(t, q, 100)
+	if len(msgs) != 3 || !bytes.Equal(msgs[0].Payload, big.Payload) {
+		t.Fatalf("got %d messages, want the large record first and 2 more", len(msgs))
+	}
+	expectSeq(t, msgs[1:], 1, 3)
+}
+
+func TestDiskBadCursor(t *testing.T) {
+	cases := map[string]string{
+		"garbage":       "not a cursor",
+		"offset beyond": "1 999999\n",
+		"segment ahead": "99 0\n",
+	}
+	for name, cursor := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			q := openDisk(t, dir)
+			appendN(t, q, 0, 3)
+			q.Close()
+			if err := os.WriteFile(filepath.Join(dir, "cursor"), []byte(cursor), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			q, err := OpenDisk(dir, testOptions)
+			if err != nil {
+				t.Fatalf("OpenDisk with a bad cursor: %v", err)
+			}
+			defer q.Close()
+			// Whatever the cursor says, the queue stays usable and in order
+			appendN(t, q, 3, 5)
+			msgs := drain(t, q, 100)
+			if len(msgs) == 0 {
+				t.Fatal("queue is stuck")
+			}
+			last := msgs[len(msgs)-1]
+			if last.Topic != testMessage(4).Topic {
+				t.Fatalf("last message %s, want %s", last.Topic, testMessage(4).Topic)
+			}
+		})
+	}
+}
+
+func TestDiskRefusesOversizedMessage(t *testing.T) {
+	q := openDisk(t, t.TempDir())
+	defer q.Close()
+	msg := testMessage(0)
+	msg.Payload = make([]byte, testOptions.SegmentBytes)
+	if err := q.Append(msg); err != ErrTooLarge {
+		t.Fatalf("Append = %v, want ErrTooLarge", err)
+	}
+}
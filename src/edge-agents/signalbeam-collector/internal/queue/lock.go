@@ -0,0 +1,34 @@
+package queue
+
+import "os"
+
+// Lock opens a queue with open while holding an exclusive lock on the file
+// at path, so that two processes never work on the same queue. The lock
+// is released when the queue is closed; ErrLocked means another process
+// holds it
+func Lock(path string, open func() (Queue, error)) (Queue, error) {
+	f, err := lockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	q, err := open()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &locked{Queue: q, lock: f}, nil
+}
+
+// locked is a queue that holds a lock file until closed
+type locked struct {
+	Queue
+	lock *os.File
+}
+
+func (l *locked) Close() error {
+	err := l.Queue.Close()
+	if lockErr := l.lock.Close(); err == nil {
+		err = lockErr
+	}
+	return err
+}
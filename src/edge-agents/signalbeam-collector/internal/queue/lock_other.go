@@ -0,0 +1,11 @@
+//go:build !unix
+
+package queue
+
+import "os"
+
+// lockFile opens the lock file at path; without flock another process is
+// not kept out
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+}
@@ -0,0 +1,204 @@
+package queue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/output"
+)
+
+// Overflow selects what a full memory queue does with another message
+type Overflow string
+
+const (
+	// DropOldest makes room by dropping the head
+	DropOldest Overflow = "drop_oldest"
+	// DropNewest refuses the message
+	DropNewest Overflow = "drop_newest"
+	// Block waits for room up to BlockTimeout, then refuses the message
+	Block Overflow = "block"
+)
+
+// MemoryOptions bounds a memory queue
+type MemoryOptions struct {
+	MaxRecords   int
+	MaxBytes     int64
+	MaxAge       time.Duration // Older messages are dropped; 0 keeps them
+	Overflow     Overflow
+	BlockTimeout time.Duration
+	// Dropped is told about each message dropped to make room, when set
+	Dropped func(msg output.Message)
+}
+
+// Memory is a bounded queue for devices without writable storage. What it
+// holds is lost when the collector stops
+type Memory struct {
+	mu      sync.Mutex
+	opts    MemoryOptions
+	items   []memoryItem
+	bytes   int64
+	seq     int64
+	room    chan struct{} // Closed and replaced whenever room is made
+	dropped int64
+	expired int64
+	blocked int64
+	closed  bool
+}
+
+// memoryItem is a message and its sequence number
+type memoryItem struct {
+	msg output.Message
+	seq int64
+}
+
+// NewMemory creates an empty memory queue
+func NewMemory(opts MemoryOptions) *Memory {
+	return &Memory{opts: opts, room: make(chan struct{})}
+}
+
+// Append adds a message, applying the overflow policy when the queue is
+// full. A message larger than the whole queue is refused
+func (q *Memory) Append(msg output.Message) error {
+	size := int64(len(msg.Payload))
+	if size > q.opts.MaxBytes {
+		q.mu.Lock()
+		q.dropped++
+		q.mu.Unlock()
+		return ErrFull
+	}
+
+	var deadline <-chan time.Time
+	q.mu.Lock()
+	for {
+		if q.closed {
+			q.mu.Unlock()
+			return ErrClosed
+		}
+		if !q.full(size) {
+			break
+		}
+		switch q.opts.Overflow {
+		case DropNewest:
+			q.dropped++
+			q.mu.Unlock()
+			return ErrFull
+		case Block:
+			if deadline == nil {
+				q.blocked++
+				timer := time.NewTimer(q.opts.BlockTimeout)
+				defer timer.Stop()
+				deadline = timer.C
+			}
+			room := q.room
+			q.mu.Unlock()
+			select {
+			case <-room:
+			case <-deadline:
+				q.mu.Lock()
+				q.dropped++
+				q.mu.Unlock()
+				return ErrFull
+			}
+			q.mu.Lock()
+			continue
+		}
+		// DropOldest
+		head := q.items[0].msg
+		q.remove()
+		q.dropped++
+		if q.opts.Dropped != nil {
+			q.mu.Unlock()
+			q.opts.Dropped(head)
+			q.mu.Lock()
+		}
+	}
+	q.seq++
+	q.items = append(q.items, memoryItem{msg: msg, seq: q.seq})
+	q.bytes += size
+	q.mu.Unlock()
+	return nil
+}
+
+// full reports whether a message of size does not fit. Called with the
+// lock held
+func (q *Memory) full(size int64) bool {
+	return len(q.items) > 0 && (len(q.items) >= q.opts.MaxRecords || q.bytes+size > q.opts.MaxBytes)
+}
+
+// remove drops the head and wakes blocked appends. Called with the lock
+// held
+func (q *Memory) remove() {
+	q.bytes -= int64(len(q.items[0].msg.Payload))
+	q.items[0] = memoryItem{}
+	q.items = q.items[1:]
+	close(q.room)
+	q.room = make(chan struct{})
+}
+
+// Next returns the oldest message, skipping those over the age limit
+func (q *Memory) Next() (Entry, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return Entry{}, false, ErrClosed
+	}
+	now := time.Now()
+	for len(q.items) > 0 && q.opts.MaxAge > 0 && now.Sub(q.items[0].msg.Time) > q.opts.MaxAge {
+		q.remove()
+		q.expired++
+	}
+	if len(q.items) == 0 {
+		return Entry{}, false, nil
+	}
+	head := q.items[0]
+	return Entry{Message: head.msg, pos: position{offset: head.seq}}, true, nil
+}
+
+// Ack removes the head when e still is the head
+func (q *Memory) Ack(e Entry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	if len(q.items) > 0 && q.items[0].seq == e.pos.offset {
+		q.remove()
+	}
+	return nil
+}
+
+// Len returns the number of messages held
+func (q *Memory) Len() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return int64(len(q.items))
+}
+
+// Stats reports what the queue holds
+func (q *Memory) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := Stats{
+		Records: int64(len(q.items)),
+		Bytes:   q.bytes,
+		Dropped: q.dropped,
+		Expired: q.expired,
+		Blocked: q.blocked,
+	}
+	if len(q.items) > 0 {
+		s.Oldest = q.items[0].msg.Time
+	}
+	return s
+}
+
+// Close drops what the queue holds and releases blocked appends
+func (q *Memory) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.items, q.bytes = nil, 0
+		close(q.room)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Package queue holds published messages while the broker is unreachable
+// and hands them back in order once it is reachable again
+package queue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/output"
+)
+
+// Errors of a queue
+var (
+	ErrClosed   = errors.New("queue closed")
+	ErrFull     = errors.New("queue full")
+	ErrTooLarge = errors.New("message larger than a segment")
+	ErrLocked   = errors.New("queue in use by another process")
+)
+
+// Queue is a first-in first-out queue of messages. A message stays at the
+// head until acknowledged, so one that fails to send is handed out again
+type Queue interface {
+	Append(msg output.Message) error
+	// Next returns the head of the queue, false when it is empty
+	Next() (Entry, bool, error)
+	// Ack removes the entry, unless retention dropped it meanwhile
+	Ack(e Entry) error
+	// Len returns the number of messages held
+	Len() int64
+	Stats() Stats
+	Close() error
+}
+
+// Entry is a message at the head of a queue
+type Entry struct {
+	output.Message
+	pos position
+}
+
+// position identifies an entry: its segment and offset on disk, or its
+// sequence number in memory
+type position struct {
+	segment uint64
+	offset  int64
+}
+
+// Stats describes what a queue holds
+type Stats struct {
+	Records  int64
+	Bytes    int64 // Held; on disk this includes read records of partly read segments
+	Segments int
+	History  int64     // Sent records kept for inspection
+	Dropped  int64     // To make room, or unreadable
+	Expired  int64     // Over the age limit
+	Blocked  int64     // Appends that waited for room
+	Oldest   time.Time // Of the head; zero when empty
+}
+
+// Map reports the stats for the heartbeat
+func (s Stats) Map() map[string]interface{} {
+	m := map[string]interface{}{
+		"records": s.Records,
+		"bytes":   s.Bytes,
+		"dropped": s.Dropped,
+		"expired": s.Expired,
+		"blocked": s.Blocked,
+	}
+	if s.Segments > 0 {
+		m["segments"] = s.Segments
+	}
+	if s.History > 0 {
+		m["history"] = s.History
+	}
+	if !s.Oldest.IsZero() {
+		m["oldest"] = s.Oldest.UTC().Format(time.RFC3339)
+	}
+	return m
+}
+
+// encode serializes a message: the time, QoS and retained flag, then the
+// type, device, topic and payload prefixed with their lengths
+func encode(msg output.Message) []byte {
+	size := 10 + len(msg.Type) + len(msg.DeviceID) + len(msg.Topic) + len(msg.Payload) + 4*binary.MaxVarintLen32
+	b := make([]byte, 0, size)
+	b = binary.BigEndian.AppendUint64(b, uint64(msg.Time.UnixNano()))
+	retained := byte(0)
+	if msg.Retained {
+		retained = 1
+	}
+	b = append(b, msg.QoS, retained)
+	for _, field := range [][]byte{[]byte(msg.Type), []byte(msg.DeviceID), []byte(msg.Topic), msg.Payload} {
+		b = binary.AppendUvarint(b, uint64(len(field)))
+		b = append(b, field...)
+	}
+	return b
+}
+
+// decode parses a message serialized by encode
+func decode(b []byte) (output.Message, error) {
+	if len(b) < 10 {
+		return output.Message{}, errors.New("record too short")
+	}
+	msg := output.Message{
+		Time:     time.Unix(0, int64(binary.BigEndian.Uint64(b))),
+		QoS:      b[8],
+		Retained: b[9] == 1,
+	}
+	b = b[10:]
+	var fields [4][]byte
+	for i := range fields {
+		n, k := binary.Uvarint(b)
+		if k <= 0 || uint64(len(b)-k) < n {
+			return output.Message{}, fmt.Errorf("record field %d truncated", i)
+		}
+		fields[i] = b[k : k+int(n)]
+		b = b[k+int(n):]
+	}
+	msg.Type, msg.DeviceID, msg.Topic = string(fields[0]), string(fields[1]), string(fields[2])
+	msg.Payload = append([]byte(nil), fields[3]...)
+	return msg, nil
+}
@@ -0,0 +1,351 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/output"
+	_ "modernc.org/sqlite" // Pure Go, so cross-compiled builds need no C toolchain
+)
+
+// enforceEvery is how many appends pass between age checks of a SQLite
+// queue; the size is checked on every append
+const enforceEvery = 100
+
+// expireEvery is how often Next checks the age limit
+const expireEvery = time.Minute
+
+// sqliteSchema creates the table of a SQLite queue. Sent messages keep
+// their row, with sent set, until retention removes them
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS records (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	time      INTEGER NOT NULL,
+	type      TEXT    NOT NULL,
+	device_id TEXT    NOT NULL,
+	topic     TEXT    NOT NULL,
+	qos       INTEGER NOT NULL,
+	retained  INTEGER NOT NULL,
+	payload   BLOB    NOT NULL,
+	size      INTEGER NOT NULL,
+	sent      INTEGER
+);
+CREATE INDEX IF NOT EXISTS records_pending ON records (id) WHERE sent IS NULL;
+CREATE INDEX IF NOT EXISTS records_time ON records (time);
+`
+
+// SQLiteOptions sets the retention of a SQLite queue
+type SQLiteOptions struct {
+	MaxBytes int64         // Oldest records are dropped above this, sent ones first
+	MaxAge   time.Duration // Older records are dropped; 0 keeps them
+	Vacuum   bool          // Compact the database when it is opened
+}
+
+// SQLite is a queue in a SQLite database. Unlike the other queues it keeps
+// sent messages as history within its retention, so what the device
+// collected can be inspected with Query or the sqlite3 shell
+type SQLite struct {
+	mu      sync.Mutex
+	db      *sql.DB
+	opts    SQLiteOptions
+	pending int64
+	sent    int64
+	bytes   int64
+	appends int
+	checked time.Time // Of the age limit by Next
+	dropped int64
+	expired int64
+	closed  bool
+}
+
+// OpenSQLite opens the database at path, creating it when needed, applies
+// the retention and compacts it when asked to
+func OpenSQLite(path string, opts SQLiteOptions) (*SQLite, error) {
+	db, err := openDB(path, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	q := &SQLite{db: db, opts: opts}
+	err = db.QueryRow(`SELECT COUNT(*) - COUNT(sent), COUNT(sent), COALESCE(SUM(size), 0) FROM records`).Scan(&q.pending, &q.sent, &q.bytes)
+	if err == nil {
+		err = q.enforce(time.Now())
+	}
+	if err == nil && opts.Vacuum {
+		_, err = db.Exec(`VACUUM`)
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return q, nil
+}
+
+// openDB opens the database with a single connection, which serializes
+// access, in WAL mode so readers such as Query do not block the collector
+func openDB(path string, readOnly bool) (*sql.DB, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?_pragma=busy_timeout(5000)"
+	if readOnly {
+		dsn += "&mode=ro"
+	} else {
+		dsn += "&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Append inserts a message and drops the oldest records over the retention
+// limits
+func (q *SQLite) Append(msg output.Message) error {
+	size := int64(len(msg.Type) + len(msg.DeviceID) + len(msg.Topic) + len(msg.Payload))
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	_, err := q.db.Exec(`INSERT INTO records (time, type, device_id, topic, qos, retained, payload, size) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		msg.Time.UnixNano(), msg.Type, msg.DeviceID, msg.Topic, msg.QoS, msg.Retained, msg.Payload, size)
+	if err != nil {
+		return err
+	}
+	q.pending++
+	q.bytes += size
+	if q.appends++; q.appends >= enforceEvery || q.bytes > q.opts.MaxBytes {
+		q.appends = 0
+		return q.enforce(time.Now())
+	}
+	return nil
+}
+
+// enforce deletes records over the age limit, then the oldest records while
+// the queue is over its size: sent ones before those still pending. Called
+// with the lock held
+func (q *SQLite) enforce(now time.Time) error {
+	if q.opts.MaxAge > 0 {
+		cutoff := now.Add(-q.opts.MaxAge).UnixNano()
+		var pending, sent, bytes int64
+		err := q.db.QueryRow(`SELECT COUNT(*) - COUNT(sent), COUNT(sent), COALESCE(SUM(size), 0) FROM records WHERE time < ?`, cutoff).Scan(&pending, &sent, &bytes)
+		if err != nil {
+			return err
+		}
+		if pending+sent > 0 {
+			if _, err := q.db.Exec(`DELETE FROM records WHERE time < ?`, cutoff); err != nil {
+				return err
+			}
+			q.pending -= pending
+			q.sent -= sent
+			q.bytes -= bytes
+			q.expired += pending
+		}
+	}
+
+	for q.bytes > q.opts.MaxBytes && q.pending+q.sent > 0 {
+		// History first, then the oldest pending records
+		where := `sent IS NOT NULL`
+		if q.sent == 0 {
+			where = `sent IS NULL`
+		}
+		last, n, bytes, err := q.oldest(where, q.bytes-q.opts.MaxBytes)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		if _, err := q.db.Exec(`DELETE FROM records WHERE `+where+` AND id <= ?`, last); err != nil {
+			return err
+		}
+		q.bytes -= bytes
+		if q.sent > 0 {
+			q.sent -= n
+		} else {
+			q.pending -= n
+			q.dropped += n
+		}
+	}
+	return nil
+}
+
+// oldest returns the last of the oldest records matching where that
+// together hold at least excess bytes, their number and size. Called with
+// the lock held
+func (q *SQLite) oldest(where string, excess int64) (last, n, bytes int64, err error) {
+	rows, err := q.db.Query(`SELECT id, size FROM records WHERE ` + where + ` ORDER BY id`)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer rows.Close()
+	for bytes < excess && rows.Next() {
+		var size int64
+		if err := rows.Scan(&last, &size); err != nil {
+			return 0, 0, 0, err
+		}
+		n++
+		bytes += size
+	}
+	return last, n, bytes, rows.Err()
+}
+
+// Next returns the oldest message not yet sent
+func (q *SQLite) Next() (Entry, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return Entry{}, false, ErrClosed
+	}
+	if now := time.Now(); q.opts.MaxAge > 0 && now.Sub(q.checked) >= expireEvery {
+		q.checked = now
+		if err := q.enforce(now); err != nil {
+			return Entry{}, false, err
+		}
+	}
+	var id int64
+	var msg output.Message
+	var at int64
+	err := q.db.QueryRow(`SELECT id, time, type, device_id, topic, qos, retained, payload FROM records WHERE sent IS NULL ORDER BY id LIMIT 1`).
+		Scan(&id, &at, &msg.Type, &msg.DeviceID, &msg.Topic, &msg.QoS, &msg.Retained, &msg.Payload)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, err
+	}
+	msg.Time = time.Unix(0, at)
+	return Entry{Message: msg, pos: position{offset: id}}, true, nil
+}
+
+// Ack marks the message sent, keeping it as history
+func (q *SQLite) Ack(e Entry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	res, err := q.db.Exec(`UPDATE records SET sent = ? WHERE id = ? AND sent IS NULL`, time.Now().UnixNano(), e.pos.offset)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		q.pending--
+		q.sent++
+	}
+	return nil
+}
+
+// Len returns the number of messages not yet sent
+func (q *SQLite) Len() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending
+}
+
+// Stats reports what the queue holds
+func (q *SQLite) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := Stats{Records: q.pending, Bytes: q.bytes, History: q.sent, Dropped: q.dropped, Expired: q.expired}
+	if !q.closed && q.pending > 0 {
+		var at int64
+		if err := q.db.QueryRow(`SELECT time FROM records WHERE sent IS NULL ORDER BY id LIMIT 1`).Scan(&at); err == nil {
+			s.Oldest = time.Unix(0, at)
+		}
+	}
+	return s
+}
+
+// Close closes the database
+func (q *SQLite) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	return q.db.Close()
+}
+
+// Record is a message in a SQLite queue, with when it was sent
+type Record struct {
+	ID int64
+	output.Message
+	Sent time.Time // Zero while pending
+}
+
+// Filter selects records for Query; zero fields match everything
+type Filter struct {
+	Type    string
+	Since   time.Time
+	Until   time.Time
+	Pending bool // Only records not yet sent
+	Limit   int
+}
+
+// Query reads records of the database at path, oldest first. The database
+// is opened read-only, so it can be inspected while the collector runs
+func Query(path string, f Filter) ([]Record, error) {
+	db, err := openDB(path, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var where []string
+	var args []interface{}
+	if f.Type != "" {
+		where, args = append(where, "type = ?"), append(args, f.Type)
+	}
+	if !f.Since.IsZero() {
+		where, args = append(where, "time >= ?"), append(args, f.Since.UnixNano())
+	}
+	if !f.Until.IsZero() {
+		where, args = append(where, "time < ?"), append(args, f.Until.UnixNano())
+	}
+	if f.Pending {
+		where = append(where, "sent IS NULL")
+	}
+	query := `SELECT id, time, type, device_id, topic, qos, retained, payload, sent FROM records`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer rows.Close()
+	var out []Record
+	for rows.Next() {
+		var r Record
+		var at int64
+		var sent sql.NullInt64
+		if err := rows.Scan(&r.ID, &at, &r.Type, &r.DeviceID, &r.Topic, &r.QoS, &r.Retained, &r.Payload, &sent); err != nil {
+			return nil, err
+		}
+		r.Time = time.Unix(0, at)
+		if sent.Valid {
+			r.Sent = time.Unix(0, sent.Int64)
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
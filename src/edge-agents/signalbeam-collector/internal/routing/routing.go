@@ -0,0 +1,141 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/expr"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/units"
+)
+
+// Rule directs matching records to a specific topic and delivery settings.
+// All configured match conditions must hold for the rule to apply.
+type Rule struct {
+	Name string
+
+	// Match conditions
+	Type   string
+	Tags   map[string]string
+	Fields map[string]string // Dotted data path -> expected value
+	// When must hold over the numeric data values, by dotted path
+	When *expr.Expr
+
+	// Route settings; nil QoS/Retained keep the defaults
+	Topic    string
+	QoS      *byte
+	Retained *bool
+	Outputs  []string // Outputs the record goes to instead of those of its type
+}
+
+// Route is the delivery decision for a record
+type Route struct {
+	Rule     string
+	Topic    string
+	QoS      byte
+	Retained bool
+	Outputs  []string // nil for the outputs of the data type
+}
+
+// Router evaluates rules in order; the first match wins
+type Router struct {
+	rules []Rule
+}
+
+// New creates a router from rules
+func New(rules []Rule) *Router {
+	return &Router{rules: rules}
+}
+
+// Match returns the first rule matching the record, or false
+func (r *Router) Match(dataType string, tags map[string]string, data map[string]interface{}) (Rule, bool) {
+	for _, rule := range r.rules {
+		if rule.matches(dataType, tags, data) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Apply overrides a default route with the settings of a matched rule
+func (rule Rule) Apply(def Route) Route {
+	route := def
+	route.Rule = rule.Name
+	if rule.Topic != "" {
+		route.Topic = rule.Topic
+	}
+	if rule.QoS != nil {
+		route.QoS = *rule.QoS
+	}
+	if rule.Retained != nil {
+		route.Retained = *rule.Retained
+	}
+	if rule.Outputs != nil {
+		route.Outputs = rule.Outputs
+	}
+	return route
+}
+
+// matches reports whether the record satisfies every condition of the rule
+func (rule Rule) matches(dataType string, tags map[string]string, data map[string]interface{}) bool {
+	if rule.Type != "" && rule.Type != dataType {
+		return false
+	}
+	for k, v := range rule.Tags {
+		if tags[k] != v {
+			return false
+		}
+	}
+	for path, want := range rule.Fields {
+		got, ok := lookup(data, path)
+		if !ok || fmt.Sprint(got) != want {
+			return false
+		}
+	}
+	if rule.When != nil {
+		// A value the expression needs but the record lacks fails the rule
+		ok, err := rule.When.True(numbers(data))
+		return err == nil && ok
+	}
+	return true
+}
+
+// numbers returns the numeric values in nested data by dotted path;
+// booleans count as 1 and 0
+func numbers(data map[string]interface{}) map[string]float64 {
+	vars := make(map[string]float64)
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			switch val := v.(type) {
+			case map[string]interface{}:
+				walk(prefix+k+".", val)
+			case bool:
+				vars[prefix+k] = 0
+				if val {
+					vars[prefix+k] = 1
+				}
+			default:
+				if f, ok := units.ToFloat(val); ok {
+					vars[prefix+k] = f
+				}
+			}
+		}
+	}
+	walk("", data)
+	return vars
+}
+
+// lookup resolves a dotted path in nested data
+func lookup(data map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
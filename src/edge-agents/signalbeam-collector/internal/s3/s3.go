@@ -0,0 +1,206 @@
+// Package s3 uploads objects to S3-compatible storage (AWS S3, MinIO, Ceph)
+// with Signature Version 4
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+)
+
+// Options configures a client
+type Options struct {
+	// Endpoint is e.g. https://s3.eu-central-1.amazonaws.com or
+	// http://minio:9000. Without it the client only uploads to presigned URLs
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket in the path instead of the host name,
+	// as most self-hosted stores expect
+	PathStyle bool
+	Timeout   time.Duration
+	TLS       *tls.Config
+	Dial      egress.DialFunc
+}
+
+// Client uploads objects to one bucket or to presigned URLs
+type Client struct {
+	opts     Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// New creates a client
+func New(opts Options) (*Client, error) {
+	var u *url.URL
+	if opts.Endpoint != "" {
+		var err error
+		u, err = url.Parse(opts.Endpoint)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("endpoint must be an http or https URL")
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = opts.TLS
+	if opts.Dial != nil {
+		transport.DialContext = opts.Dial
+	}
+
+	return &Client{
+		opts:     opts,
+		endpoint: u,
+		client:   &http.Client{Transport: transport, Timeout: opts.Timeout},
+	}, nil
+}
+
+// Put uploads an object
+func (c *Client) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	return c.PutReader(ctx, key, bytes.NewReader(body), int64(len(body)), contentType)
+}
+
+// PutReader uploads an object of size bytes from body, which is read twice:
+// once to hash the payload for the signature and once to send it
+func (c *Client) PutReader(ctx context.Context, key string, body io.ReadSeeker, size int64, contentType string) error {
+	if c.endpoint == nil {
+		return fmt.Errorf("no bucket configured")
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	u := *c.endpoint
+	path := "/" + escapePath(key)
+	if c.opts.PathStyle {
+		path = "/" + c.opts.Bucket + path
+	} else {
+		u.Host = c.opts.Bucket + "." + u.Host
+	}
+	u.Path = ""
+	u.RawPath = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String()+path, io.NopCloser(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	c.sign(req, path, hex.EncodeToString(h.Sum(nil)), time.Now().UTC())
+	return c.do(req, key)
+}
+
+// PutURL uploads size bytes from body to a presigned URL. header carries
+// headers the signature covers, such as Content-Type
+func (c *Client) PutURL(ctx context.Context, rawURL string, header http.Header, body io.Reader, size int64) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("presigned URL must be an http or https URL")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, rawURL, io.NopCloser(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	for name, values := range header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	// The query holds the signature and stays out of errors
+	return c.do(req, u.Host+u.Path)
+}
+
+// do sends an upload and turns a non-2xx response into an error naming what
+func (c *Client) do(req *http.Request, what string) error {
+	req.Header.Set("User-Agent", "signalbeam-collector/0.1.0")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// url.Error repeats the URL, presigned query included
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("upload of %s failed: %w", what, err)
+	}
+	defer resp.Body.Close()
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("upload of %s returned %s: %s", what, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// sign adds the Signature Version 4 headers for a request to path
+func (c *Client) sign(req *http.Request, path, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		"",
+		"content-type:" + req.Header.Get("Content-Type"),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signed,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + c.opts.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.opts.SecretKey), day)
+	key = hmacSHA256(key, c.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.opts.AccessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapePath percent-encodes an object key as SigV4 expects, keeping slashes
+func escapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		ch := key[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~', ch == '/':
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,160 @@
+// Package schedule parses recurring time windows in local time, such as
+// "22:00-06:00" or "sat-sun 00:00-24:00", and tells whether a time falls in
+// one and when the next one opens
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is a daily span of time on some days of the week. A window ending
+// at or before its start runs past midnight and belongs to the day it starts
+type Window struct {
+	days  [7]bool // By time.Weekday
+	start int     // Minutes after midnight
+	end   int
+}
+
+// Windows is a set of windows; a time in any of them is in the set
+type Windows []Window
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Parse reads windows written as "[days ]HH:MM-HH:MM". Days are three-letter
+// names, comma-separated or as ranges like "mon-fri"; without them the
+// window applies every day
+func Parse(specs []string) (Windows, error) {
+	windows := make(Windows, 0, len(specs))
+	for _, spec := range specs {
+		w, err := parseWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %w", spec, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseWindow(spec string) (Window, error) {
+	var w Window
+	fields := strings.Fields(strings.ToLower(spec))
+	switch len(fields) {
+	case 1:
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	case 2:
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return w, err
+		}
+		w.days = days
+		fields = fields[1:]
+	default:
+		return w, fmt.Errorf("expected [days ]HH:MM-HH:MM")
+	}
+
+	from, to, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return w, fmt.Errorf("expected HH:MM-HH:MM")
+	}
+	var err error
+	if w.start, err = parseClock(from); err != nil {
+		return w, err
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return w, err
+	}
+	if w.start == 24*60 {
+		return w, fmt.Errorf("a window cannot start at 24:00")
+	}
+	return w, nil
+}
+
+// parseDays reads "mon-fri" or "sat,sun"
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := dayNames[from]
+		if !ok {
+			return days, fmt.Errorf("unknown day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = dayNames[to]; !ok {
+				return days, fmt.Errorf("unknown day %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock reads HH:MM, up to 24:00
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// length returns the minutes a window lasts
+func (w Window) length() int {
+	if w.end > w.start {
+		return w.end - w.start
+	}
+	return w.end + 24*60 - w.start
+}
+
+// Contains reports whether t falls in any window
+func (ws Windows) Contains(t time.Time) bool {
+	t = t.Local()
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range ws {
+		// Today's window, or yesterday's running past midnight
+		if w.days[t.Weekday()] && minute >= w.start && minute-w.start < w.length() {
+			return true
+		}
+		if w.days[(t.Weekday()+6)%7] && minute+24*60-w.start < w.length() {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns when the next window opens after t, or the zero time
+// without windows
+func (ws Windows) Next(t time.Time) time.Time {
+	t = t.Local()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	var next time.Time
+	for day := 0; day <= 7; day++ {
+		date := midnight.AddDate(0, 0, day)
+		for _, w := range ws {
+			if !w.days[date.Weekday()] {
+				continue
+			}
+			open := time.Date(date.Year(), date.Month(), date.Day(), 0, w.start, 0, 0, time.Local)
+			if open.After(t) && (next.IsZero() || open.Before(next)) {
+				next = open
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	return next
+}
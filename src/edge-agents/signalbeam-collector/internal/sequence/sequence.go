@@ -0,0 +1,122 @@
+// Package sequence numbers the records of each device and gives every
+// record a unique message ID, so the ingestion pipeline can drop
+// retransmitted duplicates and notice records that never arrived. Numbers
+// keep increasing across restarts
+package sequence
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// block is how many numbers are reserved on disk at a time, so the file is
+// written once per block rather than per record. A crash skips what is left
+// of the block
+const block = 1000
+
+// Sequencer issues sequence numbers per device
+type Sequencer struct {
+	path string
+
+	mu       sync.Mutex
+	next     map[string]uint64 // Next number by device
+	reserved map[string]uint64 // Numbers below this are reserved on disk
+	issued   int64
+}
+
+// Open loads the reservations kept at path. Numbering resumes after the
+// last reservation, so no number is issued twice
+func Open(path string) (*Sequencer, error) {
+	s := &Sequencer{path: path, next: make(map[string]uint64), reserved: make(map[string]uint64)}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &s.reserved); err != nil {
+			return nil, fmt.Errorf("invalid sequence file %s: %w", path, err)
+		}
+	}
+	for device, n := range s.reserved {
+		s.next[device] = n
+	}
+	return s, nil
+}
+
+// Next returns the next sequence number of a device, starting at 1. The
+// number is valid even when reserving the next block fails, but a restart
+// may then issue it again
+func (s *Sequencer) Next(device string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.next[device]
+	if n == 0 {
+		n = 1
+	}
+	s.next[device] = n + 1
+	s.issued++
+	if n < s.reserved[device] {
+		return n, nil
+	}
+	s.reserved[device] = n + block
+	return n, s.save(s.reserved)
+}
+
+// Issued returns the numbers issued since the start
+func (s *Sequencer) Issued() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+// Last returns the last number issued per device
+func (s *Sequencer) Last() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := make(map[string]uint64, len(s.next))
+	for device, n := range s.next {
+		last[device] = n - 1
+	}
+	return last
+}
+
+// Close records the exact next numbers, so a clean restart leaves no gap
+func (s *Sequencer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for device, n := range s.next {
+		s.reserved[device] = n
+	}
+	return s.save(s.reserved)
+}
+
+// save writes the reservations atomically
+func (s *Sequencer) save(reserved map[string]uint64) error {
+	data, err := json.Marshal(reserved)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// NewID returns a random (version 4) UUID
+func NewID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,109 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Algorithm identifies the signature scheme in signed payloads
+const Algorithm = "ed25519"
+
+// Signed wraps a payload with a detached signature over its exact bytes
+type Signed struct {
+	Algorithm string          `json:"alg"`
+	KeyID     string          `json:"kid"`
+	Signature string          `json:"sig"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// Signer signs payloads with a device private key
+type Signer struct {
+	keyID string
+	key   ed25519.PrivateKey
+}
+
+// NewSigner creates a signer for the given key
+func NewSigner(keyID string, key ed25519.PrivateKey) *Signer {
+	return &Signer{keyID: keyID, key: key}
+}
+
+// LoadPrivateKey reads an Ed25519 private key from a PKCS#8 PEM file or a
+// hex/base64 encoded 32-byte seed
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	if block, _ := pem.Decode(raw); block != nil {
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		key, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("private key is not an Ed25519 key")
+		}
+		return key, nil
+	}
+
+	text := strings.TrimSpace(string(raw))
+	seed, err := hex.DecodeString(text)
+	if err != nil {
+		if seed, err = base64.StdEncoding.DecodeString(text); err != nil {
+			return nil, fmt.Errorf("private key %s is not PEM, hex or base64", path)
+		}
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("private key seed must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Sign wraps a JSON payload in a signed envelope. The payload must be
+// valid JSON; it is embedded verbatim so the signed bytes are preserved.
+func (s *Signer) Sign(payload []byte) ([]byte, error) {
+	if !json.Valid(payload) {
+		return nil, errors.New("payload is not valid JSON")
+	}
+
+	return json.Marshal(Signed{
+		Algorithm: Algorithm,
+		KeyID:     s.keyID,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, payload)),
+		Payload:   payload,
+	})
+}
+
+// Verify checks a signed envelope against known public keys and returns
+// the embedded payload
+func Verify(data []byte, keys map[string]ed25519.PublicKey) ([]byte, error) {
+	var signed Signed
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, fmt.Errorf("invalid signed payload: %w", err)
+	}
+	if signed.Algorithm != Algorithm {
+		return nil, fmt.Errorf("unsupported algorithm %q", signed.Algorithm)
+	}
+
+	pub, ok := keys[signed.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", signed.KeyID)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if !ed25519.Verify(pub, signed.Payload, sig) {
+		return nil, errors.New("signature verification failed")
+	}
+	return signed.Payload, nil
+}
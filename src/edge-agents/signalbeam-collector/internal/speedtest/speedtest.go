@@ -0,0 +1,203 @@
+// Package speedtest measures the latency and throughput of the uplink
+// against HTTP test servers
+package speedtest
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+)
+
+// pings is the number of requests latency is measured over
+const pings = 5
+
+// Options configures a test
+type Options struct {
+	DownloadURL string // Read up to Bytes by GET
+	UploadURL   string // Sent Bytes by POST
+	Bytes       int64  // Per direction
+	Timeout     time.Duration
+	TLS         *tls.Config
+	Dial        egress.DialFunc
+}
+
+// Result is the outcome of a test. Directions without a URL are zero
+type Result struct {
+	Latency       time.Duration // Median request round trip
+	Jitter        time.Duration // Mean difference between consecutive round trips
+	DownloadBytes int64
+	DownloadBps   float64 // Bits per second
+	UploadBytes   int64
+	UploadBps     float64
+}
+
+// Bytes returns the data the test transferred
+func (r Result) Bytes() int64 {
+	return r.DownloadBytes + r.UploadBytes
+}
+
+// Map returns the result as telemetry fields
+func (r Result) Map() map[string]interface{} {
+	m := map[string]interface{}{
+		"latency_ms": float64(r.Latency) / float64(time.Millisecond),
+		"jitter_ms":  float64(r.Jitter) / float64(time.Millisecond),
+	}
+	if r.DownloadBytes > 0 {
+		m["download_bytes"] = r.DownloadBytes
+		m["download_mbps"] = r.DownloadBps / 1e6
+	}
+	if r.UploadBytes > 0 {
+		m["upload_bytes"] = r.UploadBytes
+		m["upload_mbps"] = r.UploadBps / 1e6
+	}
+	return m
+}
+
+// Tester runs tests
+type Tester struct {
+	opts   Options
+	client *http.Client
+}
+
+// New creates a tester
+func New(opts Options) *Tester {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = opts.TLS
+	// Compressed responses would overstate the throughput
+	transport.DisableCompression = true
+	if opts.Dial != nil {
+		transport.DialContext = opts.Dial
+	}
+	return &Tester{opts: opts, client: &http.Client{Transport: transport}}
+}
+
+// Run measures latency, then download and upload throughput. The result
+// counts the bytes transferred even when a step fails
+func (t *Tester) Run(ctx context.Context) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.opts.Timeout)
+	defer cancel()
+	defer t.client.CloseIdleConnections()
+
+	var r Result
+	target := t.opts.DownloadURL
+	if target == "" {
+		target = t.opts.UploadURL
+	}
+	if err := t.latency(ctx, target, &r); err != nil {
+		return r, fmt.Errorf("latency: %w", err)
+	}
+	if t.opts.DownloadURL != "" {
+		if err := t.download(ctx, &r); err != nil {
+			return r, fmt.Errorf("download: %w", err)
+		}
+	}
+	if t.opts.UploadURL != "" {
+		if err := t.upload(ctx, &r); err != nil {
+			return r, fmt.Errorf("upload: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// latency times HEAD requests over a connection opened beforehand, so
+// neither the handshake nor the transfer is measured
+func (t *Tester) latency(ctx context.Context, target string, r *Result) error {
+	rtts := make([]time.Duration, 0, pings)
+	for i := 0; i <= pings; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		resp, err := t.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if i > 0 {
+			rtts = append(rtts, time.Since(start))
+		}
+	}
+
+	for i := 1; i < len(rtts); i++ {
+		r.Jitter += (rtts[i] - rtts[i-1]).Abs()
+	}
+	r.Jitter /= time.Duration(len(rtts) - 1)
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	r.Latency = rtts[len(rtts)/2]
+	return nil
+}
+
+// download reads up to the test size from the download URL
+func (t *Tester) download(ctx context.Context, r *Result) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.opts.DownloadURL, nil)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("test server returned %s", resp.Status)
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, t.opts.Bytes))
+	r.DownloadBytes = n
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("test server sent no data")
+	}
+	r.DownloadBps = float64(n*8) / time.Since(start).Seconds()
+	return nil
+}
+
+// upload posts the test size of random data, which proxies cannot compress
+func (t *Tester) upload(ctx context.Context, r *Result) error {
+	var sent atomic.Int64
+	body := &countingReader{r: io.LimitReader(rand.New(rand.NewSource(time.Now().UnixNano())), t.opts.Bytes), n: &sent}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.opts.UploadURL, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = t.opts.Bytes
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	start := time.Now()
+	resp, err := t.client.Do(req)
+	r.UploadBytes = sent.Load()
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("test server returned %s", resp.Status)
+	}
+	r.UploadBps = float64(r.UploadBytes*8) / time.Since(start).Seconds()
+	return nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
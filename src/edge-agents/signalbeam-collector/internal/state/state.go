@@ -0,0 +1,140 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/config"
+)
+
+// Paths holds the resolved writable locations used by the collector
+type Paths struct {
+	Dir       string
+	BufferDir string
+	Offsets   string
+	CrashDir  string
+	StateFile string
+
+	QuarantineDir string
+	AuditFile     string
+	DecoderDir    string
+	BootstrapFile string
+}
+
+// Resolve derives every writable path from the state configuration
+func Resolve(cfg config.StateConfig) Paths {
+	p := Paths{
+		Dir:       cfg.Dir,
+		BufferDir: cfg.BufferDir,
+		Offsets:   cfg.OffsetsFile,
+		CrashDir:  cfg.CrashDir,
+		StateFile: cfg.StateFile,
+
+		QuarantineDir: cfg.QuarantineDir,
+		AuditFile:     cfg.AuditFile,
+		DecoderDir:    cfg.DecoderDir,
+		BootstrapFile: cfg.BootstrapFile,
+	}
+
+	if p.BufferDir == "" {
+		p.BufferDir = filepath.Join(p.Dir, "buffer")
+	}
+	if p.Offsets == "" {
+		p.Offsets = filepath.Join(p.Dir, "offsets.json")
+	}
+	if p.CrashDir == "" {
+		p.CrashDir = filepath.Join(p.Dir, "crash")
+	}
+	if p.StateFile == "" {
+		p.StateFile = filepath.Join(p.Dir, "state.json")
+	}
+	if p.QuarantineDir == "" {
+		p.QuarantineDir = filepath.Join(p.Dir, "quarantine")
+	}
+	if p.AuditFile == "" {
+		p.AuditFile = filepath.Join(p.Dir, "audit.log")
+	}
+	if p.DecoderDir == "" {
+		p.DecoderDir = filepath.Join(p.Dir, "decoders")
+	}
+	if p.BootstrapFile == "" {
+		p.BootstrapFile = filepath.Join(p.Dir, "bootstrap.yaml")
+	}
+
+	return p
+}
+
+// Prepare creates the state directories and verifies they are writable
+func (p Paths) Prepare() error {
+	dirs := []struct {
+		key  string
+		path string
+	}{
+		{"state.dir", p.Dir},
+		{"state.buffer_dir", p.BufferDir},
+		{"state.crash_dir", p.CrashDir},
+		{"state.offsets_file", filepath.Dir(p.Offsets)},
+		{"state.state_file", filepath.Dir(p.StateFile)},
+		{"state.quarantine_dir", p.QuarantineDir},
+		{"state.audit_file", filepath.Dir(p.AuditFile)},
+		{"state.decoder_dir", p.DecoderDir},
+		{"state.bootstrap_file", filepath.Dir(p.BootstrapFile)},
+	}
+
+	for _, d := range dirs {
+		if err := ensureWritable(d.path); err != nil {
+			return fmt.Errorf("%s (%s) is not writable: %w. %s", d.key, d.path, err, guidance(err))
+		}
+	}
+
+	return nil
+}
+
+// WriteCrashReport stores a panic value and stack trace in the crash directory
+func (p Paths) WriteCrashReport(recovered interface{}) (string, error) {
+	name := fmt.Sprintf("crash-%s.txt", time.Now().UTC().Format("20060102T150405Z"))
+	path := filepath.Join(p.CrashDir, name)
+
+	report := fmt.Sprintf("panic: %v\n\n%s", recovered, debug.Stack())
+	if err := os.WriteFile(path, []byte(report), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write crash report: %w", err)
+	}
+
+	return path, nil
+}
+
+// ensureWritable creates the directory if needed and probes it with a temp file
+func ensureWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+
+	probe, err := os.CreateTemp(dir, ".write-probe-*")
+	if err != nil {
+		return err
+	}
+	name := probe.Name()
+	probe.Close()
+
+	return os.Remove(name)
+}
+
+// guidance returns an operator hint for the given write failure
+func guidance(err error) string {
+	switch {
+	case errors.Is(err, syscall.EROFS):
+		return "The filesystem is mounted read-only (common on OSTree and squashfs images). " +
+			"Point state.dir at a writable mount such as /var/lib/signalbeam or a tmpfs, " +
+			"or override the individual path in the state section."
+	case errors.Is(err, os.ErrPermission):
+		return "The collector user lacks write permission. Grant ownership of the directory " +
+			"to the service user or point state.dir at a location it owns."
+	default:
+		return "Set state.dir (or the individual path override) to a writable location."
+	}
+}
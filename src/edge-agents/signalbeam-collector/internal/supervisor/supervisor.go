@@ -0,0 +1,371 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Input states reported in Health
+const (
+	StateRunning     = "running"
+	StateBackoff     = "backoff"
+	StateQuarantined = "quarantined"
+	StateStopped     = "stopped"
+)
+
+// stopGrace is how long a cancelled input may take to return before its
+// goroutine is abandoned and a fresh instance started
+const stopGrace = 5 * time.Second
+
+// ErrUnhealthy is the cause recorded when an input is restarted by a failed
+// health check
+var ErrUnhealthy = errors.New("input unhealthy")
+
+// Policy controls how failed inputs are restarted
+type Policy struct {
+	InitialBackoff   time.Duration // Delay before the first restart, doubled per restart
+	MaxBackoff       time.Duration // Upper bound of the restart delay
+	MaxRestarts      int           // Restarts allowed within Window before quarantine
+	Window           time.Duration
+	Quarantine       time.Duration // How long a quarantined input stays stopped, 0 until agent restart
+	FailureThreshold int           // Consecutive failures reported via Fail before a restart
+}
+
+// Input is a supervised unit of collection. Run must return when ctx is
+// cancelled; a returned error, a panic or a failed health check restarts it
+type Input struct {
+	Name string
+	Run  func(ctx context.Context, h *Handle) error
+
+	// HealthTimeout restarts the input when it has not called Beat for this
+	// long. 0 disables the check
+	HealthTimeout time.Duration
+}
+
+// Status is the health of one input
+type Status struct {
+	State            string `json:"state"`
+	Restarts         int    `json:"restarts"`
+	Failures         int    `json:"consecutive_failures,omitempty"`
+	LastError        string `json:"last_error,omitempty"`
+	Since            int64  `json:"since"`
+	QuarantinedUntil int64  `json:"quarantined_until,omitempty"`
+}
+
+// Supervisor runs inputs and restarts them according to a policy
+type Supervisor struct {
+	policy Policy
+	logger *logrus.Entry
+
+	mu     sync.Mutex
+	inputs map[string]*Status
+}
+
+// New creates a supervisor
+func New(policy Policy, logger *logrus.Entry) *Supervisor {
+	return &Supervisor{
+		policy: policy,
+		logger: logger,
+		inputs: make(map[string]*Status),
+	}
+}
+
+// Go supervises an input until ctx is cancelled
+func (s *Supervisor) Go(ctx context.Context, wg *sync.WaitGroup, in Input) {
+	s.setState(in.Name, StateRunning, nil)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.supervise(ctx, in)
+	}()
+}
+
+// Health returns the status of every input
+func (s *Supervisor) Health() map[string]Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	health := make(map[string]Status, len(s.inputs))
+	for name, st := range s.inputs {
+		health[name] = *st
+	}
+	return health
+}
+
+// Unhealthy returns the names of inputs that are not running
+func (s *Supervisor) Unhealthy() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for name, st := range s.inputs {
+		if st.State != StateRunning && st.State != StateStopped {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// supervise runs an input, restarting it with backoff and quarantining it
+// when it fails too often
+func (s *Supervisor) supervise(ctx context.Context, in Input) {
+	logger := s.logger.WithField("input", in.Name)
+	var recent []time.Time
+
+	for {
+		err := s.runOnce(ctx, in)
+		if ctx.Err() != nil {
+			s.setState(in.Name, StateStopped, nil)
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("input exited")
+		}
+
+		now := time.Now()
+		recent = append(recent, now)
+		for len(recent) > 0 && now.Sub(recent[0]) > s.policy.Window {
+			recent = recent[1:]
+		}
+
+		var delay time.Duration
+		if len(recent) > s.policy.MaxRestarts {
+			recent = nil
+			delay = s.policy.Quarantine
+			s.quarantine(in.Name, err, delay)
+			logger.WithError(err).Errorf("Input failed %d times within %s, quarantining", s.policy.MaxRestarts+1, s.policy.Window)
+		} else {
+			delay = s.backoff(len(recent))
+			s.setState(in.Name, StateBackoff, err)
+			logger.WithError(err).Warnf("Input failed, restarting in %s", delay)
+		}
+
+		if !s.wait(ctx, delay) {
+			s.setState(in.Name, StateStopped, nil)
+			return
+		}
+		s.restarted(in.Name)
+		logger.Info("Restarting input")
+	}
+}
+
+// runOnce runs one instance of an input and returns why it stopped
+func (s *Supervisor) runOnce(ctx context.Context, in Input) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	h := &Handle{
+		name:      in.Name,
+		sup:       s,
+		cancel:    cancel,
+		threshold: s.policy.FailureThreshold,
+		lastBeat:  time.Now(),
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- in.Run(runCtx, h)
+	}()
+
+	var check <-chan time.Time
+	if in.HealthTimeout > 0 {
+		ticker := time.NewTicker(in.HealthTimeout / 2)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+
+	for {
+		select {
+		case err := <-done:
+			if cause := h.cause(); cause != nil {
+				return cause
+			}
+			return err
+		case <-check:
+			if h.sinceBeat() > in.HealthTimeout {
+				h.stop(fmt.Errorf("%w: no activity for %s", ErrUnhealthy, in.HealthTimeout))
+			}
+		case <-runCtx.Done():
+			// Cancelled by the agent or a failed health check; give the
+			// input a chance to return before abandoning it
+			select {
+			case err := <-done:
+				if cause := h.cause(); cause != nil {
+					return cause
+				}
+				return err
+			case <-time.After(stopGrace):
+				s.logger.WithField("input", in.Name).Warn("Input did not stop, abandoning it")
+				if cause := h.cause(); cause != nil {
+					return cause
+				}
+				return fmt.Errorf("input did not stop within %s", stopGrace)
+			}
+		}
+	}
+}
+
+// backoff returns the delay before the nth restart within the window
+func (s *Supervisor) backoff(n int) time.Duration {
+	delay := s.policy.InitialBackoff
+	for i := 1; i < n && delay < s.policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.policy.MaxBackoff {
+		delay = s.policy.MaxBackoff
+	}
+	return delay
+}
+
+// wait sleeps for d, or until ctx is cancelled when d is 0. It returns false
+// when ctx was cancelled
+func (s *Supervisor) wait(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		<-ctx.Done()
+		return false
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// setState records a state transition
+func (s *Supervisor) setState(name, state string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.inputs[name]
+	if !ok {
+		st = &Status{}
+		s.inputs[name] = st
+	}
+	if st.State != state {
+		st.Since = time.Now().UTC().Unix()
+	}
+	st.State = state
+	st.QuarantinedUntil = 0
+	if err != nil {
+		st.LastError = err.Error()
+	}
+}
+
+// quarantine marks an input as stopped for d
+func (s *Supervisor) quarantine(name string, err error, d time.Duration) {
+	s.setState(name, StateQuarantined, err)
+	if d > 0 {
+		s.mu.Lock()
+		s.inputs[name].QuarantinedUntil = time.Now().Add(d).UTC().Unix()
+		s.mu.Unlock()
+	}
+}
+
+// restarted counts a restart and marks the input running
+func (s *Supervisor) restarted(name string) {
+	s.setState(name, StateRunning, nil)
+	s.mu.Lock()
+	s.inputs[name].Restarts++
+	s.inputs[name].Failures = 0
+	s.mu.Unlock()
+}
+
+// setFailures records the consecutive failure count of an input
+func (s *Supervisor) setFailures(name string, n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.inputs[name]; ok {
+		st.Failures = n
+		if err != nil {
+			st.LastError = err.Error()
+		}
+	}
+}
+
+// Handle lets a running input report its health
+type Handle struct {
+	name      string
+	sup       *Supervisor
+	cancel    context.CancelFunc
+	threshold int
+
+	mu       sync.Mutex
+	lastBeat time.Time
+	failures int
+	stopErr  error
+}
+
+// Beat records activity for the health check
+func (h *Handle) Beat() {
+	h.mu.Lock()
+	h.lastBeat = time.Now()
+	h.mu.Unlock()
+}
+
+// OK records a successful operation, resetting the failure count
+func (h *Handle) OK() {
+	h.mu.Lock()
+	h.lastBeat = time.Now()
+	reset := h.failures > 0
+	h.failures = 0
+	h.mu.Unlock()
+
+	if reset {
+		h.sup.setFailures(h.name, 0, nil)
+	}
+}
+
+// Fail records a failed operation. The input is restarted once the policy's
+// failure threshold is reached
+func (h *Handle) Fail(err error) {
+	h.mu.Lock()
+	h.lastBeat = time.Now()
+	h.failures++
+	n := h.failures
+	h.mu.Unlock()
+
+	h.sup.setFailures(h.name, n, err)
+	if h.threshold > 0 && n >= h.threshold {
+		h.stop(fmt.Errorf("%w: %d consecutive failures, last: %v", ErrUnhealthy, n, err))
+	}
+}
+
+// stop cancels the input, recording why
+func (h *Handle) stop(err error) {
+	h.mu.Lock()
+	if h.stopErr == nil {
+		h.stopErr = err
+	}
+	h.mu.Unlock()
+	h.cancel()
+}
+
+// cause returns why the supervisor stopped the input, if it did
+func (h *Handle) cause() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stopErr
+}
+
+// sinceBeat returns the time since the last recorded activity
+func (h *Handle) sinceBeat() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return time.Since(h.lastBeat)
+}
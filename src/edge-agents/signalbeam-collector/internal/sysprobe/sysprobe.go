@@ -0,0 +1,8 @@
+// Package sysprobe reads host settings that checks compare with expected
+// values: kernel parameters, service states and the firewall
+package sysprobe
+
+import "errors"
+
+// ErrUnsupported is returned for probes the platform does not have
+var ErrUnsupported = errors.New("not supported on this platform")
@@ -0,0 +1,88 @@
+//go:build linux
+
+package sysprobe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Sysctl reads a kernel parameter from /proc/sys
+func Sysctl(key string) (string, error) {
+	data, err := os.ReadFile(filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/")))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Systemctl runs a query such as is-active or is-enabled and returns the
+// state it prints. The queries exit non-zero for inactive or disabled
+// units, which is not an error here
+func Systemctl(query, unit string) (string, error) {
+	out, err := run("systemctl", query, unit)
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return "", err
+	}
+	state := strings.TrimSpace(string(out))
+	if state == "" {
+		return "", errors.New("systemctl " + query + " printed no state")
+	}
+	return state, nil
+}
+
+// Firewall reports whether packet filtering is in place: an nftables rule
+// or drop policy, else an iptables rule or non-accepting policy. detail
+// names the tool and the rule count
+func Firewall() (active bool, detail string, err error) {
+	if _, lookErr := exec.LookPath("nft"); lookErr == nil {
+		out, err := run("nft", "list", "ruleset")
+		if err != nil {
+			return false, "", fmt.Errorf("nft: %w", err)
+		}
+		rules, drop := 0, false
+		for _, line := range strings.Split(string(out), "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case line == "", line == "}", strings.HasPrefix(line, "table "), strings.HasPrefix(line, "chain "):
+			case strings.HasPrefix(line, "type "):
+				drop = drop || strings.Contains(line, "policy drop")
+			default:
+				rules++
+			}
+		}
+		return rules > 0 || drop, fmt.Sprintf("nftables, %d rules", rules), nil
+	}
+
+	if _, lookErr := exec.LookPath("iptables"); lookErr == nil {
+		out, err := run("iptables", "-S")
+		if err != nil {
+			return false, "", fmt.Errorf("iptables: %w", err)
+		}
+		rules, drop := 0, false
+		for _, line := range strings.Split(string(out), "\n") {
+			switch {
+			case strings.HasPrefix(line, "-A "):
+				rules++
+			case strings.HasPrefix(line, "-P ") && !strings.HasSuffix(line, " ACCEPT"):
+				drop = true
+			}
+		}
+		return rules > 0 || drop, fmt.Sprintf("iptables, %d rules", rules), nil
+	}
+	return false, "", errors.New("neither nft nor iptables is installed")
+}
+
+// run executes a probe command with a timeout
+func run(name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return exec.CommandContext(ctx, name, args...).Output()
+}
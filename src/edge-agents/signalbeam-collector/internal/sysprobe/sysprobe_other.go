@@ -0,0 +1,18 @@
+//go:build !linux
+
+package sysprobe
+
+// Sysctl values are read from /proc/sys, which only Linux has
+func Sysctl(key string) (string, error) {
+	return "", ErrUnsupported
+}
+
+// Systemctl queries systemd, which only Linux has
+func Systemctl(query, unit string) (string, error) {
+	return "", ErrUnsupported
+}
+
+// Firewall rules are read with nft or iptables, which only Linux has
+func Firewall() (active bool, detail string, err error) {
+	return false, "", ErrUnsupported
+}
@@ -0,0 +1,103 @@
+// Package topics expands topic templates, in which names in braces such as
+// {device_id} or {site} stand for device fields and tags
+package topics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Expand replaces the placeholders of a template with the values lookup
+// returns. Placeholders lookup does not know are left as they are
+func Expand(template string, lookup func(name string) (string, bool)) string {
+	if !strings.Contains(template, "{") {
+		return template
+	}
+	var b strings.Builder
+	b.Grow(len(template) + 32)
+	rest := template
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			break
+		}
+		b.WriteString(rest[:open])
+		name := rest[open+1 : open+end]
+		if value, ok := lookup(name); ok {
+			b.WriteString(value)
+		} else {
+			b.WriteString(rest[open : open+end+1])
+		}
+		rest = rest[open+end+1:]
+	}
+	b.WriteString(rest)
+	return b.String()
+}
+
+// Names returns the placeholders of a template, checking that its braces
+// pair up and that no literal part holds an MQTT wildcard
+func Names(template string) ([]string, error) {
+	var names []string
+	rest := template
+	for rest != "" {
+		open := strings.IndexAny(rest, "{}")
+		literal := rest
+		if open >= 0 {
+			literal = rest[:open]
+		}
+		if strings.ContainsAny(literal, "+#") {
+			return nil, errors.New("wildcards + and # are not allowed in topics")
+		}
+		if open < 0 {
+			break
+		}
+		if rest[open] == '}' {
+			return nil, errors.New("unmatched }")
+		}
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end < 0 || rest[open+1+end] != '}' {
+			return nil, errors.New("unmatched {")
+		}
+		name := rest[open+1 : open+1+end]
+		if name == "" {
+			return nil, errors.New("empty placeholder {}")
+		}
+		names = append(names, name)
+		rest = rest[open+end+2:]
+	}
+	return names, nil
+}
+
+// Level makes a value safe as part of a topic level: separators, wildcards
+// and NUL become underscores
+func Level(value string) string {
+	if !strings.ContainsAny(value, "/+#\x00") {
+		return value
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '+', '#', 0:
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// Check verifies that every placeholder of a template is known
+func Check(template string, known func(name string) bool) error {
+	names, err := Names(template)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !known(name) {
+			return fmt.Errorf("unknown placeholder {%s}", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package trace
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Step results
+const (
+	Passed    = "passed"    // The stage left the record unchanged
+	Modified  = "modified"  // The stage changed the record
+	Dropped   = "dropped"   // The stage filtered the record out
+	Failed    = "failed"    // The stage hit an error and the record was lost
+	Published = "published" // An output accepted the record
+)
+
+// Step records what one pipeline stage did to a record
+type Step struct {
+	Stage  string `json:"stage"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Trace follows one record through the pipeline. A nil trace ignores
+// steps, so stages can record unconditionally
+type Trace struct {
+	Type     string    `json:"type"`
+	DeviceID string    `json:"device_id"`
+	Started  time.Time `json:"started"`
+	Steps    []Step    `json:"steps"`
+	Outcome  string    `json:"outcome"` // Result of the last step
+
+	// Delivery, set once the record reaches an output
+	Topic    string `json:"topic,omitempty"`
+	QoS      byte   `json:"qos,omitempty"`
+	Retained bool   `json:"retained,omitempty"`
+	Size     int    `json:"size,omitempty"`
+
+	// Record is the processed record; only dry runs include it
+	Record interface{} `json:"record,omitempty"`
+}
+
+// New starts a trace
+func New(dataType, deviceID string) *Trace {
+	return &Trace{Type: dataType, DeviceID: deviceID, Started: time.Now().UTC()}
+}
+
+// Step appends what a stage did
+func (t *Trace) Step(stage, result, detail string) {
+	if t == nil {
+		return
+	}
+	t.Steps = append(t.Steps, Step{Stage: stage, Result: result, Detail: detail})
+	t.Outcome = result
+}
+
+// Deliver records where an output sent the record
+func (t *Trace) Deliver(topic string, qos byte, retained bool, size int) {
+	if t == nil {
+		return
+	}
+	t.Topic, t.QoS, t.Retained, t.Size = topic, qos, retained, size
+}
+
+// Sampler traces one record in every n and keeps the most recent traces
+type Sampler struct {
+	every int64
+	seen  atomic.Int64
+
+	mu     sync.Mutex
+	keep   int
+	recent []Trace
+}
+
+// NewSampler creates a sampler tracing one record in every and keeping keep
+// finished traces
+func NewSampler(every, keep int) *Sampler {
+	if every < 1 {
+		every = 1
+	}
+	return &Sampler{every: int64(every), keep: keep}
+}
+
+// Sample returns a trace for the record when it is sampled, otherwise nil
+func (s *Sampler) Sample(dataType, deviceID string) *Trace {
+	if s == nil || (s.seen.Add(1)-1)%s.every != 0 {
+		return nil
+	}
+	return New(dataType, deviceID)
+}
+
+// Finish stores a completed trace
+func (s *Sampler) Finish(t *Trace) {
+	if s == nil || t == nil || s.keep < 1 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.recent) >= s.keep {
+		s.recent = append(s.recent[:0], s.recent[1:]...)
+	}
+	s.recent = append(s.recent, *t)
+}
+
+// Recent returns the stored traces, oldest first
+func (s *Sampler) Recent() []Trace {
+	if s == nil {
+		return []Trace{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Trace{}, s.recent...)
+}
@@ -0,0 +1,268 @@
+package units
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rule converts values at a path pattern from one unit to another.
+// Pattern segments are separated by "." and "*" matches any single segment.
+type Rule struct {
+	Path string
+	From string
+	To   string
+}
+
+// builtin declares the units of the metrics produced by the collector
+var builtin = map[string]string{
+	"system.uptime":    "seconds",
+	"system.boot_time": "unix_seconds",
+
+	"cpu.usage_percent":   "percent",
+	"cpu.times.*":         "seconds",
+	"cpu.info.mhz":        "megahertz",
+	"cpu.info.cache_size": "kilobytes",
+
+	"memory.*.total":        "bytes",
+	"memory.*.available":    "bytes",
+	"memory.*.used":         "bytes",
+	"memory.*.free":         "bytes",
+	"memory.*.active":       "bytes",
+	"memory.*.inactive":     "bytes",
+	"memory.*.buffers":      "bytes",
+	"memory.*.cached":       "bytes",
+	"memory.*.shared":       "bytes",
+	"memory.*.used_percent": "percent",
+
+	"disk.usage.total":           "bytes",
+	"disk.usage.free":            "bytes",
+	"disk.usage.used":            "bytes",
+	"disk.usage.used_percent":    "percent",
+	"disk.mounts.*.total":        "bytes",
+	"disk.mounts.*.free":         "bytes",
+	"disk.mounts.*.used":         "bytes",
+	"disk.mounts.*.used_percent": "percent",
+	"disk.io.*.read_bytes":       "bytes",
+	"disk.io.*.write_bytes":      "bytes",
+	"disk.io.*.read_count":       "operations",
+	"disk.io.*.write_count":      "operations",
+	"disk.io.*.read_time":        "milliseconds",
+	"disk.io.*.write_time":       "milliseconds",
+
+	"network.interfaces.*.bytes_sent":   "bytes",
+	"network.interfaces.*.bytes_recv":   "bytes",
+	"network.interfaces.*.packets_sent": "packets",
+	"network.interfaces.*.packets_recv": "packets",
+	"network.interfaces.*.errin":        "packets",
+	"network.interfaces.*.errout":       "packets",
+	"network.interfaces.*.dropin":       "packets",
+	"network.interfaces.*.dropout":      "packets",
+
+	"load.load1":  "1",
+	"load.load5":  "1",
+	"load.load15": "1",
+
+	"battery.*.capacity_percent":    "percent",
+	"battery.*.temperature_celsius": "celsius",
+	"battery.*.voltage_volts":       "volts",
+	"battery.*.current_amps":        "amperes",
+
+	"processes.*.cpu_percent": "percent",
+	"processes.*.rss":         "bytes",
+}
+
+// factors maps size and time units to their base unit multiplier
+var factors = map[string]struct {
+	base   string
+	factor float64
+}{
+	"bytes":        {"bytes", 1},
+	"kilobytes":    {"bytes", 1e3},
+	"megabytes":    {"bytes", 1e6},
+	"gigabytes":    {"bytes", 1e9},
+	"kibibytes":    {"bytes", 1 << 10},
+	"mebibytes":    {"bytes", 1 << 20},
+	"gibibytes":    {"bytes", 1 << 30},
+	"seconds":      {"seconds", 1},
+	"milliseconds": {"seconds", 1e-3},
+	"microseconds": {"seconds", 1e-6},
+	"minutes":      {"seconds", 60},
+	"hours":        {"seconds", 3600},
+	"hertz":        {"hertz", 1},
+	"kilohertz":    {"hertz", 1e3},
+	"megahertz":    {"hertz", 1e6},
+}
+
+// Convert converts a value between two units
+func Convert(v float64, from, to string) (float64, error) {
+	if from == to {
+		return v, nil
+	}
+
+	if isTemperature(from) && isTemperature(to) {
+		return fromKelvin(toKelvin(v, from), to), nil
+	}
+
+	f, ok := factors[from]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+	t, ok := factors[to]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+	if f.base != t.base {
+		return 0, fmt.Errorf("cannot convert %s to %s", from, to)
+	}
+
+	return v * f.factor / t.factor, nil
+}
+
+// Validate checks that a rule describes a supported conversion
+func (r Rule) Validate() error {
+	if r.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	_, err := Convert(0, r.From, r.To)
+	return err
+}
+
+// isTemperature reports whether the unit is a temperature scale
+func isTemperature(u string) bool {
+	return u == "celsius" || u == "fahrenheit" || u == "kelvin"
+}
+
+// toKelvin converts a temperature to kelvin
+func toKelvin(v float64, from string) float64 {
+	switch from {
+	case "celsius":
+		return v + 273.15
+	case "fahrenheit":
+		return (v-32)*5/9 + 273.15
+	default:
+		return v
+	}
+}
+
+// fromKelvin converts a temperature from kelvin
+func fromKelvin(v float64, to string) float64 {
+	switch to {
+	case "celsius":
+		return v - 273.15
+	case "fahrenheit":
+		return (v-273.15)*9/5 + 32
+	default:
+		return v
+	}
+}
+
+// Processor attaches units to metric payloads and applies normalization rules
+type Processor struct {
+	rules    []Rule
+	declared map[string]string
+}
+
+// NewProcessor creates a processor with the built-in unit table, extra unit
+// declarations (e.g. for external sensors) and normalization rules
+func NewProcessor(declared map[string]string, rules []Rule) *Processor {
+	all := make(map[string]string, len(builtin)+len(declared))
+	for k, v := range builtin {
+		all[k] = v
+	}
+	for k, v := range declared {
+		all[k] = v
+	}
+	return &Processor{rules: rules, declared: all}
+}
+
+// Process normalizes values in place and returns the unit of every pattern
+// that matched at least one value in the payload
+func (p *Processor) Process(data map[string]interface{}) map[string]string {
+	found := make(map[string]string)
+
+	Walk(data, nil, func(path []string, parent map[string]interface{}, key string, v interface{}) {
+		for pattern, unit := range p.declared {
+			if Match(pattern, path) {
+				found[pattern] = unit
+			}
+		}
+
+		for _, rule := range p.rules {
+			if !Match(rule.Path, path) {
+				continue
+			}
+			f, ok := ToFloat(v)
+			if !ok {
+				continue
+			}
+			converted, err := Convert(f, rule.From, rule.To)
+			if err != nil {
+				continue
+			}
+			parent[key] = converted
+			found[rule.Path] = rule.To
+			delete(found, overriddenPattern(p.declared, rule.Path, path))
+		}
+	})
+
+	return found
+}
+
+// overriddenPattern returns the declared pattern a rule replaces for a path
+func overriddenPattern(declared map[string]string, rulePath string, path []string) string {
+	for pattern := range declared {
+		if pattern != rulePath && Match(pattern, path) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// Walk visits every leaf value of a nested metrics map
+func Walk(m map[string]interface{}, prefix []string, fn func(path []string, parent map[string]interface{}, key string, v interface{})) {
+	for k, v := range m {
+		path := append(append([]string{}, prefix...), k)
+		if child, ok := v.(map[string]interface{}); ok {
+			Walk(child, path, fn)
+			continue
+		}
+		fn(path, m, k, v)
+	}
+}
+
+// Match reports whether a path matches a dotted pattern with "*" wildcards.
+// Paths are matched by segment so label values may themselves contain dots.
+func Match(pattern string, path []string) bool {
+	parts := strings.Split(pattern, ".")
+	if len(parts) != len(path) {
+		return false
+	}
+	for i, part := range parts {
+		if part != "*" && part != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ToFloat converts numeric metric values to float64
+func ToFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
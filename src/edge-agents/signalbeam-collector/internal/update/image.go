@@ -0,0 +1,311 @@
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Directive kinds
+const (
+	KindAgent = "agent" // Replaces the agent binary; artifacts by "os/arch"
+	KindImage = "image" // Installs a system image; artifacts by device type
+)
+
+// Image update systems
+var ImageSystems = []string{"rauc", "mender", "swupdate"}
+
+// ImageSystem installs system images into the inactive slot of an A/B
+// update system. The bootloader boots the new slot once and falls back to
+// the previous one unless the booted slot is confirmed
+type ImageSystem interface {
+	Name() string
+	// DeviceType selects the image of a rollout: the RAUC compatible, the
+	// Mender device_type or the swupdate board
+	DeviceType() (string, error)
+	Install(ctx context.Context, path string) error
+	Slots(ctx context.Context) (Slots, error)
+	// Confirm marks the booted slot good
+	Confirm(ctx context.Context) error
+}
+
+// Slots is the state of the A/B slots
+type Slots struct {
+	Booted  string            `json:"booted"`
+	Version string            `json:"version,omitempty"` // Of the booted image, where the system records it
+	States  map[string]string `json:"states,omitempty"`  // Boot status by slot, where the system reports it
+}
+
+// DetectImageSystem returns the named image update system, or the first
+// one installed for "auto". It returns nil for "none" or when auto
+// detection finds none. selection maps the booted root device to the
+// swupdate software set installing into the other slot
+func DetectImageSystem(name string, selection map[string]string) (ImageSystem, error) {
+	systems := map[string]ImageSystem{
+		"rauc":     rauc{},
+		"mender":   mender{},
+		"swupdate": swupdate{selection: selection},
+	}
+	switch name {
+	case "none":
+		return nil, nil
+	case "auto":
+		for _, n := range ImageSystems {
+			if present(n) {
+				return systems[n], nil
+			}
+		}
+		return nil, nil
+	}
+	s, ok := systems[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown image update system %q", name)
+	}
+	if !present(name) {
+		return nil, fmt.Errorf("image update system %s is not installed", name)
+	}
+	return s, nil
+}
+
+// present reports whether an update system is installed and configured
+func present(name string) bool {
+	switch name {
+	case "rauc":
+		return found("rauc") && exists("/etc/rauc/system.conf")
+	case "mender":
+		return (found("mender-update") || found("mender")) && menderDeviceTypeFile() != ""
+	case "swupdate":
+		return found("swupdate") && exists("/etc/hwrevision")
+	}
+	return false
+}
+
+func found(command string) bool {
+	_, err := exec.LookPath(command)
+	return err == nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// run executes a command, returning its output or its error message
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s %s: %w: %s", name, args[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s %s: %w", name, args[0], err)
+	}
+	return out, nil
+}
+
+// rootDevice returns the root= device the kernel booted from
+func rootDevice() (string, error) {
+	data, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		return "", err
+	}
+	for _, field := range strings.Fields(string(data)) {
+		if dev, ok := strings.CutPrefix(field, "root="); ok {
+			return dev, nil
+		}
+	}
+	return "", errors.New("no root= on the kernel command line")
+}
+
+// rauc drives the RAUC service through its command line client
+type rauc struct{}
+
+// raucStatus is the part of `rauc status --output-format=json` used
+type raucStatus struct {
+	Compatible string `json:"compatible"`
+	Booted     string `json:"booted"` // Boot name of the booted slot
+	Slots      []map[string]struct {
+		Class      string `json:"class"`
+		State      string `json:"state"`
+		BootName   string `json:"bootname"`
+		BootStatus string `json:"boot_status"`
+		SlotStatus struct {
+			Bundle struct {
+				Version string `json:"version"`
+			} `json:"bundle"`
+		} `json:"slot_status"`
+	} `json:"slots"`
+}
+
+func (rauc) Name() string { return "rauc" }
+
+func (rauc) status(ctx context.Context) (raucStatus, error) {
+	var st raucStatus
+	out, err := run(ctx, "rauc", "status", "--detailed", "--output-format=json")
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(out, &st); err != nil {
+		return st, fmt.Errorf("invalid rauc status: %w", err)
+	}
+	return st, nil
+}
+
+func (r rauc) DeviceType() (string, error) {
+	st, err := r.status(context.Background())
+	return st.Compatible, err
+}
+
+func (rauc) Install(ctx context.Context, path string) error {
+	_, err := run(ctx, "rauc", "install", path)
+	return err
+}
+
+func (r rauc) Slots(ctx context.Context) (Slots, error) {
+	st, err := r.status(ctx)
+	if err != nil {
+		return Slots{}, err
+	}
+	s := Slots{Booted: st.Booted, States: make(map[string]string)}
+	for _, entry := range st.Slots {
+		for name, slot := range entry {
+			if slot.BootName == "" {
+				continue // Not a bootable slot, e.g. the bootloader
+			}
+			s.States[name] = slot.BootStatus
+			if slot.State == "booted" {
+				s.Booted = name
+				s.Version = slot.SlotStatus.Bundle.Version
+			}
+		}
+	}
+	return s, nil
+}
+
+func (rauc) Confirm(ctx context.Context) error {
+	_, err := run(ctx, "rauc", "status", "mark-good", "booted")
+	return err
+}
+
+// mender drives the Mender client, mender-update from Mender 4 on
+type mender struct{}
+
+func (mender) Name() string { return "mender" }
+
+func (mender) client() string {
+	if found("mender-update") {
+		return "mender-update"
+	}
+	return "mender"
+}
+
+// menderDeviceTypeFile returns the device_type file of the Mender client
+func menderDeviceTypeFile() string {
+	for _, path := range []string{"/data/mender/device_type", "/var/lib/mender/device_type"} {
+		if exists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+func (mender) DeviceType() (string, error) {
+	path := menderDeviceTypeFile()
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "device_type="); ok {
+			return v, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no device_type in %s", path)
+}
+
+func (m mender) Install(ctx context.Context, path string) error {
+	_, err := run(ctx, m.client(), "install", path)
+	return err
+}
+
+func (m mender) Slots(ctx context.Context) (Slots, error) {
+	booted, err := rootDevice()
+	if err != nil {
+		return Slots{}, err
+	}
+	s := Slots{Booted: booted}
+	if out, err := run(ctx, m.client(), "show-artifact"); err == nil {
+		s.Version = strings.TrimSpace(string(out))
+	}
+	return s, nil
+}
+
+func (m mender) Confirm(ctx context.Context) error {
+	_, err := run(ctx, m.client(), "commit")
+	return err
+}
+
+// swupdate installs with the swupdate binary and confirms through the
+// U-Boot environment
+type swupdate struct {
+	selection map[string]string // Software set by booted root device
+}
+
+func (swupdate) Name() string { return "swupdate" }
+
+func (swupdate) DeviceType() (string, error) {
+	data, err := os.ReadFile("/etc/hwrevision")
+	if err != nil {
+		return "", err
+	}
+	board, _, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	if board == "" {
+		return "", errors.New("/etc/hwrevision names no board")
+	}
+	return board, nil
+}
+
+func (s swupdate) Install(ctx context.Context, path string) error {
+	args := []string{"-i", path}
+	if len(s.selection) > 0 {
+		booted, err := rootDevice()
+		if err != nil {
+			return err
+		}
+		set, ok := s.selection[booted]
+		if !ok {
+			return fmt.Errorf("no software set for root device %s", booted)
+		}
+		args = append(args, "-e", set)
+	}
+	_, err := run(ctx, "swupdate", args...)
+	return err
+}
+
+func (swupdate) Slots(context.Context) (Slots, error) {
+	booted, err := rootDevice()
+	return Slots{Booted: booted}, err
+}
+
+// Confirm clears the update state swupdate sets on install and the
+// bootcount flag of U-Boot
+func (swupdate) Confirm(ctx context.Context) error {
+	if _, err := run(ctx, "fw_setenv", "ustate", "0"); err != nil {
+		return err
+	}
+	_, err := run(ctx, "fw_setenv", "upgrade_available", "0")
+	return err
+}
@@ -0,0 +1,281 @@
+// Package update parses rollout directives of the Control Plane, replaces
+// the agent binary and installs system images through A/B update systems.
+// A directive names the version of a release channel and which devices take
+// part: a percentage of the fleet and cohorts by tag
+package update
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Channels lists the release channels, most conservative first
+var Channels = []string{"stable", "beta", "canary"}
+
+// Artifact is the binary of one platform
+type Artifact struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// Directive is a staged rollout of one version on one channel
+type Directive struct {
+	ID      string `json:"id"`
+	Channel string `json:"channel"`
+	Version string `json:"version"`
+	Kind    string `json:"kind,omitempty"` // KindAgent when empty
+	// Artifacts by "os/arch", e.g. "linux/arm64", or by device type for
+	// images
+	Artifacts map[string]Artifact `json:"artifacts"`
+	// Percentage of the devices in the cohorts that update, 0-100
+	Percentage *int `json:"percentage,omitempty"`
+	// Cohorts limit the rollout to devices whose tag has one of the values
+	Cohorts map[string][]string `json:"cohorts,omitempty"`
+	// Halted stops the rollout; devices not yet updated stay as they are
+	Halted bool `json:"halted,omitempty"`
+}
+
+// Parse decodes and checks a directive
+func Parse(data []byte) (*Directive, error) {
+	var d Directive
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("invalid rollout directive: %w", err)
+	}
+	switch {
+	case d.ID == "":
+		return nil, errors.New("rollout directive without id")
+	case !ValidChannel(d.Channel):
+		return nil, fmt.Errorf("rollout %s: unknown channel %q", d.ID, d.Channel)
+	case !validVersion(d.Version):
+		return nil, fmt.Errorf("rollout %s: invalid version %q", d.ID, d.Version)
+	case d.Kind != "" && d.Kind != KindAgent && d.Kind != KindImage:
+		return nil, fmt.Errorf("rollout %s: unknown kind %q", d.ID, d.Kind)
+	case d.Percentage != nil && (*d.Percentage < 0 || *d.Percentage > 100):
+		return nil, fmt.Errorf("rollout %s: percentage must be between 0 and 100", d.ID)
+	}
+	for platform, a := range d.Artifacts {
+		if !strings.HasPrefix(a.URL, "https://") {
+			return nil, fmt.Errorf("rollout %s: artifact %s must be an https URL", d.ID, platform)
+		}
+		if sum, err := hex.DecodeString(a.SHA256); err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("rollout %s: artifact %s needs a SHA-256", d.ID, platform)
+		}
+	}
+	if d.Kind == "" {
+		d.Kind = KindAgent
+	}
+	return &d, nil
+}
+
+// ValidChannel reports whether name is a release channel
+func ValidChannel(name string) bool {
+	return slices.Contains(Channels, name)
+}
+
+// Artifact returns the binary for the running platform
+func (d *Directive) Artifact() (Artifact, bool) {
+	a, ok := d.Artifacts[runtime.GOOS+"/"+runtime.GOARCH]
+	return a, ok
+}
+
+// Image returns the image for a device type
+func (d *Directive) Image(deviceType string) (Artifact, bool) {
+	a, ok := d.Artifacts[deviceType]
+	return a, ok
+}
+
+// Selects reports whether a device takes part in the rollout: its tags
+// match every cohort and it falls within the percentage. A device keeps its
+// bucket for the rollout, so raising the percentage only adds devices
+func (d *Directive) Selects(deviceID string, tags map[string]string) bool {
+	for tag, values := range d.Cohorts {
+		if !slices.Contains(values, tags[tag]) {
+			return false
+		}
+	}
+	if d.Percentage == nil {
+		return true
+	}
+	return Bucket(d.ID, deviceID) < *d.Percentage
+}
+
+// Bucket places a device in 0-99 for a rollout
+func Bucket(rollout, deviceID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(rollout))
+	h.Write([]byte{0})
+	h.Write([]byte(deviceID))
+	return int(h.Sum32() % 100)
+}
+
+// Compare orders versions such as 1.4.0 and 1.5.0-beta.2: numeric parts
+// first, then a release above its pre-releases. It returns -1, 0 or 1
+func Compare(a, b string) int {
+	a, b = strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v")
+	aCore, aPre, _ := strings.Cut(a, "-")
+	bCore, bPre, _ := strings.Cut(b, "-")
+	if c := compareDotted(aCore, bCore); c != 0 {
+		return c
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return compareDotted(aPre, bPre)
+}
+
+// compareDotted compares dot-separated parts, numerically where both are
+// numbers
+func compareDotted(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		if i >= len(as) {
+			return -1
+		}
+		if i >= len(bs) {
+			return 1
+		}
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				return cmp.Compare(an, bn)
+			}
+		case as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	return 0
+}
+
+// validVersion accepts dotted numeric versions with an optional
+// pre-release suffix
+func validVersion(v string) bool {
+	core, _, _ := strings.Cut(strings.TrimPrefix(v, "v"), "-")
+	if core == "" {
+		return false
+	}
+	for _, part := range strings.Split(core, ".") {
+		if _, err := strconv.Atoi(part); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Download fetches an artifact into dir and verifies its SHA-256, returning
+// the path of the file. Artifacts above maxBytes are refused
+func Download(ctx context.Context, client *http.Client, a Artifact, dir string, maxBytes int64) (string, error) {
+	if a.Size > maxBytes {
+		return "", fmt.Errorf("artifact is %d bytes, more than the limit of %d", a.Size, maxBytes)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "agent-*.download")
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(resp.Body, maxBytes+1))
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	switch {
+	case err != nil:
+	case n > maxBytes:
+		err = fmt.Errorf("artifact is larger than the limit of %d bytes", maxBytes)
+	case !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), a.SHA256):
+		err = errors.New("artifact SHA-256 does not match the rollout")
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Install replaces the binary at exe with the downloaded file, keeping the
+// previous one as exe.previous for a manual rollback. The running process
+// keeps its image until it restarts
+func Install(exe, downloaded string) error {
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	// Same directory, so the final rename does not cross file systems
+	staged := filepath.Join(filepath.Dir(exe), "."+filepath.Base(exe)+".new")
+	if err := copyFile(downloaded, staged, info.Mode().Perm()|0o500); err != nil {
+		return err
+	}
+	previous := exe + ".previous"
+	if err := os.Rename(exe, previous); err != nil {
+		os.Remove(staged)
+		return err
+	}
+	if err := os.Rename(staged, exe); err != nil {
+		// Put the running version back
+		os.Rename(previous, exe)
+		os.Remove(staged)
+		return err
+	}
+	os.Remove(downloaded)
+	return nil
+}
+
+// copyFile copies src to dst with the given permissions and syncs it
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,64 @@
+// Package uplink finds the network interface carrying the device's traffic,
+// the one holding the preferred default route, and what kind of link it is,
+// so failovers between ethernet, Wi-Fi and cellular can be told apart
+package uplink
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of uplink
+const (
+	Ethernet = "ethernet"
+	WiFi     = "wifi"
+	Cellular = "cellular"
+	Other    = "other"
+)
+
+// ErrUnsupported is returned where routes cannot be read
+var ErrUnsupported = errors.New("uplink detection is not supported on this platform")
+
+// ErrNoRoute is returned when no interface holds a default route
+var ErrNoRoute = errors.New("no default route")
+
+// Path is the uplink carrying traffic
+type Path struct {
+	Interface string
+	Kind      string
+	Gateway   string // Next hop, empty for point-to-point links
+}
+
+// Detector finds the active uplink
+type Detector struct {
+	kinds map[string]string // Kind by interface name, before detection
+}
+
+// New creates a detector. kinds names the kind of interfaces that are not
+// recognized, such as a USB modem showing up as ethernet
+func New(kinds map[string]string) *Detector {
+	return &Detector{kinds: kinds}
+}
+
+// ValidKind checks an uplink kind
+func ValidKind(kind string) error {
+	switch kind {
+	case Ethernet, WiFi, Cellular, Other:
+		return nil
+	}
+	return fmt.Errorf("unknown uplink kind %q, use ethernet, wifi, cellular or other", kind)
+}
+
+// Active returns the uplink holding the default route with the lowest
+// metric, IPv4 before IPv6
+func (d *Detector) Active() (Path, error) {
+	iface, gateway, err := defaultRoute()
+	if err != nil {
+		return Path{}, err
+	}
+	kind, ok := d.kinds[iface]
+	if !ok {
+		kind = classify(iface)
+	}
+	return Path{Interface: iface, Kind: kind, Gateway: gateway}, nil
+}
@@ -0,0 +1,154 @@
+//go:build linux
+
+package uplink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Route flags from linux/route.h
+const (
+	rtfUp     = 0x0001
+	rtfReject = 0x0200
+)
+
+// defaultRoute returns the interface and next hop of the IPv4 default
+// route with the lowest metric, or else the IPv6 one
+func defaultRoute() (string, string, error) {
+	iface, gateway, err := defaultRoute4()
+	if err == ErrNoRoute {
+		iface, gateway, err = defaultRoute6()
+	}
+	return iface, gateway, err
+}
+
+// defaultRoute4 reads /proc/net/route. Addresses there are hex in host
+// byte order
+func defaultRoute4() (string, string, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	var iface, gateway string
+	best := -1
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" || fields[0] == "lo" {
+			continue
+		}
+		flags, _ := strconv.ParseUint(fields[3], 16, 32)
+		metric, err := strconv.Atoi(fields[6])
+		if err != nil || flags&rtfUp == 0 || flags&rtfReject != 0 || (best >= 0 && metric >= best) {
+			continue
+		}
+		best, iface, gateway = metric, fields[0], ""
+		if raw, err := hex.DecodeString(fields[2]); err == nil && len(raw) == 4 {
+			ip := make(net.IP, 4)
+			binary.BigEndian.PutUint32(ip, binary.NativeEndian.Uint32(raw))
+			if !ip.IsUnspecified() {
+				gateway = ip.String()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	if best < 0 {
+		return "", "", ErrNoRoute
+	}
+	return iface, gateway, nil
+}
+
+// defaultRoute6 reads /proc/net/ipv6_route
+func defaultRoute6() (string, string, error) {
+	f, err := os.Open("/proc/net/ipv6_route")
+	if os.IsNotExist(err) {
+		return "", "", ErrNoRoute
+	}
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	var iface, gateway string
+	var best uint64
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Destination PrefixLen Source SrcPrefixLen NextHop Metric RefCnt Use Flags Iface
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[1] != "00" || strings.Trim(fields[0], "0") != "" || fields[9] == "lo" {
+			continue
+		}
+		flags, _ := strconv.ParseUint(fields[8], 16, 32)
+		metric, err := strconv.ParseUint(fields[5], 16, 32)
+		if err != nil || flags&rtfUp == 0 || flags&rtfReject != 0 || (found && metric >= best) {
+			continue
+		}
+		found, best, iface, gateway = true, metric, fields[9], ""
+		if raw, err := hex.DecodeString(fields[4]); err == nil && len(raw) == net.IPv6len {
+			if ip := net.IP(raw); !ip.IsUnspecified() {
+				gateway = ip.String()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	if !found {
+		return "", "", ErrNoRoute
+	}
+	return iface, gateway, nil
+}
+
+// classify tells the kind of an interface from sysfs, and from its name
+// where sysfs says nothing
+func classify(iface string) string {
+	dir := filepath.Join("/sys/class/net", iface)
+	if exists(filepath.Join(dir, "wireless")) || exists(filepath.Join(dir, "phy80211")) {
+		return WiFi
+	}
+	if uevent, err := os.ReadFile(filepath.Join(dir, "uevent")); err == nil {
+		for _, line := range strings.Split(string(uevent), "\n") {
+			switch strings.TrimSpace(line) {
+			case "DEVTYPE=wwan":
+				return Cellular
+			case "DEVTYPE=wlan":
+				return WiFi
+			}
+		}
+	}
+
+	for _, p := range []struct{ prefix, kind string }{
+		{"wwan", Cellular}, {"wwp", Cellular}, {"rmnet", Cellular}, {"ppp", Cellular}, {"usb", Cellular},
+		{"wlan", WiFi}, {"wl", WiFi},
+		{"eth", Ethernet}, {"en", Ethernet},
+	} {
+		if strings.HasPrefix(iface, p.prefix) {
+			return p.kind
+		}
+	}
+
+	// Physical interfaces with an ethernet link layer (ARPHRD_ETHER)
+	if t, err := os.ReadFile(filepath.Join(dir, "type")); err == nil && strings.TrimSpace(string(t)) == "1" && exists(filepath.Join(dir, "device")) {
+		return Ethernet
+	}
+	return Other
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package uplink
+
+// defaultRoute reports detection as unsupported
+func defaultRoute() (string, string, error) {
+	return "", "", ErrUnsupported
+}
+
+// classify cannot tell interfaces apart here
+func classify(string) string {
+	return Other
+}
@@ -0,0 +1,148 @@
+// Package upload describes bulk uploads requested by the Control Plane.
+// Large artifacts travel to S3-compatible storage over HTTP(S) instead of
+// MQTT; the request only carries where to put them
+package upload
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Request asks the collector to upload an artifact, either to a presigned
+// URL or below the configured bucket's prefix
+type Request struct {
+	ID       string `json:"id"`
+	Artifact string `json:"artifact"`       // "file", "diagnostics" or "screenshot"
+	Path     string `json:"path,omitempty"` // File to upload for "file"
+	URL      string `json:"url,omitempty"`  // Presigned PUT URL
+	// Headers are sent with the PUT, e.g. those covered by the presigned
+	// signature
+	Headers map[string]string `json:"headers,omitempty"`
+	Key     string            `json:"key,omitempty"`     // Object key when no URL is given
+	Expires int64             `json:"expires,omitempty"` // Unix time after which the request is skipped
+}
+
+// Parse decodes and validates a request
+func Parse(payload []byte) (Request, error) {
+	var r Request
+	if err := json.Unmarshal(payload, &r); err != nil {
+		return Request{}, fmt.Errorf("invalid upload request: %w", err)
+	}
+	if err := r.Validate(); err != nil {
+		return Request{}, err
+	}
+	return r, nil
+}
+
+// Validate checks that the request names an artifact and a destination
+func (r Request) Validate() error {
+	if r.ID == "" {
+		return fmt.Errorf("upload id is required")
+	}
+	switch r.Artifact {
+	case "file":
+		if !filepath.IsAbs(r.Path) {
+			return fmt.Errorf("upload %s: path must be absolute", r.ID)
+		}
+	case "diagnostics", "screenshot":
+	default:
+		return fmt.Errorf("upload %s: artifact must be file, diagnostics or screenshot", r.ID)
+	}
+
+	switch {
+	case r.URL != "":
+		u, err := url.Parse(r.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("upload %s: url must be an http or https URL", r.ID)
+		}
+	case r.Key == "":
+		return fmt.Errorf("upload %s: url or key is required", r.ID)
+	}
+	return nil
+}
+
+// Expired reports whether the request should no longer be run
+func (r Request) Expired(now time.Time) bool {
+	return r.Expires > 0 && now.Unix() > r.Expires
+}
+
+// Destination names where the artifact goes without the presigned query,
+// which holds the signature
+func (r Request) Destination() string {
+	if r.URL == "" {
+		return r.Key
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Host + u.Path
+}
+
+// Entry is one file of a bundle, taken from Data or, when Path is set, read
+// from disk
+type Entry struct {
+	Name string
+	Data []byte
+	Path string
+}
+
+// WriteBundle writes entries as a gzipped tar archive. Entries whose file
+// does not exist are left out
+func WriteBundle(w io.Writer, entries []Entry) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	now := time.Now()
+
+	for _, e := range entries {
+		if e.Path == "" {
+			hdr := &tar.Header{Name: e.Name, Mode: 0640, Size: int64(len(e.Data)), ModTime: now}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := tw.Write(e.Data); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := addFile(tw, e.Name, e.Path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// addFile copies a file into the archive. The size is taken from the open
+// file, so a log growing meanwhile is cut at that size
+func addFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{Name: name, Mode: 0640, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, info.Size())
+	return err
+}
@@ -0,0 +1,47 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxBodyBytes bounds how much of a response body is read to measure it
+const maxBodyBytes = 1 << 20
+
+// runHTTP requests the target and reports the status and body size
+func (r *Runner) runHTTP(ctx context.Context, job Job) (map[string]interface{}, error) {
+	method := job.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, job.Target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "signalbeam-collector/0.1.0")
+
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodyBytes))
+	result := map[string]interface{}{
+		"status_code": resp.StatusCode,
+		"bytes":       n,
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	switch {
+	case job.ExpectStatus != 0 && resp.StatusCode != job.ExpectStatus:
+		return result, fmt.Errorf("unexpected status %d, expected %d", resp.StatusCode, job.ExpectStatus)
+	case job.ExpectStatus == 0 && resp.StatusCode >= 400:
+		return result, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return result, nil
+}
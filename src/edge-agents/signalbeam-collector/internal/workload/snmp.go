@@ -0,0 +1,78 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// runSNMP reads the job's OIDs from the target
+func (r *Runner) runSNMP(ctx context.Context, job Job, timeout time.Duration) (map[string]interface{}, error) {
+	host, port, err := snmpTarget(job.Target)
+	if err != nil {
+		return nil, err
+	}
+	if r.check != nil {
+		if err := r.check(host, port); err != nil {
+			return nil, err
+		}
+	}
+
+	version := gosnmp.Version2c
+	if job.Version == "1" {
+		version = gosnmp.Version1
+	}
+	community := job.Community
+	if community == "" {
+		community = "public"
+	}
+
+	client := &gosnmp.GoSNMP{
+		Target:    host,
+		Port:      uint16(port),
+		Community: community,
+		Version:   version,
+		Timeout:   timeout,
+		Retries:   1,
+		Context:   ctx,
+	}
+	if err := client.Connect(); err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Conn.Close()
+
+	packet, err := client.Get(job.OIDs)
+	if err != nil {
+		return nil, err
+	}
+	if packet.Error != gosnmp.NoError {
+		return nil, fmt.Errorf("agent returned %s", packet.Error)
+	}
+
+	values := make(map[string]interface{}, len(packet.Variables))
+	for _, v := range packet.Variables {
+		values[v.Name] = snmpValue(v)
+	}
+	return map[string]interface{}{"values": values}, nil
+}
+
+// snmpValue converts a variable to a JSON-friendly value. Missing objects
+// are reported as null
+func snmpValue(v gosnmp.SnmpPDU) interface{} {
+	switch v.Type {
+	case gosnmp.OctetString:
+		if b, ok := v.Value.([]byte); ok {
+			return string(b)
+		}
+	case gosnmp.ObjectIdentifier, gosnmp.IPAddress:
+		return v.Value
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks,
+		gosnmp.Counter64, gosnmp.Uinteger32:
+		f, _ := new(big.Float).SetInt(gosnmp.ToBigInt(v.Value)).Float64()
+		return f
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/signalbeam-io/signalbeam-platform/edge-agents/signalbeam-collector/internal/egress"
+)
+
+// Job is a polling task assigned by the cloud. Jobs reach one member of a
+// collector group through a shared subscription
+type Job struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`   // "http" or "snmp"
+	Target    string `json:"target"` // URL for http, host[:port] for snmp
+	TimeoutMS int    `json:"timeout_ms,omitempty"`
+	Expires   int64  `json:"expires,omitempty"` // Unix time after which the job is skipped
+
+	// HTTP
+	Method       string `json:"method,omitempty"`        // GET (default) or HEAD
+	ExpectStatus int    `json:"expect_status,omitempty"` // Any 2xx or 3xx when unset
+
+	// SNMP
+	Version   string   `json:"version,omitempty"` // "1" or "2c" (default)
+	Community string   `json:"community,omitempty"`
+	OIDs      []string `json:"oids,omitempty"`
+}
+
+// Parse decodes and validates a job
+func Parse(payload []byte) (Job, error) {
+	var job Job
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return Job{}, fmt.Errorf("invalid job: %w", err)
+	}
+	if err := job.Validate(); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// Validate checks that the job can be run
+func (j Job) Validate() error {
+	if j.ID == "" {
+		return fmt.Errorf("job id is required")
+	}
+	if j.Target == "" {
+		return fmt.Errorf("job %s: target is required", j.ID)
+	}
+	if j.TimeoutMS < 0 {
+		return fmt.Errorf("job %s: timeout_ms must not be negative", j.ID)
+	}
+
+	switch j.Kind {
+	case "http":
+		u, err := url.Parse(j.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("job %s: target must be an http or https URL", j.ID)
+		}
+		if j.Method != "" && j.Method != http.MethodGet && j.Method != http.MethodHead {
+			return fmt.Errorf("job %s: method must be GET or HEAD", j.ID)
+		}
+	case "snmp":
+		if j.Version != "" && j.Version != "1" && j.Version != "2c" {
+			return fmt.Errorf("job %s: version must be 1 or 2c", j.ID)
+		}
+		if len(j.OIDs) == 0 || len(j.OIDs) > gosnmp.MaxOids {
+			return fmt.Errorf("job %s: between 1 and %d oids are required", j.ID, gosnmp.MaxOids)
+		}
+	default:
+		return fmt.Errorf("job %s: kind must be http or snmp", j.ID)
+	}
+	return nil
+}
+
+// Expired reports whether the job should no longer run
+func (j Job) Expired(now time.Time) bool {
+	return j.Expires > 0 && now.Unix() > j.Expires
+}
+
+// CheckFunc reports whether host:port may be contacted by clients that
+// manage their own sockets
+type CheckFunc func(host string, port int) error
+
+// Runner executes jobs
+type Runner struct {
+	timeout time.Duration
+	check   CheckFunc
+	http    *http.Client
+}
+
+// NewRunner creates a runner. timeout applies to jobs that do not set their own
+func NewRunner(timeout time.Duration, dial egress.DialFunc, check CheckFunc) *Runner {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dial
+	transport.Proxy = nil
+
+	return &Runner{
+		timeout: timeout,
+		check:   check,
+		http:    &http.Client{Transport: transport},
+	}
+}
+
+// Run executes a job and returns its result. A failed poll returns the
+// partial result alongside the error
+func (r *Runner) Run(ctx context.Context, job Job) (map[string]interface{}, error) {
+	timeout := r.timeout
+	if job.TimeoutMS > 0 {
+		timeout = time.Duration(job.TimeoutMS) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch job.Kind {
+	case "http":
+		return r.runHTTP(ctx, job)
+	case "snmp":
+		return r.runSNMP(ctx, job, timeout)
+	}
+	return nil, fmt.Errorf("unsupported job kind %q", job.Kind)
+}
+
+// snmpTarget splits an SNMP target into host and port, defaulting to 161
+func snmpTarget(target string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return target, 161, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in target %q", target)
+	}
+	return host, port, nil
+}